@@ -24,6 +24,7 @@ func setupRoutes(router *gin.Engine, db *database.Database, cfg *Config) {
 	quantumService := service.NewQuantumService(cfg.QuantumURL, db)
 	nginxService := service.NewNginxService(cfg.NginxURL, cfg.NginxConfigPath)
 	windowsLogService := service.NewWindowsLogService(db)
+	alertService := service.NewAlertService(db)
 	
 	// ============================================
 	// 組合服務
@@ -75,6 +76,7 @@ func setupRoutes(router *gin.Engine, db *database.Database, cfg *Config) {
 	quantumHandler := handler.NewQuantumHandler(quantumService)
 	nginxHandler := handler.NewNginxHandler(nginxService)
 	windowsLogHandler := handler.NewWindowsLogHandler(windowsLogService)
+	alertHandler := handler.NewAlertHandler(alertService)
 	combinedHandler := handler.NewCombinedHandler(combinedService)
 	timeTravelHandler := handler.NewTimeTravelHandler(timeTravelService)
 	adaptiveSecurityHandler := handler.NewAdaptiveSecurityHandler(adaptiveSecurityService)
@@ -206,6 +208,9 @@ func setupRoutes(router *gin.Engine, db *database.Database, cfg *Config) {
 			}
 		}
 		
+		// 告警路由
+		v2.POST("/alerts", alertHandler.CreateAlert)
+		
 		// ========== Combined APIs ==========
 		
 		combined := v2.Group("/combined")
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"axiom-backend/internal/model"
+	"axiom-backend/internal/outbox"
 	
 	"gorm.io/gorm"
 )
@@ -36,7 +37,12 @@ func (s *GDPRService) CreateDeletionRequest(ctx context.Context, subjectID, requ
 		Notes:             notes,
 	}
 	
-	err := s.db.WithContext(ctx).Create(request).Error
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(request).Error; err != nil {
+			return err
+		}
+		return outbox.Enqueue(tx, outbox.GDPRDeletionChanged(outbox.EventTypeGDPRDeletionRequested, request))
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create deletion request: %w", err)
 	}
@@ -48,24 +54,30 @@ func (s *GDPRService) CreateDeletionRequest(ctx context.Context, subjectID, requ
 func (s *GDPRService) ApproveDeletionRequest(ctx context.Context, requestID, approvedBy string) error {
 	now := time.Now()
 	
-	result := s.db.WithContext(ctx).
-		Model(&model.GDPRDeletionRequest{}).
-		Where("request_id = ? AND status = ?", requestID, "pending").
-		Updates(map[string]interface{}{
-			"status":      "approved",
-			"approved_by": approvedBy,
-			"approved_at": now,
-		})
-	
-	if result.Error != nil {
-		return fmt.Errorf("failed to approve request: %w", result.Error)
-	}
-	
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("request not found or already processed")
-	}
-	
-	return nil
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.
+			Model(&model.GDPRDeletionRequest{}).
+			Where("request_id = ? AND status = ?", requestID, "pending").
+			Updates(map[string]interface{}{
+				"status":      "approved",
+				"approved_by": approvedBy,
+				"approved_at": now,
+			})
+		
+		if result.Error != nil {
+			return fmt.Errorf("failed to approve request: %w", result.Error)
+		}
+		
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("request not found or already processed")
+		}
+		
+		request := &model.GDPRDeletionRequest{RequestID: requestID, Status: "approved"}
+		if err := outbox.Enqueue(tx, outbox.GDPRDeletionChanged(outbox.EventTypeGDPRDeletionApproved, request)); err != nil {
+			return fmt.Errorf("failed to record approval event: %w", err)
+		}
+		return nil
+	})
 }
 
 // ExecuteDeletion 執行刪除
@@ -101,16 +113,26 @@ func (s *GDPRService) ExecuteDeletion(ctx context.Context, requestID string) (*D
 	// 3. 生成驗證 Hash
 	verificationHash := s.generateVerificationHash(requestID, deletedCount)
 	
-	// 4. 更新請求狀態
+	// 4. 更新請求狀態，並在同一交易中記錄完成事件
 	now := time.Now()
-	s.db.WithContext(ctx).
-		Model(&request).
-		Updates(map[string]interface{}{
-			"status":            "completed",
-			"completion_date":   now,
-			"deleted_count":     deletedCount,
-			"verification_hash": verificationHash,
-		})
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&request).
+			Updates(map[string]interface{}{
+				"status":            "completed",
+				"completion_date":   now,
+				"deleted_count":     deletedCount,
+				"verification_hash": verificationHash,
+			}).Error
+		if err != nil {
+			return err
+		}
+		request.Status = "completed"
+		request.DeletedCount = deletedCount
+		return outbox.Enqueue(tx, outbox.GDPRDeletionChanged(outbox.EventTypeGDPRDeletionCompleted, &request))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete deletion request: %w", err)
+	}
 	
 	return &DeletionResult{
 		RequestID:        requestID,
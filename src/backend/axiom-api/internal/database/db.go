@@ -99,6 +99,7 @@ func (d *Database) AutoMigrate() error {
 		&model.MetricSnapshot{},
 		&model.User{},
 		&model.Session{},
+		&model.OutboxEvent{},
 	)
 	if err != nil {
 		return err
@@ -0,0 +1,15 @@
+package dto
+
+// CreateAlertRequest 建立告警請求
+type CreateAlertRequest struct {
+	AlertName   string            `json:"alert_name" binding:"required"`
+	Severity    string            `json:"severity" binding:"required,oneof=critical high medium low info"`
+	Source      string            `json:"source" binding:"required"` // prometheus, loki, manual, quantum, etc.
+	Category    string            `json:"category"`                  // network, security, system, quantum, etc.
+	Message     string            `json:"message" binding:"required"`
+	Description string            `json:"description"`
+	Fingerprint string            `json:"fingerprint" binding:"omitempty,max=64"` // 去重用，空白時由名稱、來源與標籤計算
+	Priority    int               `json:"priority"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"axiom-backend/internal/dto"
+	apperrors "axiom-backend/internal/errors"
+	"axiom-backend/internal/service"
+)
+
+// AlertHandler 告警處理器
+type AlertHandler struct {
+	alertService *service.AlertService
+}
+
+// NewAlertHandler 創建告警處理器
+func NewAlertHandler(alertService *service.AlertService) *AlertHandler {
+	return &AlertHandler{
+		alertService: alertService,
+	}
+}
+
+// CreateAlert 建立告警
+// @Summary 建立告警
+// @Tags Alerts
+// @Accept json
+// @Produce json
+// @Param request body dto.CreateAlertRequest true "告警"
+// @Success 200 {object} model.Alert
+// @Router /api/v2/alerts [post]
+func (h *AlertHandler) CreateAlert(c *gin.Context) {
+	var req dto.CreateAlertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handleError(c, apperrors.NewWithDetails(
+			apperrors.ErrCodeValidation,
+			"Invalid request",
+			http.StatusBadRequest,
+			err.Error(),
+		))
+		return
+	}
+
+	alert, err := h.alertService.CreateAlert(c.Request.Context(), &req)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    alert,
+	})
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+)
+
+// OutboxEvent 事件發件匣表：與業務資料在同一個交易中寫入，
+// 由 pubsub.OutboxRelay 依 ID 順序發布到消息隊列 (欄位需與 pubsub.OutboxRecord 一致)
+type OutboxEvent struct {
+	ID          uint64     `gorm:"primaryKey;autoIncrement"`
+	EventID     string     `gorm:"size:128;uniqueIndex;not null"` // BaseEvent.ID，消費端以此去重
+	Exchange    string     `gorm:"size:255;not null"`
+	RoutingKey  string     `gorm:"size:255;not null"`
+	Payload     string     `gorm:"type:text;not null"` // 事件 JSON
+	Attempts    int        `gorm:"not null;default:0"` // 發布失敗次數
+	LastError   string     `gorm:"type:text"`
+	CreatedAt   time.Time  `gorm:"not null;index"`
+	PublishedAt *time.Time `gorm:"index"` // 尚未發布時為 NULL
+}
+
+// TableName 指定表名
+func (OutboxEvent) TableName() string {
+	return "event_outbox"
+}
+
+// IsPublished 檢查事件是否已發布
+func (e *OutboxEvent) IsPublished() bool {
+	return e.PublishedAt != nil
+}
@@ -0,0 +1,132 @@
+// Package outbox 事件發件匣：業務資料與事件在同一個 GORM 交易中寫入，
+// 由 pubsub.OutboxRelay 轉發到消息隊列，避免寫入成功但事件遺失
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"axiom-backend/internal/model"
+)
+
+// DefaultExchange 預設發布的交換機
+const DefaultExchange = "pandora.events"
+
+// SchemaVersion 事件結構版本，需與 pubsub.CurrentSchemaVersion 一致
+const SchemaVersion = 2
+
+// 事件類型，同時作為路由鍵 (需符合 configs/rabbitmq/definitions.json 的綁定)；
+// 皆為 system 事件，結構與 pubsub.SystemEvent 相同
+const (
+	EventTypeAlertCreated          = "system.alert_created"
+	EventTypeQuantumJobSubmitted   = "system.quantum_job_submitted"
+	EventTypeQuantumJobCompleted   = "system.quantum_job_completed"
+	EventTypeQuantumJobFailed      = "system.quantum_job_failed"
+	EventTypeGDPRDeletionRequested = "system.gdpr_deletion_requested"
+	EventTypeGDPRDeletionApproved  = "system.gdpr_deletion_approved"
+	EventTypeGDPRDeletionCompleted = "system.gdpr_deletion_completed"
+)
+
+// Event 與 pubsub.SystemEvent 相容的事件結構
+type Event struct {
+	ID            string                 `json:"id"`
+	Type          string                 `json:"type"`
+	SchemaVersion int                    `json:"schema_version"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Tags          []string               `json:"tags,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+	Component     string                 `json:"component"`
+	Status        string                 `json:"status"`
+	Message       string                 `json:"message"`
+}
+
+// NewEvent 創建事件
+func NewEvent(eventType, severity, status, message string, metadata map[string]interface{}) *Event {
+	return &Event{
+		ID:            "evt_" + uuid.New().String(),
+		Type:          eventType,
+		SchemaVersion: SchemaVersion,
+		Timestamp:     time.Now().UTC(),
+		Source:        "axiom-api",
+		Severity:      severity,
+		Metadata:      metadata,
+		Component:     "axiom-api",
+		Status:        status,
+		Message:       message,
+	}
+}
+
+// Enqueue 在交易 tx 中寫入事件，路由鍵為事件類型；
+// tx 必須是寫入業務資料的同一個交易，事件才會與資料一起提交或回滾
+func Enqueue(tx *gorm.DB, event *Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox event: %w", err)
+	}
+
+	record := &model.OutboxEvent{
+		EventID:    event.ID,
+		Exchange:   DefaultExchange,
+		RoutingKey: event.Type,
+		Payload:    string(payload),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return fmt.Errorf("failed to write outbox event: %w", err)
+	}
+	return nil
+}
+
+// AlertCreated 告警建立事件
+func AlertCreated(alert *model.Alert) *Event {
+	event := NewEvent(EventTypeAlertCreated, alert.Severity, alert.Status, alert.Message, map[string]interface{}{
+		"alert_id":    alert.ID,
+		"alert_name":  alert.AlertName,
+		"fingerprint": alert.Fingerprint,
+		"source":      alert.Source,
+		"category":    alert.Category,
+		"message":     alert.Message,
+		"status":      alert.Status,
+	})
+	event.Tags = []string{"alert", alert.Category}
+	return event
+}
+
+// QuantumJobChanged 量子作業狀態事件，eventType 為 EventTypeQuantumJob* 之一
+func QuantumJobChanged(eventType string, job *model.QuantumJob) *Event {
+	severity := "info"
+	if eventType == EventTypeQuantumJobFailed {
+		severity = "medium"
+	}
+	metadata := map[string]interface{}{
+		"job_id":  job.JobID,
+		"type":    job.Type,
+		"backend": job.Backend,
+		"status":  job.Status,
+	}
+	if job.Error != "" {
+		metadata["error"] = job.Error
+	}
+	event := NewEvent(eventType, severity, job.Status, fmt.Sprintf("quantum job %s %s", job.JobID, job.Status), metadata)
+	event.Tags = []string{"quantum", job.Type}
+	return event
+}
+
+// GDPRDeletionChanged GDPR 刪除請求事件，eventType 為 EventTypeGDPRDeletion* 之一；
+// 事件不包含主體識別資料，只帶請求 ID
+func GDPRDeletionChanged(eventType string, request *model.GDPRDeletionRequest) *Event {
+	message := fmt.Sprintf("GDPR deletion request %s %s", request.RequestID, request.Status)
+	event := NewEvent(eventType, "info", request.Status, message, map[string]interface{}{
+		"request_id":    request.RequestID,
+		"status":        request.Status,
+		"deleted_count": request.DeletedCount,
+	})
+	event.Tags = []string{"compliance", "gdpr"}
+	return event
+}
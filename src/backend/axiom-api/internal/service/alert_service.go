@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+
+	"axiom-backend/internal/database"
+	"axiom-backend/internal/dto"
+	apperrors "axiom-backend/internal/errors"
+	"axiom-backend/internal/model"
+	"axiom-backend/internal/outbox"
+)
+
+// AlertService 告警服務
+type AlertService struct {
+	db *database.Database
+}
+
+// NewAlertService 創建告警服務
+func NewAlertService(db *database.Database) *AlertService {
+	return &AlertService{db: db}
+}
+
+// CreateAlert 寫入告警；相同指紋的活躍告警只累加次數，新告警在同一交易中記錄建立事件
+func (s *AlertService) CreateAlert(ctx context.Context, req *dto.CreateAlertRequest) (*model.Alert, error) {
+	fingerprint := req.Fingerprint
+	if fingerprint == "" {
+		fingerprint = alertFingerprint(req)
+	}
+
+	labels, err := json.Marshal(req.Labels)
+	if err != nil {
+		return nil, apperrors.Wrap(err, "marshal alert labels failed")
+	}
+	annotations, err := json.Marshal(req.Annotations)
+	if err != nil {
+		return nil, apperrors.Wrap(err, "marshal alert annotations failed")
+	}
+
+	now := time.Now()
+	alert := &model.Alert{}
+	err = s.db.PG.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("fingerprint = ?", fingerprint).First(alert).Error
+		switch {
+		case err == nil && alert.IsActive():
+			return tx.Model(alert).Updates(map[string]interface{}{
+				"count":            gorm.Expr("count + 1"),
+				"last_occurred_at": now,
+				"updated_at":       now,
+			}).Error
+		case err == nil:
+			// 已解決或已抑制的告警再次發生，重新開啟並視為新告警
+			alert.Status = "active"
+			alert.Count = 1
+			alert.Message = req.Message
+			alert.Description = req.Description
+			alert.ResolvedAt = nil
+			alert.ResolvedBy = ""
+			alert.LastOccurredAt = now
+			if err := tx.Save(alert).Error; err != nil {
+				return err
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			*alert = model.Alert{
+				AlertName:      req.AlertName,
+				Fingerprint:    fingerprint,
+				Severity:       req.Severity,
+				Source:         req.Source,
+				Category:       req.Category,
+				Message:        req.Message,
+				Description:    req.Description,
+				Status:         "active",
+				Priority:       req.Priority,
+				Count:          1,
+				Labels:         datatypes.JSON(labels),
+				Annotations:    datatypes.JSON(annotations),
+				CreatedAt:      now,
+				LastOccurredAt: now,
+			}
+			if err := tx.Create(alert).Error; err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+		return outbox.Enqueue(tx, outbox.AlertCreated(alert))
+	})
+	if err != nil {
+		return nil, apperrors.Wrap(err, "create alert failed")
+	}
+	return alert, nil
+}
+
+// alertFingerprint 以名稱、來源與排序後的標籤計算告警指紋
+func alertFingerprint(req *dto.CreateAlertRequest) string {
+	keys := make([]string, 0, len(req.Labels))
+	for key := range req.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	hash.Write([]byte(req.AlertName + "\x00" + req.Source))
+	for _, key := range keys {
+		hash.Write([]byte("\x00" + key + "=" + req.Labels[key]))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
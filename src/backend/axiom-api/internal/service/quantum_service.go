@@ -9,11 +9,13 @@ import (
 	"axiom-backend/internal/database"
 	"axiom-backend/internal/dto"
 	"axiom-backend/internal/model"
+	"axiom-backend/internal/outbox"
 	"axiom-backend/internal/vo"
 	apperrors "axiom-backend/internal/errors"
 	
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 // QuantumService 量子服務
@@ -62,6 +64,26 @@ func (s *QuantumService) GetStatus(ctx context.Context) (map[string]interface{},
 	return status, nil
 }
 
+// createJob 建立作業記錄並在同一交易中記錄提交事件
+func (s *QuantumService) createJob(job *model.QuantumJob) error {
+	return s.db.PG.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(job).Error; err != nil {
+			return err
+		}
+		return outbox.Enqueue(tx, outbox.QuantumJobChanged(outbox.EventTypeQuantumJobSubmitted, job))
+	})
+}
+
+// updateJob 更新作業狀態並在同一交易中記錄狀態事件
+func (s *QuantumService) updateJob(job *model.QuantumJob, eventType string, updates map[string]interface{}) error {
+	return s.db.PG.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(job).Updates(updates).Error; err != nil {
+			return err
+		}
+		return outbox.Enqueue(tx, outbox.QuantumJobChanged(eventType, job))
+	})
+}
+
 // GenerateQKD 生成量子密鑰
 func (s *QuantumService) GenerateQKD(ctx context.Context, req *dto.QuantumQKDRequest) (*vo.QuantumQKDVO, error) {
 	// 創建作業記錄
@@ -76,7 +98,7 @@ func (s *QuantumService) GenerateQKD(ctx context.Context, req *dto.QuantumQKDReq
 		InputData:   datatypes.JSON(fmt.Sprintf(`{"key_length": %d}`, req.KeyLength)),
 	}
 	
-	if err := s.db.PG.Create(job).Error; err != nil {
+	if err := s.createJob(job); err != nil {
 		return nil, apperrors.Wrap(err, "create quantum job failed")
 	}
 
@@ -92,20 +114,27 @@ func (s *QuantumService) GenerateQKD(ctx context.Context, req *dto.QuantumQKDReq
 	err := s.httpClient.PostJSON(ctx, "/api/v1/quantum/qkd/generate", requestBody, &result)
 	if err != nil {
 		// 更新作業狀態為失敗
-		s.db.PG.Model(job).Updates(map[string]interface{}{
+		job.Status = "failed"
+		job.Error = err.Error()
+		if updateErr := s.updateJob(job, outbox.EventTypeQuantumJobFailed, map[string]interface{}{
 			"status": "failed",
 			"error":  err.Error(),
-		})
+		}); updateErr != nil {
+			return nil, apperrors.Wrap(err, fmt.Sprintf("quantum qkd generation failed (update quantum job failed: %v)", updateErr))
+		}
 		return nil, apperrors.Wrap(err, "quantum qkd generation failed")
 	}
 
 	// 更新作業狀態為完成
 	now := time.Now()
-	s.db.PG.Model(job).Updates(map[string]interface{}{
+	job.Status = "completed"
+	if err := s.updateJob(job, outbox.EventTypeQuantumJobCompleted, map[string]interface{}{
 		"status":       "completed",
 		"completed_at": &now,
 		"result":       datatypes.JSON(fmt.Sprintf("%v", result)),
-	})
+	}); err != nil {
+		return nil, apperrors.Wrap(err, "update quantum job failed")
+	}
 
 	return &vo.QuantumQKDVO{
 		JobID:       jobID,
@@ -128,7 +157,7 @@ func (s *QuantumService) ClassifyQSVM(ctx context.Context, req *dto.QuantumQSVMR
 		SubmittedAt: time.Now(),
 	}
 	
-	if err := s.db.PG.Create(job).Error; err != nil {
+	if err := s.createJob(job); err != nil {
 		return nil, apperrors.Wrap(err, "create quantum job failed")
 	}
 
@@ -139,19 +168,26 @@ func (s *QuantumService) ClassifyQSVM(ctx context.Context, req *dto.QuantumQSVMR
 	var result map[string]interface{}
 	err := s.httpClient.PostJSON(ctx, "/api/v1/quantum/qsvm/classify", requestBody, &result)
 	if err != nil {
-		s.db.PG.Model(job).Updates(map[string]interface{}{
+		job.Status = "failed"
+		job.Error = err.Error()
+		if updateErr := s.updateJob(job, outbox.EventTypeQuantumJobFailed, map[string]interface{}{
 			"status": "failed",
 			"error":  err.Error(),
-		})
+		}); updateErr != nil {
+			return nil, apperrors.Wrap(err, fmt.Sprintf("qsvm classification failed (update quantum job failed: %v)", updateErr))
+		}
 		return nil, apperrors.Wrap(err, "qsvm classification failed")
 	}
 
 	now := time.Now()
-	s.db.PG.Model(job).Updates(map[string]interface{}{
+	job.Status = "completed"
+	if err := s.updateJob(job, outbox.EventTypeQuantumJobCompleted, map[string]interface{}{
 		"status":       "completed",
 		"completed_at": &now,
 		"result":       datatypes.JSON(fmt.Sprintf("%v", result)),
-	})
+	}); err != nil {
+		return nil, apperrors.Wrap(err, "update quantum job failed")
+	}
 
 	return &vo.QuantumClassifyVO{
 		JobID:       jobID,
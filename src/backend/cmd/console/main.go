@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
@@ -18,9 +19,11 @@ import (
 	"pandora_box_console_ids_ips/internal/loadbalancer"
 	"pandora_box_console_ids_ips/internal/logging"
 	"pandora_box_console_ids_ips/internal/metrics"
+	"pandora_box_console_ids_ips/internal/ml"
 	"pandora_box_console_ids_ips/internal/mqtt"
 	"pandora_box_console_ids_ips/internal/pubsub"
 	"pandora_box_console_ids_ips/internal/ratelimit"
+	"pandora_box_console_ids_ips/internal/token"
 )
 
 const (
@@ -81,11 +84,26 @@ func main() {
 		ErrorMessage: "Too many requests, please try again later",
 		RetryAfter:   true,
 		WhitelistIPs: viper.GetStringSlice("ratelimit.whitelist_ips"),
+
+		RedisAddr:     viper.GetString("ratelimit.redis_addr"),
+		RedisPassword: viper.GetString("ratelimit.redis_password"),
+		RedisDB:       viper.GetInt("ratelimit.redis_db"),
+	}
+	if err := viper.UnmarshalKey("ratelimit.levels", &rateLimitMiddlewareConfig.Levels); err != nil {
+		logger.Errorf("解析速率限制層級失敗: %v", err)
+	}
+	if err := viper.UnmarshalKey("ratelimit.adaptive", &rateLimitMiddlewareConfig.Adaptive); err != nil {
+		logger.Errorf("解析自適應速率限制設定失敗: %v", err)
 	}
 	rateLimitMiddleware := ratelimit.NewMiddleware(rateLimiter, rateLimitMiddlewareConfig, logger)
+	defer rateLimitMiddleware.Close()
+	if err := metricsCollector.Register(rateLimitMiddleware); err != nil {
+		logger.Errorf("註冊速率限制指標失敗: %v", err)
+	}
 
 	// 2. 初始化 Pub/Sub 系統
 	var pubsubInstance pubsub.PubSub
+	var processedEvents pubsub.IdempotencyStore
 	if viper.GetBool("pubsub.enabled") {
 		pubsubConfig := &pubsub.Config{
 			Type:          viper.GetString("pubsub.type"),
@@ -100,11 +118,56 @@ func main() {
 			logger.Errorf("初始化 Pub/Sub 失敗: %v", err)
 		} else {
 			defer pubsubInstance.Close()
+			// 事件可能重複投遞，消費者以事件 ID 去重
+			var closeProcessed func() error
+			processedEvents, closeProcessed = pubsub.NewIdempotencyStoreForConfig(pubsubConfig)
+			defer closeProcessed()
 			logger.Info("Pub/Sub 系統已啟動")
 		}
 	}
 
-	// 3. 初始化 MQTT Broker
+	// 分析引擎回報的威脅事件提高來源 IP 的風險，縮小其速率限制
+	if risk := rateLimitMiddleware.RiskTracker(); risk != nil && pubsubInstance != nil {
+		err := pubsubInstance.Subscribe(context.Background(), "security.events", pubsub.IdempotentHandler(processedEvents, func(topic string, message []byte) error {
+			var event pubsub.ThreatEvent
+			if err := json.Unmarshal(message, &event); err != nil {
+				logger.Debugf("略過無法解析的安全事件: %v", err)
+				return nil
+			}
+			risk.ReportSeverity(ratelimit.RiskSourceIP, event.SourceIP, event.Severity)
+			return nil
+		}))
+		if err != nil {
+			logger.Errorf("訂閱安全事件失敗: %v", err)
+		}
+	}
+
+	// 用戶行為基線：每分鐘的請求指標先與基線比對，偵測到異常時提高該用戶的風險
+	var behaviorRecorder *ml.RequestRecorder
+	if risk := rateLimitMiddleware.RiskTracker(); risk != nil {
+		baseline := ml.NewBehaviorBaseline(logger)
+		baseline.OnAnomaly(func(detection *ml.AnomalyDetection) {
+			if detection.IsAnomaly {
+				risk.ReportSeverity(ratelimit.RiskSourceUser, detection.UserID, detection.Severity)
+			}
+		})
+		behaviorRecorder = ml.NewRequestRecorder(baseline)
+		behaviorCtx, stopBehavior := context.WithCancel(context.Background())
+		defer stopBehavior()
+		go behaviorRecorder.Run(behaviorCtx, time.Minute)
+	}
+
+	// 3. 內嵌 MQTT Broker：設備直接連線到 Console，連線時驗證設備憑證或 Token 並強制執行主題 ACL
+	var mqttServer *mqtt.Server
+	var deviceTokens *handlers.DeviceTokenHandler
+	if viper.GetBool("mqtt.server.enabled") {
+		mqttServer, deviceTokens = startMQTTServer(logger, centralLogger)
+		if mqttServer != nil {
+			defer mqttServer.Close()
+		}
+	}
+
+	// 4. 初始化 MQTT Broker
 	var mqttBroker *mqtt.Broker
 	if viper.GetBool("mqtt.enabled") {
 		mqttConfig := &mqtt.Config{
@@ -122,12 +185,33 @@ func main() {
 			AutoReconnect:    viper.GetBool("mqtt.auto_reconnect"),
 			CleanSession:     viper.GetBool("mqtt.clean_session"),
 			OrderMatters:     viper.GetBool("mqtt.order_matters"),
+			CACert:           viper.GetString("mqtt.ca_cert"),
+			ClientCert:       viper.GetString("mqtt.client_cert"),
+			ClientKey:        viper.GetString("mqtt.client_key"),
+			DeviceID:         viper.GetString("mqtt.device_id"),
+			OfflineQueue: mqtt.OfflineQueueConfig{
+				Dir:          viper.GetString("mqtt.offline_queue.dir"),
+				MaxMessages:  viper.GetInt("mqtt.offline_queue.max_messages"),
+				MaxBytes:     viper.GetInt64("mqtt.offline_queue.max_bytes"),
+				Policy:       mqtt.OverflowPolicy(viper.GetString("mqtt.offline_queue.policy")),
+				BlockTimeout: viper.GetDuration("mqtt.offline_queue.block_timeout"),
+			},
+		}
+		if err := viper.UnmarshalKey("mqtt.acl", &mqttConfig.ACL); err != nil {
+			logger.Errorf("解析 MQTT ACL 失敗: %v", err)
 		}
 		var err error
 		mqttBroker, err = mqtt.NewBroker(mqttConfig, logger)
 		if err != nil {
 			logger.Errorf("初始化 MQTT Broker 失敗: %v", err)
 		} else {
+			mqttBroker.SetAuditLogger(centralLogger)
+			// 離線佇列深度與丟棄數與其他 Console 指標一起匯出
+			queueMetrics := metrics.NewMicroserviceMetrics("console")
+			if err := metricsCollector.Register(queueMetrics.MQTTOfflineQueueDepth, queueMetrics.MQTTOfflineQueueDropped); err != nil {
+				logger.Errorf("註冊 MQTT 離線佇列指標失敗: %v", err)
+			}
+			mqttBroker.SetQueueMetrics(queueMetrics)
 			if err := mqttBroker.Start(); err != nil {
 				logger.Errorf("啟動 MQTT Broker 失敗: %v", err)
 			} else {
@@ -137,7 +221,7 @@ func main() {
 		}
 	}
 
-	// 4. 初始化 Load Balancer（如果啟用）
+	// 5. 初始化 Load Balancer（如果啟用）
 	var lb *loadbalancer.LoadBalancer
 	if viper.GetBool("loadbalancer.enabled") {
 		lbConfig := &loadbalancer.Config{
@@ -171,6 +255,9 @@ func main() {
 	if pubsubInstance != nil {
 		logger.Info("✓ Pub/Sub 系統已啟動")
 	}
+	if mqttServer != nil {
+		logger.Info("✓ 內嵌 MQTT Broker 已啟動")
+	}
 	if mqttBroker != nil {
 		logger.Info("✓ MQTT Broker 已啟動")
 	}
@@ -180,7 +267,7 @@ func main() {
 	logger.Info("===========================")
 
 	// 創建HTTP服務器
-	server := setupHTTPServer(*port, authHandler, rateLimitMiddleware, lb, logger)
+	server := setupHTTPServer(*port, authHandler, deviceTokens, rateLimitMiddleware, behaviorRecorder, lb, logger)
 
 	// 優雅啟動和關閉
 
@@ -258,9 +345,77 @@ func loadConfig(configFile string) error {
 	return nil
 }
 
+// startMQTTServer 啟動內嵌 MQTT Broker，返回 Broker 與設備 Token 管理處理器；失敗時返回 nil
+func startMQTTServer(logger *logrus.Logger, centralLogger *logging.CentralLogger) (*mqtt.Server, *handlers.DeviceTokenHandler) {
+	tokens := token.NewAuth(logger)
+	if path := viper.GetString("mqtt.server.token_store"); path != "" {
+		if err := tokens.SetDeviceTokenStore(token.NewFileDeviceTokenStore(path)); err != nil {
+			logger.Errorf("載入設備 Token 失敗: %v", err)
+			return nil, nil
+		}
+	} else {
+		logger.Warn("未設定 mqtt.server.token_store，設備 Token 重新啟動後失效")
+	}
+
+	authenticator := mqtt.NewDeviceAuthenticator(tokens)
+	var services []struct {
+		Username string
+		Password string
+	}
+	if err := viper.UnmarshalKey("mqtt.server.services", &services); err != nil {
+		logger.Errorf("解析 MQTT 服務帳號失敗: %v", err)
+		return nil, nil
+	}
+	for _, service := range services {
+		authenticator.AddService(service.Username, os.ExpandEnv(service.Password))
+	}
+
+	var rules []mqtt.ACLRule
+	if err := viper.UnmarshalKey("mqtt.server.acl", &rules); err != nil {
+		logger.Errorf("解析內嵌 MQTT Broker ACL 失敗: %v", err)
+		return nil, nil
+	}
+	acl, err := mqtt.NewACL(append(mqtt.DefaultDeviceRules(), rules...))
+	if err != nil {
+		logger.Errorf("內嵌 MQTT Broker ACL 無效: %v", err)
+		return nil, nil
+	}
+
+	serverConfig := &mqtt.ServerConfig{
+		Addr:          viper.GetString("mqtt.server.addr"),
+		Authenticator: authenticator,
+		ACL:           acl,
+		Audit:         centralLogger,
+	}
+	if certFile := viper.GetString("mqtt.server.tls_cert"); certFile != "" {
+		serverConfig.TLSConfig, err = mqtt.ServerTLSConfig(certFile, viper.GetString("mqtt.server.tls_key"), viper.GetString("mqtt.server.client_ca"))
+		if err != nil {
+			logger.Errorf("載入內嵌 MQTT Broker TLS 設定失敗: %v", err)
+			return nil, nil
+		}
+	}
+
+	server := mqtt.NewServer(serverConfig, logger)
+	if err := server.Start(); err != nil {
+		logger.Errorf("啟動內嵌 MQTT Broker 失敗: %v", err)
+		return nil, nil
+	}
+
+	// 每小時清理過期的設備 Token
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			tokens.CleanExpiredTokens()
+		}
+	}()
+
+	return server, handlers.NewDeviceTokenHandler(tokens, server, logger, centralLogger)
+}
+
 // setupHTTPServer 設定HTTP服務器
-func setupHTTPServer(port int, authHandler *handlers.AuthHandler,
-	rateLimitMW *ratelimit.Middleware, lb *loadbalancer.LoadBalancer, logger *logrus.Logger) *http.Server {
+func setupHTTPServer(port int, authHandler *handlers.AuthHandler, deviceTokens *handlers.DeviceTokenHandler,
+	rateLimitMW *ratelimit.Middleware, behaviorRecorder *ml.RequestRecorder, lb *loadbalancer.LoadBalancer, logger *logrus.Logger) *http.Server {
 	// 設定Gin模式
 	gin.SetMode(gin.ReleaseMode)
 
@@ -276,6 +431,9 @@ func setupHTTPServer(port int, authHandler *handlers.AuthHandler,
 	if rateLimitMW != nil {
 		router.Use(rateLimitMW.Handler())
 	}
+	if behaviorRecorder != nil {
+		router.Use(behaviorMiddleware(behaviorRecorder))
+	}
 
 	// 根路由
 	router.GET("/", func(c *gin.Context) {
@@ -341,6 +499,13 @@ func setupHTTPServer(port int, authHandler *handlers.AuthHandler,
 					c.JSON(http.StatusNotImplemented, gin.H{"error": "Load balancer not enabled"})
 				}
 			})
+
+			// 設備 MQTT Token 簽發與撤銷（需要 auth.admin_token）
+			if deviceTokens != nil {
+				devices := admin.Group("/devices", handlers.RequireAdminToken(viper.GetString("auth.admin_token")))
+				devices.POST("/:id/tokens", deviceTokens.IssueToken)
+				devices.DELETE("/:id/tokens", deviceTokens.RevokeTokens)
+			}
 		}
 	}
 
@@ -353,6 +518,17 @@ func setupHTTPServer(port int, authHandler *handlers.AuthHandler,
 	}
 }
 
+// behaviorMiddleware 記錄已驗證用戶完成的請求，供行為基線學習與異常偵測
+func behaviorMiddleware(recorder *ml.RequestRecorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if uid, exists := c.Get("user_id"); exists {
+			recorder.Record(fmt.Sprint(uid), c.FullPath(), c.ClientIP(), c.Request.UserAgent(), c.Writer.Status() >= http.StatusBadRequest)
+		}
+	}
+}
+
 // corsMiddleware CORS中間件
 func corsMiddleware() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
@@ -93,6 +93,11 @@ func main() {
 	// 啟動 HTTP 健康檢查服務器
 	go startHTTPHealthServer(logger, controlService)
 
+	// 死信管理 API 預設關閉；設定 DEAD_LETTER_ADMIN_ADDR 時在獨立的埠提供，並要求 DEAD_LETTER_ADMIN_TOKEN
+	if addr := os.Getenv("DEAD_LETTER_ADMIN_ADDR"); addr != "" {
+		go startDeadLetterAdminServer(logger, addr, os.Getenv("DEAD_LETTER_ADMIN_TOKEN"), mq)
+	}
+
 	// 發布服務啟動事件
 	ctx := context.Background()
 	startEvent := pubsub.NewSystemEvent(ServiceName, "running", "Service started successfully")
@@ -185,6 +190,29 @@ func loggingInterceptor(logger *logrus.Logger) grpc.UnaryServerInterceptor {
 	}
 }
 
+// startDeadLetterAdminServer serves the dead-letter admin API (inspect, replay, purge) on its own listener
+func startDeadLetterAdminServer(logger *logrus.Logger, addr, token string, deadLetters pubsub.DeadLetterAdmin) {
+	handler, err := pubsub.NewDeadLetterAdminHandler(deadLetters, token)
+	if err != nil {
+		logger.Errorf("Dead-letter admin API disabled: %v", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", http.StripPrefix("/admin", handler))
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	logger.Infof("Dead-letter admin API listening on %s", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Errorf("Dead-letter admin server error: %v", err)
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -46,6 +46,8 @@ func main() {
 	rootCmd.PersistentFlags().String("block-time", "20:00", "每日阻斷時間 (HH:MM)")
 	rootCmd.PersistentFlags().String("unlock-time", "08:00", "每日解鎖時間 (HH:MM)")
 
+	rootCmd.AddCommand(newReplayCommand())
+
 	// 綁定環境變數
 	if err := viper.BindPFlags(rootCmd.PersistentFlags()); err != nil {
 		logger.Fatalf("綁定命令列參數失敗: %v", err)
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/axiom"
+	"pandora_box_console_ids_ips/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// newReplayCommand 建立離線重播子命令
+func newReplayCommand() *cobra.Command {
+	var (
+		rulesDir   string
+		realTime   bool
+		speed      float64
+		format     string
+		output     string
+		alertsOnly bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "replay <capture.pcap|capture.pcapng>",
+		Short: "離線重播擷取檔",
+		Long:  `讀取 pcap/pcapng 擷取檔並送入 Axiom 分析引擎，輸出分析結果報告，用於規則變更的回歸測試`,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "json" && format != "csv" {
+				return fmt.Errorf("不支援的報告格式: %s", format)
+			}
+			if level, err := logrus.ParseLevel(cmd.Flag("log-level").Value.String()); err == nil {
+				logger.SetLevel(level)
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			engine, err := startReplayEngine(ctx, rulesDir)
+			if err != nil {
+				return err
+			}
+			defer engine.Stop()
+
+			report, err := engine.ReplayFile(ctx, args[0], axiom.ReplayOptions{
+				RealTime:   realTime,
+				Speed:      speed,
+				AlertsOnly: alertsOnly,
+			})
+			if err != nil {
+				return fmt.Errorf("重播失敗: %v", err)
+			}
+
+			var writer io.Writer = os.Stdout
+			if output != "" && output != "-" {
+				file, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("建立報告檔案失敗: %v", err)
+				}
+				defer file.Close()
+				writer = file
+			}
+
+			if format == "csv" {
+				return report.WriteCSV(writer)
+			}
+			return report.WriteJSON(writer)
+		},
+	}
+
+	cmd.Flags().StringVar(&rulesDir, "rules-dir", "", "規則目錄 (未指定時使用預設規則)")
+	cmd.Flags().BoolVar(&realTime, "realtime", false, "依擷取檔的原始時間間隔重播")
+	cmd.Flags().Float64Var(&speed, "speed", 1, "原始時間重播的速度倍率")
+	cmd.Flags().StringVar(&format, "format", "json", "報告格式 (json, csv)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "報告輸出路徑 (預設: 標準輸出)")
+	cmd.Flags().BoolVar(&alertsOnly, "alerts-only", false, "報告只包含告警與阻擋的結果")
+
+	return cmd
+}
+
+// startReplayEngine 建立並啟動重播專用的分析引擎
+func startReplayEngine(ctx context.Context, rulesDir string) (*axiom.AnalysisEngine, error) {
+	engine := axiom.NewAnalysisEngine(logger, metrics.NewPrometheusMetrics(logger))
+	if rulesDir != "" {
+		rules, _, err := axiom.LoadRulesDirectory(rulesDir)
+		if err != nil {
+			return nil, fmt.Errorf("載入規則失敗: %v", err)
+		}
+		if err := engine.ReplaceRules(rules, "directory"); err != nil {
+			return nil, fmt.Errorf("套用規則失敗: %v", err)
+		}
+		logger.Infof("已載入 %d 條規則: %s", len(rules), rulesDir)
+	}
+
+	go func() {
+		if err := engine.Start(ctx); err != nil && err != context.Canceled {
+			logger.Errorf("分析引擎錯誤: %v", err)
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !engine.IsRunning() {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("分析引擎啟動逾時")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return engine, nil
+}
@@ -9,10 +9,13 @@ import (
 
 	"pandora_box_console_ids_ips/internal/axiom"
 	"pandora_box_console_ids_ips/internal/metrics"
+	"pandora_box_console_ids_ips/internal/pubsub"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
 )
 
 var (
@@ -41,6 +44,21 @@ func main() {
 	rootCmd.PersistentFlags().String("log-level", "info", "日誌等級 (debug, info, warn, error)")
 	rootCmd.PersistentFlags().String("prometheus-url", "http://prometheus:9090", "Prometheus伺服器URL")
 	rootCmd.PersistentFlags().String("grafana-url", "http://grafana:3000", "Grafana伺服器URL")
+	rootCmd.PersistentFlags().String("rules-dir", "", "規則目錄 (YAML/JSON/Snort)，變更時自動重新載入")
+	rootCmd.PersistentFlags().StringSlice("ws-allowed-origins", nil, "允許的 WebSocket 來源 (預設只允許同源)")
+	rootCmd.PersistentFlags().Int("ws-queue-size", 256, "每個 WebSocket 客戶端的發送佇列長度")
+	rootCmd.PersistentFlags().String("ws-slow-consumer-policy", "drop", "WebSocket 發送佇列已滿時的策略 (drop, disconnect)")
+	rootCmd.PersistentFlags().Int("live-feed-size", 1000, "SSE 與長輪詢續傳保留的最近事件數量")
+	rootCmd.PersistentFlags().String("rabbitmq-url", "", "排程報表與事件發件匣發布用的 RabbitMQ URL (設定檔 reports 中 publish: true 時需要)")
+	rootCmd.PersistentFlags().String("rabbitmq-exchange", "pandora.events", "排程報表發布的 exchange")
+	rootCmd.PersistentFlags().String("cloudevents-mode", "legacy", "發布事件的編碼 (legacy, structured, binary)")
+	rootCmd.PersistentFlags().String("outbox-dsn", "", "axiom-api PostgreSQL DSN，設定後轉發 event_outbox 中的事件 (需要 rabbitmq-url)")
+	rootCmd.PersistentFlags().Duration("outbox-poll-interval", time.Second, "事件發件匣輪詢間隔")
+	rootCmd.PersistentFlags().String("state-file", "/var/lib/pandora/axiom-state.json", "分析引擎狀態檔 (規則、黑白名單、誤報抑制)，空白則不保存")
+	rootCmd.PersistentFlags().String("state-dsn", "", "分析引擎狀態的 PostgreSQL DSN，設定後取代 state-file")
+	rootCmd.PersistentFlags().String("events-dsn", "", "安全事件與告警的 PostgreSQL DSN，空白則沿用 state-dsn；皆未設定時事件只保存在記憶體")
+	rootCmd.PersistentFlags().String("security-events-redis", "", "Console Pub/Sub 的 Redis 位址，設定後威脅結果發布到 security.events 以收緊來源 IP 的速率限制")
+	rootCmd.PersistentFlags().String("security-events-redis-password", "", "security-events-redis 的密碼")
 
 	// 綁定環境變數
 	if err := viper.BindPFlags(rootCmd.PersistentFlags()); err != nil {
@@ -105,6 +123,118 @@ func runUIServer(cmd *cobra.Command, args []string) {
 
 	// 初始化 UI 伺服器
 	uiServer := axiom.NewUIServer(logger, metricsClient)
+	wsConfig := axiom.DefaultWebSocketConfig()
+	wsConfig.AllowedOrigins = viper.GetStringSlice("ws-allowed-origins")
+	wsConfig.QueueSize = viper.GetInt("ws-queue-size")
+	wsConfig.SlowConsumerPolicy = viper.GetString("ws-slow-consumer-policy")
+	wsConfig.FeedSize = viper.GetInt("live-feed-size")
+	if err := uiServer.SetWebSocketConfig(wsConfig); err != nil {
+		logger.Fatalf("WebSocket 設定錯誤: %v", err)
+	}
+
+	// 連接分析引擎與規則目錄
+	engine := axiom.NewAnalysisEngine(logger, metricsClient)
+	if store := openStateStore(); store != nil {
+		defer store.Close()
+		engine.SetStateStore(store)
+	}
+	if store := openEventStore(); store != nil {
+		engine.SetEventStore(store)
+	}
+	uiServer.SetAnalysisEngine(engine)
+	go func() {
+		if err := engine.Start(ctx); err != nil && err != context.Canceled {
+			logger.Errorf("分析引擎啟動失敗: %v", err)
+		}
+	}()
+
+	// 連接 RabbitMQ (排程報表與事件發件匣共用)
+	var mq pubsub.MessageQueue
+	if url := viper.GetString("rabbitmq-url"); url != "" {
+		mqConfig := pubsub.DefaultConfig()
+		mqConfig.URL = url
+		mqConfig.Exchange = viper.GetString("rabbitmq-exchange")
+		mode, err := pubsub.ParseCloudEventsMode(viper.GetString("cloudevents-mode"))
+		if err != nil {
+			logger.Fatalf("無效的 cloudevents-mode: %v", err)
+		}
+		mqConfig.CloudEventsMode = mode
+		rabbit, err := pubsub.NewRabbitMQ(mqConfig)
+		if err != nil {
+			logger.Errorf("連接 RabbitMQ 失敗，排程報表與發件匣事件將不會發布: %v", err)
+		} else {
+			defer rabbit.Close()
+			// 發布前依事件結構驗證，無效事件送往 quarantine_events
+			mq = pubsub.NewValidatingQueue(rabbit, pubsub.DefaultSchemaRegistry(), mqConfig.Exchange)
+		}
+	}
+
+	// 規則目錄監看；載入失敗時發布 system.error 事件
+	if rulesDir := viper.GetString("rules-dir"); rulesDir != "" {
+		watcher := axiom.NewRuleWatcher(engine, rulesDir, logger)
+		if mq != nil {
+			watcher.SetPublisher(mq, viper.GetString("rabbitmq-exchange"))
+		}
+		if err := watcher.Start(ctx); err != nil {
+			logger.Errorf("規則目錄監看啟動失敗: %v", err)
+		} else {
+			uiServer.SetRuleWatcher(watcher)
+		}
+	}
+
+	// 威脅結果發布到 Console 的 Pub/Sub，由速率限制的風險追蹤收緊來源 IP 的限制
+	if addr := viper.GetString("security-events-redis"); addr != "" {
+		// 與 Console 的 pubsub 設定相同：Redis Streams、未指定 exchange
+		events, err := pubsub.NewMessageQueue(&pubsub.Config{
+			Type:              "redis",
+			RedisAddr:         addr,
+			RedisPassword:     viper.GetString("security-events-redis-password"),
+			ConnectionTimeout: 10 * time.Second,
+		})
+		if err != nil {
+			logger.Errorf("連接 Console Pub/Sub 失敗，威脅結果將不會回報給速率限制: %v", err)
+		} else {
+			defer events.Close()
+			defer publishThreats(ctx, engine, events)()
+		}
+	}
+
+	// 事件發件匣轉發 (axiom-api 在寫入資料的同一交易中記錄事件)
+	if dsn := viper.GetString("outbox-dsn"); dsn != "" && mq != nil {
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			logger.Fatalf("連接發件匣資料庫失敗: %v", err)
+		}
+		relayConfig := pubsub.DefaultOutboxRelayConfig()
+		relayConfig.PollInterval = viper.GetDuration("outbox-poll-interval")
+		relay, err := pubsub.NewOutboxRelay(db, mq, relayConfig)
+		if err != nil {
+			logger.Fatalf("建立發件匣轉發器失敗: %v", err)
+		}
+		if err := metricsClient.Register(relay); err != nil {
+			logger.Errorf("註冊發件匣指標失敗: %v", err)
+		}
+		go relay.Run(ctx)
+		logger.Info("已啟動事件發件匣轉發")
+	}
+
+	// 排程報表 (設定檔 reports 區段)
+	var schedules []axiom.ReportSchedule
+	if err := viper.UnmarshalKey("reports", &schedules); err != nil {
+		logger.Fatalf("解析排程報表設定失敗: %v", err)
+	}
+	if len(schedules) > 0 {
+		scheduler, err := axiom.NewReportScheduler(engine, logger, schedules)
+		if err != nil {
+			logger.Fatalf("建立報表排程器失敗: %v", err)
+		}
+		if mq != nil {
+			scheduler.SetPublisher(mq, viper.GetString("rabbitmq-exchange"))
+		}
+		scheduler.Start(ctx)
+		uiServer.SetReportScheduler(scheduler)
+		logger.Infof("已啟動 %d 個排程報表", len(schedules))
+	}
 
 	// 啟動 UI 伺服器
 	go func() {
@@ -117,5 +247,121 @@ func runUIServer(cmd *cobra.Command, args []string) {
 
 	// 等待上下文取消
 	<-ctx.Done()
+
+	// 寫入仍在佇列中的引擎狀態變更
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := engine.FlushState(flushCtx); err != nil {
+		logger.Errorf("寫入引擎狀態失敗: %v", err)
+	}
 	logger.Info("Axiom UI 伺服器已關閉")
 }
+
+// threatQueueSize 等待發布的威脅結果上限，超過時丟棄以免阻塞分析路徑
+const threatQueueSize = 1024
+
+// publishThreats 將非放行的分析結果以 ThreatEvent 發布到 security.events；
+// 回傳的函式取消註冊監聽器
+func publishThreats(ctx context.Context, engine *axiom.AnalysisEngine, queue pubsub.MessageQueue) func() {
+	pending := make(chan *axiom.AnalysisResult, threatQueueSize)
+	unsubscribe := engine.OnResult(func(result *axiom.AnalysisResult) {
+		if result.Action == "allow" || result.SourceIP == "" {
+			return
+		}
+		select {
+		case pending <- result:
+		default:
+			logger.Warnf("威脅事件佇列已滿，丟棄 %s 的結果", result.SourceIP)
+		}
+	})
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case result := <-pending:
+				event := pubsub.NewThreatEvent(result.ThreatType, result.SourceIP, result.Details, result.Action, threatLevelScore(result.ThreatLevel))
+				event.Source = "axiom-engine"
+				event.Severity = result.ThreatLevel
+				event.TargetIP = result.DestIP
+				event.TargetPort = result.DestPort
+				data, err := pubsub.ToJSON(event)
+				if err != nil {
+					logger.Errorf("序列化威脅事件失敗: %v", err)
+					continue
+				}
+				if err := queue.Publish(ctx, "", "security.events", data); err != nil {
+					logger.Errorf("發布威脅事件失敗: %v", err)
+				}
+			}
+		}
+	}()
+
+	return unsubscribe
+}
+
+// threatLevelScore 將引擎的威脅等級轉換為 ThreatEvent 的 1-10 分數
+func threatLevelScore(level string) int {
+	switch level {
+	case "critical":
+		return 9
+	case "high":
+		return 7
+	case "medium":
+		return 5
+	default:
+		return 3
+	}
+}
+
+// openEventStore 依設定建立 PostgreSQL 事件儲存；未設定 DSN 時回傳 nil，沿用記憶體儲存
+func openEventStore() axiom.EventStore {
+	dsn := viper.GetString("events-dsn")
+	if dsn == "" {
+		dsn = viper.GetString("state-dsn")
+	}
+	if dsn == "" {
+		logger.Warn("未設定 events-dsn 或 state-dsn，安全事件與告警只保存在記憶體")
+		return nil
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		logger.Fatalf("連接事件資料庫失敗: %v", err)
+	}
+	store, err := axiom.NewPostgresEventStore(db)
+	if err != nil {
+		logger.Fatalf("建立事件儲存失敗: %v", err)
+	}
+	logger.Info("安全事件與告警保存於 PostgreSQL")
+	return store
+}
+
+// openStateStore 依設定建立分析引擎狀態儲存；state-dsn 優先於 state-file
+func openStateStore() axiom.StateStore {
+	if dsn := viper.GetString("state-dsn"); dsn != "" {
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+		if err != nil {
+			logger.Fatalf("連接引擎狀態資料庫失敗: %v", err)
+		}
+		store, err := axiom.NewPostgresStateStore(db)
+		if err != nil {
+			logger.Fatalf("建立引擎狀態儲存失敗: %v", err)
+		}
+		logger.Info("引擎狀態保存於 PostgreSQL")
+		return store
+	}
+
+	path := viper.GetString("state-file")
+	if path == "" {
+		logger.Warn("未設定 state-file 或 state-dsn，重新啟動後規則與黑白名單將會遺失")
+		return nil
+	}
+	store, err := axiom.NewFileStateStore(path)
+	if err != nil {
+		logger.Fatalf("開啟引擎狀態檔失敗: %v", err)
+	}
+	logger.Infof("引擎狀態保存於 %s", path)
+	return store
+}
@@ -0,0 +1,384 @@
+package axiom
+
+import (
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ahoCorasick 多模式字串比對自動機 (ASCII 不分大小寫)
+type ahoCorasick struct {
+	trans   []map[byte]int32
+	fail    []int32
+	outputs [][]int32
+	count   int
+}
+
+// asciiLower ASCII 小寫對照表，避免比對時額外配置記憶體
+var asciiLower = func() [256]byte {
+	var table [256]byte
+	for i := range table {
+		c := byte(i)
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		table[i] = c
+	}
+	return table
+}()
+
+// newAhoCorasick 建立自動機，patterns 的索引即為比對時回報的 ID
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	ac := &ahoCorasick{
+		trans:   []map[byte]int32{make(map[byte]int32)},
+		fail:    []int32{0},
+		outputs: [][]int32{nil},
+		count:   len(patterns),
+	}
+
+	// 建立 trie
+	for id, pattern := range patterns {
+		state := int32(0)
+		for i := 0; i < len(pattern); i++ {
+			c := asciiLower[pattern[i]]
+			next, ok := ac.trans[state][c]
+			if !ok {
+				next = int32(len(ac.trans))
+				ac.trans = append(ac.trans, make(map[byte]int32))
+				ac.fail = append(ac.fail, 0)
+				ac.outputs = append(ac.outputs, nil)
+				ac.trans[state][c] = next
+			}
+			state = next
+		}
+		ac.outputs[state] = append(ac.outputs[state], int32(id))
+	}
+
+	// 以 BFS 建立失敗連結並合併輸出
+	queue := make([]int32, 0, len(ac.trans))
+	for _, next := range ac.trans[0] {
+		queue = append(queue, next)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for c, next := range ac.trans[state] {
+			f := ac.fail[state]
+			for {
+				if target, ok := ac.trans[f][c]; ok {
+					ac.fail[next] = target
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = ac.fail[f]
+			}
+			ac.outputs[next] = append(ac.outputs[next], ac.outputs[ac.fail[next]]...)
+			queue = append(queue, next)
+		}
+	}
+
+	return ac
+}
+
+// scan 掃描文字，對每個命中的模式呼叫 fn
+func (ac *ahoCorasick) scan(text []byte, fn func(id int32)) {
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		c := asciiLower[text[i]]
+		for {
+			if next, ok := ac.trans[state][c]; ok {
+				state = next
+				break
+			}
+			if state == 0 {
+				break
+			}
+			state = ac.fail[state]
+		}
+		for _, id := range ac.outputs[state] {
+			fn(id)
+		}
+	}
+}
+
+// rulePrefilter 以規則中的字面字串建立的預先過濾器，
+// 只有命中字串的規則 (或無法萃取字串的規則) 才需要執行完整比對
+type rulePrefilter struct {
+	automaton  *ahoCorasick
+	patternMap [][]int // 模式 ID -> 規則索引
+	always     []uint64
+	overlap    int // 比對重組資料時需回溯的位元組數，-1 表示需比對整個緩衝區
+}
+
+// newRulePrefilter 根據規則清單建立預先過濾器
+func newRulePrefilter(rules []*SecurityRule) *rulePrefilter {
+	pf := &rulePrefilter{
+		always: make([]uint64, (len(rules)+63)/64),
+	}
+
+	patterns := make([]string, 0, len(rules))
+	index := make(map[string]int)
+	for i, rule := range rules {
+		if span := ruleSpan(rule); span < 0 || pf.overlap < 0 {
+			pf.overlap = -1
+		} else if span > pf.overlap {
+			pf.overlap = span
+		}
+
+		// 停用的規則在 AnalyzePacket 中會被略過；視為永遠候選，
+		// 以免規則被重新啟用後因自動機未重建而漏判
+		var literals []string
+		if rule.Enabled {
+			literals = ruleLiterals(rule)
+		}
+		if len(literals) == 0 {
+			pf.always[i/64] |= 1 << (uint(i) % 64)
+			continue
+		}
+		for _, lit := range literals {
+			id, ok := index[lit]
+			if !ok {
+				id = len(patterns)
+				index[lit] = id
+				patterns = append(patterns, lit)
+				pf.patternMap = append(pf.patternMap, nil)
+			}
+			pf.patternMap[id] = append(pf.patternMap[id], i)
+		}
+	}
+
+	pf.automaton = newAhoCorasick(patterns)
+	return pf
+}
+
+// candidates 回傳需要完整比對的規則位元集合
+func (pf *rulePrefilter) candidates(packet *NetworkPacket) []uint64 {
+	if pf == nil {
+		return nil
+	}
+	set := make([]uint64, len(pf.always))
+	copy(set, pf.always)
+	if pf.automaton.count == 0 {
+		return set
+	}
+
+	mark := func(id int32) {
+		for _, i := range pf.patternMap[id] {
+			set[i/64] |= 1 << (uint(i) % 64)
+		}
+	}
+	scan := func(text string) {
+		pf.automaton.scan([]byte(text), mark)
+		// 部分非 ASCII 字元 (如 U+212A KELVIN SIGN) 在不分大小寫比對時等同 ASCII 字母
+		if !isASCII(text) {
+			pf.automaton.scan([]byte(foldToASCII(text)), mark)
+		}
+	}
+	if packet.PayloadString != "" {
+		scan(packet.PayloadString)
+	}
+	if len(packet.Payload) > 0 && string(packet.Payload) != packet.PayloadString {
+		scan(string(packet.Payload))
+	}
+	return set
+}
+
+// streamOverlap 重組資料需回溯比對的位元組數；未建立過濾器時回傳 -1 (比對整個緩衝區)
+func (pf *rulePrefilter) streamOverlap() int {
+	if pf == nil {
+		return -1
+	}
+	return pf.overlap
+}
+
+// isCandidate 檢查規則是否需要完整比對；set 為 nil 時 (未建立過濾器) 一律視為候選
+func isCandidate(set []uint64, i int) bool {
+	if set == nil {
+		return true
+	}
+	return set[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+// ruleLiterals 萃取規則比對成功時「至少出現其中之一」的字面字串；
+// 回傳 nil 表示無法萃取，該規則必須每次都完整比對
+func ruleLiterals(rule *SecurityRule) []string {
+	switch rule.Type {
+	case "pattern":
+		if rule.regex != nil {
+			return regexLiterals(rule.regex.String())
+		}
+		if rule.Pattern == "" || !isASCII(rule.Pattern) {
+			return nil
+		}
+		return []string{lowerASCII(rule.Pattern)}
+	case "signature":
+		if rule.Signature != nil {
+			return rule.Signature.prefilterLiterals()
+		}
+	}
+	return nil
+}
+
+// ruleSpan 規則一次匹配最多涵蓋的位元組數；-1 表示沒有上限或無法判斷
+func ruleSpan(rule *SecurityRule) int {
+	switch rule.Type {
+	case "pattern":
+		if rule.regex != nil {
+			re, err := syntax.Parse(rule.regex.String(), syntax.Perl)
+			if err != nil {
+				return -1
+			}
+			return regexSpan(re.Simplify())
+		}
+		return len(rule.Pattern)
+	case "signature":
+		if rule.Signature != nil {
+			return rule.Signature.span()
+		}
+	}
+	return 0
+}
+
+// regexSpan 正則表達式匹配的最大位元組長度；含無上限的重複或文字開頭錨點時回傳 -1
+func regexSpan(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return 0
+	case syntax.OpLiteral:
+		return len(string(re.Rune))
+	case syntax.OpCharClass:
+		// 範圍依序排列，最後一個上界為最大的字元
+		if len(re.Rune) == 0 {
+			return 0
+		}
+		return utf8.RuneLen(min(re.Rune[len(re.Rune)-1], unicode.MaxRune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return utf8.UTFMax
+	case syntax.OpCapture, syntax.OpQuest:
+		return regexSpan(re.Sub[0])
+	case syntax.OpRepeat:
+		sub := regexSpan(re.Sub[0])
+		if re.Max < 0 || sub < 0 {
+			return -1
+		}
+		return re.Max * sub
+	case syntax.OpConcat, syntax.OpAlternate:
+		total := 0
+		for _, sub := range re.Sub {
+			n := regexSpan(sub)
+			if n < 0 {
+				return -1
+			}
+			if re.Op == syntax.OpConcat {
+				total += n
+			} else if n > total {
+				total = n
+			}
+		}
+		return total
+	}
+	return -1
+}
+
+// regexLiterals 從正則表達式萃取必要的字面字串集合
+func regexLiterals(expr string) []string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return requiredLiterals(re.Simplify())
+}
+
+// requiredLiterals 遞迴分析語法樹；任何匹配都必定包含回傳集合中的至少一個字串
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		lit := string(re.Rune)
+		if lit == "" || !isASCII(lit) {
+			return nil
+		}
+		return []string{lowerASCII(lit)}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		var best []string
+		bestLen := 0
+		for _, sub := range re.Sub {
+			lits := requiredLiterals(sub)
+			if len(lits) == 0 {
+				continue
+			}
+			if l := minLen(lits); l > bestLen {
+				best, bestLen = lits, l
+			}
+		}
+		return best
+	case syntax.OpAlternate:
+		var all []string
+		for _, sub := range re.Sub {
+			lits := requiredLiterals(sub)
+			if len(lits) == 0 {
+				return nil
+			}
+			all = append(all, lits...)
+		}
+		return all
+	}
+	return nil
+}
+
+// minLen 字串集合中的最短長度
+func minLen(values []string) int {
+	n := len(values[0])
+	for _, v := range values[1:] {
+		if len(v) < n {
+			n = len(v)
+		}
+	}
+	return n
+}
+
+// isASCII 檢查字串是否只含 ASCII 字元 (自動機僅處理 ASCII 大小寫)
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// foldToASCII 將大小寫折疊後等同 ASCII 字母的字元替換為該 ASCII 字母
+func foldToASCII(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if r >= utf8.RuneSelf {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				if f < utf8.RuneSelf {
+					r = f
+					break
+				}
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// lowerASCII 逐位元組轉為 ASCII 小寫，非 ASCII 位元組保持不變
+func lowerASCII(s string) string {
+	b := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		b[i] = asciiLower[s[i]]
+	}
+	return string(b)
+}
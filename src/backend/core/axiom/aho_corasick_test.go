@@ -0,0 +1,138 @@
+package axiom
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAhoCorasickScan(t *testing.T) {
+	ac := newAhoCorasick([]string{"he", "she", "his", "hers", "SELECT"})
+
+	found := make(map[int32]int)
+	ac.scan([]byte("ushers select Hers"), func(id int32) {
+		found[id]++
+	})
+
+	assert.Equal(t, 2, found[0], "he 出現兩次")
+	assert.Equal(t, 1, found[1])
+	assert.Equal(t, 0, found[2])
+	assert.Equal(t, 2, found[3], "不分大小寫")
+	assert.Equal(t, 1, found[4])
+}
+
+func TestRequiredLiterals(t *testing.T) {
+	testCases := []struct {
+		expr     string
+		expected []string
+	}{
+		{`(?i)(union|select|insert)`, []string{"insert", "select", "union"}},
+		{`union\s+select`, []string{"select"}},
+		{`/cmd\.exe\?/c`, []string{"/cmd.exe?/c"}},
+		{`(?i)<script[^>]*>`, []string{"<script"}},
+		{`(ab)+cd`, []string{"ab"}},
+		{`a*`, nil},
+		{`(foo|.*)`, nil},
+		{`[0-9]{3}`, nil},
+		{`(?i)straße`, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expr, func(t *testing.T) {
+			lits := regexLiterals(tc.expr)
+			sort.Strings(lits)
+			if tc.expected == nil {
+				assert.Empty(t, lits)
+				return
+			}
+			// 共同前綴會被語法樹分解，只需確認每個字串都是原始候選的子字串
+			require.NotEmpty(t, lits)
+			for _, lit := range lits {
+				found := false
+				for _, want := range tc.expected {
+					if strings.Contains(want, lit) {
+						found = true
+					}
+				}
+				assert.True(t, found, "%q 不是預期字串的子字串", lit)
+			}
+		})
+	}
+}
+
+func TestRuleSpan(t *testing.T) {
+	signature := func(options string) *SecurityRule {
+		rule, err := NewSnortParser(nil).ParseRule(`alert tcp any any -> any 80 (msg:"span"; ` + options + ` sid:1;)`)
+		require.NoError(t, err)
+		return rule
+	}
+	pattern := func(value string) *SecurityRule {
+		rule := &SecurityRule{Type: "pattern", Pattern: value}
+		require.NoError(t, compileRule(rule))
+		return rule
+	}
+
+	assert.Equal(t, 5, ruleSpan(pattern("union")))
+	assert.Equal(t, 6, ruleSpan(pattern("regex:(?i)(union|select|drop)")))
+	assert.Equal(t, 5, ruleSpan(pattern("regex:ab[0-9]{0,3}")))
+	assert.Equal(t, -1, ruleSpan(pattern(`regex:union\s+select`)), "無上限的重複")
+	assert.Equal(t, -1, ruleSpan(pattern("regex:^GET")), "文字開頭錨點需要整個緩衝區")
+	assert.Equal(t, 0, ruleSpan(&SecurityRule{Type: "port", Pattern: "22"}))
+
+	assert.Equal(t, 4, ruleSpan(signature(`content:"/etc";`)))
+	assert.Equal(t, 4+2+10, ruleSpan(signature(`content:"/etc"; content:"passwd"; distance:2; within:10;`)))
+	assert.Equal(t, -1, ruleSpan(signature(`content:"/etc"; content:"passwd";`)), "兩個獨立的 content")
+	assert.Equal(t, -1, ruleSpan(signature(`content:"GET"; depth:3;`)), "絕對位置")
+	assert.Equal(t, -1, ruleSpan(signature(`content:"/etc"; content:!"passwd";`)))
+
+	pf := newRulePrefilter([]*SecurityRule{pattern("union"), pattern("regex:(?i)(select|drop)")})
+	assert.Equal(t, 6, pf.streamOverlap())
+	pf = newRulePrefilter([]*SecurityRule{pattern("union"), pattern("regex:a+")})
+	assert.Equal(t, -1, pf.streamOverlap())
+}
+
+func TestPrefilterKeepsMatchSemantics(t *testing.T) {
+	engine := newRuleBenchEngine(t, 300)
+
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"GET", "/index.html", "union", "SeLeCt", "sig_17_token", "attack_42  payload",
+		"hack", "Kill", "<script>", "drop", "ATTACK_299\tPAYLOAD", "sig_299_TOKEN", "exec"}
+
+	for i := 0; i < 2000; i++ {
+		var parts []string
+		for j := 0; j < 1+rng.Intn(5); j++ {
+			parts = append(parts, words[rng.Intn(len(words))])
+		}
+		packet := &NetworkPacket{
+			SourceIP:      "203.0.113.9",
+			DestIP:        "172.16.0.1",
+			SourcePort:    40000,
+			DestPort:      8080,
+			Protocol:      "TCP",
+			PayloadString: strings.Join(parts, " "),
+		}
+
+		candidates := engine.prefilter.candidates(packet)
+		for idx, rule := range engine.rules {
+			if engine.matchRule(rule, packet) {
+				require.True(t, isCandidate(candidates, idx), "規則 %s 命中但未被列為候選: %q", rule.ID, packet.PayloadString)
+			}
+		}
+	}
+}
+
+func TestPrefilterUnicodeFolding(t *testing.T) {
+	engine := newRuleBenchEngine(t, 0)
+	require.NoError(t, engine.AddRule(&SecurityRule{
+		ID: "kill", Name: "kill", Type: "pattern", Pattern: "regex:(?i)kill", Action: "alert", Severity: "low", Enabled: true,
+	}))
+
+	rule := engine.rules[len(engine.rules)-1]
+	packet := &NetworkPacket{PayloadString: "Kill -9"}
+	require.True(t, engine.matchRule(rule, packet))
+	assert.True(t, isCandidate(engine.prefilter.candidates(packet), len(engine.rules)-1))
+}
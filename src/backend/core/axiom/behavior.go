@@ -0,0 +1,372 @@
+package axiom
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBehaviorWindow 行為偵測視窗上限，也是狀態保留的最長時間
+const maxBehaviorWindow = 10 * time.Minute
+
+// maxTrackedTargets 每個來源追蹤的目標數上限，超過時已遠高於任何合理門檻
+const maxTrackedTargets = 4096
+
+// maxAuthFailures 每個 (來源, 服務) 保留的認證失敗記錄上限
+const maxAuthFailures = 1024
+
+// BehaviorThresholds 行為規則門檻值，零值欄位使用該偵測器的預設值
+type BehaviorThresholds struct {
+	WindowSeconds    int     `json:"window_seconds,omitempty"`
+	DistinctPorts    int     `json:"distinct_ports,omitempty"`     // port_scan: 視窗內不同目的連接埠數
+	DistinctHosts    int     `json:"distinct_hosts,omitempty"`     // port_scan: 視窗內不同目的主機數
+	FailedAttempts   int     `json:"failed_attempts,omitempty"`    // brute_force: 視窗內同一服務的認證失敗次數
+	PacketsPerSecond float64 `json:"packets_per_second,omitempty"` // ddos: 目的主機每秒封包數
+	SynAckRatio      float64 `json:"syn_ack_ratio,omitempty"`      // ddos: SYN 與 ACK 的比例
+	MinSynPackets    int     `json:"min_syn_packets,omitempty"`    // ddos: 計算 SYN/ACK 比例所需的最少 SYN 數
+}
+
+// defaultBehaviorThresholds 各偵測器的預設門檻
+var defaultBehaviorThresholds = map[string]BehaviorThresholds{
+	"port_scan":   {WindowSeconds: 60, DistinctPorts: 20, DistinctHosts: 20},
+	"brute_force": {WindowSeconds: 300, FailedAttempts: 5},
+	"ddos":        {WindowSeconds: 10, PacketsPerSecond: 1000, SynAckRatio: 3, MinSynPackets: 100},
+}
+
+// resolve 合併規則門檻與預設值
+func (t *BehaviorThresholds) resolve(pattern string) BehaviorThresholds {
+	resolved := defaultBehaviorThresholds[pattern]
+	if t == nil {
+		return resolved
+	}
+	if t.WindowSeconds > 0 {
+		resolved.WindowSeconds = t.WindowSeconds
+	}
+	if t.DistinctPorts > 0 {
+		resolved.DistinctPorts = t.DistinctPorts
+	}
+	if t.DistinctHosts > 0 {
+		resolved.DistinctHosts = t.DistinctHosts
+	}
+	if t.FailedAttempts > 0 {
+		resolved.FailedAttempts = t.FailedAttempts
+	}
+	if t.PacketsPerSecond > 0 {
+		resolved.PacketsPerSecond = t.PacketsPerSecond
+	}
+	if t.SynAckRatio > 0 {
+		resolved.SynAckRatio = t.SynAckRatio
+	}
+	if t.MinSynPackets > 0 {
+		resolved.MinSynPackets = t.MinSynPackets
+	}
+	return resolved
+}
+
+// window 視窗長度 (不超過 maxBehaviorWindow)
+func (t BehaviorThresholds) window() time.Duration {
+	window := time.Duration(t.WindowSeconds) * time.Second
+	if window <= 0 || window > maxBehaviorWindow {
+		return maxBehaviorWindow
+	}
+	return window
+}
+
+// serviceByPort 需要追蹤認證失敗的服務
+var serviceByPort = map[int]string{
+	21:   "ftp",
+	22:   "ssh",
+	23:   "telnet",
+	25:   "smtp",
+	80:   "http",
+	110:  "pop3",
+	143:  "imap",
+	443:  "https",
+	587:  "smtp",
+	3306: "mysql",
+	3389: "rdp",
+	5432: "postgresql",
+	8080: "http",
+}
+
+// authFailurePatterns 伺服器回應中代表認證失敗的特徵；SSH、RDP 等加密服務無法從內容判斷，
+// 需由 auth_result 標頭或 RecordAuthFailure 回報
+var authFailurePatterns = map[string]*regexp.Regexp{
+	"ftp":        regexp.MustCompile(`^530[ -]`),
+	"telnet":     regexp.MustCompile(`(?i)login incorrect`),
+	"smtp":       regexp.MustCompile(`^535[ -]`),
+	"http":       regexp.MustCompile(`^HTTP/\d(\.\d)? 40[13] `),
+	"pop3":       regexp.MustCompile(`^-ERR`),
+	"imap":       regexp.MustCompile(`^\S+ NO `),
+	"mysql":      regexp.MustCompile(`Access denied for user`),
+	"postgresql": regexp.MustCompile(`password authentication failed`),
+}
+
+// behaviorTracker 以滑動視窗追蹤來源與目的主機的行為
+type behaviorTracker struct {
+	now          func() time.Time
+	sources      map[string]*sourceActivity
+	auth         map[authKey][]time.Time
+	destinations map[string]*destinationActivity
+	mutex        sync.Mutex
+}
+
+// sourceActivity 來源主機最近連線的目的連接埠與主機
+type sourceActivity struct {
+	ports    map[int]time.Time
+	hosts    map[string]time.Time
+	lastSeen time.Time
+}
+
+// authKey 認證失敗計數鍵
+type authKey struct {
+	source  string
+	service string
+}
+
+// destinationActivity 目的主機每秒流量
+type destinationActivity struct {
+	buckets  map[int64]*trafficBucket
+	lastSeen time.Time
+}
+
+// trafficBucket 一秒內的封包統計
+type trafficBucket struct {
+	packets int
+	syn     int
+	ack     int
+}
+
+// newBehaviorTracker 建立行為追蹤器
+func newBehaviorTracker(now func() time.Time) *behaviorTracker {
+	if now == nil {
+		now = time.Now
+	}
+	return &behaviorTracker{
+		now:          now,
+		sources:      make(map[string]*sourceActivity),
+		auth:         make(map[authKey][]time.Time),
+		destinations: make(map[string]*destinationActivity),
+	}
+}
+
+// setClock 替換時間來源 (測試與離線重播使用)
+func (bt *behaviorTracker) setClock(now func() time.Time) {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	if now == nil {
+		now = time.Now
+	}
+	bt.now = now
+}
+
+// clock 取得目前時間
+func (bt *behaviorTracker) clock() time.Time {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	return bt.now()
+}
+
+// observe 以封包更新行為狀態
+func (bt *behaviorTracker) observe(packet *NetworkPacket) {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	now := bt.now()
+	flags := strings.ToUpper(packet.TCPFlags)
+	syn := strings.Contains(flags, "S") && !strings.Contains(flags, "A")
+	ack := strings.Contains(flags, "A") && !strings.Contains(flags, "S")
+
+	// 連線嘗試：TCP 只計算 SYN；沒有旗標資訊時每個封包都視為嘗試
+	attempt := flags == "" || syn
+	if attempt {
+		source, exists := bt.sources[packet.SourceIP]
+		if !exists {
+			source = &sourceActivity{
+				ports: make(map[int]time.Time),
+				hosts: make(map[string]time.Time),
+			}
+			bt.sources[packet.SourceIP] = source
+		}
+		source.lastSeen = now
+		if _, tracked := source.ports[packet.DestPort]; tracked || len(source.ports) < maxTrackedTargets {
+			source.ports[packet.DestPort] = now
+		}
+		if _, tracked := source.hosts[packet.DestIP]; tracked || len(source.hosts) < maxTrackedTargets {
+			source.hosts[packet.DestIP] = now
+		}
+	}
+
+	// 目的主機流量
+	dest, exists := bt.destinations[packet.DestIP]
+	if !exists {
+		dest = &destinationActivity{buckets: make(map[int64]*trafficBucket)}
+		bt.destinations[packet.DestIP] = dest
+	}
+	dest.lastSeen = now
+	second := now.Unix()
+	bucket, exists := dest.buckets[second]
+	if !exists {
+		bucket = &trafficBucket{}
+		dest.buckets[second] = bucket
+		if len(dest.buckets) > int(maxBehaviorWindow/time.Second) {
+			pruneBuckets(dest.buckets, now.Add(-maxBehaviorWindow).Unix())
+		}
+	}
+	bucket.packets++
+	if syn {
+		bucket.syn++
+	}
+	if ack {
+		bucket.ack++
+	}
+
+	// 認證結果
+	if packet.Headers != nil {
+		if result := strings.ToLower(packet.Headers["auth_result"]); result == "failure" || result == "failed" {
+			if service, ok := serviceByPort[packet.DestPort]; ok {
+				bt.recordAuthFailure(packet.SourceIP, service, now)
+			}
+		}
+	}
+	if service, ok := serviceByPort[packet.SourcePort]; ok {
+		// 伺服器回應：失敗歸屬於目的端 (用戶端)
+		if pattern, ok := authFailurePatterns[service]; ok && pattern.Match(packetPayload(packet)) {
+			bt.recordAuthFailure(packet.DestIP, service, now)
+		}
+	}
+}
+
+// recordAuthFailure 記錄認證失敗 (呼叫者需持有鎖)
+func (bt *behaviorTracker) recordAuthFailure(source, service string, now time.Time) {
+	key := authKey{source: source, service: service}
+	failures := append(bt.auth[key], now)
+	if len(failures) > maxAuthFailures {
+		failures = failures[len(failures)-maxAuthFailures:]
+	}
+	bt.auth[key] = failures
+}
+
+// distinctTargets 視窗內來源連線的不同目的連接埠與主機數
+func (bt *behaviorTracker) distinctTargets(sourceIP string, window time.Duration) (int, int) {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	source, exists := bt.sources[sourceIP]
+	if !exists {
+		return 0, 0
+	}
+
+	since := bt.now().Add(-window)
+	ports, hosts := 0, 0
+	for _, seen := range source.ports {
+		if !seen.Before(since) {
+			ports++
+		}
+	}
+	for _, seen := range source.hosts {
+		if !seen.Before(since) {
+			hosts++
+		}
+	}
+	return ports, hosts
+}
+
+// failedAuth 視窗內來源對服務的認證失敗次數
+func (bt *behaviorTracker) failedAuth(sourceIP, service string, window time.Duration) int {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	since := bt.now().Add(-window)
+	count := 0
+	for _, at := range bt.auth[authKey{source: sourceIP, service: service}] {
+		if !at.Before(since) {
+			count++
+		}
+	}
+	return count
+}
+
+// traffic 視窗內目的主機的每秒封包數與 SYN/ACK 數
+func (bt *behaviorTracker) traffic(destIP string, window time.Duration) (float64, int, int) {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	dest, exists := bt.destinations[destIP]
+	if !exists {
+		return 0, 0, 0
+	}
+
+	now := bt.now().Unix()
+	seconds := int64(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	packets, syn, ack := 0, 0, 0
+	for second, bucket := range dest.buckets {
+		if second > now-seconds && second <= now {
+			packets += bucket.packets
+			syn += bucket.syn
+			ack += bucket.ack
+		}
+	}
+	return float64(packets) / float64(seconds), syn, ack
+}
+
+// cleanup 移除超過最長視窗的狀態
+func (bt *behaviorTracker) cleanup() {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	since := bt.now().Add(-maxBehaviorWindow)
+	for ip, source := range bt.sources {
+		if source.lastSeen.Before(since) {
+			delete(bt.sources, ip)
+			continue
+		}
+		for port, seen := range source.ports {
+			if seen.Before(since) {
+				delete(source.ports, port)
+			}
+		}
+		for host, seen := range source.hosts {
+			if seen.Before(since) {
+				delete(source.hosts, host)
+			}
+		}
+	}
+
+	for key, failures := range bt.auth {
+		kept := failures[:0]
+		for _, at := range failures {
+			if !at.Before(since) {
+				kept = append(kept, at)
+			}
+		}
+		if len(kept) == 0 {
+			delete(bt.auth, key)
+		} else {
+			bt.auth[key] = kept
+		}
+	}
+
+	for ip, dest := range bt.destinations {
+		if dest.lastSeen.Before(since) {
+			delete(bt.destinations, ip)
+			continue
+		}
+		pruneBuckets(dest.buckets, since.Unix())
+	}
+}
+
+// pruneBuckets 移除早於 oldest 的每秒統計
+func pruneBuckets(buckets map[int64]*trafficBucket, oldest int64) {
+	for second := range buckets {
+		if second < oldest {
+			delete(buckets, second)
+		}
+	}
+}
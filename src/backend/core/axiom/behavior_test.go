@@ -0,0 +1,246 @@
+package axiom
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock 可手動推進的測試時鐘
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newBehaviorEngine(t *testing.T, rules ...*SecurityRule) (*AnalysisEngine, *fakeClock) {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	engine := NewAnalysisEngine(logger, metrics.NewPrometheusMetrics(logger))
+	for _, rule := range engine.GetRules() {
+		require.NoError(t, engine.RemoveRule(rule.ID))
+	}
+	for _, rule := range rules {
+		require.NoError(t, engine.AddRule(rule))
+	}
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	engine.SetClock(clock.Now)
+
+	engine.mutex.Lock()
+	engine.running = true
+	engine.mutex.Unlock()
+
+	return engine, clock
+}
+
+func behaviorRule(id, pattern string, thresholds *BehaviorThresholds) *SecurityRule {
+	return &SecurityRule{
+		ID:       id,
+		Name:     id,
+		Type:     "behavior",
+		Pattern:  pattern,
+		Action:   "alert",
+		Severity: "medium",
+		Enabled:  true,
+		Behavior: thresholds,
+	}
+}
+
+func analyze(t *testing.T, engine *AnalysisEngine, packet *NetworkPacket) *AnalysisResult {
+	t.Helper()
+
+	result, err := engine.AnalyzePacket(packet)
+	require.NoError(t, err)
+	require.NoError(t, engine.FlushEvents(context.Background()))
+	return result
+}
+
+func TestDetectPortScan(t *testing.T) {
+	engine, clock := newBehaviorEngine(t, behaviorRule("scan", "port_scan", &BehaviorThresholds{
+		WindowSeconds: 60,
+		DistinctPorts: 10,
+		DistinctHosts: 5,
+	}))
+
+	probe := func(dest string, port int) *AnalysisResult {
+		return analyze(t, engine, &NetworkPacket{
+			SourceIP: "198.51.100.1", DestIP: dest, SourcePort: 50000, DestPort: port,
+			Protocol: "TCP", TCPFlags: "S",
+		})
+	}
+
+	for port := 1; port < 10; port++ {
+		assert.Equal(t, "allow", probe("172.16.0.1", port).Action)
+		clock.Advance(5 * time.Second)
+	}
+
+	// 同一連接埠的後續封包 (非 SYN) 不增加計數
+	result := analyze(t, engine, &NetworkPacket{
+		SourceIP: "198.51.100.1", DestIP: "172.16.0.1", SourcePort: 50000, DestPort: 9999,
+		Protocol: "TCP", TCPFlags: "A",
+	})
+	assert.Equal(t, "allow", result.Action)
+
+	assert.Equal(t, "scan", probe("172.16.0.1", 10).RuleID)
+
+	// 視窗滑動後最早的連接埠離開視窗
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, "allow", probe("172.16.0.1", 11).Action)
+
+	t.Run("主機掃描", func(t *testing.T) {
+		for i := 1; i < 5; i++ {
+			result := analyze(t, engine, &NetworkPacket{
+				SourceIP: "198.51.100.2", DestIP: fmt.Sprintf("172.16.1.%d", i), DestPort: 445, Protocol: "TCP", TCPFlags: "S",
+			})
+			assert.Equal(t, "allow", result.Action)
+		}
+		result := analyze(t, engine, &NetworkPacket{
+			SourceIP: "198.51.100.2", DestIP: "172.16.1.5", DestPort: 445, Protocol: "TCP", TCPFlags: "S",
+		})
+		assert.Equal(t, "scan", result.RuleID)
+	})
+}
+
+func TestDetectBruteForce(t *testing.T) {
+	engine, clock := newBehaviorEngine(t, behaviorRule("brute", "brute_force", &BehaviorThresholds{
+		WindowSeconds:  120,
+		FailedAttempts: 3,
+	}))
+
+	attacker := "203.0.113.50"
+	login := func(port int) *AnalysisResult {
+		return analyze(t, engine, &NetworkPacket{
+			SourceIP: attacker, DestIP: "172.16.0.5", SourcePort: 41000, DestPort: port,
+			Protocol: "TCP", TCPFlags: "PA", PayloadString: "USER admin\r\n",
+		})
+	}
+	reject := func(port int, response string) {
+		analyze(t, engine, &NetworkPacket{
+			SourceIP: "172.16.0.5", DestIP: attacker, SourcePort: port, DestPort: 41000,
+			Protocol: "TCP", TCPFlags: "PA", PayloadString: response,
+		})
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "allow", login(21).Action)
+		reject(21, "530 Login incorrect.\r\n")
+		clock.Advance(30 * time.Second)
+	}
+	reject(21, "230 Login successful.\r\n")
+	assert.Equal(t, "allow", login(21).Action, "成功登入不計入失敗")
+
+	reject(21, "530 Login incorrect.\r\n")
+	assert.Equal(t, "brute", login(21).RuleID)
+
+	// 失敗次數依服務分開計算
+	assert.Equal(t, "allow", login(110).Action)
+
+	// 視窗過後重新計數
+	clock.Advance(2 * time.Minute)
+	assert.Equal(t, "allow", login(21).Action)
+
+	t.Run("加密服務的新連線不計入失敗", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			analyze(t, engine, &NetworkPacket{SourceIP: "203.0.113.51", DestIP: "172.16.0.6", DestPort: 22, Protocol: "TCP", TCPFlags: "S"})
+		}
+		result := analyze(t, engine, &NetworkPacket{SourceIP: "203.0.113.51", DestIP: "172.16.0.6", DestPort: 22, Protocol: "TCP", TCPFlags: "PA"})
+		assert.Equal(t, "allow", result.Action)
+	})
+
+	t.Run("外部回報", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			engine.RecordAuthFailure("203.0.113.52", "rdp")
+		}
+		result := analyze(t, engine, &NetworkPacket{SourceIP: "203.0.113.52", DestIP: "172.16.0.7", DestPort: 3389, Protocol: "TCP", TCPFlags: "PA"})
+		assert.Equal(t, "brute", result.RuleID)
+	})
+}
+
+func TestDetectDDoS(t *testing.T) {
+	t.Run("每秒封包數", func(t *testing.T) {
+		engine, clock := newBehaviorEngine(t, behaviorRule("ddos", "ddos", &BehaviorThresholds{
+			WindowSeconds:    5,
+			PacketsPerSecond: 20,
+		}))
+
+		var last *AnalysisResult
+		for second := 0; second < 5; second++ {
+			for i := 0; i < 19; i++ {
+				last = analyze(t, engine, &NetworkPacket{
+					SourceIP: fmt.Sprintf("198.51.100.%d", i), DestIP: "172.16.0.80", DestPort: 80, Protocol: "UDP",
+				})
+			}
+			clock.Advance(time.Second)
+		}
+		assert.Equal(t, "allow", last.Action, "每秒 19 個封包低於門檻")
+
+		clock.Advance(10 * time.Second)
+		for i := 0; i < 100; i++ {
+			last = analyze(t, engine, &NetworkPacket{SourceIP: "198.51.100.1", DestIP: "172.16.0.80", DestPort: 80, Protocol: "UDP"})
+		}
+		assert.Equal(t, "ddos", last.RuleID)
+	})
+
+	t.Run("SYN/ACK 比例", func(t *testing.T) {
+		engine, _ := newBehaviorEngine(t, behaviorRule("synflood", "ddos", &BehaviorThresholds{
+			WindowSeconds:    10,
+			PacketsPerSecond: 1000000,
+			SynAckRatio:      3,
+			MinSynPackets:    30,
+		}))
+
+		send := func(flags string, n int) *AnalysisResult {
+			var result *AnalysisResult
+			for i := 0; i < n; i++ {
+				result = analyze(t, engine, &NetworkPacket{
+					SourceIP: fmt.Sprintf("203.0.113.%d", i%200), DestIP: "172.16.0.44", DestPort: 443, Protocol: "TCP", TCPFlags: flags,
+				})
+			}
+			return result
+		}
+
+		send("A", 10)
+		assert.Equal(t, "allow", send("S", 29).Action, "SYN 數未達最低門檻")
+		assert.Equal(t, "synflood", send("S", 1).RuleID)
+	})
+}
+
+func TestBehaviorThresholdDefaults(t *testing.T) {
+	var empty *BehaviorThresholds
+	assert.Equal(t, defaultBehaviorThresholds["brute_force"], empty.resolve("brute_force"))
+
+	custom := (&BehaviorThresholds{DistinctPorts: 3}).resolve("port_scan")
+	assert.Equal(t, 3, custom.DistinctPorts)
+	assert.Equal(t, 20, custom.DistinctHosts)
+	assert.Equal(t, time.Minute, custom.window())
+
+	assert.Equal(t, maxBehaviorWindow, BehaviorThresholds{WindowSeconds: 86400}.window())
+}
+
+func TestBehaviorCleanup(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	tracker := newBehaviorTracker(clock.Now)
+
+	tracker.observe(&NetworkPacket{SourceIP: "198.51.100.1", DestIP: "172.16.0.1", DestPort: 21, Protocol: "TCP", TCPFlags: "S"})
+	tracker.observe(&NetworkPacket{SourceIP: "172.16.0.1", DestIP: "198.51.100.1", SourcePort: 21, Protocol: "TCP", TCPFlags: "PA", PayloadString: "530 Login incorrect."})
+
+	clock.Advance(maxBehaviorWindow + time.Second)
+	tracker.cleanup()
+
+	assert.Empty(t, tracker.sources)
+	assert.Empty(t, tracker.auth)
+	assert.Empty(t, tracker.destinations)
+}
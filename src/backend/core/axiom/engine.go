@@ -3,9 +3,11 @@ package axiom
 import (
 	"context"
 	"fmt"
+	"net/netip"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -17,9 +19,21 @@ type AnalysisEngine struct {
 	logger      *logrus.Logger
 	metrics     *metrics.PrometheusMetrics
 	rules       []*SecurityRule
-	blacklist   map[string]time.Time
-	whitelist   map[string]bool
+	blacklist   *IPSet[time.Time] // 前綴 -> 到期時間
+	whitelist   *IPSet[bool]
+	listMutex   sync.RWMutex // 保護黑白名單；AnalyzePacket 只持有 mutex 讀鎖時仍需寫入黑名單
 	threatCache map[string]*ThreatInfo
+	prefilter   *rulePrefilter
+	reassembler *StreamReassembler
+	behavior    *behaviorTracker
+	store       StateStore
+	writer      *stateWriter
+	ruleSet     ruleSetState
+	tuning      *ruleTuning
+	events      EventStore
+	eventWriter *eventWriter
+	listeners   resultListeners
+	packets     atomic.Uint64 // 已分析的封包數
 	mutex       sync.RWMutex
 	running     bool
 	stopChan    chan struct{}
@@ -27,17 +41,21 @@ type AnalysisEngine struct {
 
 // SecurityRule 安全規則
 type SecurityRule struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Type        string    `json:"type"` // "ip", "port", "pattern", "behavior"
-	Pattern     string    `json:"pattern"`
-	Action      string    `json:"action"`   // "block", "alert", "log"
-	Severity    string    `json:"severity"` // "low", "medium", "high", "critical"
-	Enabled     bool      `json:"enabled"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          string              `json:"id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Type        string              `json:"type"` // "ip", "port", "pattern", "behavior", "signature"
+	Pattern     string              `json:"pattern"`
+	Action      string              `json:"action"`   // "block", "alert", "log"
+	Severity    string              `json:"severity"` // "low", "medium", "high", "critical"
+	Enabled     bool                `json:"enabled"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+	Signature   *SnortSignature     `json:"signature,omitempty"` // Type 為 "signature" 時的 Snort/Suricata 規則
+	Behavior    *BehaviorThresholds `json:"behavior,omitempty"`  // Type 為 "behavior" 時的門檻值
+	Source      string              `json:"source,omitempty"`    // 規則來源: default, api, snort, directory
 	regex       *regexp.Regexp
+	ipSet       *IPSet[struct{}] // Type 為 "ip" 且 Pattern 為 IP/CIDR/範圍清單時使用
 }
 
 // ThreatInfo 威脅資訊
@@ -63,6 +81,10 @@ type NetworkPacket struct {
 	PayloadString string            `json:"payload_string"`
 	Timestamp     time.Time         `json:"timestamp"`
 	Headers       map[string]string `json:"headers"`
+	AgentID       string            `json:"agent_id,omitempty"`  // 上報封包的 Agent
+	TCPSeq        uint32            `json:"tcp_seq,omitempty"`   // TCP 序號，供串流重組使用
+	TCPFlags      string            `json:"tcp_flags,omitempty"` // TCP 旗標，例如 "S"、"SA"、"PA"、"FA"、"R"
+	conn          ConnState         // 重組器追蹤的連線狀態，供 Snort flow 選項使用
 }
 
 // AnalysisResult 分析結果
@@ -70,6 +92,9 @@ type AnalysisResult struct {
 	PacketID    string    `json:"packet_id"`
 	Timestamp   time.Time `json:"timestamp"`
 	SourceIP    string    `json:"source_ip"`
+	DestIP      string    `json:"dest_ip,omitempty"`
+	DestPort    int       `json:"dest_port,omitempty"`
+	AgentID     string    `json:"agent_id,omitempty"`
 	ThreatLevel string    `json:"threat_level"`
 	ThreatType  string    `json:"threat_type"`
 	Action      string    `json:"action"`
@@ -85,12 +110,19 @@ func NewAnalysisEngine(logger *logrus.Logger, metrics *metrics.PrometheusMetrics
 		logger:      logger,
 		metrics:     metrics,
 		rules:       make([]*SecurityRule, 0),
-		blacklist:   make(map[string]time.Time),
-		whitelist:   make(map[string]bool),
+		blacklist:   NewIPSet[time.Time](),
+		whitelist:   NewIPSet[bool](),
 		threatCache: make(map[string]*ThreatInfo),
+		behavior:    newBehaviorTracker(time.Now),
+		tuning:      newRuleTuning(),
+		events:      NewMemoryEventStore(defaultEventCapacity),
+		eventWriter: newEventWriter(logger),
 		stopChan:    make(chan struct{}),
 	}
 
+	// 預設設定必定有效
+	engine.reassembler, _ = NewStreamReassembler(logger, DefaultReassemblyConfig())
+
 	// 載入預設規則
 	engine.loadDefaultRules()
 
@@ -104,6 +136,12 @@ func (ae *AnalysisEngine) Start(ctx context.Context) error {
 		ae.mutex.Unlock()
 		return fmt.Errorf("分析引擎已在運行中")
 	}
+	if ae.store != nil {
+		if err := ae.restoreState(ctx); err != nil {
+			ae.mutex.Unlock()
+			return fmt.Errorf("恢復引擎狀態失敗: %v", err)
+		}
+	}
 	ae.running = true
 	ae.mutex.Unlock()
 
@@ -115,6 +153,9 @@ func (ae *AnalysisEngine) Start(ctx context.Context) error {
 	// 啟動黑名單清理
 	go ae.startBlacklistCleanup()
 
+	// 啟動TCP流清理
+	go ae.startFlowCleanup()
+
 	// 等待停止信號
 	select {
 	case <-ctx.Done():
@@ -128,17 +169,35 @@ func (ae *AnalysisEngine) Start(ctx context.Context) error {
 // Stop 停止分析引擎
 func (ae *AnalysisEngine) Stop() {
 	ae.mutex.Lock()
-	defer ae.mutex.Unlock()
-
 	if !ae.running {
+		ae.mutex.Unlock()
 		return
 	}
 
 	ae.running = false
 	close(ae.stopChan)
+	ae.mutex.Unlock()
+
+	// 寫入仍在佇列中的狀態變更
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	if err := ae.FlushState(ctx); err != nil {
+		ae.logger.Errorf("寫入引擎狀態失敗: %v", err)
+	}
+	if err := ae.FlushEvents(ctx); err != nil {
+		ae.logger.Errorf("寫入安全事件失敗: %v", err)
+	}
 	ae.logger.Info("Axiom分析引擎已停止")
 }
 
+// IsRunning 檢查分析引擎是否運行中
+func (ae *AnalysisEngine) IsRunning() bool {
+	ae.mutex.RLock()
+	defer ae.mutex.RUnlock()
+
+	return ae.running
+}
+
 // AnalyzePacket 分析網路封包
 func (ae *AnalysisEngine) AnalyzePacket(packet *NetworkPacket) (*AnalysisResult, error) {
 	ae.mutex.RLock()
@@ -147,6 +206,7 @@ func (ae *AnalysisEngine) AnalyzePacket(packet *NetworkPacket) (*AnalysisResult,
 	if !ae.running {
 		return nil, fmt.Errorf("分析引擎未運行")
 	}
+	ae.packets.Add(1)
 
 	// 記錄安全事件指標 (簡化版)
 	ae.metrics.RecordSecurityEvent("packet_analysis", "success")
@@ -155,19 +215,26 @@ func (ae *AnalysisEngine) AnalyzePacket(packet *NetworkPacket) (*AnalysisResult,
 		PacketID:    fmt.Sprintf("%d", time.Now().UnixNano()),
 		Timestamp:   packet.Timestamp,
 		SourceIP:    packet.SourceIP,
+		DestIP:      packet.DestIP,
+		DestPort:    packet.DestPort,
+		AgentID:     packet.AgentID,
 		ThreatLevel: "low",
 		Action:      "allow",
 		Blocked:     false,
 	}
 
-	// 檢查白名單
-	if ae.whitelist[packet.SourceIP] {
+	// 檢查白名單與黑名單 (最長前綴匹配，兩者皆匹配時以較精確的前綴為準)
+	whitelisted, blacklisted := ae.checkLists(packet.SourceIP)
+	if whitelisted {
 		result.Details = "IP在白名單中"
 		return result, nil
 	}
 
+	// 更新行為偵測的滑動視窗狀態
+	ae.behavior.observe(packet)
+
 	// 檢查黑名單
-	if _, blocked := ae.blacklist[packet.SourceIP]; blocked {
+	if blacklisted {
 		result.ThreatLevel = "high"
 		result.ThreatType = "blacklisted_ip"
 		result.Action = "block"
@@ -175,37 +242,94 @@ func (ae *AnalysisEngine) AnalyzePacket(packet *NetworkPacket) (*AnalysisResult,
 		result.Details = "IP在黑名單中"
 
 		ae.metrics.RecordSecurityEvent("blacklisted_ip", "blocked")
+		ae.listeners.notify(result)
 		return result, nil
 	}
 
+	// TCP 串流重組：先更新連線狀態，規則的 flow 選項依此判斷方向與連線是否建立
+	var stream *StreamData
+	if ae.reassembler != nil {
+		stream = ae.reassembler.Process(packet)
+		packet.conn = ae.reassembler.Conn(packet)
+	}
+
 	// 應用安全規則
-	for _, rule := range ae.rules {
-		if !rule.Enabled {
+	matched := ae.applyRules(packet, result, false)
+
+	// 跨片段的內容只有在重組後才能匹配
+	if stream != nil {
+		if !matched {
+			reassembled := stream.Packet(packet, ae.prefilter.streamOverlap())
+			if reassembled.PayloadString != string(packetPayload(packet)) {
+				matched = ae.applyRules(reassembled, result, true)
+			}
+		}
+		if matched {
+			ae.reassembler.Flush(stream.Flow, stream.Direction)
+		}
+	}
+
+	// 保留告警結果供分析師標記誤報
+	if matched {
+		ae.tuning.remember(result)
+		ae.recordEvent(packet, result)
+		ae.listeners.notify(result)
+	}
+
+	return result, nil
+}
+
+// applyRules 依序套用規則並在第一個匹配時填入結果；reassembled 為 true 時只比對內容類規則
+func (ae *AnalysisEngine) applyRules(packet *NetworkPacket, result *AnalysisResult, reassembled bool) bool {
+	// 以 Aho-Corasick 預先過濾，只對可能命中的規則執行完整比對
+	candidates := ae.prefilter.candidates(packet)
+
+	for i, rule := range ae.rules {
+		if !rule.Enabled || !isCandidate(candidates, i) {
+			continue
+		}
+		if reassembled && rule.Type != "pattern" && rule.Type != "signature" {
 			continue
 		}
 
 		if ae.matchRule(rule, packet) {
+			now := ae.behavior.clock()
+
+			// 誤報抑制：略過此規則，繼續比對其他規則
+			if ae.tuning.suppressed(rule.ID, packet, now) != nil {
+				ae.metrics.RecordRuleSuppressed(rule.ID)
+				continue
+			}
+
 			result.ThreatLevel = rule.Severity
 			result.ThreatType = rule.Type
+			if rule.Signature != nil && rule.Signature.Classtype != "" {
+				result.ThreatType = rule.Signature.Classtype
+			}
 			result.Action = rule.Action
 			result.RuleID = rule.ID
 			result.RuleName = rule.Name
 			result.Details = fmt.Sprintf("觸發規則: %s", rule.Description)
+			if reassembled {
+				result.Details += " (TCP串流重組)"
+			}
 
 			if rule.Action == "block" {
 				result.Blocked = true
 				ae.addToBlacklist(packet.SourceIP, time.Hour) // 暫時加入黑名單1小時
 			}
 
-			// 記錄威脅
+			// 記錄威脅與規則命中統計
 			ae.recordThreat(packet.SourceIP, rule.Type, rule.Severity, result.Details)
 			ae.metrics.RecordSecurityEvent(rule.Type, "detected")
+			ae.tuning.recordHit(rule.ID, result.Blocked, now)
+			ae.metrics.RecordRuleHit(rule.ID, result.Blocked, now)
 
-			break
+			return true
 		}
 	}
 
-	return result, nil
+	return false
 }
 
 // matchRule 檢查規則是否匹配
@@ -219,6 +343,8 @@ func (ae *AnalysisEngine) matchRule(rule *SecurityRule, packet *NetworkPacket) b
 		return ae.matchPatternRule(rule, packet.PayloadString)
 	case "behavior":
 		return ae.matchBehaviorRule(rule, packet)
+	case "signature":
+		return rule.Signature != nil && rule.Signature.Match(packet)
 	default:
 		return false
 	}
@@ -226,6 +352,10 @@ func (ae *AnalysisEngine) matchRule(rule *SecurityRule, packet *NetworkPacket) b
 
 // matchIPRule 匹配IP規則
 func (ae *AnalysisEngine) matchIPRule(rule *SecurityRule, ip string) bool {
+	if rule.ipSet != nil {
+		_, _, found := rule.ipSet.LookupString(ip)
+		return found
+	}
 	if rule.regex != nil {
 		return rule.regex.MatchString(ip)
 	}
@@ -251,74 +381,70 @@ func (ae *AnalysisEngine) matchPatternRule(rule *SecurityRule, payload string) b
 
 // matchBehaviorRule 匹配行為規則
 func (ae *AnalysisEngine) matchBehaviorRule(rule *SecurityRule, packet *NetworkPacket) bool {
-	// 實現行為分析邏輯
+	thresholds := rule.Behavior.resolve(rule.Pattern)
+
 	switch rule.Pattern {
 	case "port_scan":
-		return ae.detectPortScan(packet.SourceIP)
+		return ae.detectPortScan(packet.SourceIP, thresholds)
 	case "brute_force":
-		return ae.detectBruteForce(packet)
+		return ae.detectBruteForce(packet, thresholds)
 	case "ddos":
-		return ae.detectDDoS(packet.SourceIP)
+		return ae.detectDDoS(packet.DestIP, thresholds)
 	default:
 		return false
 	}
 }
 
-// detectPortScan 偵測連接埠掃描
-func (ae *AnalysisEngine) detectPortScan(sourceIP string) bool {
-	// 簡化的連接埠掃描偵測邏輯
-	threat, exists := ae.threatCache[sourceIP]
-	if !exists {
+// detectPortScan 偵測連接埠掃描 (視窗內連線的不同目的連接埠或主機過多)
+func (ae *AnalysisEngine) detectPortScan(sourceIP string, thresholds BehaviorThresholds) bool {
+	ports, hosts := ae.behavior.distinctTargets(sourceIP, thresholds.window())
+	return ports >= thresholds.DistinctPorts || hosts >= thresholds.DistinctHosts
+}
+
+// detectBruteForce 偵測暴力破解 (視窗內同一服務的認證失敗過多)
+func (ae *AnalysisEngine) detectBruteForce(packet *NetworkPacket, thresholds BehaviorThresholds) bool {
+	service, ok := serviceByPort[packet.DestPort]
+	if !ok {
 		return false
 	}
+	return ae.behavior.failedAuth(packet.SourceIP, service, thresholds.window()) >= thresholds.FailedAttempts
+}
 
-	// 如果在短時間內連接多個不同連接埠，可能是掃描
-	if time.Since(threat.LastSeen) < 1*time.Minute && threat.Count > 10 {
+// detectDDoS 偵測DDoS攻擊 (目的主機每秒封包數過高或 SYN/ACK 比例失衡)
+func (ae *AnalysisEngine) detectDDoS(destIP string, thresholds BehaviorThresholds) bool {
+	pps, syn, ack := ae.behavior.traffic(destIP, thresholds.window())
+	if pps >= thresholds.PacketsPerSecond {
 		return true
 	}
-
-	return false
-}
-
-// detectBruteForce 偵測暴力破解
-func (ae *AnalysisEngine) detectBruteForce(packet *NetworkPacket) bool {
-	// 檢查是否為SSH、FTP、HTTP認證等常見服務
-	commonPorts := []int{22, 21, 80, 443, 3389}
-	for _, port := range commonPorts {
-		if packet.DestPort == port {
-			threat, exists := ae.threatCache[packet.SourceIP]
-			if exists && threat.Count > 5 && time.Since(threat.FirstSeen) < 5*time.Minute {
-				return true
-			}
-		}
+	if ack == 0 {
+		ack = 1
 	}
-	return false
+	return syn >= thresholds.MinSynPackets && float64(syn)/float64(ack) >= thresholds.SynAckRatio
 }
 
-// detectDDoS 偵測DDoS攻擊
-func (ae *AnalysisEngine) detectDDoS(sourceIP string) bool {
-	threat, exists := ae.threatCache[sourceIP]
-	if !exists {
-		return false
-	}
+// RecordAuthFailure 記錄外部來源 (例如 SSH 日誌) 回報的認證失敗
+func (ae *AnalysisEngine) RecordAuthFailure(sourceIP, service string) {
+	ae.behavior.mutex.Lock()
+	defer ae.behavior.mutex.Unlock()
 
-	// 如果在短時間內有大量連接，可能是DDoS
-	if time.Since(threat.FirstSeen) < 1*time.Minute && threat.Count > 100 {
-		return true
-	}
+	ae.behavior.recordAuthFailure(sourceIP, service, ae.behavior.now())
+}
 
-	return false
+// SetClock 設定行為偵測、規則統計與黑名單、威脅快取、誤報抑制到期判斷使用的時間來源 (nil 表示使用系統時間)
+func (ae *AnalysisEngine) SetClock(now func() time.Time) {
+	ae.behavior.setClock(now)
 }
 
 // recordThreat 記錄威脅資訊
 func (ae *AnalysisEngine) recordThreat(sourceIP, threatType, severity, details string) {
+	now := ae.behavior.clock()
 	threat, exists := ae.threatCache[sourceIP]
 	if !exists {
 		threat = &ThreatInfo{
 			SourceIP:   sourceIP,
 			ThreatType: threatType,
 			Severity:   severity,
-			FirstSeen:  time.Now(),
+			FirstSeen:  now,
 			Count:      1,
 			Details:    make(map[string]interface{}),
 		}
@@ -327,7 +453,7 @@ func (ae *AnalysisEngine) recordThreat(sourceIP, threatType, severity, details s
 		threat.Count++
 	}
 
-	threat.LastSeen = time.Now()
+	threat.LastSeen = now
 	threat.Details["last_details"] = details
 }
 
@@ -336,23 +462,69 @@ func (ae *AnalysisEngine) AddRule(rule *SecurityRule) error {
 	ae.mutex.Lock()
 	defer ae.mutex.Unlock()
 
-	// 編譯正則表達式
+	// 編譯正則表達式與 IP 前綴
+	if err := compileRule(rule); err != nil {
+		return err
+	}
+
+	ae.prepareRule(rule)
+	if rule.Source == "" {
+		rule.Source = "api"
+	}
+	ae.rules = append(ae.rules, rule)
+	ae.rulesChanged("api")
+	// 背景寫入時規則可能已被修改，保存加入當下的副本
+	saved := *rule
+	ae.persist("規則", func(ctx context.Context, store StateStore) error {
+		return store.SaveRule(ctx, &saved)
+	})
+
+	ae.logger.Infof("已添加安全規則: %s", rule.Name)
+	return nil
+}
+
+// compileRule 編譯 "regex:" 前綴的規則模式；其餘 "ip" 規則的 Pattern 必須為逗號分隔的
+// IP、CIDR 或範圍清單並建立前綴集合，避免退回子字串比對 (10.0.0.1 會匹配 10.0.0.10)
+func compileRule(rule *SecurityRule) error {
 	if strings.HasPrefix(rule.Pattern, "regex:") {
 		regex, err := regexp.Compile(strings.TrimPrefix(rule.Pattern, "regex:"))
 		if err != nil {
 			return fmt.Errorf("編譯正則表達式失敗: %v", err)
 		}
 		rule.regex = regex
+		return nil
 	}
 
-	rule.CreatedAt = time.Now()
-	rule.UpdatedAt = time.Now()
-	ae.rules = append(ae.rules, rule)
-
-	ae.logger.Infof("已添加安全規則: %s", rule.Name)
+	if rule.Type == "ip" {
+		set := NewIPSet[struct{}]()
+		for _, item := range strings.Split(rule.Pattern, ",") {
+			prefixes, err := ParseIPPrefixes(item)
+			if err != nil {
+				return fmt.Errorf("無效的 IP 規則 %q: %w", item, err)
+			}
+			for _, prefix := range prefixes {
+				set.Insert(prefix, struct{}{})
+			}
+		}
+		rule.ipSet = set
+	}
 	return nil
 }
 
+// prepareRule 設定規則時間戳記
+func (ae *AnalysisEngine) prepareRule(rule *SecurityRule) {
+	now := time.Now()
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = now
+	}
+	rule.UpdatedAt = now
+}
+
+// rebuildPrefilter 規則變更後重建預先過濾器 (呼叫者需持有寫鎖)
+func (ae *AnalysisEngine) rebuildPrefilter() {
+	ae.prefilter = newRulePrefilter(ae.rules)
+}
+
 // RemoveRule 移除安全規則
 func (ae *AnalysisEngine) RemoveRule(ruleID string) error {
 	ae.mutex.Lock()
@@ -361,6 +533,11 @@ func (ae *AnalysisEngine) RemoveRule(ruleID string) error {
 	for i, rule := range ae.rules {
 		if rule.ID == ruleID {
 			ae.rules = append(ae.rules[:i], ae.rules[i+1:]...)
+			ae.rulesChanged("api")
+			ae.metrics.DeleteRuleMetrics(ruleID)
+			ae.persist("規則", func(ctx context.Context, store StateStore) error {
+				return store.DeleteRule(ctx, ruleID)
+			})
 			ae.logger.Infof("已移除安全規則: %s", rule.Name)
 			return nil
 		}
@@ -369,28 +546,164 @@ func (ae *AnalysisEngine) RemoveRule(ruleID string) error {
 	return fmt.Errorf("找不到規則ID: %s", ruleID)
 }
 
+// checkLists 查詢來源 IP 是否在白名單或黑名單中
+func (ae *AnalysisEngine) checkLists(ip string) (bool, bool) {
+	ae.listMutex.RLock()
+	defer ae.listMutex.RUnlock()
+
+	whitePrefix, _, whitelisted := ae.whitelist.LookupString(ip)
+	// 只比對未過期的黑名單項目，過期的較精確前綴不會遮蔽仍有效的較大網段
+	var blackPrefix netip.Prefix
+	blacklisted := false
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		blackPrefix, _, blacklisted = ae.blacklist.LookupFunc(addr, ae.behavior.clock().Before)
+	}
+
+	if whitelisted && blacklisted && blackPrefix.Bits() > whitePrefix.Bits() {
+		whitelisted = false
+	}
+	return whitelisted, blacklisted && !whitelisted
+}
+
 // addToBlacklist 添加到黑名單
 func (ae *AnalysisEngine) addToBlacklist(ip string, duration time.Duration) {
-	ae.blacklist[ip] = time.Now().Add(duration)
-	ae.logger.Warnf("已將IP %s 添加到黑名單，持續時間: %v", ip, duration)
+	if err := ae.AddToBlacklist(ip, duration); err != nil {
+		ae.logger.Errorf("添加黑名單失敗: %v", err)
+	}
 }
 
-// AddToWhitelist 添加到白名單
-func (ae *AnalysisEngine) AddToWhitelist(ip string) {
-	ae.mutex.Lock()
-	defer ae.mutex.Unlock()
+// AddToBlacklist 將 IP、CIDR 或 IP 範圍添加到黑名單
+func (ae *AnalysisEngine) AddToBlacklist(value string, duration time.Duration) error {
+	prefixes, err := ParseIPPrefixes(value)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := ae.behavior.clock().Add(duration)
+	ae.listMutex.Lock()
+	for _, prefix := range prefixes {
+		ae.blacklist.Insert(prefix, expiresAt)
+	}
+	ae.listMutex.Unlock()
+
+	for _, prefix := range prefixes {
+		key := formatPrefix(prefix)
+		ae.persist("黑名單", func(ctx context.Context, store StateStore) error {
+			return store.SaveBlacklistEntry(ctx, key, expiresAt)
+		})
+	}
+	ae.logger.Warnf("已將IP %s 添加到黑名單，持續時間: %v", value, duration)
+	return nil
+}
+
+// RemoveFromBlacklist 從黑名單移除 IP、CIDR 或 IP 範圍 (需與添加時的前綴完全相同)
+func (ae *AnalysisEngine) RemoveFromBlacklist(value string) error {
+	prefixes, err := ParseIPPrefixes(value)
+	if err != nil {
+		return err
+	}
+
+	removed := make([]netip.Prefix, 0, len(prefixes))
+	ae.listMutex.Lock()
+	for _, prefix := range prefixes {
+		if ae.blacklist.Remove(prefix) {
+			removed = append(removed, prefix)
+		}
+	}
+	ae.listMutex.Unlock()
+
+	if len(removed) == 0 {
+		return fmt.Errorf("黑名單中找不到: %s", value)
+	}
+	for _, prefix := range removed {
+		key := formatPrefix(prefix)
+		ae.persist("黑名單", func(ctx context.Context, store StateStore) error {
+			return store.DeleteBlacklistEntry(ctx, key)
+		})
+	}
+	ae.logger.Infof("已將IP %s 從黑名單移除", value)
+	return nil
+}
 
-	ae.whitelist[ip] = true
+// BlacklistEntry 黑名單項目
+type BlacklistEntry struct {
+	IP        string    `json:"ip"` // 單一 IP 或 CIDR
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GetBlacklist 取得尚未過期的黑名單項目
+func (ae *AnalysisEngine) GetBlacklist() []BlacklistEntry {
+	ae.listMutex.RLock()
+	defer ae.listMutex.RUnlock()
+
+	now := ae.behavior.clock()
+	entries := make([]BlacklistEntry, 0, ae.blacklist.Len())
+	ae.blacklist.Walk(func(prefix netip.Prefix, expiresAt time.Time) bool {
+		if now.Before(expiresAt) {
+			entries = append(entries, BlacklistEntry{IP: formatPrefix(prefix), ExpiresAt: expiresAt})
+		}
+		return true
+	})
+	return entries
+}
+
+// AddToWhitelist 將 IP、CIDR 或 IP 範圍添加到白名單
+func (ae *AnalysisEngine) AddToWhitelist(ip string) error {
+	prefixes, err := ParseIPPrefixes(ip)
+	if err != nil {
+		return err
+	}
+
+	ae.listMutex.Lock()
+	for _, prefix := range prefixes {
+		ae.whitelist.Insert(prefix, true)
+	}
+	ae.listMutex.Unlock()
+
+	for _, prefix := range prefixes {
+		key := formatPrefix(prefix)
+		ae.persist("白名單", func(ctx context.Context, store StateStore) error {
+			return store.SaveWhitelistEntry(ctx, key)
+		})
+	}
 	ae.logger.Infof("已將IP %s 添加到白名單", ip)
+	return nil
 }
 
-// RemoveFromWhitelist 從白名單移除
-func (ae *AnalysisEngine) RemoveFromWhitelist(ip string) {
-	ae.mutex.Lock()
-	defer ae.mutex.Unlock()
+// RemoveFromWhitelist 從白名單移除 IP、CIDR 或 IP 範圍
+func (ae *AnalysisEngine) RemoveFromWhitelist(ip string) error {
+	prefixes, err := ParseIPPrefixes(ip)
+	if err != nil {
+		return err
+	}
 
-	delete(ae.whitelist, ip)
+	ae.listMutex.Lock()
+	for _, prefix := range prefixes {
+		ae.whitelist.Remove(prefix)
+	}
+	ae.listMutex.Unlock()
+
+	for _, prefix := range prefixes {
+		key := formatPrefix(prefix)
+		ae.persist("白名單", func(ctx context.Context, store StateStore) error {
+			return store.DeleteWhitelistEntry(ctx, key)
+		})
+	}
 	ae.logger.Infof("已將IP %s 從白名單移除", ip)
+	return nil
+}
+
+// GetWhitelist 取得白名單項目
+func (ae *AnalysisEngine) GetWhitelist() []string {
+	ae.listMutex.RLock()
+	defer ae.listMutex.RUnlock()
+
+	entries := make([]string, 0, ae.whitelist.Len())
+	ae.whitelist.Walk(func(prefix netip.Prefix, _ bool) bool {
+		entries = append(entries, formatPrefix(prefix))
+		return true
+	})
+	return entries
 }
 
 // GetThreatInfo 取得威脅資訊
@@ -428,6 +741,7 @@ func (ae *AnalysisEngine) startThreatCacheCleanup() {
 		select {
 		case <-ticker.C:
 			ae.cleanupThreatCache()
+			ae.cleanupSuppressions()
 		case <-ae.stopChan:
 			return
 		}
@@ -439,7 +753,7 @@ func (ae *AnalysisEngine) cleanupThreatCache() {
 	ae.mutex.Lock()
 	defer ae.mutex.Unlock()
 
-	now := time.Now()
+	now := ae.behavior.clock()
 	for ip, threat := range ae.threatCache {
 		// 移除1小時前的威脅記錄
 		if now.Sub(threat.LastSeen) > 1*time.Hour {
@@ -447,9 +761,77 @@ func (ae *AnalysisEngine) cleanupThreatCache() {
 		}
 	}
 
+	// 行為偵測的滑動視窗狀態以相同週期清理
+	ae.behavior.cleanup()
+
 	ae.logger.Debugf("威脅快取清理完成，當前記錄數: %d", len(ae.threatCache))
 }
 
+// startFlowCleanup 啟動TCP流清理
+func (ae *AnalysisEngine) startFlowCleanup() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ae.cleanupFlows()
+		case <-ae.stopChan:
+			return
+		}
+	}
+}
+
+// cleanupFlows 清理逾時的TCP流
+func (ae *AnalysisEngine) cleanupFlows() {
+	ae.mutex.RLock()
+	reassembler := ae.reassembler
+	ae.mutex.RUnlock()
+
+	if reassembler == nil {
+		return
+	}
+
+	// 流的最後活動時間來自封包時間戳，離線重播時以擷取時間判斷逾時
+	expired := reassembler.Expire(ae.behavior.clock())
+	ae.logger.Debugf("TCP流清理完成，移除 %d 筆，當前流數: %d", expired, reassembler.Stats().ActiveFlows)
+}
+
+// ConfigureStreamReassembly 套用新的串流重組設定 (會清除現有的流狀態)
+func (ae *AnalysisEngine) ConfigureStreamReassembly(config ReassemblyConfig) error {
+	var reassembler *StreamReassembler
+	if config.Enabled {
+		var err error
+		reassembler, err = NewStreamReassembler(ae.logger, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	ae.reassembler = reassembler
+	ae.logger.Infof("串流重組設定已更新，啟用: %v", config.Enabled)
+	return nil
+}
+
+// PacketsInspected 引擎啟動以來分析的封包數
+func (ae *AnalysisEngine) PacketsInspected() uint64 {
+	return ae.packets.Load()
+}
+
+// GetStreamStats 取得串流重組統計
+func (ae *AnalysisEngine) GetStreamStats() ReassemblyStats {
+	ae.mutex.RLock()
+	defer ae.mutex.RUnlock()
+
+	if ae.reassembler == nil {
+		return ReassemblyStats{}
+	}
+	return ae.reassembler.Stats()
+}
+
 // startBlacklistCleanup 啟動黑名單清理
 func (ae *AnalysisEngine) startBlacklistCleanup() {
 	ticker := time.NewTicker(5 * time.Minute)
@@ -470,12 +852,26 @@ func (ae *AnalysisEngine) cleanupBlacklist() {
 	ae.mutex.Lock()
 	defer ae.mutex.Unlock()
 
-	now := time.Now()
-	for ip, expiry := range ae.blacklist {
+	now := ae.behavior.clock()
+	var expired []netip.Prefix
+	ae.listMutex.Lock()
+	ae.blacklist.Walk(func(prefix netip.Prefix, expiry time.Time) bool {
 		if now.After(expiry) {
-			delete(ae.blacklist, ip)
-			ae.logger.Infof("已從黑名單移除過期IP: %s", ip)
+			expired = append(expired, prefix)
 		}
+		return true
+	})
+	for _, prefix := range expired {
+		ae.blacklist.Remove(prefix)
+	}
+	ae.listMutex.Unlock()
+
+	for _, prefix := range expired {
+		key := formatPrefix(prefix)
+		ae.persist("黑名單", func(ctx context.Context, store StateStore) error {
+			return store.DeleteBlacklistEntry(ctx, key)
+		})
+		ae.logger.Infof("已從黑名單移除過期IP: %s", key)
 	}
 }
 
@@ -525,6 +921,7 @@ func (ae *AnalysisEngine) loadDefaultRules() {
 	}
 
 	for _, rule := range defaultRules {
+		rule.Source = "default"
 		if err := ae.AddRule(rule); err != nil {
 			ae.logger.Errorf("載入預設規則失敗: %v", err)
 		}
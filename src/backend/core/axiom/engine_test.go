@@ -3,6 +3,8 @@ package axiom
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -572,3 +574,75 @@ func TestRegexRules(t *testing.T) {
 	assert.True(t, result.Blocked)
 	assert.Equal(t, "high", result.ThreatLevel)
 }
+
+// BenchmarkAnalyzePacketRuleScaling 比較線性比對與 Aho-Corasick 預先過濾在不同規則數量下的表現；
+// 兩者都經由 applyRules 比對，差別只在是否啟用預先過濾
+func BenchmarkAnalyzePacketRuleScaling(b *testing.B) {
+	packet := &NetworkPacket{
+		SourceIP:      "203.0.113.9",
+		DestIP:        "172.16.0.1",
+		SourcePort:    40000,
+		DestPort:      8080,
+		Protocol:      "TCP",
+		PayloadString: "GET /static/app.js?v=1024 HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\nUser-Agent: bench\r\n\r\n",
+		Timestamp:     time.Now(),
+	}
+
+	for _, size := range []int{100, 1000, 5000} {
+		for _, prefilter := range []bool{false, true} {
+			name := "linear"
+			if prefilter {
+				name = "prefilter"
+			}
+			b.Run(fmt.Sprintf("%s/rules=%d", name, size), func(b *testing.B) {
+				engine := newRuleBenchEngine(b, size)
+				if !prefilter {
+					engine.prefilter = nil
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					engine.applyRules(packet, &AnalysisResult{}, false)
+				}
+			})
+		}
+	}
+}
+
+// newRuleBenchEngine 建立含有 n 條模式規則的引擎 (一半字面字串、一半正則表達式)
+func newRuleBenchEngine(tb testing.TB, n int) *AnalysisEngine {
+	tb.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	engine := NewAnalysisEngine(logger, metrics.NewPrometheusMetrics(logger))
+	rules := make([]*SecurityRule, 0, n)
+	for i := 0; i < n; i++ {
+		rule := &SecurityRule{
+			ID:       fmt.Sprintf("bench_%d", i),
+			Name:     fmt.Sprintf("bench rule %d", i),
+			Type:     "pattern",
+			Action:   "alert",
+			Severity: "medium",
+			Enabled:  true,
+		}
+		if i%2 == 0 {
+			rule.Pattern = fmt.Sprintf("sig_%d_token", i)
+		} else {
+			rule.Pattern = fmt.Sprintf("regex:(?i)attack_%d\\s+payload", i)
+			rule.regex = regexp.MustCompile(strings.TrimPrefix(rule.Pattern, "regex:"))
+		}
+		rules = append(rules, rule)
+	}
+
+	engine.mutex.Lock()
+	for _, rule := range rules {
+		engine.prepareRule(rule)
+	}
+	engine.rules = append(engine.rules, rules...)
+	engine.rebuildPrefilter()
+	engine.running = true
+	engine.mutex.Unlock()
+
+	return engine
+}
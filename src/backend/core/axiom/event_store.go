@@ -0,0 +1,648 @@
+package axiom
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultEventCapacity 記憶體事件儲存預設保留的事件與告警數量
+const defaultEventCapacity = 10000
+
+// eventQueueSize 事件寫入佇列長度；佇列已滿時丟棄事件並計數，避免阻塞封包分析
+const eventQueueSize = 4096
+
+// SecurityEvent 安全事件，每次規則命中產生一筆
+type SecurityEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"` // threat_detection
+	Severity   string    `json:"severity"`
+	Message    string    `json:"message"`
+	Timestamp  time.Time `json:"timestamp"`
+	SourceIP   string    `json:"source_ip"`
+	DestIP     string    `json:"dest_ip,omitempty"`
+	DestPort   int       `json:"dest_port,omitempty"`
+	Protocol   string    `json:"protocol,omitempty"`
+	ThreatType string    `json:"threat_type"`
+	Action     string    `json:"action"`
+	Blocked    bool      `json:"blocked"`
+	RuleID     string    `json:"rule_id"`
+	RuleName   string    `json:"rule_name,omitempty"`
+	PacketID   string    `json:"packet_id"`
+}
+
+// Alert 告警結構，動作為 alert 或 block 的規則命中會產生告警
+type Alert struct {
+	ID         string     `json:"id"`
+	Level      string     `json:"level"` // critical、warning、info
+	Severity   string     `json:"severity"`
+	Message    string     `json:"message"`
+	Timestamp  time.Time  `json:"timestamp"`
+	Source     string     `json:"source"`
+	SourceIP   string     `json:"source_ip,omitempty"`
+	RuleID     string     `json:"rule_id,omitempty"`
+	EventID    string     `json:"event_id,omitempty"`
+	Resolved   bool       `json:"resolved"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// EventQuery 事件與告警的查詢條件，空值表示不過濾
+type EventQuery struct {
+	Type     string // 只適用於事件
+	Level    string // 只適用於告警
+	Resolved *bool  // 只適用於告警
+	Severity string
+	SourceIP string
+	Since    time.Time
+	Until    time.Time
+	Limit    int // 0 表示不限制
+	Offset   int
+}
+
+// CountEntry 分組統計
+type CountEntry struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// HourlyCount 每小時事件數量
+type HourlyCount struct {
+	Hour  time.Time `json:"hour"`
+	Count int       `json:"count"`
+}
+
+// EventSummary 事件彙總統計
+type EventSummary struct {
+	Total           int            `json:"total"`
+	Blocked         int            `json:"blocked"`
+	BySeverity      map[string]int `json:"by_severity"`
+	TopTypes        []CountEntry   `json:"top_types"`
+	TopSources      []CountEntry   `json:"top_sources"`
+	TopDestinations []CountEntry   `json:"top_destinations"`
+	Hourly          []HourlyCount  `json:"hourly"`
+	LastEvent       *time.Time     `json:"last_event,omitempty"`
+}
+
+// EventStore 安全事件與告警儲存，查詢結果依時間由新到舊排列
+type EventStore interface {
+	SaveEvent(ctx context.Context, event *SecurityEvent) error
+	SaveAlert(ctx context.Context, alert *Alert) error
+	// GetEvent 事件不存在時回傳 nil
+	GetEvent(ctx context.Context, id string) (*SecurityEvent, error)
+	// QueryEvents 回傳分頁後的事件與符合條件的總數
+	QueryEvents(ctx context.Context, query EventQuery) ([]*SecurityEvent, int, error)
+	// QueryAlerts 回傳分頁後的告警與符合條件的總數
+	QueryAlerts(ctx context.Context, query EventQuery) ([]*Alert, int, error)
+	// ResolveAlert 標記告警為已解決，告警不存在時回傳 nil
+	ResolveAlert(ctx context.Context, id string, at time.Time) (*Alert, error)
+	// Summarize 彙總符合條件的事件，分組統計最多保留 top 筆
+	Summarize(ctx context.Context, query EventQuery, top int) (*EventSummary, error)
+}
+
+// alertLevel 將規則嚴重程度轉換為告警級別
+func alertLevel(severity string) string {
+	switch severity {
+	case "critical", "high":
+		return "critical"
+	case "medium":
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// matchesEvent 檢查事件是否符合查詢條件
+func (q EventQuery) matchesEvent(event *SecurityEvent) bool {
+	if q.Type != "" && event.Type != q.Type {
+		return false
+	}
+	return q.matches(event.Severity, event.SourceIP, event.Timestamp)
+}
+
+// matchesAlert 檢查告警是否符合查詢條件
+func (q EventQuery) matchesAlert(alert *Alert) bool {
+	if q.Level != "" && alert.Level != q.Level {
+		return false
+	}
+	if q.Resolved != nil && alert.Resolved != *q.Resolved {
+		return false
+	}
+	return q.matches(alert.Severity, alert.SourceIP, alert.Timestamp)
+}
+
+func (q EventQuery) matches(severity, sourceIP string, timestamp time.Time) bool {
+	if q.Severity != "" && severity != q.Severity {
+		return false
+	}
+	if q.SourceIP != "" && sourceIP != q.SourceIP {
+		return false
+	}
+	if !q.Since.IsZero() && timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !timestamp.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// page 計算分頁範圍
+func (q EventQuery) page(total int) (int, int) {
+	start := q.Offset
+	if start < 0 {
+		start = 0
+	}
+	if start > total {
+		start = total
+	}
+	end := total
+	if q.Limit > 0 && start+q.Limit < total {
+		end = start + q.Limit
+	}
+	return start, end
+}
+
+// topEntries 依數量由多到少取出前 top 筆
+func topEntries(counts map[string]int, top int) []CountEntry {
+	entries := make([]CountEntry, 0, len(counts))
+	for key, count := range counts {
+		if key == "" {
+			continue
+		}
+		entries = append(entries, CountEntry{Key: key, Count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if top > 0 && len(entries) > top {
+		entries = entries[:top]
+	}
+	return entries
+}
+
+// MemoryEventStore 記憶體事件儲存，超過容量時捨棄最舊的事件與告警
+type MemoryEventStore struct {
+	capacity int
+	events   []*SecurityEvent
+	alerts   []*Alert
+	mutex    sync.RWMutex
+}
+
+// NewMemoryEventStore 建立記憶體事件儲存
+func NewMemoryEventStore(capacity int) *MemoryEventStore {
+	if capacity <= 0 {
+		capacity = defaultEventCapacity
+	}
+	return &MemoryEventStore{
+		capacity: capacity,
+		events:   make([]*SecurityEvent, 0),
+		alerts:   make([]*Alert, 0),
+	}
+}
+
+// SaveEvent 保存事件
+func (ms *MemoryEventStore) SaveEvent(ctx context.Context, event *SecurityEvent) error {
+	if event == nil || event.ID == "" {
+		return fmt.Errorf("事件缺少 ID")
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	copied := *event
+	ms.events = append(ms.events, &copied)
+	if len(ms.events) > ms.capacity {
+		ms.events = ms.events[len(ms.events)-ms.capacity:]
+	}
+	return nil
+}
+
+// SaveAlert 保存告警
+func (ms *MemoryEventStore) SaveAlert(ctx context.Context, alert *Alert) error {
+	if alert == nil || alert.ID == "" {
+		return fmt.Errorf("告警缺少 ID")
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	copied := *alert
+	ms.alerts = append(ms.alerts, &copied)
+	if len(ms.alerts) > ms.capacity {
+		ms.alerts = ms.alerts[len(ms.alerts)-ms.capacity:]
+	}
+	return nil
+}
+
+// GetEvent 取得單一事件
+func (ms *MemoryEventStore) GetEvent(ctx context.Context, id string) (*SecurityEvent, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	for i := len(ms.events) - 1; i >= 0; i-- {
+		if ms.events[i].ID == id {
+			copied := *ms.events[i]
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// QueryEvents 查詢事件
+func (ms *MemoryEventStore) QueryEvents(ctx context.Context, query EventQuery) ([]*SecurityEvent, int, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	matched := ms.matchingEvents(query)
+	start, end := query.page(len(matched))
+	events := make([]*SecurityEvent, 0, end-start)
+	for _, event := range matched[start:end] {
+		copied := *event
+		events = append(events, &copied)
+	}
+	return events, len(matched), nil
+}
+
+// matchingEvents 依時間由新到舊取出符合條件的事件 (呼叫者需持有鎖)
+func (ms *MemoryEventStore) matchingEvents(query EventQuery) []*SecurityEvent {
+	matched := make([]*SecurityEvent, 0)
+	for _, event := range ms.events {
+		if query.matchesEvent(event) {
+			matched = append(matched, event)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.After(matched[j].Timestamp)
+	})
+	return matched
+}
+
+// QueryAlerts 查詢告警
+func (ms *MemoryEventStore) QueryAlerts(ctx context.Context, query EventQuery) ([]*Alert, int, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	matched := make([]*Alert, 0)
+	for _, alert := range ms.alerts {
+		if query.matchesAlert(alert) {
+			matched = append(matched, alert)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.After(matched[j].Timestamp)
+	})
+
+	start, end := query.page(len(matched))
+	alerts := make([]*Alert, 0, end-start)
+	for _, alert := range matched[start:end] {
+		copied := *alert
+		alerts = append(alerts, &copied)
+	}
+	return alerts, len(matched), nil
+}
+
+// ResolveAlert 標記告警為已解決，重複解決時保留第一次的時間
+func (ms *MemoryEventStore) ResolveAlert(ctx context.Context, id string, at time.Time) (*Alert, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for _, alert := range ms.alerts {
+		if alert.ID != id {
+			continue
+		}
+		if !alert.Resolved {
+			alert.Resolved = true
+			alert.ResolvedAt = &at
+		}
+		copied := *alert
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+// Summarize 彙總事件
+func (ms *MemoryEventStore) Summarize(ctx context.Context, query EventQuery, top int) (*EventSummary, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	summary := &EventSummary{BySeverity: make(map[string]int)}
+	types := make(map[string]int)
+	sources := make(map[string]int)
+	destinations := make(map[string]int)
+	hourly := make(map[time.Time]int)
+
+	for _, event := range ms.matchingEvents(query) {
+		summary.Total++
+		if event.Blocked {
+			summary.Blocked++
+		}
+		summary.BySeverity[event.Severity]++
+		types[event.ThreatType]++
+		sources[event.SourceIP]++
+		destinations[event.DestIP]++
+		hourly[event.Timestamp.UTC().Truncate(time.Hour)]++
+		if summary.LastEvent == nil {
+			last := event.Timestamp
+			summary.LastEvent = &last
+		}
+	}
+
+	summary.TopTypes = topEntries(types, top)
+	summary.TopSources = topEntries(sources, top)
+	summary.TopDestinations = topEntries(destinations, top)
+	summary.Hourly = make([]HourlyCount, 0, len(hourly))
+	for hour, count := range hourly {
+		summary.Hourly = append(summary.Hourly, HourlyCount{Hour: hour, Count: count})
+	}
+	sort.Slice(summary.Hourly, func(i, j int) bool {
+		return summary.Hourly[i].Hour.Before(summary.Hourly[j].Hour)
+	})
+	return summary, nil
+}
+
+// SecurityStats 安全統計
+type SecurityStats struct {
+	TotalThreats    int            `json:"total_threats"`
+	BlockedThreats  int            `json:"blocked_threats"`
+	ActiveThreats   int            `json:"active_threats"` // 威脅快取中仍在追蹤的來源
+	ResolvedThreats int            `json:"resolved_threats"`
+	ThreatTrend     float64        `json:"threat_trend"` // 與前一個等長時段相比的變化百分比
+	BySeverity      map[string]int `json:"by_severity"`
+	TopThreatTypes  []TypeCount    `json:"top_threat_types"`
+	TopSourceIPs    []IPCount      `json:"top_source_ips"`
+	Since           *time.Time     `json:"since,omitempty"`
+	Until           time.Time      `json:"until"`
+}
+
+// TypeCount 威脅類型統計
+type TypeCount struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// IPCount 來源 IP 統計
+type IPCount struct {
+	IP    string `json:"ip"`
+	Count int    `json:"count"`
+}
+
+// SetEventStore 設定事件儲存 (預設為記憶體儲存)
+func (ae *AnalysisEngine) SetEventStore(store EventStore) {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	ae.events = store
+}
+
+// Events 取得事件儲存
+func (ae *AnalysisEngine) Events() EventStore {
+	ae.mutex.RLock()
+	defer ae.mutex.RUnlock()
+
+	return ae.events
+}
+
+// OnResult 註冊分析結果監聽器，黑名單阻擋與規則命中的結果會在分析時同步傳入副本；
+// 監聽器在分析路徑上執行，不可阻塞。回傳的函式用於取消註冊
+func (ae *AnalysisEngine) OnResult(listener func(*AnalysisResult)) func() {
+	return ae.listeners.add(listener)
+}
+
+// resultListeners 分析結果監聽器集合，與引擎的讀寫鎖分開以便在分析路徑上通知
+type resultListeners struct {
+	mutex     sync.RWMutex
+	next      int
+	listeners map[int]func(*AnalysisResult)
+}
+
+// add 新增監聽器並回傳取消註冊的函式
+func (rl *resultListeners) add(listener func(*AnalysisResult)) func() {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if rl.listeners == nil {
+		rl.listeners = make(map[int]func(*AnalysisResult))
+	}
+	id := rl.next
+	rl.next++
+	rl.listeners[id] = listener
+
+	return func() {
+		rl.mutex.Lock()
+		defer rl.mutex.Unlock()
+		delete(rl.listeners, id)
+	}
+}
+
+// notify 將結果副本傳給所有監聽器
+func (rl *resultListeners) notify(result *AnalysisResult) {
+	rl.mutex.RLock()
+	defer rl.mutex.RUnlock()
+
+	for _, listener := range rl.listeners {
+		copied := *result
+		listener(&copied)
+	}
+}
+
+// recordEvent 將規則命中排入事件寫入佇列，動作為 alert 或 block 時同時產生告警 (呼叫者需持有讀鎖)
+func (ae *AnalysisEngine) recordEvent(packet *NetworkPacket, result *AnalysisResult) {
+	if ae.events == nil {
+		return
+	}
+
+	timestamp := result.Timestamp
+	if timestamp.IsZero() {
+		timestamp = ae.behavior.clock()
+	}
+	write := eventWrite{
+		store: ae.events,
+		event: &SecurityEvent{
+			ID:         "evt_" + result.PacketID,
+			Type:       "threat_detection",
+			Severity:   result.ThreatLevel,
+			Message:    result.Details,
+			Timestamp:  timestamp,
+			SourceIP:   result.SourceIP,
+			DestIP:     result.DestIP,
+			DestPort:   result.DestPort,
+			Protocol:   packet.Protocol,
+			ThreatType: result.ThreatType,
+			Action:     result.Action,
+			Blocked:    result.Blocked,
+			RuleID:     result.RuleID,
+			RuleName:   result.RuleName,
+			PacketID:   result.PacketID,
+		},
+	}
+	if result.Action == "alert" || result.Action == "block" {
+		write.alert = &Alert{
+			ID:        "alert_" + result.PacketID,
+			Level:     alertLevel(result.ThreatLevel),
+			Severity:  result.ThreatLevel,
+			Message:   fmt.Sprintf("%s: %s", result.RuleName, result.SourceIP),
+			Timestamp: timestamp,
+			Source:    "axiom_engine",
+			SourceIP:  result.SourceIP,
+			RuleID:    result.RuleID,
+			EventID:   write.event.ID,
+		}
+	}
+
+	ae.eventWriter.enqueue(write)
+}
+
+// FlushEvents 等待已排入佇列的事件寫入完成
+func (ae *AnalysisEngine) FlushEvents(ctx context.Context) error {
+	return ae.eventWriter.flush(ctx)
+}
+
+// DroppedEvents 因寫入佇列已滿而丟棄的事件數
+func (ae *AnalysisEngine) DroppedEvents() uint64 {
+	return ae.eventWriter.dropped.Load()
+}
+
+// eventWrite 佇列中的事件寫入；done 不為 nil 時為排空標記
+type eventWrite struct {
+	store EventStore
+	event *SecurityEvent
+	alert *Alert
+	done  chan struct{}
+}
+
+// eventWriter 在背景依序將事件與告警寫入事件儲存，避免資料庫延遲拖慢封包分析
+type eventWriter struct {
+	logger  *logrus.Logger
+	queue   chan eventWrite
+	dropped atomic.Uint64
+}
+
+// newEventWriter 建立並啟動背景事件寫入器
+func newEventWriter(logger *logrus.Logger) *eventWriter {
+	w := &eventWriter{
+		logger: logger,
+		queue:  make(chan eventWrite, eventQueueSize),
+	}
+	go w.run()
+	return w
+}
+
+// enqueue 不阻塞地排入寫入，佇列已滿時丟棄並計數
+func (w *eventWriter) enqueue(write eventWrite) {
+	select {
+	case w.queue <- write:
+	default:
+		// 只在丟棄數達到 2 的冪次時記錄，避免持續壅塞時大量輸出日誌
+		if dropped := w.dropped.Add(1); dropped&(dropped-1) == 0 {
+			w.logger.Warnf("事件寫入佇列已滿，已丟棄 %d 筆安全事件", dropped)
+		}
+	}
+}
+
+// run 依序寫入事件，告警在事件保存成功後才寫入
+func (w *eventWriter) run() {
+	for write := range w.queue {
+		if write.done != nil {
+			close(write.done)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+		if err := write.store.SaveEvent(ctx, write.event); err != nil {
+			w.logger.Warnf("保存安全事件失敗: %v", err)
+		} else if write.alert != nil {
+			if err := write.store.SaveAlert(ctx, write.alert); err != nil {
+				w.logger.Warnf("保存告警失敗: %v", err)
+			}
+		}
+		cancel()
+	}
+}
+
+// flush 等待目前已排入的寫入全部完成
+func (w *eventWriter) flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case w.queue <- eventWrite{done: done}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GetSecurityStats 統計查詢時段內的安全事件；query 的分頁條件會被忽略
+func (ae *AnalysisEngine) GetSecurityStats(ctx context.Context, query EventQuery, top int) (*SecurityStats, error) {
+	store := ae.Events()
+	if store == nil {
+		return nil, fmt.Errorf("未設定事件儲存")
+	}
+
+	query.Limit, query.Offset = 0, 0
+	if query.Until.IsZero() {
+		query.Until = time.Now()
+	}
+	summary, err := store.Summarize(ctx, query, top)
+	if err != nil {
+		return nil, fmt.Errorf("彙總安全事件失敗: %v", err)
+	}
+
+	resolved := true
+	alertQuery := query
+	alertQuery.Resolved = &resolved
+	alertQuery.Limit = 1
+	_, resolvedCount, err := store.QueryAlerts(ctx, alertQuery)
+	if err != nil {
+		return nil, fmt.Errorf("查詢告警失敗: %v", err)
+	}
+
+	stats := &SecurityStats{
+		TotalThreats:    summary.Total,
+		BlockedThreats:  summary.Blocked,
+		ActiveThreats:   len(ae.GetThreatInfo()),
+		ResolvedThreats: resolvedCount,
+		BySeverity:      summary.BySeverity,
+		TopThreatTypes:  make([]TypeCount, 0, len(summary.TopTypes)),
+		TopSourceIPs:    make([]IPCount, 0, len(summary.TopSources)),
+		Until:           query.Until,
+	}
+	for _, entry := range summary.TopTypes {
+		stats.TopThreatTypes = append(stats.TopThreatTypes, TypeCount{Type: entry.Key, Count: entry.Count})
+	}
+	for _, entry := range summary.TopSources {
+		stats.TopSourceIPs = append(stats.TopSourceIPs, IPCount{IP: entry.Key, Count: entry.Count})
+	}
+
+	// 與前一個等長時段比較趨勢
+	if !query.Since.IsZero() {
+		since := query.Since
+		stats.Since = &since
+
+		previous := query
+		previous.Since = query.Since.Add(-query.Until.Sub(query.Since))
+		previous.Until = query.Since
+		before, err := store.Summarize(ctx, previous, 0)
+		if err != nil {
+			return nil, fmt.Errorf("彙總安全事件失敗: %v", err)
+		}
+		if before.Total > 0 {
+			change := float64(summary.Total-before.Total) / float64(before.Total) * 100
+			stats.ThreatTrend = math.Round(change*10) / 10
+		}
+	}
+
+	return stats, nil
+}
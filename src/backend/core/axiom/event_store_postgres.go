@@ -0,0 +1,276 @@
+package axiom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// eventRecord 安全事件資料表
+type eventRecord struct {
+	ID         string    `gorm:"primaryKey;size:64"`
+	Type       string    `gorm:"size:32;index"`
+	Severity   string    `gorm:"size:16;index"`
+	Message    string    `gorm:"type:text"`
+	Timestamp  time.Time `gorm:"index;not null"`
+	SourceIP   string    `gorm:"size:64;index"`
+	DestIP     string    `gorm:"size:64"`
+	DestPort   int
+	Protocol   string `gorm:"size:16"`
+	ThreatType string `gorm:"size:64"`
+	Action     string `gorm:"size:16"`
+	Blocked    bool
+	RuleID     string `gorm:"size:128;index"`
+	RuleName   string `gorm:"size:256"`
+	PacketID   string `gorm:"size:64"`
+}
+
+// TableName 資料表名稱
+func (eventRecord) TableName() string {
+	return "axiom_events"
+}
+
+// alertRecord 告警資料表
+type alertRecord struct {
+	ID         string    `gorm:"primaryKey;size:64"`
+	Level      string    `gorm:"size:16;index"`
+	Severity   string    `gorm:"size:16"`
+	Message    string    `gorm:"type:text"`
+	Timestamp  time.Time `gorm:"index;not null"`
+	Source     string    `gorm:"size:64"`
+	SourceIP   string    `gorm:"size:64;index"`
+	RuleID     string    `gorm:"size:128"`
+	EventID    string    `gorm:"size:64"`
+	Resolved   bool      `gorm:"index"`
+	ResolvedAt *time.Time
+}
+
+// TableName 資料表名稱
+func (alertRecord) TableName() string {
+	return "axiom_alerts"
+}
+
+// PostgresEventStore 以 PostgreSQL 保存安全事件與告警
+type PostgresEventStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresEventStore 建立 PostgreSQL 事件儲存並自動遷移資料表
+func NewPostgresEventStore(db *gorm.DB) (*PostgresEventStore, error) {
+	if db == nil {
+		return nil, fmt.Errorf("資料庫連線不可為空")
+	}
+	if err := db.AutoMigrate(&eventRecord{}, &alertRecord{}); err != nil {
+		return nil, fmt.Errorf("遷移事件資料表失敗: %v", err)
+	}
+	return &PostgresEventStore{db: db}, nil
+}
+
+// SaveEvent 保存事件
+func (ps *PostgresEventStore) SaveEvent(ctx context.Context, event *SecurityEvent) error {
+	record := eventRecord(*event)
+	if err := ps.db.WithContext(ctx).Create(&record).Error; err != nil {
+		return fmt.Errorf("保存事件失敗: %v", err)
+	}
+	return nil
+}
+
+// SaveAlert 保存告警
+func (ps *PostgresEventStore) SaveAlert(ctx context.Context, alert *Alert) error {
+	record := alertRecord(*alert)
+	if err := ps.db.WithContext(ctx).Create(&record).Error; err != nil {
+		return fmt.Errorf("保存告警失敗: %v", err)
+	}
+	return nil
+}
+
+// GetEvent 取得單一事件
+func (ps *PostgresEventStore) GetEvent(ctx context.Context, id string) (*SecurityEvent, error) {
+	var record eventRecord
+	err := ps.db.WithContext(ctx).Where("id = ?", id).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("讀取事件失敗: %v", err)
+	}
+	event := SecurityEvent(record)
+	return &event, nil
+}
+
+// QueryEvents 查詢事件
+func (ps *PostgresEventStore) QueryEvents(ctx context.Context, query EventQuery) ([]*SecurityEvent, int, error) {
+	var total int64
+	if err := ps.events(ctx, query).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("統計事件失敗: %v", err)
+	}
+	var records []eventRecord
+	if err := paginate(ps.events(ctx, query), query).Order("timestamp DESC").Find(&records).Error; err != nil {
+		return nil, 0, fmt.Errorf("查詢事件失敗: %v", err)
+	}
+
+	events := make([]*SecurityEvent, 0, len(records))
+	for _, record := range records {
+		event := SecurityEvent(record)
+		events = append(events, &event)
+	}
+	return events, int(total), nil
+}
+
+// QueryAlerts 查詢告警
+func (ps *PostgresEventStore) QueryAlerts(ctx context.Context, query EventQuery) ([]*Alert, int, error) {
+	var total int64
+	if err := ps.alerts(ctx, query).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("統計告警失敗: %v", err)
+	}
+	var records []alertRecord
+	if err := paginate(ps.alerts(ctx, query), query).Order("timestamp DESC").Find(&records).Error; err != nil {
+		return nil, 0, fmt.Errorf("查詢告警失敗: %v", err)
+	}
+
+	alerts := make([]*Alert, 0, len(records))
+	for _, record := range records {
+		alert := Alert(record)
+		alerts = append(alerts, &alert)
+	}
+	return alerts, int(total), nil
+}
+
+// ResolveAlert 標記告警為已解決，重複解決時保留第一次的時間
+func (ps *PostgresEventStore) ResolveAlert(ctx context.Context, id string, at time.Time) (*Alert, error) {
+	db := ps.db.WithContext(ctx)
+	err := db.Model(&alertRecord{}).
+		Where("id = ? AND resolved = ?", id, false).
+		Updates(map[string]interface{}{"resolved": true, "resolved_at": at}).Error
+	if err != nil {
+		return nil, fmt.Errorf("更新告警失敗: %v", err)
+	}
+
+	var record alertRecord
+	err = db.Where("id = ?", id).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("讀取告警失敗: %v", err)
+	}
+	alert := Alert(record)
+	return &alert, nil
+}
+
+// Summarize 以 SQL 聚合彙總事件
+func (ps *PostgresEventStore) Summarize(ctx context.Context, query EventQuery, top int) (*EventSummary, error) {
+	base := func() *gorm.DB { return ps.events(ctx, query) }
+
+	var totals struct {
+		Total   int
+		Blocked int
+		Last    *time.Time
+	}
+	err := base().
+		Select("COUNT(*) AS total, COUNT(*) FILTER (WHERE blocked) AS blocked, MAX(timestamp) AS last").
+		Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("彙總事件失敗: %v", err)
+	}
+
+	summary := &EventSummary{
+		Total:      totals.Total,
+		Blocked:    totals.Blocked,
+		BySeverity: make(map[string]int),
+		LastEvent:  totals.Last,
+	}
+
+	var severities []CountEntry
+	if err := base().Select("severity AS key, COUNT(*) AS count").Group("severity").Scan(&severities).Error; err != nil {
+		return nil, fmt.Errorf("彙總事件失敗: %v", err)
+	}
+	for _, entry := range severities {
+		summary.BySeverity[entry.Key] = entry.Count
+	}
+
+	for column, target := range map[string]*[]CountEntry{
+		"threat_type": &summary.TopTypes,
+		"source_ip":   &summary.TopSources,
+		"dest_ip":     &summary.TopDestinations,
+	} {
+		db := base().Select(column + " AS key, COUNT(*) AS count").
+			Where(column + " <> ''").
+			Group(column).
+			Order("count DESC, key")
+		if top > 0 {
+			db = db.Limit(top)
+		}
+		*target = make([]CountEntry, 0)
+		if err := db.Scan(target).Error; err != nil {
+			return nil, fmt.Errorf("彙總事件失敗: %v", err)
+		}
+	}
+
+	summary.Hourly = make([]HourlyCount, 0)
+	err = base().
+		Select("date_trunc('hour', timestamp) AS hour, COUNT(*) AS count").
+		Group("hour").
+		Order("hour").
+		Scan(&summary.Hourly).Error
+	if err != nil {
+		return nil, fmt.Errorf("彙總事件失敗: %v", err)
+	}
+	for i := range summary.Hourly {
+		summary.Hourly[i].Hour = summary.Hourly[i].Hour.UTC()
+	}
+
+	return summary, nil
+}
+
+// events 建立符合查詢條件的事件查詢，每次呼叫回傳新的查詢鏈
+func (ps *PostgresEventStore) events(ctx context.Context, query EventQuery) *gorm.DB {
+	db := ps.filter(ps.db.WithContext(ctx).Model(&eventRecord{}), query)
+	if query.Type != "" {
+		db = db.Where("type = ?", query.Type)
+	}
+	return db
+}
+
+// alerts 建立符合查詢條件的告警查詢，每次呼叫回傳新的查詢鏈
+func (ps *PostgresEventStore) alerts(ctx context.Context, query EventQuery) *gorm.DB {
+	db := ps.filter(ps.db.WithContext(ctx).Model(&alertRecord{}), query)
+	if query.Level != "" {
+		db = db.Where("level = ?", query.Level)
+	}
+	if query.Resolved != nil {
+		db = db.Where("resolved = ?", *query.Resolved)
+	}
+	return db
+}
+
+// filter 套用事件與告警共用的過濾條件
+func (ps *PostgresEventStore) filter(db *gorm.DB, query EventQuery) *gorm.DB {
+	if query.Severity != "" {
+		db = db.Where("severity = ?", query.Severity)
+	}
+	if query.SourceIP != "" {
+		db = db.Where("source_ip = ?", query.SourceIP)
+	}
+	if !query.Since.IsZero() {
+		db = db.Where("timestamp >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		db = db.Where("timestamp < ?", query.Until)
+	}
+	return db
+}
+
+// paginate 套用分頁條件
+func paginate(db *gorm.DB, query EventQuery) *gorm.DB {
+	if query.Offset > 0 {
+		db = db.Offset(query.Offset)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	return db
+}
@@ -0,0 +1,79 @@
+//go:build integration
+// +build integration
+
+package axiom
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// TestPostgresEventStore 需要設定 AXIOM_TEST_POSTGRES_DSN 指向可寫入的 PostgreSQL
+func TestPostgresEventStore(t *testing.T) {
+	dsn := os.Getenv("AXIOM_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("未設定 AXIOM_TEST_POSTGRES_DSN")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewPostgresEventStore(db)
+	require.NoError(t, err)
+	defer db.Migrator().DropTable(&eventRecord{}, &alertRecord{})
+
+	ctx := context.Background()
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i, severity := range []string{"high", "high", "critical"} {
+		event := &SecurityEvent{
+			ID:         fmt.Sprintf("pg_evt_%d", i),
+			Type:       "threat_detection",
+			Severity:   severity,
+			Timestamp:  base.Add(time.Duration(i) * 40 * time.Minute),
+			SourceIP:   "203.0.113.1",
+			DestIP:     "172.16.0.44",
+			ThreatType: "pattern",
+			Blocked:    severity == "critical",
+		}
+		require.NoError(t, store.SaveEvent(ctx, event))
+		require.NoError(t, store.SaveAlert(ctx, &Alert{ID: "pg_alert_" + event.ID, Level: alertLevel(severity), Severity: severity, Timestamp: event.Timestamp, EventID: event.ID}))
+	}
+
+	events, total, err := store.QueryEvents(ctx, EventQuery{Severity: "high", Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	require.Len(t, events, 1)
+	assert.Equal(t, "pg_evt_1", events[0].ID)
+
+	event, err := store.GetEvent(ctx, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, event)
+
+	resolved, err := store.ResolveAlert(ctx, "pg_alert_pg_evt_0", base)
+	require.NoError(t, err)
+	require.NotNil(t, resolved)
+	again, err := store.ResolveAlert(ctx, "pg_alert_pg_evt_0", base.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, again.ResolvedAt.Equal(base), "保留第一次解決的時間")
+
+	done := true
+	_, total, err = store.QueryAlerts(ctx, EventQuery{Resolved: &done})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+
+	summary, err := store.Summarize(ctx, EventQuery{}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 3, summary.Total)
+	assert.Equal(t, 1, summary.Blocked)
+	assert.Equal(t, 2, summary.BySeverity["high"])
+	assert.Equal(t, []CountEntry{{Key: "203.0.113.1", Count: 3}}, summary.TopSources)
+	assert.Len(t, summary.Hourly, 2)
+}
@@ -0,0 +1,296 @@
+package axiom
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryEventStoreQuery(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryEventStore(5)
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	severities := []string{"low", "high", "high", "critical", "medium", "high"}
+	for i, severity := range severities {
+		require.NoError(t, store.SaveEvent(ctx, &SecurityEvent{
+			ID:         fmt.Sprintf("evt_%d", i),
+			Type:       "threat_detection",
+			Severity:   severity,
+			Timestamp:  base.Add(time.Duration(i) * 30 * time.Minute),
+			SourceIP:   fmt.Sprintf("203.0.113.%d", i%2),
+			ThreatType: "pattern",
+			Blocked:    severity == "critical",
+		}))
+	}
+	assert.Error(t, store.SaveEvent(ctx, &SecurityEvent{}))
+
+	// 容量為 5，最舊的 evt_0 被捨棄
+	event, err := store.GetEvent(ctx, "evt_0")
+	require.NoError(t, err)
+	assert.Nil(t, event)
+
+	events, total, err := store.QueryEvents(ctx, EventQuery{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 5, total)
+	require.Len(t, events, 2)
+	assert.Equal(t, "evt_5", events[0].ID, "由新到舊排列")
+
+	events, total, err = store.QueryEvents(ctx, EventQuery{Severity: "high", Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, events, 1)
+	assert.Equal(t, "evt_2", events[0].ID)
+
+	events, total, err = store.QueryEvents(ctx, EventQuery{
+		SourceIP: "203.0.113.1",
+		Since:    base.Add(time.Hour),
+		Until:    base.Add(150 * time.Minute),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "evt_3", events[0].ID)
+
+	_, total, err = store.QueryEvents(ctx, EventQuery{Offset: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 5, total, "超出範圍的 offset 仍回傳總數")
+
+	summary, err := store.Summarize(ctx, EventQuery{}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 5, summary.Total)
+	assert.Equal(t, 1, summary.Blocked)
+	assert.Equal(t, 3, summary.BySeverity["high"])
+	assert.Equal(t, []CountEntry{{Key: "203.0.113.1", Count: 3}}, summary.TopSources)
+	assert.Len(t, summary.Hourly, 3)
+	assert.Equal(t, base.Add(150*time.Minute), *summary.LastEvent)
+}
+
+func TestMemoryEventStoreAlerts(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryEventStore(0)
+	now := time.Now()
+
+	require.NoError(t, store.SaveAlert(ctx, &Alert{ID: "a1", Level: "critical", Severity: "high", Timestamp: now.Add(-time.Minute)}))
+	require.NoError(t, store.SaveAlert(ctx, &Alert{ID: "a2", Level: "warning", Severity: "medium", Timestamp: now}))
+
+	resolved, err := store.ResolveAlert(ctx, "a1", now)
+	require.NoError(t, err)
+	require.NotNil(t, resolved)
+	assert.True(t, resolved.Resolved)
+
+	again, err := store.ResolveAlert(ctx, "a1", now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, now, *again.ResolvedAt, "保留第一次解決的時間")
+
+	missing, err := store.ResolveAlert(ctx, "missing", now)
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	open := false
+	alerts, total, err := store.QueryAlerts(ctx, EventQuery{Resolved: &open})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "a2", alerts[0].ID)
+
+	_, total, err = store.QueryAlerts(ctx, EventQuery{Level: "critical"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+}
+
+func TestEngineRecordsEvents(t *testing.T) {
+	engine, clock := newBehaviorEngine(t,
+		&SecurityRule{ID: "sqli", Name: "SQL注入", Type: "pattern", Pattern: "union select", Action: "block", Severity: "critical", Enabled: true},
+		&SecurityRule{ID: "admin", Name: "管理路徑", Type: "pattern", Pattern: "/admin", Action: "alert", Severity: "medium", Enabled: true},
+		&SecurityRule{ID: "robots", Name: "robots", Type: "pattern", Pattern: "robots.txt", Action: "log", Severity: "low", Enabled: true},
+	)
+
+	analyze(t, engine, tuningPacket("203.0.113.1", 80, "GET /robots.txt"))
+	analyze(t, engine, tuningPacket("203.0.113.2", 80, "GET /admin"))
+	analyze(t, engine, tuningPacket("203.0.113.3", 80, "id=1 union select"))
+	analyze(t, engine, tuningPacket("203.0.113.4", 80, "GET /index"))
+
+	ctx := context.Background()
+	events, total, err := engine.Events().QueryEvents(ctx, EventQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total, "只有命中規則的封包產生事件")
+	assert.Equal(t, clock.Now(), events[0].Timestamp, "封包沒有時間戳時使用引擎時間")
+
+	alerts, total, err := engine.Events().QueryAlerts(ctx, EventQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total, "log 動作不產生告警")
+	levels := map[string]string{}
+	for _, alert := range alerts {
+		levels[alert.RuleID] = alert.Level
+		event, err := engine.Events().GetEvent(ctx, alert.EventID)
+		require.NoError(t, err)
+		require.NotNil(t, event)
+		assert.Equal(t, alert.SourceIP, event.SourceIP)
+	}
+	assert.Equal(t, map[string]string{"sqli": "critical", "admin": "warning"}, levels)
+
+	stats, err := engine.GetSecurityStats(ctx, EventQuery{}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.TotalThreats)
+	assert.Equal(t, 1, stats.BlockedThreats)
+	assert.Equal(t, 3, stats.ActiveThreats)
+}
+
+// blockingEventStore 在 release 關閉前阻塞寫入的事件儲存
+type blockingEventStore struct {
+	*MemoryEventStore
+	release chan struct{}
+}
+
+func (bs *blockingEventStore) SaveEvent(ctx context.Context, event *SecurityEvent) error {
+	<-bs.release
+	return bs.MemoryEventStore.SaveEvent(ctx, event)
+}
+
+func TestEngineEventWritesDoNotBlockAnalysis(t *testing.T) {
+	engine, _ := newBehaviorEngine(t, tuningRules()...)
+	store := &blockingEventStore{MemoryEventStore: NewMemoryEventStore(defaultEventCapacity), release: make(chan struct{})}
+	engine.SetEventStore(store)
+
+	// 儲存阻塞時分析照常完成，超出佇列的事件被丟棄並計數
+	for i := 0; i < eventQueueSize+10; i++ {
+		result, err := engine.AnalyzePacket(tuningPacket("203.0.113.1", 80, "GET /admin"))
+		require.NoError(t, err)
+		assert.Equal(t, "admin_path", result.RuleID)
+	}
+	assert.Positive(t, engine.DroppedEvents())
+
+	// 寫鎖不需等待資料庫寫入
+	require.NoError(t, engine.AddRule(&SecurityRule{ID: "extra", Name: "extra", Type: "pattern", Pattern: "zzz", Action: "alert", Severity: "low", Enabled: true}))
+
+	close(store.release)
+	require.NoError(t, engine.FlushEvents(context.Background()))
+	_, total, err := store.QueryEvents(context.Background(), EventQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, eventQueueSize+10-int(engine.DroppedEvents()), total)
+}
+
+func TestUIServerEventEndpoints(t *testing.T) {
+	engine, _ := newBehaviorEngine(t,
+		&SecurityRule{ID: "sqli", Name: "SQL注入", Type: "pattern", Pattern: "union select", Action: "block", Severity: "critical", Enabled: true},
+		&SecurityRule{ID: "admin", Name: "管理路徑", Type: "pattern", Pattern: "/admin", Action: "alert", Severity: "medium", Enabled: true},
+	)
+	now := time.Now()
+	for i, payload := range []string{"GET /admin", "GET /admin", "id=1 union select"} {
+		packet := tuningPacket(fmt.Sprintf("203.0.113.%d", i+1), 80, payload)
+		packet.Timestamp = now.Add(time.Duration(i-3) * time.Minute)
+		analyze(t, engine, packet)
+	}
+	old := tuningPacket("198.51.100.9", 80, "GET /admin")
+	old.Timestamp = now.Add(-48 * time.Hour)
+	analyze(t, engine, old)
+
+	ui := NewUIServer(engine.logger, engine.metrics)
+	ui.SetAnalysisEngine(engine)
+	router := ui.setupRouter()
+	get := func(path string, target interface{}) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if target != nil && recorder.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), target))
+		}
+		return recorder
+	}
+
+	var events struct {
+		Events []SecurityEvent `json:"events"`
+		Total  int             `json:"total"`
+		Limit  int             `json:"limit"`
+	}
+	require.Equal(t, http.StatusOK, get("/api/v1/events?limit=2", &events).Code)
+	assert.Equal(t, 4, events.Total)
+	assert.Len(t, events.Events, 2)
+	assert.Equal(t, 2, events.Limit)
+
+	require.Equal(t, http.StatusOK, get("/api/v1/events?severity=medium&time_range=24h", &events).Code)
+	assert.Equal(t, 2, events.Total, "time_range 排除兩天前的事件")
+
+	require.Equal(t, http.StatusOK, get("/api/v1/events?source_ip=203.0.113.3", &events).Code)
+	require.Equal(t, 1, events.Total)
+	eventID := events.Events[0].ID
+
+	var event SecurityEvent
+	require.Equal(t, http.StatusOK, get("/api/v1/events/"+eventID, &event).Code)
+	assert.Equal(t, "sqli", event.RuleID)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/events/missing", nil).Code)
+
+	for _, path := range []string{"/api/v1/events?limit=0", "/api/v1/events?source_ip=bad", "/api/v1/alerts?resolved=maybe", "/api/v1/security/threats?time_range=soon"} {
+		assert.Equal(t, http.StatusBadRequest, get(path, nil).Code, path)
+	}
+
+	var threats struct {
+		Threats []ThreatEvent `json:"threats"`
+		Total   int           `json:"total"`
+	}
+	require.Equal(t, http.StatusOK, get("/api/v1/security/threats?severity=all", &threats).Code)
+	assert.Equal(t, 3, threats.Total, "預設只列出最近 24 小時")
+	assert.Equal(t, "block", threats.Threats[0].Action)
+	assert.Equal(t, 80, threats.Threats[0].Port)
+
+	var alerts struct {
+		Alerts []Alert `json:"alerts"`
+		Total  int     `json:"total"`
+	}
+	require.Equal(t, http.StatusOK, get("/api/v1/alerts?level=critical", &alerts).Code)
+	require.Equal(t, 1, alerts.Total)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/alerts/"+alerts.Alerts[0].ID+"/resolve", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/alerts/missing/resolve", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	require.Equal(t, http.StatusOK, get("/api/v1/alerts?resolved=false", &alerts).Code)
+	assert.Equal(t, 3, alerts.Total)
+
+	var stats SecurityStats
+	require.Equal(t, http.StatusOK, get("/api/v1/security/stats", &stats).Code)
+	assert.Equal(t, 3, stats.TotalThreats)
+	assert.Equal(t, 1, stats.BlockedThreats)
+	assert.Equal(t, 1, stats.ResolvedThreats)
+	assert.Equal(t, "pattern", stats.TopThreatTypes[0].Type)
+
+	var report SecurityReport
+	require.Equal(t, http.StatusOK, get("/api/v1/reports/security?time_range=7d", &report).Code)
+	assert.Equal(t, 4, report.Summary.TotalThreats)
+	assert.Equal(t, "7d", report.TimeRange)
+	require.Len(t, report.ThreatByType, 1)
+	assert.Equal(t, 100.0, report.ThreatByType[0].Percentage)
+
+	recorder = get("/api/v1/reports/security?format=csv", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	reader := csv.NewReader(strings.NewReader(recorder.Body.String()))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"section", "metric", "value"}, records[0])
+	assert.Equal(t, []string{"summary", "total_threats", "3"}, records[1])
+
+	var doc struct {
+		Paths map[string]interface{} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(SwaggerDoc), &doc))
+	for _, path := range []string{"/events", "/events/{id}", "/alerts", "/security/threats/{id}/block", "/reports/security", "/reports/custom", "/reports/schedules", "/reports/schedules/{name}/run"} {
+		assert.Contains(t, doc.Paths, path)
+	}
+
+	// 未連接引擎時不再回傳假資料
+	disconnected := NewUIServer(engine.logger, engine.metrics).setupRouter()
+	recorder = httptest.NewRecorder()
+	disconnected.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/security/threats", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
@@ -0,0 +1,351 @@
+package axiom
+
+import (
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"strings"
+)
+
+// IPSet 以 patricia (路徑壓縮的 radix) 樹儲存 IPv4/IPv6 前綴，查詢採最長前綴匹配。
+// IPSet 本身不具備並行保護，由呼叫者 (例如 AnalysisEngine 的鎖) 負責同步
+type IPSet[V any] struct {
+	v4   *ipNode[V]
+	v6   *ipNode[V]
+	size int
+}
+
+// ipNode 樹節點；prefix 已遮罩，沒有值的節點只作為分岔點
+type ipNode[V any] struct {
+	prefix   netip.Prefix
+	value    V
+	hasValue bool
+	child    [2]*ipNode[V]
+}
+
+// NewIPSet 建立空的 IP 集合
+func NewIPSet[V any]() *IPSet[V] {
+	return &IPSet[V]{}
+}
+
+// Len 集合中的前綴數量
+func (s *IPSet[V]) Len() int {
+	return s.size
+}
+
+// root 依位址族取得樹根
+func (s *IPSet[V]) root(addr netip.Addr) **ipNode[V] {
+	if addr.Is4() {
+		return &s.v4
+	}
+	return &s.v6
+}
+
+// Insert 新增或更新前綴
+func (s *IPSet[V]) Insert(prefix netip.Prefix, value V) {
+	prefix = normalizePrefix(prefix)
+	node := s.root(prefix.Addr())
+
+	for {
+		current := *node
+		if current == nil {
+			*node = &ipNode[V]{prefix: prefix, value: value, hasValue: true}
+			s.size++
+			return
+		}
+
+		common := commonPrefixLen(current.prefix, prefix)
+		switch {
+		case common == current.prefix.Bits() && common == prefix.Bits():
+			// 相同前綴
+			if !current.hasValue {
+				s.size++
+			}
+			current.value = value
+			current.hasValue = true
+			return
+		case common == current.prefix.Bits():
+			// 現有節點包含新前綴，往下走
+			node = &current.child[addrBit(prefix.Addr(), common)]
+		case common == prefix.Bits():
+			// 新前綴包含現有節點，插入為其父節點
+			inserted := &ipNode[V]{prefix: prefix, value: value, hasValue: true}
+			inserted.child[addrBit(current.prefix.Addr(), common)] = current
+			*node = inserted
+			s.size++
+			return
+		default:
+			// 分岔：建立只作為分岔點的節點
+			glue := &ipNode[V]{prefix: netip.PrefixFrom(prefix.Addr(), common).Masked()}
+			leaf := &ipNode[V]{prefix: prefix, value: value, hasValue: true}
+			glue.child[addrBit(current.prefix.Addr(), common)] = current
+			glue.child[addrBit(prefix.Addr(), common)] = leaf
+			*node = glue
+			s.size++
+			return
+		}
+	}
+}
+
+// Remove 移除完全相同的前綴，回傳是否存在
+func (s *IPSet[V]) Remove(prefix netip.Prefix) bool {
+	prefix = normalizePrefix(prefix)
+	root := s.root(prefix.Addr())
+
+	var removed bool
+	*root, removed = removeNode(*root, prefix)
+	if removed {
+		s.size--
+	}
+	return removed
+}
+
+// removeNode 遞迴移除並壓縮只剩單一子節點的分岔點
+func removeNode[V any](node *ipNode[V], prefix netip.Prefix) (*ipNode[V], bool) {
+	if node == nil || !node.prefix.Contains(prefix.Addr()) || node.prefix.Bits() > prefix.Bits() {
+		return node, false
+	}
+
+	removed := false
+	if node.prefix.Bits() == prefix.Bits() {
+		if !node.hasValue {
+			return node, false
+		}
+		var zero V
+		node.value, node.hasValue = zero, false
+		removed = true
+	} else {
+		i := addrBit(prefix.Addr(), node.prefix.Bits())
+		node.child[i], removed = removeNode(node.child[i], prefix)
+	}
+
+	if !node.hasValue {
+		switch {
+		case node.child[0] == nil:
+			return node.child[1], removed
+		case node.child[1] == nil:
+			return node.child[0], removed
+		}
+	}
+	return node, removed
+}
+
+// Get 取得完全相同前綴的值
+func (s *IPSet[V]) Get(prefix netip.Prefix) (V, bool) {
+	prefix = normalizePrefix(prefix)
+	node := *s.root(prefix.Addr())
+	for node != nil && node.prefix.Bits() <= prefix.Bits() && node.prefix.Contains(prefix.Addr()) {
+		if node.prefix.Bits() == prefix.Bits() {
+			return node.value, node.hasValue
+		}
+		node = node.child[addrBit(prefix.Addr(), node.prefix.Bits())]
+	}
+	var zero V
+	return zero, false
+}
+
+// Lookup 最長前綴匹配，回傳匹配的前綴與值
+func (s *IPSet[V]) Lookup(addr netip.Addr) (netip.Prefix, V, bool) {
+	return s.LookupFunc(addr, nil)
+}
+
+// LookupFunc 最長前綴匹配，只考慮 match 回傳 true 的值；match 為 nil 時等同 Lookup
+func (s *IPSet[V]) LookupFunc(addr netip.Addr, match func(V) bool) (netip.Prefix, V, bool) {
+	addr = addr.Unmap().WithZone("")
+
+	var (
+		best      netip.Prefix
+		bestValue V
+		found     bool
+	)
+	if !addr.IsValid() {
+		return best, bestValue, false
+	}
+
+	node := *s.root(addr)
+	for node != nil && node.prefix.Contains(addr) {
+		if node.hasValue && (match == nil || match(node.value)) {
+			best, bestValue, found = node.prefix, node.value, true
+		}
+		if node.prefix.Bits() == addr.BitLen() {
+			break
+		}
+		node = node.child[addrBit(addr, node.prefix.Bits())]
+	}
+	return best, bestValue, found
+}
+
+// LookupString 以字串位址查詢，無效的位址視為不匹配
+func (s *IPSet[V]) LookupString(ip string) (netip.Prefix, V, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		var zero V
+		return netip.Prefix{}, zero, false
+	}
+	return s.Lookup(addr)
+}
+
+// Contains 位址是否落在任一前綴中
+func (s *IPSet[V]) Contains(addr netip.Addr) bool {
+	_, _, found := s.Lookup(addr)
+	return found
+}
+
+// Walk 依位址順序走訪所有前綴，fn 回傳 false 時停止
+func (s *IPSet[V]) Walk(fn func(prefix netip.Prefix, value V) bool) {
+	if walkNode(s.v4, fn) {
+		walkNode(s.v6, fn)
+	}
+}
+
+// walkNode 前序走訪 (父前綴先於子前綴)
+func walkNode[V any](node *ipNode[V], fn func(prefix netip.Prefix, value V) bool) bool {
+	if node == nil {
+		return true
+	}
+	if node.hasValue && !fn(node.prefix, node.value) {
+		return false
+	}
+	return walkNode(node.child[0], fn) && walkNode(node.child[1], fn)
+}
+
+// normalizePrefix 遮罩主機位元並將 IPv4-mapped IPv6 轉為 IPv4
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	addr := prefix.Addr()
+	if addr.Is4In6() {
+		bits := prefix.Bits() - 96
+		if bits < 0 {
+			bits = 0
+		}
+		return netip.PrefixFrom(addr.Unmap(), bits).Masked()
+	}
+	return prefix.Masked()
+}
+
+// addrBit 取得位址第 i 個位元 (由最高位元起算)
+func addrBit(addr netip.Addr, i int) int {
+	b := addr.As16()
+	if addr.Is4() {
+		i += 96
+	}
+	return int(b[i/8]>>(7-uint(i%8))) & 1
+}
+
+// commonPrefixLen 兩個前綴共同的前綴長度 (不超過較短者)
+func commonPrefixLen(a, b netip.Prefix) int {
+	limit := a.Bits()
+	if b.Bits() < limit {
+		limit = b.Bits()
+	}
+
+	x, y := a.Addr().As16(), b.Addr().As16()
+	offset := 0
+	if a.Addr().Is4() {
+		offset = 96
+	}
+
+	common := 0
+	for i := offset / 8; i < 16 && common < limit; i++ {
+		if diff := x[i] ^ y[i]; diff != 0 {
+			common += bits.LeadingZeros8(diff)
+			break
+		}
+		common += 8
+	}
+	if common > limit {
+		common = limit
+	}
+	return common
+}
+
+// ParseIPPrefixes 解析單一 IP、CIDR 或 IP 範圍 (例如 "10.0.0.1-10.0.0.20")，
+// 範圍會轉換為涵蓋相同位址的最少 CIDR 集合
+func ParseIPPrefixes(value string) ([]netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, fmt.Errorf("IP 位址不可為空")
+	}
+
+	if start, end, ok := strings.Cut(value, "-"); ok {
+		first, err := netip.ParseAddr(strings.TrimSpace(start))
+		if err != nil {
+			return nil, fmt.Errorf("無效的範圍起點: %s", start)
+		}
+		last, err := netip.ParseAddr(strings.TrimSpace(end))
+		if err != nil {
+			return nil, fmt.Errorf("無效的範圍終點: %s", end)
+		}
+		first, last = first.Unmap().WithZone(""), last.Unmap().WithZone("")
+		if first.Is4() != last.Is4() {
+			return nil, fmt.Errorf("範圍起點與終點的位址族不同: %s", value)
+		}
+		if last.Less(first) {
+			return nil, fmt.Errorf("範圍終點小於起點: %s", value)
+		}
+		return rangeToPrefixes(first, last), nil
+	}
+
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("無效的 CIDR: %s", value)
+		}
+		return []netip.Prefix{normalizePrefix(prefix)}, nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return nil, fmt.Errorf("無效的 IP 位址: %s", value)
+	}
+	addr = addr.Unmap().WithZone("")
+	return []netip.Prefix{netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+// rangeToPrefixes 將 [first, last] 範圍拆為最少的 CIDR
+func rangeToPrefixes(first, last netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for {
+		// 從起點開始，找出起點對齊且不超過終點的最大區塊
+		bitsLen := first.BitLen()
+		for bitsLen > 0 {
+			candidate := netip.PrefixFrom(first, bitsLen-1)
+			if candidate.Masked().Addr() != first || lastAddr(candidate).Compare(last) > 0 {
+				break
+			}
+			bitsLen--
+		}
+		prefix := netip.PrefixFrom(first, bitsLen)
+		prefixes = append(prefixes, prefix)
+
+		end := lastAddr(prefix)
+		if end.Compare(last) >= 0 {
+			return prefixes
+		}
+		first = end.Next()
+	}
+}
+
+// lastAddr 前綴中的最後一個位址
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Masked().Addr().As16()
+	offset := 0
+	if prefix.Addr().Is4() {
+		offset = 96
+	}
+	for i := offset + prefix.Bits(); i < 128; i++ {
+		b[i/8] |= 1 << (7 - uint(i%8))
+	}
+	addr := netip.AddrFrom16(b)
+	if prefix.Addr().Is4() {
+		return addr.Unmap()
+	}
+	return addr
+}
+
+// formatPrefix 單一主機的前綴以純 IP 表示，其餘以 CIDR 表示
+func formatPrefix(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
@@ -0,0 +1,285 @@
+package axiom
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPSetLongestPrefixMatch(t *testing.T) {
+	set := NewIPSet[string]()
+	set.Insert(netip.MustParsePrefix("10.0.0.0/8"), "corp")
+	set.Insert(netip.MustParsePrefix("10.1.0.0/16"), "lab")
+	set.Insert(netip.MustParsePrefix("10.1.2.3/32"), "host")
+	set.Insert(netip.MustParsePrefix("2001:db8::/32"), "v6")
+	set.Insert(netip.MustParsePrefix("2001:db8:1::/48"), "v6-lab")
+	assert.Equal(t, 5, set.Len())
+
+	testCases := []struct {
+		ip       string
+		expected string
+		found    bool
+	}{
+		{"10.9.9.9", "corp", true},
+		{"10.1.9.9", "lab", true},
+		{"10.1.2.3", "host", true},
+		{"::ffff:10.1.2.3", "host", true},
+		{"11.0.0.1", "", false},
+		{"2001:db8:2::1", "v6", true},
+		{"2001:db8:1::1", "v6-lab", true},
+		{"2001:db9::1", "", false},
+		{"not-an-ip", "", false},
+	}
+	for _, tc := range testCases {
+		_, value, found := set.LookupString(tc.ip)
+		assert.Equal(t, tc.found, found, tc.ip)
+		assert.Equal(t, tc.expected, value, tc.ip)
+	}
+
+	// 未遮罩的前綴視為相同前綴
+	set.Insert(netip.MustParsePrefix("10.1.5.5/16"), "lab2")
+	assert.Equal(t, 5, set.Len())
+	value, ok := set.Get(netip.MustParsePrefix("10.1.0.0/16"))
+	assert.True(t, ok)
+	assert.Equal(t, "lab2", value)
+
+	assert.True(t, set.Remove(netip.MustParsePrefix("10.1.0.0/16")))
+	assert.False(t, set.Remove(netip.MustParsePrefix("10.1.0.0/16")))
+	_, value, _ = set.LookupString("10.1.9.9")
+	assert.Equal(t, "corp", value)
+	_, value, _ = set.LookupString("10.1.2.3")
+	assert.Equal(t, "host", value)
+	assert.Equal(t, 4, set.Len())
+
+	var walked []string
+	set.Walk(func(prefix netip.Prefix, _ string) bool {
+		walked = append(walked, prefix.String())
+		return true
+	})
+	assert.Equal(t, []string{"10.0.0.0/8", "10.1.2.3/32", "2001:db8::/32", "2001:db8:1::/48"}, walked)
+}
+
+func TestIPSetMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	set := NewIPSet[int]()
+	prefixes := make(map[netip.Prefix]int)
+
+	randomAddr := func() netip.Addr {
+		// 限制在 10.0.0.0/14 以產生大量重疊
+		return netip.AddrFrom4([4]byte{10, byte(rng.Intn(4)), byte(rng.Intn(256)), byte(rng.Intn(256))})
+	}
+
+	for i := 0; i < 2000; i++ {
+		prefix := netip.PrefixFrom(randomAddr(), 14+rng.Intn(19)).Masked()
+		if rng.Intn(4) == 0 && len(prefixes) > 0 {
+			for existing := range prefixes {
+				assert.True(t, set.Remove(existing))
+				delete(prefixes, existing)
+				break
+			}
+			continue
+		}
+		set.Insert(prefix, i)
+		prefixes[prefix] = i
+	}
+	require.Equal(t, len(prefixes), set.Len())
+
+	for i := 0; i < 5000; i++ {
+		addr := randomAddr()
+		bestBits, bestValue, expectedFound := -1, 0, false
+		for prefix, value := range prefixes {
+			if prefix.Contains(addr) && prefix.Bits() > bestBits {
+				bestBits, bestValue, expectedFound = prefix.Bits(), value, true
+			}
+		}
+
+		prefix, value, found := set.Lookup(addr)
+		require.Equal(t, expectedFound, found, addr.String())
+		if found {
+			require.Equal(t, bestBits, prefix.Bits(), addr.String())
+			require.Equal(t, bestValue, value, addr.String())
+		}
+	}
+}
+
+func TestParseIPPrefixes(t *testing.T) {
+	prefixes, err := ParseIPPrefixes("10.0.0.1-10.0.0.20")
+	require.NoError(t, err)
+	var actual []string
+	for _, prefix := range prefixes {
+		actual = append(actual, prefix.String())
+	}
+	assert.Equal(t, []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/30", "10.0.0.8/29", "10.0.0.16/30", "10.0.0.20/32"}, actual)
+
+	prefixes, err = ParseIPPrefixes("0.0.0.0-255.255.255.255")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")}, prefixes)
+
+	prefixes, err = ParseIPPrefixes("2001:db8::/64")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("2001:db8::/64")}, prefixes)
+
+	prefixes, err = ParseIPPrefixes("192.168.1.77/24")
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.0/24", formatPrefix(prefixes[0]))
+
+	prefixes, err = ParseIPPrefixes("fe80::1%eth0")
+	require.NoError(t, err)
+	assert.Equal(t, "fe80::1", formatPrefix(prefixes[0]))
+
+	for _, invalid := range []string{"", "10.0.0.300", "10.0.0.0/33", "10.0.0.9-10.0.0.1", "10.0.0.1-2001:db8::1"} {
+		_, err := ParseIPPrefixes(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestEngineIPListPrecedence(t *testing.T) {
+	engine, _ := newBehaviorEngine(t)
+
+	require.NoError(t, engine.AddToWhitelist("10.0.0.0/8"))
+	require.NoError(t, engine.AddToBlacklist("10.6.6.0/24", time.Hour))
+	require.NoError(t, engine.AddToBlacklist("2001:db8::1-2001:db8::ff", time.Hour))
+	assert.Error(t, engine.AddToBlacklist("10.0.0.0/99", time.Hour))
+
+	analyze := func(ip string) *AnalysisResult {
+		result, err := engine.AnalyzePacket(&NetworkPacket{SourceIP: ip, DestIP: "172.16.0.44", DestPort: 80, Protocol: "TCP"})
+		require.NoError(t, err)
+		return result
+	}
+
+	assert.Equal(t, "IP在白名單中", analyze("10.1.1.1").Details)
+	assert.True(t, analyze("10.6.6.9").Blocked, "較精確的黑名單前綴優先")
+	assert.True(t, analyze("2001:db8::42").Blocked)
+	assert.False(t, analyze("2001:db8::100").Blocked)
+
+	// 白名單較精確時優先
+	require.NoError(t, engine.AddToWhitelist("10.6.6.9"))
+	assert.Equal(t, "IP在白名單中", analyze("10.6.6.9").Details)
+
+	assert.Equal(t, []string{"10.0.0.0/8", "10.6.6.9"}, engine.GetWhitelist())
+	require.NoError(t, engine.RemoveFromBlacklist("10.6.6.0/24"))
+	assert.Error(t, engine.RemoveFromBlacklist("10.6.6.0/24"))
+}
+
+func TestIPRuleCIDRPattern(t *testing.T) {
+	engine, _ := newBehaviorEngine(t, &SecurityRule{
+		ID:       "tor_exit_nodes",
+		Name:     "已知惡意網段",
+		Type:     "ip",
+		Pattern:  "198.51.100.0/24, 2001:db8:bad::/48, 203.0.113.10-203.0.113.20",
+		Action:   "block",
+		Severity: "high",
+		Enabled:  true,
+	})
+
+	for ip, expected := range map[string]bool{
+		"198.51.100.77":    true,
+		"198.51.101.1":     false,
+		"2001:db8:bad::9":  true,
+		"2001:db8:bad1::9": false,
+		"203.0.113.15":     true,
+		"203.0.113.21":     false,
+	} {
+		result, err := engine.AnalyzePacket(&NetworkPacket{SourceIP: ip, DestIP: "172.16.0.44", DestPort: 443, Protocol: "TCP"})
+		require.NoError(t, err)
+		assert.Equal(t, expected, result.Blocked, ip)
+	}
+}
+
+func TestUIServerBlockedIPs(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	promMetrics := metrics.NewPrometheusMetrics(logger)
+	engine := NewAnalysisEngine(logger, promMetrics)
+	ui := NewUIServer(logger, promMetrics)
+	ui.SetAnalysisEngine(engine)
+	router := ui.setupRouter()
+
+	request := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var payload bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&payload).Encode(body))
+		}
+		req := httptest.NewRequest(method, path, &payload)
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/api/v1/network/blocked-ips", BlockIPRequest{IP: "2001:db8::/64", DurationSeconds: 60}).Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/api/v1/network/blocked-ips", BlockIPRequest{IP: "10.0.0.1-bad"}).Code)
+
+	recorder := request(http.MethodGet, "/api/v1/network/blocked-ips", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var response struct {
+		BlockedIPs []map[string]interface{} `json:"blocked_ips"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	require.Len(t, response.BlockedIPs, 1)
+	assert.Equal(t, "2001:db8::/64", response.BlockedIPs[0]["ip"])
+
+	// 網路狀態與指標取自引擎，而非固定數值
+	require.NoError(t, engine.AddToWhitelist("192.0.2.0/24"))
+	recorder = request(http.MethodGet, "/api/v1/control/network/status", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var status map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.EqualValues(t, 1, status["blocked_ips"])
+	assert.EqualValues(t, len(engine.GetWhitelist()), status["allowed_ips"])
+
+	recorder = request(http.MethodGet, "/api/v1/metrics", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var counters map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &counters))
+	assert.EqualValues(t, 0, counters["threats_detected"])
+	assert.EqualValues(t, 0, counters["blocked_connections"])
+	assert.EqualValues(t, 0, counters["packets_inspected"])
+
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/api/v1/network/blocked-ips/2001:db8::/64", nil).Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/api/v1/network/blocked-ips/2001:db8::/64", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodDelete, "/api/v1/network/blocked-ips/not-an-ip", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodDelete, "/api/v1/network/blocked-ips/", nil).Code)
+	assert.Empty(t, engine.GetBlacklist())
+}
+
+func TestBlacklistExpiresOnEngineClock(t *testing.T) {
+	engine, clock := newBehaviorEngine(t)
+	require.NoError(t, engine.AddToBlacklist("203.0.113.7", time.Minute))
+
+	_, blacklisted := engine.checkLists("203.0.113.7")
+	assert.True(t, blacklisted)
+	require.Len(t, engine.GetBlacklist(), 1)
+
+	// 到期以引擎時鐘判斷，而非牆上時間
+	clock.Advance(2 * time.Minute)
+	_, blacklisted = engine.checkLists("203.0.113.7")
+	assert.False(t, blacklisted)
+	assert.Empty(t, engine.GetBlacklist())
+}
+
+func TestExpiredSpecificBlockDoesNotHideBroaderBlock(t *testing.T) {
+	engine, clock := newBehaviorEngine(t)
+	require.NoError(t, engine.AddToBlacklist("203.0.113.0/24", time.Hour))
+	require.NoError(t, engine.AddToBlacklist("203.0.113.7", time.Minute))
+
+	clock.Advance(2 * time.Minute)
+	_, blacklisted := engine.checkLists("203.0.113.7")
+	assert.True(t, blacklisted, "/32 已過期時仍受 /24 封鎖")
+
+	// 較大的白名單網段不會因過期的 /32 而勝過有效的 /24 封鎖
+	require.NoError(t, engine.AddToWhitelist("203.0.0.0/16"))
+	_, blacklisted = engine.checkLists("203.0.113.7")
+	assert.True(t, blacklisted)
+}
@@ -0,0 +1,258 @@
+package axiom
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultFeedSize 即時事件環狀緩衝區預設保留的訊息數量
+const defaultFeedSize = 1000
+
+// maxPollTimeout 長輪詢最長等待時間
+const maxPollTimeout = 60 * time.Second
+
+// feedTopics 寫入環狀緩衝區、可透過 SSE 與長輪詢續傳的主題
+var feedTopics = []string{TopicAlerts, TopicEvents, TopicNetwork}
+
+// liveFeed 最近即時訊息的環狀緩衝區，依序編號供斷線後以 Last-Event-ID 續傳
+type liveFeed struct {
+	mutex   sync.Mutex
+	entries []*livePublication
+	start   int    // 最舊一筆的位置
+	count   int    // 目前保留的數量
+	lastID  uint64 // 最後一筆的編號
+	changed chan struct{}
+}
+
+// newLiveFeed 建立環狀緩衝區
+func newLiveFeed(size int) *liveFeed {
+	return &liveFeed{
+		entries: make([]*livePublication, size),
+		changed: make(chan struct{}),
+	}
+}
+
+// append 為訊息編號並寫入緩衝區，緩衝區已滿時覆寫最舊的一筆，並喚醒等待中的讀取者
+func (f *liveFeed) append(pub *livePublication) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.lastID++
+	pub.id = f.lastID
+	pub.message.ID = pub.id
+
+	end := (f.start + f.count) % len(f.entries)
+	f.entries[end] = pub
+	if f.count < len(f.entries) {
+		f.count++
+	} else {
+		f.start = (f.start + 1) % len(f.entries)
+	}
+
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// since 取得編號大於 lastID 的訊息與新的讀取位置；missed 表示中間有訊息已被覆寫，
+// 或 lastID 超過目前編號 (伺服器重新啟動後的舊編號)。changed 在下一筆訊息寫入時關閉
+func (f *liveFeed) since(lastID uint64) (pubs []*livePublication, cursor uint64, missed bool, changed <-chan struct{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	changed = f.changed
+	oldest := f.lastID - uint64(f.count) + 1
+	if lastID > f.lastID || lastID+1 < oldest {
+		missed = true
+		lastID = oldest - 1
+	}
+	for id := lastID + 1; id <= f.lastID; id++ {
+		pubs = append(pubs, f.entries[(f.start+int(id-oldest))%len(f.entries)])
+	}
+	return pubs, f.lastID, missed, changed
+}
+
+// latest 最後一筆的編號
+func (f *liveFeed) latest() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.lastID
+}
+
+// parseFeedSubscriptions 由查詢參數建立訂閱：topics 以逗號分隔 (預設為所有可續傳主題)，
+// min_severity、agent_id、source_ip 與 rule_id 套用到每個主題
+func parseFeedSubscriptions(c *gin.Context) ([]Subscription, error) {
+	topics := feedTopics
+	if value := c.Query("topics"); value != "" {
+		topics = strings.Split(value, ",")
+	}
+
+	subs := make([]Subscription, 0, len(topics))
+	for _, topic := range topics {
+		sub := Subscription{
+			Topic:       strings.TrimSpace(topic),
+			MinSeverity: c.Query("min_severity"),
+			AgentID:     c.Query("agent_id"),
+			SourceIP:    c.Query("source_ip"),
+			RuleID:      c.Query("rule_id"),
+		}
+		if err := sub.validate(); err != nil {
+			return nil, err
+		}
+		buffered := false
+		for _, feedTopic := range feedTopics {
+			buffered = buffered || feedTopic == sub.Topic
+		}
+		if !buffered {
+			return nil, fmt.Errorf("主題不支援串流: %s", sub.Topic)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// parseLastEventID 取得續傳起點：Last-Event-ID 標頭優先，其次為 last_event_id 查詢參數
+func parseLastEventID(c *gin.Context, param string) (uint64, bool, error) {
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query(param)
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("無效的事件編號: %s", value)
+	}
+	return id, true, nil
+}
+
+// matchesAny 檢查訊息是否符合任一訂閱
+func matchesAny(subs []Subscription, pub *livePublication) bool {
+	for _, sub := range subs {
+		if sub.matches(pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamEvents 以 Server-Sent Events 串流即時訊息；帶 Last-Event-ID 重新連線時
+// 先補送緩衝區中遺漏的訊息，遺漏的訊息已被覆寫時送出 reset 事件
+func (ui *UIServer) streamEvents(c *gin.Context) {
+	subs, err := parseFeedSubscriptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lastID, resume, err := parseLastEventID(c, "last_event_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !resume {
+		lastID = ui.feed.latest()
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(ui.wsConfig.PingInterval)
+	defer heartbeat.Stop()
+
+	for {
+		pubs, cursor, missed, changed := ui.feed.since(lastID)
+		if missed {
+			fmt.Fprintf(c.Writer, "event: reset\ndata: {\"missed_after\":%d}\n\n", lastID)
+		}
+		lastID = cursor
+		for _, pub := range pubs {
+			if !matchesAny(subs, pub) {
+				continue
+			}
+			data, err := json.Marshal(pub.message)
+			if err != nil {
+				ui.logger.Warnf("序列化即時訊息失敗: %v", err)
+				continue
+			}
+			fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", pub.id, pub.message.Type, data)
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-changed:
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": keepalive\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// pollEvents 長輪詢：回傳編號大於 since 的訊息，沒有新訊息時最多等待 timeout (預設 25s)
+func (ui *UIServer) pollEvents(c *gin.Context) {
+	subs, err := parseFeedSubscriptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lastID, resume, err := parseLastEventID(c, "since")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !resume {
+		lastID = ui.feed.latest()
+	}
+	timeout := 25 * time.Second
+	if value := c.Query("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout < 0 || timeout > maxPollTimeout {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("無效的等待時間: %s", value)})
+			return
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	messages := make([]WebSocketMessage, 0)
+	missedAny := false
+wait:
+	for {
+		pubs, cursor, missed, changed := ui.feed.since(lastID)
+		missedAny = missedAny || missed
+		lastID = cursor
+		for _, pub := range pubs {
+			if matchesAny(subs, pub) {
+				messages = append(messages, pub.message)
+			}
+		}
+		if len(messages) > 0 || missedAny {
+			break wait
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-timer.C:
+			break wait
+		case <-changed:
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": messages, "last_event_id": lastID, "missed": missedAny})
+}
@@ -0,0 +1,164 @@
+package axiom
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveFeedRing(t *testing.T) {
+	feed := newLiveFeed(3)
+
+	pubs, cursor, missed, _ := feed.since(0)
+	assert.Empty(t, pubs)
+	assert.Zero(t, cursor)
+	assert.False(t, missed)
+
+	for i := 0; i < 5; i++ {
+		feed.append(&livePublication{topic: TopicEvents, message: WebSocketMessage{Type: "security_event", Data: i}})
+	}
+
+	pubs, cursor, missed, _ = feed.since(0)
+	assert.True(t, missed, "編號 1、2 已被覆寫")
+	assert.Equal(t, uint64(5), cursor)
+	require.Len(t, pubs, 3)
+	assert.Equal(t, uint64(3), pubs[0].id)
+	assert.Equal(t, uint64(3), pubs[0].message.ID)
+
+	pubs, _, missed, _ = feed.since(4)
+	assert.False(t, missed)
+	require.Len(t, pubs, 1)
+	assert.Equal(t, 4, pubs[0].message.Data)
+
+	pubs, _, _, changed := feed.since(5)
+	assert.Empty(t, pubs)
+	feed.append(&livePublication{topic: TopicEvents})
+	select {
+	case <-changed:
+	default:
+		t.Fatal("寫入新訊息時應喚醒讀取者")
+	}
+
+	// 伺服器重新啟動後客戶端帶著較大的舊編號
+	pubs, cursor, missed, _ = feed.since(100)
+	assert.True(t, missed)
+	assert.Len(t, pubs, 3)
+	assert.Equal(t, uint64(6), cursor)
+}
+
+func TestStreamEventsResume(t *testing.T) {
+	engine, _ := newBehaviorEngine(t,
+		&SecurityRule{ID: "sqli", Name: "SQL注入", Type: "pattern", Pattern: "union select", Action: "block", Severity: "critical", Enabled: true},
+		&SecurityRule{ID: "robots", Name: "robots", Type: "pattern", Pattern: "robots.txt", Action: "log", Severity: "low", Enabled: true},
+	)
+	ui := NewUIServer(engine.logger, engine.metrics)
+	ui.SetAnalysisEngine(engine)
+	server := httptest.NewServer(ui.setupRouter())
+	defer server.Close()
+
+	// 斷線期間產生的訊息：1 events/sqli、2 alerts/sqli、3 events/robots
+	analyze(t, engine, tuningPacket("203.0.113.1", 80, "id=1 union select"))
+	analyze(t, engine, tuningPacket("203.0.113.2", 80, "GET /robots.txt"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/stream/events?topics=events", nil)
+	require.NoError(t, err)
+	request.Header.Set("Last-Event-ID", "1")
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(response.Body)
+	next := func() (string, WebSocketMessage) {
+		var id string
+		var message WebSocketMessage
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &message))
+			case line == "" && id != "":
+				return id, message
+			}
+		}
+	}
+
+	id, message := next()
+	assert.Equal(t, "3", id, "只補送 Last-Event-ID 之後且符合主題的訊息")
+	assert.Equal(t, "security_event", message.Type)
+	assert.Equal(t, uint64(3), message.ID)
+
+	analyze(t, engine, tuningPacket("203.0.113.3", 80, "id=2 union select"))
+	id, message = next()
+	assert.Equal(t, "4", id)
+	assert.Equal(t, TopicEvents, message.Topic)
+
+	recorder := httptest.NewRecorder()
+	ui.setupRouter().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/stream/events?topics=metrics", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "定期更新的主題不寫入緩衝區")
+}
+
+func TestPollEvents(t *testing.T) {
+	engine, _ := newBehaviorEngine(t)
+	ui := NewUIServer(engine.logger, engine.metrics)
+	router := ui.setupRouter()
+
+	type pollResponse struct {
+		Events      []WebSocketMessage `json:"events"`
+		LastEventID uint64             `json:"last_event_id"`
+		Missed      bool               `json:"missed"`
+	}
+	poll := func(query string) (int, pollResponse) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/stream/poll"+query, nil))
+		var body pollResponse
+		if recorder.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		}
+		return recorder.Code, body
+	}
+
+	ui.publish(TopicNetwork, "ip_blocked", map[string]interface{}{"ip": "203.0.113.9"}, messageAttributes{SourceIP: "203.0.113.9"})
+	ui.publish(TopicAlerts, "alert", nil, messageAttributes{Severity: "low"})
+
+	code, body := poll("?since=0&min_severity=high&timeout=10ms")
+	require.Equal(t, http.StatusOK, code)
+	assert.Empty(t, body.Events, "低於 min_severity 的告警被過濾，網路事件沒有嚴重程度")
+	assert.Equal(t, uint64(2), body.LastEventID)
+
+	code, body = poll("?since=0&topics=network")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, body.Events, 1)
+	assert.Equal(t, "ip_blocked", body.Events[0].Type)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ui.publish(TopicAlerts, "alert", nil, messageAttributes{Severity: "critical"})
+	}()
+	start := time.Now()
+	code, body = poll("?since=2&timeout=5s")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, body.Events, 1, "等待期間的新訊息立即回傳")
+	assert.Equal(t, uint64(3), body.LastEventID)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	for _, query := range []string{"?since=abc", "?timeout=10m", "?topics=devices", "?min_severity=urgent"} {
+		code, _ = poll(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
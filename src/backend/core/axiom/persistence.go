@@ -0,0 +1,542 @@
+package axiom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StateStore 分析引擎狀態 (規則、黑名單、白名單) 的持久化介面
+type StateStore interface {
+	// LoadState 讀取所有已保存的狀態
+	LoadState(ctx context.Context) (*EngineState, error)
+	// SaveRule 新增或更新規則
+	SaveRule(ctx context.Context, rule *SecurityRule) error
+	// DeleteRule 刪除規則
+	DeleteRule(ctx context.Context, ruleID string) error
+	// SaveBlacklistEntry 新增或更新黑名單項目
+	SaveBlacklistEntry(ctx context.Context, ip string, expiresAt time.Time) error
+	// DeleteBlacklistEntry 刪除黑名單項目
+	DeleteBlacklistEntry(ctx context.Context, ip string) error
+	// SaveWhitelistEntry 新增白名單項目
+	SaveWhitelistEntry(ctx context.Context, ip string) error
+	// DeleteWhitelistEntry 刪除白名單項目
+	DeleteWhitelistEntry(ctx context.Context, ip string) error
+	// SaveSuppression 新增或更新誤報抑制條件
+	SaveSuppression(ctx context.Context, suppression *Suppression) error
+	// DeleteSuppression 刪除誤報抑制條件
+	DeleteSuppression(ctx context.Context, id string) error
+	// Close 釋放資源
+	Close() error
+}
+
+// EngineState 可持久化的引擎狀態
+type EngineState struct {
+	Rules        []*SecurityRule      `json:"rules"`
+	Blacklist    map[string]time.Time `json:"blacklist"` // IP -> 到期時間
+	Whitelist    []string             `json:"whitelist"`
+	Suppressions []*Suppression       `json:"suppressions"`
+}
+
+// fileStateVersion 狀態檔格式版本
+const fileStateVersion = 1
+
+// fileState 狀態檔內容
+type fileState struct {
+	Version      int                      `json:"version"`
+	Rules        map[string]*SecurityRule `json:"rules"`
+	Blacklist    map[string]time.Time     `json:"blacklist"`
+	Whitelist    map[string]time.Time     `json:"whitelist"` // IP -> 加入時間
+	Suppressions map[string]*Suppression  `json:"suppressions,omitempty"`
+}
+
+// FileStateStore 以單一 JSON 檔保存狀態，每次變更以「寫入暫存檔後更名」方式原子替換
+type FileStateStore struct {
+	path  string
+	state *fileState
+	mutex sync.Mutex
+	// batching 大於 0 時延後寫檔，dirty 記錄是否有未寫入的變更
+	batching int
+	dirty    bool
+}
+
+// NewFileStateStore 建立檔案狀態儲存，檔案不存在時會在第一次寫入時建立
+func NewFileStateStore(path string) (*FileStateStore, error) {
+	store := &FileStateStore{
+		path: path,
+		state: &fileState{
+			Version:      fileStateVersion,
+			Rules:        make(map[string]*SecurityRule),
+			Blacklist:    make(map[string]time.Time),
+			Whitelist:    make(map[string]time.Time),
+			Suppressions: make(map[string]*Suppression),
+		},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("讀取狀態檔失敗: %v", err)
+	}
+
+	if err := json.Unmarshal(data, store.state); err != nil {
+		return nil, fmt.Errorf("解析狀態檔失敗: %v", err)
+	}
+	if store.state.Version > fileStateVersion {
+		return nil, fmt.Errorf("不支援的狀態檔版本: %d", store.state.Version)
+	}
+	if store.state.Rules == nil {
+		store.state.Rules = make(map[string]*SecurityRule)
+	}
+	if store.state.Blacklist == nil {
+		store.state.Blacklist = make(map[string]time.Time)
+	}
+	if store.state.Whitelist == nil {
+		store.state.Whitelist = make(map[string]time.Time)
+	}
+	if store.state.Suppressions == nil {
+		store.state.Suppressions = make(map[string]*Suppression)
+	}
+	return store, nil
+}
+
+// LoadState 讀取所有已保存的狀態
+func (fs *FileStateStore) LoadState(ctx context.Context) (*EngineState, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	state := &EngineState{
+		Rules:        make([]*SecurityRule, 0, len(fs.state.Rules)),
+		Blacklist:    make(map[string]time.Time, len(fs.state.Blacklist)),
+		Whitelist:    make([]string, 0, len(fs.state.Whitelist)),
+		Suppressions: make([]*Suppression, 0, len(fs.state.Suppressions)),
+	}
+	for _, rule := range fs.state.Rules {
+		copied := *rule
+		state.Rules = append(state.Rules, &copied)
+	}
+	sort.Slice(state.Rules, func(i, j int) bool { return state.Rules[i].CreatedAt.Before(state.Rules[j].CreatedAt) })
+	for ip, expiresAt := range fs.state.Blacklist {
+		state.Blacklist[ip] = expiresAt
+	}
+	for ip := range fs.state.Whitelist {
+		state.Whitelist = append(state.Whitelist, ip)
+	}
+	sort.Strings(state.Whitelist)
+	for _, suppression := range fs.state.Suppressions {
+		copied := *suppression
+		state.Suppressions = append(state.Suppressions, &copied)
+	}
+	sort.Slice(state.Suppressions, func(i, j int) bool {
+		return state.Suppressions[i].CreatedAt.Before(state.Suppressions[j].CreatedAt)
+	})
+	return state, nil
+}
+
+// SaveRule 新增或更新規則
+func (fs *FileStateStore) SaveRule(ctx context.Context, rule *SecurityRule) error {
+	return fs.update(func(state *fileState) {
+		copied := *rule
+		state.Rules[rule.ID] = &copied
+	})
+}
+
+// DeleteRule 刪除規則
+func (fs *FileStateStore) DeleteRule(ctx context.Context, ruleID string) error {
+	return fs.update(func(state *fileState) {
+		delete(state.Rules, ruleID)
+	})
+}
+
+// SaveBlacklistEntry 新增或更新黑名單項目
+func (fs *FileStateStore) SaveBlacklistEntry(ctx context.Context, ip string, expiresAt time.Time) error {
+	return fs.update(func(state *fileState) {
+		state.Blacklist[ip] = expiresAt
+	})
+}
+
+// DeleteBlacklistEntry 刪除黑名單項目
+func (fs *FileStateStore) DeleteBlacklistEntry(ctx context.Context, ip string) error {
+	return fs.update(func(state *fileState) {
+		delete(state.Blacklist, ip)
+	})
+}
+
+// SaveWhitelistEntry 新增白名單項目
+func (fs *FileStateStore) SaveWhitelistEntry(ctx context.Context, ip string) error {
+	return fs.update(func(state *fileState) {
+		if _, exists := state.Whitelist[ip]; !exists {
+			state.Whitelist[ip] = time.Now()
+		}
+	})
+}
+
+// DeleteWhitelistEntry 刪除白名單項目
+func (fs *FileStateStore) DeleteWhitelistEntry(ctx context.Context, ip string) error {
+	return fs.update(func(state *fileState) {
+		delete(state.Whitelist, ip)
+	})
+}
+
+// SaveSuppression 新增或更新誤報抑制條件
+func (fs *FileStateStore) SaveSuppression(ctx context.Context, suppression *Suppression) error {
+	return fs.update(func(state *fileState) {
+		copied := *suppression
+		state.Suppressions[suppression.ID] = &copied
+	})
+}
+
+// DeleteSuppression 刪除誤報抑制條件
+func (fs *FileStateStore) DeleteSuppression(ctx context.Context, id string) error {
+	return fs.update(func(state *fileState) {
+		delete(state.Suppressions, id)
+	})
+}
+
+// Close 檔案儲存無需釋放資源
+func (fs *FileStateStore) Close() error {
+	return nil
+}
+
+// update 修改狀態並寫回檔案
+func (fs *FileStateStore) update(modify func(state *fileState)) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	modify(fs.state)
+	if fs.batching > 0 {
+		fs.dirty = true
+		return nil
+	}
+	return fs.flush()
+}
+
+// Batch 執行 fn 內的所有變更後只寫入一次狀態檔
+func (fs *FileStateStore) Batch(fn func() error) error {
+	fs.mutex.Lock()
+	fs.batching++
+	fs.mutex.Unlock()
+
+	err := fn()
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.batching--
+	if fs.batching == 0 && fs.dirty {
+		fs.dirty = false
+		if flushErr := fs.flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// flush 原子寫入狀態檔 (呼叫者需持有鎖)
+func (fs *FileStateStore) flush() error {
+	data, err := json.MarshalIndent(fs.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化狀態失敗: %v", err)
+	}
+
+	dir := filepath.Dir(fs.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("建立狀態目錄失敗: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fs.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("建立暫存檔失敗: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("寫入暫存檔失敗: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("同步暫存檔失敗: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("關閉暫存檔失敗: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), fs.path); err != nil {
+		return fmt.Errorf("替換狀態檔失敗: %v", err)
+	}
+	return nil
+}
+
+// persistTimeout 單次持久化操作的逾時
+const persistTimeout = 5 * time.Second
+
+const (
+	// persistQueueSize 持久化佇列長度；佇列已滿時丟棄變更並記錄錯誤，避免阻塞封包分析
+	persistQueueSize = 4096
+	// persistBatchSize 單次批次寫入的最大操作數
+	persistBatchSize = 256
+)
+
+// batchStateStore 可將多個變更合併為一次寫入的儲存
+type batchStateStore interface {
+	// Batch 執行 fn 內的所有變更後才寫入
+	Batch(fn func() error) error
+}
+
+// persistOp 佇列中的持久化操作；done 不為 nil 時為排空標記
+type persistOp struct {
+	operation string
+	store     StateStore
+	fn        func(ctx context.Context, store StateStore) error
+	done      chan struct{}
+}
+
+// stateWriter 在背景依序執行持久化操作，並將佇列中累積的操作合併為批次
+type stateWriter struct {
+	logger *logrus.Logger
+	queue  chan persistOp
+}
+
+// newStateWriter 建立並啟動背景寫入器
+func newStateWriter(logger *logrus.Logger) *stateWriter {
+	w := &stateWriter{
+		logger: logger,
+		queue:  make(chan persistOp, persistQueueSize),
+	}
+	go w.run()
+	return w
+}
+
+// enqueue 不阻塞地排入操作，佇列已滿時回傳 false
+func (w *stateWriter) enqueue(op persistOp) bool {
+	select {
+	case w.queue <- op:
+		return true
+	default:
+		return false
+	}
+}
+
+// run 取出操作並以批次寫入
+func (w *stateWriter) run() {
+	for op := range w.queue {
+		batch := []persistOp{op}
+	drain:
+		for len(batch) < persistBatchSize {
+			select {
+			case next := <-w.queue:
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		w.write(batch)
+	}
+}
+
+// write 執行一個批次；同一儲存的連續操作若支援批次則只寫入一次
+func (w *stateWriter) write(batch []persistOp) {
+	for start := 0; start < len(batch); {
+		end := start + 1
+		for end < len(batch) && batch[end].store == batch[start].store {
+			end++
+		}
+		w.writeRun(batch[start:end])
+		start = end
+	}
+}
+
+// writeRun 執行同一儲存的連續操作
+func (w *stateWriter) writeRun(ops []persistOp) {
+	apply := func() error {
+		for _, op := range ops {
+			if op.done != nil {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+			if err := op.fn(ctx, op.store); err != nil {
+				w.logger.Errorf("持久化%s失敗: %v", op.operation, err)
+			}
+			cancel()
+		}
+		return nil
+	}
+
+	if batcher, ok := ops[0].store.(batchStateStore); ok {
+		if err := batcher.Batch(apply); err != nil {
+			w.logger.Errorf("寫入引擎狀態失敗: %v", err)
+		}
+	} else {
+		apply()
+	}
+
+	for _, op := range ops {
+		if op.done != nil {
+			close(op.done)
+		}
+	}
+}
+
+// flush 等待目前已排入的操作全部寫入
+func (w *stateWriter) flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case w.queue <- persistOp{done: done}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetStateStore 設定狀態持久化儲存；需在 Start 之前呼叫才會於啟動時恢復狀態
+func (ae *AnalysisEngine) SetStateStore(store StateStore) {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	ae.store = store
+	if store != nil && ae.writer == nil {
+		ae.writer = newStateWriter(ae.logger)
+	}
+}
+
+// FlushState 等待已排入佇列的持久化操作寫入完成
+func (ae *AnalysisEngine) FlushState(ctx context.Context) error {
+	ae.mutex.RLock()
+	writer := ae.writer
+	ae.mutex.RUnlock()
+
+	if writer == nil {
+		return nil
+	}
+	return writer.flush(ctx)
+}
+
+// persist 將持久化操作排入背景寫入器；失敗只記錄錯誤，不影響記憶體中的狀態
+func (ae *AnalysisEngine) persist(operation string, fn func(ctx context.Context, store StateStore) error) {
+	if ae.store == nil {
+		return
+	}
+
+	if !ae.writer.enqueue(persistOp{operation: operation, store: ae.store, fn: fn}) {
+		ae.logger.Errorf("持久化佇列已滿，丟棄%s變更", operation)
+	}
+}
+
+// restoreState 從儲存恢復規則、黑名單與白名單 (呼叫者需持有寫鎖)
+func (ae *AnalysisEngine) restoreState(ctx context.Context) error {
+	state, err := ae.store.LoadState(ctx)
+	if err != nil {
+		return err
+	}
+
+	restored := 0
+	for _, rule := range state.Rules {
+		if err := prepareStoredRule(rule); err != nil {
+			ae.logger.Errorf("恢復規則 %s 失敗: %v", rule.ID, err)
+			continue
+		}
+
+		// 已保存的規則覆寫同 ID 的預設規則 (例如被停用的預設規則)
+		replaced := false
+		for i, existing := range ae.rules {
+			if existing.ID == rule.ID {
+				ae.rules[i] = rule
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			ae.rules = append(ae.rules, rule)
+		}
+		restored++
+	}
+	ae.rulesChanged("store")
+
+	ae.listMutex.Lock()
+	defer ae.listMutex.Unlock()
+
+	// 黑名單保留原本的到期時間，已過期的項目直接從儲存移除
+	now := ae.behavior.clock()
+	for ip, expiresAt := range state.Blacklist {
+		if !now.Before(expiresAt) {
+			if err := ae.store.DeleteBlacklistEntry(ctx, ip); err != nil {
+				ae.logger.Errorf("刪除過期黑名單 %s 失敗: %v", ip, err)
+			}
+			continue
+		}
+		prefixes, err := ParseIPPrefixes(ip)
+		if err != nil {
+			ae.logger.Errorf("恢復黑名單 %s 失敗: %v", ip, err)
+			continue
+		}
+		for _, prefix := range prefixes {
+			ae.blacklist.Insert(prefix, expiresAt)
+		}
+	}
+
+	for _, ip := range state.Whitelist {
+		prefixes, err := ParseIPPrefixes(ip)
+		if err != nil {
+			ae.logger.Errorf("恢復白名單 %s 失敗: %v", ip, err)
+			continue
+		}
+		for _, prefix := range prefixes {
+			ae.whitelist.Insert(prefix, true)
+		}
+	}
+
+	ae.tuning.mutex.Lock()
+	defer ae.tuning.mutex.Unlock()
+
+	for _, suppression := range state.Suppressions {
+		if suppression.ExpiresAt != nil && !now.Before(*suppression.ExpiresAt) {
+			if err := ae.store.DeleteSuppression(ctx, suppression.ID); err != nil {
+				ae.logger.Errorf("刪除過期誤報抑制 %s 失敗: %v", suppression.ID, err)
+			}
+			continue
+		}
+		prefix, err := parseSuppressionCIDR(suppression.SourceCIDR)
+		if err != nil {
+			ae.logger.Errorf("恢復誤報抑制 %s 失敗: %v", suppression.ID, err)
+			continue
+		}
+		ae.tuning.add(suppression, prefix)
+	}
+
+	ae.logger.Infof("已恢復引擎狀態: %d 個規則、%d 個黑名單、%d 個白名單、%d 個誤報抑制",
+		restored, ae.blacklist.Len(), ae.whitelist.Len(), len(ae.tuning.suppressions))
+	return nil
+}
+
+// prepareStoredRule 重建規則中未序列化的欄位 (正則表達式、簽章比對結構)
+func prepareStoredRule(rule *SecurityRule) error {
+	if err := compileRule(rule); err != nil {
+		return err
+	}
+
+	if rule.Type == "signature" {
+		if rule.Signature == nil {
+			return fmt.Errorf("簽章規則缺少 signature 欄位")
+		}
+		parsed, err := NewSnortParser(rule.Signature.Variables).ParseRule(rule.Signature.Raw)
+		if err != nil {
+			return err
+		}
+		rule.Signature = parsed.Signature
+	}
+	return nil
+}
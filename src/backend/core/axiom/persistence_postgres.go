@@ -0,0 +1,226 @@
+package axiom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ruleRecord 規則資料表，完整規則以 JSON 保存於 Definition
+type ruleRecord struct {
+	ID         string `gorm:"primaryKey;size:128"`
+	Type       string `gorm:"size:32;index"`
+	Enabled    bool
+	Definition string `gorm:"type:text;not null"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// TableName 資料表名稱
+func (ruleRecord) TableName() string {
+	return "axiom_rules"
+}
+
+// blacklistRecord 黑名單資料表
+type blacklistRecord struct {
+	IP        string    `gorm:"primaryKey;size:64"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
+
+// TableName 資料表名稱
+func (blacklistRecord) TableName() string {
+	return "axiom_blacklist"
+}
+
+// whitelistRecord 白名單資料表
+type whitelistRecord struct {
+	IP        string `gorm:"primaryKey;size:64"`
+	CreatedAt time.Time
+}
+
+// TableName 資料表名稱
+func (whitelistRecord) TableName() string {
+	return "axiom_whitelist"
+}
+
+// suppressionRecord 誤報抑制資料表
+type suppressionRecord struct {
+	ID         string `gorm:"primaryKey;size:64"`
+	RuleID     string `gorm:"size:128;index;not null"`
+	SourceCIDR string `gorm:"size:64;not null"`
+	DestPort   int
+	Reason     string `gorm:"type:text"`
+	PacketID   string `gorm:"size:64"`
+	ExpiresAt  *time.Time
+	Hits       int64
+	CreatedAt  time.Time
+}
+
+// TableName 資料表名稱
+func (suppressionRecord) TableName() string {
+	return "axiom_suppressions"
+}
+
+// PostgresStateStore 以 PostgreSQL 保存引擎狀態。
+// db 通常為 axiom-api 的 database.Database.PG，連線生命週期由呼叫者管理
+type PostgresStateStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresStateStore 建立 PostgreSQL 狀態儲存並自動遷移資料表
+func NewPostgresStateStore(db *gorm.DB) (*PostgresStateStore, error) {
+	if db == nil {
+		return nil, fmt.Errorf("資料庫連線不可為空")
+	}
+	if err := db.AutoMigrate(&ruleRecord{}, &blacklistRecord{}, &whitelistRecord{}, &suppressionRecord{}); err != nil {
+		return nil, fmt.Errorf("遷移引擎狀態資料表失敗: %v", err)
+	}
+	return &PostgresStateStore{db: db}, nil
+}
+
+// LoadState 讀取所有已保存的狀態，並刪除已過期的黑名單
+func (ps *PostgresStateStore) LoadState(ctx context.Context) (*EngineState, error) {
+	db := ps.db.WithContext(ctx)
+
+	var rules []ruleRecord
+	if err := db.Order("created_at").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("讀取規則失敗: %v", err)
+	}
+
+	if err := db.Where("expires_at <= ?", time.Now()).Delete(&blacklistRecord{}).Error; err != nil {
+		return nil, fmt.Errorf("刪除過期黑名單失敗: %v", err)
+	}
+	var blacklist []blacklistRecord
+	if err := db.Find(&blacklist).Error; err != nil {
+		return nil, fmt.Errorf("讀取黑名單失敗: %v", err)
+	}
+
+	var whitelist []whitelistRecord
+	if err := db.Order("ip").Find(&whitelist).Error; err != nil {
+		return nil, fmt.Errorf("讀取白名單失敗: %v", err)
+	}
+
+	var suppressions []suppressionRecord
+	if err := db.Order("created_at").Find(&suppressions).Error; err != nil {
+		return nil, fmt.Errorf("讀取誤報抑制失敗: %v", err)
+	}
+
+	state := &EngineState{
+		Rules:        make([]*SecurityRule, 0, len(rules)),
+		Blacklist:    make(map[string]time.Time, len(blacklist)),
+		Whitelist:    make([]string, 0, len(whitelist)),
+		Suppressions: make([]*Suppression, 0, len(suppressions)),
+	}
+	for _, record := range rules {
+		rule := &SecurityRule{}
+		if err := json.Unmarshal([]byte(record.Definition), rule); err != nil {
+			return nil, fmt.Errorf("解析規則 %s 失敗: %v", record.ID, err)
+		}
+		state.Rules = append(state.Rules, rule)
+	}
+	for _, record := range blacklist {
+		state.Blacklist[record.IP] = record.ExpiresAt
+	}
+	for _, record := range whitelist {
+		state.Whitelist = append(state.Whitelist, record.IP)
+	}
+	for _, record := range suppressions {
+		state.Suppressions = append(state.Suppressions, &Suppression{
+			ID:         record.ID,
+			RuleID:     record.RuleID,
+			SourceCIDR: record.SourceCIDR,
+			DestPort:   record.DestPort,
+			Reason:     record.Reason,
+			PacketID:   record.PacketID,
+			CreatedAt:  record.CreatedAt,
+			ExpiresAt:  record.ExpiresAt,
+			Hits:       record.Hits,
+		})
+	}
+	return state, nil
+}
+
+// SaveRule 新增或更新規則
+func (ps *PostgresStateStore) SaveRule(ctx context.Context, rule *SecurityRule) error {
+	definition, err := json.Marshal(rule)
+	if err != nil {
+		return fmt.Errorf("序列化規則失敗: %v", err)
+	}
+
+	record := ruleRecord{
+		ID:         rule.ID,
+		Type:       rule.Type,
+		Enabled:    rule.Enabled,
+		Definition: string(definition),
+		CreatedAt:  rule.CreatedAt,
+		UpdatedAt:  rule.UpdatedAt,
+	}
+	return ps.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"type", "enabled", "definition", "updated_at"}),
+	}).Create(&record).Error
+}
+
+// DeleteRule 刪除規則
+func (ps *PostgresStateStore) DeleteRule(ctx context.Context, ruleID string) error {
+	return ps.db.WithContext(ctx).Delete(&ruleRecord{}, "id = ?", ruleID).Error
+}
+
+// SaveBlacklistEntry 新增或更新黑名單項目
+func (ps *PostgresStateStore) SaveBlacklistEntry(ctx context.Context, ip string, expiresAt time.Time) error {
+	record := blacklistRecord{IP: ip, ExpiresAt: expiresAt}
+	return ps.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "ip"}},
+		DoUpdates: clause.AssignmentColumns([]string{"expires_at"}),
+	}).Create(&record).Error
+}
+
+// DeleteBlacklistEntry 刪除黑名單項目
+func (ps *PostgresStateStore) DeleteBlacklistEntry(ctx context.Context, ip string) error {
+	return ps.db.WithContext(ctx).Delete(&blacklistRecord{}, "ip = ?", ip).Error
+}
+
+// SaveWhitelistEntry 新增白名單項目
+func (ps *PostgresStateStore) SaveWhitelistEntry(ctx context.Context, ip string) error {
+	record := whitelistRecord{IP: ip}
+	return ps.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&record).Error
+}
+
+// DeleteWhitelistEntry 刪除白名單項目
+func (ps *PostgresStateStore) DeleteWhitelistEntry(ctx context.Context, ip string) error {
+	return ps.db.WithContext(ctx).Delete(&whitelistRecord{}, "ip = ?", ip).Error
+}
+
+// SaveSuppression 新增或更新誤報抑制條件
+func (ps *PostgresStateStore) SaveSuppression(ctx context.Context, suppression *Suppression) error {
+	record := suppressionRecord{
+		ID:         suppression.ID,
+		RuleID:     suppression.RuleID,
+		SourceCIDR: suppression.SourceCIDR,
+		DestPort:   suppression.DestPort,
+		Reason:     suppression.Reason,
+		PacketID:   suppression.PacketID,
+		ExpiresAt:  suppression.ExpiresAt,
+		Hits:       suppression.Hits,
+		CreatedAt:  suppression.CreatedAt,
+	}
+	return ps.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"reason", "packet_id", "expires_at", "hits"}),
+	}).Create(&record).Error
+}
+
+// DeleteSuppression 刪除誤報抑制條件
+func (ps *PostgresStateStore) DeleteSuppression(ctx context.Context, id string) error {
+	return ps.db.WithContext(ctx).Delete(&suppressionRecord{}, "id = ?", id).Error
+}
+
+// Close 連線由呼叫者 (database.Database) 管理，此處不關閉
+func (ps *PostgresStateStore) Close() error {
+	return nil
+}
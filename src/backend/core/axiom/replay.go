@@ -0,0 +1,328 @@
+package axiom
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// pcapngMagic pcapng 檔案開頭的 Section Header Block 類型
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// ReplayOptions 離線重播設定
+type ReplayOptions struct {
+	RealTime   bool    // 依擷取檔的原始時間間隔送出封包，否則盡可能快速重播
+	Speed      float64 // RealTime 時的速度倍率 (預設 1)
+	AlertsOnly bool    // 報告只保留命中規則或被阻擋的結果
+}
+
+// ReplayReport 離線重播報告
+type ReplayReport struct {
+	Source       string            `json:"source"`
+	Format       string            `json:"format"` // pcap 或 pcapng
+	StartedAt    time.Time         `json:"started_at"`
+	FinishedAt   time.Time         `json:"finished_at"`
+	CaptureStart time.Time         `json:"capture_start"`
+	CaptureEnd   time.Time         `json:"capture_end"`
+	Frames       int               `json:"frames"`  // 讀取的訊框數量
+	Packets      int               `json:"packets"` // 送入分析引擎的封包數量
+	Skipped      int               `json:"skipped"` // 非 IP 或無法解碼的訊框
+	Alerts       int               `json:"alerts"`  // 命中規則的結果數量
+	Blocked      int               `json:"blocked"` // 被阻擋的結果數量
+	RuleHits     map[string]int    `json:"rule_hits"`
+	Results      []*AnalysisResult `json:"results"`
+}
+
+// captureSource pcap 與 pcapng 讀取器的共同介面
+type captureSource interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// captureClock 以擷取時間作為引擎時間來源
+type captureClock struct {
+	current time.Time
+	mutex   sync.RWMutex
+}
+
+func (c *captureClock) set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.current = t
+}
+
+func (c *captureClock) now() time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.current
+}
+
+// ReplayFile 重播 pcap/pcapng 檔案
+func (ae *AnalysisEngine) ReplayFile(ctx context.Context, path string, options ReplayOptions) (*ReplayReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("開啟擷取檔失敗: %v", err)
+	}
+	defer file.Close()
+
+	report, err := ae.Replay(ctx, file, options)
+	if report != nil {
+		report.Source = path
+	}
+	return report, err
+}
+
+// Replay 從讀取器重播 pcap/pcapng 擷取資料
+//
+// 重播期間引擎的時間來源會切換為擷取時間，結束後恢復為系統時間，
+// 因此重播應使用專用的引擎實例。發生錯誤時仍回傳已處理部分的報告。
+func (ae *AnalysisEngine) Replay(ctx context.Context, reader io.Reader, options ReplayOptions) (*ReplayReport, error) {
+	if !ae.IsRunning() {
+		return nil, fmt.Errorf("分析引擎未運行")
+	}
+	if options.Speed <= 0 {
+		options.Speed = 1
+	}
+
+	buffered := bufio.NewReader(reader)
+	magic, err := buffered.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, fmt.Errorf("讀取擷取檔標頭失敗: %v", err)
+	}
+
+	report := &ReplayReport{
+		Format:    "pcap",
+		StartedAt: time.Now(),
+		RuleHits:  make(map[string]int),
+		Results:   make([]*AnalysisResult, 0),
+	}
+
+	var source captureSource
+	var ng *pcapgo.NgReader
+	if bytes.Equal(magic, pcapngMagic) {
+		report.Format = "pcapng"
+		ng, err = pcapgo.NewNgReader(buffered, pcapgo.DefaultNgReaderOptions)
+		source = ng
+	} else {
+		source, err = pcapgo.NewReader(buffered)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析%s標頭失敗: %v", report.Format, err)
+	}
+
+	clock := &captureClock{}
+	ae.SetClock(clock.now)
+	defer ae.SetClock(nil)
+
+	var previous time.Time
+	for {
+		if err := ctx.Err(); err != nil {
+			report.FinishedAt = time.Now()
+			return report, err
+		}
+
+		data, info, err := source.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.FinishedAt = time.Now()
+			return report, fmt.Errorf("讀取第 %d 個訊框失敗: %v", report.Frames+1, err)
+		}
+		report.Frames++
+
+		linkType := source.LinkType()
+		if ng != nil {
+			if iface, err := ng.Interface(info.InterfaceIndex); err == nil {
+				linkType = iface.LinkType
+			}
+		}
+
+		packet, err := PacketFromFrame(data, linkType, info.Timestamp)
+		if err != nil {
+			report.Skipped++
+			ae.logger.Debugf("略過第 %d 個訊框: %v", report.Frames, err)
+			continue
+		}
+
+		if report.CaptureStart.IsZero() {
+			report.CaptureStart = packet.Timestamp
+		}
+		if options.RealTime && !previous.IsZero() && packet.Timestamp.After(previous) {
+			delay := time.Duration(float64(packet.Timestamp.Sub(previous)) / options.Speed)
+			if err := sleepContext(ctx, delay); err != nil {
+				report.FinishedAt = time.Now()
+				return report, err
+			}
+		}
+		previous = packet.Timestamp
+		report.CaptureEnd = packet.Timestamp
+		clock.set(packet.Timestamp)
+
+		result, err := ae.AnalyzePacket(packet)
+		if err != nil {
+			report.FinishedAt = time.Now()
+			return report, err
+		}
+		report.Packets++
+		report.add(result, options.AlertsOnly)
+	}
+
+	report.FinishedAt = time.Now()
+	ae.logger.Infof("擷取檔重播完成，訊框: %d，分析: %d，告警: %d，阻擋: %d",
+		report.Frames, report.Packets, report.Alerts, report.Blocked)
+	return report, nil
+}
+
+// add 將分析結果加入報告
+func (r *ReplayReport) add(result *AnalysisResult, alertsOnly bool) {
+	alert := result.RuleID != ""
+	if alert {
+		r.Alerts++
+		r.RuleHits[result.RuleID]++
+	}
+	if result.Blocked {
+		r.Blocked++
+	}
+	if alertsOnly && !alert && !result.Blocked {
+		return
+	}
+	r.Results = append(r.Results, result)
+}
+
+// WriteJSON 以 JSON 格式輸出報告
+func (r *ReplayReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteCSV 以 CSV 格式輸出分析結果，每個結果一列
+func (r *ReplayReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"packet_id", "timestamp", "source_ip", "dest_ip", "dest_port",
+		"threat_level", "threat_type", "action", "rule_id", "rule_name", "blocked", "details"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, result := range r.Results {
+		record := []string{
+			result.PacketID,
+			result.Timestamp.UTC().Format(time.RFC3339Nano),
+			result.SourceIP,
+			result.DestIP,
+			strconv.Itoa(result.DestPort),
+			result.ThreatLevel,
+			result.ThreatType,
+			result.Action,
+			result.RuleID,
+			result.RuleName,
+			strconv.FormatBool(result.Blocked),
+			result.Details,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// PacketFromFrame 將擷取的訊框轉換為 NetworkPacket，非 IP 訊框回傳錯誤
+func PacketFromFrame(data []byte, linkType layers.LinkType, timestamp time.Time) (*NetworkPacket, error) {
+	frame := gopacket.NewPacket(data, linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+	packet := &NetworkPacket{Timestamp: timestamp}
+	switch network := frame.NetworkLayer().(type) {
+	case *layers.IPv4:
+		packet.SourceIP = network.SrcIP.String()
+		packet.DestIP = network.DstIP.String()
+		packet.Protocol = network.Protocol.String()
+	case *layers.IPv6:
+		packet.SourceIP = network.SrcIP.String()
+		packet.DestIP = network.DstIP.String()
+		packet.Protocol = network.NextHeader.String()
+	default:
+		if failure := frame.ErrorLayer(); failure != nil {
+			return nil, fmt.Errorf("解碼失敗: %v", failure.Error())
+		}
+		return nil, fmt.Errorf("非 IP 訊框")
+	}
+
+	switch transport := frame.TransportLayer().(type) {
+	case *layers.TCP:
+		packet.Protocol = "TCP"
+		packet.SourcePort = int(transport.SrcPort)
+		packet.DestPort = int(transport.DstPort)
+		packet.TCPSeq = transport.Seq
+		packet.TCPFlags = tcpFlags(transport)
+		packet.Payload = transport.Payload
+	case *layers.UDP:
+		packet.Protocol = "UDP"
+		packet.SourcePort = int(transport.SrcPort)
+		packet.DestPort = int(transport.DstPort)
+		packet.Payload = transport.Payload
+	default:
+		if icmp := frame.Layer(layers.LayerTypeICMPv4); icmp != nil {
+			packet.Protocol = "ICMP"
+		} else if icmp := frame.Layer(layers.LayerTypeICMPv6); icmp != nil {
+			packet.Protocol = "ICMPv6"
+		}
+		if network := frame.NetworkLayer(); network != nil {
+			packet.Payload = network.LayerPayload()
+		}
+	}
+	packet.PayloadString = string(packet.Payload)
+
+	return packet, nil
+}
+
+// tcpFlags 將 TCP 旗標轉為 NetworkPacket 使用的字串表示，例如 "S"、"SA"、"PA"
+func tcpFlags(tcp *layers.TCP) string {
+	var flags strings.Builder
+	for _, flag := range []struct {
+		set  bool
+		name byte
+	}{
+		{tcp.SYN, 'S'}, {tcp.FIN, 'F'}, {tcp.RST, 'R'}, {tcp.PSH, 'P'}, {tcp.ACK, 'A'}, {tcp.URG, 'U'},
+	} {
+		if flag.set {
+			flags.WriteByte(flag.name)
+		}
+	}
+	return flags.String()
+}
+
+// sleepContext 等待指定時間，context 取消時提前返回
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
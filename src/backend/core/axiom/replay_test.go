@@ -0,0 +1,210 @@
+package axiom
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureFrame 測試用的擷取訊框
+type captureFrame struct {
+	at      time.Time
+	source  string
+	port    int
+	flags   string
+	payload string
+}
+
+// encodeFrame 將測試訊框序列化為 Ethernet/IPv4/TCP
+func encodeFrame(t *testing.T, frame captureFrame) []byte {
+	t.Helper()
+
+	ethernet := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.ParseIP(frame.source),
+		DstIP:    net.ParseIP("172.16.0.44"),
+	}
+	tcp := &layers.TCP{
+		SrcPort: 40000,
+		DstPort: layers.TCPPort(frame.port),
+		Seq:     1000,
+		SYN:     frame.flags == "S",
+		PSH:     frame.flags == "PA",
+		ACK:     frame.flags == "PA",
+		Window:  65535,
+	}
+	require.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buffer, options, ethernet, ip, tcp, gopacket.Payload(frame.payload)))
+	return buffer.Bytes()
+}
+
+// writeCapture 產生 pcap 或 pcapng 擷取資料
+func writeCapture(t *testing.T, ng bool, frames []captureFrame) []byte {
+	t.Helper()
+
+	var output bytes.Buffer
+	var write func(ci gopacket.CaptureInfo, data []byte) error
+	var flush func() error
+	if ng {
+		writer, err := pcapgo.NewNgWriter(&output, layers.LinkTypeEthernet)
+		require.NoError(t, err)
+		write, flush = writer.WritePacket, writer.Flush
+	} else {
+		writer := pcapgo.NewWriter(&output)
+		require.NoError(t, writer.WriteFileHeader(65535, layers.LinkTypeEthernet))
+		write = writer.WritePacket
+	}
+
+	for _, frame := range frames {
+		data := encodeFrame(t, frame)
+		require.NoError(t, write(gopacket.CaptureInfo{Timestamp: frame.at, CaptureLength: len(data), Length: len(data)}, data))
+	}
+	if flush != nil {
+		require.NoError(t, flush())
+	}
+	return output.Bytes()
+}
+
+// scanFrames 來源對連續連接埠發出 SYN，每個間隔 gap
+func scanFrames(source string, count int, gap time.Duration) []captureFrame {
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	frames := make([]captureFrame, 0, count)
+	for i := 0; i < count; i++ {
+		frames = append(frames, captureFrame{at: start.Add(time.Duration(i) * gap), source: source, port: 1000 + i, flags: "S"})
+	}
+	return frames
+}
+
+func TestPacketFromFrame(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	packet, err := PacketFromFrame(encodeFrame(t, captureFrame{source: "198.51.100.4", port: 80, flags: "PA", payload: "GET /"}), layers.LinkTypeEthernet, at)
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.4", packet.SourceIP)
+	assert.Equal(t, "172.16.0.44", packet.DestIP)
+	assert.Equal(t, 40000, packet.SourcePort)
+	assert.Equal(t, 80, packet.DestPort)
+	assert.Equal(t, "TCP", packet.Protocol)
+	assert.Equal(t, "PA", packet.TCPFlags)
+	assert.Equal(t, uint32(1000), packet.TCPSeq)
+	assert.Equal(t, "GET /", packet.PayloadString)
+	assert.Equal(t, at, packet.Timestamp)
+
+	arp := gopacket.NewSerializeBuffer()
+	require.NoError(t, gopacket.SerializeLayers(arp, gopacket.SerializeOptions{},
+		&layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeARP},
+		&layers.ARP{AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4,
+			SourceHwAddress: []byte{0, 1, 2, 3, 4, 5}, SourceProtAddress: []byte{10, 0, 0, 1},
+			DstHwAddress: []byte{0, 0, 0, 0, 0, 0}, DstProtAddress: []byte{10, 0, 0, 2}},
+	))
+	_, err = PacketFromFrame(arp.Bytes(), layers.LinkTypeEthernet, at)
+	assert.Error(t, err)
+}
+
+func TestReplayUsesCaptureTime(t *testing.T) {
+	rule := behaviorRule("scan", "port_scan", &BehaviorThresholds{WindowSeconds: 10, DistinctPorts: 5})
+
+	testCases := []struct {
+		name   string
+		ng     bool
+		gap    time.Duration
+		alerts int
+	}{
+		{"pcap 密集掃描", false, time.Second, 1},
+		{"pcapng 密集掃描", true, time.Second, 1},
+		{"跨越視窗的慢速掃描", false, time.Minute, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine, _ := newBehaviorEngine(t, rule)
+			frames := scanFrames("203.0.113.50", 5, tc.gap)
+
+			report, err := engine.Replay(context.Background(), bytes.NewReader(writeCapture(t, tc.ng, frames)), ReplayOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, 5, report.Frames)
+			assert.Equal(t, 5, report.Packets)
+			assert.Equal(t, tc.alerts, report.Alerts, "行為偵測使用擷取時間而非系統時間")
+			assert.Equal(t, frames[0].at, report.CaptureStart.UTC())
+			assert.Equal(t, frames[4].at, report.CaptureEnd.UTC())
+			assert.Equal(t, frames[4].at, report.Results[4].Timestamp.UTC())
+			if tc.ng {
+				assert.Equal(t, "pcapng", report.Format)
+			}
+
+			// 重播結束後恢復系統時間
+			assert.WithinDuration(t, time.Now(), engine.behavior.clock(), time.Minute)
+		})
+	}
+}
+
+func TestReplayReport(t *testing.T) {
+	engine, _ := newBehaviorEngine(t,
+		&SecurityRule{ID: "admin_path", Name: "管理路徑", Type: "pattern", Pattern: "/admin", Action: "alert", Severity: "medium", Enabled: true},
+		&SecurityRule{ID: "cmd_exec", Name: "命令執行", Type: "pattern", Pattern: "cmd.exe", Action: "block", Severity: "high", Enabled: true},
+	)
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	capture := writeCapture(t, false, []captureFrame{
+		{at: start, source: "203.0.113.1", port: 80, flags: "PA", payload: "GET /index"},
+		{at: start.Add(time.Second), source: "203.0.113.2", port: 80, flags: "PA", payload: "GET /admin"},
+		{at: start.Add(2 * time.Second), source: "203.0.113.3", port: 80, flags: "PA", payload: "GET /scripts/cmd.exe"},
+	})
+
+	report, err := engine.Replay(context.Background(), bytes.NewReader(capture), ReplayOptions{AlertsOnly: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Packets)
+	assert.Equal(t, 2, report.Alerts)
+	assert.Equal(t, 1, report.Blocked)
+	assert.Equal(t, map[string]int{"admin_path": 1, "cmd_exec": 1}, report.RuleHits)
+	require.Len(t, report.Results, 2)
+
+	var output bytes.Buffer
+	require.NoError(t, report.WriteCSV(&output))
+	records, err := csv.NewReader(&output).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "rule_id", records[0][8])
+	assert.Equal(t, "cmd_exec", records[2][8])
+	assert.Equal(t, "true", records[2][10])
+
+	output.Reset()
+	require.NoError(t, report.WriteJSON(&output))
+	assert.Contains(t, output.String(), `"rule_hits"`)
+
+	_, err = engine.Replay(context.Background(), bytes.NewReader([]byte("not a capture")), ReplayOptions{})
+	assert.Error(t, err)
+}
+
+func TestReplayRealTime(t *testing.T) {
+	engine, _ := newBehaviorEngine(t)
+	capture := writeCapture(t, false, scanFrames("203.0.113.60", 3, 100*time.Millisecond))
+
+	started := time.Now()
+	report, err := engine.Replay(context.Background(), bytes.NewReader(capture), ReplayOptions{RealTime: true, Speed: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Packets)
+	assert.GreaterOrEqual(t, time.Since(started), 100*time.Millisecond, "兩倍速重播 200ms 的擷取")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = engine.Replay(ctx, bytes.NewReader(capture), ReplayOptions{RealTime: true})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, report.Packets)
+}
@@ -0,0 +1,337 @@
+package axiom
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strings"
+)
+
+// reportDocument 與輸出格式無關的報表內容，CSV、HTML 與 PDF 皆由此產生
+type reportDocument struct {
+	Title    string
+	Meta     [][2]string // 報表資訊 (名稱, 值)
+	Sections []reportSection
+}
+
+// reportSection 報表區段：一個表格與選用的長條圖
+type reportSection struct {
+	Key     string // CSV 的 section 欄位
+	Title   string
+	Headers []string
+	Rows    [][]string
+	Chart   *reportChart
+}
+
+// reportChart 長條圖資料
+type reportChart struct {
+	Labels []string
+	Values []float64
+}
+
+// writeCSVDocument 每個區段輸出獨立的標頭列，區段之間以空白列分隔
+func writeCSVDocument(w io.Writer, doc *reportDocument) error {
+	writer := csv.NewWriter(w)
+	for i, section := range doc.Sections {
+		if i > 0 {
+			// csv.Writer 不輸出空紀錄，直接寫入分隔用的空白列
+			writer.Flush()
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if err := writer.Write(append([]string{"section"}, section.Headers...)); err != nil {
+			return err
+		}
+		for _, row := range section.Rows {
+			if err := writer.Write(append([]string{section.Key}, row...)); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// reportHTMLTemplate 自包含的 HTML 報表，不引用外部樣式或腳本
+var reportHTMLTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="zh-TW">
+<head>
+<meta charset="UTF-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "Noto Sans TC", sans-serif; margin: 32px; color: #1f2937; }
+h1 { color: #0284c7; margin-bottom: 4px; }
+h2 { margin-top: 32px; border-bottom: 1px solid #e5e7eb; padding-bottom: 4px; }
+table { border-collapse: collapse; min-width: 360px; }
+th, td { border: 1px solid #e5e7eb; padding: 4px 12px; text-align: left; }
+th { background: #f3f4f6; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 2px 16px; color: #6b7280; }
+dd { margin: 0; }
+svg { display: block; margin-top: 12px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<dl>{{range .Meta}}<dt>{{index . 0}}</dt><dd>{{index . 1}}</dd>{{end}}</dl>
+{{range .Sections}}<section>
+<h2>{{.Title}}</h2>
+{{if .Rows}}<table>
+<thead><tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>{{end}}</tbody>
+</table>{{else}}<p>No data</p>{{end}}
+{{with .SVG}}{{.}}{{end}}
+</section>
+{{end}}</body>
+</html>
+`))
+
+// writeHTMLDocument 輸出自包含的 HTML，圖表以內嵌 SVG 呈現
+func writeHTMLDocument(w io.Writer, doc *reportDocument) error {
+	type htmlSection struct {
+		reportSection
+		SVG template.HTML
+	}
+	sections := make([]htmlSection, 0, len(doc.Sections))
+	for _, section := range doc.Sections {
+		sections = append(sections, htmlSection{reportSection: section, SVG: renderBarChartSVG(section.Chart)})
+	}
+
+	return reportHTMLTemplate.Execute(w, struct {
+		Title    string
+		Meta     [][2]string
+		Sections []htmlSection
+	}{doc.Title, doc.Meta, sections})
+}
+
+// 長條圖尺寸
+const (
+	chartWidth     = 640
+	chartBarHeight = 22
+	chartLabelArea = 180
+	chartValueArea = 60
+)
+
+// renderBarChartSVG 產生水平長條圖的 SVG，標籤經過跳脫
+func renderBarChartSVG(chart *reportChart) template.HTML {
+	if chart == nil || len(chart.Values) == 0 {
+		return ""
+	}
+
+	maxValue := 0.0
+	for _, value := range chart.Values {
+		maxValue = math.Max(maxValue, value)
+	}
+	barArea := float64(chartWidth - chartLabelArea - chartValueArea)
+	height := len(chart.Values)*chartBarHeight + 8
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img">`, chartWidth, height, chartWidth, height)
+	for i, value := range chart.Values {
+		y := i*chartBarHeight + 4
+		width := 0.0
+		if maxValue > 0 {
+			width = value / maxValue * barArea
+		}
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" font-size="12" text-anchor="end">%s</text>`,
+			chartLabelArea-8, y+15, template.HTMLEscapeString(chart.Labels[i]))
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="#0284c7"></rect>`,
+			chartLabelArea, y+2, width, chartBarHeight-6)
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%d" font-size="12">%s</text>`,
+			float64(chartLabelArea)+width+6, y+15, formatChartValue(value))
+	}
+	buf.WriteString(`</svg>`)
+	return template.HTML(buf.String())
+}
+
+// formatChartValue 整數不顯示小數
+func formatChartValue(value float64) string {
+	if value == math.Trunc(value) {
+		return fmt.Sprintf("%.0f", value)
+	}
+	return fmt.Sprintf("%.1f", value)
+}
+
+// PDF 版面 (A4，單位為 point)
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 50
+	pdfLineHeight = 14
+	pdfColumnGap  = 12
+)
+
+// pdfWriter 產生只使用 Helvetica 內建字型與向量圖形的 PDF，不依賴外部服務或字型檔；
+// 內建字型只支援 Latin-1，其他字元以 "?" 取代
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+// newPage 開始新頁面
+func (pw *pdfWriter) newPage() {
+	pw.pages = append(pw.pages, &bytes.Buffer{})
+	pw.y = pdfPageHeight - pdfMargin
+}
+
+// ensure 剩餘空間不足 height 時換頁
+func (pw *pdfWriter) ensure(height float64) {
+	if len(pw.pages) == 0 || pw.y-height < pdfMargin {
+		pw.newPage()
+	}
+}
+
+// current 目前頁面的內容串流
+func (pw *pdfWriter) current() *bytes.Buffer {
+	return pw.pages[len(pw.pages)-1]
+}
+
+// text 在 (x, y) 輸出一段文字
+func (pw *pdfWriter) text(x, y float64, size int, bold bool, value string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(pw.current(), "BT /%s %d Tf %.1f %.1f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(value))
+}
+
+// line 輸出一行文字並往下移動
+func (pw *pdfWriter) line(size int, bold bool, value string) {
+	height := float64(size) + 6
+	pw.ensure(height)
+	pw.y -= height
+	pw.text(pdfMargin, pw.y, size, bold, value)
+}
+
+// table 以等寬欄位輸出表格，標頭使用粗體
+func (pw *pdfWriter) table(headers []string, rows [][]string) {
+	if len(headers) == 0 {
+		return
+	}
+	columnWidth := float64(pdfPageWidth-2*pdfMargin) / float64(len(headers))
+	maxChars := int((columnWidth - pdfColumnGap) / 5.5)
+
+	writeRow := func(cells []string, bold bool) {
+		pw.ensure(pdfLineHeight)
+		pw.y -= pdfLineHeight
+		for i, cell := range cells {
+			if len([]rune(cell)) > maxChars && maxChars > 3 {
+				cell = string([]rune(cell)[:maxChars-3]) + "..."
+			}
+			pw.text(pdfMargin+float64(i)*columnWidth, pw.y, 9, bold, cell)
+		}
+	}
+	writeRow(headers, true)
+	for _, row := range rows {
+		writeRow(row, false)
+	}
+}
+
+// barChart 輸出水平長條圖
+func (pw *pdfWriter) barChart(chart *reportChart) {
+	if chart == nil || len(chart.Values) == 0 {
+		return
+	}
+	maxValue := 0.0
+	for _, value := range chart.Values {
+		maxValue = math.Max(maxValue, value)
+	}
+	labelArea, valueArea := 150.0, 40.0
+	barArea := float64(pdfPageWidth-2*pdfMargin) - labelArea - valueArea
+
+	pw.ensure(8)
+	pw.y -= 8
+	for i, value := range chart.Values {
+		pw.ensure(pdfLineHeight)
+		pw.y -= pdfLineHeight
+		width := 0.0
+		if maxValue > 0 {
+			width = value / maxValue * barArea
+		}
+		label := chart.Labels[i]
+		if len(label) > 26 {
+			label = label[:23] + "..."
+		}
+		pw.text(pdfMargin, pw.y+2, 8, false, label)
+		fmt.Fprintf(pw.current(), "0.008 0.518 0.780 rg %.1f %.1f %.1f %.1f re f 0 g\n", pdfMargin+labelArea, pw.y, width, float64(pdfLineHeight-4))
+		pw.text(pdfMargin+labelArea+width+4, pw.y+2, 8, false, formatChartValue(value))
+	}
+}
+
+// writePDFDocument 輸出 PDF 1.4 文件
+func writePDFDocument(w io.Writer, doc *reportDocument) error {
+	pw := &pdfWriter{}
+	pw.newPage()
+	pw.line(18, true, doc.Title)
+	for _, meta := range doc.Meta {
+		pw.line(9, false, meta[0]+": "+meta[1])
+	}
+	for _, section := range doc.Sections {
+		pw.ensure(40)
+		pw.y -= 10
+		pw.line(13, true, section.Title)
+		if len(section.Rows) == 0 {
+			pw.line(9, false, "No data")
+			continue
+		}
+		pw.table(section.Headers, section.Rows)
+		pw.barChart(section.Chart)
+	}
+
+	// 物件編號：1 目錄、2 頁面樹、3-4 字型，之後每頁兩個物件 (頁面、內容串流)
+	var out bytes.Buffer
+	offsets := []int{0}
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets)-1, body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pw.pages))
+	for i := range pw.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pw.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range pw.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets))
+	for _, offset := range offsets[1:] {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets), xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pdfEscape 跳脫 PDF 字串並將字元轉為 Latin-1，無法表示的字元以 "?" 取代
+func pdfEscape(value string) string {
+	var buf strings.Builder
+	for _, r := range value {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r < 0x20:
+			buf.WriteByte(' ')
+		case r < 0x80:
+			buf.WriteRune(r)
+		case r <= 0xff:
+			fmt.Fprintf(&buf, "\\%03o", r)
+		default:
+			buf.WriteByte('?')
+		}
+	}
+	return buf.String()
+}
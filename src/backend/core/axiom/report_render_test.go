@@ -0,0 +1,99 @@
+package axiom
+
+import (
+	"bytes"
+	"encoding/csv"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReportDocument() *reportDocument {
+	return &reportDocument{
+		Title: "安全報表",
+		Meta:  [][2]string{{"時間範圍", "24h"}},
+		Sections: []reportSection{
+			{
+				Key:     "summary",
+				Title:   "摘要",
+				Headers: []string{"metric", "value"},
+				Rows:    [][]string{{"total_threats", "3"}},
+			},
+			{
+				Key:     "source_ip",
+				Title:   "來源 IP",
+				Headers: []string{"ip", "count", "blocked"},
+				Rows:    [][]string{{"203.0.113.1", "2", "true"}, {"<script>", "1", "false"}},
+				Chart:   &reportChart{Labels: []string{"203.0.113.1", "<script>"}, Values: []float64{2, 1}},
+			},
+		},
+	}
+}
+
+func TestWriteCSVDocument(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeCSVDocument(&buf, testReportDocument()))
+
+	reader := csv.NewReader(&buf)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	require.NoError(t, err)
+
+	// 空白列由 csv.Reader 略過
+	assert.Equal(t, [][]string{
+		{"section", "metric", "value"},
+		{"summary", "total_threats", "3"},
+		{"section", "ip", "count", "blocked"},
+		{"source_ip", "203.0.113.1", "2", "true"},
+		{"source_ip", "<script>", "1", "false"},
+	}, records, "每個區段有各自的標頭列")
+}
+
+func TestWriteHTMLDocument(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeHTMLDocument(&buf, testReportDocument()))
+	html := buf.String()
+
+	assert.True(t, strings.HasPrefix(html, "<!DOCTYPE html>"))
+	assert.Contains(t, html, "<svg")
+	assert.Contains(t, html, "&lt;script&gt;")
+	assert.NotContains(t, html, "<script>", "資料必須跳脫")
+	assert.NotContains(t, html, "<link", "報表不依賴外部資源")
+	assert.NotContains(t, html, "src=\"http")
+}
+
+func TestWritePDFDocument(t *testing.T) {
+	doc := testReportDocument()
+	for i := 0; i < 80; i++ {
+		doc.Sections[1].Rows = append(doc.Sections[1].Rows, []string{"198.51.100." + strconv.Itoa(i), "1", "false"})
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writePDFDocument(&buf, doc))
+	pdf := buf.Bytes()
+
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-1.4")))
+	assert.True(t, bytes.HasSuffix(bytes.TrimSpace(pdf), []byte("%%EOF")))
+	assert.Contains(t, string(pdf), "(203.0.113.1)")
+
+	match := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	require.NotNil(t, match)
+	offset, err := strconv.Atoi(string(match[1]))
+	require.NoError(t, err)
+	require.Less(t, offset, len(pdf))
+	assert.True(t, bytes.HasPrefix(pdf[offset:], []byte("xref")), "startxref 指向交叉參照表")
+
+	pages := regexp.MustCompile(`/Count (\d+)`).FindSubmatch(pdf)
+	require.NotNil(t, pages)
+	count, _ := strconv.Atoi(string(pages[1]))
+	assert.Greater(t, count, 1, "超過一頁的表格自動換頁")
+}
+
+func TestPDFEscape(t *testing.T) {
+	assert.Equal(t, `a\(b\)\\c`, pdfEscape(`a(b)\c`))
+	assert.Equal(t, "?? report", pdfEscape("安全 report"))
+}
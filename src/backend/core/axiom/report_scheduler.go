@@ -0,0 +1,264 @@
+package axiom
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/pubsub"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReportSchedule 排程報表設定，產生的報表寫入 OutputDir 或透過消息隊列發布 (可同時使用)
+type ReportSchedule struct {
+	Name       string        `json:"name" mapstructure:"name"`
+	ReportType string        `json:"report_type" mapstructure:"report_type"` // security, network, system
+	Format     string        `json:"format" mapstructure:"format"`           // json, csv, html, pdf
+	Interval   time.Duration `json:"interval" mapstructure:"interval"`
+	TimeRange  string        `json:"time_range" mapstructure:"time_range"` // 預設與 Interval 相同
+	OutputDir  string        `json:"output_dir,omitempty" mapstructure:"output_dir"`
+	Publish    bool          `json:"publish" mapstructure:"publish"`
+}
+
+// validate 檢查設定並補上預設值
+func (s *ReportSchedule) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("排程報表名稱不可為空")
+	}
+	switch s.ReportType {
+	case "security", "network", "system":
+	default:
+		return fmt.Errorf("排程報表 %s: 不支援的報表類型: %s", s.Name, s.ReportType)
+	}
+	if s.Format == "" {
+		s.Format = "json"
+	}
+	if _, ok := ReportFormats[s.Format]; !ok {
+		return fmt.Errorf("排程報表 %s: 不支援的報表格式: %s", s.Name, s.Format)
+	}
+	if s.Interval <= 0 {
+		return fmt.Errorf("排程報表 %s: 間隔必須大於 0", s.Name)
+	}
+	if s.TimeRange == "" {
+		s.TimeRange = s.Interval.String()
+	}
+	if s.TimeRange != "all" {
+		if _, err := parseTimeRange(s.TimeRange); err != nil {
+			return fmt.Errorf("排程報表 %s: %v", s.Name, err)
+		}
+	}
+	if s.OutputDir == "" && !s.Publish {
+		return fmt.Errorf("排程報表 %s: 需要指定輸出目錄或啟用發布", s.Name)
+	}
+	return nil
+}
+
+// ReportRunStatus 排程報表的執行狀態
+type ReportRunStatus struct {
+	Name      string    `json:"name"`
+	Runs      int       `json:"runs"`
+	LastRun   time.Time `json:"last_run,omitempty"`
+	LastFile  string    `json:"last_file,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// ReportScheduler 依排程產生報表
+type ReportScheduler struct {
+	engine    *AnalysisEngine
+	logger    *logrus.Logger
+	schedules map[string]ReportSchedule
+	publisher pubsub.MessageQueue
+	exchange  string
+	startTime time.Time
+	now       func() time.Time
+	status    map[string]*ReportRunStatus
+	mutex     sync.Mutex
+}
+
+// NewReportScheduler 建立報表排程器
+func NewReportScheduler(engine *AnalysisEngine, logger *logrus.Logger, schedules []ReportSchedule) (*ReportScheduler, error) {
+	rs := &ReportScheduler{
+		engine:    engine,
+		logger:    logger,
+		schedules: make(map[string]ReportSchedule, len(schedules)),
+		exchange:  "pandora.events",
+		startTime: time.Now(),
+		now:       time.Now,
+		status:    make(map[string]*ReportRunStatus, len(schedules)),
+	}
+	for _, schedule := range schedules {
+		if err := schedule.validate(); err != nil {
+			return nil, err
+		}
+		if _, exists := rs.schedules[schedule.Name]; exists {
+			return nil, fmt.Errorf("排程報表名稱重複: %s", schedule.Name)
+		}
+		rs.schedules[schedule.Name] = schedule
+		rs.status[schedule.Name] = &ReportRunStatus{Name: schedule.Name}
+	}
+	return rs, nil
+}
+
+// SetPublisher 設定發布 report.generated 事件的消息隊列
+func (rs *ReportScheduler) SetPublisher(mq pubsub.MessageQueue, exchange string) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	rs.publisher = mq
+	if exchange != "" {
+		rs.exchange = exchange
+	}
+}
+
+// Start 為每個排程啟動計時器，直到 ctx 取消
+func (rs *ReportScheduler) Start(ctx context.Context) {
+	for _, schedule := range rs.schedules {
+		go func(schedule ReportSchedule) {
+			ticker := time.NewTicker(schedule.Interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := rs.Run(ctx, schedule.Name); err != nil {
+						rs.logger.Errorf("產生排程報表 %s 失敗: %v", schedule.Name, err)
+					}
+				}
+			}
+		}(schedule)
+	}
+}
+
+// Run 立即產生指定排程的報表
+func (rs *ReportScheduler) Run(ctx context.Context, name string) error {
+	schedule, exists := rs.schedules[name]
+	if !exists {
+		return fmt.Errorf("排程報表不存在: %s", name)
+	}
+
+	file, err := rs.generate(ctx, schedule)
+
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	status := rs.status[name]
+	status.Runs++
+	status.LastRun = rs.now()
+	status.LastError = ""
+	if file != "" {
+		status.LastFile = file
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+	return err
+}
+
+// Schedules 取得依名稱排序的排程設定
+func (rs *ReportScheduler) Schedules() []ReportSchedule {
+	schedules := make([]ReportSchedule, 0, len(rs.schedules))
+	for _, schedule := range rs.schedules {
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].Name < schedules[j].Name })
+	return schedules
+}
+
+// Status 取得所有排程的執行狀態
+func (rs *ReportScheduler) Status() []ReportRunStatus {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	statuses := make([]ReportRunStatus, 0, len(rs.status))
+	for _, status := range rs.status {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// generate 產生報表並寫入檔案與發布，回傳寫入的檔案路徑
+func (rs *ReportScheduler) generate(ctx context.Context, schedule ReportSchedule) (string, error) {
+	now := rs.now()
+	query := EventQuery{Until: now}
+	if schedule.TimeRange != "all" {
+		duration, _ := parseTimeRange(schedule.TimeRange)
+		query.Since = now.Add(-duration)
+	}
+
+	report, err := BuildReport(ctx, rs.engine, schedule.ReportType, query, schedule.TimeRange, rs.startTime)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, report, schedule.Format); err != nil {
+		return "", fmt.Errorf("輸出報表失敗: %v", err)
+	}
+
+	file := ""
+	if schedule.OutputDir != "" {
+		file, err = writeReportFile(schedule, now, buf.Bytes())
+		if err != nil {
+			return "", err
+		}
+		rs.logger.Infof("已產生排程報表 %s: %s", schedule.Name, file)
+	}
+
+	if schedule.Publish {
+		if err := rs.publish(ctx, schedule, file, buf.Bytes()); err != nil {
+			return file, err
+		}
+	}
+	return file, nil
+}
+
+// writeReportFile 以暫存檔寫入後改名，避免讀取者看到寫到一半的報表
+func writeReportFile(schedule ReportSchedule, generatedAt time.Time, content []byte) (string, error) {
+	if err := os.MkdirAll(schedule.OutputDir, 0o755); err != nil {
+		return "", fmt.Errorf("建立報表目錄失敗: %v", err)
+	}
+
+	name := fmt.Sprintf("%s_%s.%s", schedule.Name, generatedAt.UTC().Format("20060102T150405Z"), ReportFormats[schedule.Format].Extension)
+	path := filepath.Join(schedule.OutputDir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return "", fmt.Errorf("寫入報表失敗: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("寫入報表失敗: %v", err)
+	}
+	return path, nil
+}
+
+// publish 發布 report.generated 事件，事件內容包含完整報表
+func (rs *ReportScheduler) publish(ctx context.Context, schedule ReportSchedule, file string, content []byte) error {
+	rs.mutex.Lock()
+	publisher, exchange := rs.publisher, rs.exchange
+	rs.mutex.Unlock()
+	if publisher == nil {
+		return fmt.Errorf("未設定消息隊列，無法發布報表")
+	}
+
+	event := pubsub.NewReportEvent(schedule.Name, schedule.ReportType, schedule.Format)
+	event.ContentType = ReportFormats[schedule.Format].ContentType
+	event.TimeRange = schedule.TimeRange
+	event.File = file
+	event.Content = content
+
+	message, err := pubsub.ToJSON(event)
+	if err != nil {
+		return fmt.Errorf("序列化報表事件失敗: %v", err)
+	}
+	if err := publisher.Publish(ctx, exchange, pubsub.GetRoutingKey(pubsub.EventTypeReportGenerated), message); err != nil {
+		return fmt.Errorf("發布報表失敗: %v", err)
+	}
+	return nil
+}
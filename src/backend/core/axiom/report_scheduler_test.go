@@ -0,0 +1,126 @@
+package axiom
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/pubsub"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportScheduleValidate(t *testing.T) {
+	schedule := ReportSchedule{Name: "daily", ReportType: "security", Interval: 24 * time.Hour, OutputDir: "/tmp"}
+	require.NoError(t, schedule.validate())
+	assert.Equal(t, "json", schedule.Format)
+	assert.Equal(t, "24h0m0s", schedule.TimeRange)
+
+	for _, schedule := range []ReportSchedule{
+		{ReportType: "security", Interval: time.Hour, OutputDir: "/tmp"},
+		{Name: "a", ReportType: "custom", Interval: time.Hour, OutputDir: "/tmp"},
+		{Name: "a", ReportType: "security", Format: "xlsx", Interval: time.Hour, OutputDir: "/tmp"},
+		{Name: "a", ReportType: "security", OutputDir: "/tmp"},
+		{Name: "a", ReportType: "security", Interval: time.Hour, TimeRange: "yesterday", OutputDir: "/tmp"},
+		{Name: "a", ReportType: "security", Interval: time.Hour},
+	} {
+		assert.Error(t, schedule.validate(), "%+v", schedule)
+	}
+
+	_, err := NewReportScheduler(nil, nil, []ReportSchedule{
+		{Name: "a", ReportType: "system", Interval: time.Hour, Publish: true},
+		{Name: "a", ReportType: "network", Interval: time.Hour, Publish: true},
+	})
+	assert.Error(t, err, "名稱重複")
+}
+
+func TestReportSchedulerRun(t *testing.T) {
+	engine, _ := newBehaviorEngine(t,
+		&SecurityRule{ID: "sqli", Name: "SQL注入", Type: "pattern", Pattern: "union select", Action: "block", Severity: "critical", Enabled: true},
+	)
+	analyze(t, engine, tuningPacket("203.0.113.1", 80, "id=1 union select"))
+
+	dir := t.TempDir()
+	scheduler, err := NewReportScheduler(engine, engine.logger, []ReportSchedule{
+		{Name: "daily_security", ReportType: "security", Format: "csv", Interval: 24 * time.Hour, TimeRange: "all", OutputDir: dir, Publish: true},
+		{Name: "hourly_system", ReportType: "system", Format: "pdf", Interval: time.Hour, Publish: true},
+	})
+	require.NoError(t, err)
+	scheduler.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	// 未設定消息隊列時仍寫入檔案，但回報發布失敗
+	require.Error(t, scheduler.Run(context.Background(), "daily_security"))
+	status := scheduler.Status()
+	require.Len(t, status, 2)
+	assert.Equal(t, 1, status[0].Runs)
+	assert.NotEmpty(t, status[0].LastError)
+
+	queue := &recordingQueue{}
+	scheduler.SetPublisher(queue, "")
+	require.NoError(t, scheduler.Run(context.Background(), "daily_security"))
+	require.NoError(t, scheduler.Run(context.Background(), "hourly_system"))
+	assert.Error(t, scheduler.Run(context.Background(), "missing"))
+
+	file := filepath.Join(dir, "daily_security_20240102T030405Z.csv")
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "section,metric,value\nsummary,total_threats,1\n"))
+
+	status = scheduler.Status()
+	assert.Equal(t, ReportRunStatus{Name: "daily_security", Runs: 2, LastRun: scheduler.now(), LastFile: file}, status[0])
+
+	messages := queue.published(pubsub.GetRoutingKey(pubsub.EventTypeReportGenerated))
+	require.Len(t, messages, 2)
+	var event pubsub.ReportEvent
+	require.NoError(t, json.Unmarshal(messages[0], &event))
+	assert.Equal(t, "daily_security", event.Name)
+	assert.Equal(t, "text/csv; charset=utf-8", event.ContentType)
+	assert.Equal(t, file, event.File)
+	assert.Equal(t, content, event.Content)
+
+	var systemEvent pubsub.ReportEvent
+	require.NoError(t, json.Unmarshal(messages[1], &systemEvent))
+	assert.Equal(t, "system", systemEvent.ReportType)
+	assert.Empty(t, systemEvent.File)
+	assert.True(t, strings.HasPrefix(string(systemEvent.Content), "%PDF-1.4"))
+}
+
+func TestReportScheduleEndpoints(t *testing.T) {
+	engine, _ := newBehaviorEngine(t)
+	ui := NewUIServer(engine.logger, engine.metrics)
+	router := ui.setupRouter()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/reports/schedules", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	scheduler, err := NewReportScheduler(engine, engine.logger, []ReportSchedule{
+		{Name: "system", ReportType: "system", Format: "html", Interval: time.Hour, OutputDir: t.TempDir()},
+	})
+	require.NoError(t, err)
+	ui.SetReportScheduler(scheduler)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/reports/schedules/system/run", nil))
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var status ReportRunStatus
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, 1, status.Runs)
+	assert.True(t, strings.HasSuffix(status.LastFile, ".html"))
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/reports/schedules/missing/run", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/reports/schedules", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"runs":1`)
+}
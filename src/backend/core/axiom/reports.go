@@ -0,0 +1,442 @@
+package axiom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// reportTopEntries 報表分組統計保留的筆數
+const reportTopEntries = 10
+
+// SecurityReport 安全報表
+type SecurityReport struct {
+	ReportType   string                `json:"report_type"`
+	TimeRange    string                `json:"time_range"`
+	GeneratedAt  time.Time             `json:"generated_at"`
+	Since        *time.Time            `json:"since,omitempty"`
+	Until        time.Time             `json:"until"`
+	Summary      SecurityReportSummary `json:"summary"`
+	ThreatByType []ThreatTypeShare     `json:"threat_by_type"`
+	TopSourceIPs []IPCount             `json:"top_source_ips"`
+	Timeline     []HourlyCount         `json:"timeline"`
+}
+
+// SecurityReportSummary 安全報表摘要
+type SecurityReportSummary struct {
+	TotalThreats    int            `json:"total_threats"`
+	BlockedThreats  int            `json:"blocked_threats"`
+	ActiveThreats   int            `json:"active_threats"`
+	ResolvedThreats int            `json:"resolved_threats"`
+	ThreatTrend     float64        `json:"threat_trend"`
+	BySeverity      map[string]int `json:"by_severity"`
+}
+
+// ThreatTypeShare 威脅類型數量與佔比
+type ThreatTypeShare struct {
+	Type       string  `json:"type"`
+	Count      int     `json:"count"`
+	Percentage float64 `json:"percentage"`
+}
+
+// NetworkReport 網路報表
+type NetworkReport struct {
+	ReportType      string               `json:"report_type"`
+	TimeRange       string               `json:"time_range"`
+	GeneratedAt     time.Time            `json:"generated_at"`
+	Since           *time.Time           `json:"since,omitempty"`
+	Until           time.Time            `json:"until"`
+	Summary         NetworkReportSummary `json:"summary"`
+	TopSources      []IPCount            `json:"top_sources"`
+	TopDestinations []IPCount            `json:"top_destinations"`
+}
+
+// NetworkReportSummary 網路報表摘要
+type NetworkReportSummary struct {
+	BlockedIPs         int             `json:"blocked_ips"`
+	BlockedConnections int             `json:"blocked_connections"`
+	WhitelistedIPs     int             `json:"whitelisted_ips"`
+	Streams            ReassemblyStats `json:"streams"`
+}
+
+// BuildSecurityReport 依查詢條件建立安全報表
+func (ae *AnalysisEngine) BuildSecurityReport(ctx context.Context, query EventQuery) (*SecurityReport, error) {
+	stats, err := ae.GetSecurityStats(ctx, query, reportTopEntries)
+	if err != nil {
+		return nil, err
+	}
+	query.Until = stats.Until
+	summary, err := ae.Events().Summarize(ctx, query, reportTopEntries)
+	if err != nil {
+		return nil, fmt.Errorf("彙總安全事件失敗: %v", err)
+	}
+
+	report := &SecurityReport{
+		ReportType:  "security",
+		GeneratedAt: time.Now(),
+		Since:       stats.Since,
+		Until:       stats.Until,
+		Summary: SecurityReportSummary{
+			TotalThreats:    stats.TotalThreats,
+			BlockedThreats:  stats.BlockedThreats,
+			ActiveThreats:   stats.ActiveThreats,
+			ResolvedThreats: stats.ResolvedThreats,
+			ThreatTrend:     stats.ThreatTrend,
+			BySeverity:      stats.BySeverity,
+		},
+		ThreatByType: make([]ThreatTypeShare, 0, len(stats.TopThreatTypes)),
+		TopSourceIPs: stats.TopSourceIPs,
+		Timeline:     summary.Hourly,
+	}
+	for _, entry := range stats.TopThreatTypes {
+		share := ThreatTypeShare{Type: entry.Type, Count: entry.Count}
+		if stats.TotalThreats > 0 {
+			share.Percentage = math.Round(float64(entry.Count)/float64(stats.TotalThreats)*1000) / 10
+		}
+		report.ThreatByType = append(report.ThreatByType, share)
+	}
+	return report, nil
+}
+
+// BuildNetworkReport 依查詢條件建立網路報表
+func (ae *AnalysisEngine) BuildNetworkReport(ctx context.Context, query EventQuery) (*NetworkReport, error) {
+	store := ae.Events()
+	if store == nil {
+		return nil, fmt.Errorf("未設定事件儲存")
+	}
+
+	query.Limit, query.Offset = 0, 0
+	if query.Until.IsZero() {
+		query.Until = time.Now()
+	}
+	summary, err := store.Summarize(ctx, query, reportTopEntries)
+	if err != nil {
+		return nil, fmt.Errorf("彙總安全事件失敗: %v", err)
+	}
+
+	report := &NetworkReport{
+		ReportType:  "network",
+		GeneratedAt: time.Now(),
+		Until:       query.Until,
+		Summary: NetworkReportSummary{
+			BlockedIPs:         len(ae.GetBlacklist()),
+			BlockedConnections: summary.Blocked,
+			WhitelistedIPs:     len(ae.GetWhitelist()),
+			Streams:            ae.GetStreamStats(),
+		},
+		TopSources:      make([]IPCount, 0, len(summary.TopSources)),
+		TopDestinations: make([]IPCount, 0, len(summary.TopDestinations)),
+	}
+	if !query.Since.IsZero() {
+		since := query.Since
+		report.Since = &since
+	}
+	for _, entry := range summary.TopSources {
+		report.TopSources = append(report.TopSources, IPCount{IP: entry.Key, Count: entry.Count})
+	}
+	for _, entry := range summary.TopDestinations {
+		report.TopDestinations = append(report.TopDestinations, IPCount{IP: entry.Key, Count: entry.Count})
+	}
+	return report, nil
+}
+
+// SystemReport 系統報表
+type SystemReport struct {
+	ReportType  string              `json:"report_type"`
+	TimeRange   string              `json:"time_range"`
+	GeneratedAt time.Time           `json:"generated_at"`
+	Summary     SystemReportSummary `json:"summary"`
+	RuleSet     *RuleSetInfo        `json:"ruleset,omitempty"`
+	Streams     *ReassemblyStats    `json:"streams,omitempty"`
+}
+
+// SystemReportSummary 系統報表摘要
+type SystemReportSummary struct {
+	Uptime          string `json:"uptime"`
+	UptimeSeconds   int64  `json:"uptime_seconds"`
+	Goroutines      int    `json:"goroutines"`
+	HeapAllocBytes  uint64 `json:"heap_alloc_bytes"`
+	EngineConnected bool   `json:"engine_connected"`
+	EngineRunning   bool   `json:"engine_running"`
+}
+
+// BuildSystemReport 以程序與分析引擎 (可為 nil) 的即時狀態建立系統報表，startTime 為服務啟動時間
+func BuildSystemReport(engine *AnalysisEngine, startTime time.Time) *SystemReport {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	uptime := time.Since(startTime)
+	report := &SystemReport{
+		ReportType:  "system",
+		GeneratedAt: time.Now(),
+		Summary: SystemReportSummary{
+			Uptime:          uptime.Round(time.Second).String(),
+			UptimeSeconds:   int64(uptime.Seconds()),
+			Goroutines:      runtime.NumGoroutine(),
+			HeapAllocBytes:  memory.HeapAlloc,
+			EngineConnected: engine != nil,
+		},
+	}
+	if engine != nil {
+		report.Summary.EngineRunning = engine.IsRunning()
+		ruleSet := engine.GetRuleSetInfo()
+		streams := engine.GetStreamStats()
+		report.RuleSet = &ruleSet
+		report.Streams = &streams
+	}
+	return report
+}
+
+// BuildReport 依報表類型 (security、network、system) 建立報表；security 與 network 需要分析引擎，
+// startTime 為服務啟動時間，用於系統報表的運行時間
+func BuildReport(ctx context.Context, engine *AnalysisEngine, reportType string, query EventQuery, timeRange string, startTime time.Time) (Report, error) {
+	if engine == nil && reportType != "system" {
+		return nil, fmt.Errorf("分析引擎未連接")
+	}
+
+	switch reportType {
+	case "security":
+		report, err := engine.BuildSecurityReport(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		report.TimeRange = timeRange
+		return report, nil
+	case "network":
+		report, err := engine.BuildNetworkReport(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		report.TimeRange = timeRange
+		return report, nil
+	case "system":
+		report := BuildSystemReport(engine, startTime)
+		report.TimeRange = timeRange
+		return report, nil
+	default:
+		return nil, fmt.Errorf("不支援的報表類型: %s", reportType)
+	}
+}
+
+// Report 可輸出為 CSV、HTML 與 PDF 的報表；JSON 直接序列化報表本身
+type Report interface {
+	WriteCSV(w io.Writer) error
+	WriteHTML(w io.Writer) error
+	WritePDF(w io.Writer) error
+}
+
+// ReportFormat 報表輸出格式
+type ReportFormat struct {
+	ContentType string
+	Extension   string
+}
+
+// ReportFormats 支援的報表格式
+var ReportFormats = map[string]ReportFormat{
+	"json": {ContentType: "application/json", Extension: "json"},
+	"csv":  {ContentType: "text/csv; charset=utf-8", Extension: "csv"},
+	"html": {ContentType: "text/html; charset=utf-8", Extension: "html"},
+	"pdf":  {ContentType: "application/pdf", Extension: "pdf"},
+}
+
+// WriteReport 以指定格式輸出報表
+func WriteReport(w io.Writer, report Report, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "csv":
+		return report.WriteCSV(w)
+	case "html":
+		return report.WriteHTML(w)
+	case "pdf":
+		return report.WritePDF(w)
+	default:
+		return fmt.Errorf("不支援的報表格式: %s", format)
+	}
+}
+
+// reportMeta 報表共用的資訊列
+func reportMeta(timeRange string, generatedAt time.Time, since *time.Time, until time.Time) [][2]string {
+	meta := [][2]string{
+		{"Time range", timeRange},
+		{"Generated at", generatedAt.UTC().Format(time.RFC3339)},
+	}
+	if since != nil {
+		meta = append(meta, [2]string{"Since", since.UTC().Format(time.RFC3339)})
+	}
+	if !until.IsZero() {
+		meta = append(meta, [2]string{"Until", until.UTC().Format(time.RFC3339)})
+	}
+	return meta
+}
+
+// ipCountSection IP 統計區段
+func ipCountSection(key, title string, entries []IPCount) reportSection {
+	section := reportSection{Key: key, Title: title, Headers: []string{"ip", "count"}, Chart: &reportChart{}}
+	for _, entry := range entries {
+		section.Rows = append(section.Rows, []string{entry.IP, strconv.Itoa(entry.Count)})
+		section.Chart.Labels = append(section.Chart.Labels, entry.IP)
+		section.Chart.Values = append(section.Chart.Values, float64(entry.Count))
+	}
+	return section
+}
+
+// document 安全報表內容
+func (r *SecurityReport) document() *reportDocument {
+	summary := reportSection{
+		Key:     "summary",
+		Title:   "Summary",
+		Headers: []string{"metric", "value"},
+		Rows: [][]string{
+			{"total_threats", strconv.Itoa(r.Summary.TotalThreats)},
+			{"blocked_threats", strconv.Itoa(r.Summary.BlockedThreats)},
+			{"active_threats", strconv.Itoa(r.Summary.ActiveThreats)},
+			{"resolved_threats", strconv.Itoa(r.Summary.ResolvedThreats)},
+			{"threat_trend", strconv.FormatFloat(r.Summary.ThreatTrend, 'f', 1, 64)},
+		},
+	}
+
+	severity := reportSection{Key: "severity", Title: "Threats by Severity", Headers: []string{"severity", "count"}, Chart: &reportChart{}}
+	for _, level := range []string{"critical", "high", "medium", "low"} {
+		count := r.Summary.BySeverity[level]
+		severity.Rows = append(severity.Rows, []string{level, strconv.Itoa(count)})
+		severity.Chart.Labels = append(severity.Chart.Labels, level)
+		severity.Chart.Values = append(severity.Chart.Values, float64(count))
+	}
+
+	types := reportSection{Key: "threat_type", Title: "Threats by Type", Headers: []string{"type", "count", "percentage"}, Chart: &reportChart{}}
+	for _, share := range r.ThreatByType {
+		types.Rows = append(types.Rows, []string{share.Type, strconv.Itoa(share.Count), strconv.FormatFloat(share.Percentage, 'f', 1, 64)})
+		types.Chart.Labels = append(types.Chart.Labels, share.Type)
+		types.Chart.Values = append(types.Chart.Values, float64(share.Count))
+	}
+
+	timeline := reportSection{Key: "timeline", Title: "Hourly Timeline", Headers: []string{"hour", "count"}, Chart: &reportChart{}}
+	for _, bucket := range r.Timeline {
+		hour := bucket.Hour.UTC().Format(time.RFC3339)
+		timeline.Rows = append(timeline.Rows, []string{hour, strconv.Itoa(bucket.Count)})
+		timeline.Chart.Labels = append(timeline.Chart.Labels, bucket.Hour.UTC().Format("01-02 15:04"))
+		timeline.Chart.Values = append(timeline.Chart.Values, float64(bucket.Count))
+	}
+
+	return &reportDocument{
+		Title:    "Security Report",
+		Meta:     reportMeta(r.TimeRange, r.GeneratedAt, r.Since, r.Until),
+		Sections: []reportSection{summary, severity, types, ipCountSection("source_ip", "Top Source IPs", r.TopSourceIPs), timeline},
+	}
+}
+
+// document 網路報表內容
+func (r *NetworkReport) document() *reportDocument {
+	summary := reportSection{
+		Key:     "summary",
+		Title:   "Summary",
+		Headers: []string{"metric", "value"},
+		Rows: [][]string{
+			{"blocked_ips", strconv.Itoa(r.Summary.BlockedIPs)},
+			{"blocked_connections", strconv.Itoa(r.Summary.BlockedConnections)},
+			{"whitelisted_ips", strconv.Itoa(r.Summary.WhitelistedIPs)},
+			{"active_flows", strconv.Itoa(r.Summary.Streams.ActiveFlows)},
+			{"reassembly_memory_bytes", strconv.Itoa(r.Summary.Streams.MemoryUsed)},
+			{"overlap_conflicts", strconv.FormatInt(r.Summary.Streams.OverlapConflicts, 10)},
+		},
+	}
+
+	return &reportDocument{
+		Title: "Network Report",
+		Meta:  reportMeta(r.TimeRange, r.GeneratedAt, r.Since, r.Until),
+		Sections: []reportSection{
+			summary,
+			ipCountSection("source_ip", "Top Source IPs", r.TopSources),
+			ipCountSection("destination_ip", "Top Destination IPs", r.TopDestinations),
+		},
+	}
+}
+
+// document 系統報表內容
+func (r *SystemReport) document() *reportDocument {
+	summary := reportSection{
+		Key:     "summary",
+		Title:   "Summary",
+		Headers: []string{"metric", "value"},
+		Rows: [][]string{
+			{"uptime", r.Summary.Uptime},
+			{"uptime_seconds", strconv.FormatInt(r.Summary.UptimeSeconds, 10)},
+			{"goroutines", strconv.Itoa(r.Summary.Goroutines)},
+			{"heap_alloc_bytes", strconv.FormatUint(r.Summary.HeapAllocBytes, 10)},
+			{"engine_connected", strconv.FormatBool(r.Summary.EngineConnected)},
+			{"engine_running", strconv.FormatBool(r.Summary.EngineRunning)},
+		},
+	}
+	sections := []reportSection{summary}
+
+	if r.RuleSet != nil {
+		sections = append(sections, reportSection{
+			Key:     "ruleset",
+			Title:   "Rule Set",
+			Headers: []string{"field", "value"},
+			Rows: [][]string{
+				{"version", strconv.FormatInt(r.RuleSet.Version, 10)},
+				{"hash", r.RuleSet.Hash},
+				{"source", r.RuleSet.Source},
+				{"rule_count", strconv.Itoa(r.RuleSet.RuleCount)},
+			},
+		})
+	}
+	if r.Streams != nil {
+		sections = append(sections, reportSection{
+			Key:     "streams",
+			Title:   "Stream Reassembly",
+			Headers: []string{"metric", "value"},
+			Rows: [][]string{
+				{"active_flows", strconv.Itoa(r.Streams.ActiveFlows)},
+				{"memory_used", strconv.Itoa(r.Streams.MemoryUsed)},
+				{"segments", strconv.FormatInt(r.Streams.Segments, 10)},
+				{"out_of_order", strconv.FormatInt(r.Streams.OutOfOrder, 10)},
+				{"retransmissions", strconv.FormatInt(r.Streams.Retransmissions, 10)},
+				{"overlap_conflicts", strconv.FormatInt(r.Streams.OverlapConflicts, 10)},
+				{"flows_evicted", strconv.FormatInt(r.Streams.FlowsEvicted, 10)},
+			},
+		})
+	}
+
+	return &reportDocument{
+		Title:    "System Report",
+		Meta:     reportMeta(r.TimeRange, r.GeneratedAt, nil, time.Time{}),
+		Sections: sections,
+	}
+}
+
+// WriteCSV 每個區段輸出獨立標頭的 CSV
+func (r *SecurityReport) WriteCSV(w io.Writer) error { return writeCSVDocument(w, r.document()) }
+
+// WriteHTML 輸出含內嵌 SVG 圖表的自包含 HTML
+func (r *SecurityReport) WriteHTML(w io.Writer) error { return writeHTMLDocument(w, r.document()) }
+
+// WritePDF 輸出 PDF
+func (r *SecurityReport) WritePDF(w io.Writer) error { return writePDFDocument(w, r.document()) }
+
+// WriteCSV 每個區段輸出獨立標頭的 CSV
+func (r *NetworkReport) WriteCSV(w io.Writer) error { return writeCSVDocument(w, r.document()) }
+
+// WriteHTML 輸出含內嵌 SVG 圖表的自包含 HTML
+func (r *NetworkReport) WriteHTML(w io.Writer) error { return writeHTMLDocument(w, r.document()) }
+
+// WritePDF 輸出 PDF
+func (r *NetworkReport) WritePDF(w io.Writer) error { return writePDFDocument(w, r.document()) }
+
+// WriteCSV 每個區段輸出獨立標頭的 CSV
+func (r *SystemReport) WriteCSV(w io.Writer) error { return writeCSVDocument(w, r.document()) }
+
+// WriteHTML 輸出自包含 HTML
+func (r *SystemReport) WriteHTML(w io.Writer) error { return writeHTMLDocument(w, r.document()) }
+
+// WritePDF 輸出 PDF
+func (r *SystemReport) WritePDF(w io.Writer) error { return writePDFDocument(w, r.document()) }
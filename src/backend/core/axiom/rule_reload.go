@@ -0,0 +1,495 @@
+package axiom
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/pubsub"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ruleSetState 規則集版本狀態，由 mutex 保護
+type ruleSetState struct {
+	version   int64
+	source    string
+	updatedAt time.Time
+}
+
+// RuleSetInfo 目前生效的規則集資訊
+type RuleSetInfo struct {
+	Version   int64     `json:"version"` // 每次規則變更遞增
+	Hash      string    `json:"hash"`    // 生效規則定義 (依評估順序) 的 SHA-256
+	Source    string    `json:"source"`  // 最後一次變更的來源: api, snort, store, directory
+	RuleCount int       `json:"rule_count"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// rulesChanged 規則變更後重建預先過濾器並遞增規則集版本 (呼叫者需持有寫鎖)
+func (ae *AnalysisEngine) rulesChanged(source string) {
+	ae.rebuildPrefilter()
+	ae.ruleSet.version++
+	ae.ruleSet.source = source
+	ae.ruleSet.updatedAt = time.Now()
+}
+
+// GetRuleSetInfo 取得目前生效的規則集版本與雜湊
+func (ae *AnalysisEngine) GetRuleSetInfo() RuleSetInfo {
+	ae.mutex.RLock()
+	defer ae.mutex.RUnlock()
+
+	return RuleSetInfo{
+		Version:   ae.ruleSet.version,
+		Hash:      hashRules(ae.rules),
+		Source:    ae.ruleSet.source,
+		RuleCount: len(ae.rules),
+		UpdatedAt: ae.ruleSet.updatedAt,
+	}
+}
+
+// hashRules 計算規則定義的雜湊；時間戳記不影響雜湊
+func hashRules(rules []*SecurityRule) string {
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+	for _, rule := range rules {
+		copied := *rule
+		copied.CreatedAt, copied.UpdatedAt = time.Time{}, time.Time{}
+		encoder.Encode(&copied)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ReplaceRules 以新規則集原子替換引擎中來自 source 的規則，其他來源 (API、Snort、預設規則) 保持不變；
+// 同 ID 的其他規則由新規則取代，任一規則驗證失敗時保留原規則集。
+// 替換的規則不寫入 StateStore，來源 (例如規則目錄) 本身即為持久化的依據
+func (ae *AnalysisEngine) ReplaceRules(rules []*SecurityRule, source string) error {
+	if err := ValidateRules(rules); err != nil {
+		return err
+	}
+
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	ids := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		ids[rule.ID] = true
+	}
+
+	// 新規則放在原本該來源第一條規則的位置，維持與其他規則的評估順序
+	existing := make(map[string]*SecurityRule)
+	kept := make([]*SecurityRule, 0, len(ae.rules))
+	insertAt := -1
+	for _, rule := range ae.rules {
+		if rule.Source != source && !ids[rule.ID] {
+			kept = append(kept, rule)
+			continue
+		}
+		if insertAt < 0 {
+			insertAt = len(kept)
+		}
+		existing[rule.ID] = rule
+	}
+	if insertAt < 0 {
+		insertAt = len(kept)
+	}
+
+	for _, rule := range rules {
+		ae.prepareRule(rule)
+		rule.Source = source
+		if old, ok := existing[rule.ID]; ok {
+			rule.CreatedAt = old.CreatedAt
+		}
+	}
+
+	ae.rules = slices.Insert(kept, insertAt, rules...)
+	ae.rulesChanged(source)
+
+	ae.logger.Infof("已替換規則集: %d 個規則 (來源: %s, 總規則數: %d, 版本: %d)", len(rules), source, len(ae.rules), ae.ruleSet.version)
+	return nil
+}
+
+// validRuleTypes 支援的規則類型
+var validRuleTypes = map[string]bool{"ip": true, "port": true, "pattern": true, "behavior": true, "signature": true}
+
+// validRuleActions 支援的規則動作
+var validRuleActions = map[string]bool{"block": true, "alert": true, "log": true}
+
+// validRuleSeverities 支援的嚴重程度
+var validRuleSeverities = map[string]bool{"low": true, "medium": true, "high": true, "critical": true}
+
+// ValidateRules 驗證規則集 (必要欄位、列舉值、ID 唯一、正則表達式與簽章可編譯)，
+// 回傳所有錯誤的合併結果
+func ValidateRules(rules []*SecurityRule) error {
+	var errs []error
+	seen := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		if rule == nil {
+			errs = append(errs, fmt.Errorf("規則 #%d: 定義為空", i+1))
+			continue
+		}
+		if rule.ID != "" {
+			if seen[rule.ID] {
+				errs = append(errs, fmt.Errorf("規則 %s: ID 重複", rule.ID))
+				continue
+			}
+			seen[rule.ID] = true
+		}
+		if err := validateRule(rule); err != nil {
+			name := rule.ID
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			errs = append(errs, fmt.Errorf("規則 %s: %v", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateRule 驗證單一規則並編譯其比對結構
+func validateRule(rule *SecurityRule) error {
+	switch {
+	case rule.ID == "":
+		return fmt.Errorf("缺少 id")
+	case !validRuleTypes[rule.Type]:
+		return fmt.Errorf("不支援的規則類型: %q", rule.Type)
+	case !validRuleActions[rule.Action]:
+		return fmt.Errorf("不支援的動作: %q", rule.Action)
+	case !validRuleSeverities[rule.Severity]:
+		return fmt.Errorf("不支援的嚴重程度: %q", rule.Severity)
+	}
+
+	switch rule.Type {
+	case "signature":
+		// 簽章規則可直接以 pattern 撰寫 Snort 規則
+		if rule.Signature == nil && rule.Pattern != "" {
+			rule.Signature = &SnortSignature{Raw: rule.Pattern}
+		}
+		if rule.Signature != nil && rule.Pattern == "" {
+			rule.Pattern = rule.Signature.Raw
+		}
+	case "behavior":
+		if _, ok := defaultBehaviorThresholds[rule.Pattern]; !ok {
+			return fmt.Errorf("不支援的行為模式: %q", rule.Pattern)
+		}
+	default:
+		if rule.Pattern == "" {
+			return fmt.Errorf("缺少 pattern")
+		}
+	}
+	if rule.Behavior != nil && rule.Type != "behavior" {
+		return fmt.Errorf("只有 behavior 規則可設定 behavior 門檻")
+	}
+
+	return prepareStoredRule(rule)
+}
+
+// ruleFileExtensions 規則目錄中會載入的副檔名
+var ruleFileExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true, ".rules": true}
+
+// ruleFile 規則檔內容；檔案頂層亦可直接為規則陣列
+type ruleFile struct {
+	Rules []*fileRule `json:"rules"`
+}
+
+// fileRule 規則檔中的規則；未指定 enabled 時預設啟用
+type fileRule struct {
+	*SecurityRule
+	Enabled *bool `json:"enabled"`
+}
+
+// LoadRulesDirectory 讀取並驗證目錄中的規則檔 (YAML、JSON 與 Snort .rules)，依檔名順序
+// 合併，並回傳規則與檔案內容的雜湊。以 "." 開頭的檔案 (編輯器暫存檔等) 會被忽略
+func LoadRulesDirectory(dir string) ([]*SecurityRule, string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("讀取規則目錄失敗: %v", err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !ruleFileExtensions[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	rules := make([]*SecurityRule, 0)
+	origin := make(map[string]string) // 規則 ID -> 檔名
+	var errs []error
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("規則檔 %s: %v", name, err))
+			continue
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("規則檔 %s: %v", name, err))
+			continue
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", name, len(data))
+		hash.Write(data)
+
+		parsed, err := parseRuleFile(name, data)
+		if err == nil {
+			err = ValidateRules(parsed)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("規則檔 %s: %v", name, err))
+			continue
+		}
+		for _, rule := range parsed {
+			if previous, exists := origin[rule.ID]; exists {
+				errs = append(errs, fmt.Errorf("規則檔 %s: 規則 %s 與 %s 的 ID 重複", name, rule.ID, previous))
+				continue
+			}
+			origin[rule.ID] = name
+		}
+		rules = append(rules, parsed...)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, "", err
+	}
+	return rules, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// parseRuleFile 依副檔名解析規則檔
+func parseRuleFile(name string, data []byte) ([]*SecurityRule, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".rules":
+		rules, report, err := NewSnortParser(nil).ParseRules(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if len(report.Errors) > 0 {
+			errs := make([]error, 0, len(report.Errors))
+			for _, perr := range report.Errors {
+				errs = append(errs, perr)
+			}
+			return nil, errors.Join(errs...)
+		}
+		return rules, nil
+	case ".yaml", ".yml":
+		var document interface{}
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("解析 YAML 失敗: %v", err)
+		}
+		// 轉為 JSON 後與 JSON 規則檔共用欄位名稱與結構檢查
+		converted, err := json.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("轉換 YAML 失敗: %v", err)
+		}
+		return decodeRuleDocument(converted)
+	default:
+		return decodeRuleDocument(data)
+	}
+}
+
+// decodeRuleDocument 解析 JSON 規則文件，不允許未知欄位
+func decodeRuleDocument(data []byte) ([]*SecurityRule, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil, nil
+	}
+
+	var items []*fileRule
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if data[0] == '[' {
+		if err := decoder.Decode(&items); err != nil {
+			return nil, fmt.Errorf("解析規則失敗: %v", err)
+		}
+	} else {
+		var document ruleFile
+		if err := decoder.Decode(&document); err != nil {
+			return nil, fmt.Errorf("解析規則失敗: %v", err)
+		}
+		items = document.Rules
+	}
+
+	rules := make([]*SecurityRule, 0, len(items))
+	for i, item := range items {
+		if item == nil || item.SecurityRule == nil {
+			return nil, fmt.Errorf("規則 #%d 定義為空", i+1)
+		}
+		item.SecurityRule.Enabled = item.Enabled == nil || *item.Enabled
+		rules = append(rules, item.SecurityRule)
+	}
+	return rules, nil
+}
+
+// defaultRuleReloadDebounce 檔案變更後等待多久才重新載入，合併編輯器的連續寫入
+const defaultRuleReloadDebounce = 500 * time.Millisecond
+
+// RuleWatcherStatus 規則目錄監看狀態
+type RuleWatcherStatus struct {
+	Directory  string    `json:"directory"`
+	FileHash   string    `json:"file_hash"` // 最後一次成功載入的檔案內容雜湊
+	LastReload time.Time `json:"last_reload"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// RuleWatcher 監看規則目錄，檔案變更時驗證並原子替換引擎規則集；
+// 驗證失敗時保留原規則集並發布 system.error 事件
+type RuleWatcher struct {
+	engine    *AnalysisEngine
+	dir       string
+	logger    *logrus.Logger
+	publisher pubsub.MessageQueue
+	exchange  string
+	debounce  time.Duration
+	status    RuleWatcherStatus
+	mutex     sync.Mutex
+}
+
+// NewRuleWatcher 建立規則目錄監看器
+func NewRuleWatcher(engine *AnalysisEngine, dir string, logger *logrus.Logger) *RuleWatcher {
+	return &RuleWatcher{
+		engine:   engine,
+		dir:      dir,
+		logger:   logger,
+		exchange: "pandora.events",
+		debounce: defaultRuleReloadDebounce,
+		status:   RuleWatcherStatus{Directory: dir},
+	}
+}
+
+// SetPublisher 設定載入失敗時發布 system.error 事件的消息隊列
+func (rw *RuleWatcher) SetPublisher(mq pubsub.MessageQueue, exchange string) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	rw.publisher = mq
+	if exchange != "" {
+		rw.exchange = exchange
+	}
+}
+
+// Status 取得監看狀態
+func (rw *RuleWatcher) Status() RuleWatcherStatus {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	return rw.status
+}
+
+// Reload 重新載入規則目錄；檔案內容未變更時不替換規則集
+func (rw *RuleWatcher) Reload(ctx context.Context) error {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	rules, fileHash, err := LoadRulesDirectory(rw.dir)
+	if err == nil && fileHash == rw.status.FileHash {
+		return nil
+	}
+	if err == nil {
+		err = rw.engine.ReplaceRules(rules, "directory")
+	}
+	if err != nil {
+		rw.status.LastError = err.Error()
+		rw.logger.Errorf("重新載入規則目錄 %s 失敗，保留原規則集: %v", rw.dir, err)
+		rw.publishError(ctx, err)
+		return fmt.Errorf("重新載入規則失敗: %v", err)
+	}
+
+	rw.status.FileHash = fileHash
+	rw.status.LastReload = time.Now()
+	rw.status.LastError = ""
+	return nil
+}
+
+// publishError 發布規則載入失敗事件 (呼叫者需持有鎖)
+func (rw *RuleWatcher) publishError(ctx context.Context, cause error) {
+	if rw.publisher == nil {
+		return
+	}
+
+	event := pubsub.NewSystemEvent("axiom-engine", "error", "規則集重新載入失敗，已保留原規則集")
+	event.Type = pubsub.EventTypeSystemError
+	event.Severity = "high"
+	event.ErrorCode = "RULE_RELOAD_FAILED"
+	event.ErrorDetails = cause.Error()
+	event.Metadata["directory"] = rw.dir
+	event.Metadata["active_version"] = rw.engine.GetRuleSetInfo().Version
+
+	message, err := pubsub.ToJSON(event)
+	if err != nil {
+		rw.logger.Errorf("序列化系統錯誤事件失敗: %v", err)
+		return
+	}
+	if err := rw.publisher.Publish(ctx, rw.exchange, pubsub.GetRoutingKey(pubsub.EventTypeSystemError), message); err != nil {
+		rw.logger.Errorf("發布系統錯誤事件失敗: %v", err)
+	}
+}
+
+// Start 載入規則目錄並開始監看，直到 ctx 取消。
+// 初次載入失敗時引擎沿用既有規則，仍會繼續監看等待修正
+func (rw *RuleWatcher) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("建立檔案監看失敗: %v", err)
+	}
+	if err := watcher.Add(rw.dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("監看規則目錄失敗: %v", err)
+	}
+
+	rw.Reload(ctx)
+
+	go rw.watch(ctx, watcher)
+	return nil
+}
+
+// watch 處理檔案事件，以 debounce 合併連續變更
+func (rw *RuleWatcher) watch(ctx context.Context, watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+
+	timer := time.NewTimer(rw.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// 不過濾檔名：ConfigMap 等以符號連結切換目錄的方式只會觸發隱藏檔事件，
+			// 內容未變更時 Reload 不會替換規則集
+			rw.logger.Debugf("規則目錄變更: %s", event)
+			timer.Reset(rw.debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			rw.logger.Errorf("監看規則目錄錯誤: %v", err)
+		case <-timer.C:
+			rw.Reload(ctx)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package axiom
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/metrics"
+	"pandora_box_console_ids_ips/internal/pubsub"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingQueue 記錄發布訊息的消息隊列
+type recordingQueue struct {
+	messages map[string][][]byte
+	mutex    sync.Mutex
+}
+
+func (q *recordingQueue) Publish(ctx context.Context, exchange, routingKey string, message []byte) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.messages == nil {
+		q.messages = make(map[string][][]byte)
+	}
+	q.messages[routingKey] = append(q.messages[routingKey], message)
+	return nil
+}
+
+func (q *recordingQueue) Subscribe(ctx context.Context, queue string, handler pubsub.MessageHandler) error {
+	return nil
+}
+
+func (q *recordingQueue) Close() error { return nil }
+
+func (q *recordingQueue) Health(ctx context.Context) error { return nil }
+
+func (q *recordingQueue) published(routingKey string) [][]byte {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return append([][]byte(nil), q.messages[routingKey]...)
+}
+
+const yamlRules = `
+rules:
+  - id: sqli_union
+    name: SQL注入 UNION
+    type: pattern
+    pattern: "regex:(?i)union\\s+select"
+    action: block
+    severity: high
+  - id: scan_fast
+    name: 快速掃描
+    type: behavior
+    pattern: port_scan
+    action: alert
+    severity: medium
+    enabled: false
+    behavior:
+      window_seconds: 10
+      distinct_ports: 5
+`
+
+const jsonRules = `[
+  {"id": "telnet", "name": "Telnet", "type": "port", "pattern": "23", "action": "alert", "severity": "low"},
+  {"id": "passwd_sig", "name": "passwd", "type": "signature", "action": "alert", "severity": "high",
+   "pattern": "alert tcp any any -> any 80 (msg:\"passwd\"; content:\"/etc/passwd\"; sid:9001; rev:1;)"}
+]`
+
+const snortRules = `alert tcp any any -> any any (msg:"shellshock"; content:"() {"; sid:9002; rev:1;)
+`
+
+func writeRuleFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+}
+
+func TestLoadRulesDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeRuleFile(t, dir, "10-web.yaml", yamlRules)
+	writeRuleFile(t, dir, "20-misc.json", jsonRules)
+	writeRuleFile(t, dir, "30-community.rules", snortRules)
+	writeRuleFile(t, dir, ".20-misc.json.swp", "garbage")
+	writeRuleFile(t, dir, "README.md", "garbage")
+
+	rules, hash, err := LoadRulesDirectory(dir)
+	require.NoError(t, err)
+	require.Len(t, rules, 5)
+	assert.NotEmpty(t, hash)
+
+	ids := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		ids = append(ids, rule.ID)
+	}
+	assert.Equal(t, []string{"sqli_union", "scan_fast", "telnet", "passwd_sig", "sid_9002"}, ids)
+	assert.True(t, rules[0].Enabled, "未指定 enabled 時預設啟用")
+	assert.False(t, rules[1].Enabled)
+	assert.Equal(t, 5, rules[1].Behavior.DistinctPorts)
+	require.NoError(t, ValidateRules(rules))
+	require.NotNil(t, rules[3].Signature)
+	assert.Equal(t, 9001, rules[3].Signature.SID)
+
+	// 內容不變時雜湊不變
+	_, again, err := LoadRulesDirectory(dir)
+	require.NoError(t, err)
+	assert.Equal(t, hash, again)
+
+	writeRuleFile(t, dir, "40-bad.yaml", "rules:\n  - id: x\n    patern: typo\n")
+	_, _, err = LoadRulesDirectory(dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "40-bad.yaml")
+	assert.Contains(t, err.Error(), "patern")
+}
+
+func TestValidateRules(t *testing.T) {
+	valid := func() *SecurityRule {
+		return &SecurityRule{ID: "r1", Type: "pattern", Pattern: "evil", Action: "alert", Severity: "low"}
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(rule *SecurityRule)
+		errMsg string
+	}{
+		{"缺少 id", func(r *SecurityRule) { r.ID = "" }, "缺少 id"},
+		{"未知類型", func(r *SecurityRule) { r.Type = "magic" }, "規則類型"},
+		{"未知動作", func(r *SecurityRule) { r.Action = "drop" }, "動作"},
+		{"未知嚴重程度", func(r *SecurityRule) { r.Severity = "urgent" }, "嚴重程度"},
+		{"缺少 pattern", func(r *SecurityRule) { r.Pattern = "" }, "pattern"},
+		{"正則表達式錯誤", func(r *SecurityRule) { r.Pattern = "regex:(unclosed" }, "正則表達式"},
+		{"IP 規則格式錯誤", func(r *SecurityRule) { r.Type, r.Pattern = "ip", "10.0.0.1, 10.0.0" }, "無效的 IP 規則"},
+		{"未知行為", func(r *SecurityRule) { r.Type, r.Pattern = "behavior", "teleport" }, "行為模式"},
+		{"簽章錯誤", func(r *SecurityRule) { r.Type, r.Pattern = "signature", "alert tcp any any" }, "規則 r1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := valid()
+			tc.modify(rule)
+			err := ValidateRules([]*SecurityRule{rule})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.errMsg)
+		})
+	}
+
+	err := ValidateRules([]*SecurityRule{valid(), valid()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ID 重複")
+}
+
+func TestRuleWatcherReloadAndRollback(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.FatalLevel)
+	engine := NewAnalysisEngine(logger, metrics.NewPrometheusMetrics(logger))
+	engine.mutex.Lock()
+	engine.running = true
+	engine.mutex.Unlock()
+
+	dir := t.TempDir()
+	writeRuleFile(t, dir, "web.yaml", yamlRules)
+
+	// 其他來源的規則不受目錄重新載入影響；預設的 SQL 注入規則會先於目錄規則匹配，先移除
+	require.NoError(t, engine.RemoveRule("rule_004"))
+	require.NoError(t, engine.AddRule(&SecurityRule{ID: "api_rule", Name: "api", Type: "pattern", Pattern: "api-only", Action: "alert", Severity: "low", Enabled: true}))
+	initial := len(engine.GetRules())
+
+	queue := &recordingQueue{}
+	watcher := NewRuleWatcher(engine, dir, logger)
+	watcher.SetPublisher(queue, "")
+	watcher.debounce = 20 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, watcher.Start(ctx))
+
+	loaded := engine.GetRuleSetInfo()
+	assert.Equal(t, "directory", loaded.Source)
+	assert.Equal(t, initial+2, loaded.RuleCount, "只替換目錄來源的規則")
+	assert.NotEmpty(t, watcher.Status().FileHash)
+
+	result, err := engine.AnalyzePacket(&NetworkPacket{SourceIP: "203.0.113.9", DestIP: "172.16.0.44", DestPort: 80, Protocol: "TCP", PayloadString: "id=1 UNION SELECT password"})
+	require.NoError(t, err)
+	assert.Equal(t, "sqli_union", result.RuleID)
+
+	// 內容未變更時不替換
+	require.NoError(t, watcher.Reload(ctx))
+	assert.Equal(t, loaded.Version, engine.GetRuleSetInfo().Version)
+
+	// 無效的規則：保留原規則集並發布 system.error
+	writeRuleFile(t, dir, "broken.json", `[{"id": "bad", "type": "pattern", "pattern": "regex:([", "action": "block", "severity": "high"}]`)
+	require.Eventually(t, func() bool {
+		return len(queue.published("system.error")) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	current := engine.GetRuleSetInfo()
+	assert.Equal(t, loaded.Version, current.Version)
+	assert.Equal(t, loaded.Hash, current.Hash)
+	assert.Contains(t, watcher.Status().LastError, "broken.json")
+
+	var event pubsub.SystemEvent
+	require.NoError(t, json.Unmarshal(queue.published("system.error")[0], &event))
+	assert.Equal(t, pubsub.EventTypeSystemError, event.Type)
+	assert.Equal(t, "RULE_RELOAD_FAILED", event.ErrorCode)
+	assert.Contains(t, event.ErrorDetails, "正則表達式")
+
+	// 修正後自動載入
+	writeRuleFile(t, dir, "broken.json", `[{"id": "good", "type": "pattern", "pattern": "regex:(fixed)", "action": "block", "severity": "high"}]`)
+	require.Eventually(t, func() bool {
+		return engine.GetRuleSetInfo().RuleCount == initial+3
+	}, 2*time.Second, 10*time.Millisecond)
+	sources := make(map[string]int)
+	for _, rule := range engine.GetRules() {
+		sources[rule.Source]++
+	}
+	assert.Equal(t, 3, sources["directory"])
+	assert.Equal(t, 1, sources["api"])
+	assert.Equal(t, 3, sources["default"])
+	assert.Empty(t, watcher.Status().LastError)
+	assert.Greater(t, engine.GetRuleSetInfo().Version, loaded.Version)
+}
+
+func TestRuleSetHashIgnoresTimestamps(t *testing.T) {
+	rules := func() []*SecurityRule {
+		return []*SecurityRule{{ID: "a", Type: "pattern", Pattern: "x", Action: "log", Severity: "low", Enabled: true}}
+	}
+
+	first, second := rules(), rules()
+	second[0].CreatedAt = time.Now()
+	assert.Equal(t, hashRules(first), hashRules(second))
+
+	second[0].Pattern = "y"
+	assert.NotEqual(t, hashRules(first), hashRules(second))
+}
@@ -0,0 +1,387 @@
+package axiom
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxRecentResults 可標記為誤報的最近告警結果數量
+const maxRecentResults = 10000
+
+// RuleStats 規則命中統計
+type RuleStats struct {
+	RuleID         string     `json:"rule_id"`
+	RuleName       string     `json:"rule_name"`
+	Type           string     `json:"type"`
+	Action         string     `json:"action"`
+	Enabled        bool       `json:"enabled"`
+	Hits           int64      `json:"hits"`
+	Blocks         int64      `json:"blocks"`
+	Suppressed     int64      `json:"suppressed"`      // 命中但被誤報抑制
+	FalsePositives int64      `json:"false_positives"` // 被分析師標記為誤報的次數
+	LastHit        *time.Time `json:"last_hit,omitempty"`
+}
+
+// Suppression 誤報抑制：規則在指定來源網段與目的連接埠上的匹配不產生告警
+type Suppression struct {
+	ID         string     `json:"id"`
+	RuleID     string     `json:"rule_id"`
+	SourceCIDR string     `json:"source_cidr"`
+	DestPort   int        `json:"dest_port"` // 0 表示任意連接埠
+	Reason     string     `json:"reason,omitempty"`
+	PacketID   string     `json:"packet_id,omitempty"` // 被標記為誤報的分析結果
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Hits       int64      `json:"hits"`
+}
+
+// FalsePositiveOptions 標記誤報時產生抑制條件的選項
+type FalsePositiveOptions struct {
+	SourceCIDR string        // 預設為結果的來源 IP，須包含該 IP
+	AnyPort    bool          // 不限目的連接埠
+	Reason     string        // 標記原因
+	Duration   time.Duration // 抑制有效期，0 表示永久
+}
+
+// ruleCounter 單一規則的命中計數
+type ruleCounter struct {
+	hits           int64
+	blocks         int64
+	suppressed     int64
+	falsePositives int64
+	lastHit        time.Time
+}
+
+// suppressionKey 抑制條件索引鍵
+type suppressionKey struct {
+	ruleID   string
+	destPort int
+}
+
+// ruleTuning 規則命中統計、誤報抑制與最近告警結果
+type ruleTuning struct {
+	stats        map[string]*ruleCounter
+	suppressions map[string]*Suppression
+	index        map[suppressionKey]*IPSet[*Suppression]
+	recent       map[string]*AnalysisResult
+	recentOrder  []string
+	recentNext   int
+	mutex        sync.Mutex
+}
+
+// newRuleTuning 建立規則調校狀態
+func newRuleTuning() *ruleTuning {
+	return &ruleTuning{
+		stats:        make(map[string]*ruleCounter),
+		suppressions: make(map[string]*Suppression),
+		index:        make(map[suppressionKey]*IPSet[*Suppression]),
+		recent:       make(map[string]*AnalysisResult),
+		recentOrder:  make([]string, maxRecentResults),
+	}
+}
+
+// counter 取得規則計數 (呼叫者需持有鎖)
+func (rt *ruleTuning) counter(ruleID string) *ruleCounter {
+	counter, exists := rt.stats[ruleID]
+	if !exists {
+		counter = &ruleCounter{}
+		rt.stats[ruleID] = counter
+	}
+	return counter
+}
+
+// recordHit 記錄規則命中
+func (rt *ruleTuning) recordHit(ruleID string, blocked bool, at time.Time) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	counter := rt.counter(ruleID)
+	counter.hits++
+	if blocked {
+		counter.blocks++
+	}
+	counter.lastHit = at
+}
+
+// suppressed 查詢規則對封包的匹配是否被抑制；被抑制時更新計數。
+// now 為引擎時間來源 (重播時為封包時間)，到期時間依此判斷
+func (rt *ruleTuning) suppressed(ruleID string, packet *NetworkPacket, now time.Time) *Suppression {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	if len(rt.index) == 0 {
+		return nil
+	}
+
+	for _, port := range []int{packet.DestPort, 0} {
+		set, exists := rt.index[suppressionKey{ruleID: ruleID, destPort: port}]
+		if !exists {
+			continue
+		}
+		_, suppression, found := set.LookupString(packet.SourceIP)
+		if !found || (suppression.ExpiresAt != nil && !now.Before(*suppression.ExpiresAt)) {
+			continue
+		}
+		suppression.Hits++
+		rt.counter(ruleID).suppressed++
+		return suppression
+	}
+	return nil
+}
+
+// remember 保存告警結果供之後標記誤報，超過上限時覆蓋最舊的結果
+func (rt *ruleTuning) remember(result *AnalysisResult) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	if oldest := rt.recentOrder[rt.recentNext]; oldest != "" {
+		delete(rt.recent, oldest)
+	}
+	copied := *result
+	rt.recent[result.PacketID] = &copied
+	rt.recentOrder[rt.recentNext] = result.PacketID
+	rt.recentNext = (rt.recentNext + 1) % len(rt.recentOrder)
+}
+
+// add 新增抑制條件；相同 (規則, 網段, 連接埠) 的條件會被更新而非重複建立 (呼叫者需持有鎖)
+func (rt *ruleTuning) add(suppression *Suppression, prefix netip.Prefix) *Suppression {
+	key := suppressionKey{ruleID: suppression.RuleID, destPort: suppression.DestPort}
+	set, exists := rt.index[key]
+	if !exists {
+		set = NewIPSet[*Suppression]()
+		rt.index[key] = set
+	}
+
+	if existing, found := set.Get(prefix); found {
+		existing.Reason = suppression.Reason
+		existing.ExpiresAt = suppression.ExpiresAt
+		if suppression.PacketID != "" {
+			existing.PacketID = suppression.PacketID
+		}
+		return existing
+	}
+
+	set.Insert(prefix, suppression)
+	rt.suppressions[suppression.ID] = suppression
+	return suppression
+}
+
+// remove 移除抑制條件 (呼叫者需持有鎖)
+func (rt *ruleTuning) remove(id string) (*Suppression, bool) {
+	suppression, exists := rt.suppressions[id]
+	if !exists {
+		return nil, false
+	}
+	delete(rt.suppressions, id)
+
+	key := suppressionKey{ruleID: suppression.RuleID, destPort: suppression.DestPort}
+	if set, ok := rt.index[key]; ok {
+		if prefix, err := netip.ParsePrefix(suppression.SourceCIDR); err == nil {
+			set.Remove(prefix)
+		}
+		if set.Len() == 0 {
+			delete(rt.index, key)
+		}
+	}
+	return suppression, true
+}
+
+// expired 取得已過期的抑制條件 ID
+func (rt *ruleTuning) expired(now time.Time) []string {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+
+	var ids []string
+	for id, suppression := range rt.suppressions {
+		if suppression.ExpiresAt != nil && !now.Before(*suppression.ExpiresAt) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// parseSuppressionCIDR 解析抑制條件的來源網段 (單一 IP 或 CIDR，不接受範圍)
+func parseSuppressionCIDR(value string) (netip.Prefix, error) {
+	prefixes, err := ParseIPPrefixes(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if len(prefixes) != 1 {
+		return netip.Prefix{}, fmt.Errorf("來源網段必須為單一 IP 或 CIDR: %s", value)
+	}
+	return prefixes[0], nil
+}
+
+// AddSuppression 新增誤報抑制條件
+func (ae *AnalysisEngine) AddSuppression(suppression Suppression) (*Suppression, error) {
+	if suppression.RuleID == "" {
+		return nil, fmt.Errorf("規則ID不可為空")
+	}
+	if suppression.DestPort < 0 || suppression.DestPort > 65535 {
+		return nil, fmt.Errorf("無效的目的連接埠: %d", suppression.DestPort)
+	}
+	prefix, err := parseSuppressionCIDR(suppression.SourceCIDR)
+	if err != nil {
+		return nil, err
+	}
+
+	ae.mutex.RLock()
+	exists := false
+	for _, rule := range ae.rules {
+		if rule.ID == suppression.RuleID {
+			exists = true
+			break
+		}
+	}
+	ae.mutex.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("找不到規則ID: %s", suppression.RuleID)
+	}
+
+	if suppression.ID == "" {
+		suppression.ID = fmt.Sprintf("sup_%d", time.Now().UnixNano())
+	}
+	if suppression.CreatedAt.IsZero() {
+		suppression.CreatedAt = ae.behavior.clock()
+	}
+	suppression.SourceCIDR = prefix.String()
+
+	ae.tuning.mutex.Lock()
+	added := ae.tuning.add(&suppression, prefix)
+	saved := *added
+	ae.tuning.mutex.Unlock()
+
+	ae.persist("誤報抑制", func(ctx context.Context, store StateStore) error {
+		return store.SaveSuppression(ctx, &saved)
+	})
+	ae.logger.Infof("已新增誤報抑制: 規則 %s, 來源 %s, 連接埠 %d", saved.RuleID, saved.SourceCIDR, saved.DestPort)
+	return &saved, nil
+}
+
+// RemoveSuppression 移除誤報抑制條件
+func (ae *AnalysisEngine) RemoveSuppression(id string) error {
+	ae.tuning.mutex.Lock()
+	_, removed := ae.tuning.remove(id)
+	ae.tuning.mutex.Unlock()
+
+	if !removed {
+		return fmt.Errorf("找不到抑制條件: %s", id)
+	}
+	ae.persist("誤報抑制", func(ctx context.Context, store StateStore) error {
+		return store.DeleteSuppression(ctx, id)
+	})
+	return nil
+}
+
+// GetSuppressions 取得所有誤報抑制條件 (依建立時間排序)
+func (ae *AnalysisEngine) GetSuppressions() []Suppression {
+	ae.tuning.mutex.Lock()
+	defer ae.tuning.mutex.Unlock()
+
+	suppressions := make([]Suppression, 0, len(ae.tuning.suppressions))
+	for _, suppression := range ae.tuning.suppressions {
+		suppressions = append(suppressions, *suppression)
+	}
+	sort.Slice(suppressions, func(i, j int) bool {
+		return suppressions[i].CreatedAt.Before(suppressions[j].CreatedAt)
+	})
+	return suppressions
+}
+
+// MarkFalsePositive 將最近的告警結果標記為誤報，並建立對應的抑制條件
+func (ae *AnalysisEngine) MarkFalsePositive(packetID string, options FalsePositiveOptions) (*Suppression, error) {
+	ae.tuning.mutex.Lock()
+	result, exists := ae.tuning.recent[packetID]
+	var copied AnalysisResult
+	if exists {
+		copied = *result
+	}
+	ae.tuning.mutex.Unlock()
+
+	if !exists {
+		return nil, fmt.Errorf("找不到分析結果: %s", packetID)
+	}
+
+	source := options.SourceCIDR
+	if source == "" {
+		source = copied.SourceIP
+	}
+	prefix, err := parseSuppressionCIDR(source)
+	if err != nil {
+		return nil, err
+	}
+	if addr, err := netip.ParseAddr(copied.SourceIP); err != nil || !prefix.Contains(addr.Unmap()) {
+		return nil, fmt.Errorf("來源網段 %s 不包含結果的來源 IP %s", source, copied.SourceIP)
+	}
+
+	suppression := Suppression{
+		RuleID:     copied.RuleID,
+		SourceCIDR: prefix.String(),
+		Reason:     options.Reason,
+		PacketID:   packetID,
+	}
+	if !options.AnyPort {
+		suppression.DestPort = copied.DestPort
+	}
+	if options.Duration > 0 {
+		expiresAt := ae.behavior.clock().Add(options.Duration)
+		suppression.ExpiresAt = &expiresAt
+	}
+
+	added, err := ae.AddSuppression(suppression)
+	if err != nil {
+		return nil, err
+	}
+
+	ae.tuning.mutex.Lock()
+	ae.tuning.counter(copied.RuleID).falsePositives++
+	ae.tuning.mutex.Unlock()
+	return added, nil
+}
+
+// GetRuleStats 取得所有規則的命中統計 (依命中次數由高至低排序)，包含從未命中的規則
+func (ae *AnalysisEngine) GetRuleStats() []RuleStats {
+	ae.mutex.RLock()
+	rules := make([]*SecurityRule, len(ae.rules))
+	copy(rules, ae.rules)
+	ae.mutex.RUnlock()
+
+	ae.tuning.mutex.Lock()
+	defer ae.tuning.mutex.Unlock()
+
+	stats := make([]RuleStats, 0, len(rules))
+	for _, rule := range rules {
+		entry := RuleStats{
+			RuleID:   rule.ID,
+			RuleName: rule.Name,
+			Type:     rule.Type,
+			Action:   rule.Action,
+			Enabled:  rule.Enabled,
+		}
+		if counter, exists := ae.tuning.stats[rule.ID]; exists {
+			entry.Hits = counter.hits
+			entry.Blocks = counter.blocks
+			entry.Suppressed = counter.suppressed
+			entry.FalsePositives = counter.falsePositives
+			if !counter.lastHit.IsZero() {
+				lastHit := counter.lastHit
+				entry.LastHit = &lastHit
+			}
+		}
+		stats = append(stats, entry)
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Hits > stats[j].Hits })
+	return stats
+}
+
+// cleanupSuppressions 移除已過期的抑制條件
+func (ae *AnalysisEngine) cleanupSuppressions() {
+	for _, id := range ae.tuning.expired(ae.behavior.clock()) {
+		ae.RemoveSuppression(id)
+	}
+}
@@ -0,0 +1,174 @@
+package axiom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tuningRules() []*SecurityRule {
+	return []*SecurityRule{
+		{ID: "admin_path", Name: "管理路徑", Type: "pattern", Pattern: "/admin", Action: "alert", Severity: "medium", Enabled: true},
+		{ID: "admin_login", Name: "管理登入", Type: "pattern", Pattern: "/admin/login", Action: "block", Severity: "high", Enabled: true},
+		{ID: "never", Name: "從未命中", Type: "pattern", Pattern: "zzz-never", Action: "alert", Severity: "low", Enabled: true},
+	}
+}
+
+func tuningPacket(source string, port int, payload string) *NetworkPacket {
+	return &NetworkPacket{SourceIP: source, DestIP: "172.16.0.44", DestPort: port, Protocol: "TCP", PayloadString: payload}
+}
+
+func TestRuleStats(t *testing.T) {
+	engine, clock := newBehaviorEngine(t, tuningRules()...)
+
+	analyze(t, engine, tuningPacket("203.0.113.1", 80, "GET /admin"))
+	clock.Advance(time.Minute)
+	analyze(t, engine, tuningPacket("203.0.113.2", 80, "GET /admin/users"))
+	analyze(t, engine, tuningPacket("203.0.113.3", 80, "GET /index"))
+
+	stats := engine.GetRuleStats()
+	require.Len(t, stats, 3)
+	assert.Equal(t, "admin_path", stats[0].RuleID)
+	assert.Equal(t, int64(2), stats[0].Hits)
+	assert.Equal(t, int64(0), stats[0].Blocks)
+	require.NotNil(t, stats[0].LastHit)
+	assert.Equal(t, clock.Now(), *stats[0].LastHit, "使用引擎時間來源")
+
+	// 從未命中的規則也列出，方便找出無效規則
+	assert.Equal(t, int64(0), stats[2].Hits)
+	assert.Nil(t, stats[2].LastHit)
+}
+
+func TestMarkFalsePositive(t *testing.T) {
+	engine, _ := newBehaviorEngine(t, tuningRules()...)
+
+	result := analyze(t, engine, tuningPacket("203.0.113.7", 8080, "GET /admin"))
+	require.Equal(t, "admin_path", result.RuleID)
+	assert.Equal(t, 8080, result.DestPort)
+
+	_, err := engine.MarkFalsePositive("unknown", FalsePositiveOptions{})
+	assert.Error(t, err)
+	_, err = engine.MarkFalsePositive(result.PacketID, FalsePositiveOptions{SourceCIDR: "198.51.100.0/24"})
+	assert.Error(t, err, "網段必須包含來源 IP")
+
+	suppression, err := engine.MarkFalsePositive(result.PacketID, FalsePositiveOptions{
+		SourceCIDR: "203.0.113.0/24",
+		Reason:     "內部掃描器",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "admin_path", suppression.RuleID)
+	assert.Equal(t, "203.0.113.0/24", suppression.SourceCIDR)
+	assert.Equal(t, 8080, suppression.DestPort)
+
+	// 同網段、同連接埠不再告警
+	assert.Equal(t, "allow", analyze(t, engine, tuningPacket("203.0.113.99", 8080, "GET /admin")).Action)
+	// 被抑制的規則不影響其他規則
+	assert.Equal(t, "admin_login", analyze(t, engine, tuningPacket("203.0.113.98", 8080, "GET /admin/login")).RuleID)
+	// 其他連接埠或網段照常告警
+	assert.Equal(t, "admin_path", analyze(t, engine, tuningPacket("203.0.113.99", 443, "GET /admin")).RuleID)
+	assert.Equal(t, "admin_path", analyze(t, engine, tuningPacket("198.51.100.1", 8080, "GET /admin")).RuleID)
+
+	stats := engine.GetRuleStats()
+	for _, entry := range stats {
+		if entry.RuleID == "admin_path" {
+			assert.Equal(t, int64(3), entry.Hits)
+			assert.Equal(t, int64(2), entry.Suppressed)
+			assert.Equal(t, int64(1), entry.FalsePositives)
+		}
+	}
+	suppressions := engine.GetSuppressions()
+	require.Len(t, suppressions, 1)
+	assert.Equal(t, int64(2), suppressions[0].Hits)
+
+	// 任意連接埠且限時的抑制
+	result = analyze(t, engine, tuningPacket("198.51.100.1", 22, "GET /admin"))
+	_, err = engine.MarkFalsePositive(result.PacketID, FalsePositiveOptions{AnyPort: true, Duration: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, "allow", analyze(t, engine, tuningPacket("198.51.100.1", 9999, "GET /admin")).Action)
+
+	// 到期後恢復告警並由清理移除
+	for _, s := range engine.GetSuppressions() {
+		if s.DestPort == 0 {
+			expired := engine.behavior.clock().Add(-time.Second)
+			engine.tuning.suppressions[s.ID].ExpiresAt = &expired
+		}
+	}
+	assert.Equal(t, "admin_path", analyze(t, engine, tuningPacket("198.51.100.1", 9999, "GET /admin")).RuleID)
+	engine.cleanupSuppressions()
+	assert.Len(t, engine.GetSuppressions(), 1)
+
+	require.NoError(t, engine.RemoveSuppression(suppressions[0].ID))
+	assert.Error(t, engine.RemoveSuppression(suppressions[0].ID))
+	assert.Equal(t, "admin_path", analyze(t, engine, tuningPacket("203.0.113.99", 8080, "GET /admin")).RuleID)
+}
+
+func TestSuppressionPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := NewFileStateStore(path)
+	require.NoError(t, err)
+
+	engine, _ := newBehaviorEngine(t, tuningRules()...)
+	engine.SetStateStore(store)
+	_, err = engine.AddSuppression(Suppression{RuleID: "admin_path", SourceCIDR: "10.8.0.0/16", DestPort: 80, Reason: "VPN"})
+	require.NoError(t, err)
+	_, err = engine.AddSuppression(Suppression{RuleID: "missing", SourceCIDR: "10.8.0.0/16"})
+	assert.Error(t, err)
+	_, err = engine.AddSuppression(Suppression{RuleID: "admin_path", SourceCIDR: "10.0.0.1-10.0.0.9"})
+	assert.Error(t, err, "不接受 IP 範圍")
+	require.NoError(t, engine.FlushState(context.Background()))
+
+	reopened, err := NewFileStateStore(path)
+	require.NoError(t, err)
+	restored := newPersistentEngine(t, reopened)
+	require.NoError(t, restored.AddRule(tuningRules()[0]))
+	startEngine(t, restored)
+
+	suppressions := restored.GetSuppressions()
+	require.Len(t, suppressions, 1)
+	assert.Equal(t, "VPN", suppressions[0].Reason)
+
+	result, err := restored.AnalyzePacket(tuningPacket("10.8.3.3", 80, "GET /admin"))
+	require.NoError(t, err)
+	assert.NotEqual(t, "admin_path", result.RuleID)
+}
+
+func TestUIServerFalsePositive(t *testing.T) {
+	engine, _ := newBehaviorEngine(t, tuningRules()...)
+	ui := NewUIServer(engine.logger, engine.metrics)
+	ui.SetAnalysisEngine(engine)
+	router := ui.setupRouter()
+
+	result := analyze(t, engine, tuningPacket("203.0.113.7", 80, "GET /admin"))
+
+	body, _ := json.Marshal(FalsePositiveRequest{PacketID: result.PacketID, Reason: "測試"})
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/security/false-positives", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/security/rules/stats?limit=1", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var response struct {
+		Rules []RuleStats `json:"rules"`
+		Total int         `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	require.Len(t, response.Rules, 1)
+	assert.Equal(t, int64(1), response.Rules[0].FalsePositives)
+	assert.Equal(t, len(engine.GetRuleStats()), response.Total, "total 為截斷前的規則數")
+	assert.Greater(t, response.Total, 1)
+
+	suppressions := engine.GetSuppressions()
+	require.Len(t, suppressions, 1)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, "/api/v1/security/suppressions/"+suppressions[0].ID, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
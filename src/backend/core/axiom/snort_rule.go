@@ -0,0 +1,1177 @@
+package axiom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SnortSignature Snort/Suricata 相容的簽章規則
+type SnortSignature struct {
+	Action        string            `json:"action"`   // alert, log, drop, reject, sdrop
+	Protocol      string            `json:"protocol"` // tcp, udp, icmp, ip, http ...
+	Source        string            `json:"source"`
+	SourcePorts   string            `json:"source_ports"`
+	Direction     string            `json:"direction"` // "->" 或 "<>"
+	Destination   string            `json:"destination"`
+	DestPorts     string            `json:"dest_ports"`
+	Message       string            `json:"msg"`
+	SID           int               `json:"sid"`
+	Rev           int               `json:"rev"`
+	Classtype     string            `json:"classtype,omitempty"`
+	Priority      int               `json:"priority,omitempty"`
+	Flow          []string          `json:"flow,omitempty"`
+	References    []string          `json:"references,omitempty"`
+	Raw           string            `json:"raw"`
+	Variables     map[string]string `json:"variables,omitempty"` // 解析時覆寫的規則變數，供重新解析使用
+	src           addressSpec
+	dst           addressSpec
+	srcPorts      portSpec
+	dstPorts      portSpec
+	detection     []*detectOption
+	bidirectional bool
+}
+
+// detectOption 偵測選項 (content 或 pcre)，依規則中出現的順序評估
+type detectOption struct {
+	content  []byte
+	negated  bool
+	nocase   bool
+	offset   int
+	depth    int
+	distance int
+	within   int
+	relative bool
+	pcre     *regexp.Regexp
+}
+
+// SnortParseError 單行規則解析錯誤
+type SnortParseError struct {
+	Line        int      `json:"line"`
+	SID         int      `json:"sid,omitempty"`
+	Message     string   `json:"message"`
+	Unsupported []string `json:"unsupported,omitempty"`
+}
+
+// Error 實作 error 介面
+func (e *SnortParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("第 %d 行: %s", e.Line, e.Message)
+	}
+	return e.Message
+}
+
+// SnortLoadReport 規則載入報告
+type SnortLoadReport struct {
+	Total       int                `json:"total"`
+	Loaded      int                `json:"loaded"`
+	Skipped     int                `json:"skipped"`
+	Errors      []*SnortParseError `json:"errors,omitempty"`
+	Unsupported map[string]int     `json:"unsupported,omitempty"` // 不支援的關鍵字 -> 出現次數
+}
+
+// SnortParser Snort/Suricata 規則解析器
+type SnortParser struct {
+	variables map[string]string
+}
+
+// defaultSnortVariables 預設規則變數，可於 NewSnortParser 覆寫
+var defaultSnortVariables = map[string]string{
+	"HOME_NET":          "any",
+	"EXTERNAL_NET":      "any",
+	"HTTP_SERVERS":      "$HOME_NET",
+	"SQL_SERVERS":       "$HOME_NET",
+	"DNS_SERVERS":       "$HOME_NET",
+	"SMTP_SERVERS":      "$HOME_NET",
+	"TELNET_SERVERS":    "$HOME_NET",
+	"HTTP_PORTS":        "[80,81,311,591,593,901,1220,1414,1741,1830,2301,2381,2809,3128,3702,4343,4848,5250,7001,7145,7510,7777,7779,8000,8008,8014,8028,8080,8088,8118,8123,8180,8181,8243,8280,8800,8888,8899,9080,9090,9091,9443,9999,11371,55555]",
+	"SHELLCODE_PORTS":   "!80",
+	"ORACLE_PORTS":      "1521",
+	"SSH_PORTS":         "22",
+	"FTP_PORTS":         "[21,2100,3535]",
+	"FILE_DATA_PORTS":   "[$HTTP_PORTS,110,143]",
+	"SIP_PORTS":         "[5060,5061,5600]",
+	"DNP3_PORTS":        "20000",
+	"MODBUS_PORTS":      "502",
+	"GTP_PORTS":         "[2123,2152,3386]",
+	"AIM_SERVERS":       "any",
+	"DNP3_SERVER":       "$HOME_NET",
+	"DNP3_CLIENT":       "$HOME_NET",
+	"MODBUS_SERVER":     "$HOME_NET",
+	"MODBUS_CLIENT":     "$HOME_NET",
+	"ENIP_SERVER":       "$HOME_NET",
+	"ENIP_CLIENT":       "$HOME_NET",
+	"SSH_SERVERS":       "$HOME_NET",
+	"SIP_SERVERS":       "$HOME_NET",
+	"TELNET_PORTS":      "23",
+	"MSSQL_PORTS":       "1433",
+	"MYSQL_PORTS":       "3306",
+	"SMB_PORTS":         "[139,445]",
+	"DNS_PORTS":         "53",
+	"SMTP_PORTS":        "[25,465,587]",
+	"ORACLE_SERVERS":    "$HOME_NET",
+	"VNC_PORTS":         "[5800,5900]",
+	"RDP_PORTS":         "3389",
+	"POP3_PORTS":        "110",
+	"IMAP_PORTS":        "143",
+	"TLS_PORTS":         "443",
+	"SIP_SERVER_PORTS":  "5060",
+	"DHCP_SERVER_PORTS": "67",
+}
+
+// snortClasstypePriority classification.config 中常見 classtype 的預設優先級
+var snortClasstypePriority = map[string]int{
+	"attempted-admin":                1,
+	"attempted-user":                 1,
+	"inappropriate-content":          1,
+	"policy-violation":               1,
+	"shellcode-detect":               1,
+	"successful-admin":               1,
+	"successful-user":                1,
+	"trojan-activity":                1,
+	"unsuccessful-user":              1,
+	"web-application-attack":         1,
+	"command-and-control":            1,
+	"exploit-kit":                    1,
+	"targeted-activity":              1,
+	"attempted-dos":                  2,
+	"attempted-recon":                2,
+	"bad-unknown":                    2,
+	"default-login-attempt":          2,
+	"denial-of-service":              2,
+	"misc-attack":                    2,
+	"non-standard-protocol":          2,
+	"rpc-portmap-decode":             2,
+	"successful-dos":                 2,
+	"successful-recon-largescale":    2,
+	"successful-recon-limited":       2,
+	"suspicious-filename-detect":     2,
+	"suspicious-login":               2,
+	"system-call-detect":             2,
+	"unusual-client-port-connection": 2,
+	"web-application-activity":       2,
+	"coin-mining":                    2,
+	"icmp-event":                     3,
+	"misc-activity":                  3,
+	"network-scan":                   3,
+	"not-suspicious":                 3,
+	"protocol-command-decode":        3,
+	"string-detect":                  3,
+	"unknown":                        3,
+	"tcp-connection":                 4,
+}
+
+// snortIgnoredKeywords 不影響偵測結果、僅作為中繼資料保留的關鍵字
+var snortIgnoredKeywords = map[string]bool{
+	"metadata":     true,
+	"gid":          true,
+	"target":       true,
+	"fast_pattern": true,
+	"rawbytes":     true,
+}
+
+// snortBufferKeywords HTTP 緩衝區修飾詞；目前 NetworkPacket 不區分 HTTP 欄位，
+// 因此這些修飾詞會對整個負載進行比對
+var snortBufferKeywords = map[string]bool{
+	"http_uri":          true,
+	"http_raw_uri":      true,
+	"http_header":       true,
+	"http_raw_header":   true,
+	"http_client_body":  true,
+	"http_method":       true,
+	"http_cookie":       true,
+	"http_raw_cookie":   true,
+	"http_user_agent":   true,
+	"http_host":         true,
+	"http_stat_code":    true,
+	"http_stat_msg":     true,
+	"http.uri":          true,
+	"http.uri.raw":      true,
+	"http.header":       true,
+	"http.header.raw":   true,
+	"http.method":       true,
+	"http.cookie":       true,
+	"http.user_agent":   true,
+	"http.host":         true,
+	"http.request_body": true,
+	"http.stat_code":    true,
+	"file_data":         true,
+	"file.data":         true,
+	"pkt_data":          true,
+}
+
+// snortSupportedFlow 支援的 flow 選項
+var snortSupportedFlow = map[string]bool{
+	"established": true,
+	"stateless":   true,
+	"to_server":   true,
+	"to_client":   true,
+	"from_server": true,
+	"from_client": true,
+}
+
+// snortActions 規則動作對應至引擎動作
+var snortActions = map[string]string{
+	"alert":  "alert",
+	"log":    "log",
+	"drop":   "block",
+	"reject": "block",
+	"sdrop":  "block",
+}
+
+// snortProtocols 應用層協定對應至傳輸層協定
+var snortProtocols = map[string]string{
+	"tcp":  "tcp",
+	"udp":  "udp",
+	"icmp": "icmp",
+	"ip":   "ip",
+	"http": "tcp",
+	"tls":  "tcp",
+	"ssh":  "tcp",
+	"ftp":  "tcp",
+	"smtp": "tcp",
+	"smb":  "tcp",
+	"dns":  "ip",
+}
+
+// NewSnortParser 建立規則解析器，vars 會覆寫預設的規則變數 (如 HOME_NET)
+func NewSnortParser(vars map[string]string) *SnortParser {
+	variables := make(map[string]string, len(defaultSnortVariables)+len(vars))
+	for k, v := range defaultSnortVariables {
+		variables[k] = v
+	}
+	for k, v := range vars {
+		variables[strings.TrimPrefix(k, "$")] = v
+	}
+	return &SnortParser{variables: variables}
+}
+
+// overrides 回傳與預設值不同的規則變數
+func (p *SnortParser) overrides() map[string]string {
+	var vars map[string]string
+	for k, v := range p.variables {
+		if defaultSnortVariables[k] == v {
+			continue
+		}
+		if vars == nil {
+			vars = make(map[string]string)
+		}
+		vars[k] = v
+	}
+	return vars
+}
+
+// ParseRule 解析單行 Snort/Suricata 規則
+func (p *SnortParser) ParseRule(line string) (*SecurityRule, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, &SnortParseError{Message: "空白或註解行"}
+	}
+
+	open := strings.Index(line, "(")
+	if open < 0 || !strings.HasSuffix(line, ")") {
+		return nil, &SnortParseError{Message: "缺少規則選項區段 (...)"}
+	}
+
+	header := strings.Fields(line[:open])
+	if len(header) != 7 {
+		return nil, &SnortParseError{Message: fmt.Sprintf("規則標頭欄位數錯誤: 預期 7 個，實際 %d 個", len(header))}
+	}
+
+	sig := &SnortSignature{
+		Action:      strings.ToLower(header[0]),
+		Protocol:    strings.ToLower(header[1]),
+		Source:      header[2],
+		SourcePorts: header[3],
+		Direction:   header[4],
+		Destination: header[5],
+		DestPorts:   header[6],
+		Raw:         line,
+		Variables:   p.overrides(),
+	}
+
+	action, ok := snortActions[sig.Action]
+	if !ok {
+		return nil, &SnortParseError{Message: fmt.Sprintf("不支援的規則動作: %s", sig.Action)}
+	}
+	if _, ok := snortProtocols[sig.Protocol]; !ok {
+		return nil, &SnortParseError{Message: fmt.Sprintf("不支援的協定: %s", sig.Protocol)}
+	}
+	switch sig.Direction {
+	case "->":
+	case "<>":
+		sig.bidirectional = true
+	default:
+		return nil, &SnortParseError{Message: fmt.Sprintf("無效的方向運算子: %s", sig.Direction)}
+	}
+
+	var err error
+	if sig.src, err = p.parseAddress(sig.Source, 0); err != nil {
+		return nil, &SnortParseError{Message: fmt.Sprintf("來源位址: %v", err)}
+	}
+	if sig.dst, err = p.parseAddress(sig.Destination, 0); err != nil {
+		return nil, &SnortParseError{Message: fmt.Sprintf("目的位址: %v", err)}
+	}
+	if sig.srcPorts, err = p.parsePorts(sig.SourcePorts, 0); err != nil {
+		return nil, &SnortParseError{Message: fmt.Sprintf("來源連接埠: %v", err)}
+	}
+	if sig.dstPorts, err = p.parsePorts(sig.DestPorts, 0); err != nil {
+		return nil, &SnortParseError{Message: fmt.Sprintf("目的連接埠: %v", err)}
+	}
+
+	options, err := splitSnortOptions(line[open+1 : len(line)-1])
+	if err != nil {
+		return nil, &SnortParseError{Message: err.Error()}
+	}
+
+	var unsupported []string
+	var last *detectOption
+	for _, opt := range options {
+		key, value := opt[0], opt[1]
+		switch key {
+		case "msg":
+			sig.Message = unquoteSnort(value)
+		case "sid":
+			if sig.SID, err = strconv.Atoi(value); err != nil {
+				return nil, &SnortParseError{Message: fmt.Sprintf("無效的 sid: %s", value)}
+			}
+		case "rev":
+			if sig.Rev, err = strconv.Atoi(value); err != nil {
+				return nil, &SnortParseError{SID: sig.SID, Message: fmt.Sprintf("無效的 rev: %s", value)}
+			}
+		case "classtype":
+			sig.Classtype = value
+		case "priority":
+			if sig.Priority, err = strconv.Atoi(value); err != nil {
+				return nil, &SnortParseError{SID: sig.SID, Message: fmt.Sprintf("無效的 priority: %s", value)}
+			}
+		case "reference":
+			sig.References = append(sig.References, value)
+		case "flow":
+			for _, f := range strings.Split(value, ",") {
+				f = strings.TrimSpace(f)
+				// 連線狀態只追蹤 TCP，其他協定僅支援 stateless
+				if !snortSupportedFlow[f] || (f != "stateless" && snortProtocols[sig.Protocol] != "tcp") {
+					unsupported = append(unsupported, "flow:"+f)
+					continue
+				}
+				sig.Flow = append(sig.Flow, f)
+			}
+		case "content":
+			last, err = parseSnortContent(value)
+			if err != nil {
+				return nil, &SnortParseError{SID: sig.SID, Message: err.Error()}
+			}
+			sig.detection = append(sig.detection, last)
+		case "pcre":
+			last, err = parseSnortPCRE(value)
+			if err != nil {
+				return nil, &SnortParseError{SID: sig.SID, Message: err.Error()}
+			}
+			sig.detection = append(sig.detection, last)
+		case "nocase", "offset", "depth", "distance", "within":
+			if last == nil || last.pcre != nil {
+				return nil, &SnortParseError{SID: sig.SID, Message: fmt.Sprintf("%s 必須跟在 content 之後", key)}
+			}
+			if err := last.applyModifier(key, value); err != nil {
+				return nil, &SnortParseError{SID: sig.SID, Message: err.Error()}
+			}
+		default:
+			if snortIgnoredKeywords[key] || snortBufferKeywords[key] {
+				continue
+			}
+			unsupported = append(unsupported, key)
+		}
+	}
+
+	if sig.SID == 0 {
+		return nil, &SnortParseError{Message: "規則缺少 sid"}
+	}
+	if len(unsupported) > 0 {
+		return nil, &SnortParseError{
+			SID:         sig.SID,
+			Message:     fmt.Sprintf("不支援的關鍵字: %s", strings.Join(unsupported, ", ")),
+			Unsupported: unsupported,
+		}
+	}
+
+	rule := &SecurityRule{
+		ID:          fmt.Sprintf("sid_%d", sig.SID),
+		Name:        sig.Message,
+		Description: sig.Message,
+		Type:        "signature",
+		Pattern:     sig.Raw,
+		Action:      action,
+		Severity:    sig.severity(),
+		Enabled:     true,
+		Signature:   sig,
+	}
+	return rule, nil
+}
+
+// ParseRules 逐行解析規則檔，回傳成功解析的規則與載入報告
+func (p *SnortParser) ParseRules(r io.Reader) ([]*SecurityRule, *SnortLoadReport, error) {
+	report := &SnortLoadReport{Unsupported: make(map[string]int)}
+	rules := make([]*SecurityRule, 0)
+	index := make(map[int]int) // sid -> rules 索引
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNo := 0
+	var pending strings.Builder
+	startLine := 0
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimSpace(scanner.Text())
+
+		// 支援以反斜線延續的多行規則
+		if strings.HasSuffix(text, "\\") {
+			if pending.Len() == 0 {
+				startLine = lineNo
+			}
+			pending.WriteString(strings.TrimSuffix(text, "\\"))
+			continue
+		}
+		if pending.Len() > 0 {
+			pending.WriteString(text)
+			text = pending.String()
+			pending.Reset()
+		} else {
+			startLine = lineNo
+		}
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		report.Total++
+		rule, err := p.ParseRule(text)
+		if err != nil {
+			report.Skipped++
+			perr, ok := err.(*SnortParseError)
+			if !ok {
+				perr = &SnortParseError{Message: err.Error()}
+			}
+			perr.Line = startLine
+			for _, kw := range perr.Unsupported {
+				report.Unsupported[kw]++
+			}
+			report.Errors = append(report.Errors, perr)
+			continue
+		}
+
+		// 相同 sid 保留 rev 較新的版本
+		if i, exists := index[rule.Signature.SID]; exists {
+			report.Skipped++
+			if rules[i].Signature.Rev < rule.Signature.Rev {
+				rules[i] = rule
+			}
+			continue
+		}
+		index[rule.Signature.SID] = len(rules)
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return rules, report, fmt.Errorf("讀取規則失敗: %w", err)
+	}
+
+	report.Loaded = len(rules)
+	return rules, report, nil
+}
+
+// LoadSnortRules 從 reader 載入 Snort/Suricata 規則至引擎
+func (ae *AnalysisEngine) LoadSnortRules(r io.Reader, vars map[string]string) (*SnortLoadReport, error) {
+	rules, report, err := NewSnortParser(vars).ParseRules(r)
+	if err != nil {
+		return report, err
+	}
+
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	existing := make(map[string]int, len(ae.rules))
+	for i, rule := range ae.rules {
+		existing[rule.ID] = i
+	}
+
+	for _, rule := range rules {
+		ae.prepareRule(rule)
+		rule.Source = "snort"
+		if i, ok := existing[rule.ID]; ok {
+			rule.CreatedAt = ae.rules[i].CreatedAt
+			ae.rules[i] = rule
+			continue
+		}
+		ae.rules = append(ae.rules, rule)
+	}
+	ae.rulesChanged("snort")
+
+	for kw, count := range report.Unsupported {
+		ae.logger.Warnf("Snort規則使用不支援的關鍵字 %s (%d 條規則)", kw, count)
+	}
+	ae.logger.Infof("已載入 %d 條 Snort 規則，略過 %d 條", report.Loaded, report.Skipped)
+	return report, nil
+}
+
+// LoadSnortRulesFile 從檔案載入 Snort/Suricata 規則
+func (ae *AnalysisEngine) LoadSnortRulesFile(path string, vars map[string]string) (*SnortLoadReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("開啟規則檔失敗: %w", err)
+	}
+	defer file.Close()
+
+	return ae.LoadSnortRules(file, vars)
+}
+
+// Match 檢查封包是否符合簽章
+func (s *SnortSignature) Match(packet *NetworkPacket) bool {
+	if !s.matchProtocol(packet.Protocol) {
+		return false
+	}
+
+	forward := s.matchEndpoints(packet.SourceIP, packet.SourcePort, packet.DestIP, packet.DestPort)
+	if !forward && !(s.bidirectional && s.matchEndpoints(packet.DestIP, packet.DestPort, packet.SourceIP, packet.SourcePort)) {
+		return false
+	}
+	if !s.matchFlow(packet.conn) {
+		return false
+	}
+
+	payload := packet.Payload
+	if len(payload) == 0 {
+		payload = []byte(packet.PayloadString)
+	}
+	if len(s.detection) == 0 {
+		return true
+	}
+
+	lower := bytes.ToLower(payload)
+	return matchDetection(payload, lower, s.detection, 0)
+}
+
+// prefilterLiterals 回傳預先過濾使用的字面字串：最長的非否定 content，
+// 若無 content 則嘗試從 pcre 萃取
+func (s *SnortSignature) prefilterLiterals() []string {
+	var best []byte
+	for _, opt := range s.detection {
+		if opt.pcre == nil && !opt.negated && len(opt.content) > len(best) {
+			best = opt.content
+		}
+	}
+	if best != nil {
+		return []string{lowerASCII(string(best))}
+	}
+	for _, opt := range s.detection {
+		if opt.pcre != nil {
+			if lits := regexLiterals(opt.pcre.String()); len(lits) > 0 {
+				return lits
+			}
+		}
+	}
+	return nil
+}
+
+// span 偵測選項一次匹配最多涵蓋的位元組數；含 pcre、否定 content、絕對位置
+// 或未以 within 限制範圍的多個 content 時回傳 -1
+func (s *SnortSignature) span() int {
+	span := 0
+	for i, opt := range s.detection {
+		switch {
+		case opt.pcre != nil || opt.negated:
+			return -1
+		case i == 0 && !opt.relative:
+			if opt.offset > 0 || opt.depth > 0 {
+				return -1
+			}
+			span = len(opt.content)
+		case opt.relative && opt.within > 0:
+			span += max(opt.distance, 0) + opt.within
+		default:
+			return -1
+		}
+	}
+	return span
+}
+
+// severity 根據 priority 或 classtype 推導嚴重程度
+func (s *SnortSignature) severity() string {
+	priority := s.Priority
+	if priority == 0 {
+		priority = snortClasstypePriority[s.Classtype]
+	}
+	switch priority {
+	case 1:
+		return "high"
+	case 2:
+		return "medium"
+	case 3, 4:
+		return "low"
+	default:
+		return "medium"
+	}
+}
+
+// matchProtocol 匹配協定
+func (s *SnortSignature) matchProtocol(protocol string) bool {
+	want := snortProtocols[s.Protocol]
+	if want == "ip" {
+		return true
+	}
+	return strings.EqualFold(want, protocol)
+}
+
+// matchFlow 依重組器追蹤的連線狀態評估 flow 選項；未追蹤的封包只符合 stateless
+func (s *SnortSignature) matchFlow(conn ConnState) bool {
+	for _, f := range s.Flow {
+		switch f {
+		case "established":
+			if !conn.Established {
+				return false
+			}
+		case "to_server", "from_client":
+			if !conn.Tracked || !conn.ToServer {
+				return false
+			}
+		case "to_client", "from_server":
+			if !conn.Tracked || conn.ToServer {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchEndpoints 匹配來源與目的位址、連接埠
+func (s *SnortSignature) matchEndpoints(srcIP string, srcPort int, dstIP string, dstPort int) bool {
+	return s.src.match(srcIP) && s.srcPorts.match(srcPort) &&
+		s.dst.match(dstIP) && s.dstPorts.match(dstPort)
+}
+
+// matchDetection 依序評估偵測選項，相對位置比對失敗時回溯嘗試下一個匹配位置
+func matchDetection(payload, lower []byte, options []*detectOption, prevEnd int) bool {
+	if len(options) == 0 {
+		return true
+	}
+	opt := options[0]
+
+	if opt.pcre != nil {
+		start := 0
+		if opt.relative {
+			start = prevEnd
+		}
+		for start <= len(payload) {
+			loc := opt.pcre.FindIndex(payload[start:])
+			if loc == nil {
+				return false
+			}
+			end := start + loc[1]
+			if matchDetection(payload, lower, options[1:], end) {
+				return true
+			}
+			start += loc[0] + 1
+		}
+		return false
+	}
+
+	haystack := payload
+	if opt.nocase {
+		haystack = lower
+	}
+
+	start, end := opt.offset, len(payload)
+	if opt.relative {
+		start = prevEnd + opt.distance
+		if opt.within > 0 {
+			end = start + opt.within
+		}
+	} else if opt.depth > 0 {
+		end = opt.offset + opt.depth
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > len(payload) {
+		end = len(payload)
+	}
+
+	if opt.negated {
+		if start < end && bytes.Contains(haystack[start:end], opt.content) {
+			return false
+		}
+		return matchDetection(payload, lower, options[1:], prevEnd)
+	}
+
+	for start < end {
+		i := bytes.Index(haystack[start:end], opt.content)
+		if i < 0 {
+			return false
+		}
+		matchEnd := start + i + len(opt.content)
+		if matchDetection(payload, lower, options[1:], matchEnd) {
+			return true
+		}
+		start += i + 1
+	}
+	return false
+}
+
+// applyModifier 套用 content 修飾詞
+func (o *detectOption) applyModifier(key, value string) error {
+	if key == "nocase" {
+		o.nocase = true
+		o.content = bytes.ToLower(o.content)
+		return nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("無效的 %s 值: %s", key, value)
+	}
+	switch key {
+	case "offset":
+		o.offset = n
+	case "depth":
+		o.depth = n
+	case "distance":
+		o.distance = n
+		o.relative = true
+	case "within":
+		o.within = n
+		o.relative = true
+	}
+	return nil
+}
+
+// parseSnortContent 解析 content 選項 (支援 |hex| 與否定)
+func parseSnortContent(value string) (*detectOption, error) {
+	opt := &detectOption{}
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "!") {
+		opt.negated = true
+		value = strings.TrimSpace(value[1:])
+	}
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return nil, fmt.Errorf("content 必須以雙引號包住: %s", value)
+	}
+	value = value[1 : len(value)-1]
+
+	var buf bytes.Buffer
+	inHex := false
+	var hexBuf strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '|':
+			if inHex {
+				decoded, err := hex.DecodeString(strings.Join(strings.Fields(hexBuf.String()), ""))
+				if err != nil {
+					return nil, fmt.Errorf("content 十六進位格式錯誤: %v", err)
+				}
+				buf.Write(decoded)
+				hexBuf.Reset()
+			}
+			inHex = !inHex
+		case inHex:
+			hexBuf.WriteByte(c)
+		case c == '\\' && i+1 < len(value):
+			i++
+			buf.WriteByte(value[i])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if inHex {
+		return nil, fmt.Errorf("content 十六進位區段未結束")
+	}
+	if buf.Len() == 0 {
+		return nil, fmt.Errorf("content 不可為空")
+	}
+
+	opt.content = buf.Bytes()
+	return opt, nil
+}
+
+// parseSnortPCRE 將 pcre:"/pattern/flags" 轉換為 Go 正則表達式
+func parseSnortPCRE(value string) (*detectOption, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "!") {
+		return nil, fmt.Errorf("不支援否定的 pcre")
+	}
+	value = unquoteSnort(value)
+	if !strings.HasPrefix(value, "/") {
+		return nil, fmt.Errorf("pcre 格式錯誤: %s", value)
+	}
+	end := strings.LastIndex(value, "/")
+	if end <= 0 {
+		return nil, fmt.Errorf("pcre 格式錯誤: %s", value)
+	}
+
+	pattern, flags := value[1:end], value[end+1:]
+	opt := &detectOption{}
+	var goFlags strings.Builder
+	for _, f := range flags {
+		switch f {
+		case 'i', 's', 'm':
+			goFlags.WriteRune(f)
+		case 'x':
+			// RE2 不支援擴充模式，移除未跳脫的空白
+			pattern = stripPCREWhitespace(pattern)
+		case 'R':
+			opt.relative = true
+		case 'U', 'I', 'P', 'H', 'D', 'M', 'C', 'K', 'S', 'Y', 'B', 'O':
+			// HTTP 緩衝區旗標：與 http_* 修飾詞相同，比對整個負載
+		default:
+			return nil, fmt.Errorf("不支援的 pcre 旗標: %c", f)
+		}
+	}
+	if goFlags.Len() > 0 {
+		pattern = "(?" + goFlags.String() + ")" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("編譯 pcre 失敗: %v", err)
+	}
+	opt.pcre = re
+	return opt, nil
+}
+
+// stripPCREWhitespace 處理 PCRE 的 x 旗標
+func stripPCREWhitespace(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c == '\\' && i+1 < len(pattern) {
+			b.WriteByte(c)
+			b.WriteByte(pattern[i+1])
+			i++
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// splitSnortOptions 以分號切割規則選項，處理引號與跳脫字元
+func splitSnortOptions(body string) ([][2]string, error) {
+	var options [][2]string
+	var current strings.Builder
+	inQuote := false
+
+	flush := func() {
+		text := strings.TrimSpace(current.String())
+		current.Reset()
+		if text == "" {
+			return
+		}
+		key, value := text, ""
+		if i := strings.Index(text, ":"); i >= 0 {
+			key, value = text[:i], strings.TrimSpace(text[i+1:])
+		}
+		options = append(options, [2]string{strings.ToLower(strings.TrimSpace(key)), value})
+	}
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '\\' && i+1 < len(body):
+			current.WriteByte(c)
+			current.WriteByte(body[i+1])
+			i++
+		case c == '"':
+			inQuote = !inQuote
+			current.WriteByte(c)
+		case c == ';' && !inQuote:
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("規則選項中的引號未結束")
+	}
+	flush()
+	return options, nil
+}
+
+// unquoteSnort 移除外層引號並處理跳脫字元
+func unquoteSnort(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) && strings.IndexByte(`";\:`, value[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// addressSpec 規則位址定義
+type addressSpec struct {
+	any     bool
+	include []netip.Prefix
+	exclude []netip.Prefix
+}
+
+// match 檢查 IP 是否符合位址定義
+func (a addressSpec) match(ip string) bool {
+	if a.any && len(a.exclude) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return a.any && len(a.exclude) == 0
+	}
+	addr = addr.Unmap()
+	for _, p := range a.exclude {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if a.any {
+		return true
+	}
+	for _, p := range a.include {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddress 解析位址欄位 (any、變數、IP、CIDR、清單與否定)
+func (p *SnortParser) parseAddress(value string, depth int) (addressSpec, error) {
+	var spec addressSpec
+	if depth > 10 {
+		return spec, fmt.Errorf("變數展開層數過多: %s", value)
+	}
+
+	for _, item := range splitSnortList(value) {
+		negated := false
+		for strings.HasPrefix(item, "!") {
+			negated = !negated
+			item = item[1:]
+		}
+
+		var sub addressSpec
+		switch {
+		case strings.EqualFold(item, "any"):
+			sub.any = true
+		case strings.HasPrefix(item, "$"):
+			expanded, ok := p.variables[item[1:]]
+			if !ok {
+				return spec, fmt.Errorf("未定義的變數: %s", item)
+			}
+			var err error
+			if sub, err = p.parseAddress(expanded, depth+1); err != nil {
+				return spec, err
+			}
+		case strings.HasPrefix(item, "["):
+			var err error
+			if sub, err = p.parseAddress(item, depth+1); err != nil {
+				return spec, err
+			}
+		default:
+			prefix, err := parseSnortPrefix(item)
+			if err != nil {
+				return spec, err
+			}
+			sub.include = []netip.Prefix{prefix}
+		}
+
+		if negated {
+			if sub.any {
+				return spec, fmt.Errorf("不可否定 any")
+			}
+			spec.exclude = append(spec.exclude, sub.include...)
+			spec.include = append(spec.include, sub.exclude...)
+			continue
+		}
+		spec.any = spec.any || sub.any
+		spec.include = append(spec.include, sub.include...)
+		spec.exclude = append(spec.exclude, sub.exclude...)
+	}
+	// 僅含否定項時 (例如 !10.0.0.0/8) 表示「除此之外的任何位址」
+	if len(spec.include) == 0 && len(spec.exclude) > 0 {
+		spec.any = true
+	}
+	return spec, nil
+}
+
+// parseSnortPrefix 解析 IP 或 CIDR
+func parseSnortPrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("無效的 CIDR: %s", value)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("無效的 IP: %s", value)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// portRange 連接埠範圍
+type portRange struct {
+	low, high int
+}
+
+// portSpec 規則連接埠定義
+type portSpec struct {
+	any     bool
+	include []portRange
+	exclude []portRange
+}
+
+// match 檢查連接埠是否符合定義
+func (s portSpec) match(port int) bool {
+	for _, r := range s.exclude {
+		if port >= r.low && port <= r.high {
+			return false
+		}
+	}
+	if s.any {
+		return true
+	}
+	for _, r := range s.include {
+		if port >= r.low && port <= r.high {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePorts 解析連接埠欄位 (any、變數、範圍、清單與否定)
+func (p *SnortParser) parsePorts(value string, depth int) (portSpec, error) {
+	var spec portSpec
+	if depth > 10 {
+		return spec, fmt.Errorf("變數展開層數過多: %s", value)
+	}
+
+	for _, item := range splitSnortList(value) {
+		negated := false
+		for strings.HasPrefix(item, "!") {
+			negated = !negated
+			item = item[1:]
+		}
+
+		var sub portSpec
+		switch {
+		case strings.EqualFold(item, "any"):
+			sub.any = true
+		case strings.HasPrefix(item, "$"):
+			expanded, ok := p.variables[item[1:]]
+			if !ok {
+				return spec, fmt.Errorf("未定義的變數: %s", item)
+			}
+			var err error
+			if sub, err = p.parsePorts(expanded, depth+1); err != nil {
+				return spec, err
+			}
+		case strings.HasPrefix(item, "["):
+			var err error
+			if sub, err = p.parsePorts(item, depth+1); err != nil {
+				return spec, err
+			}
+		default:
+			r, err := parsePortRange(item)
+			if err != nil {
+				return spec, err
+			}
+			sub.include = []portRange{r}
+		}
+
+		if negated {
+			if sub.any {
+				return spec, fmt.Errorf("不可否定 any")
+			}
+			spec.exclude = append(spec.exclude, sub.include...)
+			spec.include = append(spec.include, sub.exclude...)
+			continue
+		}
+		spec.any = spec.any || sub.any
+		spec.include = append(spec.include, sub.include...)
+		spec.exclude = append(spec.exclude, sub.exclude...)
+	}
+	if len(spec.include) == 0 && len(spec.exclude) > 0 {
+		spec.any = true
+	}
+	return spec, nil
+}
+
+// parsePortRange 解析單一連接埠或範圍 (80、1024:、:1023、8000:8080)
+func parsePortRange(value string) (portRange, error) {
+	r := portRange{low: 0, high: 65535}
+	if i := strings.Index(value, ":"); i >= 0 {
+		var err error
+		if low := value[:i]; low != "" {
+			if r.low, err = strconv.Atoi(low); err != nil {
+				return r, fmt.Errorf("無效的連接埠: %s", value)
+			}
+		}
+		if high := value[i+1:]; high != "" {
+			if r.high, err = strconv.Atoi(high); err != nil {
+				return r, fmt.Errorf("無效的連接埠: %s", value)
+			}
+		}
+	} else {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return r, fmt.Errorf("無效的連接埠: %s", value)
+		}
+		r.low, r.high = port, port
+	}
+	if r.low < 0 || r.high > 65535 || r.low > r.high {
+		return r, fmt.Errorf("連接埠超出範圍: %s", value)
+	}
+	return r, nil
+}
+
+// splitSnortList 展開最外層的 [a,b,...] 清單，保留巢狀清單
+func splitSnortList(value string) []string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		value = value[1 : len(value)-1]
+	} else {
+		return []string{value}
+	}
+
+	var items []string
+	depth, start := 0, 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, strings.TrimSpace(value[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	items = append(items, strings.TrimSpace(value[start:]))
+	return items
+}
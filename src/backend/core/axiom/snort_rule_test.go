@@ -0,0 +1,319 @@
+package axiom
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadTestCorpus(t *testing.T) ([]*SecurityRule, *SnortLoadReport) {
+	t.Helper()
+
+	file, err := os.Open("testdata/rules/community.rules")
+	require.NoError(t, err)
+	defer file.Close()
+
+	rules, report, err := NewSnortParser(map[string]string{
+		"HOME_NET":     "[10.0.0.0/8,192.168.0.0/16]",
+		"EXTERNAL_NET": "!$HOME_NET",
+	}).ParseRules(file)
+	require.NoError(t, err)
+	return rules, report
+}
+
+func findRule(rules []*SecurityRule, id string) *SecurityRule {
+	for _, rule := range rules {
+		if rule.ID == id {
+			return rule
+		}
+	}
+	return nil
+}
+
+func TestParseSnortRuleCorpus(t *testing.T) {
+	rules, report := loadTestCorpus(t)
+
+	assert.Equal(t, 15, report.Total)
+	assert.Equal(t, 10, report.Loaded)
+	assert.Equal(t, 5, report.Skipped)
+	assert.Len(t, rules, 10)
+
+	// 不支援的關鍵字應被回報
+	assert.Equal(t, 1, report.Unsupported["flags"])
+	assert.Equal(t, 1, report.Unsupported["threshold"])
+	assert.Equal(t, 1, report.Unsupported["byte_test"])
+	assert.Equal(t, 1, report.Unsupported["flowbits"])
+
+	lines := make(map[int]bool)
+	for _, e := range report.Errors {
+		assert.Greater(t, e.Line, 0)
+		lines[e.Line] = true
+	}
+	assert.Len(t, lines, 5)
+
+	rule := findRule(rules, "sid_2006446")
+	require.NotNil(t, rule)
+	assert.Equal(t, "signature", rule.Type)
+	assert.Equal(t, "alert", rule.Action)
+	assert.Equal(t, "high", rule.Severity)
+	assert.Equal(t, 14, rule.Signature.Rev)
+	assert.Equal(t, "web-application-attack", rule.Signature.Classtype)
+	assert.Equal(t, []string{"established", "to_server"}, rule.Signature.Flow)
+
+	mysql := findRule(rules, "sid_1775")
+	require.NotNil(t, mysql)
+	assert.Equal(t, "block", mysql.Action)
+	assert.Equal(t, "medium", mysql.Severity, "priority 應覆寫 classtype 的預設優先級")
+}
+
+func TestSnortSignatureMatch(t *testing.T) {
+	rules, _ := loadTestCorpus(t)
+
+	testCases := []struct {
+		name    string
+		ruleID  string
+		packet  *NetworkPacket
+		matched bool
+	}{
+		{
+			name:   "passwd 存取",
+			ruleID: "sid_1122",
+			packet: &NetworkPacket{SourceIP: "203.0.113.5", DestIP: "10.0.0.5", SourcePort: 40000, DestPort: 80,
+				Protocol: "TCP", PayloadString: "GET /../../etc/passwd HTTP/1.1"},
+			matched: true,
+		},
+		{
+			name:   "內部來源不符合 EXTERNAL_NET",
+			ruleID: "sid_1122",
+			packet: &NetworkPacket{SourceIP: "10.1.2.3", DestIP: "10.0.0.5", SourcePort: 40000, DestPort: 80,
+				Protocol: "TCP", PayloadString: "GET /etc/passwd HTTP/1.1"},
+			matched: false,
+		},
+		{
+			name:   "連接埠不在 HTTP_PORTS",
+			ruleID: "sid_1122",
+			packet: &NetworkPacket{SourceIP: "203.0.113.5", DestIP: "10.0.0.5", SourcePort: 40000, DestPort: 22,
+				Protocol: "TCP", PayloadString: "GET /etc/passwd HTTP/1.1"},
+			matched: false,
+		},
+		{
+			name:   "UNION 後接 SELECT (nocase + distance)",
+			ruleID: "sid_2006446",
+			packet: &NetworkPacket{SourceIP: "198.51.100.7", DestIP: "192.168.1.10", SourcePort: 51000, DestPort: 8080,
+				Protocol: "tcp", PayloadString: "GET /item?id=1 union all select password from users HTTP/1.1"},
+			matched: true,
+		},
+		{
+			name:   "SELECT 出現在 UNION 之前",
+			ruleID: "sid_2006446",
+			packet: &NetworkPacket{SourceIP: "198.51.100.7", DestIP: "192.168.1.10", SourcePort: 51000, DestPort: 8080,
+				Protocol: "tcp", PayloadString: "GET /select?q=union HTTP/1.1"},
+			matched: false,
+		},
+		{
+			name:   "pcre 不分大小寫",
+			ruleID: "sid_2009714",
+			packet: &NetworkPacket{SourceIP: "198.51.100.7", DestIP: "192.168.1.10", SourcePort: 51000, DestPort: 80,
+				Protocol: "TCP", PayloadString: "GET /?q=<SCRIPT src=x></SCRIPT> HTTP/1.1"},
+			matched: true,
+		},
+		{
+			name:   "十六進位 content 搭配 offset/depth",
+			ruleID: "sid_41978",
+			packet: &NetworkPacket{SourceIP: "198.51.100.7", DestIP: "10.0.0.9", SourcePort: 49152, DestPort: 445,
+				Protocol: "TCP", Payload: []byte("\x00\x00\x00\x85\xffSMB\x32\x00\x00\x00\x00\x18")},
+			matched: true,
+		},
+		{
+			name:   "十六進位 content 超出 depth",
+			ruleID: "sid_41978",
+			packet: &NetworkPacket{SourceIP: "198.51.100.7", DestIP: "10.0.0.9", SourcePort: 49152, DestPort: 445,
+				Protocol: "TCP", Payload: []byte("\x00\x00\x00\x85\x00\xffSMB\x32\x00\x00\x00\x00")},
+			matched: false,
+		},
+		{
+			name:   "within 與否定 content",
+			ruleID: "sid_25050",
+			packet: &NetworkPacket{SourceIP: "10.0.0.20", DestIP: "203.0.113.80", SourcePort: 50123, DestPort: 80,
+				Protocol: "TCP", PayloadString: "POST /panel/gate.php HTTP/1.1\r\nHost: evil\r\n\r\n"},
+			matched: true,
+		},
+		{
+			name:   "否定 content 出現時不匹配",
+			ruleID: "sid_25050",
+			packet: &NetworkPacket{SourceIP: "10.0.0.20", DestIP: "203.0.113.80", SourcePort: 50123, DestPort: 80,
+				Protocol: "TCP", PayloadString: "POST /panel/gate.php HTTP/1.1\r\nReferer: http://x/\r\n\r\n"},
+			matched: false,
+		},
+		{
+			name:   "UDP 規則不匹配 TCP 封包",
+			ruleID: "sid_2014939",
+			packet: &NetworkPacket{SourceIP: "10.0.0.20", DestIP: "8.8.8.8", SourcePort: 53000, DestPort: 53,
+				Protocol: "TCP", Payload: []byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x05onion")},
+			matched: false,
+		},
+		{
+			name:   "DNS 查詢",
+			ruleID: "sid_2014939",
+			packet: &NetworkPacket{SourceIP: "10.0.0.20", DestIP: "8.8.8.8", SourcePort: 53000, DestPort: 53,
+				Protocol: "UDP", Payload: []byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x04test\x05ONION\x00")},
+			matched: true,
+		},
+		{
+			name:   "雙向規則反向封包",
+			ruleID: "sid_718",
+			packet: &NetworkPacket{SourceIP: "10.0.0.1", DestIP: "10.0.0.2", SourcePort: 23, DestPort: 40000,
+				Protocol: "TCP", PayloadString: "Login incorrect\r\n"},
+			matched: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := findRule(rules, tc.ruleID)
+			require.NotNil(t, rule)
+
+			// 與引擎相同，flow 選項依重組器追蹤的連線狀態評估
+			reassembler, err := NewStreamReassembler(logrus.New(), DefaultReassemblyConfig())
+			require.NoError(t, err)
+			reassembler.Process(tc.packet)
+			tc.packet.conn = reassembler.Conn(tc.packet)
+			assert.Equal(t, tc.matched, rule.Signature.Match(tc.packet))
+		})
+	}
+}
+
+func TestParseSnortRuleErrors(t *testing.T) {
+	parser := NewSnortParser(nil)
+
+	testCases := []struct {
+		name string
+		rule string
+		err  string
+	}{
+		{"缺少 sid", `alert tcp any any -> any any (msg:"no sid"; content:"x";)`, "sid"},
+		{"未定義變數", `alert tcp $NOPE any -> any any (msg:"x"; sid:1;)`, "未定義的變數"},
+		{"無效 CIDR", `alert tcp 10.0.0.0/33 any -> any any (msg:"x"; sid:1;)`, "CIDR"},
+		{"修飾詞無 content", `alert tcp any any -> any any (msg:"x"; nocase; sid:1;)`, "content"},
+		{"RE2 不支援的 pcre", `alert tcp any any -> any any (msg:"x"; pcre:"/(?=a)b/"; sid:1;)`, "pcre"},
+		{"引號未結束", `alert tcp any any -> any any (msg:"x; sid:1;)`, "引號"},
+		{"非 TCP 的 flow 狀態", `alert udp any any -> any 53 (msg:"x"; flow:to_server; sid:1;)`, "flow:to_server"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parser.ParseRule(tc.rule)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestSnortContentEscapes(t *testing.T) {
+	rule, err := NewSnortParser(nil).ParseRule(
+		`alert tcp any any -> any any (msg:"semicolon \; in msg"; content:"a\;b|3A 20|c\"d"; sid:42; rev:1;)`)
+	require.NoError(t, err)
+
+	assert.Equal(t, "semicolon ; in msg", rule.Signature.Message)
+	assert.True(t, rule.Signature.Match(&NetworkPacket{Protocol: "TCP", PayloadString: `xx a;b: c"d yy`}))
+	assert.False(t, rule.Signature.Match(&NetworkPacket{Protocol: "TCP", PayloadString: `a;b:c"d`}))
+}
+
+func TestLoadSnortRulesIntoEngine(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	metricsClient := metrics.NewPrometheusMetrics(logger)
+	engine := NewAnalysisEngine(logger, metricsClient)
+	initial := len(engine.GetRules())
+
+	rules := strings.Join([]string{
+		`alert tcp $EXTERNAL_NET any -> $HOME_NET $HTTP_PORTS (msg:"SERVER-WEBAPP /etc/passwd file access attempt"; flow:to_server,established; content:"/etc/passwd"; classtype:attempted-recon; sid:1122; rev:17;)`,
+		`alert tcp $EXTERNAL_NET any -> $HOME_NET $HTTP_PORTS (msg:"SERVER-WEBAPP /etc/passwd file access attempt"; flow:to_server,established; content:"/etc/passwd"; classtype:attempted-recon; sid:1122; rev:18;)`,
+		`alert tcp any any -> any 22 (msg:"scan"; flags:S; sid:2001219; rev:20;)`,
+	}, "\n")
+
+	report, err := engine.LoadSnortRules(strings.NewReader(rules), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Loaded)
+	assert.Equal(t, 2, report.Skipped)
+	assert.Equal(t, 1, report.Unsupported["flags"])
+	assert.Len(t, engine.GetRules(), initial+1)
+
+	engine.mutex.Lock()
+	engine.running = true
+	engine.mutex.Unlock()
+
+	result, err := engine.AnalyzePacket(&NetworkPacket{
+		SourceIP:      "203.0.113.5",
+		DestIP:        "172.16.0.10",
+		SourcePort:    40000,
+		DestPort:      80,
+		Protocol:      "TCP",
+		PayloadString: "GET /cgi-bin/../../etc/passwd HTTP/1.1",
+		Timestamp:     time.Now(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "sid_1122", result.RuleID)
+	assert.Equal(t, "attempted-recon", result.ThreatType)
+	assert.Equal(t, "alert", result.Action)
+	assert.Equal(t, "medium", result.ThreatLevel)
+	assert.False(t, result.Blocked)
+
+	for _, rule := range engine.GetRules() {
+		if rule.ID == "sid_1122" {
+			assert.Equal(t, 18, rule.Signature.Rev, "應保留較新的 rev")
+		}
+	}
+}
+
+func TestSnortFlowUsesConnectionState(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	engine := NewAnalysisEngine(logger, metrics.NewPrometheusMetrics(logger))
+
+	rules := `alert tcp any any -> any 80 (msg:"to server"; flow:to_server,established; content:"/etc/passwd"; sid:3001; rev:1;)` + "\n" +
+		`alert tcp any 80 -> any any (msg:"to client"; flow:to_client,established; content:"root:x:0:0"; sid:3002; rev:1;)`
+	report, err := engine.LoadSnortRules(strings.NewReader(rules), nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, report.Loaded)
+
+	engine.mutex.Lock()
+	engine.running = true
+	engine.mutex.Unlock()
+
+	client, server := "198.51.100.7", "172.16.0.20"
+	analyze := func(src string, srcPort int, dst string, dstPort int, flags, payload string) *AnalysisResult {
+		result, err := engine.AnalyzePacket(&NetworkPacket{
+			SourceIP: src, SourcePort: srcPort, DestIP: dst, DestPort: dstPort,
+			Protocol: "TCP", TCPFlags: flags, PayloadString: payload, Timestamp: time.Now(),
+		})
+		require.NoError(t, err)
+		return result
+	}
+
+	// 交握完成前的 SYN 資料不符合 established
+	assert.Empty(t, analyze(client, 41000, server, 80, "S", "GET /etc/passwd").RuleID)
+	analyze(server, 80, client, 41000, "SA", "")
+	analyze(client, 41000, server, 80, "A", "")
+
+	assert.Equal(t, "sid_3001", analyze(client, 41000, server, 80, "PA", "GET /etc/passwd HTTP/1.1").RuleID)
+	assert.Equal(t, "sid_3002", analyze(server, 80, client, 41000, "PA", "root:x:0:0:root").RuleID)
+
+	// 方向不符：伺服器回應中的 /etc/passwd 不是 to_server
+	assert.Empty(t, analyze(server, 80, client, 41000, "PA", "see /etc/passwd").RuleID)
+
+	// 重組器未追蹤的封包不符合 flow 狀態
+	for _, r := range engine.GetRules() {
+		if r.ID == "sid_3001" {
+			assert.False(t, r.Signature.Match(&NetworkPacket{Protocol: "TCP", DestPort: 80, PayloadString: "/etc/passwd"}))
+		}
+	}
+}
@@ -0,0 +1,568 @@
+package axiom
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// OverlapPolicy 重疊片段處理策略
+type OverlapPolicy string
+
+const (
+	// OverlapPolicyFirst 保留先到達的資料 (Windows/BSD 行為)
+	OverlapPolicyFirst OverlapPolicy = "first"
+	// OverlapPolicyLast 以後到達的資料覆寫 (Linux/Solaris 行為)
+	OverlapPolicyLast OverlapPolicy = "last"
+)
+
+// StreamDirection 串流方向
+type StreamDirection string
+
+const (
+	DirectionClient StreamDirection = "client" // 用戶端 -> 伺服器
+	DirectionServer StreamDirection = "server" // 伺服器 -> 用戶端
+)
+
+// ReassemblyConfig TCP 串流重組設定
+type ReassemblyConfig struct {
+	Enabled           bool          `json:"enabled"`
+	OverlapPolicy     OverlapPolicy `json:"overlap_policy"`
+	MaxStreamBytes    int           `json:"max_stream_bytes"`    // 每個方向保留供比對的已重組資料上限
+	MaxPendingBytes   int           `json:"max_pending_bytes"`   // 每個方向等待補洞的亂序資料上限
+	MaxMemory         int           `json:"max_memory"`          // 所有流的總記憶體上限
+	MaxFlows          int           `json:"max_flows"`           // 同時追蹤的流數上限
+	FlowTimeout       time.Duration `json:"flow_timeout"`        // 閒置流逾時
+	ClosedFlowTimeout time.Duration `json:"closed_flow_timeout"` // 收到 FIN/RST 後的逾時
+}
+
+// DefaultReassemblyConfig 預設重組設定
+func DefaultReassemblyConfig() ReassemblyConfig {
+	return ReassemblyConfig{
+		Enabled:           true,
+		OverlapPolicy:     OverlapPolicyFirst,
+		MaxStreamBytes:    16 * 1024,
+		MaxPendingBytes:   64 * 1024,
+		MaxMemory:         64 * 1024 * 1024,
+		MaxFlows:          100000,
+		FlowTimeout:       2 * time.Minute,
+		ClosedFlowTimeout: 10 * time.Second,
+	}
+}
+
+// Validate 驗證重組設定
+func (c ReassemblyConfig) Validate() error {
+	switch c.OverlapPolicy {
+	case OverlapPolicyFirst, OverlapPolicyLast:
+	default:
+		return fmt.Errorf("不支援的重疊策略: %s", c.OverlapPolicy)
+	}
+	if c.MaxStreamBytes <= 0 || c.MaxPendingBytes <= 0 || c.MaxMemory <= 0 || c.MaxFlows <= 0 {
+		return fmt.Errorf("重組記憶體與流數上限必須大於 0")
+	}
+	if c.FlowTimeout <= 0 || c.ClosedFlowTimeout <= 0 {
+		return fmt.Errorf("流逾時必須大於 0")
+	}
+	return nil
+}
+
+// FlowKey 以 5-tuple 識別的 TCP 流 (兩個方向共用同一個鍵)
+type FlowKey struct {
+	Protocol string `json:"protocol"`
+	AddrA    string `json:"addr_a"`
+	PortA    int    `json:"port_a"`
+	AddrB    string `json:"addr_b"`
+	PortB    int    `json:"port_b"`
+}
+
+// newFlowKey 由封包建立正規化的流鍵
+func newFlowKey(packet *NetworkPacket) FlowKey {
+	key := FlowKey{
+		Protocol: strings.ToLower(packet.Protocol),
+		AddrA:    packet.SourceIP,
+		PortA:    packet.SourcePort,
+		AddrB:    packet.DestIP,
+		PortB:    packet.DestPort,
+	}
+	if key.AddrA > key.AddrB || (key.AddrA == key.AddrB && key.PortA > key.PortB) {
+		key.AddrA, key.AddrB = key.AddrB, key.AddrA
+		key.PortA, key.PortB = key.PortB, key.PortA
+	}
+	return key
+}
+
+// String 流鍵的文字表示
+func (k FlowKey) String() string {
+	return fmt.Sprintf("%s %s:%d <-> %s:%d", k.Protocol, k.AddrA, k.PortA, k.AddrB, k.PortB)
+}
+
+// StreamData 重組後可供比對的串流資料
+type StreamData struct {
+	Flow      FlowKey         `json:"flow"`
+	Direction StreamDirection `json:"direction"`
+	Data      []byte          `json:"data"`      // 目前保留的連續資料 (最多 MaxStreamBytes)
+	Delivered int             `json:"delivered"` // 本次封包新增的位元組數
+}
+
+// Packet 以重組資料建立供規則比對的封包；overlap 不為負時只保留本次新增的資料
+// 與其前 overlap 個位元組，較早的資料已在先前的封包比對過
+func (sd *StreamData) Packet(orig *NetworkPacket, overlap int) *NetworkPacket {
+	data := sd.Data
+	if overlap >= 0 {
+		if start := len(data) - sd.Delivered - overlap; start > 0 {
+			data = data[start:]
+		}
+	}
+
+	packet := *orig
+	packet.Payload = nil
+	packet.PayloadString = string(data)
+	return &packet
+}
+
+// ConnState 封包所屬 TCP 連線的狀態，供 Snort flow 選項判斷
+type ConnState struct {
+	Tracked     bool `json:"tracked"`     // 封包屬於重組器追蹤中的流
+	ToServer    bool `json:"to_server"`   // 封包由用戶端送往伺服器
+	Established bool `json:"established"` // 已完成三向交握，或由資料封包中途接手的流
+}
+
+// ReassemblyStats 重組統計
+type ReassemblyStats struct {
+	ActiveFlows      int   `json:"active_flows"`
+	MemoryUsed       int   `json:"memory_used"`
+	Segments         int64 `json:"segments"`
+	OutOfOrder       int64 `json:"out_of_order"`
+	Retransmissions  int64 `json:"retransmissions"`
+	Overlaps         int64 `json:"overlaps"`
+	OverlapConflicts int64 `json:"overlap_conflicts"` // 重疊但內容不同 (可能為 IDS 規避)
+	GapsSkipped      int64 `json:"gaps_skipped"`
+	FlowsEvicted     int64 `json:"flows_evicted"`
+	FlowsExpired     int64 `json:"flows_expired"`
+}
+
+// StreamReassembler TCP 串流重組器
+type StreamReassembler struct {
+	logger *logrus.Logger
+	config ReassemblyConfig
+	flows  map[FlowKey]*tcpFlow
+	lru    *list.List // 最近使用的流在前
+	memory int
+	stats  ReassemblyStats
+	mutex  sync.Mutex
+}
+
+// tcpFlow 單一 TCP 流的狀態
+type tcpFlow struct {
+	key         FlowKey
+	clientIP    string
+	clientPort  int
+	client      tcpStream
+	server      tcpStream
+	lastSeen    time.Time
+	closed      bool
+	synAck      bool // 伺服器已回應 SYN-ACK
+	established bool
+	element     *list.Element
+}
+
+// tcpStream 單一方向的重組狀態，位移量以 64 位元相對於初始序號計算以避免序號迴繞
+type tcpStream struct {
+	initialized  bool
+	base         uint32 // 位移 0 對應的序號
+	next         int64  // 下一個預期的位移
+	buffer       []byte // 已重組的連續資料，結尾位移為 next
+	pending      []streamSegment
+	pendingBytes int
+}
+
+// streamSegment 等待補洞的亂序片段
+type streamSegment struct {
+	offset int64
+	data   []byte
+}
+
+func (s streamSegment) end() int64 {
+	return s.offset + int64(len(s.data))
+}
+
+// NewStreamReassembler 建立 TCP 串流重組器
+func NewStreamReassembler(logger *logrus.Logger, config ReassemblyConfig) (*StreamReassembler, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &StreamReassembler{
+		logger: logger,
+		config: config,
+		flows:  make(map[FlowKey]*tcpFlow),
+		lru:    list.New(),
+	}, nil
+}
+
+// Process 處理一個封包；當該方向有新的連續資料時回傳重組結果，否則回傳 nil
+func (sr *StreamReassembler) Process(packet *NetworkPacket) *StreamData {
+	if !strings.EqualFold(packet.Protocol, "tcp") {
+		return nil
+	}
+
+	now := packet.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	flags := strings.ToUpper(packet.TCPFlags)
+	payload := packetPayload(packet)
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	key := newFlowKey(packet)
+	flow, exists := sr.flows[key]
+	if !exists {
+		// 沒有資料也沒有 SYN 的封包 (例如流被移除後的 ACK) 不建立新流
+		if len(payload) == 0 && !strings.Contains(flags, "S") {
+			return nil
+		}
+		flow = sr.newFlow(key, packet, flags)
+	}
+	sr.lru.MoveToFront(flow.element)
+	flow.lastSeen = now
+
+	direction := DirectionServer
+	stream := &flow.server
+	if packet.SourceIP == flow.clientIP && packet.SourcePort == flow.clientPort {
+		direction = DirectionClient
+		stream = &flow.client
+	}
+
+	before := flow.memory()
+	defer func() {
+		sr.memory += flow.memory() - before
+		sr.enforceMemory(flow)
+	}()
+
+	if strings.Contains(flags, "R") || strings.Contains(flags, "F") {
+		flow.closed = true
+	}
+	switch {
+	case strings.Contains(flags, "R"):
+		flow.established = false
+	case strings.Contains(flags, "S"):
+		if strings.Contains(flags, "A") && direction == DirectionServer {
+			flow.synAck = true
+		}
+	case strings.Contains(flags, "A") && direction == DirectionClient && flow.synAck:
+		// 用戶端確認 SYN-ACK，三向交握完成
+		flow.established = true
+	}
+
+	// 沒有序號也沒有旗標的封包 (即時擷取未帶 TCP 標頭資訊) 只更新連線狀態，
+	// 無法確認前後順序，不納入重組以免把同一對端點間無關的封包串接在一起
+	if packet.TCPSeq == 0 && flags == "" {
+		return nil
+	}
+	if strings.Contains(flags, "S") {
+		// SYN 佔用一個序號，資料從 seq+1 開始
+		if !stream.initialized {
+			stream.initialized = true
+			stream.base = packet.TCPSeq + 1
+		}
+		return nil
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+
+	if !stream.initialized {
+		// 中途接手的流：以第一個資料片段作為起點
+		stream.initialized = true
+		stream.base = packet.TCPSeq
+	}
+
+	offset := stream.next + int64(int32(packet.TCPSeq-(stream.base+uint32(stream.next))))
+
+	sr.stats.Segments++
+	delivered := sr.insert(stream, offset, payload)
+	if delivered == 0 {
+		return nil
+	}
+
+	data := make([]byte, len(stream.buffer))
+	copy(data, stream.buffer)
+	return &StreamData{
+		Flow:      key,
+		Direction: direction,
+		Data:      data,
+		Delivered: delivered,
+	}
+}
+
+// packetPayload 取得封包酬載，Payload 為空時使用 PayloadString
+func packetPayload(packet *NetworkPacket) []byte {
+	if len(packet.Payload) == 0 && packet.PayloadString != "" {
+		return []byte(packet.PayloadString)
+	}
+	return packet.Payload
+}
+
+// newFlow 建立新流；送出 SYN (不含 ACK) 的一方視為用戶端，中途接手的流以連接埠較大的一方為用戶端
+func (sr *StreamReassembler) newFlow(key FlowKey, packet *NetworkPacket, flags string) *tcpFlow {
+	flow := &tcpFlow{
+		key:        key,
+		clientIP:   packet.SourceIP,
+		clientPort: packet.SourcePort,
+		// 未看到交握就出現資料的流視為中途接手的已建立連線
+		established: !strings.Contains(flags, "S"),
+	}
+	if strings.Contains(flags, "S") && strings.Contains(flags, "A") {
+		flow.clientIP, flow.clientPort = packet.DestIP, packet.DestPort
+	}
+	if flow.established && packet.SourcePort < packet.DestPort {
+		// 中途接手時以較小的連接埠作為服務端，避免把伺服器回應誤判為用戶端
+		flow.clientIP, flow.clientPort = packet.DestIP, packet.DestPort
+	}
+
+	for len(sr.flows) >= sr.config.MaxFlows {
+		sr.evictOldest()
+	}
+	flow.element = sr.lru.PushFront(flow)
+	sr.flows[key] = flow
+	return flow
+}
+
+// insert 依重疊策略插入片段並交付連續資料，回傳新交付的位元組數
+func (sr *StreamReassembler) insert(stream *tcpStream, offset int64, data []byte) int {
+	seg := streamSegment{offset: offset, data: data}
+
+	// 已交付過的資料：只檢查是否與仍保留的內容衝突
+	if seg.offset < stream.next {
+		bufStart := stream.next - int64(len(stream.buffer))
+		if conflicts(streamSegment{offset: bufStart, data: stream.buffer}, seg) {
+			sr.stats.OverlapConflicts++
+		}
+		if seg.end() <= stream.next {
+			sr.stats.Retransmissions++
+			return 0
+		}
+		sr.stats.Overlaps++
+		seg = streamSegment{offset: stream.next, data: seg.data[stream.next-seg.offset:]}
+	}
+
+	if seg.offset > stream.next {
+		sr.stats.OutOfOrder++
+	}
+
+	stream.addPending(seg, sr.config.OverlapPolicy, &sr.stats)
+
+	delivered := stream.deliver()
+	if stream.pendingBytes > sr.config.MaxPendingBytes && len(stream.pending) > 0 {
+		// 缺口遲遲未補齊：略過缺口，並清空緩衝區以免跨缺口誤判
+		sr.stats.GapsSkipped++
+		stream.next = stream.pending[0].offset
+		stream.buffer = nil
+		delivered += stream.deliver()
+	}
+
+	if excess := len(stream.buffer) - sr.config.MaxStreamBytes; excess > 0 {
+		stream.buffer = append([]byte(nil), stream.buffer[excess:]...)
+	}
+	return delivered
+}
+
+// addPending 將片段加入等待佇列並維持片段不重疊
+func (s *tcpStream) addPending(seg streamSegment, policy OverlapPolicy, stats *ReassemblyStats) {
+	pieces := []streamSegment{seg}
+	kept := s.pending[:0:0]
+
+	for _, existing := range s.pending {
+		if existing.end() <= seg.offset || existing.offset >= seg.end() {
+			kept = append(kept, existing)
+			continue
+		}
+
+		stats.Overlaps++
+		if conflicts(existing, seg) {
+			stats.OverlapConflicts++
+		}
+
+		if policy == OverlapPolicyLast {
+			// 後到資料優先：保留既有片段未被覆蓋的部分
+			kept = append(kept, subtract(existing, seg)...)
+			continue
+		}
+
+		// 先到資料優先：新片段只保留未重疊的部分
+		kept = append(kept, existing)
+		var remaining []streamSegment
+		for _, piece := range pieces {
+			remaining = append(remaining, subtract(piece, existing)...)
+		}
+		pieces = remaining
+	}
+
+	kept = append(kept, pieces...)
+	sort.Slice(kept, func(i, j int) bool { return kept[i].offset < kept[j].offset })
+
+	s.pending = kept
+	s.pendingBytes = 0
+	for _, p := range kept {
+		s.pendingBytes += len(p.data)
+	}
+}
+
+// deliver 將從 next 開始連續的片段移入緩衝區
+func (s *tcpStream) deliver() int {
+	delivered := 0
+	for len(s.pending) > 0 && s.pending[0].offset == s.next {
+		seg := s.pending[0]
+		s.pending = s.pending[1:]
+		s.pendingBytes -= len(seg.data)
+		s.buffer = append(s.buffer, seg.data...)
+		s.next = seg.end()
+		delivered += len(seg.data)
+	}
+	return delivered
+}
+
+// subtract 回傳 seg 扣除 other 覆蓋範圍後剩下的片段 (最多兩段)
+func subtract(seg, other streamSegment) []streamSegment {
+	var result []streamSegment
+	if seg.offset < other.offset {
+		end := other.offset
+		if end > seg.end() {
+			end = seg.end()
+		}
+		result = append(result, streamSegment{offset: seg.offset, data: seg.data[:end-seg.offset]})
+	}
+	if seg.end() > other.end() {
+		start := other.end()
+		if start < seg.offset {
+			start = seg.offset
+		}
+		result = append(result, streamSegment{offset: start, data: seg.data[start-seg.offset:]})
+	}
+	return result
+}
+
+// conflicts 檢查兩個片段的重疊部分內容是否不同
+func conflicts(a, b streamSegment) bool {
+	start, end := a.offset, a.end()
+	if b.offset > start {
+		start = b.offset
+	}
+	if b.end() < end {
+		end = b.end()
+	}
+	if start >= end {
+		return false
+	}
+	return !bytes.Equal(a.data[start-a.offset:end-a.offset], b.data[start-b.offset:end-b.offset])
+}
+
+// memory 流目前佔用的位元組數
+func (f *tcpFlow) memory() int {
+	return len(f.client.buffer) + f.client.pendingBytes + len(f.server.buffer) + f.server.pendingBytes
+}
+
+// enforceMemory 超過總記憶體上限時移除最久未使用的流 (不移除目前處理中的流)
+func (sr *StreamReassembler) enforceMemory(current *tcpFlow) {
+	for sr.memory > sr.config.MaxMemory {
+		oldest := sr.lru.Back()
+		if oldest == nil || oldest.Value.(*tcpFlow) == current {
+			return
+		}
+		sr.evictOldest()
+	}
+}
+
+// evictOldest 移除最久未使用的流 (呼叫者需持有鎖)
+func (sr *StreamReassembler) evictOldest() {
+	oldest := sr.lru.Back()
+	if oldest == nil {
+		return
+	}
+	flow := oldest.Value.(*tcpFlow)
+	sr.removeFlow(flow)
+	sr.stats.FlowsEvicted++
+	sr.logger.Debugf("記憶體或流數達上限，移除TCP流: %s", flow.key)
+}
+
+// removeFlow 移除流並釋放記憶體計數 (呼叫者需持有鎖)
+func (sr *StreamReassembler) removeFlow(flow *tcpFlow) {
+	sr.memory -= flow.memory()
+	sr.lru.Remove(flow.element)
+	delete(sr.flows, flow.key)
+}
+
+// Flush 清除指定方向已重組的資料，避免同一段內容重複告警
+func (sr *StreamReassembler) Flush(key FlowKey, direction StreamDirection) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	flow, exists := sr.flows[key]
+	if !exists {
+		return
+	}
+	stream := &flow.server
+	if direction == DirectionClient {
+		stream = &flow.client
+	}
+	sr.memory -= len(stream.buffer)
+	stream.buffer = nil
+}
+
+// Conn 取得封包所屬 TCP 流的連線狀態；應在 Process 之後呼叫，未追蹤的封包回傳零值
+func (sr *StreamReassembler) Conn(packet *NetworkPacket) ConnState {
+	if !strings.EqualFold(packet.Protocol, "tcp") {
+		return ConnState{}
+	}
+
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	flow, exists := sr.flows[newFlowKey(packet)]
+	if !exists {
+		return ConnState{}
+	}
+	return ConnState{
+		Tracked:     true,
+		ToServer:    packet.SourceIP == flow.clientIP && packet.SourcePort == flow.clientPort,
+		Established: flow.established,
+	}
+}
+
+// Expire 移除逾時的流，回傳移除數量
+func (sr *StreamReassembler) Expire(now time.Time) int {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	expired := 0
+	for _, flow := range sr.flows {
+		timeout := sr.config.FlowTimeout
+		if flow.closed {
+			timeout = sr.config.ClosedFlowTimeout
+		}
+		if now.Sub(flow.lastSeen) > timeout {
+			sr.removeFlow(flow)
+			expired++
+		}
+	}
+	sr.stats.FlowsExpired += int64(expired)
+	return expired
+}
+
+// Stats 取得重組統計
+func (sr *StreamReassembler) Stats() ReassemblyStats {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	stats := sr.stats
+	stats.ActiveFlows = len(sr.flows)
+	stats.MemoryUsed = sr.memory
+	return stats
+}
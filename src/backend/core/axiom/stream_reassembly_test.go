@@ -0,0 +1,268 @@
+package axiom
+
+import (
+	"testing"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/metrics"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReassembler(t *testing.T, modify func(*ReassemblyConfig)) *StreamReassembler {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	config := DefaultReassemblyConfig()
+	if modify != nil {
+		modify(&config)
+	}
+	sr, err := NewStreamReassembler(logger, config)
+	require.NoError(t, err)
+	return sr
+}
+
+// tcpSegment 建立用戶端 10.1.1.1:40000 -> 10.2.2.2:80 的 TCP 片段
+func tcpSegment(seq uint32, flags, payload string) *NetworkPacket {
+	return &NetworkPacket{
+		SourceIP:      "10.1.1.1",
+		DestIP:        "10.2.2.2",
+		SourcePort:    40000,
+		DestPort:      80,
+		Protocol:      "TCP",
+		PayloadString: payload,
+		TCPSeq:        seq,
+		TCPFlags:      flags,
+		Timestamp:     time.Unix(1700000000, 0),
+	}
+}
+
+func TestStreamReassemblyOutOfOrder(t *testing.T) {
+	sr := newTestReassembler(t, nil)
+
+	assert.Nil(t, sr.Process(tcpSegment(1000, "S", "")))
+	assert.Nil(t, sr.Process(tcpSegment(1007, "PA", "world")), "缺口未補齊前不交付")
+
+	stream := sr.Process(tcpSegment(1001, "PA", "hello "))
+	require.NotNil(t, stream)
+	assert.Equal(t, "hello world", string(stream.Data))
+	assert.Equal(t, 11, stream.Delivered)
+	assert.Equal(t, DirectionClient, stream.Direction)
+
+	stats := sr.Stats()
+	assert.Equal(t, int64(1), stats.OutOfOrder)
+	assert.Equal(t, 1, stats.ActiveFlows)
+}
+
+func TestStreamReassemblyIgnoresUnsequencedPackets(t *testing.T) {
+	sr := newTestReassembler(t, nil)
+
+	// 即時擷取的封包沒有序號與旗標，無法判斷是否屬於同一段資料
+	first := tcpSegment(0, "", "GET /?q=uni")
+	assert.Nil(t, sr.Process(first))
+	assert.Nil(t, sr.Process(tcpSegment(0, "", "on HTTP/1.1")))
+
+	conn := sr.Conn(first)
+	assert.True(t, conn.Tracked, "仍追蹤連線狀態供 flow 選項使用")
+	assert.True(t, conn.Established)
+	assert.True(t, conn.ToServer)
+	assert.Equal(t, 0, sr.Stats().MemoryUsed)
+	assert.Equal(t, int64(0), sr.Stats().Segments)
+}
+
+func TestStreamDataPacketWindow(t *testing.T) {
+	sd := &StreamData{Data: []byte("0123456789abcdef"), Delivered: 4}
+	orig := tcpSegment(1, "PA", "cdef")
+
+	assert.Equal(t, "789abcdef", sd.Packet(orig, 5).PayloadString, "新資料加上回溯的位元組")
+	assert.Equal(t, "0123456789abcdef", sd.Packet(orig, 100).PayloadString)
+	assert.Equal(t, "0123456789abcdef", sd.Packet(orig, -1).PayloadString, "-1 比對整個緩衝區")
+	assert.Equal(t, "cdef", orig.PayloadString, "不修改原始封包")
+}
+
+func TestStreamReassemblySequenceWrap(t *testing.T) {
+	sr := newTestReassembler(t, nil)
+
+	sr.Process(tcpSegment(0xfffffffc, "S", ""))
+	require.NotNil(t, sr.Process(tcpSegment(0xfffffffd, "PA", "abc")))
+	stream := sr.Process(tcpSegment(0, "PA", "def"))
+	require.NotNil(t, stream)
+	assert.Equal(t, "abcdef", string(stream.Data))
+}
+
+func TestStreamReassemblyOverlapPolicy(t *testing.T) {
+	testCases := []struct {
+		policy   OverlapPolicy
+		expected string
+	}{
+		{OverlapPolicyFirst, "GET /index.html"},
+		{OverlapPolicyLast, "GET /evil!.html"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			sr := newTestReassembler(t, func(c *ReassemblyConfig) { c.OverlapPolicy = tc.policy })
+
+			sr.Process(tcpSegment(99, "S", ""))
+			assert.Nil(t, sr.Process(tcpSegment(105, "PA", "index.html")))
+			assert.Nil(t, sr.Process(tcpSegment(105, "PA", "evil!")))
+			stream := sr.Process(tcpSegment(100, "PA", "GET /"))
+			require.NotNil(t, stream)
+			assert.Equal(t, tc.expected, string(stream.Data))
+			assert.Equal(t, int64(1), sr.Stats().OverlapConflicts)
+		})
+	}
+}
+
+func TestStreamReassemblyRetransmission(t *testing.T) {
+	sr := newTestReassembler(t, nil)
+
+	sr.Process(tcpSegment(0, "S", ""))
+	require.NotNil(t, sr.Process(tcpSegment(1, "PA", "abcdef")))
+	assert.Nil(t, sr.Process(tcpSegment(1, "PA", "abcdef")), "完整重傳不交付")
+
+	stream := sr.Process(tcpSegment(4, "PA", "defghi"))
+	require.NotNil(t, stream)
+	assert.Equal(t, "abcdefghi", string(stream.Data))
+	assert.Equal(t, 3, stream.Delivered)
+
+	stats := sr.Stats()
+	assert.Equal(t, int64(1), stats.Retransmissions)
+	assert.Equal(t, int64(0), stats.OverlapConflicts)
+}
+
+func TestStreamReassemblyLimits(t *testing.T) {
+	t.Run("缺口超過上限時略過", func(t *testing.T) {
+		sr := newTestReassembler(t, func(c *ReassemblyConfig) { c.MaxPendingBytes = 8 })
+
+		sr.Process(tcpSegment(0, "S", ""))
+		assert.Nil(t, sr.Process(tcpSegment(11, "PA", "12345")))
+		stream := sr.Process(tcpSegment(16, "PA", "6789"))
+		require.NotNil(t, stream)
+		assert.Equal(t, "123456789", string(stream.Data))
+		assert.Equal(t, int64(1), sr.Stats().GapsSkipped)
+	})
+
+	t.Run("保留資料上限", func(t *testing.T) {
+		sr := newTestReassembler(t, func(c *ReassemblyConfig) { c.MaxStreamBytes = 4 })
+
+		stream := sr.Process(tcpSegment(1, "PA", "abcdefgh"))
+		require.NotNil(t, stream)
+		assert.Equal(t, "efgh", string(stream.Data))
+	})
+
+	t.Run("記憶體上限移除最久未使用的流", func(t *testing.T) {
+		sr := newTestReassembler(t, func(c *ReassemblyConfig) { c.MaxMemory = 10 })
+
+		first := tcpSegment(1, "PA", "aaaaaa")
+		second := tcpSegment(1, "PA", "bbbbbb")
+		second.SourcePort = 40001
+
+		sr.Process(first)
+		sr.Process(second)
+
+		stats := sr.Stats()
+		assert.Equal(t, 1, stats.ActiveFlows)
+		assert.Equal(t, int64(1), stats.FlowsEvicted)
+		assert.Equal(t, 6, stats.MemoryUsed)
+	})
+
+	t.Run("流數上限", func(t *testing.T) {
+		sr := newTestReassembler(t, func(c *ReassemblyConfig) { c.MaxFlows = 2 })
+
+		for port := 40000; port < 40005; port++ {
+			packet := tcpSegment(0, "S", "")
+			packet.SourcePort = port
+			sr.Process(packet)
+		}
+		assert.Equal(t, 2, sr.Stats().ActiveFlows)
+		assert.Equal(t, int64(3), sr.Stats().FlowsEvicted)
+	})
+}
+
+func TestStreamReassemblyExpire(t *testing.T) {
+	sr := newTestReassembler(t, nil)
+	start := time.Unix(1700000000, 0)
+
+	open := tcpSegment(1, "PA", "open")
+	closed := tcpSegment(1, "FA", "bye")
+	closed.SourcePort = 40001
+	sr.Process(open)
+	sr.Process(closed)
+
+	assert.Equal(t, 1, sr.Expire(start.Add(30*time.Second)), "已關閉的流較早逾時")
+	assert.Equal(t, 1, sr.Stats().ActiveFlows)
+	assert.Equal(t, 1, sr.Expire(start.Add(3*time.Minute)))
+	assert.Equal(t, 0, sr.Stats().MemoryUsed)
+	assert.Equal(t, int64(2), sr.Stats().FlowsExpired)
+}
+
+func TestAnalyzePacketReassembledStream(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	engine := NewAnalysisEngine(logger, metrics.NewPrometheusMetrics(logger))
+	require.NoError(t, engine.AddRule(&SecurityRule{
+		ID:       "sqli_tautology",
+		Name:     "SQL注入恆真式",
+		Type:     "pattern",
+		Pattern:  `regex:(?i)'\s*or\s+1=1`,
+		Action:   "alert",
+		Severity: "high",
+		Enabled:  true,
+	}))
+
+	engine.mutex.Lock()
+	engine.running = true
+	engine.mutex.Unlock()
+
+	head := "GET /item?id=1' or "
+	tail := "1=1-- HTTP/1.1\r\n"
+
+	// 第二個片段先到達
+	packets := []*NetworkPacket{
+		tcpSegment(5000, "S", ""),
+		tcpSegment(5001+uint32(len(head)), "PA", tail),
+		tcpSegment(5001, "PA", head),
+	}
+	for _, packet := range packets {
+		packet.SourceIP = "203.0.113.7"
+	}
+
+	var results []*AnalysisResult
+	for _, packet := range packets {
+		result, err := engine.AnalyzePacket(packet)
+		require.NoError(t, err)
+		results = append(results, result)
+	}
+
+	assert.Equal(t, "allow", results[1].Action, "單一片段不應匹配")
+	assert.Equal(t, "sqli_tautology", results[2].RuleID)
+	assert.Equal(t, "alert", results[2].Action)
+	assert.Contains(t, results[2].Details, "TCP串流重組")
+
+	// 告警後清除已比對的資料，後續片段不重複告警
+	next := tcpSegment(5001+uint32(len(head+tail)), "PA", "Host: x\r\n\r\n")
+	next.SourceIP = "203.0.113.7"
+	result, err := engine.AnalyzePacket(next)
+	require.NoError(t, err)
+	assert.Equal(t, "allow", result.Action)
+
+	// 沒有序號與旗標的封包不串接，避免無關封包組成誤判的內容
+	for _, payload := range []string{"GET /?q=1' o", "r 1=1-- HTTP/1.1"} {
+		result, err := engine.AnalyzePacket(&NetworkPacket{
+			SourceIP: "203.0.113.8", DestIP: "10.2.2.2", SourcePort: 40001, DestPort: 80,
+			Protocol: "TCP", PayloadString: payload,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "allow", result.Action)
+	}
+
+	require.NoError(t, engine.ConfigureStreamReassembly(ReassemblyConfig{}))
+	assert.Equal(t, ReassemblyStats{}, engine.GetStreamStats())
+	assert.Error(t, engine.ConfigureStreamReassembly(ReassemblyConfig{Enabled: true}))
+}
@@ -53,6 +53,10 @@ const SwaggerDoc = `{
       "name": "Events",
       "description": "事件管理"
     },
+    {
+      "name": "Reports",
+      "description": "報表"
+    },
     {
       "name": "Control",
       "description": "系統控制"
@@ -122,42 +126,245 @@ const SwaggerDoc = `{
         "description": "返回威脅偵測事件列表，支援過濾和分頁",
         "operationId": "getThreatEvents",
         "parameters": [
-          {
-            "name": "severity",
-            "in": "query",
-            "description": "威脅嚴重程度過濾",
-            "type": "string",
-            "enum": ["all", "critical", "high", "medium", "low"],
-            "default": "all"
-          },
+          {"$ref": "#/parameters/severity"},
+          {"$ref": "#/parameters/source_ip"},
           {
             "name": "time_range",
             "in": "query",
-            "description": "時間範圍",
+            "description": "由現在往前推算的時間範圍，例如 1h、24h、7d、30d；all 表示不限",
             "type": "string",
-            "enum": ["1h", "24h", "7d", "30d"],
             "default": "24h"
           },
+          {"$ref": "#/parameters/since"},
+          {"$ref": "#/parameters/until"},
+          {"$ref": "#/parameters/limit"},
+          {"$ref": "#/parameters/offset"}
+        ],
+        "responses": {
+          "200": {
+            "description": "成功返回威脅事件",
+            "schema": {
+              "$ref": "#/definitions/ThreatEventsResponse"
+            }
+          },
+          "400": {
+            "description": "查詢參數錯誤",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "503": {
+            "description": "分析引擎未連接",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/security/threats/{id}/block": {
+      "post": {
+        "tags": ["Security"],
+        "summary": "阻斷威脅來源",
+        "description": "將威脅事件的來源 IP 加入黑名單一小時",
+        "operationId": "blockThreatSource",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "description": "威脅事件 ID",
+            "required": true,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "成功阻斷",
+            "schema": {
+              "$ref": "#/definitions/SuccessResponse"
+            }
+          },
+          "404": {
+            "description": "威脅事件不存在",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/security/rules/ruleset": {
+      "get": {
+        "tags": ["Security"],
+        "summary": "取得規則集版本",
+        "description": "返回目前生效的規則集版本、雜湊與規則目錄監看狀態",
+        "operationId": "getRuleSet",
+        "responses": {
+          "200": {
+            "description": "成功返回規則集資訊",
+            "schema": {
+              "$ref": "#/definitions/RuleSetResponse"
+            }
+          },
+          "503": {
+            "description": "分析引擎未連接",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/security/rules/reload": {
+      "post": {
+        "tags": ["Security"],
+        "summary": "重新載入規則目錄",
+        "description": "立即驗證並載入規則目錄；驗證失敗時保留原規則集",
+        "operationId": "reloadRules",
+        "responses": {
+          "200": {
+            "description": "成功載入",
+            "schema": {
+              "$ref": "#/definitions/RuleSetResponse"
+            }
+          },
+          "422": {
+            "description": "規則驗證失敗",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/security/rules/stats": {
+      "get": {
+        "tags": ["Security"],
+        "summary": "取得規則命中統計",
+        "description": "返回每個規則的命中、阻斷、抑制與誤報次數及最後命中時間，依命中次數排序",
+        "operationId": "getRuleStats",
+        "parameters": [
           {
             "name": "limit",
             "in": "query",
-            "description": "每頁數量",
-            "type": "integer",
-            "default": 50
+            "description": "只返回命中最多的前 N 個規則",
+            "required": false,
+            "type": "integer"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "成功返回規則統計",
+            "schema": {
+              "$ref": "#/definitions/RuleStatsResponse"
+            }
+          }
+        }
+      }
+    },
+    "/security/false-positives": {
+      "post": {
+        "tags": ["Security"],
+        "summary": "標記誤報",
+        "description": "將最近的分析結果標記為誤報，並建立 (規則, 來源網段, 目的連接埠) 抑制條件",
+        "operationId": "markFalsePositive",
+        "parameters": [
+          {
+            "name": "body",
+            "in": "body",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/FalsePositiveRequest"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "成功建立抑制條件",
+            "schema": {
+              "$ref": "#/definitions/Suppression"
+            }
           },
+          "400": {
+            "description": "找不到分析結果或網段無效",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/security/suppressions": {
+      "get": {
+        "tags": ["Security"],
+        "summary": "取得誤報抑制條件",
+        "operationId": "getSuppressions",
+        "responses": {
+          "200": {
+            "description": "成功返回抑制條件",
+            "schema": {
+              "type": "array",
+              "items": {
+                "$ref": "#/definitions/Suppression"
+              }
+            }
+          }
+        }
+      },
+      "post": {
+        "tags": ["Security"],
+        "summary": "新增誤報抑制條件",
+        "operationId": "addSuppression",
+        "parameters": [
           {
-            "name": "offset",
-            "in": "query",
-            "description": "偏移量",
-            "type": "integer",
-            "default": 0
+            "name": "body",
+            "in": "body",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/Suppression"
+            }
           }
         ],
         "responses": {
           "200": {
-            "description": "成功返回威脅事件",
+            "description": "成功新增",
             "schema": {
-              "$ref": "#/definitions/ThreatEventsResponse"
+              "$ref": "#/definitions/Suppression"
+            }
+          },
+          "400": {
+            "description": "規則不存在或網段無效",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/security/suppressions/{id}": {
+      "delete": {
+        "tags": ["Security"],
+        "summary": "移除誤報抑制條件",
+        "operationId": "removeSuppression",
+        "parameters": [
+          {
+            "name": "id",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "成功移除",
+            "schema": {
+              "$ref": "#/definitions/SuccessResponse"
+            }
+          },
+          "404": {
+            "description": "找不到抑制條件",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
             }
           }
         }
@@ -167,8 +374,21 @@ const SwaggerDoc = `{
       "get": {
         "tags": ["Security"],
         "summary": "取得安全統計",
-        "description": "返回安全相關的統計數據",
+        "description": "返回查詢時段內的安全事件統計，趨勢為與前一個等長時段的變化百分比",
         "operationId": "getSecurityStats",
+        "parameters": [
+          {"$ref": "#/parameters/severity"},
+          {"$ref": "#/parameters/source_ip"},
+          {
+            "name": "time_range",
+            "in": "query",
+            "description": "由現在往前推算的時間範圍，例如 1h、24h、7d、30d；all 表示不限",
+            "type": "string",
+            "default": "24h"
+          },
+          {"$ref": "#/parameters/since"},
+          {"$ref": "#/parameters/until"}
+        ],
         "responses": {
           "200": {
             "description": "成功返回安全統計",
@@ -209,6 +429,36 @@ const SwaggerDoc = `{
             }
           }
         }
+      },
+      "post": {
+        "tags": ["Network"],
+        "summary": "阻斷 IP",
+        "description": "將單一 IP、CIDR (IPv4/IPv6) 或 IP 範圍加入黑名單",
+        "operationId": "blockIP",
+        "parameters": [
+          {
+            "name": "body",
+            "in": "body",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/BlockIPRequest"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "成功阻斷",
+            "schema": {
+              "$ref": "#/definitions/SuccessResponse"
+            }
+          },
+          "400": {
+            "description": "無效的 IP、CIDR 或範圍",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
       }
     },
     "/network/blocked-ips/{ip}": {
@@ -221,7 +471,7 @@ const SwaggerDoc = `{
           {
             "name": "ip",
             "in": "path",
-            "description": "IP 位址",
+            "description": "IP 位址、CIDR (例如 10.0.0.0/8、2001:db8::/64) 或 IP 範圍",
             "required": true,
             "type": "string"
           }
@@ -232,6 +482,12 @@ const SwaggerDoc = `{
             "schema": {
               "$ref": "#/definitions/SuccessResponse"
             }
+          },
+          "404": {
+            "description": "黑名單中找不到",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
           }
         }
       }
@@ -272,20 +528,13 @@ const SwaggerDoc = `{
             "description": "是否已解決",
             "type": "boolean"
           },
-          {
-            "name": "limit",
-            "in": "query",
-            "description": "每頁數量",
-            "type": "integer",
-            "default": 50
-          },
-          {
-            "name": "offset",
-            "in": "query",
-            "description": "偏移量",
-            "type": "integer",
-            "default": 0
-          }
+          {"$ref": "#/parameters/severity"},
+          {"$ref": "#/parameters/source_ip"},
+          {"$ref": "#/parameters/time_range"},
+          {"$ref": "#/parameters/since"},
+          {"$ref": "#/parameters/until"},
+          {"$ref": "#/parameters/limit"},
+          {"$ref": "#/parameters/offset"}
         ],
         "responses": {
           "200": {
@@ -318,6 +567,12 @@ const SwaggerDoc = `{
             "schema": {
               "$ref": "#/definitions/SuccessResponse"
             }
+          },
+          "404": {
+            "description": "告警不存在",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
           }
         }
       }
@@ -335,20 +590,13 @@ const SwaggerDoc = `{
             "description": "事件類型",
             "type": "string"
           },
-          {
-            "name": "limit",
-            "in": "query",
-            "description": "每頁數量",
-            "type": "integer",
-            "default": 50
-          },
-          {
-            "name": "offset",
-            "in": "query",
-            "description": "偏移量",
-            "type": "integer",
-            "default": 0
-          }
+          {"$ref": "#/parameters/severity"},
+          {"$ref": "#/parameters/source_ip"},
+          {"$ref": "#/parameters/time_range"},
+          {"$ref": "#/parameters/since"},
+          {"$ref": "#/parameters/until"},
+          {"$ref": "#/parameters/limit"},
+          {"$ref": "#/parameters/offset"}
         ],
         "responses": {
           "200": {
@@ -381,38 +629,220 @@ const SwaggerDoc = `{
             "schema": {
               "$ref": "#/definitions/Event"
             }
+          },
+          "404": {
+            "description": "事件不存在",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
           }
         }
       }
     },
-    "/control/network": {
-      "post": {
-        "tags": ["Control"],
-        "summary": "控制網路狀態",
-        "description": "阻斷或解除網路阻斷",
-        "operationId": "controlNetwork",
+    "/reports/security": {
+      "get": {
+        "tags": ["Reports"],
+        "summary": "生成安全報表",
+        "operationId": "generateSecurityReport",
+        "produces": ["application/json", "text/csv", "text/html", "application/pdf"],
         "parameters": [
           {
-            "name": "body",
-            "in": "body",
-            "description": "控制動作",
-            "required": true,
-            "schema": {
-              "$ref": "#/definitions/NetworkControlRequest"
-            }
-          }
+            "name": "time_range",
+            "in": "query",
+            "description": "由現在往前推算的時間範圍，例如 1h、24h、7d、30d；all 表示不限",
+            "type": "string",
+            "default": "24h"
+          },
+          {"$ref": "#/parameters/format"}
         ],
         "responses": {
           "200": {
-            "description": "成功執行控制動作",
+            "description": "成功產生報表",
             "schema": {
-              "$ref": "#/definitions/SuccessResponse"
+              "$ref": "#/definitions/SecurityReport"
             }
+          }
+        }
+      }
+    },
+    "/reports/network": {
+      "get": {
+        "tags": ["Reports"],
+        "summary": "生成網路報表",
+        "operationId": "generateNetworkReport",
+        "produces": ["application/json", "text/csv", "text/html", "application/pdf"],
+        "parameters": [
+          {
+            "name": "time_range",
+            "in": "query",
+            "description": "由現在往前推算的時間範圍，例如 1h、24h、7d、30d；all 表示不限",
+            "type": "string",
+            "default": "24h"
           },
-          "400": {
-            "description": "無效的請求",
+          {"$ref": "#/parameters/format"}
+        ],
+        "responses": {
+          "200": {
+            "description": "成功產生報表",
             "schema": {
-              "$ref": "#/definitions/ErrorResponse"
+              "$ref": "#/definitions/NetworkReport"
+            }
+          }
+        }
+      }
+    },
+    "/reports/system": {
+      "get": {
+        "tags": ["Reports"],
+        "summary": "生成系統報表",
+        "operationId": "generateSystemReport",
+        "produces": ["application/json", "text/csv", "text/html", "application/pdf"],
+        "parameters": [
+          {
+            "name": "time_range",
+            "in": "query",
+            "description": "由現在往前推算的時間範圍，例如 1h、24h、7d、30d；all 表示不限",
+            "type": "string",
+            "default": "24h"
+          },
+          {"$ref": "#/parameters/format"}
+        ],
+        "responses": {
+          "200": {
+            "description": "成功產生報表",
+            "schema": {
+              "$ref": "#/definitions/SystemReport"
+            }
+          }
+        }
+      }
+    },
+    "/reports/custom": {
+      "post": {
+        "tags": ["Reports"],
+        "summary": "生成自訂報表",
+        "description": "依 report_type 產生安全、網路或系統報表",
+        "operationId": "generateCustomReport",
+        "produces": ["application/json", "text/csv", "text/html", "application/pdf"],
+        "parameters": [
+          {
+            "name": "body",
+            "in": "body",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/CustomReportRequest"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "成功產生報表"
+          },
+          "400": {
+            "description": "請求格式錯誤或不支援的報表類型",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/reports/schedules": {
+      "get": {
+        "tags": ["Reports"],
+        "summary": "取得排程報表",
+        "description": "排程報表設定與最近一次執行狀態",
+        "operationId": "getReportSchedules",
+        "responses": {
+          "200": {
+            "description": "成功",
+            "schema": {
+              "type": "object",
+              "properties": {
+                "schedules": {"type": "array", "items": {"$ref": "#/definitions/ReportSchedule"}},
+                "status": {"type": "array", "items": {"$ref": "#/definitions/ReportRunStatus"}}
+              }
+            }
+          },
+          "503": {
+            "description": "未設定排程報表",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/reports/schedules/{name}/run": {
+      "post": {
+        "tags": ["Reports"],
+        "summary": "立即執行排程報表",
+        "description": "立即產生報表並寫入輸出目錄或發布 report.generated 事件",
+        "operationId": "runReportSchedule",
+        "parameters": [
+          {
+            "name": "name",
+            "in": "path",
+            "required": true,
+            "type": "string"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "執行完成",
+            "schema": {
+              "$ref": "#/definitions/ReportRunStatus"
+            }
+          },
+          "404": {
+            "description": "排程報表不存在",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "500": {
+            "description": "產生報表失敗",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          },
+          "503": {
+            "description": "未設定排程報表",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/control/network": {
+      "post": {
+        "tags": ["Control"],
+        "summary": "控制網路狀態",
+        "description": "阻斷或解除網路阻斷",
+        "operationId": "controlNetwork",
+        "parameters": [
+          {
+            "name": "body",
+            "in": "body",
+            "description": "控制動作",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/NetworkControlRequest"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "成功執行控制動作",
+            "schema": {
+              "$ref": "#/definitions/SuccessResponse"
+            }
+          },
+          "400": {
+            "description": "無效的請求",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
             }
           }
         }
@@ -509,6 +939,141 @@ const SwaggerDoc = `{
         }
       }
     },
+    "/stream/events": {
+      "get": {
+        "tags": ["Monitoring"],
+        "summary": "即時事件串流 (SSE)",
+        "description": "以 Server-Sent Events 串流告警、安全事件與網路事件，與 /ws 共用事件來源。帶 Last-Event-ID 重新連線時補送環狀緩衝區中遺漏的訊息，遺漏的訊息已被覆寫時先送出 reset 事件",
+        "operationId": "streamEvents",
+        "produces": ["text/event-stream"],
+        "parameters": [
+          {
+            "name": "topics",
+            "in": "query",
+            "description": "以逗號分隔的主題 (alerts, events, network)，預設為全部",
+            "type": "string"
+          },
+          {
+            "name": "min_severity",
+            "in": "query",
+            "description": "只接收嚴重程度不低於此值的訊息",
+            "type": "string",
+            "enum": ["low", "medium", "high", "critical"]
+          },
+          {
+            "name": "agent_id",
+            "in": "query",
+            "type": "string"
+          },
+          {"$ref": "#/parameters/source_ip"},
+          {
+            "name": "rule_id",
+            "in": "query",
+            "type": "string"
+          },
+          {
+            "name": "Last-Event-ID",
+            "in": "header",
+            "description": "最後收到的事件編號",
+            "type": "integer"
+          },
+          {
+            "name": "last_event_id",
+            "in": "query",
+            "description": "最後收到的事件編號 (無法設定標頭時使用)",
+            "type": "integer"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "事件串流，每則事件的 data 為 WebSocketMessage"
+          },
+          "400": {
+            "description": "查詢參數錯誤",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/stream/poll": {
+      "get": {
+        "tags": ["Monitoring"],
+        "summary": "即時事件長輪詢",
+        "description": "回傳編號大於 since 的訊息，沒有新訊息時等待到 timeout",
+        "operationId": "pollEvents",
+        "parameters": [
+          {
+            "name": "topics",
+            "in": "query",
+            "description": "以逗號分隔的主題 (alerts, events, network)，預設為全部",
+            "type": "string"
+          },
+          {
+            "name": "min_severity",
+            "in": "query",
+            "description": "只接收嚴重程度不低於此值的訊息",
+            "type": "string",
+            "enum": ["low", "medium", "high", "critical"]
+          },
+          {
+            "name": "agent_id",
+            "in": "query",
+            "type": "string"
+          },
+          {"$ref": "#/parameters/source_ip"},
+          {
+            "name": "rule_id",
+            "in": "query",
+            "type": "string"
+          },
+          {
+            "name": "since",
+            "in": "query",
+            "description": "最後收到的事件編號，未指定時只等待新訊息",
+            "type": "integer"
+          },
+          {
+            "name": "timeout",
+            "in": "query",
+            "description": "最長等待時間 (Go duration，最多 60s)",
+            "type": "string",
+            "default": "25s"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "新訊息",
+            "schema": {
+              "$ref": "#/definitions/PollResponse"
+            }
+          },
+          "400": {
+            "description": "查詢參數錯誤",
+            "schema": {
+              "$ref": "#/definitions/ErrorResponse"
+            }
+          }
+        }
+      }
+    },
+    "/ws/clients": {
+      "get": {
+        "tags": ["Monitoring"],
+        "summary": "取得 WebSocket 客戶端",
+        "description": "返回每個 /ws 客戶端的訂閱主題、發送佇列長度與因佇列已滿而捨棄的訊息數",
+        "operationId": "getWebSocketClients",
+        "responses": {
+          "200": {
+            "description": "成功返回客戶端列表",
+            "schema": {
+              "$ref": "#/definitions/WebSocketClientsResponse"
+            }
+          }
+        }
+      }
+    },
     "/monitoring/services": {
       "get": {
         "tags": ["Monitoring"],
@@ -551,6 +1116,63 @@ const SwaggerDoc = `{
       }
     }
   },
+  "parameters": {
+    "severity": {
+      "name": "severity",
+      "in": "query",
+      "description": "嚴重程度過濾，all 表示不過濾",
+      "type": "string",
+      "enum": ["all", "critical", "high", "medium", "low"]
+    },
+    "source_ip": {
+      "name": "source_ip",
+      "in": "query",
+      "description": "來源 IP 過濾",
+      "type": "string"
+    },
+    "since": {
+      "name": "since",
+      "in": "query",
+      "description": "起始時間 (RFC3339)，指定時忽略 time_range",
+      "type": "string",
+      "format": "date-time"
+    },
+    "until": {
+      "name": "until",
+      "in": "query",
+      "description": "結束時間 (RFC3339，不含)",
+      "type": "string",
+      "format": "date-time"
+    },
+    "time_range": {
+      "name": "time_range",
+      "in": "query",
+      "description": "由現在往前推算的時間範圍，例如 1h、24h、7d、30d；all 表示不限",
+      "type": "string"
+    },
+    "limit": {
+      "name": "limit",
+      "in": "query",
+      "description": "每頁數量 (1-1000)",
+      "type": "integer",
+      "default": 50
+    },
+    "offset": {
+      "name": "offset",
+      "in": "query",
+      "description": "偏移量",
+      "type": "integer",
+      "default": 0
+    },
+    "format": {
+      "name": "format",
+      "in": "query",
+      "description": "報表格式",
+      "type": "string",
+      "enum": ["json", "csv", "html", "pdf"],
+      "default": "json"
+    }
+  },
   "definitions": {
     "SystemStatus": {
       "type": "object",
@@ -621,15 +1243,15 @@ const SwaggerDoc = `{
     "ThreatEvent": {
       "type": "object",
       "properties": {
-        "id": {"type": "string"},
-        "timestamp": {"type": "string"},
-        "type": {"type": "string"},
+        "id": {"type": "string", "description": "事件 ID，可用於 /events/{id}"},
+        "timestamp": {"type": "string", "format": "date-time"},
+        "type": {"type": "string", "description": "威脅類型 (規則類型或 Snort classtype)"},
         "severity": {"type": "string"},
         "source_ip": {"type": "string"},
         "destination_ip": {"type": "string"},
         "port": {"type": "integer"},
         "protocol": {"type": "string"},
-        "action": {"type": "string"},
+        "action": {"type": "string", "enum": ["block", "alert", "log"]},
         "description": {"type": "string"},
         "rule_id": {"type": "string"}
       }
@@ -639,11 +1261,35 @@ const SwaggerDoc = `{
       "properties": {
         "total_threats": {"type": "integer"},
         "blocked_threats": {"type": "integer"},
-        "active_threats": {"type": "integer"},
+        "active_threats": {"type": "integer", "description": "威脅快取中仍在追蹤的來源數量"},
         "resolved_threats": {"type": "integer"},
-        "threat_trend": {"type": "number"},
-        "top_threat_types": {"type": "array"},
-        "top_source_ips": {"type": "array"}
+        "threat_trend": {"type": "number", "description": "與前一個等長時段相比的變化百分比"},
+        "by_severity": {"type": "object", "additionalProperties": {"type": "integer"}},
+        "top_threat_types": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "properties": {
+              "type": {"type": "string"},
+              "count": {"type": "integer"}
+            }
+          }
+        },
+        "top_source_ips": {
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/IPCount"
+          }
+        },
+        "since": {"type": "string", "format": "date-time"},
+        "until": {"type": "string", "format": "date-time"}
+      }
+    },
+    "IPCount": {
+      "type": "object",
+      "properties": {
+        "ip": {"type": "string"},
+        "count": {"type": "integer"}
       }
     },
     "NetworkStats": {
@@ -670,13 +1316,154 @@ const SwaggerDoc = `{
         }
       }
     },
+    "RuleStatsResponse": {
+      "type": "object",
+      "properties": {
+        "rules": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "properties": {
+              "rule_id": {"type": "string"},
+              "rule_name": {"type": "string"},
+              "type": {"type": "string"},
+              "action": {"type": "string"},
+              "enabled": {"type": "boolean"},
+              "hits": {"type": "integer"},
+              "blocks": {"type": "integer"},
+              "suppressed": {"type": "integer"},
+              "false_positives": {"type": "integer"},
+              "last_hit": {"type": "string", "format": "date-time"}
+            }
+          }
+        },
+        "total": {"type": "integer"},
+        "timestamp": {"type": "integer"}
+      }
+    },
+    "FalsePositiveRequest": {
+      "type": "object",
+      "required": ["packet_id"],
+      "properties": {
+        "packet_id": {"type": "string", "description": "分析結果的 packet_id"},
+        "source_cidr": {"type": "string", "description": "抑制的來源網段，預設為結果的來源 IP"},
+        "any_port": {"type": "boolean", "description": "不限目的連接埠"},
+        "reason": {"type": "string"},
+        "duration_seconds": {"type": "integer", "description": "抑制有效秒數，0 表示永久"}
+      }
+    },
+    "Suppression": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "string"},
+        "rule_id": {"type": "string"},
+        "source_cidr": {"type": "string"},
+        "dest_port": {"type": "integer", "description": "0 表示任意連接埠"},
+        "reason": {"type": "string"},
+        "packet_id": {"type": "string"},
+        "created_at": {"type": "string", "format": "date-time"},
+        "expires_at": {"type": "string", "format": "date-time"},
+        "hits": {"type": "integer"}
+      }
+    },
+    "RuleSetResponse": {
+      "type": "object",
+      "properties": {
+        "ruleset": {
+          "type": "object",
+          "properties": {
+            "version": {"type": "integer", "description": "每次規則變更遞增"},
+            "hash": {"type": "string", "description": "生效規則定義的 SHA-256"},
+            "source": {"type": "string", "enum": ["api", "snort", "store", "directory"]},
+            "rule_count": {"type": "integer"},
+            "updated_at": {"type": "string", "format": "date-time"}
+          }
+        },
+        "watcher": {
+          "type": "object",
+          "properties": {
+            "directory": {"type": "string"},
+            "file_hash": {"type": "string"},
+            "last_reload": {"type": "string", "format": "date-time"},
+            "last_error": {"type": "string"}
+          }
+        }
+      }
+    },
+    "BlockIPRequest": {
+      "type": "object",
+      "required": ["ip"],
+      "properties": {
+        "ip": {"type": "string", "description": "單一 IP、CIDR 或 IP 範圍 (例如 10.0.0.1-10.0.0.20)"},
+        "duration_seconds": {"type": "integer", "description": "阻斷秒數，預設 3600"}
+      }
+    },
+    "WebSocketMessage": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "integer", "description": "環狀緩衝區編號，只有 alerts、events、network 主題的訊息有編號"},
+        "type": {"type": "string"},
+        "topic": {"type": "string"},
+        "data": {"type": "object"}
+      }
+    },
+    "PollResponse": {
+      "type": "object",
+      "properties": {
+        "events": {
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/WebSocketMessage"
+          }
+        },
+        "last_event_id": {"type": "integer", "description": "下一次輪詢的 since"},
+        "missed": {"type": "boolean", "description": "部分訊息已被環狀緩衝區覆寫"}
+      }
+    },
+    "Subscription": {
+      "type": "object",
+      "required": ["topic"],
+      "properties": {
+        "topic": {"type": "string", "enum": ["alerts", "events", "metrics", "network", "devices", "dashboard"]},
+        "min_severity": {"type": "string", "enum": ["low", "medium", "high", "critical"]},
+        "agent_id": {"type": "string"},
+        "source_ip": {"type": "string"},
+        "rule_id": {"type": "string"}
+      }
+    },
+    "WebSocketClientsResponse": {
+      "type": "object",
+      "properties": {
+        "clients": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "properties": {
+              "id": {"type": "string"},
+              "remote_addr": {"type": "string"},
+              "connected_at": {"type": "string", "format": "date-time"},
+              "subscriptions": {
+                "type": "array",
+                "items": {
+                  "$ref": "#/definitions/Subscription"
+                }
+              },
+              "queued": {"type": "integer"},
+              "dropped": {"type": "integer"}
+            }
+          }
+        },
+        "total": {"type": "integer"},
+        "queue_size": {"type": "integer"},
+        "slow_consumer_policy": {"type": "string", "enum": ["drop", "disconnect"]}
+      }
+    },
     "BlockedIP": {
       "type": "object",
       "properties": {
         "ip": {"type": "string"},
-        "reason": {"type": "string"},
-        "blocked_at": {"type": "string"},
-        "expires_at": {"type": "string"},
+        "reason": {"type": "string", "description": "威脅快取中記錄的威脅類型"},
+        "expires_at": {"type": "string", "format": "date-time"},
         "threat_count": {"type": "integer"}
       }
     },
@@ -707,7 +1494,12 @@ const SwaggerDoc = `{
     "AlertsResponse": {
       "type": "object",
       "properties": {
-        "alerts": {"type": "array"},
+        "alerts": {
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/Alert"
+          }
+        },
         "total": {"type": "integer"},
         "limit": {"type": "integer"},
         "offset": {"type": "integer"}
@@ -716,7 +1508,12 @@ const SwaggerDoc = `{
     "EventsResponse": {
       "type": "object",
       "properties": {
-        "events": {"type": "array"},
+        "events": {
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/Event"
+          }
+        },
         "total": {"type": "integer"},
         "limit": {"type": "integer"},
         "offset": {"type": "integer"}
@@ -726,11 +1523,177 @@ const SwaggerDoc = `{
       "type": "object",
       "properties": {
         "id": {"type": "string"},
-        "type": {"type": "string"},
+        "type": {"type": "string", "enum": ["threat_detection"]},
         "severity": {"type": "string"},
         "message": {"type": "string"},
-        "timestamp": {"type": "integer"},
-        "data": {"type": "object"}
+        "timestamp": {"type": "string", "format": "date-time"},
+        "source_ip": {"type": "string"},
+        "dest_ip": {"type": "string"},
+        "dest_port": {"type": "integer"},
+        "protocol": {"type": "string"},
+        "threat_type": {"type": "string"},
+        "action": {"type": "string"},
+        "blocked": {"type": "boolean"},
+        "rule_id": {"type": "string"},
+        "rule_name": {"type": "string"},
+        "packet_id": {"type": "string"}
+      }
+    },
+    "Alert": {
+      "type": "object",
+      "properties": {
+        "id": {"type": "string"},
+        "level": {"type": "string", "enum": ["critical", "warning", "info"]},
+        "severity": {"type": "string"},
+        "message": {"type": "string"},
+        "timestamp": {"type": "string", "format": "date-time"},
+        "source": {"type": "string"},
+        "source_ip": {"type": "string"},
+        "rule_id": {"type": "string"},
+        "event_id": {"type": "string"},
+        "resolved": {"type": "boolean"},
+        "resolved_at": {"type": "string", "format": "date-time"}
+      }
+    },
+    "SecurityReport": {
+      "type": "object",
+      "properties": {
+        "report_type": {"type": "string"},
+        "time_range": {"type": "string"},
+        "generated_at": {"type": "string", "format": "date-time"},
+        "since": {"type": "string", "format": "date-time"},
+        "until": {"type": "string", "format": "date-time"},
+        "summary": {
+          "type": "object",
+          "properties": {
+            "total_threats": {"type": "integer"},
+            "blocked_threats": {"type": "integer"},
+            "active_threats": {"type": "integer"},
+            "resolved_threats": {"type": "integer"},
+            "threat_trend": {"type": "number"},
+            "by_severity": {"type": "object", "additionalProperties": {"type": "integer"}}
+          }
+        },
+        "threat_by_type": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "properties": {
+              "type": {"type": "string"},
+              "count": {"type": "integer"},
+              "percentage": {"type": "number"}
+            }
+          }
+        },
+        "top_source_ips": {
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/IPCount"
+          }
+        },
+        "timeline": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "properties": {
+              "hour": {"type": "string", "format": "date-time"},
+              "count": {"type": "integer"}
+            }
+          }
+        }
+      }
+    },
+    "NetworkReport": {
+      "type": "object",
+      "properties": {
+        "report_type": {"type": "string"},
+        "time_range": {"type": "string"},
+        "generated_at": {"type": "string", "format": "date-time"},
+        "since": {"type": "string", "format": "date-time"},
+        "until": {"type": "string", "format": "date-time"},
+        "summary": {
+          "type": "object",
+          "properties": {
+            "blocked_ips": {"type": "integer"},
+            "blocked_connections": {"type": "integer"},
+            "whitelisted_ips": {"type": "integer"},
+            "streams": {"type": "object", "description": "TCP 串流重組統計"}
+          }
+        },
+        "top_sources": {
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/IPCount"
+          }
+        },
+        "top_destinations": {
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/IPCount"
+          }
+        }
+      }
+    },
+    "SystemReport": {
+      "type": "object",
+      "properties": {
+        "report_type": {"type": "string"},
+        "time_range": {"type": "string"},
+        "generated_at": {"type": "string", "format": "date-time"},
+        "summary": {
+          "type": "object",
+          "properties": {
+            "uptime": {"type": "string"},
+            "uptime_seconds": {"type": "integer"},
+            "goroutines": {"type": "integer"},
+            "heap_alloc_bytes": {"type": "integer"},
+            "engine_connected": {"type": "boolean"},
+            "engine_running": {"type": "boolean"}
+          }
+        },
+        "ruleset": {"type": "object"},
+        "streams": {"type": "object"}
+      }
+    },
+    "CustomReportRequest": {
+      "type": "object",
+      "required": ["report_type"],
+      "properties": {
+        "report_type": {"type": "string", "enum": ["security", "network", "system"]},
+        "time_range": {"type": "string", "default": "24h"},
+        "filters": {
+          "type": "object",
+          "properties": {
+            "severity": {"type": "string"},
+            "source_ip": {"type": "string"},
+            "type": {"type": "string"}
+          }
+        },
+        "since": {"type": "string", "format": "date-time"},
+        "until": {"type": "string", "format": "date-time"},
+        "format": {"type": "string", "enum": ["json", "csv", "html", "pdf"]}
+      }
+    },
+    "ReportSchedule": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string"},
+        "report_type": {"type": "string", "enum": ["security", "network", "system"]},
+        "format": {"type": "string", "enum": ["json", "csv", "html", "pdf"]},
+        "interval": {"type": "integer", "description": "間隔 (奈秒)"},
+        "time_range": {"type": "string"},
+        "output_dir": {"type": "string"},
+        "publish": {"type": "boolean"}
+      }
+    },
+    "ReportRunStatus": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string"},
+        "runs": {"type": "integer"},
+        "last_run": {"type": "string", "format": "date-time"},
+        "last_file": {"type": "string"},
+        "last_error": {"type": "string"}
       }
     },
     "NetworkControlRequest": {
@@ -1,9 +1,13 @@
 package axiom
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,13 +16,24 @@ import (
 	"pandora_box_console_ids_ips/internal/metrics"
 )
 
+const (
+	maxPageSize    = 1000 // 列表查詢每頁上限
+	dashboardItems = 10   // 儀表板顯示的最近告警與事件數量
+)
+
 // UIServer Axiom UI伺服器
 type UIServer struct {
 	logger         *logrus.Logger
 	metricsClient  *metrics.PrometheusMetrics
-	websocketConns map[string]*websocket.Conn
+	hub            *wsHub
+	feed           *liveFeed
+	wsConfig       WebSocketConfig
 	upgrader       websocket.Upgrader
 	startTime      time.Time
+	engine         *AnalysisEngine
+	ruleWatcher    *RuleWatcher
+	reports        *ReportScheduler
+	stopResults    func() // 取消訂閱引擎分析結果
 }
 
 // SystemStatus 系統狀態
@@ -62,46 +77,69 @@ type SystemStatus struct {
 		AlertManager bool `json:"alertmanager"`
 	} `json:"monitoring"`
 	Devices struct {
-		Total        int    `json:"total"`
-		Online       int    `json:"online"`
-		Offline      int    `json:"offline"`
-		LastActivity string `json:"lastActivity"`
+		Total   int `json:"total"`
+		Online  int `json:"online"`
+		Offline int `json:"offline"`
 	} `json:"devices"`
 }
 
-// Alert 告警結構
-type Alert struct {
-	ID        string    `json:"id"`
-	Level     string    `json:"level"`
-	Message   string    `json:"message"`
-	Timestamp time.Time `json:"timestamp"`
-	Source    string    `json:"source"`
-	Resolved  bool      `json:"resolved"`
-}
-
 // WebSocketMessage WebSocket訊息結構
 type WebSocketMessage struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
+	ID    uint64      `json:"id,omitempty"` // 環狀緩衝區編號，可作為 SSE 的 Last-Event-ID
+	Type  string      `json:"type"`
+	Topic string      `json:"topic,omitempty"` // 伺服器推送訊息所屬的訂閱主題
+	Data  interface{} `json:"data"`
 }
 
 // NewUIServer 建立新的UI伺服器
 func NewUIServer(logger *logrus.Logger, metricsClient *metrics.PrometheusMetrics) *UIServer {
-	return &UIServer{
-		logger:         logger,
-		metricsClient:  metricsClient,
-		websocketConns: make(map[string]*websocket.Conn),
-		startTime:      time.Now(),
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // 在生產環境中應該檢查來源
-			},
-		},
+	ui := &UIServer{
+		logger:        logger,
+		metricsClient: metricsClient,
+		hub:           newWSHub(),
+		feed:          newLiveFeed(defaultFeedSize),
+		wsConfig:      DefaultWebSocketConfig(),
+		startTime:     time.Now(),
+	}
+	ui.upgrader = websocket.Upgrader{CheckOrigin: ui.checkOrigin}
+	return ui
+}
+
+// SetAnalysisEngine 連接分析引擎，連接後黑名單等端點改用引擎的即時資料，並即時推送分析結果
+func (ui *UIServer) SetAnalysisEngine(engine *AnalysisEngine) {
+	if ui.stopResults != nil {
+		ui.stopResults()
+		ui.stopResults = nil
 	}
+	ui.engine = engine
+	if engine != nil {
+		ui.stopResults = engine.OnResult(ui.publishResult)
+	}
+}
+
+// SetRuleWatcher 連接規則目錄監看器，啟用手動重新載入端點
+func (ui *UIServer) SetRuleWatcher(watcher *RuleWatcher) {
+	ui.ruleWatcher = watcher
+}
+
+// SetReportScheduler 連接報表排程器，啟用排程查詢與手動執行端點
+func (ui *UIServer) SetReportScheduler(scheduler *ReportScheduler) {
+	ui.reports = scheduler
 }
 
 // StartUIServer 啟動UI伺服器
 func (ui *UIServer) StartUIServer(port string) error {
+	router := ui.setupRouter()
+
+	// 啟動定期數據推送
+	go ui.startPeriodicUpdates()
+
+	ui.logger.Infof("Axiom UI伺服器啟動於端口: %s", port)
+	return router.Run(":" + port)
+}
+
+// setupRouter 建立路由
+func (ui *UIServer) setupRouter() *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 	router.Use(gin.Recovery())
@@ -145,11 +183,19 @@ func (ui *UIServer) StartUIServer(port string) error {
 		api.GET("/security/threats", ui.getThreatEvents)
 		api.GET("/security/stats", ui.getSecurityStats)
 		api.POST("/security/threats/:id/block", ui.blockThreatSource)
+		api.GET("/security/rules/ruleset", ui.getRuleSet)
+		api.POST("/security/rules/reload", ui.reloadRules)
+		api.GET("/security/rules/stats", ui.getRuleStats)
+		api.POST("/security/false-positives", ui.markFalsePositive)
+		api.GET("/security/suppressions", ui.getSuppressions)
+		api.POST("/security/suppressions", ui.addSuppression)
+		api.DELETE("/security/suppressions/:id", ui.removeSuppression)
 		
 		// 網路管理
 		api.GET("/network/stats", ui.getNetworkStats)
 		api.GET("/network/blocked-ips", ui.getBlockedIPs)
-		api.DELETE("/network/blocked-ips/:ip", ui.unblockIP)
+		api.POST("/network/blocked-ips", ui.blockIP)
+		api.DELETE("/network/blocked-ips/*ip", ui.unblockIP) // 萬用參數以支援含 "/" 的 CIDR
 		api.GET("/network/interfaces", ui.getNetworkInterfaces)
 		
 		// 設備管理
@@ -163,6 +209,8 @@ func (ui *UIServer) StartUIServer(port string) error {
 		api.GET("/reports/network", ui.generateNetworkReport)
 		api.GET("/reports/system", ui.generateSystemReport)
 		api.POST("/reports/custom", ui.generateCustomReport)
+		api.GET("/reports/schedules", ui.getReportSchedules)
+		api.POST("/reports/schedules/:name/run", ui.runReportSchedule)
 		
 		// 事件管理
 		api.GET("/events", ui.getEvents)
@@ -187,12 +235,13 @@ func (ui *UIServer) StartUIServer(port string) error {
 
 	// WebSocket 路由
 	router.GET("/ws", ui.handleWebSocket)
+	router.GET("/api/v1/ws/clients", ui.getWebSocketClients)
 
-	// 啟動定期數據推送
-	go ui.startPeriodicUpdates()
+	// SSE 與長輪詢，供無法升級 WebSocket 的環境使用
+	router.GET("/api/v1/stream/events", ui.streamEvents)
+	router.GET("/api/v1/stream/poll", ui.pollEvents)
 
-	ui.logger.Infof("Axiom UI伺服器啟動於端口: %s", port)
-	return router.Run(":" + port)
+	return router
 }
 
 // corsMiddleware CORS中間件
@@ -244,6 +293,7 @@ func (ui *UIServer) loggingMiddleware() gin.HandlerFunc {
 
 // getSystemStatus 取得系統狀態
 func (ui *UIServer) getSystemStatus(c *gin.Context) {
+	counts := ui.engineCounters(c.Request.Context())
 	status := SystemStatus{
 		Agent: struct {
 			Status     string  `json:"status"`
@@ -287,8 +337,8 @@ func (ui *UIServer) getSystemStatus(c *gin.Context) {
 			BlockTime:   "",
 			UnlockTime:  "",
 			TotalTraffic: 1024000,
-			BlockedIPs:  12,
-			AllowedIPs:  156,
+			BlockedIPs:  counts.blockedIPs,
+			AllowedIPs:  counts.allowedIPs,
 		},
 		Security: struct {
 			TotalAlerts     int    `json:"totalAlerts"`
@@ -297,14 +347,7 @@ func (ui *UIServer) getSystemStatus(c *gin.Context) {
 			InfoAlerts      int    `json:"infoAlerts"`
 			ThreatsBlocked  int    `json:"threatsBlocked"`
 			LastThreat      string `json:"lastThreat"`
-		}{
-			TotalAlerts:    45,
-			CriticalAlerts: 3,
-			WarningAlerts:  12,
-			InfoAlerts:     30,
-			ThreatsBlocked: 8,
-			LastThreat:     "2025-01-14 10:30:15",
-		},
+		}{},
 		Monitoring: struct {
 			Prometheus   bool `json:"prometheus"`
 			Grafana      bool `json:"grafana"`
@@ -317,18 +360,25 @@ func (ui *UIServer) getSystemStatus(c *gin.Context) {
 			AlertManager: true,
 		},
 		Devices: struct {
-			Total        int    `json:"total"`
-			Online       int    `json:"online"`
-			Offline      int    `json:"offline"`
-			LastActivity string `json:"lastActivity"`
+			Total   int `json:"total"`
+			Online  int `json:"online"`
+			Offline int `json:"offline"`
 		}{
-			Total:        5,
-			Online:       4,
-			Offline:      1,
-			LastActivity: "2025-01-14 10:25:30",
+			Total:   5,
+			Online:  4,
+			Offline: 1,
 		},
 	}
 
+	// 安全概況來自分析引擎的事件儲存
+	overview := ui.securityOverview()
+	status.Security.TotalAlerts = overview["total_alerts"].(int)
+	status.Security.CriticalAlerts = overview["critical_alerts"].(int)
+	status.Security.WarningAlerts = overview["warning_alerts"].(int)
+	status.Security.InfoAlerts = overview["info_alerts"].(int)
+	status.Security.ThreatsBlocked = overview["threats_blocked"].(int)
+	status.Security.LastThreat = overview["last_threat"].(string)
+
 	c.JSON(http.StatusOK, status)
 }
 
@@ -355,130 +405,200 @@ func (ui *UIServer) getHealth(c *gin.Context) {
 func (ui *UIServer) getDashboardData(c *gin.Context) {
 	data := map[string]interface{}{
 		"system_status": ui.getSystemStatusData(),
-		"alerts":       ui.getAlertsData(),
-		"events":       ui.getEventsData(),
-		"metrics":      ui.getMetricsData(),
-		"timestamp":    time.Now().Unix(),
+		"alerts":        ui.recentAlerts(dashboardItems),
+		"events":        ui.recentEvents(dashboardItems),
+		"metrics":       ui.getMetricsData(),
+		"timestamp":     time.Now().Unix(),
 	}
 
 	c.JSON(http.StatusOK, data)
 }
 
-// getAlerts 取得告警列表
-func (ui *UIServer) getAlerts(c *gin.Context) {
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-	level := c.Query("level")
-	resolved := c.Query("resolved")
+// parseEventQuery 解析事件、告警與威脅列表共用的分頁與過濾參數；
+// 未指定 since 時以 time_range (例如 1h、24h、7d，"all" 表示不限) 往前推算
+func parseEventQuery(c *gin.Context, defaultRange string) (EventQuery, error) {
+	query := EventQuery{
+		Type:     c.Query("type"),
+		Level:    c.Query("level"),
+		Severity: c.Query("severity"),
+		SourceIP: c.Query("source_ip"),
+	}
+	if query.Severity == "all" {
+		query.Severity = ""
+	}
+	if query.SourceIP != "" {
+		if _, err := netip.ParseAddr(query.SourceIP); err != nil {
+			return query, fmt.Errorf("source_ip 格式錯誤: %s", query.SourceIP)
+		}
+	}
 
-	alerts := ui.getAlertsData()
-	
-	// 過濾告警
-	filtered := make([]Alert, 0)
-	for _, alert := range alerts {
-		if level != "" && alert.Level != level {
-			continue
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		return query, fmt.Errorf("limit 必須介於 1 到 %d", maxPageSize)
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		return query, fmt.Errorf("offset 不可為負數")
+	}
+	query.Limit, query.Offset = limit, offset
+
+	if value := c.Query("resolved"); value != "" {
+		resolved, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, fmt.Errorf("resolved 必須為布林值")
 		}
-		if resolved != "" {
-			resolvedBool, _ := strconv.ParseBool(resolved)
-			if alert.Resolved != resolvedBool {
-				continue
-			}
+		query.Resolved = &resolved
+	}
+
+	if value := c.Query("since"); value != "" {
+		if query.Since, err = time.Parse(time.RFC3339, value); err != nil {
+			return query, fmt.Errorf("since 必須為 RFC3339 時間: %v", err)
+		}
+	}
+	if value := c.Query("until"); value != "" {
+		if query.Until, err = time.Parse(time.RFC3339, value); err != nil {
+			return query, fmt.Errorf("until 必須為 RFC3339 時間: %v", err)
 		}
-		filtered = append(filtered, alert)
+	}
+	if timeRange := c.DefaultQuery("time_range", defaultRange); query.Since.IsZero() && timeRange != "" && timeRange != "all" {
+		duration, err := parseTimeRange(timeRange)
+		if err != nil {
+			return query, err
+		}
+		query.Since = time.Now().Add(-duration)
 	}
 
-	// 分頁
-	start := offset
-	if start > len(filtered) {
-		start = len(filtered)
+	return query, nil
+}
+
+// parseTimeRange 解析時間範圍，除了 Go 的時間格式外也接受以 d 表示天數
+func parseTimeRange(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		count, err := strconv.Atoi(days)
+		if err != nil || count <= 0 {
+			return 0, fmt.Errorf("時間範圍格式錯誤: %s", value)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
 	}
-	end := start + limit
-	if end > len(filtered) {
-		end = len(filtered)
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("時間範圍格式錯誤: %s", value)
 	}
+	return duration, nil
+}
 
-	result := filtered[start:end]
+// eventStore 取得分析引擎的事件儲存，未連接時回應 503
+func (ui *UIServer) eventStore(c *gin.Context) EventStore {
+	if ui.engine == nil || ui.engine.Events() == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "分析引擎未連接"})
+		return nil
+	}
+	return ui.engine.Events()
+}
+
+// getAlerts 取得告警列表
+func (ui *UIServer) getAlerts(c *gin.Context) {
+	query, err := parseEventQuery(c, "all")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	store := ui.eventStore(c)
+	if store == nil {
+		return
+	}
+
+	alerts, total, err := store.QueryAlerts(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"alerts": result,
-		"total":  len(filtered),
-		"limit":  limit,
-		"offset": offset,
+		"alerts": alerts,
+		"total":  total,
+		"limit":  query.Limit,
+		"offset": query.Offset,
 	})
 }
 
 // resolveAlert 解決告警
 func (ui *UIServer) resolveAlert(c *gin.Context) {
 	alertID := c.Param("id")
-	
+	store := ui.eventStore(c)
+	if store == nil {
+		return
+	}
+
+	alert, err := store.ResolveAlert(c.Request.Context(), alertID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if alert == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("告警不存在: %s", alertID)})
+		return
+	}
+
 	ui.logger.Infof("解決告警: %s", alertID)
-	
-	// 這裡應該更新資料庫中的告警狀態
-	ui.broadcastWebSocketMessage("alert_resolved", map[string]interface{}{
-		"alert_id": alertID,
+
+	ui.publish(TopicAlerts, "alert_resolved", map[string]interface{}{
+		"alert_id":  alertID,
 		"timestamp": time.Now().Unix(),
-	})
+	}, messageAttributes{Severity: alert.Severity, SourceIP: alert.SourceIP, RuleID: alert.RuleID})
 
 	c.JSON(http.StatusOK, gin.H{
-		"status": "success",
-		"message": "告警已解決",
-		"alert_id": alertID,
+		"status":    "success",
+		"message":   "告警已解決",
+		"alert_id":  alertID,
+		"alert":     alert,
+		"timestamp": time.Now().Unix(),
 	})
 }
 
 // getEvents 取得事件列表
 func (ui *UIServer) getEvents(c *gin.Context) {
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-	eventType := c.Query("type")
-
-	events := ui.getEventsData()
-	
-	// 過濾事件
-	filtered := make([]map[string]interface{}, 0)
-	for _, event := range events {
-		if eventType != "" && event["type"] != eventType {
-			continue
-		}
-		filtered = append(filtered, event)
-	}
-
-	// 分頁
-	start := offset
-	if start > len(filtered) {
-		start = len(filtered)
+	query, err := parseEventQuery(c, "all")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	end := start + limit
-	if end > len(filtered) {
-		end = len(filtered)
+	store := ui.eventStore(c)
+	if store == nil {
+		return
 	}
 
-	result := filtered[start:end]
+	events, total, err := store.QueryEvents(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"events": result,
-		"total":  len(filtered),
-		"limit":  limit,
-		"offset": offset,
+		"events": events,
+		"total":  total,
+		"limit":  query.Limit,
+		"offset": query.Offset,
 	})
 }
 
 // getEvent 取得單個事件
 func (ui *UIServer) getEvent(c *gin.Context) {
 	eventID := c.Param("id")
-	
-	// 這裡應該從資料庫獲取事件
-	event := map[string]interface{}{
-		"id":        eventID,
-		"type":      "threat_detection",
-		"severity":  "high",
-		"message":   "偵測到惡意IP連接嘗試",
-		"timestamp": time.Now().Unix(),
-		"data": map[string]interface{}{
-			"source_ip":   "192.168.1.100",
-			"threat_type": "brute_force",
-		},
+	store := ui.eventStore(c)
+	if store == nil {
+		return
+	}
+
+	event, err := store.GetEvent(c.Request.Context(), eventID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("事件不存在: %s", eventID)})
+		return
 	}
 
 	c.JSON(http.StatusOK, event)
@@ -498,18 +618,18 @@ func (ui *UIServer) controlNetwork(c *gin.Context) {
 	switch request.Action {
 	case "block":
 		ui.logger.Info("透過UI請求阻斷網路")
-		ui.broadcastWebSocketMessage("network_status", map[string]interface{}{
+		ui.publish(TopicNetwork, "network_status", map[string]interface{}{
 			"blocked": true,
 			"action":  "block",
 			"timestamp": time.Now().Unix(),
-		})
+		}, messageAttributes{})
 	case "unblock":
 		ui.logger.Info("透過UI請求解除網路阻斷")
-		ui.broadcastWebSocketMessage("network_status", map[string]interface{}{
+		ui.publish(TopicNetwork, "network_status", map[string]interface{}{
 			"blocked": false,
 			"action":  "unblock",
 			"timestamp": time.Now().Unix(),
-		})
+		}, messageAttributes{})
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "無效的動作"})
 		return
@@ -524,13 +644,14 @@ func (ui *UIServer) controlNetwork(c *gin.Context) {
 
 // getNetworkStatus 取得網路狀態
 func (ui *UIServer) getNetworkStatus(c *gin.Context) {
+	counts := ui.engineCounters(c.Request.Context())
 	status := map[string]interface{}{
 		"blocked":      false,
 		"block_time":   "",
 		"unlock_time":  "",
 		"total_traffic": 1024000,
-		"blocked_ips":   12,
-		"allowed_ips":   156,
+		"blocked_ips":   counts.blockedIPs,
+		"allowed_ips":   counts.allowedIPs,
 		"last_update":   time.Now().Unix(),
 	}
 
@@ -551,11 +672,11 @@ func (ui *UIServer) controlDevice(c *gin.Context) {
 
 	ui.logger.Infof("透過UI請求裝置操作: %s", request.Action)
 
-	ui.broadcastWebSocketMessage("device_status", map[string]interface{}{
+	ui.publish(TopicDevices, "device_status", map[string]interface{}{
 		"action": request.Action,
 		"data":   request.Data,
 		"timestamp": time.Now().Unix(),
-	})
+	}, messageAttributes{})
 
 	c.JSON(http.StatusOK, gin.H{
 		"status": "success", 
@@ -594,12 +715,13 @@ func (ui *UIServer) getDeviceStatus(c *gin.Context) {
 
 // getMetrics 取得指標數據
 func (ui *UIServer) getMetrics(c *gin.Context) {
+	counts := ui.engineCounters(c.Request.Context())
 	metrics := map[string]interface{}{
 		"network_blocked":     0,
 		"device_connected":    1,
-		"threats_detected":    45,
-		"packets_inspected":   1000000,
-		"blocked_connections": 12,
+		"threats_detected":    counts.threats,
+		"packets_inspected":   counts.packets,
+		"blocked_connections": counts.blocked,
 		"active_sessions":     8,
 		"system_uptime":       time.Since(ui.startTime).Seconds(),
 		"cpu_usage":           15.5,
@@ -614,11 +736,12 @@ func (ui *UIServer) getMetrics(c *gin.Context) {
 // getPrometheusMetrics 取得Prometheus指標
 func (ui *UIServer) getPrometheusMetrics(c *gin.Context) {
 	// 這裡應該從Prometheus獲取實際指標
+	counts := ui.engineCounters(c.Request.Context())
 	metrics := map[string]interface{}{
 		"pandora_system_uptime_seconds": time.Since(ui.startTime).Seconds(),
-		"pandora_threats_detected_total": 45,
-		"pandora_packets_inspected_total": 1000000,
-		"pandora_blocked_connections_total": 12,
+		"pandora_threats_detected_total": counts.threats,
+		"pandora_packets_inspected_total": counts.packets,
+		"pandora_blocked_connections_total": counts.blocked,
 		"pandora_active_sessions": 8,
 		"pandora_cpu_usage_percent": 15.5,
 		"pandora_memory_usage_percent": 42.3,
@@ -675,97 +798,6 @@ func (ui *UIServer) getServiceStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, status)
 }
 
-// handleWebSocket 處理WebSocket連接
-func (ui *UIServer) handleWebSocket(c *gin.Context) {
-	conn, err := ui.upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		ui.logger.Errorf("WebSocket升級失敗: %v", err)
-		return
-	}
-	defer conn.Close()
-
-	clientID := c.Query("client_id")
-	if clientID == "" {
-		clientID = fmt.Sprintf("client_%d", time.Now().UnixNano())
-	}
-
-	ui.websocketConns[clientID] = conn
-	defer delete(ui.websocketConns, clientID)
-
-	ui.logger.Infof("WebSocket客戶端連接: %s", clientID)
-
-	// 發送初始數據
-	if err := ui.sendWebSocketMessage(conn, "connected", map[string]interface{}{
-		"client_id": clientID,
-		"timestamp": time.Now().Unix(),
-		"server_time": time.Now().Format("2006-01-02 15:04:05"),
-	}); err != nil {
-		ui.logger.Errorf("發送初始數據失敗: %v", err)
-	}
-
-	// 監聽客戶端訊息
-	for {
-		var message WebSocketMessage
-		err := conn.ReadJSON(&message)
-		if err != nil {
-			ui.logger.Debugf("WebSocket讀取錯誤: %v", err)
-			break
-		}
-
-		ui.handleWebSocketMessage(clientID, message)
-	}
-}
-
-// handleWebSocketMessage 處理WebSocket訊息
-func (ui *UIServer) handleWebSocketMessage(clientID string, message WebSocketMessage) {
-	ui.logger.Debugf("收到WebSocket訊息: %s - %s", clientID, message.Type)
-
-	switch message.Type {
-	case "ping":
-		conn := ui.websocketConns[clientID]
-		if conn != nil {
-			if err := ui.sendWebSocketMessage(conn, "pong", map[string]interface{}{
-				"timestamp": time.Now().Unix(),
-			}); err != nil {
-				ui.logger.Errorf("發送pong訊息失敗: %v", err)
-			}
-		}
-	case "subscribe":
-		ui.logger.Debugf("客戶端 %s 訂閱: %v", clientID, message.Data)
-	case "get_status":
-		conn := ui.websocketConns[clientID]
-		if conn != nil {
-			if err := ui.sendWebSocketMessage(conn, "status_update", ui.getSystemStatusData()); err != nil {
-				ui.logger.Errorf("發送狀態更新失敗: %v", err)
-			}
-		}
-	}
-}
-
-// sendWebSocketMessage 發送WebSocket訊息
-func (ui *UIServer) sendWebSocketMessage(conn *websocket.Conn, msgType string, data interface{}) error {
-	message := WebSocketMessage{
-		Type: msgType,
-		Data: data,
-	}
-	return conn.WriteJSON(message)
-}
-
-// broadcastWebSocketMessage 廣播WebSocket訊息
-func (ui *UIServer) broadcastWebSocketMessage(msgType string, data interface{}) {
-	message := WebSocketMessage{
-		Type: msgType,
-		Data: data,
-	}
-
-	for clientID, conn := range ui.websocketConns {
-		if err := conn.WriteJSON(message); err != nil {
-			ui.logger.Errorf("發送WebSocket訊息失敗 (客戶端: %s): %v", clientID, err)
-			delete(ui.websocketConns, clientID)
-		}
-	}
-}
-
 // startPeriodicUpdates 啟動定期更新
 func (ui *UIServer) startPeriodicUpdates() {
 	ticker := time.NewTicker(30 * time.Second)
@@ -781,16 +813,18 @@ func (ui *UIServer) startPeriodicUpdates() {
 func (ui *UIServer) sendPeriodicUpdates() {
 	data := map[string]interface{}{
 		"system_status": ui.getSystemStatusData(),
-		"alerts":       ui.getAlertsData(),
-		"events":       ui.getEventsData(),
-		"timestamp":    time.Now().Unix(),
+		"alerts":        ui.recentAlerts(dashboardItems),
+		"events":        ui.recentEvents(dashboardItems),
+		"timestamp":     time.Now().Unix(),
 	}
 
-	ui.broadcastWebSocketMessage("dashboard_update", data)
+	ui.publish(TopicDashboard, "dashboard_update", data, messageAttributes{})
+	ui.publish(TopicMetrics, "metrics_update", ui.getMetricsData(), messageAttributes{})
 }
 
 // getSystemStatusData 取得系統狀態數據
 func (ui *UIServer) getSystemStatusData() map[string]interface{} {
+	counts := ui.engineCounters(context.Background())
 	return map[string]interface{}{
 		"agent": map[string]interface{}{
 			"status":       "online",
@@ -813,17 +847,10 @@ func (ui *UIServer) getSystemStatusData() map[string]interface{} {
 			"block_time":    "",
 			"unlock_time":   "",
 			"total_traffic": 1024000,
-			"blocked_ips":   12,
-			"allowed_ips":   156,
-		},
-		"security": map[string]interface{}{
-			"total_alerts":    45,
-			"critical_alerts": 3,
-			"warning_alerts":  12,
-			"info_alerts":     30,
-			"threats_blocked": 8,
-			"last_threat":     "2025-01-14 10:30:15",
+			"blocked_ips":   counts.blockedIPs,
+			"allowed_ips":   counts.allowedIPs,
 		},
+		"security": ui.securityOverview(),
 		"monitoring": map[string]interface{}{
 			"prometheus":   true,
 			"grafana":      true,
@@ -831,90 +858,90 @@ func (ui *UIServer) getSystemStatusData() map[string]interface{} {
 			"alertmanager": true,
 		},
 		"devices": map[string]interface{}{
-			"total":         5,
-			"online":        4,
-			"offline":       1,
-			"last_activity": "2025-01-14 10:25:30",
+			"total":   5,
+			"online":  4,
+			"offline": 1,
 		},
 	}
 }
 
-// getAlertsData 取得告警數據
-func (ui *UIServer) getAlertsData() []Alert {
-	return []Alert{
-		{
-			ID:        "alert_001",
-			Level:     "critical",
-			Message:   "偵測到惡意IP連接嘗試",
-			Timestamp: time.Now().Add(-5 * time.Minute),
-			Source:    "threat_detection",
-			Resolved:  false,
-		},
-		{
-			ID:        "alert_002",
-			Level:     "warning",
-			Message:   "網路流量異常",
-			Timestamp: time.Now().Add(-10 * time.Minute),
-			Source:    "network_monitor",
-			Resolved:  false,
-		},
-		{
-			ID:        "alert_003",
-			Level:     "info",
-			Message:   "系統更新完成",
-			Timestamp: time.Now().Add(-15 * time.Minute),
-			Source:    "system",
-			Resolved:  true,
-		},
+// recentAlerts 取得最近的未解決告警，引擎未連接時回傳空列表
+func (ui *UIServer) recentAlerts(limit int) []*Alert {
+	if ui.engine == nil || ui.engine.Events() == nil {
+		return []*Alert{}
 	}
+
+	resolved := false
+	alerts, _, err := ui.engine.Events().QueryAlerts(context.Background(), EventQuery{Resolved: &resolved, Limit: limit})
+	if err != nil {
+		ui.logger.Warnf("查詢告警失敗: %v", err)
+		return []*Alert{}
+	}
+	return alerts
 }
 
-// getEventsData 取得事件數據
-func (ui *UIServer) getEventsData() []map[string]interface{} {
-	return []map[string]interface{}{
-		{
-			"id":        "event_001",
-			"type":      "threat_detection",
-			"severity":  "high",
-			"message":   "偵測到惡意IP連接嘗試",
-			"timestamp": time.Now().Unix() - 300,
-			"data": map[string]interface{}{
-				"source_ip":   "192.168.1.100",
-				"threat_type": "brute_force",
-			},
-		},
-		{
-			"id":        "event_002",
-			"type":      "network_event",
-			"severity":  "info",
-			"message":   "網路連接已恢復",
-			"timestamp": time.Now().Unix() - 600,
-			"data": map[string]interface{}{
-				"action": "unblock",
-			},
-		},
-		{
-			"id":        "event_003",
-			"type":      "device_event",
-			"severity":  "medium",
-			"message":   "USB裝置連接",
-			"timestamp": time.Now().Unix() - 900,
-			"data": map[string]interface{}{
-				"device_id": "device_001",
-				"port":      "/dev/ttyUSB0",
-			},
-		},
+// recentEvents 取得最近的安全事件，引擎未連接時回傳空列表
+func (ui *UIServer) recentEvents(limit int) []*SecurityEvent {
+	if ui.engine == nil || ui.engine.Events() == nil {
+		return []*SecurityEvent{}
 	}
+
+	events, _, err := ui.engine.Events().QueryEvents(context.Background(), EventQuery{Limit: limit})
+	if err != nil {
+		ui.logger.Warnf("查詢安全事件失敗: %v", err)
+		return []*SecurityEvent{}
+	}
+	return events
+}
+
+// securityOverview 最近 24 小時的安全概況
+func (ui *UIServer) securityOverview() map[string]interface{} {
+	overview := map[string]interface{}{
+		"total_alerts":    0,
+		"critical_alerts": 0,
+		"warning_alerts":  0,
+		"info_alerts":     0,
+		"threats_blocked": 0,
+		"last_threat":     "",
+	}
+	if ui.engine == nil || ui.engine.Events() == nil {
+		return overview
+	}
+
+	store := ui.engine.Events()
+	ctx := context.Background()
+	since := time.Now().Add(-24 * time.Hour)
+	for _, level := range []string{"critical", "warning", "info"} {
+		_, count, err := store.QueryAlerts(ctx, EventQuery{Level: level, Since: since, Limit: 1})
+		if err != nil {
+			ui.logger.Warnf("查詢告警失敗: %v", err)
+			return overview
+		}
+		overview[level+"_alerts"] = count
+		overview["total_alerts"] = overview["total_alerts"].(int) + count
+	}
+
+	summary, err := store.Summarize(ctx, EventQuery{Since: since}, 0)
+	if err != nil {
+		ui.logger.Warnf("彙總安全事件失敗: %v", err)
+		return overview
+	}
+	overview["threats_blocked"] = summary.Blocked
+	if summary.LastEvent != nil {
+		overview["last_threat"] = summary.LastEvent.Format("2006-01-02 15:04:05")
+	}
+	return overview
 }
 
 // getMetricsData 取得指標數據
 func (ui *UIServer) getMetricsData() map[string]interface{} {
+	counts := ui.engineCounters(context.Background())
 	return map[string]interface{}{
 		"network_blocked":     0,
 		"device_connected":    1,
-		"threats_detected":    45,
-		"packets_inspected":   1000000,
-		"blocked_connections": 12,
+		"threats_detected":    counts.threats,
+		"packets_inspected":   counts.packets,
+		"blocked_connections": counts.blocked,
 		"active_sessions":     8,
 		"system_uptime":       time.Since(ui.startTime).Seconds(),
 		"cpu_usage":           15.5,
@@ -923,111 +950,156 @@ func (ui *UIServer) getMetricsData() map[string]interface{} {
 	}
 }
 
+// engineCounts 由分析引擎取得的統計
+type engineCounts struct {
+	blockedIPs int    // 黑名單項目數
+	allowedIPs int    // 白名單項目數
+	threats    int    // 偵測到的威脅數
+	blocked    int    // 阻斷的連線數
+	packets    uint64 // 已分析的封包數
+}
+
+// engineCounters 由分析引擎取得目前的黑白名單大小、威脅數、阻斷數與封包數；引擎未連接時皆為 0
+func (ui *UIServer) engineCounters(ctx context.Context) engineCounts {
+	var counts engineCounts
+	if ui.engine == nil {
+		return counts
+	}
+
+	counts.blockedIPs = len(ui.engine.GetBlacklist())
+	counts.allowedIPs = len(ui.engine.GetWhitelist())
+	counts.packets = ui.engine.PacketsInspected()
+	if store := ui.engine.Events(); store != nil {
+		summary, err := store.Summarize(ctx, EventQuery{}, 0)
+		if err != nil {
+			ui.logger.Warnf("彙總安全事件失敗: %v", err)
+		} else {
+			counts.threats, counts.blocked = summary.Total, summary.Blocked
+		}
+	}
+	return counts
+}
+
+// ThreatEvent 威脅事件列表項目
+type ThreatEvent struct {
+	ID            string `json:"id"`
+	Timestamp     string `json:"timestamp"`
+	Type          string `json:"type"`
+	Severity      string `json:"severity"`
+	SourceIP      string `json:"source_ip"`
+	DestinationIP string `json:"destination_ip"`
+	Port          int    `json:"port"`
+	Protocol      string `json:"protocol"`
+	Action        string `json:"action"`
+	Description   string `json:"description"`
+	RuleID        string `json:"rule_id"`
+}
+
 // getThreatEvents 取得威脅事件列表
 func (ui *UIServer) getThreatEvents(c *gin.Context) {
-	_ = c.DefaultQuery("severity", "all")
-	_ = c.DefaultQuery("time_range", "24h")
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	query, err := parseEventQuery(c, "24h")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	store := ui.eventStore(c)
+	if store == nil {
+		return
+	}
 
-	// 模擬威脅事件數據
-	threats := []map[string]interface{}{
-		{
-			"id":             "threat_001",
-			"timestamp":      time.Now().Add(-1 * time.Hour).Format(time.RFC3339),
-			"type":           "SQL Injection Attempt",
-			"severity":       "critical",
-			"source_ip":      "192.168.1.100",
-			"destination_ip": "10.0.0.5",
-			"port":           3306,
-			"protocol":       "TCP",
-			"action":         "blocked",
-			"description":    "偵測到 SQL 注入攻擊嘗試",
-			"rule_id":        "RULE-001",
-		},
-		{
-			"id":             "threat_002",
-			"timestamp":      time.Now().Add(-2 * time.Hour).Format(time.RFC3339),
-			"type":           "Brute Force Attack",
-			"severity":       "high",
-			"source_ip":      "192.168.1.101",
-			"destination_ip": "10.0.0.6",
-			"port":           22,
-			"protocol":       "SSH",
-			"action":         "blocked",
-			"description":    "偵測到 SSH 暴力破解攻擊",
-			"rule_id":        "RULE-002",
-		},
-		{
-			"id":             "threat_003",
-			"timestamp":      time.Now().Add(-3 * time.Hour).Format(time.RFC3339),
-			"type":           "Port Scanning",
-			"severity":       "medium",
-			"source_ip":      "192.168.1.102",
-			"destination_ip": "10.0.0.7",
-			"port":           0,
-			"protocol":       "ICMP",
-			"action":         "logged",
-			"description":    "偵測到端口掃描活動",
-			"rule_id":        "RULE-003",
-		},
+	events, total, err := store.QueryEvents(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	threats := make([]ThreatEvent, 0, len(events))
+	for _, event := range events {
+		threats = append(threats, ThreatEvent{
+			ID:            event.ID,
+			Timestamp:     event.Timestamp.Format(time.RFC3339),
+			Type:          event.ThreatType,
+			Severity:      event.Severity,
+			SourceIP:      event.SourceIP,
+			DestinationIP: event.DestIP,
+			Port:          event.DestPort,
+			Protocol:      event.Protocol,
+			Action:        event.Action,
+			Description:   event.Message,
+			RuleID:        event.RuleID,
+		})
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"threats": threats,
-		"total":   len(threats),
-		"limit":   limit,
-		"offset":  offset,
+		"total":   total,
+		"limit":   query.Limit,
+		"offset":  query.Offset,
 	})
 }
 
 // getSecurityStats 取得安全統計
 func (ui *UIServer) getSecurityStats(c *gin.Context) {
-	stats := map[string]interface{}{
-		"total_threats":    156,
-		"blocked_threats":  142,
-		"active_threats":   8,
-		"resolved_threats": 148,
-		"threat_trend":     -5.2,
-		"top_threat_types": []map[string]interface{}{
-			{"type": "SQL Injection", "count": 42},
-			{"type": "Brute Force", "count": 35},
-			{"type": "Port Scanning", "count": 28},
-			{"type": "DDoS", "count": 22},
-			{"type": "XSS", "count": 18},
-		},
-		"top_source_ips": []map[string]interface{}{
-			{"ip": "192.168.1.100", "count": 25},
-			{"ip": "192.168.1.101", "count": 18},
-			{"ip": "192.168.1.102", "count": 15},
-			{"ip": "192.168.1.103", "count": 12},
-		},
+	query, err := parseEventQuery(c, "24h")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if ui.eventStore(c) == nil {
+		return
+	}
+
+	stats, err := ui.engine.GetSecurityStats(c.Request.Context(), query, 5)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, stats)
 }
 
-// blockThreatSource 阻斷威脅來源
+// blockThreatSource 阻斷威脅事件的來源 IP
 func (ui *UIServer) blockThreatSource(c *gin.Context) {
 	threatID := c.Param("id")
-	
-	ui.logger.Infof("阻斷威脅來源: %s", threatID)
-	
-	ui.broadcastWebSocketMessage("threat_blocked", map[string]interface{}{
+	store := ui.eventStore(c)
+	if store == nil {
+		return
+	}
+
+	event, err := store.GetEvent(c.Request.Context(), threatID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("威脅事件不存在: %s", threatID)})
+		return
+	}
+	if err := ui.engine.AddToBlacklist(event.SourceIP, time.Hour); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ui.logger.Infof("阻斷威脅來源: %s (%s)", threatID, event.SourceIP)
+
+	ui.publish(TopicNetwork, "threat_blocked", map[string]interface{}{
 		"threat_id": threatID,
+		"ip":        event.SourceIP,
 		"timestamp": time.Now().Unix(),
-	})
+	}, messageAttributes{Severity: event.Severity, SourceIP: event.SourceIP, RuleID: event.RuleID})
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":    "success",
 		"message":   "威脅來源已阻斷",
 		"threat_id": threatID,
+		"ip":        event.SourceIP,
 		"timestamp": time.Now().Unix(),
 	})
 }
 
 // getNetworkStats 取得網路統計
 func (ui *UIServer) getNetworkStats(c *gin.Context) {
+	counts := ui.engineCounters(c.Request.Context())
 	stats := map[string]interface{}{
 		"total_traffic":       10240000,
 		"inbound_traffic":     6144000,
@@ -1036,7 +1108,7 @@ func (ui *UIServer) getNetworkStats(c *gin.Context) {
 		"latency":             12.5,
 		"bandwidth_usage":     65.5,
 		"active_connections":  128,
-		"blocked_connections": 12,
+		"blocked_connections": counts.blocked,
 	}
 
 	c.JSON(http.StatusOK, stats)
@@ -1044,21 +1116,25 @@ func (ui *UIServer) getNetworkStats(c *gin.Context) {
 
 // getBlockedIPs 取得被阻斷的 IP 列表
 func (ui *UIServer) getBlockedIPs(c *gin.Context) {
-	blockedIPs := []map[string]interface{}{
-		{
-			"ip":           "192.168.1.100",
-			"reason":       "SQL 注入攻擊嘗試",
-			"blocked_at":   time.Now().Add(-2 * time.Hour).Format(time.RFC3339),
-			"expires_at":   time.Now().Add(22 * time.Hour).Format(time.RFC3339),
-			"threat_count": 25,
-		},
-		{
-			"ip":           "192.168.1.101",
-			"reason":       "SSH 暴力破解攻擊",
-			"blocked_at":   time.Now().Add(-1 * time.Hour).Format(time.RFC3339),
-			"expires_at":   time.Now().Add(23 * time.Hour).Format(time.RFC3339),
-			"threat_count": 18,
-		},
+	if ui.engine == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "分析引擎未連接"})
+		return
+	}
+
+	entries := ui.engine.GetBlacklist()
+	threats := ui.engine.GetThreatInfo()
+	blockedIPs := make([]map[string]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		blockedIP := map[string]interface{}{
+			"ip":           entry.IP,
+			"expires_at":   entry.ExpiresAt.Format(time.RFC3339),
+			"threat_count": 0,
+		}
+		if threat, exists := threats[entry.IP]; exists {
+			blockedIP["reason"] = threat.ThreatType
+			blockedIP["threat_count"] = threat.Count
+		}
+		blockedIPs = append(blockedIPs, blockedIP)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -1066,16 +1142,226 @@ func (ui *UIServer) getBlockedIPs(c *gin.Context) {
 	})
 }
 
+// getRuleSet 取得目前生效的規則集版本與雜湊
+func (ui *UIServer) getRuleSet(c *gin.Context) {
+	if ui.engine == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "分析引擎未連接"})
+		return
+	}
+
+	response := gin.H{
+		"ruleset": ui.engine.GetRuleSetInfo(),
+	}
+	if ui.ruleWatcher != nil {
+		response["watcher"] = ui.ruleWatcher.Status()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// reloadRules 立即重新載入規則目錄
+func (ui *UIServer) reloadRules(c *gin.Context) {
+	if ui.ruleWatcher == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "未設定規則目錄"})
+		return
+	}
+
+	if err := ui.ruleWatcher.Reload(c.Request.Context()); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"ruleset": ui.engine.GetRuleSetInfo(),
+		"watcher": ui.ruleWatcher.Status(),
+	})
+}
+
+// getRuleStats 取得規則命中統計
+func (ui *UIServer) getRuleStats(c *gin.Context) {
+	if ui.engine == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "分析引擎未連接"})
+		return
+	}
+
+	stats := ui.engine.GetRuleStats()
+	total := len(stats)
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 && limit < len(stats) {
+		stats = stats[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rules":     stats,
+		"total":     total,
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// FalsePositiveRequest 標記誤報請求
+type FalsePositiveRequest struct {
+	PacketID        string `json:"packet_id" binding:"required"`
+	SourceCIDR      string `json:"source_cidr"` // 預設為結果的來源 IP
+	AnyPort         bool   `json:"any_port"`
+	Reason          string `json:"reason"`
+	DurationSeconds int    `json:"duration_seconds"` // 0 表示永久
+}
+
+// markFalsePositive 將分析結果標記為誤報並建立抑制條件
+func (ui *UIServer) markFalsePositive(c *gin.Context) {
+	var request FalsePositiveRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if ui.engine == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "分析引擎未連接"})
+		return
+	}
+
+	suppression, err := ui.engine.MarkFalsePositive(request.PacketID, FalsePositiveOptions{
+		SourceCIDR: request.SourceCIDR,
+		AnyPort:    request.AnyPort,
+		Reason:     request.Reason,
+		Duration:   time.Duration(request.DurationSeconds) * time.Second,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"suppression": suppression,
+	})
+}
+
+// getSuppressions 取得誤報抑制條件
+func (ui *UIServer) getSuppressions(c *gin.Context) {
+	if ui.engine == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "分析引擎未連接"})
+		return
+	}
+
+	suppressions := ui.engine.GetSuppressions()
+	c.JSON(http.StatusOK, gin.H{
+		"suppressions": suppressions,
+		"total":        len(suppressions),
+	})
+}
+
+// addSuppression 直接新增誤報抑制條件
+func (ui *UIServer) addSuppression(c *gin.Context) {
+	var request Suppression
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if ui.engine == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "分析引擎未連接"})
+		return
+	}
+
+	request.ID, request.Hits = "", 0
+	suppression, err := ui.engine.AddSuppression(request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"suppression": suppression,
+	})
+}
+
+// removeSuppression 移除誤報抑制條件
+func (ui *UIServer) removeSuppression(c *gin.Context) {
+	if ui.engine == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "分析引擎未連接"})
+		return
+	}
+
+	if err := ui.engine.RemoveSuppression(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"message":   "抑制條件已移除",
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// BlockIPRequest 阻斷 IP 請求
+type BlockIPRequest struct {
+	IP              string `json:"ip" binding:"required"` // 單一 IP、CIDR 或 IP 範圍
+	DurationSeconds int    `json:"duration_seconds"`      // 預設 1 小時
+}
+
+// blockIP 阻斷 IP、CIDR 或 IP 範圍
+func (ui *UIServer) blockIP(c *gin.Context) {
+	var request BlockIPRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if ui.engine == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "分析引擎未連接"})
+		return
+	}
+
+	duration := time.Hour
+	if request.DurationSeconds > 0 {
+		duration = time.Duration(request.DurationSeconds) * time.Second
+	}
+	if err := ui.engine.AddToBlacklist(request.IP, duration); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ui.publish(TopicNetwork, "ip_blocked", map[string]interface{}{
+		"ip":        request.IP,
+		"timestamp": time.Now().Unix(),
+	}, messageAttributes{SourceIP: request.IP})
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"message":    "IP 已阻斷",
+		"ip":         request.IP,
+		"expires_at": time.Now().Add(duration).Format(time.RFC3339),
+		"timestamp":  time.Now().Unix(),
+	})
+}
+
 // unblockIP 解除 IP 阻斷
 func (ui *UIServer) unblockIP(c *gin.Context) {
-	ip := c.Param("ip")
-	
+	ip := strings.TrimPrefix(c.Param("ip"), "/")
+	if ip == "" {
+		ip = c.Query("ip")
+	}
+	if ip == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少 IP"})
+		return
+	}
+	if _, err := ParseIPPrefixes(ip); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if ui.engine != nil {
+		if err := ui.engine.RemoveFromBlacklist(ip); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	ui.logger.Infof("解除 IP 阻斷: %s", ip)
 	
-	ui.broadcastWebSocketMessage("ip_unblocked", map[string]interface{}{
+	ui.publish(TopicNetwork, "ip_unblocked", map[string]interface{}{
 		"ip":        ip,
 		"timestamp": time.Now().Unix(),
-	})
+	}, messageAttributes{SourceIP: ip})
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":    "success",
@@ -1206,10 +1492,10 @@ func (ui *UIServer) restartDevice(c *gin.Context) {
 	
 	ui.logger.Infof("重啟設備: %s", deviceID)
 	
-	ui.broadcastWebSocketMessage("device_restarting", map[string]interface{}{
+	ui.publish(TopicDevices, "device_restarting", map[string]interface{}{
 		"device_id": deviceID,
 		"timestamp": time.Now().Unix(),
-	})
+	}, messageAttributes{})
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":    "success",
@@ -1231,11 +1517,11 @@ func (ui *UIServer) updateDeviceConfig(c *gin.Context) {
 
 	ui.logger.Infof("更新設備配置: %s, 配置: %v", deviceID, config)
 	
-	ui.broadcastWebSocketMessage("device_config_updated", map[string]interface{}{
+	ui.publish(TopicDevices, "device_config_updated", map[string]interface{}{
 		"device_id": deviceID,
 		"config":    config,
 		"timestamp": time.Now().Unix(),
-	})
+	}, messageAttributes{})
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":    "success",
@@ -1248,134 +1534,75 @@ func (ui *UIServer) updateDeviceConfig(c *gin.Context) {
 
 // generateSecurityReport 生成安全報表
 func (ui *UIServer) generateSecurityReport(c *gin.Context) {
-	timeRange := c.DefaultQuery("time_range", "24h")
-	format := c.DefaultQuery("format", "json")
-
-	report := map[string]interface{}{
-		"report_type":  "security",
-		"time_range":   timeRange,
-		"generated_at": time.Now().Format(time.RFC3339),
-		"summary": map[string]interface{}{
-			"total_threats":    156,
-			"blocked_threats":  142,
-			"active_threats":   8,
-			"resolved_threats": 148,
-		},
-		"threat_by_type": []map[string]interface{}{
-			{"type": "SQL Injection", "count": 42, "percentage": 26.9},
-			{"type": "Brute Force", "count": 35, "percentage": 22.4},
-			{"type": "Port Scanning", "count": 28, "percentage": 17.9},
-			{"type": "DDoS", "count": 22, "percentage": 14.1},
-			{"type": "XSS", "count": 18, "percentage": 11.5},
-		},
-		"top_source_ips": []map[string]interface{}{
-			{"ip": "192.168.1.100", "threats": 25, "severity": "high"},
-			{"ip": "192.168.1.101", "threats": 18, "severity": "medium"},
-		},
-		"timeline": []map[string]interface{}{
-			{"hour": "00:00", "threats": 5},
-			{"hour": "01:00", "threats": 3},
-			{"hour": "02:00", "threats": 8},
-		},
-	}
+	ui.respondQueryReport(c, "security")
+}
 
-	if format == "csv" {
-		c.Header("Content-Disposition", "attachment; filename=security_report.csv")
-		c.Header("Content-Type", "text/csv")
-		// 這裡應該生成實際的 CSV
-		c.String(http.StatusOK, "報表數據...")
-	} else {
-		c.JSON(http.StatusOK, report)
+// respondQueryReport 依查詢參數 (time_range、since、until、severity、source_ip 與 format) 產生報表
+func (ui *UIServer) respondQueryReport(c *gin.Context, reportType string) {
+	query, err := parseEventQuery(c, "24h")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	query.Limit, query.Offset = 0, 0
+
+	ui.respondReport(c, reportType, query, c.DefaultQuery("time_range", "24h"), c.DefaultQuery("format", "json"))
 }
 
 // generateNetworkReport 生成網路報表
 func (ui *UIServer) generateNetworkReport(c *gin.Context) {
-	timeRange := c.DefaultQuery("time_range", "24h")
-	format := c.DefaultQuery("format", "json")
-
-	report := map[string]interface{}{
-		"report_type":  "network",
-		"time_range":   timeRange,
-		"generated_at": time.Now().Format(time.RFC3339),
-		"summary": map[string]interface{}{
-			"total_traffic":       "10.24 GB",
-			"inbound_traffic":     "6.14 GB",
-			"outbound_traffic":    "4.10 GB",
-			"active_connections":  128,
-			"blocked_connections": 12,
-			"avg_latency":         12.5,
-			"packet_loss":         0.05,
-		},
-		"traffic_by_hour": []map[string]interface{}{
-			{"hour": "00:00", "inbound": 250, "outbound": 180},
-			{"hour": "01:00", "inbound": 220, "outbound": 150},
-		},
-		"top_destinations": []map[string]interface{}{
-			{"ip": "8.8.8.8", "traffic": "1.2 GB", "protocol": "DNS"},
-			{"ip": "1.1.1.1", "traffic": "0.8 GB", "protocol": "HTTPS"},
-		},
-		"protocols": []map[string]interface{}{
-			{"protocol": "HTTPS", "traffic": "5.2 GB", "percentage": 50.8},
-			{"protocol": "HTTP", "traffic": "2.5 GB", "percentage": 24.4},
-			{"protocol": "DNS", "traffic": "1.5 GB", "percentage": 14.6},
-		},
+	ui.respondQueryReport(c, "network")
+}
+
+// respondReport 建立報表並以 JSON、CSV、HTML 或 PDF 回應
+func (ui *UIServer) respondReport(c *gin.Context, reportType string, query EventQuery, timeRange, format string) {
+	if format == "" {
+		format = "json"
+	}
+	output, ok := ReportFormats[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("不支援的報表格式: %s", format)})
+		return
+	}
+	if reportType != "system" && ui.eventStore(c) == nil {
+		return
 	}
 
-	if format == "csv" {
-		c.Header("Content-Disposition", "attachment; filename=network_report.csv")
-		c.Header("Content-Type", "text/csv")
-		c.String(http.StatusOK, "報表數據...")
-	} else {
+	report, err := BuildReport(c.Request.Context(), ui.engine, reportType, query, timeRange, ui.startTime)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if format == "json" {
 		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, report, format); err != nil {
+		ui.logger.Errorf("輸出%s報表失敗: %v", reportType, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if format != "html" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s_report.%s", reportType, output.Extension))
 	}
+	c.Data(http.StatusOK, output.ContentType, buf.Bytes())
 }
 
 // generateSystemReport 生成系統報表
 func (ui *UIServer) generateSystemReport(c *gin.Context) {
-	timeRange := c.DefaultQuery("time_range", "24h")
-	format := c.DefaultQuery("format", "json")
-
-	report := map[string]interface{}{
-		"report_type":  "system",
-		"time_range":   timeRange,
-		"generated_at": time.Now().Format(time.RFC3339),
-		"summary": map[string]interface{}{
-			"uptime":        "72h 45m",
-			"cpu_usage_avg": 15.5,
-			"mem_usage_avg": 42.3,
-			"disk_usage":    28.7,
-		},
-		"resource_usage": []map[string]interface{}{
-			{"timestamp": "2025-01-14 10:00", "cpu": 12.5, "memory": 40.2, "disk": 28.5},
-			{"timestamp": "2025-01-14 11:00", "cpu": 15.8, "memory": 42.5, "disk": 28.7},
-		},
-		"services_status": []map[string]interface{}{
-			{"service": "Axiom UI", "status": "healthy", "uptime": "72h 45m"},
-			{"service": "Prometheus", "status": "healthy", "uptime": "72h 45m"},
-			{"service": "Grafana", "status": "healthy", "uptime": "72h 45m"},
-		},
-		"events": []map[string]interface{}{
-			{"time": "2025-01-14 10:30", "type": "system", "message": "服務重啟"},
-			{"time": "2025-01-14 11:15", "type": "info", "message": "配置更新"},
-		},
-	}
-
-	if format == "csv" {
-		c.Header("Content-Disposition", "attachment; filename=system_report.csv")
-		c.Header("Content-Type", "text/csv")
-		c.String(http.StatusOK, "報表數據...")
-	} else {
-		c.JSON(http.StatusOK, report)
-	}
+	ui.respondQueryReport(c, "system")
 }
 
-// generateCustomReport 生成自訂報表
+// generateCustomReport 生成自訂報表：依 report_type 產生安全、網路或系統報表，
+// filters 支援 severity、source_ip 與 type，since/until 指定時優先於 time_range
 func (ui *UIServer) generateCustomReport(c *gin.Context) {
 	var request struct {
-		ReportType string                 `json:"report_type"`
+		ReportType string                 `json:"report_type" binding:"required"`
 		TimeRange  string                 `json:"time_range"`
-		Metrics    []string               `json:"metrics"`
+		Since      *time.Time             `json:"since"`
+		Until      *time.Time             `json:"until"`
 		Filters    map[string]interface{} `json:"filters"`
 		Format     string                 `json:"format"`
 	}
@@ -1384,27 +1611,95 @@ func (ui *UIServer) generateCustomReport(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	switch request.ReportType {
+	case "security", "network", "system":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("不支援的報表類型: %s", request.ReportType)})
+		return
+	}
+	if request.TimeRange == "" {
+		request.TimeRange = "24h"
+	}
+
+	query := EventQuery{}
+	for key, target := range map[string]*string{"severity": &query.Severity, "source_ip": &query.SourceIP, "type": &query.Type} {
+		value, ok := request.Filters[key]
+		if !ok {
+			continue
+		}
+		if *target, ok = value.(string); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("過濾條件 %s 必須為字串", key)})
+			return
+		}
+	}
+	if query.SourceIP != "" {
+		if _, err := netip.ParseAddr(query.SourceIP); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("無效的來源 IP: %s", query.SourceIP)})
+			return
+		}
+	}
+	if query.Severity == "all" {
+		query.Severity = ""
+	}
+
+	switch {
+	case request.Since != nil:
+		query.Since = *request.Since
+	case request.TimeRange != "all":
+		duration, err := parseTimeRange(request.TimeRange)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query.Since = time.Now().Add(-duration)
+	}
+	if request.Until != nil {
+		query.Until = *request.Until
+	}
+
+	ui.logger.Infof("生成自訂報表: %s (%s)", request.ReportType, request.TimeRange)
+	ui.respondReport(c, request.ReportType, query, request.TimeRange, request.Format)
+}
+
+// getReportSchedules 取得排程報表設定與執行狀態
+func (ui *UIServer) getReportSchedules(c *gin.Context) {
+	if ui.reports == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "未設定排程報表"})
+		return
+	}
 
-	ui.logger.Infof("生成自訂報表: %v", request)
+	c.JSON(http.StatusOK, gin.H{
+		"schedules": ui.reports.Schedules(),
+		"status":    ui.reports.Status(),
+	})
+}
 
-	report := map[string]interface{}{
-		"report_type":  request.ReportType,
-		"time_range":   request.TimeRange,
-		"generated_at": time.Now().Format(time.RFC3339),
-		"metrics":      request.Metrics,
-		"filters":      request.Filters,
-		"data": []map[string]interface{}{
-			{"timestamp": "2025-01-14 10:00", "value": 123},
-			{"timestamp": "2025-01-14 11:00", "value": 156},
-		},
+// runReportSchedule 立即執行排程報表
+func (ui *UIServer) runReportSchedule(c *gin.Context) {
+	if ui.reports == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "未設定排程報表"})
+		return
 	}
 
-	if request.Format == "csv" {
-		c.Header("Content-Disposition", "attachment; filename=custom_report.csv")
-		c.Header("Content-Type", "text/csv")
-		c.String(http.StatusOK, "報表數據...")
-	} else {
-		c.JSON(http.StatusOK, report)
+	name := c.Param("name")
+	found := false
+	for _, schedule := range ui.reports.Schedules() {
+		found = found || schedule.Name == name
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("排程報表不存在: %s", name)})
+		return
+	}
+
+	if err := ui.reports.Run(c.Request.Context(), name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, status := range ui.reports.Status() {
+		if status.Name == name {
+			c.JSON(http.StatusOK, status)
+			return
+		}
 	}
 }
 
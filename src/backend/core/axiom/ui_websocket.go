@@ -0,0 +1,568 @@
+package axiom
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket 訂閱主題
+const (
+	TopicAlerts    = "alerts"    // 告警 (alert/block 動作的分析結果與告警解決)
+	TopicEvents    = "events"    // 安全事件 (所有被阻擋或命中規則的分析結果)
+	TopicMetrics   = "metrics"   // 指標
+	TopicNetwork   = "network"   // 網路狀態
+	TopicDevices   = "devices"   // 裝置狀態
+	TopicDashboard = "dashboard" // 儀表板定期更新
+)
+
+// websocketTopics 可訂閱的主題，依序作為未指定主題時的預設訂閱
+var websocketTopics = []string{TopicAlerts, TopicEvents, TopicMetrics, TopicNetwork, TopicDevices, TopicDashboard}
+
+// 慢速客戶端處理策略
+const (
+	SlowConsumerDrop       = "drop"       // 佇列已滿時捨棄新訊息
+	SlowConsumerDisconnect = "disconnect" // 佇列已滿時中斷連線
+)
+
+// severityRanks 嚴重程度排序，用於 min_severity 過濾
+var severityRanks = map[string]int{"low": 1, "medium": 2, "high": 3, "critical": 4}
+
+// WebSocketConfig WebSocket 設定
+type WebSocketConfig struct {
+	AllowedOrigins     []string      `json:"allowed_origins"` // 允許的 Origin，支援 "*" 與 "https://*.example.com"；空白時只允許與 Host 相同的來源
+	QueueSize          int           `json:"queue_size"`      // 每個客戶端的發送佇列長度
+	FeedSize           int           `json:"feed_size"`       // SSE 與長輪詢續傳用的環狀緩衝區長度
+	SlowConsumerPolicy string        `json:"slow_consumer_policy"`
+	WriteTimeout       time.Duration `json:"write_timeout"`
+	PingInterval       time.Duration `json:"ping_interval"`
+}
+
+// DefaultWebSocketConfig 預設 WebSocket 設定
+func DefaultWebSocketConfig() WebSocketConfig {
+	return WebSocketConfig{
+		QueueSize:          256,
+		FeedSize:           defaultFeedSize,
+		SlowConsumerPolicy: SlowConsumerDrop,
+		WriteTimeout:       10 * time.Second,
+		PingInterval:       30 * time.Second,
+	}
+}
+
+// validate 檢查設定
+func (cfg WebSocketConfig) validate() error {
+	if cfg.QueueSize <= 0 || cfg.FeedSize <= 0 {
+		return fmt.Errorf("發送佇列與環狀緩衝區長度必須大於 0")
+	}
+	if cfg.SlowConsumerPolicy != SlowConsumerDrop && cfg.SlowConsumerPolicy != SlowConsumerDisconnect {
+		return fmt.Errorf("不支援的慢速客戶端策略: %s", cfg.SlowConsumerPolicy)
+	}
+	if cfg.WriteTimeout <= 0 || cfg.PingInterval <= 0 {
+		return fmt.Errorf("寫入逾時與 ping 間隔必須大於 0")
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("無效的 Origin: %s", origin)
+		}
+	}
+	return nil
+}
+
+// allowOrigin 檢查瀏覽器送出的 Origin；沒有 Origin 標頭的非瀏覽器客戶端一律允許
+func (cfg WebSocketConfig) allowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if len(cfg.AllowedOrigins) == 0 {
+		return strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		pattern, err := url.Parse(allowed)
+		if err != nil || !strings.HasPrefix(pattern.Host, "*.") || !strings.EqualFold(pattern.Scheme, u.Scheme) {
+			continue
+		}
+		if strings.HasSuffix(strings.ToLower(u.Host), strings.ToLower(pattern.Host[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription 客戶端訂閱條件，同一主題只保留最後一次的訂閱
+type Subscription struct {
+	Topic       string `json:"topic"`
+	MinSeverity string `json:"min_severity,omitempty"` // 只接收嚴重程度不低於此值的訊息
+	AgentID     string `json:"agent_id,omitempty"`
+	SourceIP    string `json:"source_ip,omitempty"`
+	RuleID      string `json:"rule_id,omitempty"`
+}
+
+// validate 檢查訂閱條件
+func (s Subscription) validate() error {
+	known := false
+	for _, topic := range websocketTopics {
+		known = known || topic == s.Topic
+	}
+	if !known {
+		return fmt.Errorf("未知的主題: %s", s.Topic)
+	}
+	if s.MinSeverity != "" && severityRanks[s.MinSeverity] == 0 {
+		return fmt.Errorf("無效的嚴重程度: %s", s.MinSeverity)
+	}
+	return nil
+}
+
+// matches 檢查訊息是否符合訂閱條件；設定了過濾條件時，缺少對應屬性的訊息不符合
+func (s Subscription) matches(pub *livePublication) bool {
+	if s.Topic != pub.topic {
+		return false
+	}
+	if s.MinSeverity != "" && severityRanks[pub.attrs.Severity] < severityRanks[s.MinSeverity] {
+		return false
+	}
+	if s.AgentID != "" && s.AgentID != pub.attrs.AgentID {
+		return false
+	}
+	if s.SourceIP != "" && s.SourceIP != pub.attrs.SourceIP {
+		return false
+	}
+	return s.RuleID == "" || s.RuleID == pub.attrs.RuleID
+}
+
+// messageAttributes 訊息的過濾屬性
+type messageAttributes struct {
+	Severity string
+	AgentID  string
+	SourceIP string
+	RuleID   string
+}
+
+// livePublication 發佈到即時訊息的一筆訊息
+type livePublication struct {
+	id      uint64 // 環狀緩衝區編號，不寫入緩衝區的主題為 0
+	topic   string
+	message WebSocketMessage
+	attrs   messageAttributes
+}
+
+// wsClient WebSocket 客戶端，所有寫入都由 writeLoop 負責
+type wsClient struct {
+	id          string
+	conn        *websocket.Conn
+	remoteAddr  string
+	connectedAt time.Time
+	send        chan WebSocketMessage
+	done        chan struct{}
+	closeOnce   sync.Once
+	dropped     atomic.Uint64
+
+	mutex         sync.RWMutex
+	subscriptions map[string]Subscription
+}
+
+// newWSClient 建立客戶端
+func newWSClient(id string, conn *websocket.Conn, queueSize int) *wsClient {
+	client := &wsClient{
+		id:            id,
+		conn:          conn,
+		connectedAt:   time.Now(),
+		send:          make(chan WebSocketMessage, queueSize),
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]Subscription),
+	}
+	if conn != nil {
+		client.remoteAddr = conn.RemoteAddr().String()
+	}
+	return client
+}
+
+// subscribe 新增或取代主題的訂閱
+func (c *wsClient) subscribe(sub Subscription) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.subscriptions[sub.Topic] = sub
+}
+
+// unsubscribe 取消主題的訂閱
+func (c *wsClient) unsubscribe(topic string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.subscriptions, topic)
+}
+
+// listSubscriptions 依主題排序的訂閱列表
+func (c *wsClient) listSubscriptions() []Subscription {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	subs := make([]Subscription, 0, len(c.subscriptions))
+	for _, sub := range c.subscriptions {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Topic < subs[j].Topic })
+	return subs
+}
+
+// wants 檢查客戶端是否訂閱了此訊息
+func (c *wsClient) wants(pub *livePublication) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	sub, ok := c.subscriptions[pub.topic]
+	return ok && sub.matches(pub)
+}
+
+// enqueue 以非阻塞方式放入發送佇列，佇列已滿時依策略捨棄訊息或中斷連線
+func (c *wsClient) enqueue(message WebSocketMessage, policy string) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return true
+	default:
+	}
+
+	c.dropped.Add(1)
+	if policy == SlowConsumerDisconnect {
+		c.close()
+	}
+	return false
+}
+
+// close 關閉客戶端，可重複呼叫
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+}
+
+// WebSocketClientInfo WebSocket 客戶端狀態
+type WebSocketClientInfo struct {
+	ID            string         `json:"id"`
+	RemoteAddr    string         `json:"remote_addr"`
+	ConnectedAt   time.Time      `json:"connected_at"`
+	Subscriptions []Subscription `json:"subscriptions"`
+	Queued        int            `json:"queued"`
+	Dropped       uint64         `json:"dropped"`
+}
+
+// wsHub 管理 WebSocket 客戶端與訊息分派
+type wsHub struct {
+	mutex   sync.RWMutex
+	clients map[string]*wsClient
+}
+
+// newWSHub 建立客戶端集合
+func newWSHub() *wsHub {
+	return &wsHub{clients: make(map[string]*wsClient)}
+}
+
+// add 加入客戶端，相同 ID 的舊連線會被關閉
+func (h *wsHub) add(client *wsClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if previous, exists := h.clients[client.id]; exists {
+		previous.close()
+	}
+	h.clients[client.id] = client
+}
+
+// remove 移除客戶端 (只移除同一個連線，避免誤刪重新連線的客戶端)
+func (h *wsHub) remove(client *wsClient) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.clients[client.id] == client {
+		delete(h.clients, client.id)
+	}
+}
+
+// get 取得客戶端
+func (h *wsHub) get(id string) *wsClient {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return h.clients[id]
+}
+
+// publish 將訊息放入所有訂閱者的發送佇列
+func (h *wsHub) publish(pub *livePublication, policy string) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for _, client := range h.clients {
+		if client.wants(pub) {
+			client.enqueue(pub.message, policy)
+		}
+	}
+}
+
+// info 取得所有客戶端狀態
+func (h *wsHub) info() []WebSocketClientInfo {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	clients := make([]WebSocketClientInfo, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, WebSocketClientInfo{
+			ID:            client.id,
+			RemoteAddr:    client.remoteAddr,
+			ConnectedAt:   client.connectedAt,
+			Subscriptions: client.listSubscriptions(),
+			Queued:        len(client.send),
+			Dropped:       client.dropped.Load(),
+		})
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// SetWebSocketConfig 設定 WebSocket 來源檢查、發送佇列與慢速客戶端策略，需在啟動前呼叫
+func (ui *UIServer) SetWebSocketConfig(config WebSocketConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if config.FeedSize != ui.wsConfig.FeedSize {
+		ui.feed = newLiveFeed(config.FeedSize)
+	}
+	ui.wsConfig = config
+	return nil
+}
+
+// checkOrigin 依設定檢查 WebSocket 連線來源
+func (ui *UIServer) checkOrigin(r *http.Request) bool {
+	if ui.wsConfig.allowOrigin(r) {
+		return true
+	}
+	ui.logger.Warnf("拒絕 WebSocket 連線來源: %s", r.Header.Get("Origin"))
+	return false
+}
+
+// handleWebSocket 處理WebSocket連接；topics 查詢參數 (以逗號分隔) 指定初始訂閱，未指定時訂閱所有主題
+func (ui *UIServer) handleWebSocket(c *gin.Context) {
+	var initial []Subscription
+	if topics := c.Query("topics"); topics != "" {
+		for _, topic := range strings.Split(topics, ",") {
+			sub := Subscription{Topic: strings.TrimSpace(topic)}
+			if err := sub.validate(); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			initial = append(initial, sub)
+		}
+	} else {
+		for _, topic := range websocketTopics {
+			initial = append(initial, Subscription{Topic: topic})
+		}
+	}
+
+	conn, err := ui.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		ui.logger.Errorf("WebSocket升級失敗: %v", err)
+		return
+	}
+
+	// 客戶端 ID 一律由伺服器產生，避免客戶端指定他人的 ID 把對方的連線踢掉
+	clientID := newWSClientID()
+	client := newWSClient(clientID, conn, ui.wsConfig.QueueSize)
+	for _, sub := range initial {
+		client.subscribe(sub)
+	}
+	ui.hub.add(client)
+	defer func() {
+		ui.hub.remove(client)
+		client.close()
+		ui.logger.Infof("WebSocket客戶端斷線: %s", clientID)
+	}()
+
+	ui.logger.Infof("WebSocket客戶端連接: %s", clientID)
+	go ui.writeLoop(client)
+
+	// 發送初始數據
+	client.enqueue(WebSocketMessage{Type: "connected", Data: map[string]interface{}{
+		"client_id":     clientID,
+		"timestamp":     time.Now().Unix(),
+		"server_time":   time.Now().Format("2006-01-02 15:04:05"),
+		"subscriptions": client.listSubscriptions(),
+	}}, ui.wsConfig.SlowConsumerPolicy)
+
+	// 監聽客戶端訊息；超過兩個 ping 間隔沒有任何訊息或 pong 時視為斷線
+	pongWait := 2 * ui.wsConfig.PingInterval
+	conn.SetReadLimit(64 * 1024)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		var message WebSocketMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			ui.logger.Debugf("WebSocket讀取錯誤: %v", err)
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		ui.handleWebSocketMessage(client, message)
+	}
+}
+
+// writeLoop 依序寫出發送佇列並定期送出 ping，寫入失敗或逾時即中斷連線
+func (ui *UIServer) writeLoop(client *wsClient) {
+	ticker := time.NewTicker(ui.wsConfig.PingInterval)
+	defer ticker.Stop()
+	defer client.close()
+
+	for {
+		select {
+		case <-client.done:
+			return
+		case message := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(ui.wsConfig.WriteTimeout))
+			if err := client.conn.WriteJSON(message); err != nil {
+				ui.logger.Errorf("發送WebSocket訊息失敗 (客戶端: %s): %v", client.id, err)
+				return
+			}
+		case <-ticker.C:
+			deadline := time.Now().Add(ui.wsConfig.WriteTimeout)
+			if err := client.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				ui.logger.Debugf("發送WebSocket ping失敗 (客戶端: %s): %v", client.id, err)
+				return
+			}
+		}
+	}
+}
+
+// newWSClientID 產生隨機的客戶端 ID
+func newWSClientID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("client_%d", time.Now().UnixNano())
+	}
+	return "client_" + hex.EncodeToString(buf)
+}
+
+// handleWebSocketMessage 處理WebSocket訊息
+func (ui *UIServer) handleWebSocketMessage(client *wsClient, message WebSocketMessage) {
+	ui.logger.Debugf("收到WebSocket訊息: %s - %s", client.id, message.Type)
+
+	reply := func(msgType string, data interface{}) {
+		client.enqueue(WebSocketMessage{Type: msgType, Data: data}, ui.wsConfig.SlowConsumerPolicy)
+	}
+
+	switch message.Type {
+	case "ping":
+		reply("pong", map[string]interface{}{
+			"timestamp": time.Now().Unix(),
+		})
+	case "subscribe":
+		var sub Subscription
+		if err := decodeMessageData(message.Data, &sub); err != nil {
+			reply("error", map[string]interface{}{"message": err.Error()})
+			return
+		}
+		if err := sub.validate(); err != nil {
+			reply("error", map[string]interface{}{"message": err.Error()})
+			return
+		}
+		client.subscribe(sub)
+		ui.logger.Debugf("客戶端 %s 訂閱: %+v", client.id, sub)
+		reply("subscribed", map[string]interface{}{"subscriptions": client.listSubscriptions()})
+	case "unsubscribe":
+		var sub Subscription
+		if err := decodeMessageData(message.Data, &sub); err != nil || sub.Topic == "" {
+			reply("error", map[string]interface{}{"message": "取消訂閱需要指定 topic"})
+			return
+		}
+		client.unsubscribe(sub.Topic)
+		reply("unsubscribed", map[string]interface{}{"subscriptions": client.listSubscriptions()})
+	case "get_status":
+		reply("status_update", ui.getSystemStatusData())
+	default:
+		reply("error", map[string]interface{}{"message": fmt.Sprintf("未知的訊息類型: %s", message.Type)})
+	}
+}
+
+// decodeMessageData 將訊息的 data 欄位轉換為指定結構
+func decodeMessageData(data interface{}, target interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("無效的訊息內容: %v", err)
+	}
+	if err := json.Unmarshal(raw, target); err != nil {
+		return fmt.Errorf("無效的訊息內容: %v", err)
+	}
+	return nil
+}
+
+// publish 發佈即時訊息給訂閱此主題的 WebSocket 客戶端；可續傳的主題同時寫入環狀緩衝區供 SSE 與長輪詢讀取
+func (ui *UIServer) publish(topic, msgType string, data interface{}, attrs messageAttributes) {
+	pub := &livePublication{
+		topic:   topic,
+		message: WebSocketMessage{Type: msgType, Topic: topic, Data: data},
+		attrs:   attrs,
+	}
+	for _, feedTopic := range feedTopics {
+		if feedTopic == topic {
+			ui.feed.append(pub)
+			break
+		}
+	}
+	ui.hub.publish(pub, ui.wsConfig.SlowConsumerPolicy)
+}
+
+// publishResult 推送引擎的分析結果：所有結果發佈到 events，alert/block 動作同時發佈到 alerts
+func (ui *UIServer) publishResult(result *AnalysisResult) {
+	attrs := messageAttributes{
+		Severity: result.ThreatLevel,
+		AgentID:  result.AgentID,
+		SourceIP: result.SourceIP,
+		RuleID:   result.RuleID,
+	}
+	ui.publish(TopicEvents, "security_event", result, attrs)
+	if result.Action == "alert" || result.Action == "block" {
+		ui.publish(TopicAlerts, "alert", result, attrs)
+	}
+}
+
+// getWebSocketClients 取得 WebSocket 客戶端的訂閱與佇列狀態
+func (ui *UIServer) getWebSocketClients(c *gin.Context) {
+	clients := ui.hub.info()
+	c.JSON(http.StatusOK, gin.H{
+		"clients":              clients,
+		"total":                len(clients),
+		"queue_size":           ui.wsConfig.QueueSize,
+		"slow_consumer_policy": ui.wsConfig.SlowConsumerPolicy,
+	})
+}
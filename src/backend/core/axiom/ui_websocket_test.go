@@ -0,0 +1,183 @@
+package axiom
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialWebSocket 連線到測試伺服器的 /ws 並讀取 connected 訊息
+func dialWebSocket(t *testing.T, server *httptest.Server, query string) (*websocket.Conn, string) {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	message := readWebSocket(t, conn)
+	require.Equal(t, "connected", message.Type)
+	return conn, message.Data.(map[string]interface{})["client_id"].(string)
+}
+
+// readWebSocket 讀取下一則訊息
+func readWebSocket(t *testing.T, conn *websocket.Conn) WebSocketMessage {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	var message WebSocketMessage
+	require.NoError(t, conn.ReadJSON(&message))
+	return message
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	engine, _ := newBehaviorEngine(t,
+		&SecurityRule{ID: "sqli", Name: "SQL注入", Type: "pattern", Pattern: "union select", Action: "block", Severity: "critical", Enabled: true},
+		&SecurityRule{ID: "admin", Name: "管理路徑", Type: "pattern", Pattern: "/admin", Action: "alert", Severity: "medium", Enabled: true},
+	)
+	ui := NewUIServer(engine.logger, engine.metrics)
+	ui.SetAnalysisEngine(engine)
+	server := httptest.NewServer(ui.setupRouter())
+	defer server.Close()
+
+	conn, _ := dialWebSocket(t, server, "?topics=metrics")
+	require.NoError(t, conn.WriteJSON(WebSocketMessage{Type: "subscribe", Data: Subscription{Topic: TopicAlerts, MinSeverity: "high"}}))
+	reply := readWebSocket(t, conn)
+	require.Equal(t, "subscribed", reply.Type)
+	assert.Len(t, reply.Data.(map[string]interface{})["subscriptions"], 2)
+
+	require.NoError(t, conn.WriteJSON(WebSocketMessage{Type: "subscribe", Data: Subscription{Topic: "unknown"}}))
+	assert.Equal(t, "error", readWebSocket(t, conn).Type)
+
+	agentConn, agentID := dialWebSocket(t, server, "?topics=events")
+	require.NoError(t, agentConn.WriteJSON(WebSocketMessage{Type: "subscribe", Data: Subscription{Topic: TopicEvents, AgentID: "agent-2"}}))
+	require.Equal(t, "subscribed", readWebSocket(t, agentConn).Type)
+
+	medium := tuningPacket("203.0.113.1", 80, "GET /admin")
+	medium.AgentID = "agent-1"
+	analyze(t, engine, medium)
+	critical := tuningPacket("203.0.113.2", 80, "id=1 union select")
+	critical.AgentID = "agent-2"
+	analyze(t, engine, critical)
+
+	// medium 告警低於 min_severity，第一則收到的就是 critical
+	message := readWebSocket(t, conn)
+	assert.Equal(t, "alert", message.Type)
+	assert.Equal(t, TopicAlerts, message.Topic)
+	assert.Equal(t, "sqli", message.Data.(map[string]interface{})["rule_id"])
+
+	message = readWebSocket(t, agentConn)
+	assert.Equal(t, "security_event", message.Type)
+	assert.Equal(t, "agent-2", message.Data.(map[string]interface{})["agent_id"])
+
+	require.NoError(t, conn.WriteJSON(WebSocketMessage{Type: "unsubscribe", Data: map[string]string{"topic": TopicAlerts}}))
+	assert.Equal(t, "unsubscribed", readWebSocket(t, conn).Type)
+	ui.publish(TopicAlerts, "alert", nil, messageAttributes{Severity: "critical"})
+	ui.publish(TopicMetrics, "metrics_update", ui.getMetricsData(), messageAttributes{})
+	assert.Equal(t, "metrics_update", readWebSocket(t, conn).Type)
+
+	info := ui.hub.info()
+	require.Len(t, info, 2)
+	for _, client := range info {
+		if client.ID == agentID {
+			assert.Equal(t, []Subscription{{Topic: TopicEvents, AgentID: "agent-2"}}, client.Subscriptions)
+		}
+	}
+}
+
+func TestWebSocketClientIDAssignedByServer(t *testing.T) {
+	engine, _ := newBehaviorEngine(t)
+	ui := NewUIServer(engine.logger, engine.metrics)
+	server := httptest.NewServer(ui.setupRouter())
+	defer server.Close()
+
+	// 客戶端指定的 client_id 不會沿用，也不會踢掉同名的既有連線
+	first, firstID := dialWebSocket(t, server, "?client_id=viewer&topics=metrics")
+	second, secondID := dialWebSocket(t, server, "?client_id=viewer&topics=metrics")
+	assert.NotEqual(t, "viewer", firstID)
+	assert.NotEqual(t, firstID, secondID)
+	assert.Len(t, ui.hub.info(), 2)
+
+	ui.publish(TopicMetrics, "metrics_update", nil, messageAttributes{})
+	assert.Equal(t, "metrics_update", readWebSocket(t, first).Type)
+	assert.Equal(t, "metrics_update", readWebSocket(t, second).Type)
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	engine, _ := newBehaviorEngine(t)
+	ui := NewUIServer(engine.logger, engine.metrics)
+	server := httptest.NewServer(ui.setupRouter())
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	dial := func(origin string) int {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			conn.Close()
+		}
+		require.NotNil(t, resp)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusSwitchingProtocols, dial(""), "非瀏覽器客戶端")
+	assert.Equal(t, http.StatusSwitchingProtocols, dial(server.URL), "同源")
+	assert.Equal(t, http.StatusForbidden, dial("https://evil.example"))
+
+	config := DefaultWebSocketConfig()
+	config.AllowedOrigins = []string{"https://*.soc.example", "https://console.example"}
+	require.NoError(t, ui.SetWebSocketConfig(config))
+	assert.Equal(t, http.StatusSwitchingProtocols, dial("https://console.example"))
+	assert.Equal(t, http.StatusSwitchingProtocols, dial("https://screen1.soc.example"))
+	assert.Equal(t, http.StatusForbidden, dial("http://screen1.soc.example"), "scheme 不符")
+	assert.Equal(t, http.StatusForbidden, dial(server.URL), "設定允許清單後不再預設允許同源")
+
+	config.AllowedOrigins = []string{"console.example"}
+	assert.Error(t, ui.SetWebSocketConfig(config))
+	config.AllowedOrigins = nil
+	config.SlowConsumerPolicy = "block"
+	assert.Error(t, ui.SetWebSocketConfig(config))
+}
+
+func TestWebSocketSlowConsumer(t *testing.T) {
+	hub := newWSHub()
+	dropper := newWSClient("dropper", nil, 2)
+	dropper.subscribe(Subscription{Topic: TopicEvents})
+	hub.add(dropper)
+
+	for i := 0; i < 5; i++ {
+		hub.publish(&livePublication{topic: TopicEvents, message: WebSocketMessage{Type: "security_event", Data: i}}, SlowConsumerDrop)
+	}
+	assert.Len(t, dropper.send, 2)
+	assert.Equal(t, uint64(3), dropper.dropped.Load())
+	assert.Equal(t, 0, (<-dropper.send).Data, "保留較早的訊息")
+
+	disconnector := newWSClient("disconnector", nil, 1)
+	disconnector.subscribe(Subscription{Topic: TopicEvents})
+	hub.add(disconnector)
+	for i := 0; i < 3; i++ {
+		hub.publish(&livePublication{topic: TopicEvents, message: WebSocketMessage{Type: "security_event"}}, SlowConsumerDisconnect)
+	}
+	select {
+	case <-disconnector.done:
+	default:
+		t.Fatal("佇列已滿時應中斷連線")
+	}
+	assert.Equal(t, uint64(1), disconnector.dropped.Load(), "中斷後不再計入")
+
+	// 相同 ID 重新連線時關閉舊連線，舊連線移除時不影響新連線
+	replacement := newWSClient("dropper", nil, 2)
+	hub.add(replacement)
+	<-dropper.done
+	hub.remove(dropper)
+	assert.Same(t, replacement, hub.get("dropper"))
+}
@@ -56,16 +56,17 @@ func NewAuthHandlerWithModules(
 	if pubsubInstance != nil {
 		// 訂閱認證事件
 		ctx := context.Background()
-		pubsubInstance.Subscribe(ctx, "auth.events", func(topic string, message []byte) error {
+		processed := pubsub.NewMemoryIdempotencyStore(pubsub.DefaultIdempotencyTTL)
+		pubsubInstance.Subscribe(ctx, "auth.events", pubsub.IdempotentHandler(processed, func(topic string, message []byte) error {
 			logger.Infof("收到認證事件: %s", string(message))
 			return nil
-		})
+		}))
 
 		// 訂閱安全事件
-		pubsubInstance.Subscribe(ctx, "security.events", func(topic string, message []byte) error {
+		pubsubInstance.Subscribe(ctx, "security.events", pubsub.IdempotentHandler(processed, func(topic string, message []byte) error {
 			logger.Warnf("收到安全事件: %s", string(message))
 			return nil
-		})
+		}))
 	}
 
 	// 設定 MQTT 訂閱
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"pandora_box_console_ids_ips/internal/logging"
+	"pandora_box_console_ids_ips/internal/mqtt"
+	"pandora_box_console_ids_ips/internal/token"
+)
+
+// DeviceTokenHandler 設備 MQTT Token 的簽發與撤銷
+type DeviceTokenHandler struct {
+	tokens        *token.Auth
+	server        *mqtt.Server
+	logger        *logrus.Logger
+	centralLogger *logging.CentralLogger
+}
+
+// IssueDeviceTokenRequest 簽發設備 Token 請求
+type IssueDeviceTokenRequest struct {
+	TTL string `json:"ttl,omitempty"` // 例如 "720h"，空白使用預設有效期限
+}
+
+// NewDeviceTokenHandler 創建設備 Token 處理器；server 不為 nil 時撤銷 Token 會中斷設備的現有連線
+func NewDeviceTokenHandler(tokens *token.Auth, server *mqtt.Server, logger *logrus.Logger, centralLogger *logging.CentralLogger) *DeviceTokenHandler {
+	return &DeviceTokenHandler{
+		tokens:        tokens,
+		server:        server,
+		logger:        logger,
+		centralLogger: centralLogger,
+	}
+}
+
+// IssueToken 為設備簽發 Token（POST /devices/:id/tokens）；明文 Token 只在回應中出現一次
+func (h *DeviceTokenHandler) IssueToken(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	var req IssueDeviceTokenRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+	}
+	ttl := token.DefaultDeviceTokenTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ttl"})
+			return
+		}
+		ttl = parsed
+	}
+
+	issuedAt := time.Now()
+	tokenStr, err := h.tokens.IssueDeviceToken(deviceID, ttl)
+	if err != nil {
+		h.logger.Errorf("簽發設備 Token 失敗 - 設備: %s, 錯誤: %v", deviceID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue device token"})
+		return
+	}
+
+	h.centralLogger.LogSecurityEvent(deviceID, "DEVICE_TOKEN_ISSUE", "success",
+		"已簽發設備 MQTT Token", map[string]interface{}{
+			"ttl":       ttl.String(),
+			"client_ip": c.ClientIP(),
+		})
+	c.JSON(http.StatusCreated, gin.H{
+		"device_id":  deviceID,
+		"token":      tokenStr,
+		"expires_at": issuedAt.Add(ttl).UTC(),
+	})
+}
+
+// RevokeTokens 撤銷設備的所有 Token 並中斷其連線（DELETE /devices/:id/tokens）
+func (h *DeviceTokenHandler) RevokeTokens(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	revoked, err := h.tokens.RevokeDeviceTokens(deviceID)
+	disconnected := 0
+	if h.server != nil {
+		disconnected = h.server.DisconnectIdentity(mqtt.IdentityDevice, deviceID)
+	}
+	if err != nil {
+		h.logger.Errorf("撤銷設備 Token 失敗 - 設備: %s, 錯誤: %v", deviceID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to persist token revocation", "revoked": revoked})
+		return
+	}
+
+	h.centralLogger.LogSecurityEvent(deviceID, "DEVICE_TOKEN_REVOKE", "success",
+		"已撤銷設備 MQTT Token", map[string]interface{}{
+			"revoked":      revoked,
+			"disconnected": disconnected,
+			"client_ip":    c.ClientIP(),
+		})
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":    deviceID,
+		"revoked":      revoked,
+		"disconnected": disconnected,
+	})
+}
+
+// RequireAdminToken 要求 "Authorization: Bearer <token>"；未設定 token 時拒絕所有請求
+func RequireAdminToken(adminToken string) gin.HandlerFunc {
+	expected := []byte("Bearer " + adminToken)
+	return func(c *gin.Context) {
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="pandora-admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
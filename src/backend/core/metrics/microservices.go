@@ -42,6 +42,10 @@ type MicroserviceMetrics struct {
 	RabbitMQPublishErrors  *prometheus.CounterVec
 	RabbitMQSubscribeTotal *prometheus.CounterVec
 	RabbitMQConsumeErrors  *prometheus.CounterVec
+
+	// MQTT 指標
+	MQTTOfflineQueueDepth   *prometheus.GaugeVec
+	MQTTOfflineQueueDropped *prometheus.CounterVec
 }
 
 // NewMicroserviceMetrics creates new microservice metrics
@@ -226,6 +230,22 @@ func NewMicroserviceMetrics(serviceName string) *MicroserviceMetrics {
 			},
 			[]string{"queue", "error_type"},
 		),
+
+		// MQTT 指標
+		MQTTOfflineQueueDepth: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "mqtt_offline_queue_depth",
+				Help: "Number of MQTT messages buffered on disk while the broker is unreachable",
+			},
+			[]string{"client_id"},
+		),
+		MQTTOfflineQueueDropped: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "mqtt_offline_queue_dropped_total",
+				Help: "Total number of MQTT messages dropped from the offline queue",
+			},
+			[]string{"client_id", "reason"},
+		),
 	}
 }
 
@@ -279,3 +299,12 @@ func (m *MicroserviceMetrics) RecordRabbitMQPublish(exchange, routingKey, status
 	m.RabbitMQPublishTotal.WithLabelValues(exchange, routingKey, status).Inc()
 }
 
+// SetMQTTOfflineQueueDepth records the MQTT offline queue depth
+func (m *MicroserviceMetrics) SetMQTTOfflineQueueDepth(clientID string, depth int) {
+	m.MQTTOfflineQueueDepth.WithLabelValues(clientID).Set(float64(depth))
+}
+
+// RecordMQTTOfflineQueueDrop records messages dropped from the MQTT offline queue
+func (m *MicroserviceMetrics) RecordMQTTOfflineQueueDrop(clientID, reason string, count int) {
+	m.MQTTOfflineQueueDropped.WithLabelValues(clientID, reason).Add(float64(count))
+}
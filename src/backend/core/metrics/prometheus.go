@@ -26,6 +26,12 @@ type PrometheusMetrics struct {
 	// 基礎安全指標 (簡化版)
 	SecurityEventsCounter *prometheus.CounterVec
 
+	// 規則命中指標
+	RuleHitsCounter       *prometheus.CounterVec
+	RuleBlocksCounter     *prometheus.CounterVec
+	RuleSuppressedCounter *prometheus.CounterVec
+	RuleLastHitGauge      *prometheus.GaugeVec
+
 	// 效能指標
 	ResponseTimeHistogram *prometheus.HistogramVec
 	ActiveSessionsGauge   prometheus.Gauge
@@ -89,6 +95,39 @@ func NewPrometheusMetrics(logger *logrus.Logger) *PrometheusMetrics {
 		[]string{"event_type", "status"},
 	)
 
+	// 規則命中指標
+	ruleHitsCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pandora_rule_hits_total",
+			Help: "規則命中總數",
+		},
+		[]string{"rule_id"},
+	)
+
+	ruleBlocksCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pandora_rule_blocks_total",
+			Help: "規則命中並阻斷的總數",
+		},
+		[]string{"rule_id"},
+	)
+
+	ruleSuppressedCounter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pandora_rule_suppressed_total",
+			Help: "規則命中但被誤報抑制的總數",
+		},
+		[]string{"rule_id"},
+	)
+
+	ruleLastHitGauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "pandora_rule_last_hit_timestamp_seconds",
+			Help: "規則最後一次命中的時間 (Unix 秒)",
+		},
+		[]string{"rule_id"},
+	)
+
 	// 效能指標
 	responseTimeHistogram := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -122,6 +161,10 @@ func NewPrometheusMetrics(logger *logrus.Logger) *PrometheusMetrics {
 		systemUptimeGauge,
 		authAttempts,
 		securityEventsCounter,
+		ruleHitsCounter,
+		ruleBlocksCounter,
+		ruleSuppressedCounter,
+		ruleLastHitGauge,
 		responseTimeHistogram,
 		activeSessionsGauge,
 		dataThroughputGauge,
@@ -137,6 +180,10 @@ func NewPrometheusMetrics(logger *logrus.Logger) *PrometheusMetrics {
 		SystemUptimeGauge:      systemUptimeGauge,
 		AuthAttempts:           authAttempts,
 		SecurityEventsCounter:  securityEventsCounter,
+		RuleHitsCounter:        ruleHitsCounter,
+		RuleBlocksCounter:      ruleBlocksCounter,
+		RuleSuppressedCounter:  ruleSuppressedCounter,
+		RuleLastHitGauge:       ruleLastHitGauge,
 		ResponseTimeHistogram:  responseTimeHistogram,
 		ActiveSessionsGauge:    activeSessionsGauge,
 		DataThroughputGauge:    dataThroughputGauge,
@@ -144,6 +191,16 @@ func NewPrometheusMetrics(logger *logrus.Logger) *PrometheusMetrics {
 	}
 }
 
+// Register 註冊其他元件的指標收集器 (例如事件發件匣轉發器)
+func (pm *PrometheusMetrics) Register(collectors ...prometheus.Collector) error {
+	for _, collector := range collectors {
+		if err := pm.registry.Register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // StartMetricsServer 啟動Prometheus指標伺服器
 func (pm *PrometheusMetrics) StartMetricsServer(port string) error {
 	gin.SetMode(gin.ReleaseMode)
@@ -209,6 +266,29 @@ func (pm *PrometheusMetrics) RecordSecurityEvent(eventType, status string) {
 	pm.SecurityEventsCounter.WithLabelValues(eventType, status).Inc()
 }
 
+// RecordRuleHit 記錄規則命中
+func (pm *PrometheusMetrics) RecordRuleHit(ruleID string, blocked bool, at time.Time) {
+	pm.RuleHitsCounter.WithLabelValues(ruleID).Inc()
+	if blocked {
+		pm.RuleBlocksCounter.WithLabelValues(ruleID).Inc()
+	}
+	pm.RuleLastHitGauge.WithLabelValues(ruleID).Set(float64(at.Unix()))
+}
+
+// RecordRuleSuppressed 記錄被誤報抑制的規則命中
+func (pm *PrometheusMetrics) RecordRuleSuppressed(ruleID string) {
+	pm.RuleSuppressedCounter.WithLabelValues(ruleID).Inc()
+}
+
+// DeleteRuleMetrics 規則移除後刪除其指標，避免標籤無限增長
+func (pm *PrometheusMetrics) DeleteRuleMetrics(ruleID string) {
+	labels := prometheus.Labels{"rule_id": ruleID}
+	pm.RuleHitsCounter.Delete(labels)
+	pm.RuleBlocksCounter.Delete(labels)
+	pm.RuleSuppressedCounter.Delete(labels)
+	pm.RuleLastHitGauge.Delete(labels)
+}
+
 // RecordResponseTime 記錄回應時間
 func (pm *PrometheusMetrics) RecordResponseTime(operation, status string, duration time.Duration) {
 	pm.ResponseTimeHistogram.WithLabelValues(operation, status).Observe(duration.Seconds())
@@ -98,6 +98,33 @@ func TestRecordSecurityEvent(t *testing.T) {
 	assert.Contains(t, response, `status="detected"`)
 }
 
+func TestRecordRuleHit(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	metrics := NewPrometheusMetrics(logger)
+
+	metrics.RecordRuleHit("rule_001", true, time.Unix(1700000000, 0))
+	metrics.RecordRuleHit("rule_001", false, time.Unix(1700000100, 0))
+	metrics.RecordRuleSuppressed("rule_002")
+
+	handler := promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{})
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	response := w.Body.String()
+	assert.Contains(t, response, `pandora_rule_hits_total{rule_id="rule_001"} 2`)
+	assert.Contains(t, response, `pandora_rule_blocks_total{rule_id="rule_001"} 1`)
+	assert.Contains(t, response, `pandora_rule_last_hit_timestamp_seconds{rule_id="rule_001"} 1.7000001e+09`)
+	assert.Contains(t, response, `pandora_rule_suppressed_total{rule_id="rule_002"} 1`)
+
+	// 規則移除後不再輸出其指標
+	metrics.DeleteRuleMetrics("rule_001")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.NotContains(t, w.Body.String(), `rule_id="rule_001"`)
+}
+
 func TestRecordResponseTime(t *testing.T) {
 	logger := logrus.New()
 	logger.SetLevel(logrus.ErrorLevel)
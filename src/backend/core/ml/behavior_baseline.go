@@ -18,6 +18,8 @@ type BehaviorBaseline struct {
 	logger         *logrus.Logger
 	learningPeriod time.Duration
 	updateInterval time.Duration
+	listeners      map[int]func(*AnomalyDetection)
+	nextListener   int
 }
 
 // UserProfile represents a user's behavioral baseline
@@ -179,6 +181,8 @@ func (bb *BehaviorBaseline) DetectAnomaly(ctx context.Context, userID string, me
 	// 確定嚴重程度
 	detection.Severity = bb.determineSeverity(detection.AnomalyScore)
 
+	bb.notify(detection)
+
 	if detection.IsAnomaly {
 		bb.logger.Warnf("Anomaly detected for user %s: score=%.2f, severity=%s", 
 			userID, detection.AnomalyScore, detection.Severity)
@@ -187,6 +191,37 @@ func (bb *BehaviorBaseline) DetectAnomaly(ctx context.Context, userID string, me
 	return detection, nil
 }
 
+// OnAnomaly registers a listener for every detection computed after the learning period
+// (e.g. to tighten rate limits for the user). It returns a function that unregisters the listener.
+func (bb *BehaviorBaseline) OnAnomaly(listener func(*AnomalyDetection)) func() {
+	bb.mu.Lock()
+	defer bb.mu.Unlock()
+
+	if bb.listeners == nil {
+		bb.listeners = make(map[int]func(*AnomalyDetection))
+	}
+	id := bb.nextListener
+	bb.nextListener++
+	bb.listeners[id] = listener
+
+	return func() {
+		bb.mu.Lock()
+		defer bb.mu.Unlock()
+		delete(bb.listeners, id)
+	}
+}
+
+// notify passes a copy of the detection to all listeners
+func (bb *BehaviorBaseline) notify(detection *AnomalyDetection) {
+	bb.mu.RLock()
+	defer bb.mu.RUnlock()
+
+	for _, listener := range bb.listeners {
+		copied := *detection
+		listener(&copied)
+	}
+}
+
 // updateProfile updates user profile with new metrics
 func (bb *BehaviorBaseline) updateProfile(profile *UserProfile, metrics *UserMetrics) {
 	profile.TotalRequests += metrics.RequestCount
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"os"
 	"sync"
 	"time"
 
@@ -172,7 +173,6 @@ func (dld *DeepLearningDetector) forward(input []float64) []float64 {
 
 	// 通過每一層
 	for i := 0; i < len(dld.model.Layers)-1; i++ {
-		layer := dld.model.Layers[i]
 		nextLayer := dld.model.Layers[i+1]
 
 		// 計算 z = W * a + b
@@ -390,7 +390,9 @@ func (dld *DeepLearningDetector) SaveModel(filepath string) error {
 		return fmt.Errorf("failed to marshal model: %w", err)
 	}
 
-	// 實際實現需要寫入檔案
+	if err := os.WriteFile(filepath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write model: %w", err)
+	}
 	dld.logger.Infof("Model saved to %s", filepath)
 	return nil
 }
@@ -429,6 +431,10 @@ func initializeWeights(model *NeuralNetwork) {
 	}
 }
 
+func sigmoid(x float64) float64 {
+	return 1.0 / (1.0 + math.Exp(-x))
+}
+
 func applyActivation(z []float64, activationType string) []float64 {
 	activated := make([]float64, len(z))
 
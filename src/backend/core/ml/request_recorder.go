@@ -0,0 +1,109 @@
+package ml
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RequestRecorder aggregates per-user request metrics over an interval and feeds them
+// to a BehaviorBaseline: each interval is first checked against the user's baseline
+// (notifying OnAnomaly listeners) and then learned into it
+type RequestRecorder struct {
+	baseline *BehaviorBaseline
+	mu       sync.Mutex
+	window   map[string]*UserMetrics
+	started  time.Time
+	now      func() time.Time
+}
+
+// NewRequestRecorder creates a recorder feeding the given baseline
+func NewRequestRecorder(baseline *BehaviorBaseline) *RequestRecorder {
+	return &RequestRecorder{
+		baseline: baseline,
+		window:   make(map[string]*UserMetrics),
+		started:  time.Now(),
+		now:      time.Now,
+	}
+}
+
+// Record adds a completed request of an authenticated user to the current interval
+func (rr *RequestRecorder) Record(userID, endpoint, ip, userAgent string, failed bool) {
+	if userID == "" {
+		return
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	metrics, exists := rr.window[userID]
+	if !exists {
+		metrics = &UserMetrics{
+			SessionCount: 1,
+			Endpoints:    make(map[string]int),
+			UserAgents:   make(map[string]int),
+			IPs:          make(map[string]int),
+			Countries:    make(map[string]int),
+			Cities:       make(map[string]int),
+		}
+		rr.window[userID] = metrics
+	}
+	metrics.RequestCount++
+	metrics.TotalRequests++
+	if failed {
+		metrics.ErrorCount++
+	}
+	if endpoint != "" {
+		metrics.Endpoints[endpoint]++
+	}
+	if userAgent != "" {
+		metrics.UserAgents[userAgent]++
+	}
+	if ip != "" {
+		metrics.IPs[ip]++
+	}
+}
+
+// Flush closes the current interval: users with a baseline are checked for anomalies,
+// then every user's metrics are learned
+func (rr *RequestRecorder) Flush(ctx context.Context) {
+	rr.mu.Lock()
+	window := rr.window
+	elapsed := rr.now().Sub(rr.started).Seconds()
+	rr.window = make(map[string]*UserMetrics)
+	rr.started = rr.now()
+	rr.mu.Unlock()
+
+	for userID, metrics := range window {
+		if elapsed > 0 {
+			metrics.RequestRate = float64(metrics.RequestCount) / elapsed
+		}
+		if _, err := rr.baseline.GetProfile(userID); err == nil {
+			if _, err := rr.baseline.DetectAnomaly(ctx, userID, metrics); err != nil {
+				rr.baseline.logger.Debugf("Anomaly detection failed for user %s: %v", userID, err)
+			}
+		}
+		if err := rr.baseline.LearnUserBehavior(ctx, userID, metrics); err != nil {
+			rr.baseline.logger.Debugf("Learning behavior failed for user %s: %v", userID, err)
+		}
+	}
+}
+
+// Run flushes the recorder every interval until the context is canceled
+func (rr *RequestRecorder) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rr.Flush(ctx)
+		}
+	}
+}
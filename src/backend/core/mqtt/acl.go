@@ -0,0 +1,205 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"pandora_box_console_ids_ips/internal/logging"
+)
+
+// ErrNotAuthorized 操作不符合主題 ACL
+var ErrNotAuthorized = errors.New("MQTT 操作未授權")
+
+// IdentityKind 連線身分類型
+type IdentityKind string
+
+const (
+	// IdentityDevice ESP32、Arduino 等設備
+	IdentityDevice IdentityKind = "device"
+	// IdentityService Console、Agent 等內部服務
+	IdentityService IdentityKind = "service"
+)
+
+// ACLAction MQTT 操作
+type ACLAction string
+
+const (
+	ACLPublish   ACLAction = "publish"
+	ACLSubscribe ACLAction = "subscribe"
+
+	// aclConnect 只用於稽核被拒絕的連線
+	aclConnect ACLAction = "connect"
+)
+
+// Identity 已驗證的連線身分
+type Identity struct {
+	ID       string       `json:"id"`
+	Kind     IdentityKind `json:"kind"`
+	ClientID string       `json:"client_id,omitempty"`
+	// Method 驗證方式：certificate、token 或 password
+	Method string `json:"method,omitempty"`
+}
+
+// ACLRule 主題存取規則；Topic 可使用 {deviceID}（設備身分 ID）與 {clientID} 佔位符
+type ACLRule struct {
+	Kind      IdentityKind `yaml:"kind" json:"kind"`           // 適用的身分類型，空白代表全部
+	ID        string       `yaml:"id" json:"id"`               // 適用的身分 ID，空白代表該類型全部
+	Topic     string       `yaml:"topic" json:"topic"`         // 主題過濾器，例如 devices/{deviceID}/#
+	Publish   bool         `yaml:"publish" json:"publish"`     // 允許發布
+	Subscribe bool         `yaml:"subscribe" json:"subscribe"` // 允許訂閱
+}
+
+// ACL 主題存取控制清單：沒有任何規則允許的操作一律拒絕
+type ACL struct {
+	rules []ACLRule
+}
+
+// NewACL 創建主題 ACL
+func NewACL(rules []ACLRule) (*ACL, error) {
+	for i, rule := range rules {
+		if err := validateTopicFilter(rule.Topic); err != nil {
+			return nil, fmt.Errorf("ACL 規則 %d: %w", i, err)
+		}
+		if rule.Kind != "" && rule.Kind != IdentityDevice && rule.Kind != IdentityService {
+			return nil, fmt.Errorf("ACL 規則 %d: 未知的身分類型 %q", i, rule.Kind)
+		}
+	}
+	return &ACL{rules: append([]ACLRule(nil), rules...)}, nil
+}
+
+// DefaultDeviceRules 預設規則：設備只能存取 devices/{deviceID}/#
+func DefaultDeviceRules() []ACLRule {
+	return []ACLRule{
+		{Kind: IdentityDevice, Topic: "devices/{deviceID}/#", Publish: true, Subscribe: true},
+	}
+}
+
+// Allowed 檢查身分是否可以對主題（訂閱時為主題過濾器）執行操作
+func (a *ACL) Allowed(identity *Identity, action ACLAction, topic string) bool {
+	if a == nil || identity == nil {
+		return false
+	}
+	if action == ACLPublish && strings.ContainsAny(topic, "+#") {
+		return false
+	}
+	if validateTopicFilter(topic) != nil {
+		return false
+	}
+
+	for _, rule := range a.rules {
+		if rule.Kind != "" && rule.Kind != identity.Kind {
+			continue
+		}
+		if rule.ID != "" && rule.ID != identity.ID {
+			continue
+		}
+		if (action == ACLPublish && !rule.Publish) || (action == ACLSubscribe && !rule.Subscribe) {
+			continue
+		}
+		filter, ok := expandRuleTopic(rule.Topic, identity)
+		if !ok {
+			continue
+		}
+		if filterCovers(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandRuleTopic 代入規則中的佔位符；身分 ID 含有主題分隔符或萬用字元時不適用該規則
+func expandRuleTopic(topic string, identity *Identity) (string, bool) {
+	if strings.Contains(topic, "{deviceID}") {
+		if identity.Kind != IdentityDevice || !validTopicLevel(identity.ID) {
+			return "", false
+		}
+		topic = strings.ReplaceAll(topic, "{deviceID}", identity.ID)
+	}
+	if strings.Contains(topic, "{clientID}") {
+		if !validTopicLevel(identity.ClientID) {
+			return "", false
+		}
+		topic = strings.ReplaceAll(topic, "{clientID}", identity.ClientID)
+	}
+	return topic, true
+}
+
+// validTopicLevel 檢查字串可以作為單一主題層級
+func validTopicLevel(level string) bool {
+	return level != "" && !strings.ContainsAny(level, "/+#\x00")
+}
+
+// validateTopicFilter 檢查主題過濾器格式：# 只能是最後一層，+ 與 # 必須佔滿整個層級
+func validateTopicFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("主題不可為空")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return fmt.Errorf("無效的主題過濾器 %q", filter)
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return fmt.Errorf("無效的主題過濾器 %q", filter)
+		}
+	}
+	return nil
+}
+
+// filterCovers 檢查符合 topic（可為過濾器）的所有主題是否都符合 filter
+func filterCovers(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	// $ 開頭的系統主題不符合以萬用字元開頭的過濾器
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return false
+	}
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			// devices/a/# 也符合 devices/a
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		switch {
+		case level == "+":
+			if topicLevels[i] == "#" {
+				return false
+			}
+		case level != topicLevels[i]:
+			return false
+		}
+	}
+	return len(topicLevels) == len(filterLevels)
+}
+
+// topicMatches 檢查發布主題是否符合訂閱過濾器
+func topicMatches(filter, topic string) bool {
+	return filterCovers(filter, topic)
+}
+
+// auditDenied 將被拒絕的操作記錄到中央日誌
+func auditDenied(audit *logging.CentralLogger, identity *Identity, action ACLAction, topic, reason string) {
+	if audit == nil {
+		return
+	}
+	details := map[string]interface{}{
+		"topic":  topic,
+		"reason": reason,
+	}
+	id := ""
+	if identity != nil {
+		id = identity.ID
+		details["identity_kind"] = string(identity.Kind)
+		details["client_id"] = identity.ClientID
+		details["auth_method"] = identity.Method
+	}
+	message := fmt.Sprintf("拒絕 MQTT %s: %s", action, topic)
+	if topic == "" {
+		message = fmt.Sprintf("拒絕 MQTT %s: %s", action, reason)
+	}
+	audit.LogSecurityEvent(id, "MQTT_"+strings.ToUpper(string(action)), "denied", message, details)
+}
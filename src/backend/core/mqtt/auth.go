@@ -0,0 +1,91 @@
+package mqtt
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+
+	"pandora_box_console_ids_ips/internal/token"
+)
+
+// ErrBadCredentials 連線憑證錯誤
+var ErrBadCredentials = errors.New("MQTT 帳號或密碼錯誤")
+
+// Credentials 連線時提供的憑證
+type Credentials struct {
+	ClientID string
+	Username string
+	Password []byte
+	// PeerCertificates 客戶端送出的憑證（第一張為客戶端憑證），不代表已通過驗證
+	PeerCertificates []*x509.Certificate
+	// VerifiedChains 以 TLS 設定的 ClientCAs 驗證通過的憑證鏈；
+	// ClientAuth 為 RequestClientCert 或 RequireAnyClientCert 時可能為空
+	VerifiedChains [][]*x509.Certificate
+}
+
+// Authenticator 驗證連線並返回身分
+type Authenticator interface {
+	Authenticate(creds Credentials) (*Identity, error)
+}
+
+// DeviceAuthenticator 設備與服務的驗證：
+//   - 客戶端憑證：憑證 CN 為設備 ID（憑證鏈必須已由 TLS 設定的 ClientCAs 驗證）
+//   - 服務帳號：以 AddService 註冊的帳號密碼
+//   - 設備 Token：帳號為設備 ID，密碼為 core/token 簽發的設備 Token
+type DeviceAuthenticator struct {
+	tokens   *token.Auth
+	services map[string][32]byte
+	mu       sync.RWMutex
+}
+
+// NewDeviceAuthenticator 創建設備驗證器；tokens 為 nil 時不接受設備 Token
+func NewDeviceAuthenticator(tokens *token.Auth) *DeviceAuthenticator {
+	return &DeviceAuthenticator{
+		tokens:   tokens,
+		services: make(map[string][32]byte),
+	}
+}
+
+// AddService 註冊內部服務帳號（只保存密碼雜湊）
+func (a *DeviceAuthenticator) AddService(username, password string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.services[username] = sha256.Sum256([]byte(password))
+}
+
+// Authenticate 依序以客戶端憑證、服務帳號、設備 Token 驗證
+func (a *DeviceAuthenticator) Authenticate(creds Credentials) (*Identity, error) {
+	if len(creds.PeerCertificates) > 0 {
+		// 未經驗證的憑證可以自簽任意 CN，不能作為設備身分
+		if len(creds.VerifiedChains) == 0 || len(creds.VerifiedChains[0]) == 0 {
+			return nil, fmt.Errorf("%w: 客戶端憑證未通過 CA 驗證", ErrBadCredentials)
+		}
+		deviceID := creds.VerifiedChains[0][0].Subject.CommonName
+		if !validTopicLevel(deviceID) {
+			return nil, fmt.Errorf("%w: 憑證 CN %q 不是有效的設備 ID", ErrBadCredentials, deviceID)
+		}
+		return &Identity{ID: deviceID, Kind: IdentityDevice, ClientID: creds.ClientID, Method: "certificate"}, nil
+	}
+	if creds.Username == "" {
+		return nil, fmt.Errorf("%w: 未提供帳號", ErrBadCredentials)
+	}
+
+	a.mu.RLock()
+	want, isService := a.services[creds.Username]
+	a.mu.RUnlock()
+	if isService {
+		got := sha256.Sum256(creds.Password)
+		if subtle.ConstantTimeCompare(want[:], got[:]) != 1 {
+			return nil, ErrBadCredentials
+		}
+		return &Identity{ID: creds.Username, Kind: IdentityService, ClientID: creds.ClientID, Method: "password"}, nil
+	}
+
+	if a.tokens == nil || !validTopicLevel(creds.Username) || !a.tokens.VerifyDeviceToken(creds.Username, string(creds.Password)) {
+		return nil, ErrBadCredentials
+	}
+	return &Identity{ID: creds.Username, Kind: IdentityDevice, ClientID: creds.ClientID, Method: "token"}, nil
+}
@@ -1,12 +1,20 @@
 package mqtt
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/sirupsen/logrus"
+
+	"pandora_box_console_ids_ips/internal/logging"
+	"pandora_box_console_ids_ips/internal/pubsub"
 )
 
 // Broker MQTT 代理（客戶端包裝）
@@ -16,12 +24,26 @@ type Broker struct {
 	logger    *logrus.Logger
 	handlers  map[string]MessageHandler
 	mu        sync.RWMutex
-	connected bool
+	connected atomic.Bool
+	ceMode    pubsub.CloudEventsMode
+	acl       *ACL
+	identity  *Identity
+	audit     *logging.CentralLogger
+
+	queue        *OfflineQueue
+	queueMetrics QueueMetrics
+	draining     atomic.Bool
 }
 
 // MessageHandler 訊息處理函數
 type MessageHandler func(topic string, payload []byte) error
 
+// QueueMetrics 離線佇列指標（由 metrics.MicroserviceMetrics 實作）
+type QueueMetrics interface {
+	SetMQTTOfflineQueueDepth(clientID string, depth int)
+	RecordMQTTOfflineQueueDrop(clientID, reason string, count int)
+}
+
 // Config MQTT 配置
 type Config struct {
 	Broker           string        `yaml:"broker" json:"broker"`                         // MQTT 代理地址
@@ -30,6 +52,9 @@ type Config struct {
 	Username         string        `yaml:"username" json:"username"`                     // 用戶名
 	Password         string        `yaml:"password" json:"password"`                     // 密碼
 	TLSEnabled       bool          `yaml:"tls_enabled" json:"tls_enabled"`               // 是否啟用 TLS
+	CACert           string        `yaml:"ca_cert" json:"ca_cert"`                       // CA 憑證（驗證 Broker）
+	ClientCert       string        `yaml:"client_cert" json:"client_cert"`               // 客戶端憑證（設備以憑證 CN 作為身分）
+	ClientKey        string        `yaml:"client_key" json:"client_key"`                 // 客戶端私鑰
 	DefaultQoS       byte          `yaml:"default_qos" json:"default_qos"`               // 預設 QoS (0, 1, 2)
 	KeepAlive        int           `yaml:"keep_alive" json:"keep_alive"`                 // 保持連接時間（秒）
 	ConnectTimeout   time.Duration `yaml:"connect_timeout" json:"connect_timeout"`       // 連接超時
@@ -38,6 +63,12 @@ type Config struct {
 	AutoReconnect    bool          `yaml:"auto_reconnect" json:"auto_reconnect"`         // 自動重連
 	CleanSession     bool          `yaml:"clean_session" json:"clean_session"`           // 清理會話
 	OrderMatters     bool          `yaml:"order_matters" json:"order_matters"`           // 訊息順序
+	CloudEventsMode  string        `yaml:"cloudevents_mode" json:"cloudevents_mode"`     // 事件編碼：legacy（預設）或 structured
+	DeviceID         string        `yaml:"device_id" json:"device_id"`                   // 設定時以設備身分套用 ACL，否則為服務身分（帳號或 Client ID）
+	ACL              []ACLRule     `yaml:"acl" json:"acl"`                               // 主題 ACL，空白時不限制
+
+	// OfflineQueue 斷線時暫存 QoS≥1 訊息的磁碟佇列，Dir 空白時停用
+	OfflineQueue OfflineQueueConfig `yaml:"offline_queue" json:"offline_queue"`
 }
 
 // NewBroker 創建新的 MQTT Broker
@@ -69,10 +100,30 @@ func NewBroker(config *Config, logger *logrus.Logger) (*Broker, error) {
 		config.MaxReconnectWait = 10 * time.Minute
 	}
 
+	ceMode, err := parseCloudEventsMode(config.CloudEventsMode)
+	if err != nil {
+		return nil, err
+	}
+
 	broker := &Broker{
 		config:   config,
 		logger:   logger,
 		handlers: make(map[string]MessageHandler),
+		ceMode:   ceMode,
+		identity: configIdentity(config),
+	}
+	if len(config.ACL) > 0 {
+		if broker.acl, err = NewACL(config.ACL); err != nil {
+			return nil, err
+		}
+	}
+	if config.OfflineQueue.Dir != "" {
+		if broker.queue, err = NewOfflineQueue(config.OfflineQueue); err != nil {
+			return nil, err
+		}
+		if pending := broker.queue.Len(); pending > 0 {
+			logger.Infof("離線佇列中有 %d 則待送訊息，連線後重送", pending)
+		}
 	}
 
 	// 創建 MQTT 客戶端選項
@@ -84,6 +135,13 @@ func NewBroker(config *Config, logger *logrus.Logger) (*Broker, error) {
 		brokerURL = fmt.Sprintf("ssl://%s:%d", config.Broker, config.Port)
 	}
 	opts.AddBroker(brokerURL)
+	if config.TLSEnabled {
+		tlsConfig, err := clientTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
 
 	// 設定客戶端 ID
 	opts.SetClientID(config.ClientID)
@@ -128,7 +186,7 @@ func (b *Broker) Start() error {
 		return fmt.Errorf("連接 MQTT Broker 失敗: %w", err)
 	}
 
-	b.connected = true
+	b.connected.Store(true)
 	b.logger.Info("已連接到 MQTT Broker")
 
 	return nil
@@ -152,16 +210,43 @@ func (b *Broker) Stop() {
 
 	// 斷開連接
 	b.client.Disconnect(250)
-	b.connected = false
+	b.connected.Store(false)
+	if b.queue != nil {
+		// 未送出的訊息保留在磁碟上
+		b.queue.Close()
+	}
 	b.logger.Info("已斷開 MQTT Broker 連接")
 }
 
-// Publish 發布訊息
+// Publish 發布訊息；啟用離線佇列時，斷線期間的 QoS≥1 訊息寫入佇列，重新連線後依序重送
 func (b *Broker) Publish(topic string, payload []byte, qos byte, retained bool) error {
-	if !b.connected {
+	queued := b.queue != nil && qos > 0
+	if !queued && !b.connected.Load() {
 		return fmt.Errorf("未連接到 MQTT Broker")
 	}
+	if err := b.authorize(ACLPublish, topic); err != nil {
+		return err
+	}
+
+	payload, err := encodeEventPayload(b.ceMode, payload)
+	if err != nil {
+		return err
+	}
+
+	// 佇列中還有訊息時也要排隊，以維持發布順序
+	if queued && (!b.IsConnected() || b.queue.Len() > 0) {
+		return b.enqueue(topic, qos, retained, payload)
+	}
+	err = b.publish(topic, qos, retained, payload)
+	if err != nil && queued && !b.IsConnected() {
+		b.logger.Warnf("發布時連接中斷，訊息改存入離線佇列 [%s]: %v", topic, err)
+		return b.enqueue(topic, qos, retained, payload)
+	}
+	return err
+}
 
+// publish 直接發布已編碼的訊息
+func (b *Broker) publish(topic string, qos byte, retained bool, payload []byte) error {
 	token := b.client.Publish(topic, qos, retained, payload)
 	if !token.WaitTimeout(5 * time.Second) {
 		return fmt.Errorf("發布訊息超時")
@@ -187,9 +272,12 @@ func (b *Broker) PublishJSON(topic string, data interface{}) error {
 
 // Subscribe 訂閱主題
 func (b *Broker) Subscribe(topic string, handler MessageHandler) error {
-	if !b.connected {
+	if !b.connected.Load() {
 		return fmt.Errorf("未連接到 MQTT Broker")
 	}
+	if err := b.authorize(ACLSubscribe, topic); err != nil {
+		return err
+	}
 
 	// 儲存 handler
 	b.mu.Lock()
@@ -198,14 +286,19 @@ func (b *Broker) Subscribe(topic string, handler MessageHandler) error {
 
 	// 訂閱主題
 	token := b.client.Subscribe(topic, b.config.DefaultQoS, func(client mqtt.Client, msg mqtt.Message) {
-		b.handleMessage(msg)
+		b.handleMessage(topic, msg)
 	})
 
 	if !token.WaitTimeout(5 * time.Second) {
 		return fmt.Errorf("訂閱主題超時")
 	}
 
-	if err := token.Error(); err != nil {
+	err := token.Error()
+	if err == nil && subscribeRejected(token, topic) {
+		// Broker 以 SUBACK 0x80 拒絕訂閱（例如不符合 Broker 端的 ACL）
+		err = ErrNotAuthorized
+	}
+	if err != nil {
 		b.mu.Lock()
 		delete(b.handlers, topic)
 		b.mu.Unlock()
@@ -218,7 +311,7 @@ func (b *Broker) Subscribe(topic string, handler MessageHandler) error {
 
 // Unsubscribe 取消訂閱
 func (b *Broker) Unsubscribe(topic string) error {
-	if !b.connected {
+	if !b.connected.Load() {
 		return fmt.Errorf("未連接到 MQTT Broker")
 	}
 
@@ -243,16 +336,16 @@ func (b *Broker) Unsubscribe(topic string) error {
 
 // IsConnected 檢查是否已連接
 func (b *Broker) IsConnected() bool {
-	return b.connected && b.client.IsConnected()
+	return b.connected.Load() && b.client.IsConnected()
 }
 
-// handleMessage 處理接收到的訊息
-func (b *Broker) handleMessage(msg mqtt.Message) {
+// handleMessage 處理接收到的訊息；filter 為訂閱時的主題過濾器（可含萬用字元）
+func (b *Broker) handleMessage(filter string, msg mqtt.Message) {
 	topic := msg.Topic()
 	payload := msg.Payload()
 
 	b.mu.RLock()
-	handler, exists := b.handlers[topic]
+	handler, exists := b.handlers[filter]
 	b.mu.RUnlock()
 
 	if !exists {
@@ -262,14 +355,193 @@ func (b *Broker) handleMessage(msg mqtt.Message) {
 
 	b.logger.Debugf("收到訊息 [%s]: %d bytes", topic, len(payload))
 
+	// 接受舊格式與 CloudEvents，處理函數一律收到舊格式事件
+	payload, err := DecodeEventPayload(payload, "", nil)
+	if err != nil {
+		b.logger.Errorf("無效的 CloudEvent [%s]: %v", topic, err)
+		return
+	}
+
 	if err := handler(topic, payload); err != nil {
 		b.logger.Errorf("處理訊息失敗 [%s]: %v", topic, err)
 	}
 }
 
+// enqueue 將訊息寫入離線佇列；已連線時立即開始重送
+func (b *Broker) enqueue(topic string, qos byte, retained bool, payload []byte) error {
+	dropped, err := b.queue.Enqueue(QueuedMessage{Topic: topic, QoS: qos, Retained: retained, Payload: payload})
+	if dropped > 0 {
+		b.logger.Warnf("離線佇列已滿，丟棄 %d 則最舊的訊息", dropped)
+		b.recordQueueDrop("overflow", dropped)
+	}
+	if err != nil {
+		if errors.Is(err, ErrOfflineQueueFull) {
+			b.recordQueueDrop("full", 1)
+		}
+		return fmt.Errorf("寫入離線佇列失敗: %w", err)
+	}
+	b.reportQueueDepth()
+	b.logger.Debugf("訊息已存入離線佇列 [%s]: %d bytes", topic, len(payload))
+
+	if b.IsConnected() {
+		b.startReplay()
+	}
+	return nil
+}
+
+// startReplay 啟動離線佇列重送（同時只有一個重送 goroutine）
+func (b *Broker) startReplay() {
+	if b.queue == nil || !b.draining.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		for {
+			b.replayOfflineQueue()
+			b.draining.Store(false)
+			// 釋放後再檢查一次，避免遺漏重送結束前剛寫入的訊息
+			if b.queue.Len() == 0 || !b.IsConnected() || !b.draining.CompareAndSwap(false, true) {
+				return
+			}
+		}
+	}()
+}
+
+// replayOfflineQueue 依序重送離線佇列中的訊息，送出成功才移除；連接中斷時停止
+func (b *Broker) replayOfflineQueue() {
+	replayed := 0
+	for b.IsConnected() {
+		msg, ok, err := b.queue.Peek()
+		if errors.Is(err, ErrOfflineMessageCorrupt) {
+			b.logger.Errorf("丟棄離線佇列中的訊息: %v", err)
+			b.recordQueueDrop("corrupt", 1)
+			continue
+		}
+		if err != nil {
+			b.logger.Errorf("讀取離線佇列失敗: %v", err)
+			break
+		}
+		if !ok {
+			break
+		}
+		if err := b.publish(msg.Topic, msg.QoS, msg.Retained, msg.Payload); err != nil {
+			b.logger.Warnf("重送離線訊息失敗，等待重新連線 [%s]: %v", msg.Topic, err)
+			break
+		}
+		if err := b.queue.Remove(msg.Seq); err != nil {
+			b.logger.Errorf("移除已送出的離線訊息失敗: %v", err)
+			break
+		}
+		replayed++
+		b.reportQueueDepth()
+	}
+	if replayed > 0 {
+		b.logger.Infof("已重送 %d 則離線訊息，剩餘 %d 則", replayed, b.queue.Len())
+	}
+}
+
+// SetQueueMetrics 設定離線佇列指標
+func (b *Broker) SetQueueMetrics(m QueueMetrics) {
+	b.mu.Lock()
+	b.queueMetrics = m
+	b.mu.Unlock()
+	b.reportQueueDepth()
+}
+
+// OfflineQueueDepth 返回離線佇列中待送的訊息數
+func (b *Broker) OfflineQueueDepth() int {
+	if b.queue == nil {
+		return 0
+	}
+	return b.queue.Len()
+}
+
+func (b *Broker) reportQueueDepth() {
+	b.mu.RLock()
+	m := b.queueMetrics
+	b.mu.RUnlock()
+	if m != nil && b.queue != nil {
+		m.SetMQTTOfflineQueueDepth(b.config.ClientID, b.queue.Len())
+	}
+}
+
+func (b *Broker) recordQueueDrop(reason string, count int) {
+	b.mu.RLock()
+	m := b.queueMetrics
+	b.mu.RUnlock()
+	if m != nil {
+		m.RecordMQTTOfflineQueueDrop(b.config.ClientID, reason, count)
+	}
+}
+
+// SetAuditLogger 設定稽核日誌，記錄被 ACL 拒絕的操作
+func (b *Broker) SetAuditLogger(audit *logging.CentralLogger) {
+	b.audit = audit
+}
+
+// Identity 返回套用 ACL 的身分
+func (b *Broker) Identity() Identity {
+	return *b.identity
+}
+
+// authorize 以本客戶端的身分檢查主題 ACL，拒絕時記錄稽核日誌
+func (b *Broker) authorize(action ACLAction, topic string) error {
+	if b.acl == nil || b.acl.Allowed(b.identity, action, topic) {
+		return nil
+	}
+	b.logger.Warnf("拒絕 MQTT %s [%s %s]: %s", action, b.identity.Kind, b.identity.ID, topic)
+	auditDenied(b.audit, b.identity, action, topic, "topic ACL")
+	return fmt.Errorf("%w: %s %s", ErrNotAuthorized, action, topic)
+}
+
+// configIdentity 由設定決定本客戶端的身分
+func configIdentity(config *Config) *Identity {
+	if config.DeviceID != "" {
+		return &Identity{ID: config.DeviceID, Kind: IdentityDevice, ClientID: config.ClientID}
+	}
+	id := config.Username
+	if id == "" {
+		id = config.ClientID
+	}
+	return &Identity{ID: id, Kind: IdentityService, ClientID: config.ClientID}
+}
+
+// clientTLSConfig 載入 CA 與客戶端憑證
+func clientTLSConfig(config *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CACert != "" {
+		pem, err := os.ReadFile(config.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("讀取 CA 憑證失敗: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 憑證 %s 格式無效", config.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.ClientCert != "" || config.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("載入客戶端憑證失敗: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// subscribeRejected 檢查 SUBACK 是否回傳失敗 (0x80)
+func subscribeRejected(token mqtt.Token, topic string) bool {
+	subToken, ok := token.(*mqtt.SubscribeToken)
+	if !ok {
+		return false
+	}
+	code, exists := subToken.Result()[topic]
+	return exists && code == 0x80
+}
+
 // onConnect 連接成功回調
 func (b *Broker) onConnect(client mqtt.Client) {
-	b.connected = true
+	b.connected.Store(true)
 	b.logger.Info("MQTT 連接成功")
 
 	// 重新訂閱所有主題
@@ -281,18 +553,22 @@ func (b *Broker) onConnect(client mqtt.Client) {
 	b.mu.RUnlock()
 
 	for _, topic := range topics {
-		token := client.Subscribe(topic, b.config.DefaultQoS, func(client mqtt.Client, msg mqtt.Message) {
-			b.handleMessage(msg)
+		filter := topic
+		token := client.Subscribe(filter, b.config.DefaultQoS, func(client mqtt.Client, msg mqtt.Message) {
+			b.handleMessage(filter, msg)
 		})
 		if token.Wait() && token.Error() == nil {
 			b.logger.Infof("重新訂閱主題: %s", topic)
 		}
 	}
+
+	// 依序重送斷線期間暫存的訊息
+	b.startReplay()
 }
 
 // onConnectionLost 連接丟失回調
 func (b *Broker) onConnectionLost(client mqtt.Client, err error) {
-	b.connected = false
+	b.connected.Store(false)
 	b.logger.Errorf("MQTT 連接丟失: %v", err)
 }
 
@@ -0,0 +1,97 @@
+package mqtt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"pandora_box_console_ids_ips/internal/pubsub"
+)
+
+// UserProperty MQTT 5 使用者屬性
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// CloudEventUserProperties 依 CloudEvents MQTT 綁定返回 binary 模式的使用者屬性、Content Type 與 payload
+// （屬性名稱不加前綴，值一律使用字串形式）
+func CloudEventUserProperties(ce *pubsub.CloudEvent) ([]UserProperty, string, []byte, error) {
+	if err := ce.Validate(); err != nil {
+		return nil, "", nil, err
+	}
+
+	attrs := ce.Attributes()
+	props := make([]UserProperty, 0, len(attrs))
+	for name, value := range attrs {
+		props = append(props, UserProperty{Key: name, Value: attributeString(value)})
+	}
+	return props, ce.DataContentType, ce.Data, nil
+}
+
+// CloudEventFromUserProperties 解碼 binary 模式的 MQTT 5 訊息；使用者屬性沒有 specversion 時 ok 為 false
+func CloudEventFromUserProperties(contentType string, props []UserProperty, payload []byte) (ce *pubsub.CloudEvent, ok bool, err error) {
+	attrs := make(map[string]interface{}, len(props))
+	for _, prop := range props {
+		attrs[prop.Key] = prop.Value
+	}
+	if _, ok := attrs["specversion"]; !ok {
+		return nil, false, nil
+	}
+	ce, err = pubsub.NewCloudEventFromAttributes(attrs, contentType, payload)
+	return ce, true, err
+}
+
+// DecodeEventPayload 將訊息轉為舊版 JSON 事件格式，接受舊格式、structured 與 binary 模式的 CloudEvents
+func DecodeEventPayload(payload []byte, contentType string, props []UserProperty) ([]byte, error) {
+	ce, ok, err := CloudEventFromUserProperties(contentType, props, payload)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return pubsub.CloudEventToEvent(ce)
+	}
+	return pubsub.DecodeEvent(payload, contentType, nil)
+}
+
+// encodeEventPayload 依設定的模式編碼事件；非事件訊息維持原樣
+func encodeEventPayload(mode pubsub.CloudEventsMode, payload []byte) ([]byte, error) {
+	if mode != pubsub.CloudEventsStructured {
+		return payload, nil
+	}
+	ce, err := pubsub.EventToCloudEvent(payload)
+	if err != nil {
+		return payload, nil
+	}
+	encoded, err := json.Marshal(ce)
+	if err != nil {
+		return nil, fmt.Errorf("編碼 CloudEvent 失敗: %w", err)
+	}
+	return encoded, nil
+}
+
+// parseCloudEventsMode 解析設定；MQTT 3.1.1 沒有使用者屬性，只支援 structured 模式
+func parseCloudEventsMode(value string) (pubsub.CloudEventsMode, error) {
+	mode, err := pubsub.ParseCloudEventsMode(value)
+	if err != nil {
+		return mode, err
+	}
+	if mode == pubsub.CloudEventsBinary {
+		return mode, fmt.Errorf("CloudEvents binary 模式需要 MQTT 5 使用者屬性，目前的 MQTT 3.1.1 客戶端只支援 structured 模式")
+	}
+	return mode, nil
+}
+
+// attributeString 返回屬性的字串形式
+func attributeString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	}
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,74 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"pandora_box_console_ids_ips/internal/pubsub"
+)
+
+func TestCloudEventUserProperties(t *testing.T) {
+	legacy, err := pubsub.ToJSON(pubsub.NewDeviceEvent("usb-001", "usb-serial", "connected"))
+	require.NoError(t, err)
+	ce, err := pubsub.EventToCloudEvent(legacy)
+	require.NoError(t, err)
+
+	props, contentType, payload, err := CloudEventUserProperties(ce)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", contentType)
+	values := make(map[string]string)
+	for _, prop := range props {
+		values[prop.Key] = prop.Value
+	}
+	assert.Equal(t, "1.0", values["specversion"])
+	assert.Equal(t, ce.ID, values["id"])
+	assert.Equal(t, "device.connected", values["type"])
+	assert.Equal(t, "2", values["schemaversion"])
+	assert.NotContains(t, values, "datacontenttype")
+
+	decoded, err := DecodeEventPayload(payload, contentType, props)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(legacy), string(decoded))
+
+	// MQTT 綁定規格範例：屬性名稱不加前綴
+	decoded, err = DecodeEventPayload([]byte(`{"appinfoA":"abc"}`), "application/json", []UserProperty{
+		{Key: "specversion", Value: "1.0"},
+		{Key: "type", Value: "com.example.someevent"},
+		{Key: "time", Value: "2018-04-05T03:56:24Z"},
+		{Key: "id", Value: "1234-1234-1234"},
+		{Key: "source", Value: "/mycontext/subcontext"},
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"1234-1234-1234","type":"com.example.someevent","source":"/mycontext/subcontext","timestamp":"2018-04-05T03:56:24Z","appinfoA":"abc"}`, string(decoded))
+
+	_, err = DecodeEventPayload(nil, "", []UserProperty{{Key: "specversion", Value: "1.0"}})
+	assert.Error(t, err)
+}
+
+func TestEncodeEventPayload(t *testing.T) {
+	legacy, err := pubsub.ToJSON(pubsub.NewSystemEvent("mqtt", "running", "started"))
+	require.NoError(t, err)
+
+	unchanged, err := encodeEventPayload(pubsub.CloudEventsDisabled, legacy)
+	require.NoError(t, err)
+	assert.Equal(t, legacy, unchanged)
+
+	structured, err := encodeEventPayload(pubsub.CloudEventsStructured, legacy)
+	require.NoError(t, err)
+	assert.Contains(t, string(structured), `"specversion":"1.0"`)
+	decoded, err := DecodeEventPayload(structured, "", nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(legacy), string(decoded))
+
+	// 感測器原始資料不是事件，維持原樣
+	raw, err := encodeEventPayload(pubsub.CloudEventsStructured, []byte(`{"temperature":21.5}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"temperature":21.5}`, string(raw))
+
+	_, err = NewBroker(&Config{Broker: "localhost", CloudEventsMode: "binary"}, nil)
+	assert.Error(t, err)
+	_, err = NewBroker(&Config{Broker: "localhost", CloudEventsMode: "structured"}, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,318 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrOfflineQueueFull 離線佇列已滿（block 策略等待逾時）
+	ErrOfflineQueueFull = errors.New("MQTT 離線佇列已滿")
+	// ErrOfflineQueueClosed 離線佇列已關閉
+	ErrOfflineQueueClosed = errors.New("MQTT 離線佇列已關閉")
+	// ErrOfflineMessageCorrupt 磁碟上的訊息無法解析（已從佇列移除）
+	ErrOfflineMessageCorrupt = errors.New("MQTT 離線訊息已損毀")
+)
+
+// OverflowPolicy 離線佇列滿時的處理策略
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest 丟棄最舊的訊息
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowBlock 阻塞發布直到有空間或逾時
+	OverflowBlock OverflowPolicy = "block"
+)
+
+const (
+	offlineMessageExt = ".msg"
+	offlineTempExt    = ".tmp"
+)
+
+// OfflineQueueConfig 離線佇列配置
+type OfflineQueueConfig struct {
+	Dir          string         `yaml:"dir" json:"dir"`                     // 佇列目錄
+	MaxMessages  int            `yaml:"max_messages" json:"max_messages"`   // 最大訊息數，預設 10000
+	MaxBytes     int64          `yaml:"max_bytes" json:"max_bytes"`         // 最大磁碟用量（位元組），0 代表不限制
+	Policy       OverflowPolicy `yaml:"policy" json:"policy"`               // drop_oldest（預設）或 block
+	BlockTimeout time.Duration  `yaml:"block_timeout" json:"block_timeout"` // block 策略的最長等待時間，預設 5 秒
+}
+
+// QueuedMessage 離線佇列中的訊息（payload 已完成編碼與 ACL 檢查）
+type QueuedMessage struct {
+	Seq        uint64    `json:"-"`
+	Topic      string    `json:"topic"`
+	QoS        byte      `json:"qos"`
+	Retained   bool      `json:"retained"`
+	Payload    []byte    `json:"payload"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+// OfflineQueue 磁碟上的離線發送佇列：每則訊息一個檔案，檔名為遞增序號
+type OfflineQueue struct {
+	config  OfflineQueueConfig
+	seqs    []uint64
+	sizes   map[uint64]int64
+	bytes   int64
+	nextSeq uint64
+	dropped uint64
+	closed  bool
+	mu      sync.Mutex
+	space   *sync.Cond
+}
+
+// NewOfflineQueue 開啟離線佇列，並載入目錄中上次未送出的訊息
+func NewOfflineQueue(config OfflineQueueConfig) (*OfflineQueue, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("離線佇列目錄不可為空")
+	}
+	if config.MaxMessages <= 0 {
+		config.MaxMessages = 10000
+	}
+	if config.Policy == "" {
+		config.Policy = OverflowDropOldest
+	}
+	if config.Policy != OverflowDropOldest && config.Policy != OverflowBlock {
+		return nil, fmt.Errorf("未知的離線佇列策略: %s", config.Policy)
+	}
+	if config.BlockTimeout <= 0 {
+		config.BlockTimeout = 5 * time.Second
+	}
+	if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("建立離線佇列目錄失敗: %w", err)
+	}
+
+	q := &OfflineQueue{
+		config: config,
+		sizes:  make(map[uint64]int64),
+	}
+	q.space = sync.NewCond(&q.mu)
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// load 掃描佇列目錄，重建序號索引並清除寫入中斷的暫存檔
+func (q *OfflineQueue) load() error {
+	entries, err := os.ReadDir(q.config.Dir)
+	if err != nil {
+		return fmt.Errorf("讀取離線佇列目錄失敗: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, offlineTempExt) {
+			os.Remove(filepath.Join(q.config.Dir, name))
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, offlineMessageExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, offlineMessageExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("讀取離線訊息失敗: %w", err)
+		}
+		q.seqs = append(q.seqs, seq)
+		q.sizes[seq] = info.Size()
+		q.bytes += info.Size()
+		if seq >= q.nextSeq {
+			q.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(q.seqs, func(i, j int) bool { return q.seqs[i] < q.seqs[j] })
+	return nil
+}
+
+// Enqueue 將訊息寫入佇列尾端，返回因 drop_oldest 策略被丟棄的訊息數
+func (q *OfflineQueue) Enqueue(msg QueuedMessage) (int, error) {
+	if msg.EnqueuedAt.IsZero() {
+		msg.EnqueuedAt = time.Now()
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return 0, fmt.Errorf("序列化離線訊息失敗: %w", err)
+	}
+	size := int64(len(data))
+	if q.config.MaxBytes > 0 && size > q.config.MaxBytes {
+		q.mu.Lock()
+		q.dropped++
+		q.mu.Unlock()
+		return 0, fmt.Errorf("%w: 訊息大小 %d 超過上限 %d", ErrOfflineQueueFull, size, q.config.MaxBytes)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := 0
+	deadline := time.Now().Add(q.config.BlockTimeout)
+	for !q.closed && q.full(size) {
+		if q.config.Policy == OverflowDropOldest {
+			if err := q.removeLocked(q.seqs[0]); err != nil {
+				return dropped, err
+			}
+			q.dropped++
+			dropped++
+			continue
+		}
+		if !q.waitLocked(deadline) {
+			q.dropped++
+			return dropped, ErrOfflineQueueFull
+		}
+	}
+	if q.closed {
+		return dropped, ErrOfflineQueueClosed
+	}
+
+	seq := q.nextSeq
+	if err := writeFileAtomic(q.path(seq), data); err != nil {
+		return dropped, fmt.Errorf("寫入離線訊息失敗: %w", err)
+	}
+	q.nextSeq++
+	q.seqs = append(q.seqs, seq)
+	q.sizes[seq] = size
+	q.bytes += size
+	return dropped, nil
+}
+
+// full 檢查加入 size 大小的訊息後是否超過上限
+func (q *OfflineQueue) full(size int64) bool {
+	if len(q.seqs) == 0 {
+		return false
+	}
+	if len(q.seqs) >= q.config.MaxMessages {
+		return true
+	}
+	return q.config.MaxBytes > 0 && q.bytes+size > q.config.MaxBytes
+}
+
+// waitLocked 等待佇列釋放空間，逾時返回 false
+func (q *OfflineQueue) waitLocked(deadline time.Time) bool {
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return false
+	}
+	// sync.Cond 不支援逾時，以計時器喚醒
+	timer := time.AfterFunc(remaining, func() {
+		q.mu.Lock()
+		q.space.Broadcast()
+		q.mu.Unlock()
+	})
+	q.space.Wait()
+	timer.Stop()
+	return true
+}
+
+// Peek 返回最舊的訊息（不移除）；訊息損毀時將其丟棄並返回 ErrOfflineMessageCorrupt
+func (q *OfflineQueue) Peek() (QueuedMessage, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.seqs) == 0 {
+		return QueuedMessage{}, false, nil
+	}
+	seq := q.seqs[0]
+	data, err := os.ReadFile(q.path(seq))
+	if err != nil {
+		return QueuedMessage{}, false, fmt.Errorf("讀取離線訊息失敗: %w", err)
+	}
+	var msg QueuedMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		if err := q.removeLocked(seq); err != nil {
+			return QueuedMessage{}, false, err
+		}
+		q.dropped++
+		return QueuedMessage{}, false, fmt.Errorf("%w: 序號 %d: %v", ErrOfflineMessageCorrupt, seq, err)
+	}
+	msg.Seq = seq
+	return msg, true, nil
+}
+
+// Remove 移除已送出的訊息
+func (q *OfflineQueue) Remove(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.removeLocked(seq)
+}
+
+// removeLocked 刪除訊息檔案並喚醒等待空間的發布者
+func (q *OfflineQueue) removeLocked(seq uint64) error {
+	size, exists := q.sizes[seq]
+	if !exists {
+		return nil
+	}
+	if err := os.Remove(q.path(seq)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("刪除離線訊息失敗: %w", err)
+	}
+	delete(q.sizes, seq)
+	q.bytes -= size
+	for i, s := range q.seqs {
+		if s == seq {
+			q.seqs = append(q.seqs[:i], q.seqs[i+1:]...)
+			break
+		}
+	}
+	q.space.Broadcast()
+	return nil
+}
+
+// Len 返回佇列中的訊息數
+func (q *OfflineQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.seqs)
+}
+
+// Dropped 返回累計丟棄的訊息數
+func (q *OfflineQueue) Dropped() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// Close 關閉佇列；未送出的訊息保留在磁碟上，下次啟動時重送
+func (q *OfflineQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.space.Broadcast()
+	q.mu.Unlock()
+}
+
+func (q *OfflineQueue) path(seq uint64) string {
+	return filepath.Join(q.config.Dir, fmt.Sprintf("%020d%s", seq, offlineMessageExt))
+}
+
+// writeFileAtomic 先寫入暫存檔再改名，避免中斷時留下不完整的訊息
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + offlineTempExt
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,237 @@
+package mqtt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drainTestQueue 依序取出佇列中所有訊息的 payload
+func drainTestQueue(t *testing.T, q *OfflineQueue) []string {
+	t.Helper()
+	var payloads []string
+	for {
+		msg, ok, err := q.Peek()
+		require.NoError(t, err)
+		if !ok {
+			return payloads
+		}
+		payloads = append(payloads, string(msg.Payload))
+		require.NoError(t, q.Remove(msg.Seq))
+	}
+}
+
+func TestOfflineQueuePersistsInOrder(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewOfflineQueue(OfflineQueueConfig{Dir: dir})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := q.Enqueue(QueuedMessage{Topic: "devices/esp32-01/telemetry", QoS: 1, Payload: []byte(fmt.Sprintf("m%d", i))})
+		require.NoError(t, err)
+	}
+	q.Close()
+	_, err = q.Enqueue(QueuedMessage{Topic: "devices/esp32-01/telemetry", QoS: 1})
+	assert.ErrorIs(t, err, ErrOfflineQueueClosed)
+
+	// 寫入中斷留下的暫存檔在重新開啟時清除
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000099.msg.tmp"), []byte("{"), 0o600))
+
+	reopened, err := NewOfflineQueue(OfflineQueueConfig{Dir: dir})
+	require.NoError(t, err)
+	assert.Equal(t, 3, reopened.Len())
+	_, err = reopened.Enqueue(QueuedMessage{Topic: "devices/esp32-01/telemetry", QoS: 1, Payload: []byte("m3")})
+	require.NoError(t, err)
+
+	msg, ok, err := reopened.Peek()
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "devices/esp32-01/telemetry", msg.Topic)
+	assert.Equal(t, byte(1), msg.QoS)
+	assert.Equal(t, []string{"m0", "m1", "m2", "m3"}, drainTestQueue(t, reopened))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestOfflineQueueDropOldest(t *testing.T) {
+	q, err := NewOfflineQueue(OfflineQueueConfig{Dir: t.TempDir(), MaxMessages: 2})
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		dropped, err := q.Enqueue(QueuedMessage{Topic: "t", QoS: 1, Payload: []byte(fmt.Sprintf("m%d", i))})
+		require.NoError(t, err)
+		assert.Equal(t, i >= 2, dropped == 1)
+	}
+	assert.Equal(t, uint64(2), q.Dropped())
+	assert.Equal(t, []string{"m2", "m3"}, drainTestQueue(t, q))
+}
+
+func TestOfflineQueueBlock(t *testing.T) {
+	q, err := NewOfflineQueue(OfflineQueueConfig{
+		Dir:          t.TempDir(),
+		MaxMessages:  1,
+		Policy:       OverflowBlock,
+		BlockTimeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	_, err = q.Enqueue(QueuedMessage{Topic: "t", QoS: 1, Payload: []byte("m0")})
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = q.Enqueue(QueuedMessage{Topic: "t", QoS: 1, Payload: []byte("m1")})
+	assert.ErrorIs(t, err, ErrOfflineQueueFull)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, uint64(1), q.Dropped())
+
+	// 有空間時被阻塞的發布者繼續寫入
+	q.config.BlockTimeout = 2 * time.Second
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := q.Enqueue(QueuedMessage{Topic: "t", QoS: 1, Payload: []byte("m2")})
+		assert.NoError(t, err)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	msg, ok, err := q.Peek()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, q.Remove(msg.Seq))
+	wg.Wait()
+	assert.Equal(t, []string{"m2"}, drainTestQueue(t, q))
+
+	_, err = NewOfflineQueue(OfflineQueueConfig{Dir: t.TempDir(), Policy: "lifo"})
+	assert.Error(t, err)
+}
+
+func TestOfflineQueueCorruptMessage(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewOfflineQueue(OfflineQueueConfig{Dir: dir})
+	require.NoError(t, err)
+	_, err = q.Enqueue(QueuedMessage{Topic: "t", QoS: 1, Payload: []byte("m0")})
+	require.NoError(t, err)
+	_, err = q.Enqueue(QueuedMessage{Topic: "t", QoS: 1, Payload: []byte("m1")})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(q.path(0), []byte("not json"), 0o600))
+
+	_, _, err = q.Peek()
+	assert.ErrorIs(t, err, ErrOfflineMessageCorrupt)
+	assert.Equal(t, []string{"m1"}, drainTestQueue(t, q))
+}
+
+type testQueueMetrics struct {
+	mu    sync.Mutex
+	depth int
+	drops map[string]int
+}
+
+func (m *testQueueMetrics) SetMQTTOfflineQueueDepth(clientID string, depth int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.depth = depth
+}
+
+func (m *testQueueMetrics) RecordMQTTOfflineQueueDrop(clientID, reason string, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drops[reason] += count
+}
+
+func (m *testQueueMetrics) snapshot() (int, map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	drops := make(map[string]int, len(m.drops))
+	for reason, count := range m.drops {
+		drops[reason] = count
+	}
+	return m.depth, drops
+}
+
+func TestBrokerOfflineQueueReplay(t *testing.T) {
+	server := startTestServer(t, &ServerConfig{})
+	port := server.Port()
+
+	var mu sync.Mutex
+	var received []string
+	subscribe := func(b *Broker) {
+		require.NoError(t, b.Subscribe("devices/esp32-01/telemetry", func(topic string, payload []byte) error {
+			mu.Lock()
+			received = append(received, string(payload))
+			mu.Unlock()
+			return nil
+		}))
+	}
+
+	device, err := connectTestBroker(t, server, &Config{
+		ClientID:         "esp32-01",
+		AutoReconnect:    true,
+		MaxReconnectWait: 100 * time.Millisecond,
+		OfflineQueue:     OfflineQueueConfig{Dir: t.TempDir(), MaxMessages: 3},
+	})
+	require.NoError(t, err)
+	metrics := &testQueueMetrics{drops: make(map[string]int)}
+	device.SetQueueMetrics(metrics)
+
+	// Broker 斷線：QoS 1 訊息寫入離線佇列，QoS 0 訊息直接失敗
+	require.NoError(t, server.Close())
+	require.Eventually(t, func() bool { return !device.IsConnected() }, 2*time.Second, 10*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, device.Publish("devices/esp32-01/telemetry", []byte(fmt.Sprintf("m%d", i)), 1, false))
+	}
+	assert.Error(t, device.Publish("devices/esp32-01/telemetry", []byte("qos0"), 0, false))
+	assert.Equal(t, 3, device.OfflineQueueDepth())
+	depth, drops := metrics.snapshot()
+	assert.Equal(t, 3, depth)
+	assert.Equal(t, map[string]int{"overflow": 1}, drops)
+
+	// 在同一端口重新啟動 Broker，訂閱者就緒後才允許設備重新連線，設備連線後依序重送
+	gate := &gateAuthenticator{blocked: "esp32-01"}
+	restarted := NewServer(&ServerConfig{Addr: fmt.Sprintf("127.0.0.1:%d", port), Authenticator: gate}, testLogger())
+	require.NoError(t, restarted.Start())
+	t.Cleanup(func() { restarted.Close() })
+	subscriber, err := connectTestBroker(t, restarted, &Config{ClientID: "console-2"})
+	require.NoError(t, err)
+	subscribe(subscriber)
+	gate.open()
+
+	require.Eventually(t, func() bool { return device.OfflineQueueDepth() == 0 }, 5*time.Second, 20*time.Millisecond)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"m1", "m2", "m3"}, received)
+	mu.Unlock()
+	depth, _ = metrics.snapshot()
+	assert.Equal(t, 0, depth)
+}
+
+// gateAuthenticator 在 open 之前拒絕指定的客戶端
+type gateAuthenticator struct {
+	blocked string
+	mu      sync.Mutex
+	opened  bool
+}
+
+func (a *gateAuthenticator) Authenticate(creds Credentials) (*Identity, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if creds.ClientID == a.blocked && !a.opened {
+		return nil, ErrBadCredentials
+	}
+	return &Identity{ID: creds.ClientID, Kind: IdentityService, ClientID: creds.ClientID}, nil
+}
+
+func (a *gateAuthenticator) open() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.opened = true
+}
@@ -0,0 +1,552 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/sirupsen/logrus"
+
+	"pandora_box_console_ids_ips/internal/logging"
+)
+
+// errClientIDInUse Client ID 已由其他身分的連線使用
+var errClientIDInUse = errors.New("Client ID 已被使用")
+
+// ServerConfig 內嵌 MQTT Broker 設定
+type ServerConfig struct {
+	// Addr 監聽地址，例如 "127.0.0.1:1883"；端口 0 使用隨機端口
+	Addr string
+	// TLSConfig 設定後使用 TLS；以客戶端憑證驗證設備時設定 ClientAuth 與 ClientCAs
+	TLSConfig *tls.Config
+	// Authenticator 驗證連線；nil 時接受所有連線，身分為帳號（或 Client ID）的服務身分，只適用於開發與測試
+	Authenticator Authenticator
+	// ACL 主題存取控制；nil 時不限制
+	ACL *ACL
+	// Audit 記錄被拒絕的連線與操作
+	Audit *logging.CentralLogger
+	// ConnectTimeout 等待 CONNECT 封包的時間
+	ConnectTimeout time.Duration
+}
+
+// Server 內嵌 MQTT 3.1.1 Broker（QoS 0/1、保留訊息與遺囑訊息，不保存離線會話），
+// 在 Publish 與 Subscribe 時強制執行主題 ACL，讓測試與單機部署不需要 Mosquitto
+type Server struct {
+	config   *ServerConfig
+	logger   *logrus.Logger
+	listener net.Listener
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	retained map[string]*packets.PublishPacket
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// session 單一客戶端連線
+type session struct {
+	server   *Server
+	conn     net.Conn
+	identity *Identity
+
+	writeMu sync.Mutex
+	nextID  uint16
+
+	subsMu sync.RWMutex
+	subs   map[string]byte
+	will   *packets.PublishPacket
+}
+
+// NewServer 創建內嵌 MQTT Broker
+func NewServer(config *ServerConfig, logger *logrus.Logger) *Server {
+	if logger == nil {
+		logger = logrus.New()
+	}
+	if config.Addr == "" {
+		config.Addr = "127.0.0.1:1883"
+	}
+	if config.ConnectTimeout == 0 {
+		config.ConnectTimeout = 10 * time.Second
+	}
+
+	return &Server{
+		config:   config,
+		logger:   logger,
+		sessions: make(map[string]*session),
+		retained: make(map[string]*packets.PublishPacket),
+	}
+}
+
+// Start 開始監聽連線
+func (s *Server) Start() error {
+	var listener net.Listener
+	var err error
+	if s.config.TLSConfig != nil {
+		listener, err = tls.Listen("tcp", s.config.Addr, s.config.TLSConfig)
+	} else {
+		listener, err = net.Listen("tcp", s.config.Addr)
+	}
+	if err != nil {
+		return fmt.Errorf("監聽 MQTT 端口失敗: %w", err)
+	}
+	s.listener = listener
+
+	s.wg.Add(1)
+	go s.acceptLoop()
+
+	s.logger.Infof("內嵌 MQTT Broker 已啟動: %s", listener.Addr())
+	return nil
+}
+
+// ServerTLSConfig 載入 Broker 憑證；設定 clientCAFile 時驗證客戶端憑證，
+// 未送出憑證的客戶端仍可以服務帳號或設備 Token 連線
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("載入 Broker 憑證失敗: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("讀取客戶端 CA 憑證失敗: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("客戶端 CA 憑證 %s 格式無效", clientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// Addr 返回實際監聽的地址
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Port 返回實際監聽的端口
+func (s *Server) Port() int {
+	if addr, ok := s.listener.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+// Close 停止監聽並斷開所有連線
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for _, sess := range sessions {
+		sess.conn.Close()
+	}
+	s.wg.Wait()
+	s.logger.Info("內嵌 MQTT Broker 已停止")
+	return err
+}
+
+// acceptLoop 接受新連線
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Errorf("接受 MQTT 連線失敗: %v", err)
+			continue
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+		}()
+	}
+}
+
+// serve 處理一個連線：驗證 CONNECT 後處理封包直到斷線
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(s.config.ConnectTimeout))
+	var state tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			s.logger.Warnf("MQTT TLS 握手失敗 [%s]: %v", conn.RemoteAddr(), err)
+			return
+		}
+		state = tlsConn.ConnectionState()
+	}
+
+	packet, err := packets.ReadPacket(conn)
+	if err != nil {
+		return
+	}
+	connect, ok := packet.(*packets.ConnectPacket)
+	if !ok {
+		s.logger.Warnf("MQTT 連線 [%s] 的第一個封包不是 CONNECT", conn.RemoteAddr())
+		return
+	}
+	if code := connect.Validate(); code != packets.Accepted {
+		writeConnack(conn, code)
+		return
+	}
+
+	clientID := connect.ClientIdentifier
+	if clientID == "" {
+		clientID = fmt.Sprintf("auto-%d", time.Now().UnixNano())
+	}
+	creds := Credentials{
+		ClientID:         clientID,
+		Username:         connect.Username,
+		Password:         connect.Password,
+		PeerCertificates: state.PeerCertificates,
+		VerifiedChains:   state.VerifiedChains,
+	}
+
+	identity, err := s.authenticate(creds)
+	if err != nil {
+		s.logger.Warnf("MQTT 連線驗證失敗 [%s, client %s]: %v", conn.RemoteAddr(), clientID, err)
+		auditDenied(s.config.Audit, &Identity{ID: connect.Username, ClientID: clientID}, aclConnect, "", err.Error())
+		code := byte(packets.ErrRefusedNotAuthorised)
+		if errors.Is(err, ErrBadCredentials) {
+			code = packets.ErrRefusedBadUsernameOrPassword
+		}
+		writeConnack(conn, code)
+		return
+	}
+
+	sess := &session{server: s, conn: conn, identity: identity, subs: make(map[string]byte)}
+	if connect.WillFlag {
+		will := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		will.TopicName = connect.WillTopic
+		will.Payload = connect.WillMessage
+		will.Qos = connect.WillQos
+		will.Retain = connect.WillRetain
+		sess.will = will
+	}
+	if err := s.register(clientID, sess); err != nil {
+		if errors.Is(err, errClientIDInUse) {
+			s.logger.Warnf("拒絕 MQTT 連線 [%s, client %s]: %v", conn.RemoteAddr(), clientID, err)
+			auditDenied(s.config.Audit, identity, aclConnect, "", err.Error())
+			writeConnack(conn, packets.ErrRefusedIDRejected)
+		}
+		return
+	}
+	defer s.unregister(clientID, sess)
+
+	if err := sess.write(connackPacket(packets.Accepted)); err != nil {
+		return
+	}
+	s.logger.Debugf("MQTT 客戶端已連線: %s (%s %s)", clientID, identity.Kind, identity.ID)
+
+	keepAlive := time.Duration(connect.Keepalive) * time.Second * 3 / 2
+	for {
+		if keepAlive > 0 {
+			conn.SetReadDeadline(time.Now().Add(keepAlive))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.logger.Debugf("MQTT 客戶端 %s 連線中斷: %v", clientID, err)
+			}
+			s.publishWill(sess)
+			return
+		}
+
+		switch p := packet.(type) {
+		case *packets.PublishPacket:
+			err = s.handlePublish(sess, p)
+		case *packets.PubrelPacket:
+			pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			pubcomp.MessageID = p.MessageID
+			err = sess.write(pubcomp)
+		case *packets.PubackPacket, *packets.PubrecPacket, *packets.PubcompPacket:
+			// 只以 QoS 0/1 投遞且不重送，不需要追蹤確認
+		case *packets.SubscribePacket:
+			err = s.handleSubscribe(sess, p)
+		case *packets.UnsubscribePacket:
+			err = s.handleUnsubscribe(sess, p)
+		case *packets.PingreqPacket:
+			err = sess.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		default:
+			s.logger.Warnf("MQTT 客戶端 %s 送出不支援的封包: %s", clientID, packet)
+			s.publishWill(sess)
+			return
+		}
+		if err != nil {
+			s.logger.Debugf("MQTT 客戶端 %s 寫入失敗: %v", clientID, err)
+			return
+		}
+	}
+}
+
+// authenticate 驗證連線；沒有設定 Authenticator 時接受所有連線
+func (s *Server) authenticate(creds Credentials) (*Identity, error) {
+	if s.config.Authenticator == nil {
+		id := creds.Username
+		if id == "" {
+			id = creds.ClientID
+		}
+		return &Identity{ID: id, Kind: IdentityService, ClientID: creds.ClientID, Method: "anonymous"}, nil
+	}
+	return s.config.Authenticator.Authenticate(creds)
+}
+
+// register 登記會話；同一身分以相同 Client ID 重新連線時中斷舊連線，
+// 其他身分使用已連線的 Client ID 則拒絕，避免踢掉其他設備
+func (s *Server) register(clientID string, sess *session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return net.ErrClosed
+	}
+	if old, exists := s.sessions[clientID]; exists {
+		if old.identity.Kind != sess.identity.Kind || old.identity.ID != sess.identity.ID {
+			return fmt.Errorf("%w: %s 已由 %s %s 使用", errClientIDInUse, clientID, old.identity.Kind, old.identity.ID)
+		}
+		old.conn.Close()
+	}
+	s.sessions[clientID] = sess
+	return nil
+}
+
+// DisconnectIdentity 中斷某個身分的所有連線（例如撤銷設備 Token 後），返回中斷數量
+func (s *Server) DisconnectIdentity(kind IdentityKind, id string) int {
+	s.mu.Lock()
+	var conns []net.Conn
+	for _, sess := range s.sessions {
+		if sess.identity.Kind == kind && sess.identity.ID == id {
+			conns = append(conns, sess.conn)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
+
+// unregister 移除會話
+func (s *Server) unregister(clientID string, sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessions[clientID] == sess {
+		delete(s.sessions, clientID)
+	}
+}
+
+// allowed 檢查 ACL，拒絕時記錄稽核日誌
+func (s *Server) allowed(sess *session, action ACLAction, topic string) bool {
+	if s.config.ACL == nil || s.config.ACL.Allowed(sess.identity, action, topic) {
+		return true
+	}
+	s.logger.Warnf("拒絕 MQTT %s [%s %s]: %s", action, sess.identity.Kind, sess.identity.ID, topic)
+	auditDenied(s.config.Audit, sess.identity, action, topic, "topic ACL")
+	return false
+}
+
+// handlePublish 處理 PUBLISH；未授權的訊息不投遞（MQTT 3.1.1 無法回報發布失敗，仍照常確認）
+func (s *Server) handlePublish(sess *session, p *packets.PublishPacket) error {
+	if s.allowed(sess, ACLPublish, p.TopicName) {
+		s.route(p)
+	}
+
+	switch p.Qos {
+	case 1:
+		puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+		puback.MessageID = p.MessageID
+		return sess.write(puback)
+	case 2:
+		pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+		pubrec.MessageID = p.MessageID
+		return sess.write(pubrec)
+	}
+	return nil
+}
+
+// route 投遞訊息給訂閱者並更新保留訊息
+func (s *Server) route(p *packets.PublishPacket) {
+	s.mu.Lock()
+	if p.Retain {
+		if len(p.Payload) == 0 {
+			delete(s.retained, p.TopicName)
+		} else {
+			s.retained[p.TopicName] = p
+		}
+	}
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		if qos, ok := sess.matchQoS(p.TopicName); ok {
+			sess.deliver(p, min(qos, p.Qos), false)
+		}
+	}
+}
+
+// handleSubscribe 處理 SUBSCRIBE；未授權的過濾器回傳 0x80
+func (s *Server) handleSubscribe(sess *session, p *packets.SubscribePacket) error {
+	suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	suback.MessageID = p.MessageID
+
+	var granted []string
+	for i, filter := range p.Topics {
+		if validateTopicFilter(filter) != nil || !s.allowed(sess, ACLSubscribe, filter) {
+			suback.ReturnCodes = append(suback.ReturnCodes, 0x80)
+			continue
+		}
+		qos := min(p.Qoss[i], 1)
+		sess.subsMu.Lock()
+		sess.subs[filter] = qos
+		sess.subsMu.Unlock()
+		suback.ReturnCodes = append(suback.ReturnCodes, qos)
+		granted = append(granted, filter)
+	}
+	if err := sess.write(suback); err != nil {
+		return err
+	}
+
+	// 送出符合的保留訊息
+	s.mu.Lock()
+	var retained []*packets.PublishPacket
+	for topic, msg := range s.retained {
+		for _, filter := range granted {
+			if topicMatches(filter, topic) {
+				retained = append(retained, msg)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+	for _, msg := range retained {
+		if qos, ok := sess.matchQoS(msg.TopicName); ok {
+			sess.deliver(msg, min(qos, msg.Qos), true)
+		}
+	}
+	return nil
+}
+
+// handleUnsubscribe 處理 UNSUBSCRIBE
+func (s *Server) handleUnsubscribe(sess *session, p *packets.UnsubscribePacket) error {
+	sess.subsMu.Lock()
+	for _, filter := range p.Topics {
+		delete(sess.subs, filter)
+	}
+	sess.subsMu.Unlock()
+
+	unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+	unsuback.MessageID = p.MessageID
+	return sess.write(unsuback)
+}
+
+// publishWill 連線異常中斷時發布遺囑訊息
+func (s *Server) publishWill(sess *session) {
+	if sess.will == nil {
+		return
+	}
+	if s.allowed(sess, ACLPublish, sess.will.TopicName) {
+		s.route(sess.will)
+	}
+}
+
+// matchQoS 返回符合主題的訂閱中最高的 QoS
+func (sess *session) matchQoS(topic string) (byte, bool) {
+	sess.subsMu.RLock()
+	defer sess.subsMu.RUnlock()
+
+	matched := false
+	var qos byte
+	for filter, q := range sess.subs {
+		if topicMatches(filter, topic) {
+			matched = true
+			qos = max(qos, q)
+		}
+	}
+	return qos, matched
+}
+
+// deliver 以指定 QoS 送出訊息
+func (sess *session) deliver(p *packets.PublishPacket, qos byte, retain bool) {
+	out := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	out.TopicName = p.TopicName
+	out.Payload = p.Payload
+	out.Qos = qos
+	out.Retain = retain
+
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	if qos > 0 {
+		sess.nextID++
+		if sess.nextID == 0 {
+			sess.nextID = 1
+		}
+		out.MessageID = sess.nextID
+	}
+	if err := sess.writeLocked(out); err != nil {
+		sess.server.logger.Debugf("投遞 MQTT 訊息給 %s 失敗: %v", sess.identity.ClientID, err)
+	}
+}
+
+// write 送出封包
+func (sess *session) write(p packets.ControlPacket) error {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	return sess.writeLocked(p)
+}
+
+func (sess *session) writeLocked(p packets.ControlPacket) error {
+	sess.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return p.Write(sess.conn)
+}
+
+// connackPacket 建立 CONNACK 封包
+func connackPacket(code byte) *packets.ConnackPacket {
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	connack.ReturnCode = code
+	return connack
+}
+
+// writeConnack 送出拒絕連線的 CONNACK
+func writeConnack(conn net.Conn, code byte) {
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	connackPacket(code).Write(conn)
+}
@@ -0,0 +1,393 @@
+package mqtt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"pandora_box_console_ids_ips/internal/logging"
+	"pandora_box_console_ids_ips/internal/token"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	return logger
+}
+
+// startTestServer 啟動使用隨機端口的內嵌 Broker
+func startTestServer(t *testing.T, config *ServerConfig) *Server {
+	t.Helper()
+	config.Addr = "127.0.0.1:0"
+	server := NewServer(config, testLogger())
+	require.NoError(t, server.Start())
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// connectTestBroker 以客戶端連線到內嵌 Broker
+func connectTestBroker(t *testing.T, server *Server, config *Config) (*Broker, error) {
+	t.Helper()
+	config.Broker = "127.0.0.1"
+	config.Port = server.Port()
+	config.DefaultQoS = 1
+	config.ConnectTimeout = 5 * time.Second
+	broker, err := NewBroker(config, testLogger())
+	require.NoError(t, err)
+	if err := broker.Start(); err != nil {
+		return nil, err
+	}
+	t.Cleanup(broker.Stop)
+	return broker, nil
+}
+
+func TestACLAllowed(t *testing.T) {
+	acl, err := NewACL(append(DefaultDeviceRules(),
+		ACLRule{Kind: IdentityService, ID: "console", Topic: "devices/+/telemetry", Subscribe: true},
+		ACLRule{Kind: IdentityService, ID: "console", Topic: "devices/+/commands", Publish: true},
+	))
+	require.NoError(t, err)
+
+	device := &Identity{ID: "esp32-01", Kind: IdentityDevice}
+	console := &Identity{ID: "console", Kind: IdentityService}
+	tests := []struct {
+		identity *Identity
+		action   ACLAction
+		topic    string
+		want     bool
+	}{
+		{device, ACLPublish, "devices/esp32-01/telemetry", true},
+		{device, ACLPublish, "devices/esp32-01", true},
+		{device, ACLSubscribe, "devices/esp32-01/commands/#", true},
+		{device, ACLSubscribe, "devices/esp32-01/+", true},
+		{device, ACLPublish, "devices/esp32-02/telemetry", false},
+		{device, ACLSubscribe, "devices/+/telemetry", false},
+		{device, ACLSubscribe, "devices/#", false},
+		{device, ACLSubscribe, "#", false},
+		{device, ACLPublish, "devices/esp32-01/#", false},
+		{&Identity{ID: "+", Kind: IdentityDevice}, ACLSubscribe, "devices/+/telemetry", false},
+		{&Identity{ID: "esp32-01", Kind: IdentityService}, ACLPublish, "devices/esp32-01/telemetry", false},
+		{console, ACLSubscribe, "devices/+/telemetry", true},
+		{console, ACLSubscribe, "devices/esp32-01/telemetry", true},
+		{console, ACLSubscribe, "devices/#", false},
+		{console, ACLPublish, "devices/esp32-01/commands", true},
+		{console, ACLPublish, "devices/esp32-01/telemetry", false},
+		{&Identity{ID: "agent", Kind: IdentityService}, ACLSubscribe, "devices/+/telemetry", false},
+		{nil, ACLPublish, "devices/esp32-01/telemetry", false},
+	}
+	for _, tt := range tests {
+		id := "<nil>"
+		if tt.identity != nil {
+			id = string(tt.identity.Kind) + ":" + tt.identity.ID
+		}
+		assert.Equal(t, tt.want, acl.Allowed(tt.identity, tt.action, tt.topic), "%s %s %s", id, tt.action, tt.topic)
+	}
+
+	_, err = NewACL([]ACLRule{{Topic: "devices/#/x"}})
+	assert.Error(t, err)
+	_, err = NewACL([]ACLRule{{Kind: "robot", Topic: "#"}})
+	assert.Error(t, err)
+}
+
+func TestServerEnforcesDeviceACL(t *testing.T) {
+	tokens := token.NewAuth(testLogger())
+	authenticator := NewDeviceAuthenticator(tokens)
+	authenticator.AddService("console", "console-secret")
+	acl, err := NewACL(append(DefaultDeviceRules(),
+		ACLRule{Kind: IdentityService, ID: "console", Topic: "devices/#", Publish: true, Subscribe: true},
+	))
+	require.NoError(t, err)
+	audit := logging.NewCentralLogger()
+	t.Cleanup(audit.Stop)
+	server := startTestServer(t, &ServerConfig{Authenticator: authenticator, ACL: acl, Audit: audit})
+
+	deviceToken, err := tokens.IssueDeviceToken("esp32-01", time.Hour)
+	require.NoError(t, err)
+	otherToken, err := tokens.IssueDeviceToken("esp32-02", time.Hour)
+	require.NoError(t, err)
+
+	console, err := connectTestBroker(t, server, &Config{ClientID: "console", Username: "console", Password: "console-secret"})
+	require.NoError(t, err)
+	received := make(chan string, 10)
+	require.NoError(t, console.Subscribe("devices/+/telemetry", func(topic string, payload []byte) error {
+		received <- topic + " " + string(payload)
+		return nil
+	}))
+
+	device, err := connectTestBroker(t, server, &Config{ClientID: "esp32-01", Username: "esp32-01", Password: deviceToken})
+	require.NoError(t, err)
+	require.NoError(t, device.Publish("devices/esp32-01/telemetry", []byte(`{"temp":21}`), 1, false))
+	select {
+	case message := <-received:
+		assert.Equal(t, `devices/esp32-01/telemetry {"temp":21}`, message)
+	case <-time.After(2 * time.Second):
+		t.Fatal("未收到設備訊息")
+	}
+
+	// 冒用其他設備的主題：Broker 丟棄並記錄稽核日誌
+	require.NoError(t, device.Publish("devices/esp32-02/telemetry", []byte(`{"temp":99}`), 1, false))
+	err = device.Subscribe("devices/#", func(topic string, payload []byte) error { return nil })
+	assert.ErrorIs(t, err, ErrNotAuthorized)
+	require.NoError(t, device.Subscribe("devices/esp32-01/commands", func(topic string, payload []byte) error { return nil }))
+	select {
+	case message := <-received:
+		t.Fatalf("未授權的訊息不應投遞: %s", message)
+	case <-time.After(100 * time.Millisecond):
+	}
+	require.Eventually(t, func() bool { return audit.GetStats().SecurityEvents == 2 }, 2*time.Second, 10*time.Millisecond)
+
+	// Token 綁定設備：不能以其他設備 ID 使用
+	_, err = connectTestBroker(t, server, &Config{ClientID: "esp32-02", Username: "esp32-02", Password: deviceToken})
+	assert.Error(t, err)
+	_, err = connectTestBroker(t, server, &Config{ClientID: "esp32-02", Username: "esp32-02", Password: otherToken})
+	assert.NoError(t, err)
+	_, err = tokens.RevokeDeviceTokens("esp32-02")
+	require.NoError(t, err)
+	_, err = connectTestBroker(t, server, &Config{ClientID: "esp32-02b", Username: "esp32-02", Password: otherToken})
+	assert.Error(t, err)
+	_, err = connectTestBroker(t, server, &Config{ClientID: "console-2", Username: "console", Password: "wrong"})
+	assert.Error(t, err)
+	// paho 在 MQTT 3.1.1 被拒絕後會以 3.1 重試，每次拒絕都會記錄
+	require.Eventually(t, func() bool { return audit.GetStats().SecurityEvents >= 5 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestBrokerClientACL(t *testing.T) {
+	server := startTestServer(t, &ServerConfig{})
+	audit := logging.NewCentralLogger()
+	t.Cleanup(audit.Stop)
+
+	agent, err := connectTestBroker(t, server, &Config{
+		ClientID: "agent",
+		Username: "agent",
+		ACL: []ACLRule{
+			{Kind: IdentityService, ID: "agent", Topic: "pandora/agent/#", Publish: true, Subscribe: true},
+		},
+	})
+	require.NoError(t, err)
+	agent.SetAuditLogger(audit)
+	assert.Equal(t, Identity{ID: "agent", Kind: IdentityService, ClientID: "agent"}, agent.Identity())
+
+	received := make(chan string, 1)
+	require.NoError(t, agent.Subscribe("pandora/agent/+/status", func(topic string, payload []byte) error {
+		received <- topic
+		return nil
+	}))
+	require.NoError(t, agent.Publish("pandora/agent/a1/status", []byte("ok"), 1, false))
+	select {
+	case topic := <-received:
+		assert.Equal(t, "pandora/agent/a1/status", topic)
+	case <-time.After(2 * time.Second):
+		t.Fatal("未收到萬用字元訂閱的訊息")
+	}
+
+	assert.ErrorIs(t, agent.Publish("devices/esp32-01/commands", []byte("reboot"), 1, false), ErrNotAuthorized)
+	assert.ErrorIs(t, agent.Subscribe("#", func(topic string, payload []byte) error { return nil }), ErrNotAuthorized)
+	require.Eventually(t, func() bool { return audit.GetStats().SecurityEvents == 2 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServerRetainedMessages(t *testing.T) {
+	server := startTestServer(t, &ServerConfig{})
+
+	device, err := connectTestBroker(t, server, &Config{ClientID: "esp32-01"})
+	require.NoError(t, err)
+	require.NoError(t, device.Publish("devices/esp32-01/status", []byte("online"), 1, true))
+
+	console, err := connectTestBroker(t, server, &Config{ClientID: "console"})
+	require.NoError(t, err)
+	received := make(chan string, 1)
+	require.NoError(t, console.Subscribe("devices/+/status", func(topic string, payload []byte) error {
+		received <- string(payload)
+		return nil
+	}))
+	select {
+	case payload := <-received:
+		assert.Equal(t, "online", payload)
+	case <-time.After(2 * time.Second):
+		t.Fatal("未收到保留訊息")
+	}
+}
+
+func TestServerCertificateAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey := newTestCertificate(t, "pandora-ca", nil, nil)
+	serverCert, serverKey := newTestCertificate(t, "127.0.0.1", caCert, caKey)
+	deviceCert, deviceKey := newTestCertificate(t, "arduino-07", caCert, caKey)
+	writeTestPEM(t, filepath.Join(dir, "ca.crt"), "CERTIFICATE", caCert.Raw)
+	writeTestPEM(t, filepath.Join(dir, "client.crt"), "CERTIFICATE", deviceCert.Raw)
+	keyDER, err := x509.MarshalECPrivateKey(deviceKey)
+	require.NoError(t, err)
+	writeTestPEM(t, filepath.Join(dir, "client.key"), "EC PRIVATE KEY", keyDER)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	server := startTestServer(t, &ServerConfig{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+			MinVersion:   tls.VersionTLS12,
+		},
+		Authenticator: NewDeviceAuthenticator(nil),
+		ACL:           mustACL(t, DefaultDeviceRules()),
+	})
+
+	device, err := connectTestBroker(t, server, &Config{
+		ClientID:   "arduino-07",
+		TLSEnabled: true,
+		CACert:     filepath.Join(dir, "ca.crt"),
+		ClientCert: filepath.Join(dir, "client.crt"),
+		ClientKey:  filepath.Join(dir, "client.key"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, device.Subscribe("devices/arduino-07/commands", func(topic string, payload []byte) error { return nil }))
+	assert.ErrorIs(t, device.Subscribe("devices/esp32-01/commands", func(topic string, payload []byte) error { return nil }), ErrNotAuthorized)
+
+	// 沒有客戶端憑證無法完成 TLS 握手
+	_, err = connectTestBroker(t, server, &Config{ClientID: "anonymous", TLSEnabled: true, CACert: filepath.Join(dir, "ca.crt")})
+	assert.Error(t, err)
+}
+
+func TestServerRejectsUnverifiedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey := newTestCertificate(t, "pandora-ca", nil, nil)
+	serverCert, serverKey := newTestCertificate(t, "127.0.0.1", caCert, caKey)
+	writeTestPEM(t, filepath.Join(dir, "ca.crt"), "CERTIFICATE", caCert.Raw)
+
+	// 自簽憑證冒用 arduino-07 的 CN
+	forgedCA, forgedCAKey := newTestCertificate(t, "forged-ca", nil, nil)
+	forgedCert, forgedKey := newTestCertificate(t, "arduino-07", forgedCA, forgedCAKey)
+	writeTestPEM(t, filepath.Join(dir, "forged.crt"), "CERTIFICATE", forgedCert.Raw)
+	keyDER, err := x509.MarshalECPrivateKey(forgedKey)
+	require.NoError(t, err)
+	writeTestPEM(t, filepath.Join(dir, "forged.key"), "EC PRIVATE KEY", keyDER)
+
+	// RequireAnyClientCert 接受任何憑證但不驗證憑證鏈
+	audit := logging.NewCentralLogger()
+	t.Cleanup(audit.Stop)
+	server := startTestServer(t, &ServerConfig{
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+			ClientAuth:   tls.RequireAnyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		},
+		Authenticator: NewDeviceAuthenticator(nil),
+		ACL:           mustACL(t, DefaultDeviceRules()),
+		Audit:         audit,
+	})
+
+	_, err = connectTestBroker(t, server, &Config{
+		ClientID:   "arduino-07",
+		TLSEnabled: true,
+		CACert:     filepath.Join(dir, "ca.crt"),
+		ClientCert: filepath.Join(dir, "forged.crt"),
+		ClientKey:  filepath.Join(dir, "forged.key"),
+	})
+	assert.Error(t, err)
+	require.Eventually(t, func() bool { return audit.GetStats().SecurityEvents >= 1 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServerRejectsClientIDTakeover(t *testing.T) {
+	tokens := token.NewAuth(testLogger())
+	authenticator := NewDeviceAuthenticator(tokens)
+	server := startTestServer(t, &ServerConfig{Authenticator: authenticator, ACL: mustACL(t, DefaultDeviceRules())})
+
+	victimToken, err := tokens.IssueDeviceToken("esp32-01", time.Hour)
+	require.NoError(t, err)
+	attackerToken, err := tokens.IssueDeviceToken("esp32-66", time.Hour)
+	require.NoError(t, err)
+
+	victim, err := connectTestBroker(t, server, &Config{ClientID: "esp32-01", Username: "esp32-01", Password: victimToken})
+	require.NoError(t, err)
+
+	// 其他身分不能以相同 Client ID 中斷既有連線
+	_, err = connectTestBroker(t, server, &Config{ClientID: "esp32-01", Username: "esp32-66", Password: attackerToken})
+	assert.Error(t, err)
+	assert.True(t, victim.IsConnected())
+	require.NoError(t, victim.Subscribe("devices/esp32-01/commands", func(topic string, payload []byte) error { return nil }))
+
+	// 同一設備重新連線取代舊連線
+	_, err = connectTestBroker(t, server, &Config{ClientID: "esp32-01", Username: "esp32-01", Password: victimToken})
+	assert.NoError(t, err)
+}
+
+func mustACL(t *testing.T, rules []ACLRule) *ACL {
+	acl, err := NewACL(rules)
+	require.NoError(t, err)
+	return acl
+}
+
+// newTestCertificate 建立測試憑證；parent 為 nil 時建立自簽 CA
+func newTestCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(commonName); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func writeTestPEM(t *testing.T, path, blockType string, der []byte) {
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+}
+
+func TestServerPersistedDeviceTokens(t *testing.T) {
+	store := token.NewFileDeviceTokenStore(filepath.Join(t.TempDir(), "device-tokens.json"))
+	issuer := token.NewAuth(testLogger())
+	require.NoError(t, issuer.SetDeviceTokenStore(store))
+	deviceToken, err := issuer.IssueDeviceToken("esp32-01", time.Hour)
+	require.NoError(t, err)
+
+	// 重新啟動後從 store 載入 Token
+	tokens := token.NewAuth(testLogger())
+	require.NoError(t, tokens.SetDeviceTokenStore(store))
+	server := startTestServer(t, &ServerConfig{Authenticator: NewDeviceAuthenticator(tokens), ACL: mustACL(t, DefaultDeviceRules())})
+	device, err := connectTestBroker(t, server, &Config{ClientID: "esp32-01", Username: "esp32-01", Password: deviceToken})
+	require.NoError(t, err)
+
+	// 撤銷後中斷現有連線，重新載入後也不再有效
+	revoked, err := tokens.RevokeDeviceTokens("esp32-01")
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	assert.Equal(t, 1, server.DisconnectIdentity(IdentityDevice, "esp32-01"))
+	require.Eventually(t, func() bool { return !device.IsConnected() }, 2*time.Second, 10*time.Millisecond)
+
+	reloaded := token.NewAuth(testLogger())
+	require.NoError(t, reloaded.SetDeviceTokenStore(store))
+	assert.False(t, reloaded.VerifyDeviceToken("esp32-01", deviceToken))
+}
@@ -0,0 +1,58 @@
+package multitenant
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoTenantContext is returned when a tenant-scoped operation runs without a tenant
+var ErrNoTenantContext = errors.New("no tenant in context")
+
+type tenantContextKey struct{}
+
+type systemContextKey struct{}
+
+type scopeContextKey struct{}
+
+// WithTenant returns a context carrying the tenant
+func WithTenant(ctx context.Context, tenant *Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant carried by the context
+func TenantFromContext(ctx context.Context) (*Tenant, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tenant, ok := ctx.Value(tenantContextKey{}).(*Tenant)
+	return tenant, ok && tenant != nil
+}
+
+// WithSystemScope marks the context as a system operation that is not bound to a tenant
+// (tenant catalog, migrations). 系統操作不受租戶查詢限制
+func WithSystemScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemContextKey{}, true)
+}
+
+// isSystemScope reports whether the context was marked by WithSystemScope
+func isSystemScope(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	system, _ := ctx.Value(systemContextKey{}).(bool)
+	return system
+}
+
+// withTenantScope records that the tenant's schema or connection pool has been selected
+func withTenantScope(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, scopeContextKey{}, tenantID)
+}
+
+// tenantScope returns the tenant whose schema or connection pool the context is bound to
+func tenantScope(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	tenantID, ok := ctx.Value(scopeContextKey{}).(string)
+	return tenantID, ok
+}
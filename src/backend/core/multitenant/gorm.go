@@ -0,0 +1,207 @@
+package multitenant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// ConnectFunc opens the connection pool of a per-tenant database (IsolationSeparateDB, IsolationDedicatedHost)
+type ConnectFunc func(tenant *Tenant, database string) (*gorm.DB, error)
+
+// TenantPlugin is a GORM plugin that rejects every statement that is not bound to a tenant.
+// A statement is bound when its context comes from TenantRouter.WithTenantDB; WithSystemScope
+// opts out for tenant-independent tables
+type TenantPlugin struct {
+	// tenantID restricts a per-tenant connection pool to its own tenant; empty for the shared pool
+	tenantID string
+}
+
+// NewTenantPlugin creates the plugin for the shared connection pool
+func NewTenantPlugin() *TenantPlugin {
+	return &TenantPlugin{}
+}
+
+// Name implements gorm.Plugin
+func (p *TenantPlugin) Name() string {
+	return "multitenant:enforce"
+}
+
+// Initialize implements gorm.Plugin
+func (p *TenantPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	registrations := []struct {
+		name     string
+		register func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register},
+	}
+	for _, registration := range registrations {
+		if err := registration.register("multitenant:enforce_"+registration.name, p.enforce); err != nil {
+			return fmt.Errorf("failed to register tenant callback %s: %w", registration.name, err)
+		}
+	}
+	return nil
+}
+
+// enforce rejects statements without a tenant scope
+func (p *TenantPlugin) enforce(db *gorm.DB) {
+	ctx := db.Statement.Context
+	if isSystemScope(ctx) {
+		return
+	}
+	tenant, ok := TenantFromContext(ctx)
+	scope, scoped := tenantScope(ctx)
+	switch {
+	case !ok:
+		db.AddError(ErrNoTenantContext)
+	case !scoped || scope != tenant.ID:
+		db.AddError(fmt.Errorf("%w: statement for tenant %s is not routed through its schema", ErrNoTenantContext, tenant.ID))
+	case p.tenantID != "" && p.tenantID != tenant.ID:
+		db.AddError(fmt.Errorf("%w: tenant %s cannot use the database of tenant %s", ErrNoTenantContext, tenant.ID, p.tenantID))
+	}
+}
+
+// TenantRouter routes tenant queries: IsolationSharedDB sets search_path to the tenant schema,
+// IsolationSeparateDB and IsolationDedicatedHost use a per-tenant connection pool
+type TenantRouter struct {
+	manager *TenantManager
+	shared  *gorm.DB
+	connect ConnectFunc
+	pools   map[string]*gorm.DB
+	mu      sync.Mutex
+}
+
+// NewTenantRouter creates a router and installs TenantPlugin on the shared connection pool.
+// connect is required unless the isolation level is IsolationSharedDB
+func NewTenantRouter(manager *TenantManager, shared *gorm.DB, connect ConnectFunc) (*TenantRouter, error) {
+	if manager == nil || shared == nil {
+		return nil, fmt.Errorf("tenant manager and shared database are required")
+	}
+	if manager.Isolation() != IsolationSharedDB && connect == nil {
+		return nil, fmt.Errorf("isolation level %s requires a connect function", manager.Isolation())
+	}
+	if err := shared.Use(NewTenantPlugin()); err != nil {
+		return nil, fmt.Errorf("failed to install tenant plugin: %w", err)
+	}
+
+	return &TenantRouter{
+		manager: manager,
+		shared:  shared,
+		connect: connect,
+		pools:   make(map[string]*gorm.DB),
+	}, nil
+}
+
+// WithTenantDB runs fn against the database of the tenant carried by ctx.
+// For IsolationSharedDB fn runs in a transaction whose search_path is the tenant schema
+func (r *TenantRouter) WithTenantDB(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return ErrNoTenantContext
+	}
+	if tenant.Status != TenantStatusActive {
+		return fmt.Errorf("tenant %s is %s", tenant.ID, tenant.Status)
+	}
+	return r.withTenant(ctx, tenant, fn)
+}
+
+// withTenant runs fn against the database of tenant regardless of its status (used by TenantLifecycle)
+func (r *TenantRouter) withTenant(ctx context.Context, tenant *Tenant, fn func(tx *gorm.DB) error) error {
+	ctx = withTenantScope(WithTenant(ctx, tenant), tenant.ID)
+
+	if r.manager.Isolation() == IsolationSharedDB {
+		schema := r.manager.GetSchemaName(tenant.ID)
+		return r.shared.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			// SET LOCAL only lasts until the end of the transaction, so the pooled connection is not affected
+			if err := tx.Exec(searchPathSQL(schema)).Error; err != nil {
+				return fmt.Errorf("failed to set search_path for tenant %s: %w", tenant.ID, err)
+			}
+			return fn(tx)
+		})
+	}
+
+	pool, err := r.pool(tenant)
+	if err != nil {
+		return err
+	}
+	return fn(pool.WithContext(ctx))
+}
+
+// pool returns the per-tenant connection pool, opening it on first use
+func (r *TenantRouter) pool(tenant *Tenant) (*gorm.DB, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if pool, exists := r.pools[tenant.ID]; exists {
+		return pool, nil
+	}
+
+	database := r.manager.GetDatabaseName(tenant.ID)
+	pool, err := r.connect(tenant, database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database %s: %w", database, err)
+	}
+	if err := pool.Use(&TenantPlugin{tenantID: tenant.ID}); err != nil {
+		return nil, fmt.Errorf("failed to install tenant plugin: %w", err)
+	}
+	r.pools[tenant.ID] = pool
+
+	r.manager.logger.Infof("Opened connection pool for tenant %s (%s)", tenant.ID, database)
+	return pool, nil
+}
+
+// ClosePool closes the connection pool of a tenant (for example after suspension)
+func (r *TenantRouter) ClosePool(tenantID string) error {
+	r.mu.Lock()
+	pool, exists := r.pools[tenantID]
+	delete(r.pools, tenantID)
+	r.mu.Unlock()
+
+	if !exists {
+		return nil
+	}
+	return closeGormDB(pool)
+}
+
+// Close closes all per-tenant connection pools; the shared pool belongs to the caller
+func (r *TenantRouter) Close() error {
+	r.mu.Lock()
+	pools := r.pools
+	r.pools = make(map[string]*gorm.DB)
+	r.mu.Unlock()
+
+	var firstErr error
+	for _, pool := range pools {
+		if err := closeGormDB(pool); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func closeGormDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// searchPathSQL builds the statement that switches to the tenant schema
+func searchPathSQL(schema string) string {
+	return fmt.Sprintf("SET LOCAL search_path TO %s, public", quoteIdentifier(schema))
+}
+
+// quoteIdentifier quotes a PostgreSQL identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,546 @@
+package multitenant
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LifecycleOperation is a tenant lifecycle workflow
+type LifecycleOperation string
+
+const (
+	OperationProvision LifecycleOperation = "provision"
+	OperationSuspend   LifecycleOperation = "suspend"
+	OperationDelete    LifecycleOperation = "delete"
+)
+
+// LifecycleStatus is the status of a lifecycle workflow
+type LifecycleStatus string
+
+const (
+	LifecycleRunning   LifecycleStatus = "running"
+	LifecycleWaiting   LifecycleStatus = "waiting" // deletion waiting for the grace period
+	LifecycleFailed    LifecycleStatus = "failed"  // retried by Resume
+	LifecycleCompleted LifecycleStatus = "completed"
+	LifecycleCancelled LifecycleStatus = "cancelled"
+)
+
+var (
+	// ErrLifecycleInProgress is returned when another workflow of the tenant has not finished
+	ErrLifecycleInProgress = errors.New("tenant lifecycle operation in progress")
+	// errLifecycleWaiting is returned by a step that cannot complete yet
+	errLifecycleWaiting = errors.New("lifecycle step waiting")
+)
+
+// LifecycleState is the persisted progress of a tenant workflow
+type LifecycleState struct {
+	TenantID  string             `json:"tenant_id"`
+	Operation LifecycleOperation `json:"operation"`
+	Status    LifecycleStatus    `json:"status"`
+	// Step is the last completed step; the workflow continues after it
+	Step       string    `json:"step"`
+	Reason     string    `json:"reason,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ExportPath string    `json:"export_path,omitempty"`
+	PurgeAfter time.Time `json:"purge_after,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Done reports whether the workflow has finished
+func (s *LifecycleState) Done() bool {
+	return s.Status == LifecycleCompleted || s.Status == LifecycleCancelled
+}
+
+// LifecycleConfig configures tenant lifecycle workflows
+type LifecycleConfig struct {
+	// GracePeriod time between a deletion request and the purge of the tenant data (default 30 days)
+	GracePeriod time.Duration `yaml:"grace_period" json:"grace_period"`
+	// ExportDir directory the data of deleted tenants is exported to before the purge
+	ExportDir string `yaml:"export_dir" json:"export_dir"`
+	// ResumeInterval how often Start resumes unfinished workflows (default 1m)
+	ResumeInterval time.Duration `yaml:"resume_interval" json:"resume_interval"`
+}
+
+// lifecycleStep one idempotent step of a workflow
+type lifecycleStep struct {
+	name string
+	run  func(ctx context.Context, state *LifecycleState) error
+}
+
+// TenantLifecycle runs the provisioning, suspension and deletion workflows of tenants.
+// Progress is recorded after every step, so a workflow interrupted by a crash or an error
+// continues from the failed step when it is started again or when Resume runs
+type TenantLifecycle struct {
+	manager  *TenantManager
+	database TenantDatabase
+	config   LifecycleConfig
+	logger   *logrus.Logger
+
+	// states latest workflow per tenant; mu also serialises workflow runs
+	states map[string]*LifecycleState
+	mu     sync.Mutex
+}
+
+// NewTenantLifecycle creates the lifecycle manager and loads recorded workflows from the tenant store
+func NewTenantLifecycle(ctx context.Context, manager *TenantManager, database TenantDatabase, config LifecycleConfig) (*TenantLifecycle, error) {
+	if manager == nil || database == nil {
+		return nil, fmt.Errorf("tenant manager and tenant database are required")
+	}
+	if config.GracePeriod <= 0 {
+		config.GracePeriod = 30 * 24 * time.Hour
+	}
+	if config.ResumeInterval <= 0 {
+		config.ResumeInterval = time.Minute
+	}
+
+	l := &TenantLifecycle{
+		manager:  manager,
+		database: database,
+		config:   config,
+		logger:   manager.logger,
+		states:   make(map[string]*LifecycleState),
+	}
+	if manager.store != nil {
+		states, err := manager.store.LoadLifecycleStates(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, state := range states {
+			l.states[state.TenantID] = state
+		}
+	}
+	return l, nil
+}
+
+// Provision registers a tenant, creates its schema or database, runs the migrations and activates it.
+// Calling it again for a tenant that is still provisioning resumes the workflow
+func (l *TenantLifecycle) Provision(ctx context.Context, tenant *Tenant) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	existing, err := l.manager.GetTenant(ctx, tenant.ID)
+	switch {
+	case err != nil:
+		if err := l.manager.createTenant(ctx, tenant, TenantStatusProvisioning); err != nil {
+			return err
+		}
+	case existing.Status != TenantStatusProvisioning:
+		return fmt.Errorf("tenant %s already exists", tenant.ID)
+	}
+
+	state, err := l.begin(ctx, tenant.ID, OperationProvision, "")
+	if err != nil {
+		return err
+	}
+	return l.run(ctx, state)
+}
+
+// Suspend suspends a tenant, revokes its API keys and agents and closes its connections
+func (l *TenantLifecycle) Suspend(ctx context.Context, tenantID, reason string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.manager.GetTenant(ctx, tenantID); err != nil {
+		return err
+	}
+	state, err := l.begin(ctx, tenantID, OperationSuspend, reason)
+	if err != nil {
+		return err
+	}
+	return l.run(ctx, state)
+}
+
+// Delete blocks a tenant immediately, exports its data and purges it once the grace period has passed.
+// The purge itself happens in Resume; until then CancelDeletion restores the tenant
+func (l *TenantLifecycle) Delete(ctx context.Context, tenantID, reason string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tenant, err := l.manager.GetTenant(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if tenant.Status == TenantStatusDeleted {
+		return fmt.Errorf("tenant %s is already deleted", tenantID)
+	}
+	state, err := l.begin(ctx, tenantID, OperationDelete, reason)
+	if err != nil {
+		return err
+	}
+	return l.run(ctx, state)
+}
+
+// CancelDeletion stops a deletion that has not started purging; the tenant is left suspended
+func (l *TenantLifecycle) CancelDeletion(ctx context.Context, tenantID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, exists := l.states[tenantID]
+	if !exists || state.Operation != OperationDelete || state.Done() {
+		return fmt.Errorf("tenant %s has no pending deletion", tenantID)
+	}
+	if stepIndex(l.steps(OperationDelete), state.Step) >= stepIndex(l.steps(OperationDelete), "grace_period") {
+		return fmt.Errorf("tenant %s is already being purged", tenantID)
+	}
+
+	if err := l.manager.setStatus(ctx, tenantID, TenantStatusSuspended, map[string]string{"suspension_reason": "deletion cancelled"}); err != nil {
+		return err
+	}
+	state.Status = LifecycleCancelled
+	state.Error = ""
+	if err := l.save(ctx, state); err != nil {
+		return err
+	}
+
+	l.logger.Infof("Cancelled deletion of tenant %s", tenantID)
+	return nil
+}
+
+// Resume continues every unfinished workflow: failed steps are retried and deletions whose
+// grace period has passed are purged. Tenants left in provisioning without a recorded workflow are adopted
+func (l *TenantLifecycle) Resume(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tenants, err := l.manager.ListTenants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		if state, exists := l.states[tenant.ID]; tenant.Status == TenantStatusProvisioning && (!exists || state.Done()) {
+			if _, err := l.begin(ctx, tenant.ID, OperationProvision, ""); err != nil {
+				return err
+			}
+		}
+	}
+
+	pending := make([]*LifecycleState, 0)
+	for _, state := range l.states {
+		if !state.Done() {
+			pending = append(pending, state)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].StartedAt.Before(pending[j].StartedAt)
+	})
+
+	var errs []error
+	for _, state := range pending {
+		if err := l.run(ctx, state); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start resumes unfinished workflows until ctx is cancelled
+func (l *TenantLifecycle) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(l.config.ResumeInterval)
+		defer ticker.Stop()
+		for {
+			if err := l.Resume(ctx); err != nil {
+				l.logger.Errorf("Tenant lifecycle: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// State returns a copy of the latest workflow of a tenant
+func (l *TenantLifecycle) State(tenantID string) (LifecycleState, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, exists := l.states[tenantID]
+	if !exists {
+		return LifecycleState{}, false
+	}
+	return *state, true
+}
+
+// begin returns the unfinished workflow of the same operation or records a new one.
+// A deletion supersedes any unfinished workflow; other operations wait for it to finish (caller holds mu)
+func (l *TenantLifecycle) begin(ctx context.Context, tenantID string, operation LifecycleOperation, reason string) (*LifecycleState, error) {
+	if current, exists := l.states[tenantID]; exists && !current.Done() {
+		if current.Operation == operation {
+			return current, nil
+		}
+		if operation != OperationDelete {
+			return nil, fmt.Errorf("%w: %s %s", ErrLifecycleInProgress, tenantID, current.Operation)
+		}
+	}
+
+	now := l.manager.now()
+	state := &LifecycleState{
+		TenantID:  tenantID,
+		Operation: operation,
+		Status:    LifecycleRunning,
+		Reason:    reason,
+		StartedAt: now,
+	}
+	if operation == OperationDelete {
+		state.PurgeAfter = now.Add(l.config.GracePeriod)
+	}
+	if err := l.save(ctx, state); err != nil {
+		return nil, err
+	}
+	l.states[tenantID] = state
+	return state, nil
+}
+
+// run executes the steps after the last completed one, recording progress after each (caller holds mu)
+func (l *TenantLifecycle) run(ctx context.Context, state *LifecycleState) error {
+	steps := l.steps(state.Operation)
+	for _, step := range steps[stepIndex(steps, state.Step)+1:] {
+		err := step.run(ctx, state)
+		if errors.Is(err, errLifecycleWaiting) {
+			if state.Status != LifecycleWaiting {
+				state.Status = LifecycleWaiting
+				state.Error = ""
+				return l.save(ctx, state)
+			}
+			return nil
+		}
+		if err != nil {
+			state.Status = LifecycleFailed
+			state.Error = err.Error()
+			if saveErr := l.save(ctx, state); saveErr != nil {
+				l.logger.Errorf("Failed to record lifecycle state of tenant %s: %v", state.TenantID, saveErr)
+			}
+			return fmt.Errorf("tenant %s %s failed at %s: %w", state.TenantID, state.Operation, step.name, err)
+		}
+
+		state.Step = step.name
+		state.Status = LifecycleRunning
+		state.Error = ""
+		if err := l.save(ctx, state); err != nil {
+			return err
+		}
+	}
+
+	state.Status = LifecycleCompleted
+	if err := l.save(ctx, state); err != nil {
+		return err
+	}
+	l.logger.Infof("Tenant %s %s completed", state.TenantID, state.Operation)
+	return nil
+}
+
+// save records a workflow state in the tenant store
+func (l *TenantLifecycle) save(ctx context.Context, state *LifecycleState) error {
+	state.UpdatedAt = l.manager.now()
+	if l.manager.store == nil {
+		return nil
+	}
+	return l.manager.store.SaveLifecycleState(ctx, state)
+}
+
+// steps returns the steps of an operation in order; every step must be idempotent
+func (l *TenantLifecycle) steps(operation LifecycleOperation) []lifecycleStep {
+	revoke := []lifecycleStep{
+		{"revoke_api_keys", l.revokeAPIKeys},
+		{"revoke_agents", l.revokeAgents},
+		{"close_connections", l.disconnect},
+	}
+
+	switch operation {
+	case OperationProvision:
+		return []lifecycleStep{
+			{"create_database", l.createDatabase},
+			{"migrate", l.migrate},
+			{"activate", l.activate},
+		}
+	case OperationSuspend:
+		return append([]lifecycleStep{{"suspend", l.suspend}}, revoke...)
+	case OperationDelete:
+		return append(append([]lifecycleStep{{"mark_deleting", l.markDeleting}}, revoke...),
+			lifecycleStep{"export", l.export},
+			lifecycleStep{"grace_period", l.waitGracePeriod},
+			lifecycleStep{"purge", l.purge},
+			lifecycleStep{"finalize", l.finalize},
+		)
+	default:
+		return nil
+	}
+}
+
+// stepIndex returns the index of a step, -1 when no step has completed
+func stepIndex(steps []lifecycleStep, name string) int {
+	for i, step := range steps {
+		if step.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *TenantLifecycle) createDatabase(ctx context.Context, state *LifecycleState) error {
+	tenant, err := l.manager.GetTenant(ctx, state.TenantID)
+	if err != nil {
+		return err
+	}
+	return l.database.Create(ctx, tenant)
+}
+
+func (l *TenantLifecycle) migrate(ctx context.Context, state *LifecycleState) error {
+	tenant, err := l.manager.GetTenant(ctx, state.TenantID)
+	if err != nil {
+		return err
+	}
+	return l.database.Migrate(ctx, tenant)
+}
+
+func (l *TenantLifecycle) activate(ctx context.Context, state *LifecycleState) error {
+	if err := l.manager.setStatus(ctx, state.TenantID, TenantStatusActive, nil); err != nil {
+		return err
+	}
+	l.logger.Infof("Provisioned tenant: %s", state.TenantID)
+	return nil
+}
+
+func (l *TenantLifecycle) suspend(ctx context.Context, state *LifecycleState) error {
+	return l.manager.SuspendTenant(ctx, state.TenantID, state.Reason)
+}
+
+func (l *TenantLifecycle) revokeAPIKeys(ctx context.Context, state *LifecycleState) error {
+	_, err := l.manager.RevokeAPIKeys(ctx, state.TenantID)
+	return err
+}
+
+func (l *TenantLifecycle) revokeAgents(ctx context.Context, state *LifecycleState) error {
+	tenant, err := l.manager.GetTenant(ctx, state.TenantID)
+	if err != nil {
+		return err
+	}
+	revoked, err := l.database.RevokeAgents(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	if revoked > 0 {
+		l.logger.Infof("Revoked %d agents for tenant: %s", revoked, state.TenantID)
+	}
+	return nil
+}
+
+func (l *TenantLifecycle) disconnect(ctx context.Context, state *LifecycleState) error {
+	tenant, err := l.manager.GetTenant(ctx, state.TenantID)
+	if err != nil {
+		return err
+	}
+	return l.database.Disconnect(ctx, tenant)
+}
+
+func (l *TenantLifecycle) markDeleting(ctx context.Context, state *LifecycleState) error {
+	metadata := map[string]string{
+		"deletion_reason": state.Reason,
+		"purge_after":     state.PurgeAfter.UTC().Format(time.RFC3339),
+	}
+	if err := l.manager.setStatus(ctx, state.TenantID, TenantStatusDeleting, metadata); err != nil {
+		return err
+	}
+	l.logger.Warnf("Scheduled deletion of tenant %s after %s: %s", state.TenantID, metadata["purge_after"], state.Reason)
+	return nil
+}
+
+// export writes the tenant and all of its rows to a gzip-compressed JSON lines file.
+// The file name is derived from the workflow start so a retried export replaces the partial one
+func (l *TenantLifecycle) export(ctx context.Context, state *LifecycleState) error {
+	if l.config.ExportDir == "" {
+		return fmt.Errorf("export directory is not configured")
+	}
+	tenant, err := l.manager.GetTenant(ctx, state.TenantID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.config.ExportDir, 0o750); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s.jsonl.gz", tenant.ID, state.StartedAt.UTC().Format("20060102T150405Z"))
+	target := filepath.Join(l.config.ExportDir, name)
+	tmp := target + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	writeErr := func() error {
+		gz := gzip.NewWriter(file)
+		header := map[string]interface{}{
+			"tenant":      tenant,
+			"exported_at": l.manager.now().UTC(),
+		}
+		if err := json.NewEncoder(gz).Encode(header); err != nil {
+			return err
+		}
+		if err := l.database.Export(ctx, tenant, gz); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		return file.Sync()
+	}()
+	if closeErr := file.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to export tenant %s: %w", tenant.ID, writeErr)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return fmt.Errorf("failed to export tenant %s: %w", tenant.ID, err)
+	}
+
+	state.ExportPath = target
+	l.logger.Infof("Exported tenant %s to %s", tenant.ID, target)
+	return nil
+}
+
+func (l *TenantLifecycle) waitGracePeriod(ctx context.Context, state *LifecycleState) error {
+	if l.manager.now().Before(state.PurgeAfter) {
+		return errLifecycleWaiting
+	}
+	return nil
+}
+
+func (l *TenantLifecycle) purge(ctx context.Context, state *LifecycleState) error {
+	tenant, err := l.manager.GetTenant(ctx, state.TenantID)
+	if err != nil {
+		return err
+	}
+	if err := l.database.Drop(ctx, tenant); err != nil {
+		return err
+	}
+	l.logger.Warnf("Purged data of tenant %s", state.TenantID)
+	return nil
+}
+
+func (l *TenantLifecycle) finalize(ctx context.Context, state *LifecycleState) error {
+	// 寬限期間可能又發出了 API 金鑰
+	if _, err := l.manager.RevokeAPIKeys(ctx, state.TenantID); err != nil {
+		return err
+	}
+	metadata := map[string]string{"purged_at": l.manager.now().UTC().Format(time.RFC3339)}
+	if err := l.manager.setStatus(ctx, state.TenantID, TenantStatusDeleted, metadata); err != nil {
+		return err
+	}
+	l.logger.Infof("Deleted tenant: %s", state.TenantID)
+	return nil
+}
@@ -0,0 +1,268 @@
+package multitenant
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeTenantDatabase 記錄呼叫次數，並可讓指定方法失敗
+type fakeTenantDatabase struct {
+	mu       sync.Mutex
+	calls    map[string]int
+	failures map[string]int
+}
+
+func newFakeTenantDatabase(failures map[string]int) *fakeTenantDatabase {
+	return &fakeTenantDatabase{calls: make(map[string]int), failures: failures}
+}
+
+func (f *fakeTenantDatabase) call(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[method]++
+	if f.failures[method] > 0 {
+		f.failures[method]--
+		return fmt.Errorf("%s failed", method)
+	}
+	return nil
+}
+
+func (f *fakeTenantDatabase) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *fakeTenantDatabase) Create(ctx context.Context, tenant *Tenant) error {
+	return f.call("Create")
+}
+
+func (f *fakeTenantDatabase) Migrate(ctx context.Context, tenant *Tenant) error {
+	return f.call("Migrate")
+}
+
+func (f *fakeTenantDatabase) RevokeAgents(ctx context.Context, tenant *Tenant) (int, error) {
+	return 0, f.call("RevokeAgents")
+}
+
+func (f *fakeTenantDatabase) Disconnect(ctx context.Context, tenant *Tenant) error {
+	return f.call("Disconnect")
+}
+
+func (f *fakeTenantDatabase) Export(ctx context.Context, tenant *Tenant, w io.Writer) error {
+	return f.call("Export")
+}
+
+func (f *fakeTenantDatabase) Drop(ctx context.Context, tenant *Tenant) error {
+	return f.call("Drop")
+}
+
+func TestLifecycleProvisionResumesAfterFailure(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	tm, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	database := newFakeTenantDatabase(map[string]int{"Migrate": 1})
+	lifecycle, err := NewTenantLifecycle(ctx, tm, database, LifecycleConfig{})
+	require.NoError(t, err)
+
+	err = lifecycle.Provision(ctx, &Tenant{ID: "acme", Name: "Acme", Domain: "acme.example.com"})
+	require.Error(t, err)
+	state, ok := lifecycle.State("acme")
+	require.True(t, ok)
+	assert.Equal(t, LifecycleFailed, state.Status)
+	assert.Equal(t, "create_database", state.Step)
+	assert.Contains(t, state.Error, "Migrate failed")
+	_, err = tm.GetTenantByDomain(ctx, "acme.example.com")
+	assert.Error(t, err, "provisioning tenants cannot be resolved")
+
+	// 模擬重新啟動：從 store 載入並繼續
+	restarted, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	resumed, err := NewTenantLifecycle(ctx, restarted, database, LifecycleConfig{})
+	require.NoError(t, err)
+	require.NoError(t, resumed.Resume(ctx))
+
+	acme, err := restarted.GetTenant(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, TenantStatusActive, acme.Status)
+	assert.Equal(t, 1, database.count("Create"), "completed steps are not repeated")
+	assert.Equal(t, 2, database.count("Migrate"))
+	state, _ = resumed.State("acme")
+	assert.Equal(t, LifecycleCompleted, state.Status)
+	assert.Empty(t, state.Error)
+
+	assert.Error(t, resumed.Provision(ctx, &Tenant{ID: "acme", Name: "Acme"}))
+
+	// 已登記但尚未記錄流程的租戶也會被接手
+	require.NoError(t, restarted.createTenant(ctx, &Tenant{ID: "globex", Name: "Globex"}, TenantStatusProvisioning))
+	require.NoError(t, resumed.Resume(ctx))
+	globex, err := restarted.GetTenant(ctx, "globex")
+	require.NoError(t, err)
+	assert.Equal(t, TenantStatusActive, globex.Status)
+}
+
+func TestLifecycleSuspendRevokesCredentials(t *testing.T) {
+	ctx := context.Background()
+	tm := NewTenantManager(IsolationSharedDB, testLogger())
+	database := newFakeTenantDatabase(map[string]int{"RevokeAgents": 1})
+	lifecycle, err := NewTenantLifecycle(ctx, tm, database, LifecycleConfig{})
+	require.NoError(t, err)
+	require.NoError(t, lifecycle.Provision(ctx, &Tenant{ID: "acme", Name: "Acme"}))
+	key, err := tm.IssueAPIKey(ctx, "acme")
+	require.NoError(t, err)
+
+	assert.Error(t, lifecycle.Suspend(ctx, "acme", "unpaid"))
+	acme, err := tm.GetTenant(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, TenantStatusSuspended, acme.Status)
+	_, err = tm.GetTenantByAPIKey(ctx, key)
+	assert.Error(t, err)
+
+	require.NoError(t, lifecycle.Suspend(ctx, "acme", "unpaid"))
+	assert.Equal(t, 2, database.count("RevokeAgents"))
+	assert.Equal(t, 1, database.count("Disconnect"))
+	assert.Equal(t, "unpaid", acme.Metadata["suspension_reason"])
+
+	// 重新啟用後舊金鑰仍然無效
+	require.NoError(t, tm.ReactivateTenant(ctx, "acme"))
+	_, err = tm.GetTenantByAPIKey(ctx, key)
+	assert.Error(t, err)
+
+	assert.Error(t, lifecycle.Suspend(ctx, "unknown", "unpaid"))
+}
+
+func TestLifecycleDeleteExportsThenPurges(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	tm, err := NewPersistentTenantManager(ctx, IsolationDedicatedHost, store, testLogger())
+	require.NoError(t, err)
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}
+	tm.now = clock.Now
+
+	dir := t.TempDir()
+	router, err := NewTenantRouter(tm, openTestDB(t, fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()+"_shared")), func(tenant *Tenant, database string) (*gorm.DB, error) {
+		return gorm.Open(sqlite.Open(filepath.Join(dir, database+".db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { router.Close() })
+
+	migrations := fstest.MapFS{
+		"001_init.sql": {Data: []byte(`
+CREATE TABLE agents (id INTEGER PRIMARY KEY, agent_id TEXT, status TEXT DEFAULT 'active', api_key_hash TEXT, deregistered_at DATETIME, updated_at DATETIME);
+CREATE TABLE events (id INTEGER PRIMARY KEY, message TEXT);`)},
+		"002_seed.sql": {Data: []byte(`
+INSERT INTO agents (agent_id, api_key_hash) VALUES ('agent-1', 'abc');
+INSERT INTO events (message) VALUES ('hello');`)},
+		"README.md": {Data: []byte("not a migration")},
+	}
+	database := NewSQLTenantDatabase(router, migrations)
+	exportDir := filepath.Join(t.TempDir(), "exports")
+	lifecycle, err := NewTenantLifecycle(ctx, tm, database, LifecycleConfig{GracePeriod: 7 * 24 * time.Hour, ExportDir: exportDir})
+	require.NoError(t, err)
+
+	require.NoError(t, lifecycle.Provision(ctx, &Tenant{ID: "acme", Name: "Acme"}))
+	require.NoError(t, lifecycle.Provision(ctx, &Tenant{ID: "globex", Name: "Globex"}))
+	acme, err := tm.GetTenant(ctx, "acme")
+	require.NoError(t, err)
+
+	// 重複執行 migration 不會重跑已套用的檔案
+	require.NoError(t, database.Migrate(ctx, acme))
+	countRows := func(tenant *Tenant, table, where string) int64 {
+		var count int64
+		require.NoError(t, router.withTenant(ctx, tenant, func(tx *gorm.DB) error {
+			query := tx.Table(table)
+			if where != "" {
+				query = query.Where(where)
+			}
+			return query.Count(&count).Error
+		}))
+		return count
+	}
+	assert.Equal(t, int64(1), countRows(acme, "events", ""))
+	assert.Equal(t, int64(2), countRows(acme, "schema_migrations", ""))
+
+	key, err := tm.IssueAPIKey(ctx, "acme")
+	require.NoError(t, err)
+	require.NoError(t, lifecycle.Delete(ctx, "acme", "contract ended"))
+
+	state, ok := lifecycle.State("acme")
+	require.True(t, ok)
+	assert.Equal(t, LifecycleWaiting, state.Status)
+	assert.Equal(t, "export", state.Step)
+	assert.Equal(t, clock.Now().Add(7*24*time.Hour), state.PurgeAfter)
+	acme, err = tm.GetTenant(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, TenantStatusDeleting, acme.Status)
+	_, err = tm.GetTenantByAPIKey(ctx, key)
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), countRows(acme, "agents", "status = 'inactive' AND api_key_hash IS NULL"))
+
+	// 匯出檔包含租戶資訊與所有資料列
+	file, err := os.Open(state.ExportPath)
+	require.NoError(t, err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	scanner := bufio.NewScanner(gz)
+	require.True(t, scanner.Scan())
+	assert.Contains(t, scanner.Text(), `"ID":"acme"`)
+	tables := make(map[string]int)
+	var message interface{}
+	for scanner.Scan() {
+		var row exportRow
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+		tables[row.Table]++
+		if row.Table == "events" {
+			message = row.Row["message"]
+		}
+	}
+	assert.Equal(t, map[string]int{"agents": 1, "events": 1, "schema_migrations": 2}, tables)
+	assert.Equal(t, "hello", message)
+
+	// 寬限期內不清除，且可取消
+	require.NoError(t, lifecycle.Delete(ctx, "globex", "trial expired"))
+	require.NoError(t, lifecycle.CancelDeletion(ctx, "globex"))
+	require.NoError(t, lifecycle.Resume(ctx))
+	assert.Equal(t, int64(1), countRows(acme, "events", ""))
+
+	// 重新啟動後，寬限期過了才清除
+	clock.Set(clock.Now().Add(8 * 24 * time.Hour))
+	restarted, err := NewTenantLifecycle(ctx, tm, database, LifecycleConfig{GracePeriod: 7 * 24 * time.Hour, ExportDir: exportDir})
+	require.NoError(t, err)
+	require.NoError(t, restarted.Resume(ctx))
+
+	state, _ = restarted.State("acme")
+	assert.Equal(t, LifecycleCompleted, state.Status)
+	acme, err = tm.GetTenant(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, TenantStatusDeleted, acme.Status)
+	assert.NotEmpty(t, acme.Metadata["purged_at"])
+	require.NoError(t, router.withTenant(ctx, acme, func(tx *gorm.DB) error {
+		tables, err := tx.Migrator().GetTables()
+		assert.Empty(t, tables)
+		return err
+	}))
+
+	globex, err := tm.GetTenant(ctx, "globex")
+	require.NoError(t, err)
+	assert.Equal(t, TenantStatusSuspended, globex.Status)
+	assert.Equal(t, int64(1), countRows(globex, "events", ""))
+	assert.Error(t, restarted.CancelDeletion(ctx, "acme"))
+}
@@ -0,0 +1,90 @@
+package multitenant
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResolverConfig configures how the tenant of a request is resolved
+type ResolverConfig struct {
+	// APIKeyHeader header carrying the tenant API key (default X-API-Key)
+	APIKeyHeader string `yaml:"api_key_header" json:"api_key_header"`
+	// TenantHeader header carrying the tenant ID (default X-Tenant-ID)
+	TenantHeader string `yaml:"tenant_header" json:"tenant_header"`
+	// TrustTenantHeader accepts TenantHeader without an API key; only enable behind a trusted gateway
+	TrustTenantHeader bool `yaml:"trust_tenant_header" json:"trust_tenant_header"`
+}
+
+// TenantResolver resolves the tenant of an HTTP request by API key, domain or header
+type TenantResolver struct {
+	manager *TenantManager
+	config  ResolverConfig
+}
+
+// NewTenantResolver creates a tenant resolver
+func NewTenantResolver(manager *TenantManager, config ResolverConfig) *TenantResolver {
+	if config.APIKeyHeader == "" {
+		config.APIKeyHeader = "X-API-Key"
+	}
+	if config.TenantHeader == "" {
+		config.TenantHeader = "X-Tenant-ID"
+	}
+	return &TenantResolver{manager: manager, config: config}
+}
+
+// Resolve returns the tenant of a request. An API key takes precedence, then the request domain,
+// then the tenant header when it is trusted
+func (r *TenantResolver) Resolve(req *http.Request) (*Tenant, error) {
+	ctx := req.Context()
+	if key := req.Header.Get(r.config.APIKeyHeader); key != "" {
+		return r.manager.GetTenantByAPIKey(ctx, key)
+	}
+
+	if tenant, err := r.manager.GetTenantByDomain(ctx, requestDomain(req)); err == nil {
+		return tenant, nil
+	}
+
+	if tenantID := req.Header.Get(r.config.TenantHeader); tenantID != "" && r.config.TrustTenantHeader {
+		tenant, err := r.manager.GetTenant(ctx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		if tenant.Status != TenantStatusActive {
+			return nil, ErrNoTenantContext
+		}
+		return tenant, nil
+	}
+
+	return nil, ErrNoTenantContext
+}
+
+// Middleware resolves the tenant and stores it in the request context; requests without a tenant are rejected
+func (r *TenantResolver) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, err := r.Resolve(c.Request)
+		if err != nil {
+			r.manager.logger.Warnf("Tenant resolution failed: %s %s - %v", c.ClientIP(), c.Request.URL.Path, err)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Tenant could not be resolved",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("tenant_id", tenant.ID)
+		c.Request = c.Request.WithContext(WithTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}
+
+// requestDomain returns the request host without port
+func requestDomain(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
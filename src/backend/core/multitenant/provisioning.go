@@ -0,0 +1,310 @@
+package multitenant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TenantDatabase creates, migrates, exports and removes the data of a tenant.
+// Every method must be idempotent so that an interrupted lifecycle step can simply be run again
+type TenantDatabase interface {
+	// Create creates the schema or database of the tenant if it does not exist
+	Create(ctx context.Context, tenant *Tenant) error
+	// Migrate applies the migrations that have not been applied to the tenant yet
+	Migrate(ctx context.Context, tenant *Tenant) error
+	// RevokeAgents deactivates the registered agents of the tenant and returns how many were revoked
+	RevokeAgents(ctx context.Context, tenant *Tenant) (int, error)
+	// Disconnect closes open connections to the tenant database
+	Disconnect(ctx context.Context, tenant *Tenant) error
+	// Export writes every row of the tenant as JSON lines
+	Export(ctx context.Context, tenant *Tenant, w io.Writer) error
+	// Drop removes the schema or database of the tenant
+	Drop(ctx context.Context, tenant *Tenant) error
+}
+
+// schemaMigration migration bookkeeping table in every tenant schema or database
+// (database/migrations/002 also records itself here)
+type schemaMigration struct {
+	Version     string `gorm:"primaryKey;size:64"`
+	Description string `gorm:"type:text"`
+	AppliedAt   time.Time
+}
+
+// TableName table name
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// exportRow one exported row
+type exportRow struct {
+	Table string                 `json:"table"`
+	Row   map[string]interface{} `json:"row"`
+}
+
+// SQLTenantDatabase implements TenantDatabase on top of a TenantRouter.
+// IsolationSharedDB uses one PostgreSQL schema per tenant, IsolationSeparateDB one PostgreSQL
+// database per tenant, and IsolationDedicatedHost an externally created database per tenant
+type SQLTenantDatabase struct {
+	router     *TenantRouter
+	migrations fs.FS
+}
+
+// NewSQLTenantDatabase creates a tenant database manager applying the *.sql files of migrations
+// (for example os.DirFS("database/migrations")) in file name order
+func NewSQLTenantDatabase(router *TenantRouter, migrations fs.FS) *SQLTenantDatabase {
+	return &SQLTenantDatabase{
+		router:     router,
+		migrations: migrations,
+	}
+}
+
+// system returns the shared connection pool in system scope
+func (d *SQLTenantDatabase) system(ctx context.Context) *gorm.DB {
+	return d.router.shared.WithContext(WithSystemScope(ctx))
+}
+
+// Create creates the tenant schema or database
+func (d *SQLTenantDatabase) Create(ctx context.Context, tenant *Tenant) error {
+	manager := d.router.manager
+	switch manager.Isolation() {
+	case IsolationSharedDB:
+		schema := manager.GetSchemaName(tenant.ID)
+		if err := d.system(ctx).Exec("CREATE SCHEMA IF NOT EXISTS " + quoteIdentifier(schema)).Error; err != nil {
+			return fmt.Errorf("failed to create schema %s: %w", schema, err)
+		}
+		return nil
+	case IsolationSeparateDB:
+		// CREATE DATABASE has no IF NOT EXISTS and cannot run in a transaction
+		database := manager.GetDatabaseName(tenant.ID)
+		var count int64
+		if err := d.system(ctx).Raw("SELECT COUNT(*) FROM pg_database WHERE datname = ?", database).Scan(&count).Error; err != nil {
+			return fmt.Errorf("failed to look up database %s: %w", database, err)
+		}
+		if count > 0 {
+			return nil
+		}
+		if err := d.system(ctx).Exec("CREATE DATABASE " + quoteIdentifier(database)).Error; err != nil {
+			return fmt.Errorf("failed to create database %s: %w", database, err)
+		}
+		return nil
+	default:
+		// 專用主機的資料庫由外部建立，這裡只確認可以連線
+		return d.router.withTenant(ctx, tenant, func(tx *gorm.DB) error {
+			return tx.Exec("SELECT 1").Error
+		})
+	}
+}
+
+// Migrate applies pending migrations, each in its own transaction together with its schema_migrations row
+func (d *SQLTenantDatabase) Migrate(ctx context.Context, tenant *Tenant) error {
+	if d.migrations == nil {
+		return nil
+	}
+	files, err := fs.Glob(d.migrations, "*.sql")
+	if err != nil {
+		return fmt.Errorf("failed to list migrations: %w", err)
+	}
+	sort.Strings(files)
+
+	applied := 0
+	for _, file := range files {
+		version, description := migrationVersion(file)
+		err := d.router.withTenant(ctx, tenant, func(db *gorm.DB) error {
+			return db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.AutoMigrate(&schemaMigration{}); err != nil {
+					return err
+				}
+				var count int64
+				if err := tx.Model(&schemaMigration{}).Where("version = ?", version).Count(&count).Error; err != nil {
+					return err
+				}
+				if count > 0 {
+					return nil
+				}
+
+				content, err := fs.ReadFile(d.migrations, file)
+				if err != nil {
+					return err
+				}
+				if err := tx.Exec(string(content)).Error; err != nil {
+					return err
+				}
+				applied++
+				record := &schemaMigration{Version: version, Description: description, AppliedAt: time.Now()}
+				return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(record).Error
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %s for tenant %s: %w", file, tenant.ID, err)
+		}
+	}
+
+	if applied > 0 {
+		d.router.manager.logger.Infof("Applied %d migrations for tenant %s", applied, tenant.ID)
+	}
+	return nil
+}
+
+// RevokeAgents marks the agents of the tenant inactive and clears their API keys
+func (d *SQLTenantDatabase) RevokeAgents(ctx context.Context, tenant *Tenant) (int, error) {
+	revoked := 0
+	err := d.router.withTenant(ctx, tenant, func(tx *gorm.DB) error {
+		owned, err := d.ownsSchema(tx, tenant)
+		if err != nil || !owned {
+			return err
+		}
+		if !tx.Migrator().HasTable("agents") {
+			return nil
+		}
+		now := time.Now()
+		result := tx.Table("agents").
+			Where("status <> ? OR api_key_hash IS NOT NULL", "inactive").
+			Updates(map[string]interface{}{
+				"status":          "inactive",
+				"api_key_hash":    nil,
+				"deregistered_at": now,
+				"updated_at":      now,
+			})
+		revoked = int(result.RowsAffected)
+		return result.Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke agents of tenant %s: %w", tenant.ID, err)
+	}
+	return revoked, nil
+}
+
+// Disconnect closes the per-tenant connection pool
+func (d *SQLTenantDatabase) Disconnect(ctx context.Context, tenant *Tenant) error {
+	return d.router.ClosePool(tenant.ID)
+}
+
+// Export writes every row of every tenant table as one JSON object per line
+func (d *SQLTenantDatabase) Export(ctx context.Context, tenant *Tenant, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return d.router.withTenant(ctx, tenant, func(tx *gorm.DB) error {
+		tables, err := d.tables(tx, tenant)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			rows, err := tx.Table(table).Rows()
+			if err != nil {
+				return fmt.Errorf("failed to export table %s: %w", table, err)
+			}
+			for rows.Next() {
+				row := make(map[string]interface{})
+				if err := tx.ScanRows(rows, &row); err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to export table %s: %w", table, err)
+				}
+				for column, value := range row {
+					if data, ok := value.([]byte); ok {
+						row[column] = string(data)
+					}
+				}
+				if err := encoder.Encode(exportRow{Table: table, Row: row}); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+			if err := rows.Close(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Drop removes the tenant schema or database
+func (d *SQLTenantDatabase) Drop(ctx context.Context, tenant *Tenant) error {
+	manager := d.router.manager
+	switch manager.Isolation() {
+	case IsolationSharedDB:
+		schema := manager.GetSchemaName(tenant.ID)
+		if err := d.system(ctx).Exec("DROP SCHEMA IF EXISTS " + quoteIdentifier(schema) + " CASCADE").Error; err != nil {
+			return fmt.Errorf("failed to drop schema %s: %w", schema, err)
+		}
+		return nil
+	case IsolationSeparateDB:
+		if err := d.router.ClosePool(tenant.ID); err != nil {
+			return err
+		}
+		database := manager.GetDatabaseName(tenant.ID)
+		if err := d.system(ctx).Exec("DROP DATABASE IF EXISTS " + quoteIdentifier(database)).Error; err != nil {
+			return fmt.Errorf("failed to drop database %s: %w", database, err)
+		}
+		return nil
+	default:
+		// 專用主機的資料庫不屬於我們，只刪除其中的資料表
+		err := d.router.withTenant(ctx, tenant, func(tx *gorm.DB) error {
+			tables, err := d.tables(tx, tenant)
+			if err != nil {
+				return err
+			}
+			for _, table := range tables {
+				if err := tx.Migrator().DropTable(table); err != nil {
+					return fmt.Errorf("failed to drop table %s: %w", table, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return d.router.ClosePool(tenant.ID)
+	}
+}
+
+// tables lists the tenant tables in name order
+func (d *SQLTenantDatabase) tables(tx *gorm.DB, tenant *Tenant) ([]string, error) {
+	owned, err := d.ownsSchema(tx, tenant)
+	if err != nil || !owned {
+		return nil, err
+	}
+
+	all, err := tx.Migrator().GetTables()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables of tenant %s: %w", tenant.ID, err)
+	}
+	tables := make([]string, 0, len(all))
+	for _, table := range all {
+		if !strings.HasPrefix(table, "sqlite_") {
+			tables = append(tables, table)
+		}
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// ownsSchema reports whether tx resolves unqualified tables in the tenant's own schema.
+// search_path falls back to public when the tenant schema does not exist, and statements
+// there would touch the shared tables of every tenant
+func (d *SQLTenantDatabase) ownsSchema(tx *gorm.DB, tenant *Tenant) (bool, error) {
+	manager := d.router.manager
+	if manager.Isolation() != IsolationSharedDB {
+		return true, nil
+	}
+	var current string
+	if err := tx.Raw("SELECT current_schema()").Scan(&current).Error; err != nil {
+		return false, err
+	}
+	return current == manager.GetSchemaName(tenant.ID), nil
+}
+
+// migrationVersion splits "001_initial_schema.sql" into "001" and "initial schema"
+func migrationVersion(file string) (string, string) {
+	name := strings.TrimSuffix(path.Base(file), ".sql")
+	version, description, _ := strings.Cut(name, "_")
+	return version, strings.ReplaceAll(description, "_", " ")
+}
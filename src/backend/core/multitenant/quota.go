@@ -0,0 +1,429 @@
+package multitenant
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Resource names accepted by CheckResourceLimit, IncrementUsage and ConsumeQuota
+const (
+	ResourceUsers       = "users"
+	ResourceDevices     = "devices"
+	ResourceEvents      = "events"
+	ResourceStorage     = "storage"
+	ResourceAPICalls    = "api_calls"
+	ResourceConnections = "connections"
+)
+
+// usageDayLayout calendar day format of usage records
+const usageDayLayout = "2006-01-02"
+
+var (
+	// ErrUnknownResource is returned for resource names that have no limit
+	ErrUnknownResource = errors.New("unknown resource")
+	// ErrQuotaExceeded is returned when a tenant would exceed a hard limit
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+)
+
+// QuotaStatus is the usage of one resource after a quota check
+type QuotaStatus struct {
+	TenantID string  `json:"tenant_id"`
+	Resource string  `json:"resource"`
+	Used     float64 `json:"used"`
+	Limit    float64 `json:"limit"` // negative means unlimited
+	Day      string  `json:"day"`
+	// ResetAt is the next local midnight for daily resources, zero otherwise
+	ResetAt time.Time `json:"reset_at,omitempty"`
+}
+
+// Unlimited reports whether the resource has no limit
+func (s QuotaStatus) Unlimited() bool {
+	return s.Limit < 0
+}
+
+// Remaining returns how much of the limit is left (-1 when unlimited)
+func (s QuotaStatus) Remaining() float64 {
+	if s.Unlimited() {
+		return -1
+	}
+	if s.Used >= s.Limit {
+		return 0
+	}
+	return s.Limit - s.Used
+}
+
+// UsageRecord is the metered usage of a tenant for one calendar day
+type UsageRecord struct {
+	TenantID  string           `json:"tenant_id"`
+	Day       string           `json:"day"`
+	Plan      SubscriptionPlan `json:"plan"`
+	Events    int64            `json:"events"`
+	APICalls  int64            `json:"api_calls"`
+	Users     int              `json:"users"`
+	Devices   int              `json:"devices"`
+	StorageGB float64          `json:"storage_gb"`
+	// Final is false for the day still in progress
+	Final bool `json:"final"`
+}
+
+// ConsumeQuota atomically checks and increments the usage of a resource.
+// When the increment would exceed a hard limit nothing is recorded and ErrQuotaExceeded is returned
+func (tm *TenantManager) ConsumeQuota(ctx context.Context, tenantID, resource string, amount int64) (QuotaStatus, error) {
+	return tm.changeUsage(ctx, tenantID, resource, amount, true)
+}
+
+// changeUsage adds amount to a resource, enforcing its limit when enforce is set. With a store the
+// counter is incremented atomically by the store outside tm.mu and the cached usage is refreshed
+// from the result, so increments of other replicas are neither lost nor overwritten
+func (tm *TenantManager) changeUsage(ctx context.Context, tenantID, resource string, amount int64, enforce bool) (QuotaStatus, error) {
+	tm.mu.Lock()
+	tenant, exists := tm.tenants[tenantID]
+	if !exists {
+		tm.mu.Unlock()
+		return QuotaStatus{}, fmt.Errorf("tenant %s not found", tenantID)
+	}
+	tm.rolloverLocked(ctx, tenant)
+
+	used, limit, err := resourceUsage(tenant, resource)
+	if err != nil {
+		tm.mu.Unlock()
+		return QuotaStatus{}, err
+	}
+	status := QuotaStatus{
+		TenantID: tenantID,
+		Resource: resource,
+		Used:     used,
+		Limit:    limit,
+		Day:      tenant.Usage.Day,
+	}
+	if isDailyResource(resource) {
+		status.ResetAt = nextLocalMidnight(tm.now(), tenantLocation(tenant))
+	}
+	enforced := limit
+	if !enforce {
+		enforced = -1
+	}
+
+	if tm.store == nil {
+		defer tm.mu.Unlock()
+		if enforced >= 0 && used+float64(amount) > enforced {
+			return status, fmt.Errorf("%w: %s %s %.0f/%.0f", ErrQuotaExceeded, tenantID, resource, used, limit)
+		}
+		if err := addUsage(tenant.Usage, resource, amount); err != nil {
+			return status, err
+		}
+		tenant.Usage.LastUpdated = tm.now()
+		status.Used = used + float64(amount)
+		return status, nil
+	}
+	tm.mu.Unlock()
+
+	value, err := tm.store.AddUsage(ctx, tenantID, resource, counterDay(resource, status.Day), int64(used), amount, int64(enforced))
+	if err != nil && !errors.Is(err, ErrQuotaExceeded) {
+		return status, err
+	}
+	tm.mu.Lock()
+	if tenant.Usage.Day == status.Day {
+		setUsage(tenant.Usage, resource, value)
+		tenant.Usage.LastUpdated = tm.now()
+	}
+	tm.mu.Unlock()
+
+	status.Used = float64(value)
+	if err != nil {
+		return status, fmt.Errorf("%w: %s %s %.0f/%.0f", ErrQuotaExceeded, tenantID, resource, status.Used, limit)
+	}
+	return status, nil
+}
+
+// RolloverDailyUsage closes the usage day of every tenant whose local calendar day has changed.
+// It is called periodically so that idle tenants are reset and metered too
+func (tm *TenantManager) RolloverDailyUsage(ctx context.Context) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	for _, tenant := range tm.tenants {
+		if tenant.Status == TenantStatusDeleted {
+			continue
+		}
+		tm.rolloverLocked(ctx, tenant)
+	}
+}
+
+// rolloverLocked resets the daily counters when the tenant's local calendar day has changed,
+// records the closed day for metering and saves the new usage day. Returns true when the usage
+// changed (caller holds the lock)
+func (tm *TenantManager) rolloverLocked(ctx context.Context, tenant *Tenant) bool {
+	if tenant.Usage == nil {
+		tenant.Usage = &ResourceUsage{}
+	}
+	today := tm.now().In(tenantLocation(tenant)).Format(usageDayLayout)
+	usage := tenant.Usage
+	if usage.Day == today {
+		return false
+	}
+	if usage.Day != "" {
+		tm.closeUsageDayLocked(ctx, tenant)
+	}
+	usage.EventsToday = 0
+	usage.APICallsToday = 0
+	usage.Day = today
+	usage.LastUpdated = tm.now()
+	// 其他副本可能已在新的一天計數
+	tm.refreshUsageLocked(ctx, tenant)
+
+	if tm.store != nil {
+		if err := tm.store.SaveUsage(ctx, tenant.ID, usage); err != nil {
+			tm.logger.Errorf("Failed to save usage for tenant %s: %v", tenant.ID, err)
+		}
+	}
+	return true
+}
+
+// refreshUsageLocked replaces the cached counters of the tenant's usage day with the stored ones (caller holds the lock)
+func (tm *TenantManager) refreshUsageLocked(ctx context.Context, tenant *Tenant) {
+	if tm.store == nil {
+		return
+	}
+	counters, err := tm.store.LoadUsageCounters(ctx, tenant.ID, tenant.Usage.Day)
+	if err != nil {
+		tm.logger.Errorf("Failed to load usage for tenant %s: %v", tenant.ID, err)
+		return
+	}
+	for resource, value := range counters {
+		setUsage(tenant.Usage, resource, value)
+	}
+}
+
+// closeUsageDayLocked records the final usage of the tenant's current usage day (caller holds the lock)
+func (tm *TenantManager) closeUsageDayLocked(ctx context.Context, tenant *Tenant) {
+	tm.refreshUsageLocked(ctx, tenant)
+	record := newUsageRecord(tenant)
+	record.Final = true
+	if tm.store != nil {
+		if err := tm.store.SaveUsageRecord(ctx, record); err != nil {
+			tm.logger.Errorf("Failed to save usage record for tenant %s (%s): %v", tenant.ID, record.Day, err)
+		}
+	} else {
+		tm.history = append(tm.history, record)
+	}
+	tm.logger.Infof("Closed daily usage for tenant %s (%s)", tenant.ID, record.Day)
+}
+
+// UsageRecords returns the metered usage per tenant and day for days in [from, to] (YYYY-MM-DD, empty for open).
+// The current day of each tenant is included with Final set to false
+func (tm *TenantManager) UsageRecords(ctx context.Context, from, to string) ([]UsageRecord, error) {
+	for _, day := range []string{from, to} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse(usageDayLayout, day); err != nil {
+			return nil, fmt.Errorf("invalid day %q: use YYYY-MM-DD", day)
+		}
+	}
+	inRange := func(day string) bool {
+		return (from == "" || day >= from) && (to == "" || day <= to)
+	}
+
+	var records []UsageRecord
+	if tm.store != nil {
+		stored, err := tm.store.LoadUsageRecords(ctx, from, to)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, stored...)
+	}
+
+	tm.mu.Lock()
+	for _, record := range tm.history {
+		if inRange(record.Day) {
+			records = append(records, record)
+		}
+	}
+	for _, tenant := range tm.tenants {
+		if tenant.Status == TenantStatusDeleted {
+			continue
+		}
+		tm.rolloverLocked(ctx, tenant)
+		if record := newUsageRecord(tenant); inRange(record.Day) {
+			records = append(records, record)
+		}
+	}
+	tm.mu.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Day != records[j].Day {
+			return records[i].Day < records[j].Day
+		}
+		return records[i].TenantID < records[j].TenantID
+	})
+	return records, nil
+}
+
+// ExportUsage writes the metered usage for billing as "csv" or "json"
+func (tm *TenantManager) ExportUsage(ctx context.Context, w io.Writer, format, from, to string) error {
+	records, err := tm.UsageRecords(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "csv":
+		return WriteUsageCSV(w, records)
+	case "json", "":
+		return WriteUsageJSON(w, records)
+	default:
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// WriteUsageCSV writes usage records as CSV with a header row
+func WriteUsageCSV(w io.Writer, records []UsageRecord) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"day", "tenant_id", "plan", "events", "api_calls", "users", "devices", "storage_gb", "final"}); err != nil {
+		return err
+	}
+	for _, record := range records {
+		row := []string{
+			record.Day,
+			record.TenantID,
+			string(record.Plan),
+			strconv.FormatInt(record.Events, 10),
+			strconv.FormatInt(record.APICalls, 10),
+			strconv.Itoa(record.Users),
+			strconv.Itoa(record.Devices),
+			strconv.FormatFloat(record.StorageGB, 'f', -1, 64),
+			strconv.FormatBool(record.Final),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteUsageJSON writes usage records as a JSON array
+func WriteUsageJSON(w io.Writer, records []UsageRecord) error {
+	if records == nil {
+		records = []UsageRecord{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+// newUsageRecord snapshots the current usage day of a tenant
+func newUsageRecord(tenant *Tenant) UsageRecord {
+	usage := tenant.Usage
+	return UsageRecord{
+		TenantID:  tenant.ID,
+		Day:       usage.Day,
+		Plan:      tenant.Plan,
+		Events:    usage.EventsToday,
+		APICalls:  usage.APICallsToday,
+		Users:     usage.CurrentUsers,
+		Devices:   usage.CurrentDevices,
+		StorageGB: usage.StorageUsedGB,
+	}
+}
+
+// resourceUsage returns the current usage and limit of a resource; a negative limit means unlimited
+func resourceUsage(tenant *Tenant, resource string) (float64, float64, error) {
+	usage, limits := tenant.Usage, tenant.Limits
+	if limits == nil {
+		limits = &ResourceLimits{MaxUsers: -1, MaxDevices: -1, MaxEvents: -1, MaxStorageGB: -1, MaxAPICallsPerDay: -1, MaxConcurrentConns: -1}
+	}
+	switch resource {
+	case ResourceUsers:
+		return float64(usage.CurrentUsers), float64(limits.MaxUsers), nil
+	case ResourceDevices:
+		return float64(usage.CurrentDevices), float64(limits.MaxDevices), nil
+	case ResourceEvents:
+		return float64(usage.EventsToday), float64(limits.MaxEvents), nil
+	case ResourceStorage:
+		return usage.StorageUsedGB, float64(limits.MaxStorageGB), nil
+	case ResourceAPICalls:
+		return float64(usage.APICallsToday), float64(limits.MaxAPICallsPerDay), nil
+	case ResourceConnections:
+		return float64(usage.ConcurrentConns), float64(limits.MaxConcurrentConns), nil
+	default:
+		return 0, 0, fmt.Errorf("%w: %s", ErrUnknownResource, resource)
+	}
+}
+
+// isDailyResource reports whether the resource is reset at the tenant's local midnight
+func isDailyResource(resource string) bool {
+	return resource == ResourceEvents || resource == ResourceAPICalls
+}
+
+// counterDay returns the stored counter day of a resource; gauges are not kept per day
+func counterDay(resource, day string) string {
+	if isDailyResource(resource) {
+		return day
+	}
+	return ""
+}
+
+// setUsage sets a resource to a stored counter value; unknown resources are ignored
+func setUsage(usage *ResourceUsage, resource string, value int64) {
+	switch resource {
+	case ResourceUsers:
+		usage.CurrentUsers = int(value)
+	case ResourceDevices:
+		usage.CurrentDevices = int(value)
+	case ResourceEvents:
+		usage.EventsToday = value
+	case ResourceStorage:
+		usage.StorageUsedGB = float64(value)
+	case ResourceAPICalls:
+		usage.APICallsToday = value
+	case ResourceConnections:
+		usage.ConcurrentConns = int(value)
+	}
+}
+
+// addUsage adds amount (may be negative for gauges such as devices) to a resource
+func addUsage(usage *ResourceUsage, resource string, amount int64) error {
+	switch resource {
+	case ResourceUsers:
+		usage.CurrentUsers += int(amount)
+	case ResourceDevices:
+		usage.CurrentDevices += int(amount)
+	case ResourceEvents:
+		usage.EventsToday += amount
+	case ResourceStorage:
+		usage.StorageUsedGB += float64(amount)
+	case ResourceAPICalls:
+		usage.APICallsToday += amount
+	case ResourceConnections:
+		usage.ConcurrentConns += int(amount)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownResource, resource)
+	}
+	return nil
+}
+
+// tenantLocation returns the tenant's timezone, UTC when unset or invalid
+func tenantLocation(tenant *Tenant) *time.Location {
+	if tenant.Config == nil || tenant.Config.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tenant.Config.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// nextLocalMidnight returns the start of the next calendar day in loc
+func nextLocalMidnight(now time.Time, loc *time.Location) time.Time {
+	local := now.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,260 @@
+package multitenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"pandora_box_console_ids_ips/internal/pubsub"
+)
+
+// Quota event types published on the tenant.* routing keys
+const (
+	EventTypeQuotaWarning  pubsub.EventType = "tenant.quota_warning"
+	EventTypeQuotaExceeded pubsub.EventType = "tenant.quota_exceeded"
+)
+
+// QuotaEvent is published when a tenant reaches the soft limit or is refused by the hard limit
+type QuotaEvent struct {
+	pubsub.BaseEvent
+
+	TenantID string  `json:"tenant_id"`
+	Resource string  `json:"resource"`
+	Used     float64 `json:"used"`
+	Limit    float64 `json:"limit"`
+	Day      string  `json:"day"`
+}
+
+// QuotaConfig configures quota enforcement
+type QuotaConfig struct {
+	// SoftLimitRatio fraction of a limit that triggers a warning event (default 0.8)
+	SoftLimitRatio float64 `yaml:"soft_limit_ratio" json:"soft_limit_ratio"`
+	// Exchange exchange quota events are published to (default pandora.events)
+	Exchange string `yaml:"exchange" json:"exchange"`
+	// RolloverInterval how often idle tenants are checked for a new usage day (default 1m)
+	RolloverInterval time.Duration `yaml:"rollover_interval" json:"rollover_interval"`
+}
+
+// QuotaEnforcer enforces tenant quotas in HTTP and event consumers and publishes limit events
+type QuotaEnforcer struct {
+	manager   *TenantManager
+	publisher pubsub.MessageQueue
+	config    QuotaConfig
+	logger    *logrus.Logger
+
+	// notified remembers the day a limit event was last published per tenant, resource and level
+	notified map[string]string
+	mu       sync.Mutex
+}
+
+// NewQuotaEnforcer creates a quota enforcer; publisher may be nil to disable limit events
+func NewQuotaEnforcer(manager *TenantManager, publisher pubsub.MessageQueue, config QuotaConfig) *QuotaEnforcer {
+	if config.SoftLimitRatio <= 0 || config.SoftLimitRatio >= 1 {
+		config.SoftLimitRatio = 0.8
+	}
+	if config.Exchange == "" {
+		config.Exchange = "pandora.events"
+	}
+	if config.RolloverInterval <= 0 {
+		config.RolloverInterval = time.Minute
+	}
+	return &QuotaEnforcer{
+		manager:   manager,
+		publisher: publisher,
+		config:    config,
+		logger:    manager.logger,
+		notified:  make(map[string]string),
+	}
+}
+
+// Start resets daily usage of idle tenants until ctx is cancelled
+func (q *QuotaEnforcer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(q.config.RolloverInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				q.manager.RolloverDailyUsage(ctx)
+			}
+		}
+	}()
+}
+
+// Consume checks and records usage, publishing soft and hard limit events
+func (q *QuotaEnforcer) Consume(ctx context.Context, tenantID, resource string, amount int64) (QuotaStatus, error) {
+	status, err := q.manager.ConsumeQuota(ctx, tenantID, resource, amount)
+	switch {
+	case errors.Is(err, ErrQuotaExceeded):
+		q.notify(ctx, EventTypeQuotaExceeded, "high", status)
+	case err == nil && !status.Unlimited() && status.Limit > 0 && status.Used >= status.Limit*q.config.SoftLimitRatio:
+		q.notify(ctx, EventTypeQuotaWarning, "medium", status)
+	}
+	return status, err
+}
+
+// notify publishes a limit event at most once per tenant, resource, level and day
+func (q *QuotaEnforcer) notify(ctx context.Context, eventType pubsub.EventType, severity string, status QuotaStatus) {
+	key := fmt.Sprintf("%s|%s|%s", status.TenantID, status.Resource, eventType)
+	q.mu.Lock()
+	if q.notified[key] == status.Day {
+		q.mu.Unlock()
+		return
+	}
+	q.notified[key] = status.Day
+	q.mu.Unlock()
+
+	q.logger.Warnf("Tenant %s %s: %s %.0f/%.0f", status.TenantID, eventType, status.Resource, status.Used, status.Limit)
+	if q.publisher == nil {
+		return
+	}
+
+	event := &QuotaEvent{
+		BaseEvent: pubsub.BaseEvent{
+			ID:            fmt.Sprintf("quota_%s_%s_%d", status.TenantID, status.Resource, time.Now().UnixNano()),
+			Type:          eventType,
+			SchemaVersion: pubsub.CurrentSchemaVersion,
+			Timestamp:     time.Now(),
+			Source:        "multitenant",
+			Severity:      severity,
+			Tags:          []string{"tenant", "quota", status.Resource},
+			Metadata:      map[string]interface{}{"tenant_id": status.TenantID},
+		},
+		TenantID: status.TenantID,
+		Resource: status.Resource,
+		Used:     status.Used,
+		Limit:    status.Limit,
+		Day:      status.Day,
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		q.logger.Errorf("Failed to encode quota event: %v", err)
+		return
+	}
+	if err := q.publisher.Publish(ctx, q.config.Exchange, string(eventType), data); err != nil {
+		q.logger.Errorf("Failed to publish quota event for tenant %s: %v", status.TenantID, err)
+		// 允許下次重新發布
+		q.mu.Lock()
+		delete(q.notified, key)
+		q.mu.Unlock()
+	}
+}
+
+// APIMiddleware meters API calls of the tenant resolved by TenantResolver.Middleware
+// and rejects requests over the daily limit
+func (q *QuotaEnforcer) APIMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant, ok := TenantFromContext(c.Request.Context())
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Tenant could not be resolved",
+			})
+			c.Abort()
+			return
+		}
+
+		status, err := q.Consume(c.Request.Context(), tenant.ID, ResourceAPICalls, 1)
+		if err != nil && !errors.Is(err, ErrQuotaExceeded) {
+			q.logger.Errorf("Quota check failed for tenant %s: %v", tenant.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Internal server error",
+			})
+			c.Abort()
+			return
+		}
+
+		if !status.Unlimited() {
+			c.Header("X-Quota-Limit", strconv.FormatFloat(status.Limit, 'f', -1, 64))
+			c.Header("X-Quota-Remaining", strconv.FormatFloat(status.Remaining(), 'f', -1, 64))
+			c.Header("X-Quota-Reset", strconv.FormatInt(status.ResetAt.Unix(), 10))
+		}
+		if err != nil {
+			c.Header("Retry-After", strconv.FormatInt(int64(time.Until(status.ResetAt).Seconds())+1, 10))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":    "Daily API quota exceeded",
+				"resource": status.Resource,
+				"limit":    status.Limit,
+				"reset_at": status.ResetAt,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// EventHandler wraps a pubsub consumer so that each event is metered against the tenant's event quota.
+// Events over the hard limit are dropped (acknowledged without calling next); events without a tenant pass through
+func (q *QuotaEnforcer) EventHandler(next pubsub.MessageHandler) pubsub.MessageHandler {
+	return func(topic string, message []byte) error {
+		tenantID := eventTenantID(message)
+		if tenantID == "" {
+			return next(topic, message)
+		}
+
+		_, err := q.Consume(context.Background(), tenantID, ResourceEvents, 1)
+		if errors.Is(err, ErrQuotaExceeded) {
+			q.logger.Debugf("Dropped event for tenant %s over quota: %s", tenantID, topic)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return next(topic, message)
+	}
+}
+
+// UsageExportHandler serves the usage-metering export: GET ?from=YYYY-MM-DD&to=YYYY-MM-DD&format=csv|json
+func (q *QuotaEnforcer) UsageExportHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", "json")
+		records, err := q.manager.UsageRecords(c.Request.Context(), c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		switch format {
+		case "csv":
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Header("Content-Disposition", `attachment; filename="tenant-usage.csv"`)
+			err = WriteUsageCSV(c.Writer, records)
+		case "json":
+			c.Header("Content-Type", "application/json; charset=utf-8")
+			err = WriteUsageJSON(c.Writer, records)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported format: " + format})
+			return
+		}
+		if err != nil {
+			q.logger.Errorf("Failed to write usage export: %v", err)
+		}
+	}
+}
+
+// eventTenantID returns the tenant of an event from "tenant_id" or "metadata.tenant_id"
+func eventTenantID(message []byte) string {
+	var payload struct {
+		TenantID string                 `json:"tenant_id"`
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := json.Unmarshal(message, &payload); err != nil {
+		return ""
+	}
+	if payload.TenantID != "" {
+		return payload.TenantID
+	}
+	tenantID, _ := payload.Metadata["tenant_id"].(string)
+	return tenantID
+}
@@ -0,0 +1,334 @@
+package multitenant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"pandora_box_console_ids_ips/internal/pubsub"
+)
+
+// recordingQueue 記錄發布的訊息
+type recordingQueue struct {
+	mu        sync.Mutex
+	published []QuotaEvent
+	keys      []string
+}
+
+func (q *recordingQueue) Publish(ctx context.Context, exchange, routingKey string, message []byte) error {
+	var event QuotaEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.published = append(q.published, event)
+	q.keys = append(q.keys, routingKey)
+	return nil
+}
+
+func (q *recordingQueue) Subscribe(ctx context.Context, queue string, handler pubsub.MessageHandler) error {
+	return nil
+}
+
+func (q *recordingQueue) Close() error { return nil }
+
+func (q *recordingQueue) Health(ctx context.Context) error { return nil }
+
+func (q *recordingQueue) events() []QuotaEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]QuotaEvent(nil), q.published...)
+}
+
+// fakeClock 可手動推進的時鐘
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+func newQuotaTenant(t *testing.T, tm *TenantManager, id, timezone string, limits ResourceLimits) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: id, Name: id, Config: &TenantConfig{Timezone: timezone}}))
+	tenant, err := tm.GetTenant(ctx, id)
+	require.NoError(t, err)
+	updated := *tenant
+	updated.Limits = &limits
+	require.NoError(t, tm.UpdateTenant(ctx, &updated))
+}
+
+func TestConsumeQuotaDailyResetInTenantTimezone(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	tm, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+
+	taipei, err := time.LoadLocation("Asia/Taipei")
+	require.NoError(t, err)
+	// 台北 23:30 = UTC 15:30
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 23, 30, 0, 0, taipei)}
+	tm.now = clock.Now
+
+	newQuotaTenant(t, tm, "acme", "Asia/Taipei", ResourceLimits{MaxUsers: -1, MaxDevices: 2, MaxEvents: 3, MaxStorageGB: -1, MaxAPICallsPerDay: 10, MaxConcurrentConns: -1})
+	newQuotaTenant(t, tm, "globex", "UTC", ResourceLimits{MaxUsers: -1, MaxDevices: -1, MaxEvents: 3, MaxStorageGB: -1, MaxAPICallsPerDay: 10, MaxConcurrentConns: -1})
+
+	for _, id := range []string{"acme", "globex"} {
+		status, err := tm.ConsumeQuota(ctx, id, ResourceEvents, 3)
+		require.NoError(t, err)
+		assert.Equal(t, float64(3), status.Used)
+		_, err = tm.ConsumeQuota(ctx, id, ResourceEvents, 1)
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+	}
+	status, err := tm.ConsumeQuota(ctx, "acme", ResourceEvents, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "2026-03-01", status.Day)
+	assert.Equal(t, time.Date(2026, 3, 2, 0, 0, 0, 0, taipei), status.ResetAt)
+	assert.Equal(t, float64(3), status.Used, "rejected increments are not recorded")
+
+	// 台北跨日，UTC 仍是同一天
+	clock.Set(time.Date(2026, 3, 2, 0, 30, 0, 0, taipei))
+	status, err = tm.ConsumeQuota(ctx, "acme", ResourceEvents, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "2026-03-02", status.Day)
+	assert.Equal(t, float64(1), status.Used)
+	_, err = tm.ConsumeQuota(ctx, "globex", ResourceEvents, 1)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// 非每日資源不會重置，且沒有 ResetAt
+	_, err = tm.ConsumeQuota(ctx, "acme", ResourceDevices, 2)
+	require.NoError(t, err)
+	status, err = tm.ConsumeQuota(ctx, "acme", ResourceDevices, 1)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.True(t, status.ResetAt.IsZero())
+
+	_, err = tm.ConsumeQuota(ctx, "acme", "bandwidth", 1)
+	assert.ErrorIs(t, err, ErrUnknownResource)
+	assert.ErrorIs(t, tm.IncrementUsage(ctx, "acme", "bandwidth", 1), ErrUnknownResource)
+	_, err = tm.CheckResourceLimit(ctx, "acme", "bandwidth")
+	assert.ErrorIs(t, err, ErrUnknownResource)
+
+	// 閒置租戶由 RolloverDailyUsage 重置
+	clock.Set(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC))
+	tm.RolloverDailyUsage(ctx)
+	globex, err := tm.GetTenant(ctx, "globex")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), globex.Usage.EventsToday)
+	assert.Equal(t, "2026-03-02", globex.Usage.Day)
+
+	records, err := tm.UsageRecords(ctx, "2026-03-01", "2026-03-01")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "acme", records[0].TenantID)
+	assert.Equal(t, int64(3), records[0].Events)
+	assert.True(t, records[0].Final)
+	assert.Equal(t, "globex", records[1].TenantID)
+
+	// 重新載入後保留當日使用量
+	reloaded, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	reloaded.now = clock.Now
+	ok, err := reloaded.CheckResourceLimit(ctx, "acme", ResourceEvents)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	acme, err := reloaded.GetTenant(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), acme.Usage.EventsToday)
+}
+
+// TestConsumeQuotaAcrossReplicas 共用儲存的多個管理器同時扣用配額，不遺失計數也不超過上限
+func TestConsumeQuotaAcrossReplicas(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	first, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	newQuotaTenant(t, first, "acme", "UTC", ResourceLimits{MaxUsers: -1, MaxDevices: -1, MaxEvents: 50, MaxStorageGB: -1, MaxAPICallsPerDay: -1, MaxConcurrentConns: -1})
+	second, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for _, tm := range []*TenantManager{first, second} {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(tm *TenantManager) {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					if _, err := tm.ConsumeQuota(ctx, "acme", ResourceEvents, 1); err == nil {
+						mu.Lock()
+						accepted++
+						mu.Unlock()
+					} else {
+						assert.ErrorIs(t, err, ErrQuotaExceeded)
+					}
+				}
+			}(tm)
+		}
+	}
+	wg.Wait()
+	assert.Equal(t, 50, accepted)
+
+	require.NoError(t, second.IncrementUsage(ctx, "acme", ResourceAPICalls, 3))
+	require.NoError(t, first.IncrementUsage(ctx, "acme", ResourceAPICalls, 4))
+	status, err := first.ConsumeQuota(ctx, "acme", ResourceAPICalls, 0)
+	require.NoError(t, err)
+	assert.Equal(t, float64(7), status.Used, "另一個副本的計數不會被覆寫")
+	status, err = second.ConsumeQuota(ctx, "acme", ResourceEvents, 0)
+	require.NoError(t, err)
+	assert.Equal(t, float64(50), status.Used)
+}
+
+func TestQuotaEnforcerEvents(t *testing.T) {
+	tm := NewTenantManager(IsolationSharedDB, testLogger())
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}
+	tm.now = clock.Now
+	newQuotaTenant(t, tm, "acme", "UTC", ResourceLimits{MaxUsers: -1, MaxDevices: -1, MaxEvents: 10, MaxStorageGB: -1, MaxAPICallsPerDay: -1, MaxConcurrentConns: -1})
+
+	queue := &recordingQueue{}
+	enforcer := NewQuotaEnforcer(tm, queue, QuotaConfig{})
+
+	var handled []string
+	handler := enforcer.EventHandler(func(topic string, message []byte) error {
+		handled = append(handled, topic)
+		return nil
+	})
+	tenantEvent := []byte(`{"type":"threat.detected","metadata":{"tenant_id":"acme"}}`)
+	for i := 0; i < 12; i++ {
+		require.NoError(t, handler("threat.detected", tenantEvent))
+	}
+	require.NoError(t, handler("system.started", []byte(`{"type":"system.started"}`)))
+	assert.Len(t, handled, 11, "10 tenant events within quota plus one without tenant")
+
+	events := queue.events()
+	require.Len(t, events, 2, "one warning and one exceeded event per day")
+	assert.Equal(t, EventTypeQuotaWarning, events[0].Type)
+	assert.Equal(t, float64(8), events[0].Used)
+	assert.Equal(t, EventTypeQuotaExceeded, events[1].Type)
+	assert.Equal(t, "acme", events[1].TenantID)
+	assert.Equal(t, ResourceEvents, events[1].Resource)
+	assert.Equal(t, float64(10), events[1].Limit)
+	assert.Equal(t, []string{"tenant.quota_warning", "tenant.quota_exceeded"}, queue.keys)
+
+	// 隔天重新計算，事件再次發布
+	clock.Set(time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))
+	for i := 0; i < 11; i++ {
+		require.NoError(t, handler("threat.detected", tenantEvent))
+	}
+	events = queue.events()
+	require.Len(t, events, 4)
+	assert.Equal(t, "2026-03-02", events[3].Day)
+}
+
+func TestQuotaEnforcerAPIMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tm := NewTenantManager(IsolationSharedDB, testLogger())
+	tm.now = func() time.Time { return time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC) }
+	newQuotaTenant(t, tm, "acme", "UTC", ResourceLimits{MaxUsers: -1, MaxDevices: -1, MaxEvents: -1, MaxStorageGB: -1, MaxAPICallsPerDay: 2, MaxConcurrentConns: -1})
+	newQuotaTenant(t, tm, "globex", "UTC", ResourceLimits{MaxUsers: -1, MaxDevices: -1, MaxEvents: -1, MaxStorageGB: -1, MaxAPICallsPerDay: -1, MaxConcurrentConns: -1})
+
+	enforcer := NewQuotaEnforcer(tm, nil, QuotaConfig{})
+	router := gin.New()
+	router.Use(NewTenantResolver(tm, ResolverConfig{TrustTenantHeader: true}).Middleware())
+	router.Use(enforcer.APIMiddleware())
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	call := func(tenantID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Tenant-ID", tenantID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call("acme")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-Quota-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-Quota-Remaining"))
+	reset := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC).Unix()
+	assert.Equal(t, strconv.FormatInt(reset, 10), w.Header().Get("X-Quota-Reset"))
+
+	assert.Equal(t, http.StatusOK, call("acme").Code)
+	w = call("acme")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-Quota-Remaining"))
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	w = call("globex")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-Quota-Limit"), "unlimited tenants get no quota headers")
+
+	// 未經 TenantResolver 的路由拒絕
+	bare := gin.New()
+	bare.Use(enforcer.APIMiddleware())
+	bare.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	w = httptest.NewRecorder()
+	bare.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestUsageExport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	tm := NewTenantManager(IsolationSharedDB, testLogger())
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}
+	tm.now = clock.Now
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "acme", Name: "Acme", Plan: PlanBasic}))
+	require.NoError(t, tm.IncrementUsage(ctx, "acme", ResourceAPICalls, 5))
+	require.NoError(t, tm.IncrementUsage(ctx, "acme", ResourceEvents, 7))
+	clock.Set(time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))
+	require.NoError(t, tm.IncrementUsage(ctx, "acme", ResourceAPICalls, 1))
+
+	var buf bytes.Buffer
+	require.NoError(t, tm.ExportUsage(ctx, &buf, "csv", "", ""))
+	assert.Equal(t, "day,tenant_id,plan,events,api_calls,users,devices,storage_gb,final\n"+
+		"2026-03-01,acme,basic,7,5,0,0,0,true\n"+
+		"2026-03-02,acme,basic,0,1,0,0,0,false\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, tm.ExportUsage(ctx, &buf, "json", "2026-03-02", ""))
+	var records []UsageRecord
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &records))
+	require.Len(t, records, 1)
+	assert.Equal(t, int64(1), records[0].APICalls)
+	assert.False(t, records[0].Final)
+
+	assert.Error(t, tm.ExportUsage(ctx, &buf, "xml", "", ""))
+	assert.Error(t, tm.ExportUsage(ctx, &buf, "csv", "03/01/2026", ""))
+
+	enforcer := NewQuotaEnforcer(tm, nil, QuotaConfig{})
+	router := gin.New()
+	router.GET("/usage", enforcer.UsageExportHandler())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/usage?format=csv&to=2026-03-01", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "\n"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/usage?from=bad", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
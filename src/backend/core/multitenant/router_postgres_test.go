@@ -0,0 +1,156 @@
+//go:build integration
+// +build integration
+
+package multitenant
+
+import (
+	"context"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// TestTenantRouterSharedSchemas 需要設定 MULTITENANT_TEST_POSTGRES_DSN 指向可建立 schema 的 PostgreSQL
+func TestTenantRouterSharedSchemas(t *testing.T) {
+	dsn := os.Getenv("MULTITENANT_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("未設定 MULTITENANT_TEST_POSTGRES_DSN")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+	system := db.WithContext(WithSystemScope(ctx))
+
+	store, err := NewPostgresTenantStore(db)
+	require.NoError(t, err)
+	defer system.Migrator().DropTable(&tenantRecord{}, &tenantAPIKeyRecord{}, &usageRecord{}, &lifecycleRecord{})
+
+	tm, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	router, err := NewTenantRouter(tm, db, nil)
+	require.NoError(t, err)
+
+	for _, id := range []string{"it_acme", "it_globex"} {
+		require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: id, Name: id}))
+		schema := tm.GetSchemaName(id)
+		require.NoError(t, system.Exec("CREATE SCHEMA IF NOT EXISTS "+quoteIdentifier(schema)).Error)
+		defer system.Exec("DROP SCHEMA IF EXISTS " + quoteIdentifier(schema) + " CASCADE")
+		require.NoError(t, system.Exec("CREATE TABLE "+quoteIdentifier(schema)+".event_records (id serial PRIMARY KEY, message text)").Error)
+	}
+
+	acme, err := tm.GetTenant(ctx, "it_acme")
+	require.NoError(t, err)
+	globex, err := tm.GetTenant(ctx, "it_globex")
+	require.NoError(t, err)
+
+	require.NoError(t, router.WithTenantDB(WithTenant(ctx, acme), func(tx *gorm.DB) error {
+		return tx.Create(&eventRecord{Message: "acme"}).Error
+	}))
+	var events []eventRecord
+	require.NoError(t, router.WithTenantDB(WithTenant(ctx, globex), func(tx *gorm.DB) error {
+		return tx.Find(&events).Error
+	}))
+	assert.Empty(t, events)
+	require.NoError(t, router.WithTenantDB(WithTenant(ctx, acme), func(tx *gorm.DB) error {
+		return tx.Find(&events).Error
+	}))
+	require.Len(t, events, 1)
+	assert.Equal(t, "acme", events[0].Message)
+
+	assert.ErrorIs(t, db.Find(&events).Error, ErrNoTenantContext)
+}
+
+// TestLifecycleSharedSchemas 在 PostgreSQL 上建立、匯出並刪除租戶 schema
+func TestLifecycleSharedSchemas(t *testing.T) {
+	dsn := os.Getenv("MULTITENANT_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("未設定 MULTITENANT_TEST_POSTGRES_DSN")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+	system := db.WithContext(WithSystemScope(ctx))
+
+	store, err := NewPostgresTenantStore(db)
+	require.NoError(t, err)
+	defer system.Migrator().DropTable(&tenantRecord{}, &tenantAPIKeyRecord{}, &usageRecord{}, &lifecycleRecord{})
+
+	tm, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	router, err := NewTenantRouter(tm, db, nil)
+	require.NoError(t, err)
+	defer system.Exec("DROP SCHEMA IF EXISTS " + quoteIdentifier(tm.GetSchemaName("it_lifecycle")) + " CASCADE")
+
+	database := NewSQLTenantDatabase(router, fstest.MapFS{
+		"001_events.sql": {Data: []byte(`CREATE TABLE IF NOT EXISTS event_records (id serial PRIMARY KEY, message text);
+INSERT INTO event_records (message) VALUES ('seed');`)},
+	})
+	lifecycle, err := NewTenantLifecycle(ctx, tm, database, LifecycleConfig{GracePeriod: time.Nanosecond, ExportDir: t.TempDir()})
+	require.NoError(t, err)
+
+	require.NoError(t, lifecycle.Provision(ctx, &Tenant{ID: "it_lifecycle", Name: "Lifecycle"}))
+	tenant, err := tm.GetTenant(ctx, "it_lifecycle")
+	require.NoError(t, err)
+	var events []eventRecord
+	require.NoError(t, router.WithTenantDB(WithTenant(ctx, tenant), func(tx *gorm.DB) error {
+		return tx.Find(&events).Error
+	}))
+	require.Len(t, events, 1)
+
+	require.NoError(t, lifecycle.Delete(ctx, "it_lifecycle", "integration test"))
+	time.Sleep(time.Millisecond)
+	require.NoError(t, lifecycle.Resume(ctx))
+	state, _ := lifecycle.State("it_lifecycle")
+	assert.Equal(t, LifecycleCompleted, state.Status)
+	assert.FileExists(t, state.ExportPath)
+
+	var schemas int64
+	require.NoError(t, system.Raw("SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name = ?", tm.GetSchemaName("it_lifecycle")).Scan(&schemas).Error)
+	assert.Zero(t, schemas)
+}
+
+// TestRevokeAgentsWithoutSchema 租戶 schema 不存在時 search_path 會落到 public，不得撤銷 public 的 agent
+func TestRevokeAgentsWithoutSchema(t *testing.T) {
+	dsn := os.Getenv("MULTITENANT_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("未設定 MULTITENANT_TEST_POSTGRES_DSN")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	ctx := context.Background()
+	system := db.WithContext(WithSystemScope(ctx))
+
+	store, err := NewPostgresTenantStore(db)
+	require.NoError(t, err)
+	defer system.Migrator().DropTable(&tenantRecord{}, &tenantAPIKeyRecord{}, &usageRecord{}, &lifecycleRecord{})
+
+	require.NoError(t, system.Exec(`CREATE TABLE public.agents (id serial PRIMARY KEY, status text, api_key_hash text,
+deregistered_at timestamptz, updated_at timestamptz)`).Error)
+	defer system.Exec("DROP TABLE IF EXISTS public.agents")
+	require.NoError(t, system.Exec("INSERT INTO public.agents (status, api_key_hash) VALUES ('active', 'hash')").Error)
+
+	tm, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	router, err := NewTenantRouter(tm, db, nil)
+	require.NoError(t, err)
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "it_noschema", Name: "No schema"}))
+	tenant, err := tm.GetTenant(ctx, "it_noschema")
+	require.NoError(t, err)
+
+	revoked, err := NewSQLTenantDatabase(router, nil).RevokeAgents(ctx, tenant)
+	require.NoError(t, err)
+	assert.Zero(t, revoked)
+
+	var active int64
+	require.NoError(t, system.Raw("SELECT COUNT(*) FROM public.agents WHERE status = 'active'").Scan(&active).Error)
+	assert.Equal(t, int64(1), active)
+}
@@ -0,0 +1,467 @@
+package multitenant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TenantStore persists the tenant catalog
+type TenantStore interface {
+	// LoadTenants returns all stored tenants, including deleted ones
+	LoadTenants(ctx context.Context) ([]*Tenant, error)
+	// LoadTenant returns a stored tenant, or ErrTenantNotFound
+	LoadTenant(ctx context.Context, tenantID string) (*Tenant, error)
+	// LoadTenantByDomain returns the active tenant of a domain, or ErrTenantNotFound
+	LoadTenantByDomain(ctx context.Context, domain string) (*Tenant, error)
+	// SaveTenant inserts or updates a tenant
+	SaveTenant(ctx context.Context, tenant *Tenant) error
+	// SaveUsage updates only the usage counters of a tenant
+	SaveUsage(ctx context.Context, tenantID string, usage *ResourceUsage) error
+	// AddUsage atomically adds amount to a usage counter and returns its new value. A missing counter
+	// starts from base; when limit is not negative and the result would exceed it nothing changes
+	// and ErrQuotaExceeded is returned with the current value
+	AddUsage(ctx context.Context, tenantID, resource, day string, base, amount, limit int64) (int64, error)
+	// LoadUsageCounters returns the usage counters of a tenant for a day, including the gauges
+	LoadUsageCounters(ctx context.Context, tenantID, day string) (map[string]int64, error)
+	// LoadAPIKeys returns API key hashes mapped to tenant IDs
+	LoadAPIKeys(ctx context.Context) (map[string]string, error)
+	// LoadAPIKeyOwner returns the tenant ID of an API key hash, or ErrTenantNotFound
+	LoadAPIKeyOwner(ctx context.Context, keyHash string) (string, error)
+	// SaveAPIKey stores the hash of a tenant API key
+	SaveAPIKey(ctx context.Context, keyHash, tenantID string) error
+	// DeleteAPIKeys removes all API keys of a tenant and returns how many were removed
+	DeleteAPIKeys(ctx context.Context, tenantID string) (int, error)
+	// SaveUsageRecord inserts or replaces the metered usage of a tenant for one day
+	SaveUsageRecord(ctx context.Context, record UsageRecord) error
+	// LoadUsageRecords returns usage records for days in [from, to] (empty for open)
+	LoadUsageRecords(ctx context.Context, from, to string) ([]UsageRecord, error)
+	// SaveLifecycleState inserts or updates the lifecycle workflow of a tenant
+	SaveLifecycleState(ctx context.Context, state *LifecycleState) error
+	// LoadLifecycleStates returns the lifecycle workflows of all tenants
+	LoadLifecycleStates(ctx context.Context) ([]*LifecycleState, error)
+}
+
+// tenantRecord tenant catalog table; nested settings are stored as JSON
+type tenantRecord struct {
+	ID        string `gorm:"primaryKey;size:64"`
+	Name      string `gorm:"size:255;not null"`
+	Domain    string `gorm:"size:255;index"`
+	Status    string `gorm:"size:32;index;not null"`
+	Plan      string `gorm:"size:32;not null"`
+	Limits    string `gorm:"type:text"`
+	Usage     string `gorm:"type:text"`
+	Config    string `gorm:"type:text"`
+	Metadata  string `gorm:"type:text"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TableName table name
+func (tenantRecord) TableName() string {
+	return "tenants"
+}
+
+// tenantAPIKeyRecord API key table; only the SHA-256 hash of the key is stored
+type tenantAPIKeyRecord struct {
+	KeyHash   string `gorm:"primaryKey;size:64"`
+	TenantID  string `gorm:"size:64;index;not null"`
+	CreatedAt time.Time
+}
+
+// TableName table name
+func (tenantAPIKeyRecord) TableName() string {
+	return "tenant_api_keys"
+}
+
+// usageRecord daily metering table
+type usageRecord struct {
+	TenantID  string `gorm:"primaryKey;size:64"`
+	Day       string `gorm:"primaryKey;size:10"`
+	Plan      string `gorm:"size:32"`
+	Events    int64
+	APICalls  int64
+	Users     int
+	Devices   int
+	StorageGB float64
+	Final     bool
+	UpdatedAt time.Time
+}
+
+// TableName table name
+func (usageRecord) TableName() string {
+	return "tenant_usage_daily"
+}
+
+// usageCounterRecord usage counter table; daily counters are keyed by the tenant-local day,
+// gauges (users, devices, storage, connections) by an empty day
+type usageCounterRecord struct {
+	TenantID  string `gorm:"primaryKey;size:64"`
+	Resource  string `gorm:"primaryKey;size:32"`
+	Day       string `gorm:"primaryKey;size:10"`
+	Value     int64  `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+// TableName table name
+func (usageCounterRecord) TableName() string {
+	return "tenant_usage_counters"
+}
+
+// lifecycleRecord lifecycle workflow table; one row per tenant holding its latest workflow
+type lifecycleRecord struct {
+	TenantID   string `gorm:"primaryKey;size:64"`
+	Operation  string `gorm:"size:32;not null"`
+	Status     string `gorm:"size:32;index;not null"`
+	Step       string `gorm:"size:64"`
+	Reason     string `gorm:"type:text"`
+	Error      string `gorm:"type:text"`
+	ExportPath string `gorm:"size:512"`
+	PurgeAfter time.Time
+	StartedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// TableName table name
+func (lifecycleRecord) TableName() string {
+	return "tenant_lifecycle"
+}
+
+// PostgresTenantStore stores the tenant catalog in PostgreSQL (public schema of the shared database)
+type PostgresTenantStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresTenantStore creates the store and migrates its tables
+func NewPostgresTenantStore(db *gorm.DB) (*PostgresTenantStore, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database connection is required")
+	}
+	store := &PostgresTenantStore{db: db}
+	if err := store.session(context.Background()).AutoMigrate(&tenantRecord{}, &tenantAPIKeyRecord{}, &usageRecord{}, &usageCounterRecord{}, &lifecycleRecord{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate tenant tables: %w", err)
+	}
+	return store, nil
+}
+
+// session the tenant catalog is global, so it runs in system scope
+func (s *PostgresTenantStore) session(ctx context.Context) *gorm.DB {
+	return s.db.WithContext(WithSystemScope(ctx))
+}
+
+// LoadTenants returns all stored tenants
+func (s *PostgresTenantStore) LoadTenants(ctx context.Context) ([]*Tenant, error) {
+	var records []tenantRecord
+	if err := s.session(ctx).Order("created_at").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to load tenants: %w", err)
+	}
+
+	tenants := make([]*Tenant, 0, len(records))
+	for _, record := range records {
+		tenant, err := record.toTenant()
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+// LoadTenant returns a stored tenant
+func (s *PostgresTenantStore) LoadTenant(ctx context.Context, tenantID string) (*Tenant, error) {
+	return s.loadTenant(ctx, "id = ?", tenantID)
+}
+
+// LoadTenantByDomain returns the active tenant of a domain
+func (s *PostgresTenantStore) LoadTenantByDomain(ctx context.Context, domain string) (*Tenant, error) {
+	return s.loadTenant(ctx, "domain = ? AND status = ?", domain, string(TenantStatusActive))
+}
+
+// loadTenant returns the first tenant matching the condition
+func (s *PostgresTenantStore) loadTenant(ctx context.Context, query string, args ...interface{}) (*Tenant, error) {
+	var record tenantRecord
+	err := s.session(ctx).Where(query, args...).Order("created_at").Take(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tenant: %w", err)
+	}
+	return record.toTenant()
+}
+
+// SaveTenant inserts or updates a tenant
+func (s *PostgresTenantStore) SaveTenant(ctx context.Context, tenant *Tenant) error {
+	record, err := newTenantRecord(tenant)
+	if err != nil {
+		return err
+	}
+	err = s.session(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(record).Error
+	if err != nil {
+		return fmt.Errorf("failed to save tenant %s: %w", tenant.ID, err)
+	}
+	return nil
+}
+
+// SaveUsage updates only the usage column of a tenant
+func (s *PostgresTenantStore) SaveUsage(ctx context.Context, tenantID string, usage *ResourceUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return fmt.Errorf("failed to encode usage: %w", err)
+	}
+	err = s.session(ctx).Model(&tenantRecord{}).Where("id = ?", tenantID).Update("usage", string(data)).Error
+	if err != nil {
+		return fmt.Errorf("failed to save usage for tenant %s: %w", tenantID, err)
+	}
+	return nil
+}
+
+// AddUsage increments a usage counter with a single conditional UPDATE, so concurrent
+// replicas never lose increments or exceed the limit together
+func (s *PostgresTenantStore) AddUsage(ctx context.Context, tenantID, resource, day string, base, amount, limit int64) (int64, error) {
+	db := s.session(ctx)
+	now := time.Now()
+	initial := &usageCounterRecord{TenantID: tenantID, Resource: resource, Day: day, Value: base, UpdatedAt: now}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(initial).Error; err != nil {
+		return 0, fmt.Errorf("failed to create usage counter for tenant %s: %w", tenantID, err)
+	}
+
+	var values []int64
+	err := db.Raw(`UPDATE tenant_usage_counters SET value = value + ?, updated_at = ?
+		WHERE tenant_id = ? AND resource = ? AND day = ? AND (? OR value + ? <= ?)
+		RETURNING value`, amount, now, tenantID, resource, day, limit < 0, amount, limit).Scan(&values).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to add usage for tenant %s: %w", tenantID, err)
+	}
+	if len(values) > 0 {
+		return values[0], nil
+	}
+
+	var current usageCounterRecord
+	if err := db.Where("tenant_id = ? AND resource = ? AND day = ?", tenantID, resource, day).First(&current).Error; err != nil {
+		return 0, fmt.Errorf("failed to load usage for tenant %s: %w", tenantID, err)
+	}
+	return current.Value, ErrQuotaExceeded
+}
+
+// LoadUsageCounters returns the counters of a tenant for a day and its gauges
+func (s *PostgresTenantStore) LoadUsageCounters(ctx context.Context, tenantID, day string) (map[string]int64, error) {
+	var records []usageCounterRecord
+	err := s.session(ctx).Where("tenant_id = ? AND (day = ? OR day = ?)", tenantID, day, "").Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage for tenant %s: %w", tenantID, err)
+	}
+	counters := make(map[string]int64, len(records))
+	for _, record := range records {
+		counters[record.Resource] = record.Value
+	}
+	return counters, nil
+}
+
+// LoadAPIKeys returns API key hashes mapped to tenant IDs
+func (s *PostgresTenantStore) LoadAPIKeys(ctx context.Context) (map[string]string, error) {
+	var records []tenantAPIKeyRecord
+	if err := s.session(ctx).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to load API keys: %w", err)
+	}
+	keys := make(map[string]string, len(records))
+	for _, record := range records {
+		keys[record.KeyHash] = record.TenantID
+	}
+	return keys, nil
+}
+
+// LoadAPIKeyOwner returns the tenant ID of an API key hash
+func (s *PostgresTenantStore) LoadAPIKeyOwner(ctx context.Context, keyHash string) (string, error) {
+	var record tenantAPIKeyRecord
+	err := s.session(ctx).Where("key_hash = ?", keyHash).Take(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrTenantNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load API key: %w", err)
+	}
+	return record.TenantID, nil
+}
+
+// SaveAPIKey stores the hash of a tenant API key
+func (s *PostgresTenantStore) SaveAPIKey(ctx context.Context, keyHash, tenantID string) error {
+	record := &tenantAPIKeyRecord{KeyHash: keyHash, TenantID: tenantID, CreatedAt: time.Now()}
+	if err := s.session(ctx).Create(record).Error; err != nil {
+		return fmt.Errorf("failed to save API key for tenant %s: %w", tenantID, err)
+	}
+	return nil
+}
+
+// DeleteAPIKeys removes all API keys of a tenant
+func (s *PostgresTenantStore) DeleteAPIKeys(ctx context.Context, tenantID string) (int, error) {
+	result := s.session(ctx).Where("tenant_id = ?", tenantID).Delete(&tenantAPIKeyRecord{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete API keys for tenant %s: %w", tenantID, result.Error)
+	}
+	return int(result.RowsAffected), nil
+}
+
+// SaveUsageRecord inserts or replaces the metered usage of a tenant for one day
+func (s *PostgresTenantStore) SaveUsageRecord(ctx context.Context, record UsageRecord) error {
+	row := &usageRecord{
+		TenantID:  record.TenantID,
+		Day:       record.Day,
+		Plan:      string(record.Plan),
+		Events:    record.Events,
+		APICalls:  record.APICalls,
+		Users:     record.Users,
+		Devices:   record.Devices,
+		StorageGB: record.StorageGB,
+		Final:     record.Final,
+	}
+	if err := s.session(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(row).Error; err != nil {
+		return fmt.Errorf("failed to save usage record for tenant %s: %w", record.TenantID, err)
+	}
+	return nil
+}
+
+// LoadUsageRecords returns usage records for days in [from, to]
+func (s *PostgresTenantStore) LoadUsageRecords(ctx context.Context, from, to string) ([]UsageRecord, error) {
+	query := s.session(ctx).Order("day, tenant_id")
+	if from != "" {
+		query = query.Where("day >= ?", from)
+	}
+	if to != "" {
+		query = query.Where("day <= ?", to)
+	}
+	var rows []usageRecord
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load usage records: %w", err)
+	}
+
+	records := make([]UsageRecord, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, UsageRecord{
+			TenantID:  row.TenantID,
+			Day:       row.Day,
+			Plan:      SubscriptionPlan(row.Plan),
+			Events:    row.Events,
+			APICalls:  row.APICalls,
+			Users:     row.Users,
+			Devices:   row.Devices,
+			StorageGB: row.StorageGB,
+			Final:     row.Final,
+		})
+	}
+	return records, nil
+}
+
+// SaveLifecycleState inserts or updates the lifecycle workflow of a tenant
+func (s *PostgresTenantStore) SaveLifecycleState(ctx context.Context, state *LifecycleState) error {
+	record := &lifecycleRecord{
+		TenantID:   state.TenantID,
+		Operation:  string(state.Operation),
+		Status:     string(state.Status),
+		Step:       state.Step,
+		Reason:     state.Reason,
+		Error:      state.Error,
+		ExportPath: state.ExportPath,
+		PurgeAfter: state.PurgeAfter,
+		StartedAt:  state.StartedAt,
+		UpdatedAt:  state.UpdatedAt,
+	}
+	if err := s.session(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(record).Error; err != nil {
+		return fmt.Errorf("failed to save lifecycle state for tenant %s: %w", state.TenantID, err)
+	}
+	return nil
+}
+
+// LoadLifecycleStates returns the lifecycle workflows of all tenants
+func (s *PostgresTenantStore) LoadLifecycleStates(ctx context.Context) ([]*LifecycleState, error) {
+	var records []lifecycleRecord
+	if err := s.session(ctx).Order("started_at").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to load lifecycle states: %w", err)
+	}
+
+	states := make([]*LifecycleState, 0, len(records))
+	for _, record := range records {
+		states = append(states, &LifecycleState{
+			TenantID:   record.TenantID,
+			Operation:  LifecycleOperation(record.Operation),
+			Status:     LifecycleStatus(record.Status),
+			Step:       record.Step,
+			Reason:     record.Reason,
+			Error:      record.Error,
+			ExportPath: record.ExportPath,
+			PurgeAfter: record.PurgeAfter,
+			StartedAt:  record.StartedAt,
+			UpdatedAt:  record.UpdatedAt,
+		})
+	}
+	return states, nil
+}
+
+// newTenantRecord converts a tenant into its table row
+func newTenantRecord(tenant *Tenant) (*tenantRecord, error) {
+	record := &tenantRecord{
+		ID:        tenant.ID,
+		Name:      tenant.Name,
+		Domain:    tenant.Domain,
+		Status:    string(tenant.Status),
+		Plan:      string(tenant.Plan),
+		CreatedAt: tenant.CreatedAt,
+		UpdatedAt: tenant.UpdatedAt,
+	}
+	fields := []struct {
+		target *string
+		value  interface{}
+	}{
+		{&record.Limits, tenant.Limits},
+		{&record.Usage, tenant.Usage},
+		{&record.Config, tenant.Config},
+		{&record.Metadata, tenant.Metadata},
+	}
+	for _, field := range fields {
+		data, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode tenant %s: %w", tenant.ID, err)
+		}
+		*field.target = string(data)
+	}
+	return record, nil
+}
+
+// toTenant converts a table row back into a tenant
+func (r *tenantRecord) toTenant() (*Tenant, error) {
+	tenant := &Tenant{
+		ID:        r.ID,
+		Name:      r.Name,
+		Domain:    r.Domain,
+		Status:    TenantStatus(r.Status),
+		Plan:      SubscriptionPlan(r.Plan),
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+	fields := []struct {
+		data   string
+		target interface{}
+	}{
+		{r.Limits, &tenant.Limits},
+		{r.Usage, &tenant.Usage},
+		{r.Config, &tenant.Config},
+		{r.Metadata, &tenant.Metadata},
+	}
+	for _, field := range fields {
+		if field.data == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(field.data), field.target); err != nil {
+			return nil, fmt.Errorf("failed to decode tenant %s: %w", r.ID, err)
+		}
+	}
+	if tenant.Usage == nil {
+		tenant.Usage = &ResourceUsage{}
+	}
+	return tenant, nil
+}
@@ -2,7 +2,12 @@ package multitenant
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"regexp"
 	"sync"
 	"time"
 
@@ -15,8 +20,18 @@ type TenantManager struct {
 	mu         sync.RWMutex
 	logger     *logrus.Logger
 	isolation  IsolationLevel
+	store      TenantStore
+	apiKeys    map[string]string // API key hash -> tenant ID when there is no store
+	history    []UsageRecord     // closed usage days when there is no store
+	now        func() time.Time
 }
 
+// ErrTenantNotFound is returned by a TenantStore for unknown tenants and API keys
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// tenantIDPattern tenant IDs become schema and database names, so only safe identifiers are allowed
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_]{0,47}$`)
+
 // Tenant represents a tenant in the system
 type Tenant struct {
 	ID          string
@@ -44,9 +59,11 @@ type Tenant struct {
 type TenantStatus string
 
 const (
-	TenantStatusActive    TenantStatus = "active"
-	TenantStatusSuspended TenantStatus = "suspended"
-	TenantStatusDeleted   TenantStatus = "deleted"
+	TenantStatusProvisioning TenantStatus = "provisioning" // database is being created (see TenantLifecycle)
+	TenantStatusActive       TenantStatus = "active"
+	TenantStatusSuspended    TenantStatus = "suspended"
+	TenantStatusDeleting     TenantStatus = "deleting" // waiting for the purge grace period
+	TenantStatusDeleted      TenantStatus = "deleted"
 )
 
 // SubscriptionPlan represents subscription plans
@@ -87,6 +104,7 @@ type ResourceUsage struct {
 	APICallsToday      int64
 	ConcurrentConns    int
 	LastUpdated        time.Time
+	Day                string // calendar day (tenant timezone) the daily counters belong to
 }
 
 // TenantConfig contains tenant-specific configuration
@@ -110,20 +128,73 @@ func NewTenantManager(isolation IsolationLevel, logger *logrus.Logger) *TenantMa
 		tenants:   make(map[string]*Tenant),
 		logger:    logger,
 		isolation: isolation,
+		apiKeys:   make(map[string]string),
+		now:       time.Now,
 	}
 }
 
+// NewPersistentTenantManager creates a tenant manager backed by a store and loads the stored tenants.
+// Every change is written through to the store before it becomes visible. Tenant and API key lookups
+// read through to the store, so suspensions and revocations made by other replicas apply immediately
+func NewPersistentTenantManager(ctx context.Context, isolation IsolationLevel, store TenantStore, logger *logrus.Logger) (*TenantManager, error) {
+	if store == nil {
+		return nil, fmt.Errorf("tenant store is required")
+	}
+	tm := NewTenantManager(isolation, logger)
+	tm.store = store
+
+	tenants, err := store.LoadTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, tenant := range tenants {
+		tm.refreshUsageLocked(ctx, tenant)
+		tm.tenants[tenant.ID] = tenant
+	}
+
+	tm.logger.Infof("Loaded %d tenants from store", len(tenants))
+	return tm, nil
+}
+
+// Isolation returns the data isolation level
+func (tm *TenantManager) Isolation() IsolationLevel {
+	return tm.isolation
+}
+
+// persist writes a tenant through to the store (caller holds the lock)
+func (tm *TenantManager) persist(ctx context.Context, tenant *Tenant) error {
+	if tm.store == nil {
+		return nil
+	}
+	return tm.store.SaveTenant(ctx, tenant)
+}
+
 // CreateTenant creates a new tenant
 func (tm *TenantManager) CreateTenant(ctx context.Context, tenant *Tenant) error {
+	return tm.createTenant(ctx, tenant, TenantStatusActive)
+}
+
+// createTenant creates a new tenant with the given initial status
+func (tm *TenantManager) createTenant(ctx context.Context, tenant *Tenant, status TenantStatus) error {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
+	if !tenantIDPattern.MatchString(tenant.ID) {
+		return fmt.Errorf("invalid tenant ID %q: use lowercase letters, digits and underscores", tenant.ID)
+	}
 	if _, exists := tm.tenants[tenant.ID]; exists {
 		return fmt.Errorf("tenant %s already exists", tenant.ID)
 	}
+	if tenant.Domain != "" {
+		for _, existing := range tm.tenants {
+			if existing.Domain == tenant.Domain && existing.Status != TenantStatusDeleted {
+				return fmt.Errorf("domain %s is already used by tenant %s", tenant.Domain, existing.ID)
+			}
+		}
+	}
 
 	// 設置預設值
-	tenant.Status = TenantStatusActive
+	tenant.Status = status
 	tenant.CreatedAt = time.Now()
 	tenant.UpdatedAt = time.Now()
 
@@ -133,6 +204,7 @@ func (tm *TenantManager) CreateTenant(ctx context.Context, tenant *Tenant) error
 	// 初始化使用量
 	tenant.Usage = &ResourceUsage{
 		LastUpdated: time.Now(),
+		Day:         tm.now().In(tenantLocation(tenant)).Format(usageDayLayout),
 	}
 
 	// 初始化配置
@@ -140,6 +212,9 @@ func (tm *TenantManager) CreateTenant(ctx context.Context, tenant *Tenant) error
 		tenant.Config = tm.getDefaultConfig(tenant.Plan)
 	}
 
+	if err := tm.persist(ctx, tenant); err != nil {
+		return err
+	}
 	tm.tenants[tenant.ID] = tenant
 	tm.logger.Infof("Created tenant: %s (%s)", tenant.ID, tenant.Name)
 
@@ -148,6 +223,17 @@ func (tm *TenantManager) CreateTenant(ctx context.Context, tenant *Tenant) error
 
 // GetTenant retrieves a tenant by ID
 func (tm *TenantManager) GetTenant(ctx context.Context, tenantID string) (*Tenant, error) {
+	if tm.store != nil {
+		stored, err := tm.store.LoadTenant(ctx, tenantID)
+		if errors.Is(err, ErrTenantNotFound) {
+			return nil, fmt.Errorf("tenant %s not found", tenantID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return tm.syncTenant(ctx, stored), nil
+	}
+
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
 
@@ -159,6 +245,23 @@ func (tm *TenantManager) GetTenant(ctx context.Context, tenantID string) (*Tenan
 	return tenant, nil
 }
 
+// syncTenant replaces the cached copy of a tenant with the stored one, keeping the cached usage
+// counters (they are kept current by the store's counters); returns the cached tenant
+func (tm *TenantManager) syncTenant(ctx context.Context, stored *Tenant) *Tenant {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	cached, exists := tm.tenants[stored.ID]
+	if !exists {
+		tm.refreshUsageLocked(ctx, stored)
+		tm.tenants[stored.ID] = stored
+		return stored
+	}
+	stored.Usage = cached.Usage
+	*cached = *stored
+	return cached
+}
+
 // UpdateTenant updates a tenant
 func (tm *TenantManager) UpdateTenant(ctx context.Context, tenant *Tenant) error {
 	tm.mu.Lock()
@@ -169,6 +272,9 @@ func (tm *TenantManager) UpdateTenant(ctx context.Context, tenant *Tenant) error
 	}
 
 	tenant.UpdatedAt = time.Now()
+	if err := tm.persist(ctx, tenant); err != nil {
+		return err
+	}
 	tm.tenants[tenant.ID] = tenant
 
 	tm.logger.Infof("Updated tenant: %s", tenant.ID)
@@ -177,6 +283,21 @@ func (tm *TenantManager) UpdateTenant(ctx context.Context, tenant *Tenant) error
 
 // DeleteTenant deletes a tenant
 func (tm *TenantManager) DeleteTenant(ctx context.Context, tenantID string) error {
+	if err := tm.setStatus(ctx, tenantID, TenantStatusDeleted, nil); err != nil {
+		return err
+	}
+
+	tm.logger.Infof("Deleted tenant: %s", tenantID)
+	return nil
+}
+
+// setStatus changes the status of a tenant and merges metadata into its metadata
+func (tm *TenantManager) setStatus(ctx context.Context, tenantID string, status TenantStatus, metadata map[string]string) error {
+	// 以儲存中的最新版本為基礎，避免覆寫其他副本的變更
+	if _, err := tm.GetTenant(ctx, tenantID); err != nil {
+		return err
+	}
+
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
@@ -185,59 +306,47 @@ func (tm *TenantManager) DeleteTenant(ctx context.Context, tenantID string) erro
 		return fmt.Errorf("tenant %s not found", tenantID)
 	}
 
-	tenant.Status = TenantStatusDeleted
-	tenant.UpdatedAt = time.Now()
-
-	tm.logger.Infof("Deleted tenant: %s", tenantID)
-	return nil
-}
-
-// CheckResourceLimit checks if tenant has exceeded resource limits
-func (tm *TenantManager) CheckResourceLimit(ctx context.Context, tenantID string, resource string) (bool, error) {
-	tenant, err := tm.GetTenant(ctx, tenantID)
-	if err != nil {
-		return false, err
+	updated := *tenant
+	updated.Status = status
+	updated.UpdatedAt = time.Now()
+	if len(metadata) > 0 {
+		updated.Metadata = make(map[string]string, len(tenant.Metadata)+len(metadata))
+		for key, value := range tenant.Metadata {
+			updated.Metadata[key] = value
+		}
+		for key, value := range metadata {
+			updated.Metadata[key] = value
+		}
 	}
-
-	switch resource {
-	case "users":
-		return tenant.Usage.CurrentUsers < tenant.Limits.MaxUsers, nil
-	case "devices":
-		return tenant.Usage.CurrentDevices < tenant.Limits.MaxDevices, nil
-	case "events":
-		return tenant.Usage.EventsToday < tenant.Limits.MaxEvents, nil
-	case "storage":
-		return tenant.Usage.StorageUsedGB < float64(tenant.Limits.MaxStorageGB), nil
-	case "api_calls":
-		return tenant.Usage.APICallsToday < tenant.Limits.MaxAPICallsPerDay, nil
-	case "connections":
-		return tenant.Usage.ConcurrentConns < tenant.Limits.MaxConcurrentConns, nil
-	default:
-		return false, fmt.Errorf("unknown resource: %s", resource)
+	if err := tm.persist(ctx, &updated); err != nil {
+		return err
 	}
+	*tenant = updated
+	return nil
 }
 
-// IncrementUsage increments resource usage
-func (tm *TenantManager) IncrementUsage(ctx context.Context, tenantID string, resource string, amount int64) error {
+// CheckResourceLimit checks if tenant has exceeded resource limits (negative limits are unlimited)
+func (tm *TenantManager) CheckResourceLimit(ctx context.Context, tenantID string, resource string) (bool, error) {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
 
 	tenant, exists := tm.tenants[tenantID]
 	if !exists {
-		return fmt.Errorf("tenant %s not found", tenantID)
+		return false, fmt.Errorf("tenant %s not found", tenantID)
 	}
+	tm.rolloverLocked(ctx, tenant)
 
-	switch resource {
-	case "events":
-		tenant.Usage.EventsToday += amount
-	case "api_calls":
-		tenant.Usage.APICallsToday += amount
-	case "storage":
-		tenant.Usage.StorageUsedGB += float64(amount)
+	used, limit, err := resourceUsage(tenant, resource)
+	if err != nil {
+		return false, err
 	}
+	return limit < 0 || used < limit, nil
+}
 
-	tenant.Usage.LastUpdated = time.Now()
-	return nil
+// IncrementUsage increments resource usage without enforcing limits (see ConsumeQuota)
+func (tm *TenantManager) IncrementUsage(ctx context.Context, tenantID string, resource string, amount int64) error {
+	_, err := tm.changeUsage(ctx, tenantID, resource, amount, false)
+	return err
 }
 
 // GetDatabaseName returns the database name for a tenant
@@ -332,6 +441,20 @@ func (tm *TenantManager) getDefaultConfig(plan SubscriptionPlan) *TenantConfig {
 
 // ListTenants lists all tenants
 func (tm *TenantManager) ListTenants(ctx context.Context) ([]*Tenant, error) {
+	if tm.store != nil {
+		stored, err := tm.store.LoadTenants(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tenants := make([]*Tenant, 0, len(stored))
+		for _, tenant := range stored {
+			if tenant = tm.syncTenant(ctx, tenant); tenant.Status != TenantStatusDeleted {
+				tenants = append(tenants, tenant)
+			}
+		}
+		return tenants, nil
+	}
+
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
 
@@ -347,6 +470,17 @@ func (tm *TenantManager) ListTenants(ctx context.Context) ([]*Tenant, error) {
 
 // GetTenantByDomain retrieves a tenant by domain
 func (tm *TenantManager) GetTenantByDomain(ctx context.Context, domain string) (*Tenant, error) {
+	if tm.store != nil {
+		stored, err := tm.store.LoadTenantByDomain(ctx, domain)
+		if errors.Is(err, ErrTenantNotFound) {
+			return nil, fmt.Errorf("tenant not found for domain: %s", domain)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return tm.syncTenant(ctx, stored), nil
+	}
+
 	tm.mu.RLock()
 	defer tm.mu.RUnlock()
 
@@ -359,40 +493,22 @@ func (tm *TenantManager) GetTenantByDomain(ctx context.Context, domain string) (
 	return nil, fmt.Errorf("tenant not found for domain: %s", domain)
 }
 
-// SuspendTenant suspends a tenant
+// SuspendTenant suspends a tenant (see TenantLifecycle.Suspend for revoking its credentials)
 func (tm *TenantManager) SuspendTenant(ctx context.Context, tenantID string, reason string) error {
-	tm.mu.Lock()
-	defer tm.mu.Unlock()
-
-	tenant, exists := tm.tenants[tenantID]
-	if !exists {
-		return fmt.Errorf("tenant %s not found", tenantID)
+	if err := tm.setStatus(ctx, tenantID, TenantStatusSuspended, map[string]string{"suspension_reason": reason}); err != nil {
+		return err
 	}
 
-	tenant.Status = TenantStatusSuspended
-	tenant.UpdatedAt = time.Now()
-	if tenant.Metadata == nil {
-		tenant.Metadata = make(map[string]string)
-	}
-	tenant.Metadata["suspension_reason"] = reason
-
 	tm.logger.Warnf("Suspended tenant %s: %s", tenantID, reason)
 	return nil
 }
 
 // ReactivateTenant reactivates a suspended tenant
 func (tm *TenantManager) ReactivateTenant(ctx context.Context, tenantID string) error {
-	tm.mu.Lock()
-	defer tm.mu.Unlock()
-
-	tenant, exists := tm.tenants[tenantID]
-	if !exists {
-		return fmt.Errorf("tenant %s not found", tenantID)
+	if err := tm.setStatus(ctx, tenantID, TenantStatusActive, nil); err != nil {
+		return err
 	}
 
-	tenant.Status = TenantStatusActive
-	tenant.UpdatedAt = time.Now()
-
 	tm.logger.Infof("Reactivated tenant: %s", tenantID)
 	return nil
 }
@@ -427,3 +543,94 @@ func (tm *TenantManager) GetTenantStats() map[string]interface{} {
 	return stats
 }
 
+// IssueAPIKey creates a new API key for a tenant. Only the key hash is kept; the key is returned once
+func (tm *TenantManager) IssueAPIKey(ctx context.Context, tenantID string) (string, error) {
+	tenant, err := tm.GetTenant(ctx, tenantID)
+	if err != nil || tenant.Status == TenantStatusDeleted {
+		return "", fmt.Errorf("tenant %s not found", tenantID)
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	keyBytes := make([]byte, 32)
+	if _, err := rand.Read(keyBytes); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	key := "pk_" + hex.EncodeToString(keyBytes)
+	keyHash := hashAPIKey(key)
+
+	if tm.store != nil {
+		if err := tm.store.SaveAPIKey(ctx, keyHash, tenantID); err != nil {
+			return "", err
+		}
+	} else {
+		tm.apiKeys[keyHash] = tenantID
+	}
+
+	tm.logger.Infof("Issued API key for tenant: %s", tenantID)
+	return key, nil
+}
+
+// GetTenantByAPIKey retrieves the active tenant that owns an API key
+func (tm *TenantManager) GetTenantByAPIKey(ctx context.Context, key string) (*Tenant, error) {
+	if tm.store != nil {
+		tenantID, err := tm.store.LoadAPIKeyOwner(ctx, hashAPIKey(key))
+		if errors.Is(err, ErrTenantNotFound) {
+			return nil, fmt.Errorf("unknown API key")
+		}
+		if err != nil {
+			return nil, err
+		}
+		tenant, err := tm.GetTenant(ctx, tenantID)
+		if err != nil || tenant.Status != TenantStatusActive {
+			return nil, fmt.Errorf("tenant %s is not active", tenantID)
+		}
+		return tenant, nil
+	}
+
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	tenantID, exists := tm.apiKeys[hashAPIKey(key)]
+	if !exists {
+		return nil, fmt.Errorf("unknown API key")
+	}
+	tenant, exists := tm.tenants[tenantID]
+	if !exists || tenant.Status != TenantStatusActive {
+		return nil, fmt.Errorf("tenant %s is not active", tenantID)
+	}
+
+	return tenant, nil
+}
+
+// RevokeAPIKeys revokes all API keys of a tenant and returns how many were revoked
+func (tm *TenantManager) RevokeAPIKeys(ctx context.Context, tenantID string) (int, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	revoked := 0
+	if tm.store != nil {
+		var err error
+		if revoked, err = tm.store.DeleteAPIKeys(ctx, tenantID); err != nil {
+			return 0, err
+		}
+	}
+	for keyHash, owner := range tm.apiKeys {
+		if owner == tenantID {
+			delete(tm.apiKeys, keyHash)
+			revoked++
+		}
+	}
+
+	if revoked > 0 {
+		tm.logger.Infof("Revoked %d API keys for tenant: %s", revoked, tenantID)
+	}
+	return revoked, nil
+}
+
+// hashAPIKey returns the hex SHA-256 of an API key
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
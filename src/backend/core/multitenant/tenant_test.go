@@ -0,0 +1,286 @@
+package multitenant
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func testLogger() *logrus.Logger {
+	l := logrus.New()
+	l.SetLevel(logrus.WarnLevel)
+	return l
+}
+
+func openTestDB(t *testing.T, dsn string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func newTestStore(t *testing.T) (*gorm.DB, *PostgresTenantStore) {
+	t.Helper()
+	db := openTestDB(t, fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()))
+	store, err := NewPostgresTenantStore(db)
+	require.NoError(t, err)
+	return db, store
+}
+
+// eventRecord tenant-scoped table used by the tests
+type eventRecord struct {
+	ID      uint `gorm:"primaryKey"`
+	Message string
+}
+
+func TestPersistentTenantManager(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+
+	tm, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "acme", Name: "Acme", Domain: "acme.example.com", Plan: PlanBasic}))
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "globex", Name: "Globex", Plan: PlanFree}))
+	assert.Error(t, tm.CreateTenant(ctx, &Tenant{ID: "Robert'); DROP", Name: "bad"}))
+	assert.Error(t, tm.CreateTenant(ctx, &Tenant{ID: "acme2", Name: "dup", Domain: "acme.example.com"}))
+	require.NoError(t, tm.IncrementUsage(ctx, "acme", "api_calls", 7))
+	require.NoError(t, tm.SuspendTenant(ctx, "globex", "unpaid"))
+	key, err := tm.IssueAPIKey(ctx, "acme")
+	require.NoError(t, err)
+
+	// 重新載入後狀態一致
+	reloaded, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	acme, err := reloaded.GetTenant(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, "Acme", acme.Name)
+	assert.Equal(t, PlanBasic, acme.Plan)
+	assert.Equal(t, int64(7), acme.Usage.APICallsToday)
+	assert.Equal(t, int64(10000), acme.Limits.MaxAPICallsPerDay)
+	assert.True(t, acme.Config.EnableAdvancedFeatures)
+	globex, err := reloaded.GetTenant(ctx, "globex")
+	require.NoError(t, err)
+	assert.Equal(t, TenantStatusSuspended, globex.Status)
+	assert.Equal(t, "unpaid", globex.Metadata["suspension_reason"])
+
+	byKey, err := reloaded.GetTenantByAPIKey(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "acme", byKey.ID)
+	_, err = reloaded.GetTenantByAPIKey(ctx, "pk_unknown")
+	assert.Error(t, err)
+	revoked, err := reloaded.RevokeAPIKeys(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	_, err = reloaded.GetTenantByAPIKey(ctx, key)
+	assert.Error(t, err)
+
+	again, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	_, err = again.GetTenantByAPIKey(ctx, key)
+	assert.Error(t, err)
+}
+
+// TestTenantChangesAcrossReplicas 另一個副本的停用、撤銷與新增租戶立即生效
+func TestTenantChangesAcrossReplicas(t *testing.T) {
+	_, store := newTestStore(t)
+	ctx := context.Background()
+	admin, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	require.NoError(t, admin.CreateTenant(ctx, &Tenant{ID: "acme", Name: "Acme", Domain: "acme.example.com", Plan: PlanBasic}))
+	require.NoError(t, admin.CreateTenant(ctx, &Tenant{ID: "globex", Name: "Globex", Plan: PlanBasic}))
+	acmeKey, err := admin.IssueAPIKey(ctx, "acme")
+	require.NoError(t, err)
+	globexKey, err := admin.IssueAPIKey(ctx, "globex")
+	require.NoError(t, err)
+
+	replica, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	_, err = replica.GetTenantByAPIKey(ctx, acmeKey)
+	require.NoError(t, err)
+	_, err = replica.GetTenantByDomain(ctx, "acme.example.com")
+	require.NoError(t, err)
+
+	require.NoError(t, admin.SuspendTenant(ctx, "acme", "unpaid"))
+	_, err = replica.GetTenantByAPIKey(ctx, acmeKey)
+	assert.Error(t, err, "停用後 API key 失效")
+	_, err = replica.GetTenantByDomain(ctx, "acme.example.com")
+	assert.Error(t, err)
+	acme, err := replica.GetTenant(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, TenantStatusSuspended, acme.Status)
+
+	revoked, err := admin.RevokeAPIKeys(ctx, "globex")
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	_, err = replica.GetTenantByAPIKey(ctx, globexKey)
+	assert.Error(t, err, "撤銷後 API key 失效")
+
+	require.NoError(t, admin.CreateTenant(ctx, &Tenant{ID: "initech", Name: "Initech", Plan: PlanFree}))
+	tenants, err := replica.ListTenants(ctx)
+	require.NoError(t, err)
+	assert.Len(t, tenants, 3)
+	require.NoError(t, replica.ReactivateTenant(ctx, "acme"))
+	_, err = admin.GetTenantByAPIKey(ctx, acmeKey)
+	assert.NoError(t, err)
+}
+
+func TestTenantResolverMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	tm := NewTenantManager(IsolationSharedDB, testLogger())
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "acme", Name: "Acme", Domain: "acme.example.com"}))
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "globex", Name: "Globex"}))
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "initech", Name: "Initech"}))
+	require.NoError(t, tm.SuspendTenant(ctx, "initech", "abuse"))
+	key, err := tm.IssueAPIKey(ctx, "globex")
+	require.NoError(t, err)
+
+	serve := func(config ResolverConfig, host string, headers map[string]string) (int, string) {
+		router := gin.New()
+		router.Use(NewTenantResolver(tm, config).Middleware())
+		router.GET("/", func(c *gin.Context) {
+			tenant, ok := TenantFromContext(c.Request.Context())
+			require.True(t, ok)
+			c.String(http.StatusOK, tenant.ID)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = host
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+
+	code, body := serve(ResolverConfig{}, "acme.example.com:8443", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "acme", body)
+
+	code, body = serve(ResolverConfig{}, "acme.example.com", map[string]string{"X-API-Key": key})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "globex", body, "API key takes precedence over domain")
+
+	code, _ = serve(ResolverConfig{}, "api.example.com", map[string]string{"X-API-Key": "pk_forged"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// 未信任時忽略租戶標頭
+	code, _ = serve(ResolverConfig{}, "api.example.com", map[string]string{"X-Tenant-ID": "globex"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, body = serve(ResolverConfig{TrustTenantHeader: true}, "api.example.com", map[string]string{"X-Tenant-ID": "globex"})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "globex", body)
+	code, _ = serve(ResolverConfig{TrustTenantHeader: true}, "api.example.com", map[string]string{"X-Tenant-ID": "initech"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestTenantPluginRejectsUnscopedQueries(t *testing.T) {
+	db, store := newTestStore(t)
+	ctx := context.Background()
+	tm, err := NewPersistentTenantManager(ctx, IsolationSharedDB, store, testLogger())
+	require.NoError(t, err)
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "acme", Name: "Acme"}))
+	_, err = NewTenantRouter(tm, db, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, db.WithContext(WithSystemScope(ctx)).AutoMigrate(&eventRecord{}))
+
+	var events []eventRecord
+	assert.ErrorIs(t, db.Find(&events).Error, ErrNoTenantContext)
+	assert.ErrorIs(t, db.Create(&eventRecord{Message: "leak"}).Error, ErrNoTenantContext)
+	assert.ErrorIs(t, db.Exec("DELETE FROM event_records").Error, ErrNoTenantContext)
+
+	// 有租戶但未經 Router 選擇 schema 也拒絕
+	acme, err := tm.GetTenant(ctx, "acme")
+	require.NoError(t, err)
+	assert.ErrorIs(t, db.WithContext(WithTenant(ctx, acme)).Find(&events).Error, ErrNoTenantContext)
+
+	// 租戶目錄仍可正常使用
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "globex", Name: "Globex"}))
+}
+
+func TestTenantRouterSeparateDatabases(t *testing.T) {
+	db, store := newTestStore(t)
+	ctx := context.Background()
+	tm, err := NewPersistentTenantManager(ctx, IsolationSeparateDB, store, testLogger())
+	require.NoError(t, err)
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "acme", Name: "Acme"}))
+	require.NoError(t, tm.CreateTenant(ctx, &Tenant{ID: "globex", Name: "Globex"}))
+
+	dir := t.TempDir()
+	opened := make(map[string]int)
+	_, err = NewTenantRouter(tm, db, nil)
+	assert.Error(t, err, "separate databases need a connect function")
+	router, err := NewTenantRouter(tm, db, func(tenant *Tenant, database string) (*gorm.DB, error) {
+		opened[database]++
+		return gorm.Open(sqlite.Open(filepath.Join(dir, database+".db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { router.Close() })
+
+	acme, err := tm.GetTenant(ctx, "acme")
+	require.NoError(t, err)
+	globex, err := tm.GetTenant(ctx, "globex")
+	require.NoError(t, err)
+
+	write := func(tenant *Tenant, message string) {
+		require.NoError(t, router.WithTenantDB(WithTenant(ctx, tenant), func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&eventRecord{}); err != nil {
+				return err
+			}
+			return tx.Create(&eventRecord{Message: message}).Error
+		}))
+	}
+	read := func(tenant *Tenant) []string {
+		var events []eventRecord
+		require.NoError(t, router.WithTenantDB(WithTenant(ctx, tenant), func(tx *gorm.DB) error {
+			return tx.Order("id").Find(&events).Error
+		}))
+		messages := make([]string, 0, len(events))
+		for _, event := range events {
+			messages = append(messages, event.Message)
+		}
+		return messages
+	}
+	write(acme, "acme-1")
+	write(globex, "globex-1")
+	write(acme, "acme-2")
+	assert.Equal(t, []string{"acme-1", "acme-2"}, read(acme))
+	assert.Equal(t, []string{"globex-1"}, read(globex))
+	assert.Equal(t, map[string]int{"pandora_tenant_acme": 1, "pandora_tenant_globex": 1}, opened)
+
+	assert.ErrorIs(t, router.WithTenantDB(ctx, func(tx *gorm.DB) error { return nil }), ErrNoTenantContext)
+
+	// 租戶的連線池不能用其他租戶的 context
+	err = router.WithTenantDB(WithTenant(ctx, acme), func(tx *gorm.DB) error {
+		var events []eventRecord
+		return tx.WithContext(withTenantScope(WithTenant(ctx, globex), "globex")).Find(&events).Error
+	})
+	assert.ErrorIs(t, err, ErrNoTenantContext)
+
+	require.NoError(t, tm.SuspendTenant(ctx, "globex", "unpaid"))
+	suspended, err := tm.GetTenant(ctx, "globex")
+	require.NoError(t, err)
+	assert.Error(t, router.WithTenantDB(WithTenant(ctx, suspended), func(tx *gorm.DB) error { return nil }))
+}
+
+func TestSearchPathSQL(t *testing.T) {
+	tm := NewTenantManager(IsolationSharedDB, testLogger())
+	assert.Equal(t, `SET LOCAL search_path TO "tenant_acme", public`, searchPathSQL(tm.GetSchemaName("acme")))
+	assert.Equal(t, `"a""b"`, quoteIdentifier(`a"b`))
+}
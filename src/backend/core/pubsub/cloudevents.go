@@ -0,0 +1,659 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// CloudEventsSpecVersion is the CloudEvents specification version produced and accepted
+	// 支援的 CloudEvents 規格版本
+	CloudEventsSpecVersion = "1.0"
+
+	// CloudEventsContentType is the content type of structured mode messages
+	// structured 模式的消息內容類型
+	CloudEventsContentType = "application/cloudevents+json"
+
+	// AMQPHeaderPrefix prefixes CloudEvents attributes in AMQP headers (binary mode);
+	// the older "cloudEvents:" prefix is also accepted when reading
+	// binary 模式下 AMQP 標頭的屬性前綴，讀取時同時接受舊的 "cloudEvents:" 前綴
+	AMQPHeaderPrefix = "cloudEvents_"
+
+	// DefaultCloudEventSource is used for events without a source
+	// 事件沒有 source 時使用的預設值
+	DefaultCloudEventSource = "pandora-box-console"
+
+	amqpHeaderPrefixColon = "cloudEvents:"
+)
+
+// CloudEventsMode selects how events are encoded on the wire
+// CloudEvents 編碼模式
+type CloudEventsMode string
+
+const (
+	// CloudEventsDisabled publishes events in the legacy JSON format
+	CloudEventsDisabled CloudEventsMode = ""
+
+	// CloudEventsStructured publishes the whole CloudEvent as application/cloudevents+json
+	CloudEventsStructured CloudEventsMode = "structured"
+
+	// CloudEventsBinary publishes the event data as the body and the attributes as headers
+	CloudEventsBinary CloudEventsMode = "binary"
+)
+
+// ParseCloudEventsMode parses a configuration value ("", "legacy", "structured" or "binary")
+// 解析 CloudEvents 編碼模式設定
+func ParseCloudEventsMode(value string) (CloudEventsMode, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "legacy", "disabled":
+		return CloudEventsDisabled, nil
+	case string(CloudEventsStructured):
+		return CloudEventsStructured, nil
+	case string(CloudEventsBinary):
+		return CloudEventsBinary, nil
+	}
+	return CloudEventsDisabled, fmt.Errorf("unknown CloudEvents mode %q", value)
+}
+
+// CloudEvent is a CloudEvents 1.0 event
+// CloudEvents 1.0 事件
+type CloudEvent struct {
+	SpecVersion     string
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	DataContentType string
+	DataSchema      string
+
+	// Time is the zero time when the attribute is not set
+	Time time.Time
+
+	// Data is the event payload: the JSON document for JSON content types, otherwise the raw bytes (nil = no data)
+	Data []byte
+
+	// Extensions holds extension attributes; values are string, bool, int64, time.Time or []byte
+	Extensions map[string]interface{}
+}
+
+// cloudEventAttributes are the context attributes defined by the specification
+var cloudEventAttributes = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"source":          true,
+	"type":            true,
+	"subject":         true,
+	"time":            true,
+	"datacontenttype": true,
+	"dataschema":      true,
+}
+
+// Validate checks the required attributes and the extension names
+// 檢查必要屬性與擴充屬性名稱
+func (ce *CloudEvent) Validate() error {
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		return fmt.Errorf("unsupported CloudEvents specversion %q", ce.SpecVersion)
+	}
+	if ce.ID == "" {
+		return fmt.Errorf("CloudEvent is missing id")
+	}
+	if ce.Source == "" {
+		return fmt.Errorf("CloudEvent is missing source")
+	}
+	if ce.Type == "" {
+		return fmt.Errorf("CloudEvent is missing type")
+	}
+	for name := range ce.Extensions {
+		if !validAttributeName(name) {
+			return fmt.Errorf("invalid CloudEvents attribute name %q", name)
+		}
+		if cloudEventAttributes[name] || name == "data" || name == "data_base64" {
+			return fmt.Errorf("extension %q shadows a reserved attribute", name)
+		}
+	}
+	return nil
+}
+
+// Attributes returns the context attributes except datacontenttype, keyed by attribute name,
+// as carried in binary mode headers
+// 返回 binary 模式標頭使用的屬性（不含 datacontenttype）
+func (ce *CloudEvent) Attributes() map[string]interface{} {
+	attrs := map[string]interface{}{
+		"specversion": ce.SpecVersion,
+		"id":          ce.ID,
+		"source":      ce.Source,
+		"type":        ce.Type,
+	}
+	if ce.Subject != "" {
+		attrs["subject"] = ce.Subject
+	}
+	if ce.DataSchema != "" {
+		attrs["dataschema"] = ce.DataSchema
+	}
+	if !ce.Time.IsZero() {
+		attrs["time"] = ce.Time
+	}
+	for name, value := range ce.Extensions {
+		attrs[name] = value
+	}
+	return attrs
+}
+
+// NewCloudEventFromAttributes builds a binary mode CloudEvent from its attributes, content type and body;
+// string values are accepted for every attribute type, as in MQTT user properties
+// 由 binary 模式的屬性、內容類型與消息內容建立 CloudEvent
+func NewCloudEventFromAttributes(attrs map[string]interface{}, contentType string, data []byte) (*CloudEvent, error) {
+	ce := &CloudEvent{DataContentType: contentType, Data: data}
+	for name, value := range attrs {
+		if value == nil {
+			continue
+		}
+		switch name {
+		case "time":
+			t, err := attributeTime(value)
+			if err != nil {
+				return nil, err
+			}
+			ce.Time = t
+		case "specversion", "id", "source", "type", "subject", "dataschema":
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("CloudEvents attribute %s must be a string, got %T", name, value)
+			}
+			switch name {
+			case "specversion":
+				ce.SpecVersion = s
+			case "id":
+				ce.ID = s
+			case "source":
+				ce.Source = s
+			case "type":
+				ce.Type = s
+			case "subject":
+				ce.Subject = s
+			case "dataschema":
+				ce.DataSchema = s
+			}
+		case "datacontenttype":
+			if s, ok := value.(string); ok && ce.DataContentType == "" {
+				ce.DataContentType = s
+			}
+		default:
+			v, err := extensionValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("CloudEvents extension %s: %w", name, err)
+			}
+			if ce.Extensions == nil {
+				ce.Extensions = make(map[string]interface{})
+			}
+			ce.Extensions[name] = v
+		}
+	}
+	if err := ce.Validate(); err != nil {
+		return nil, err
+	}
+	return ce, nil
+}
+
+// MarshalJSON encodes the event in the JSON event format (structured mode)
+// 以 JSON 事件格式（structured 模式）編碼
+func (ce *CloudEvent) MarshalJSON() ([]byte, error) {
+	if err := ce.Validate(); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]interface{}, len(ce.Extensions)+8)
+	for name, value := range ce.Attributes() {
+		if t, ok := value.(time.Time); ok {
+			value = t.Format(time.RFC3339Nano)
+		}
+		out[name] = value
+	}
+	if ce.DataContentType != "" {
+		out["datacontenttype"] = ce.DataContentType
+	}
+	if ce.Data != nil {
+		switch {
+		case isJSONContentType(ce.DataContentType):
+			if !json.Valid(ce.Data) {
+				return nil, fmt.Errorf("CloudEvent %s data is not valid JSON", ce.ID)
+			}
+			out["data"] = json.RawMessage(ce.Data)
+		case utf8.Valid(ce.Data):
+			out["data"] = string(ce.Data)
+		default:
+			out["data_base64"] = base64.StdEncoding.EncodeToString(ce.Data)
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes the JSON event format; attributes set to null are treated as absent
+// 解碼 JSON 事件格式；值為 null 的屬性視為未設定
+func (ce *CloudEvent) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("CloudEvent is not a JSON object: %w", err)
+	}
+
+	attrs := make(map[string]interface{}, len(raw))
+	var contentType string
+	var payload, payloadBase64 json.RawMessage
+	for name, value := range raw {
+		if string(value) == "null" {
+			continue
+		}
+		switch name {
+		case "data":
+			payload = value
+			continue
+		case "data_base64":
+			payloadBase64 = value
+			continue
+		case "datacontenttype":
+			if err := json.Unmarshal(value, &contentType); err != nil {
+				return fmt.Errorf("CloudEvents attribute datacontenttype must be a string")
+			}
+			continue
+		}
+		if !validAttributeName(name) {
+			return fmt.Errorf("invalid CloudEvents attribute name %q", name)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+		var v interface{}
+		if err := decoder.Decode(&v); err != nil {
+			return fmt.Errorf("CloudEvents attribute %s: %w", name, err)
+		}
+		attrs[name] = v
+	}
+
+	var body []byte
+	switch {
+	case payload != nil && payloadBase64 != nil:
+		return fmt.Errorf("CloudEvent has both data and data_base64")
+	case payloadBase64 != nil:
+		var encoded string
+		if err := json.Unmarshal(payloadBase64, &encoded); err != nil {
+			return fmt.Errorf("CloudEvents data_base64 must be a string")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("CloudEvents data_base64 is not valid base64: %w", err)
+		}
+		body = decoded
+	case payload != nil:
+		body = []byte(payload)
+		if !isJSONContentType(contentType) {
+			// 非 JSON 內容以 JSON 字串攜帶
+			var text string
+			if err := json.Unmarshal(payload, &text); err == nil {
+				body = []byte(text)
+			}
+		}
+	}
+
+	decoded, err := NewCloudEventFromAttributes(attrs, contentType, body)
+	if err != nil {
+		return err
+	}
+	*ce = *decoded
+	return nil
+}
+
+// AMQPHeaders returns the attributes as AMQP application properties for binary mode;
+// time uses its canonical string form, since AMQP 0-9-1 timestamps have second precision
+// 返回 binary 模式的 AMQP 標頭；time 以字串表示，避免 AMQP 時間戳記只有秒級精度
+func (ce *CloudEvent) AMQPHeaders() amqp.Table {
+	headers := amqp.Table{}
+	for name, value := range ce.Attributes() {
+		if t, ok := value.(time.Time); ok {
+			value = t.Format(time.RFC3339Nano)
+		}
+		headers[AMQPHeaderPrefix+name] = value
+	}
+	return headers
+}
+
+// CloudEventFromAMQP decodes a binary mode AMQP message; ok is false when the headers carry no CloudEvent
+// 解碼 binary 模式的 AMQP 消息；標頭沒有 CloudEvents 屬性時 ok 為 false
+func CloudEventFromAMQP(contentType string, headers map[string]interface{}, body []byte) (ce *CloudEvent, ok bool, err error) {
+	attrs := make(map[string]interface{})
+	for key, value := range headers {
+		for _, prefix := range []string{AMQPHeaderPrefix, amqpHeaderPrefixColon} {
+			if strings.HasPrefix(key, prefix) {
+				attrs[strings.TrimPrefix(key, prefix)] = value
+			}
+		}
+	}
+	if _, ok := attrs["specversion"]; !ok {
+		return nil, false, nil
+	}
+	ce, err = NewCloudEventFromAttributes(attrs, contentType, body)
+	return ce, true, err
+}
+
+// EncodeAMQP rewrites a legacy event publishing in the given mode; messages that are not
+// BaseEvent-based events (e.g. quarantine envelopes) are left unchanged
+// 依模式改寫 AMQP 消息；非事件消息（例如隔離事件）維持原格式
+func EncodeAMQP(mode CloudEventsMode, publishing *amqp.Publishing) error {
+	if mode == CloudEventsDisabled {
+		return nil
+	}
+	ce, err := EventToCloudEvent(publishing.Body)
+	if err != nil {
+		return nil
+	}
+
+	switch mode {
+	case CloudEventsStructured:
+		body, err := json.Marshal(ce)
+		if err != nil {
+			return fmt.Errorf("failed to encode CloudEvent: %w", err)
+		}
+		publishing.ContentType = CloudEventsContentType
+		publishing.Body = body
+	case CloudEventsBinary:
+		if publishing.Headers == nil {
+			publishing.Headers = amqp.Table{}
+		}
+		for k, v := range ce.AMQPHeaders() {
+			publishing.Headers[k] = v
+		}
+		publishing.ContentType = ce.DataContentType
+		publishing.MessageId = ce.ID
+		publishing.Body = ce.Data
+	default:
+		return fmt.Errorf("unknown CloudEvents mode %q", mode)
+	}
+	return nil
+}
+
+// DecodeEvent returns message in the legacy JSON format, accepting legacy events,
+// structured CloudEvents and binary CloudEvents (attributes in AMQP headers)
+// 將消息轉為舊版 JSON 格式：接受舊格式、structured 與 binary（AMQP 標頭）CloudEvents
+func DecodeEvent(message []byte, contentType string, headers map[string]interface{}) ([]byte, error) {
+	ce, ok, err := CloudEventFromAMQP(contentType, headers, message)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return CloudEventToEvent(ce)
+	}
+	if !isStructuredCloudEvent(message, contentType) {
+		return message, nil
+	}
+
+	ce = &CloudEvent{}
+	if err := json.Unmarshal(message, ce); err != nil {
+		return nil, err
+	}
+	return CloudEventToEvent(ce)
+}
+
+// isStructuredCloudEvent reports whether message is a structured mode CloudEvent
+func isStructuredCloudEvent(message []byte, contentType string) bool {
+	if mediaType(contentType) == CloudEventsContentType {
+		return true
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(message), []byte("{")) {
+		return false
+	}
+	var probe struct {
+		SpecVersion *string `json:"specversion"`
+	}
+	return json.Unmarshal(message, &probe) == nil && probe.SpecVersion != nil
+}
+
+// EventToCloudEvent maps a legacy JSON event to a CloudEvent: BaseEvent id, source, type and
+// timestamp become the id, source, type and time attributes, severity and schema_version the
+// severity and schemaversion extensions, and the remaining fields the JSON data
+// 舊格式事件轉為 CloudEvent：BaseEvent 的 id、source、type、timestamp 對應同名屬性與 time，
+// severity 與 schema_version 成為擴充屬性，其餘欄位作為 JSON 資料
+func EventToCloudEvent(message []byte) (*CloudEvent, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(message, &fields); err != nil {
+		return nil, fmt.Errorf("event is not a JSON object: %w", err)
+	}
+
+	ce := &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		Source:          DefaultCloudEventSource,
+		DataContentType: "application/json",
+	}
+	take := func(name string, target interface{}) error {
+		raw, ok := fields[name]
+		if !ok {
+			return nil
+		}
+		delete(fields, name)
+		if string(raw) == "null" {
+			return nil
+		}
+		if err := json.Unmarshal(raw, target); err != nil {
+			return fmt.Errorf("event field %s: %w", name, err)
+		}
+		return nil
+	}
+
+	var source, severity string
+	var timestamp *time.Time
+	var version *int64
+	for name, target := range map[string]interface{}{
+		"id":             &ce.ID,
+		"type":           &ce.Type,
+		"source":         &source,
+		"timestamp":      &timestamp,
+		"severity":       &severity,
+		"schema_version": &version,
+	} {
+		if err := take(name, target); err != nil {
+			return nil, err
+		}
+	}
+	if ce.ID == "" || ce.Type == "" {
+		return nil, fmt.Errorf("event has no id or type")
+	}
+	if source != "" {
+		ce.Source = source
+	}
+	if timestamp != nil && !timestamp.IsZero() {
+		ce.Time = *timestamp
+	}
+	if severity != "" {
+		ce.setExtension("severity", severity)
+	}
+	if version != nil {
+		ce.setExtension("schemaversion", *version)
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event data: %w", err)
+	}
+	ce.Data = data
+	return ce, nil
+}
+
+// CloudEventToEvent maps a CloudEvent back to the legacy JSON format. JSON object data provides
+// the event fields; other data is kept under "data". The subject and unknown extensions are
+// added to metadata
+// CloudEvent 轉回舊格式事件：JSON 物件資料作為事件欄位，其他資料放在 "data"；
+// subject 與其他擴充屬性加入 metadata
+func CloudEventToEvent(ce *CloudEvent) ([]byte, error) {
+	fields := make(map[string]interface{})
+	if ce.Data != nil {
+		var decoded interface{}
+		if isJSONContentType(ce.DataContentType) && json.Unmarshal(ce.Data, &decoded) == nil {
+			if object, ok := decoded.(map[string]interface{}); ok {
+				fields = object
+			} else {
+				fields["data"] = decoded
+			}
+		} else if utf8.Valid(ce.Data) {
+			fields["data"] = string(ce.Data)
+		} else {
+			fields["data"] = ce.Data
+		}
+	}
+
+	fields["id"] = ce.ID
+	fields["type"] = ce.Type
+	fields["source"] = ce.Source
+	if !ce.Time.IsZero() {
+		fields["timestamp"] = ce.Time
+	}
+
+	metadata, _ := fields["metadata"].(map[string]interface{})
+	addMetadata := func(name string, value interface{}) {
+		if metadata == nil {
+			if _, taken := fields["metadata"]; taken {
+				return
+			}
+			metadata = make(map[string]interface{})
+		}
+		if _, exists := metadata[name]; !exists {
+			metadata[name] = value
+		}
+	}
+	if ce.Subject != "" {
+		addMetadata("subject", ce.Subject)
+	}
+
+	names := make([]string, 0, len(ce.Extensions))
+	for name := range ce.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := ce.Extensions[name]
+		switch name {
+		case "severity":
+			if s, ok := value.(string); ok {
+				fields["severity"] = s
+				continue
+			}
+		case "schemaversion":
+			if version, ok := extensionInt(value); ok {
+				fields["schema_version"] = version
+				continue
+			}
+		}
+		addMetadata(name, value)
+	}
+	if metadata != nil {
+		fields["metadata"] = metadata
+	}
+
+	return json.Marshal(fields)
+}
+
+func (ce *CloudEvent) setExtension(name string, value interface{}) {
+	if ce.Extensions == nil {
+		ce.Extensions = make(map[string]interface{})
+	}
+	ce.Extensions[name] = value
+}
+
+// validAttributeName reports whether name uses only lower-case ASCII letters and digits
+func validAttributeName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// extensionValue converts a decoded attribute value to one of the CloudEvents types
+func extensionValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string, bool, time.Time, []byte:
+		return v, nil
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an integer", v)
+		}
+		return n, nil
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case float64:
+		if v != float64(int64(v)) {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+		return int64(v), nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", value)
+}
+
+// extensionInt reads an integer extension, which is a string in MQTT user properties
+func extensionInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// attributeTime reads the time attribute from an RFC 3339 string or an AMQP timestamp
+func attributeTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid CloudEvents time %q", v)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("CloudEvents time must be a timestamp, got %T", value)
+}
+
+// isJSONContentType reports whether data with this content type is JSON; an absent content type means JSON
+func isJSONContentType(contentType string) bool {
+	media := mediaType(contentType)
+	return media == "" || media == "application/json" || media == "text/json" || strings.HasSuffix(media, "+json")
+}
+
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return media
+}
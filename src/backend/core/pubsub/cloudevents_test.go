@@ -0,0 +1,290 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/cloudevents 收錄 CloudEvents JSON 事件格式規格中的範例
+func TestCloudEventSpecExamples(t *testing.T) {
+	specTime := time.Date(2018, 4, 5, 17, 31, 0, 0, time.UTC)
+	tests := []struct {
+		file  string
+		check func(t *testing.T, ce *CloudEvent)
+	}{
+		{"xml-data.json", func(t *testing.T, ce *CloudEvent) {
+			assert.Equal(t, "com.github.pull_request.opened", ce.Type)
+			assert.Equal(t, "https://github.com/cloudevents/spec/pull", ce.Source)
+			assert.Equal(t, "123", ce.Subject)
+			assert.Equal(t, "text/xml", ce.DataContentType)
+			assert.Equal(t, `<much wow="xml"/>`, string(ce.Data))
+		}},
+		{"binary-data.json", func(t *testing.T, ce *CloudEvent) {
+			assert.Equal(t, "application/vnd.apache.thrift.binary", ce.DataContentType)
+			assert.Equal(t, []byte{0x80, 0x01, 0x00, 0x01}, ce.Data[:4])
+			assert.NotContains(t, ce.Extensions, "unsetextension")
+		}},
+		{"json-data.json", func(t *testing.T, ce *CloudEvent) {
+			assert.Equal(t, "C234-1234-1234", ce.ID)
+			assert.Empty(t, ce.Subject)
+			assert.JSONEq(t, `{"appinfoA":"abc","appinfoB":123,"appinfoC":true}`, string(ce.Data))
+		}},
+		{"minimal.json", func(t *testing.T, ce *CloudEvent) {
+			assert.True(t, ce.Time.IsZero())
+			assert.Nil(t, ce.Data)
+			assert.Empty(t, ce.Extensions)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "cloudevents", tt.file))
+			require.NoError(t, err)
+
+			var ce CloudEvent
+			require.NoError(t, json.Unmarshal(data, &ce))
+			assert.Equal(t, CloudEventsSpecVersion, ce.SpecVersion)
+			if tt.file != "minimal.json" {
+				assert.True(t, specTime.Equal(ce.Time))
+				assert.Equal(t, "value", ce.Extensions["comexampleextension1"])
+				assert.Equal(t, int64(5), ce.Extensions["comexampleothervalue"])
+			}
+			tt.check(t, &ce)
+
+			// 重新編碼後與範例相同（null 屬性視為未設定）
+			encoded, err := json.Marshal(&ce)
+			require.NoError(t, err)
+			var want map[string]interface{}
+			require.NoError(t, json.Unmarshal(data, &want))
+			for name, value := range want {
+				if value == nil {
+					delete(want, name)
+				}
+			}
+			expected, err := json.Marshal(want)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expected), string(encoded))
+		})
+	}
+}
+
+func TestCloudEventRejectsInvalidEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		errText string
+	}{
+		{"missing id", `{"specversion":"1.0","type":"a","source":"/b"}`, "missing id"},
+		{"missing source", `{"specversion":"1.0","id":"1","type":"a"}`, "missing source"},
+		{"empty type", `{"specversion":"1.0","id":"1","type":"","source":"/b"}`, "missing type"},
+		{"old specversion", `{"specversion":"0.3","id":"1","type":"a","source":"/b"}`, `unsupported CloudEvents specversion "0.3"`},
+		{"data and data_base64", `{"specversion":"1.0","id":"1","type":"a","source":"/b","data":{},"data_base64":"e30="}`, "both data and data_base64"},
+		{"upper-case extension", `{"specversion":"1.0","id":"1","type":"a","source":"/b","comExample":"x"}`, `invalid CloudEvents attribute name "comExample"`},
+		{"bad time", `{"specversion":"1.0","id":"1","type":"a","source":"/b","time":"yesterday"}`, `invalid CloudEvents time "yesterday"`},
+		{"object extension", `{"specversion":"1.0","id":"1","type":"a","source":"/b","ext":{"a":1}}`, "unsupported type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ce CloudEvent
+			err := json.Unmarshal([]byte(tt.payload), &ce)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errText)
+		})
+	}
+}
+
+func TestEventCloudEventRoundTrip(t *testing.T) {
+	report := NewReportEvent("daily", "security", "pdf")
+	report.Content = []byte("%PDF-1.7")
+	events := map[string]interface{}{
+		"threat":  NewThreatEvent("ddos", "192.168.1.100", "DDoS", "blocked", 8),
+		"network": NewNetworkEvent("port_scan", "192.168.1.100", "10.0.0.1", "tcp"),
+		"system":  NewSystemEvent("engine", "running", "started"),
+		"device":  NewDeviceEvent("usb-001", "usb-serial", "connected"),
+		"report":  report,
+	}
+	for kind, event := range events {
+		t.Run(kind, func(t *testing.T) {
+			legacy, err := ToJSON(event)
+			require.NoError(t, err)
+			var base BaseEvent
+			require.NoError(t, FromJSON(legacy, &base))
+
+			ce, err := EventToCloudEvent(legacy)
+			require.NoError(t, err)
+			assert.Equal(t, base.ID, ce.ID)
+			assert.Equal(t, base.Source, ce.Source)
+			assert.Equal(t, string(base.Type), ce.Type)
+			assert.True(t, base.Timestamp.Equal(ce.Time))
+			assert.Equal(t, "application/json", ce.DataContentType)
+			assert.Equal(t, base.Severity, ce.Extensions["severity"])
+			assert.Equal(t, int64(CurrentSchemaVersion), ce.Extensions["schemaversion"])
+			assert.NotContains(t, string(ce.Data), `"id"`)
+
+			structured, err := json.Marshal(ce)
+			require.NoError(t, err)
+			decoded, err := DecodeEvent(structured, CloudEventsContentType, nil)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(legacy), string(decoded))
+
+			// 未標示內容類型時以 specversion 辨識 structured 模式
+			decoded, err = DecodeEvent(structured, "", nil)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(legacy), string(decoded))
+
+			decoded, err = DecodeEvent(ce.Data, ce.DataContentType, ce.AMQPHeaders())
+			require.NoError(t, err)
+			assert.JSONEq(t, string(legacy), string(decoded))
+
+			// 舊格式原樣通過
+			decoded, err = DecodeEvent(legacy, "application/json", nil)
+			require.NoError(t, err)
+			assert.Equal(t, legacy, decoded)
+		})
+	}
+}
+
+func TestDecodeEventFromForeignCloudEvents(t *testing.T) {
+	// 舊的 "cloudEvents:" 前綴與 AMQP 時間戳記
+	headers := map[string]interface{}{
+		"cloudEvents:specversion":  "1.0",
+		"cloudEvents:id":           "A234-1234-1234",
+		"cloudEvents:source":       "https://github.com/cloudevents/spec/pull",
+		"cloudEvents:type":         "com.github.pull_request.opened",
+		"cloudEvents:subject":      "123",
+		"cloudEvents:time":         time.Date(2018, 4, 5, 17, 31, 0, 0, time.UTC),
+		"cloudEvents:comexampleid": int32(7),
+		"x-retry-count":            int64(1),
+	}
+	decoded, err := DecodeEvent([]byte(`<much wow="xml"/>`), "text/xml", headers)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"id": "A234-1234-1234",
+		"type": "com.github.pull_request.opened",
+		"source": "https://github.com/cloudevents/spec/pull",
+		"timestamp": "2018-04-05T17:31:00Z",
+		"data": "<much wow=\"xml\"/>",
+		"metadata": {"subject": "123", "comexampleid": 7}
+	}`, string(decoded))
+
+	data, err := os.ReadFile(filepath.Join("testdata", "cloudevents", "json-data.json"))
+	require.NoError(t, err)
+	decoded, err = DecodeEvent(data, "", nil)
+	require.NoError(t, err)
+	var event Event
+	require.NoError(t, FromJSON(decoded, &event))
+	assert.Equal(t, "C234-1234-1234", event.ID)
+	assert.Equal(t, "/mycontext", event.Source)
+	assert.True(t, time.Date(2018, 4, 5, 17, 31, 0, 0, time.UTC).Equal(event.Timestamp))
+
+	_, err = DecodeEvent([]byte(`{}`), "application/json", map[string]interface{}{"cloudEvents_specversion": "1.0"})
+	assert.Error(t, err)
+	_, err = DecodeEvent([]byte(`{"specversion":"1.0"}`), "", nil)
+	assert.Error(t, err)
+}
+
+func TestRabbitMQCloudEventsModes(t *testing.T) {
+	event, err := ToJSON(NewThreatEvent("ddos", "192.168.1.100", "DDoS", "blocked", 8))
+	require.NoError(t, err)
+	var base BaseEvent
+	require.NoError(t, FromJSON(event, &base))
+
+	for _, mode := range []CloudEventsMode{CloudEventsDisabled, CloudEventsStructured, CloudEventsBinary} {
+		t.Run(string(mode), func(t *testing.T) {
+			broker := newFakeBroker()
+			mq := newTestRabbitMQ(broker)
+			defer mq.Close()
+			mq.config.CloudEventsMode = mode
+			setupRetryQueue(t, broker, "threat_events")
+
+			ctx := context.Background()
+			require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.detected", event))
+			// 非事件消息不轉換
+			require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.raw", []byte(`{"reason":"not an event"}`)))
+
+			msg, ok, err := broker.Get("threat_events", false)
+			require.NoError(t, err)
+			require.True(t, ok)
+			switch mode {
+			case CloudEventsDisabled:
+				assert.Equal(t, "application/json", msg.ContentType)
+				assert.Equal(t, event, msg.Body)
+			case CloudEventsStructured:
+				assert.Equal(t, CloudEventsContentType, msg.ContentType)
+				assert.Contains(t, string(msg.Body), `"specversion":"1.0"`)
+			case CloudEventsBinary:
+				assert.Equal(t, "application/json", msg.ContentType)
+				assert.Equal(t, base.ID, msg.Headers["cloudEvents_id"])
+				assert.Equal(t, "threat.detected", msg.Headers["cloudEvents_type"])
+				assert.Equal(t, base.Severity, msg.Headers["cloudEvents_severity"])
+				assert.Equal(t, base.ID, msg.MessageId)
+				assert.NotContains(t, string(msg.Body), base.ID)
+			}
+			broker.Nack(msg.DeliveryTag, false, true)
+
+			received := make(chan []byte, 2)
+			require.NoError(t, mq.SubscribeWithOptions(ctx, "threat_events", func(topic string, message []byte) error {
+				received <- message
+				return nil
+			}, testRetryOptions(1)))
+			// 訂閱端一律收到舊格式
+			var messages []string
+			for len(messages) < 2 {
+				select {
+				case message := <-received:
+					messages = append(messages, string(message))
+				case <-time.After(2 * time.Second):
+					t.Fatal("未收到消息")
+				}
+			}
+			if !strings.Contains(messages[0], base.ID) {
+				messages[0], messages[1] = messages[1], messages[0]
+			}
+			assert.JSONEq(t, string(event), messages[0])
+			assert.JSONEq(t, `{"reason":"not an event"}`, messages[1])
+		})
+	}
+}
+
+func TestRabbitMQDeadLettersInvalidCloudEvents(t *testing.T) {
+	broker := newFakeBroker()
+	mq := newTestRabbitMQ(broker)
+	defer mq.Close()
+	setupRetryQueue(t, broker, "threat_events")
+
+	recorder := &callRecorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, mq.SubscribeWithOptions(ctx, "threat_events", recorder.handle, testRetryOptions(3)))
+
+	require.NoError(t, broker.PublishWithContext(ctx, "pandora.events", "threat.detected", false, false, amqp.Publishing{
+		ContentType: CloudEventsContentType,
+		Body:        []byte(`{"specversion":"1.0","type":"threat.detected"}`),
+	}))
+	dead := DeadLetterQueueName("threat_events")
+	require.Eventually(t, func() bool { return broker.depth(dead) == 1 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, recorder.count())
+
+	letters, err := mq.InspectDeadLetters(ctx, "threat_events", 0)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Contains(t, letters[0].Reason, "invalid CloudEvent: CloudEvent is missing id")
+}
+
+func TestParseCloudEventsMode(t *testing.T) {
+	for value, want := range map[string]CloudEventsMode{"": CloudEventsDisabled, "legacy": CloudEventsDisabled, "Structured": CloudEventsStructured, "binary": CloudEventsBinary} {
+		mode, err := ParseCloudEventsMode(value)
+		require.NoError(t, err)
+		assert.Equal(t, want, mode)
+	}
+	_, err := ParseCloudEventsMode("batch")
+	assert.Error(t, err)
+}
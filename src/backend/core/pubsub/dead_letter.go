@@ -0,0 +1,292 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultDeadLetterLimit is the number of dead letters returned when no limit is given
+// 未指定數量時查看的死信數量
+const DefaultDeadLetterLimit = 100
+
+// DeadLetter is a message held in a dead-letter queue
+// 死信隊列中的消息與失敗資訊
+type DeadLetter struct {
+	MessageID  string                 `json:"message_id,omitempty"`
+	Queue      string                 `json:"queue"`
+	Exchange   string                 `json:"exchange"`
+	RoutingKey string                 `json:"routing_key"`
+	Reason     string                 `json:"reason"`
+	RetryCount int                    `json:"retry_count"`
+	FailedAt   time.Time              `json:"failed_at"`
+	Headers    map[string]interface{} `json:"headers,omitempty"`
+	Body       []byte                 `json:"body"`
+}
+
+// DeadLetterAdmin inspects, replays and purges dead letters of a queue
+// 死信管理接口
+type DeadLetterAdmin interface {
+	// InspectDeadLetters returns up to limit dead letters without removing them
+	InspectDeadLetters(ctx context.Context, queue string, limit int) ([]DeadLetter, error)
+
+	// ReplayDeadLetters re-publishes up to limit dead letters (0 = all) to their original routing key
+	ReplayDeadLetters(ctx context.Context, queue string, limit int) (int, error)
+
+	// PurgeDeadLetters removes all dead letters of the queue
+	PurgeDeadLetters(ctx context.Context, queue string) (int, error)
+}
+
+// newDeadLetter converts a delivery from a dead-letter queue
+func newDeadLetter(msg amqp.Delivery) DeadLetter {
+	letter := DeadLetter{
+		MessageID:  msg.MessageId,
+		Headers:    make(map[string]interface{}),
+		Body:       msg.Body,
+		RetryCount: headerInt(msg.Headers[HeaderRetryCount]),
+	}
+	for k, v := range msg.Headers {
+		letter.Headers[k] = v
+	}
+	letter.Queue, _ = msg.Headers[HeaderSourceQueue].(string)
+	letter.Exchange, _ = msg.Headers[HeaderOriginalExchange].(string)
+	letter.RoutingKey, _ = msg.Headers[HeaderOriginalRoutingKey].(string)
+	letter.Reason, _ = msg.Headers[HeaderFailureReason].(string)
+	letter.FailedAt, _ = msg.Headers[HeaderFailedAt].(time.Time)
+	return letter
+}
+
+// adminChannel returns the channel used for dead-letter administration
+func (mq *RabbitMQ) adminChannel() (amqpChannel, error) {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	if mq.ch == nil {
+		return nil, fmt.Errorf("channel is not initialized")
+	}
+	return mq.ch, nil
+}
+
+// InspectDeadLetters returns up to limit dead letters of queue without removing them
+// 查看死信：取出後全部放回隊列，順序不變
+func (mq *RabbitMQ) InspectDeadLetters(ctx context.Context, queue string, limit int) ([]DeadLetter, error) {
+	ch, err := mq.adminChannel()
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultDeadLetterLimit
+	}
+
+	mq.adminMu.Lock()
+	defer mq.adminMu.Unlock()
+
+	var deliveries []amqp.Delivery
+	defer func() {
+		// 由後往前放回，讓消息保持原本的順序
+		for i := len(deliveries) - 1; i >= 0; i-- {
+			deliveries[i].Nack(false, true)
+		}
+	}()
+
+	letters := make([]DeadLetter, 0)
+	for len(deliveries) < limit {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		msg, ok, err := ch.Get(DeadLetterQueueName(queue), false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dead letter: %w", err)
+		}
+		if !ok {
+			break
+		}
+		deliveries = append(deliveries, msg)
+		letters = append(letters, newDeadLetter(msg))
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetters re-publishes up to limit dead letters (0 = all) to their original
+// exchange and routing key with the retry count and failure headers cleared
+// 重新發布死信到原始交換機與路由鍵，並清除重試次數與失敗標頭；
+// 只處理開始時已在隊列中的消息，重播後再次失敗的消息不會在同一次呼叫中重複處理
+func (mq *RabbitMQ) ReplayDeadLetters(ctx context.Context, queue string, limit int) (int, error) {
+	ch, err := mq.adminChannel()
+	if err != nil {
+		return 0, err
+	}
+
+	publisher, err := mq.confirmed()
+	if err != nil {
+		return 0, err
+	}
+
+	mq.adminMu.Lock()
+	defer mq.adminMu.Unlock()
+
+	replayed := 0
+	remaining := -1
+	for (limit <= 0 || replayed < limit) && remaining != 0 {
+		if err := ctx.Err(); err != nil {
+			return replayed, err
+		}
+		msg, ok, err := ch.Get(DeadLetterQueueName(queue), false)
+		if err != nil {
+			return replayed, fmt.Errorf("failed to get dead letter: %w", err)
+		}
+		if !ok {
+			break
+		}
+		if remaining < 0 {
+			remaining = int(msg.MessageCount) + 1
+		}
+		remaining--
+
+		headers := amqp.Table{}
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		exchange, _ := headers[HeaderOriginalExchange].(string)
+		routingKey, ok := headers[HeaderOriginalRoutingKey].(string)
+		if !ok {
+			exchange, routingKey = "", queue
+		}
+		for _, key := range []string{HeaderRetryCount, HeaderOriginalExchange, HeaderOriginalRoutingKey, HeaderSourceQueue, HeaderFailureReason, HeaderFailedAt} {
+			delete(headers, key)
+		}
+
+		// broker 確認重播的消息後才移除死信
+		err = publisher.publish(ctx, exchange, routingKey, amqp.Publishing{
+			Headers:      headers,
+			ContentType:  msg.ContentType,
+			DeliveryMode: amqp.Persistent,
+			Priority:     msg.Priority,
+			MessageId:    msg.MessageId,
+			Timestamp:    msg.Timestamp,
+			Body:         msg.Body,
+		})
+		if err != nil {
+			msg.Nack(false, true)
+			return replayed, fmt.Errorf("failed to replay dead letter: %w", err)
+		}
+		msg.Ack(false)
+		replayed++
+	}
+	return replayed, nil
+}
+
+// PurgeDeadLetters removes all dead letters of queue
+// 清空死信隊列
+func (mq *RabbitMQ) PurgeDeadLetters(ctx context.Context, queue string) (int, error) {
+	ch, err := mq.adminChannel()
+	if err != nil {
+		return 0, err
+	}
+
+	mq.adminMu.Lock()
+	defer mq.adminMu.Unlock()
+
+	purged, err := ch.QueuePurge(DeadLetterQueueName(queue), false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge dead letters: %w", err)
+	}
+	return purged, nil
+}
+
+// NewDeadLetterHandler returns an HTTP admin API for dead letters:
+//
+//	GET    /dead-letters/{queue}?limit=N         查看死信
+//	POST   /dead-letters/{queue}/replay?limit=N  重新發布死信 (limit 省略時全部)
+//	DELETE /dead-letters/{queue}                 清空死信
+func NewDeadLetterHandler(admin DeadLetterAdmin) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /dead-letters/{queue}", func(w http.ResponseWriter, r *http.Request) {
+		limit, err := parseLimit(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		queue := r.PathValue("queue")
+		letters, err := admin.InspectDeadLetters(r.Context(), queue, limit)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"queue": queue, "count": len(letters), "dead_letters": letters})
+	})
+
+	mux.HandleFunc("POST /dead-letters/{queue}/replay", func(w http.ResponseWriter, r *http.Request) {
+		limit, err := parseLimit(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		queue := r.PathValue("queue")
+		replayed, err := admin.ReplayDeadLetters(r.Context(), queue, limit)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error(), "replayed": replayed})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"queue": queue, "replayed": replayed})
+	})
+
+	mux.HandleFunc("DELETE /dead-letters/{queue}", func(w http.ResponseWriter, r *http.Request) {
+		queue := r.PathValue("queue")
+		purged, err := admin.PurgeDeadLetters(r.Context(), queue)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"queue": queue, "purged": purged})
+	})
+
+	return mux
+}
+
+// NewDeadLetterAdminHandler returns NewDeadLetterHandler guarded by a bearer token:
+// every request must carry "Authorization: Bearer <token>". An empty token is an error
+// 死信管理會清空或重播正式環境的消息，一律要求 token
+func NewDeadLetterAdminHandler(admin DeadLetterAdmin, token string) (http.Handler, error) {
+	if token == "" {
+		return nil, fmt.Errorf("dead-letter admin token is not configured")
+	}
+
+	handler := NewDeadLetterHandler(admin)
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dead-letters"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "unauthorized"})
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}), nil
+}
+
+// parseLimit parses the optional limit query parameter
+func parseLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid limit: %s", value)
+	}
+	return limit, nil
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
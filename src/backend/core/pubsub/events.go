@@ -34,6 +34,9 @@ const (
 	EventTypeDeviceDisconnect EventType = "device.disconnected"
 	EventTypeDeviceData       EventType = "device.data"
 	EventTypeDeviceError      EventType = "device.error"
+
+	// Report Events - 報表事件
+	EventTypeReportGenerated  EventType = "report.generated"
 )
 
 // BaseEvent contains common fields for all events
@@ -45,6 +48,10 @@ type BaseEvent struct {
 	// Type is the event type
 	Type EventType `json:"type"`
 
+	// SchemaVersion is the version of the event schema the payload conforms to
+	// (payloads without it are version 1, see SchemaRegistry)
+	SchemaVersion int `json:"schema_version"`
+
 	// Timestamp is when the event occurred
 	Timestamp time.Time `json:"timestamp"`
 
@@ -187,18 +194,46 @@ type DeviceEvent struct {
 	ErrorMessage string `json:"error_message,omitempty"`
 }
 
+// ReportEvent represents a generated report
+// 報表事件，用於發布排程產生的報表內容
+type ReportEvent struct {
+	BaseEvent
+
+	// Name is the schedule name that produced the report
+	Name string `json:"name"`
+
+	// ReportType is the report type (e.g., "security", "network", "system")
+	ReportType string `json:"report_type"`
+
+	// Format is the rendered format (e.g., "json", "csv", "html", "pdf")
+	Format string `json:"format"`
+
+	// ContentType is the MIME type of Content
+	ContentType string `json:"content_type"`
+
+	// TimeRange is the period covered by the report (e.g., "24h")
+	TimeRange string `json:"time_range"`
+
+	// File is the path the report was written to (if applicable)
+	File string `json:"file,omitempty"`
+
+	// Content is the rendered report (base64 encoded in JSON)
+	Content []byte `json:"content"`
+}
+
 // NewThreatEvent creates a new threat event
 // 創建威脅事件的輔助函數
 func NewThreatEvent(threatType, sourceIP, description, action string, threatLevel int) *ThreatEvent {
 	return &ThreatEvent{
 		BaseEvent: BaseEvent{
-			ID:        generateEventID(),
-			Type:      EventTypeThreatDetected,
-			Timestamp: time.Now(),
-			Source:    "pandora-agent",
-			Severity:  severityFromThreatLevel(threatLevel),
-			Tags:      []string{"threat", threatType},
-			Metadata:  make(map[string]interface{}),
+			ID:            generateEventID(),
+			Type:          EventTypeThreatDetected,
+			SchemaVersion: CurrentSchemaVersion,
+			Timestamp:     time.Now(),
+			Source:        "pandora-agent",
+			Severity:      severityFromThreatLevel(threatLevel),
+			Tags:          []string{"threat", threatType},
+			Metadata:      make(map[string]interface{}),
 		},
 		ThreatType:  threatType,
 		ThreatLevel: threatLevel,
@@ -214,13 +249,14 @@ func NewThreatEvent(threatType, sourceIP, description, action string, threatLeve
 func NewNetworkEvent(eventSubType, sourceIP, destIP, protocol string) *NetworkEvent {
 	return &NetworkEvent{
 		BaseEvent: BaseEvent{
-			ID:        generateEventID(),
-			Type:      EventTypeNetworkAttack,
-			Timestamp: time.Now(),
-			Source:    "pandora-agent",
-			Severity:  "medium",
-			Tags:      []string{"network", eventSubType},
-			Metadata:  make(map[string]interface{}),
+			ID:            generateEventID(),
+			Type:          EventTypeNetworkAttack,
+			SchemaVersion: CurrentSchemaVersion,
+			Timestamp:     time.Now(),
+			Source:        "pandora-agent",
+			Severity:      "medium",
+			Tags:          []string{"network", eventSubType},
+			Metadata:      make(map[string]interface{}),
 		},
 		EventSubType: eventSubType,
 		SourceIP:     sourceIP,
@@ -234,13 +270,14 @@ func NewNetworkEvent(eventSubType, sourceIP, destIP, protocol string) *NetworkEv
 func NewSystemEvent(component, status, message string) *SystemEvent {
 	return &SystemEvent{
 		BaseEvent: BaseEvent{
-			ID:        generateEventID(),
-			Type:      EventTypeSystemStarted,
-			Timestamp: time.Now(),
-			Source:    component,
-			Severity:  "info",
-			Tags:      []string{"system", component},
-			Metadata:  make(map[string]interface{}),
+			ID:            generateEventID(),
+			Type:          EventTypeSystemStarted,
+			SchemaVersion: CurrentSchemaVersion,
+			Timestamp:     time.Now(),
+			Source:        component,
+			Severity:      "info",
+			Tags:          []string{"system", component},
+			Metadata:      make(map[string]interface{}),
 		},
 		Component: component,
 		Status:    status,
@@ -254,13 +291,14 @@ func NewSystemEvent(component, status, message string) *SystemEvent {
 func NewDeviceEvent(deviceID, deviceType, status string) *DeviceEvent {
 	return &DeviceEvent{
 		BaseEvent: BaseEvent{
-			ID:        generateEventID(),
-			Type:      EventTypeDeviceConnected,
-			Timestamp: time.Now(),
-			Source:    "pandora-agent",
-			Severity:  "info",
-			Tags:      []string{"device", deviceType},
-			Metadata:  make(map[string]interface{}),
+			ID:            generateEventID(),
+			Type:          EventTypeDeviceConnected,
+			SchemaVersion: CurrentSchemaVersion,
+			Timestamp:     time.Now(),
+			Source:        "pandora-agent",
+			Severity:      "info",
+			Tags:          []string{"device", deviceType},
+			Metadata:      make(map[string]interface{}),
 		},
 		DeviceID:   deviceID,
 		DeviceType: deviceType,
@@ -269,6 +307,26 @@ func NewDeviceEvent(deviceID, deviceType, status string) *DeviceEvent {
 	}
 }
 
+// NewReportEvent creates a new report event
+// 創建報表事件的輔助函數
+func NewReportEvent(name, reportType, format string) *ReportEvent {
+	return &ReportEvent{
+		BaseEvent: BaseEvent{
+			ID:            generateEventID(),
+			Type:          EventTypeReportGenerated,
+			SchemaVersion: CurrentSchemaVersion,
+			Timestamp:     time.Now(),
+			Source:        "axiom-engine",
+			Severity:      "info",
+			Tags:          []string{"report", reportType},
+			Metadata:      make(map[string]interface{}),
+		},
+		Name:       name,
+		ReportType: reportType,
+		Format:     format,
+	}
+}
+
 // ToJSON converts an event to JSON
 // 將事件轉換為 JSON
 func ToJSON(event interface{}) ([]byte, error) {
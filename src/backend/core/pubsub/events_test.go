@@ -53,6 +53,22 @@ func TestNewDeviceEvent(t *testing.T) {
 	assert.NotEmpty(t, event.ID)
 }
 
+func TestNewReportEvent(t *testing.T) {
+	event := NewReportEvent("daily", "security", "pdf")
+	event.Content = []byte("%PDF-1.4")
+
+	assert.Equal(t, EventTypeReportGenerated, event.Type)
+	assert.Equal(t, "daily", event.Name)
+	assert.Equal(t, []string{"report", "security"}, event.Tags)
+
+	data, err := ToJSON(event)
+	require.NoError(t, err)
+
+	var decoded ReportEvent
+	require.NoError(t, FromJSON(data, &decoded))
+	assert.Equal(t, event.Content, decoded.Content)
+}
+
 func TestToJSON(t *testing.T) {
 	event := NewThreatEvent("ddos", "192.168.1.100", "Test", "blocked", 8)
 
@@ -114,6 +130,7 @@ func TestGetRoutingKey(t *testing.T) {
 		{EventTypeNetworkAttack, "network.attack"},
 		{EventTypeSystemStarted, "system.started"},
 		{EventTypeDeviceConnected, "device.connected"},
+		{EventTypeReportGenerated, "report.generated"},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,389 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeBroker 記憶體 AMQP broker，實作 amqpChannel 供單元測試使用：
+// 支援預設/direct/topic/fanout 交換機、手動確認、x-message-ttl 與 x-dead-letter-* 隊列參數
+type fakeBroker struct {
+	mu        sync.Mutex
+	exchanges map[string]string
+	bindings  map[string][]fakeBinding
+	queues    map[string]*fakeQueue
+	unacked   map[uint64]*fakeUnacked
+	nextTag   uint64
+	closed    bool
+}
+
+type fakeBinding struct {
+	queue string
+	key   string
+}
+
+type fakeMessage struct {
+	exchange   string
+	routingKey string
+	publishing amqp.Publishing
+}
+
+type fakeQueue struct {
+	name      string
+	args      amqp.Table
+	messages  []*fakeMessage
+	consumers []chan amqp.Delivery
+	next      int
+}
+
+type fakeUnacked struct {
+	queue   string
+	message *fakeMessage
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		exchanges: map[string]string{"": "direct"},
+		bindings:  make(map[string][]fakeBinding),
+		queues:    make(map[string]*fakeQueue),
+		unacked:   make(map[uint64]*fakeUnacked),
+	}
+}
+
+// fakeConfirmChannel 以 publisher confirm 模式發布到 fakeBroker 的通道；nack 時 broker 拒絕並丟棄消息
+type fakeConfirmChannel struct {
+	*fakeBroker
+	mu        sync.Mutex
+	listeners []chan amqp.Confirmation
+	tag       uint64
+	nack      bool
+}
+
+// newTestRabbitMQ 建立使用記憶體 broker 的 RabbitMQ
+func newTestRabbitMQ(broker *fakeBroker) *RabbitMQ {
+	confirm, err := newConfirmPublisher(&fakeConfirmChannel{fakeBroker: broker})
+	if err != nil {
+		panic(err)
+	}
+	return &RabbitMQ{
+		config:    &Config{Exchange: "pandora.events", ConnectionTimeout: time.Second},
+		ch:        broker,
+		confirm:   confirm,
+		closeChan: make(chan struct{}),
+	}
+}
+
+func (c *fakeConfirmChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (c *fakeConfirmChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.listeners = append(c.listeners, confirm)
+	return confirm
+}
+
+func (c *fakeConfirmChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.nack {
+		if err := c.fakeBroker.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg); err != nil {
+			return err
+		}
+	}
+	c.tag++
+	for _, listener := range c.listeners {
+		listener <- amqp.Confirmation{DeliveryTag: c.tag, Ack: !c.nack}
+	}
+	return nil
+}
+
+// Close 只關閉確認通知，broker 由 RabbitMQ.ch 關閉
+func (c *fakeConfirmChannel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, listener := range c.listeners {
+		close(listener)
+	}
+	c.listeners = nil
+	return nil
+}
+
+func (c *fakeConfirmChannel) setNack(nack bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nack = nack
+}
+
+func (b *fakeBroker) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if existing, ok := b.exchanges[name]; ok && existing != kind {
+		return fmt.Errorf("PRECONDITION_FAILED - exchange %s already declared as %s", name, existing)
+	}
+	b.exchanges[name] = kind
+	return nil
+}
+
+func (b *fakeBroker) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		q = &fakeQueue{name: name, args: args}
+		b.queues[name] = q
+	}
+	return amqp.Queue{Name: name, Messages: len(q.messages), Consumers: len(q.consumers)}, nil
+}
+
+func (b *fakeBroker) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		return amqp.Queue{}, fmt.Errorf("NOT_FOUND - no queue '%s'", name)
+	}
+	return amqp.Queue{Name: name, Messages: len(q.messages), Consumers: len(q.consumers)}, nil
+}
+
+func (b *fakeBroker) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.exchanges[exchange]; !ok {
+		return fmt.Errorf("NOT_FOUND - no exchange '%s'", exchange)
+	}
+	if _, ok := b.queues[name]; !ok {
+		return fmt.Errorf("NOT_FOUND - no queue '%s'", name)
+	}
+	b.bindings[exchange] = append(b.bindings[exchange], fakeBinding{queue: name, key: key})
+	return nil
+}
+
+func (b *fakeBroker) QueuePurge(name string, noWait bool) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		return 0, fmt.Errorf("NOT_FOUND - no queue '%s'", name)
+	}
+	purged := len(q.messages)
+	q.messages = nil
+	return purged, nil
+}
+
+func (b *fakeBroker) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return nil
+}
+
+func (b *fakeBroker) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("channel/connection is not open")
+	}
+	return b.route(&fakeMessage{exchange: exchange, routingKey: key, publishing: msg})
+}
+
+// route 依交換機類型將消息放入符合的隊列
+func (b *fakeBroker) route(msg *fakeMessage) error {
+	kind, ok := b.exchanges[msg.exchange]
+	if !ok {
+		return fmt.Errorf("NOT_FOUND - no exchange '%s'", msg.exchange)
+	}
+	if msg.exchange == "" {
+		if q, ok := b.queues[msg.routingKey]; ok {
+			b.enqueue(q, msg)
+		}
+		return nil
+	}
+	for _, binding := range b.bindings[msg.exchange] {
+		matched := kind == "fanout" ||
+			(kind == "direct" && binding.key == msg.routingKey) ||
+			(kind == "topic" && MatchRoutingKey(binding.key, msg.routingKey))
+		if matched {
+			b.enqueue(b.queues[binding.queue], msg)
+		}
+	}
+	return nil
+}
+
+// enqueue 放入隊列；設定 x-message-ttl 的隊列在過期後轉送到 x-dead-letter-exchange
+func (b *fakeBroker) enqueue(q *fakeQueue, msg *fakeMessage) {
+	copied := *msg
+	q.messages = append(q.messages, &copied)
+	if ttl, ok := q.args["x-message-ttl"].(int64); ok {
+		time.AfterFunc(time.Duration(ttl)*time.Millisecond, func() { b.expire(q, &copied) })
+	}
+	b.dispatch(q)
+}
+
+func (b *fakeBroker) expire(q *fakeQueue, msg *fakeMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, queued := range q.messages {
+		if queued != msg {
+			continue
+		}
+		q.messages = append(q.messages[:i], q.messages[i+1:]...)
+		dlx, ok := q.args["x-dead-letter-exchange"].(string)
+		if !ok {
+			return
+		}
+		key := msg.routingKey
+		if dlk, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+			key = dlk
+		}
+		b.route(&fakeMessage{exchange: dlx, routingKey: key, publishing: msg.publishing})
+		return
+	}
+}
+
+// dispatch 將隊列中的消息輪流交給消費者
+func (b *fakeBroker) dispatch(q *fakeQueue) {
+	for len(q.messages) > 0 && len(q.consumers) > 0 && !b.closed {
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		consumer := q.consumers[q.next%len(q.consumers)]
+		q.next++
+		consumer <- b.delivery(q, msg, false)
+	}
+}
+
+func (b *fakeBroker) delivery(q *fakeQueue, msg *fakeMessage, autoAck bool) amqp.Delivery {
+	b.nextTag++
+	if !autoAck {
+		b.unacked[b.nextTag] = &fakeUnacked{queue: q.name, message: msg}
+	}
+	return amqp.Delivery{
+		Acknowledger: b,
+		Headers:      msg.publishing.Headers,
+		ContentType:  msg.publishing.ContentType,
+		DeliveryMode: msg.publishing.DeliveryMode,
+		Priority:     msg.publishing.Priority,
+		MessageId:    msg.publishing.MessageId,
+		Timestamp:    msg.publishing.Timestamp,
+		MessageCount: uint32(len(q.messages)),
+		DeliveryTag:  b.nextTag,
+		Exchange:     msg.exchange,
+		RoutingKey:   msg.routingKey,
+		Body:         msg.publishing.Body,
+	}
+}
+
+func (b *fakeBroker) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queue]
+	if !ok {
+		return nil, fmt.Errorf("NOT_FOUND - no queue '%s'", queue)
+	}
+	deliveries := make(chan amqp.Delivery, 1024)
+	q.consumers = append(q.consumers, deliveries)
+	b.dispatch(q)
+	return deliveries, nil
+}
+
+func (b *fakeBroker) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queue]
+	if !ok {
+		return amqp.Delivery{}, false, fmt.Errorf("NOT_FOUND - no queue '%s'", queue)
+	}
+	if len(q.messages) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	msg := q.messages[0]
+	q.messages = q.messages[1:]
+	return b.delivery(q, msg, autoAck), true, nil
+}
+
+func (b *fakeBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for _, q := range b.queues {
+		for _, consumer := range q.consumers {
+			close(consumer)
+		}
+		q.consumers = nil
+	}
+	return nil
+}
+
+func (b *fakeBroker) Ack(tag uint64, multiple bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.unacked[tag]; !ok {
+		return fmt.Errorf("PRECONDITION_FAILED - unknown delivery tag %d", tag)
+	}
+	delete(b.unacked, tag)
+	return nil
+}
+
+// Nack 重新放回時置於隊列最前面
+func (b *fakeBroker) Nack(tag uint64, multiple, requeue bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	unacked, ok := b.unacked[tag]
+	if !ok {
+		return fmt.Errorf("PRECONDITION_FAILED - unknown delivery tag %d", tag)
+	}
+	delete(b.unacked, tag)
+	if requeue {
+		q := b.queues[unacked.queue]
+		q.messages = append([]*fakeMessage{unacked.message}, q.messages...)
+		b.dispatch(q)
+	}
+	return nil
+}
+
+func (b *fakeBroker) Reject(tag uint64, requeue bool) error {
+	return b.Nack(tag, false, requeue)
+}
+
+// depth 隊列中等待的消息數量
+func (b *fakeBroker) depth(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if q, ok := b.queues[queue]; ok {
+		return len(q.messages)
+	}
+	return -1
+}
+
+// queueArgs 隊列宣告時的參數
+func (b *fakeBroker) queueArgs(queue string) amqp.Table {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if q, ok := b.queues[queue]; ok {
+		return q.args
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultIdempotencyTTL is how long a processed event ID is remembered
+// 已處理事件 ID 的預設保留時間
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// DefaultIdempotencyLease is how long an event stays claimed while its handler runs;
+// if the consumer crashes the claim expires and the redelivery is processed
+// 處理中事件的預設租約，消費者崩潰時租約到期，重新投遞的消息可再次處理
+const DefaultIdempotencyLease = 5 * time.Minute
+
+// ErrEventInProgress is returned when another consumer holds the lease for an event;
+// the message should be retried later rather than acknowledged
+// 事件正由其他消費者處理中，應稍後重試而非直接確認
+var ErrEventInProgress = errors.New("event is being processed by another consumer")
+
+// IdempotencyStore remembers which event IDs have been processed
+// 冪等記錄：保存已處理的事件 ID
+type IdempotencyStore interface {
+	// Claim takes a short lease on id; it returns false if id was already processed
+	// and ErrEventInProgress if another consumer holds the lease
+	Claim(ctx context.Context, id string) (bool, error)
+
+	// Complete records id as processed for the store's ttl
+	Complete(ctx context.Context, id string) error
+
+	// Release forgets id so that a redelivery is processed again
+	Release(ctx context.Context, id string) error
+}
+
+// IdempotentHandler wraps handler so each BaseEvent.ID is handled once;
+// duplicates are acknowledged without calling handler, messages without an ID are passed through.
+// The ID is recorded as processed only after handler succeeds; a failed handler releases it
+// and a crashed one leaves a lease that expires, so the redelivery is processed
+// 以 BaseEvent.ID 去重的消息處理函數：重複的事件直接確認，沒有 ID 的消息照常處理；
+// 處理成功後才記錄為已處理，失敗時釋放 ID，崩潰時租約到期後重新投遞的消息會再次處理
+func IdempotentHandler(store IdempotencyStore, handler MessageHandler) MessageHandler {
+	return func(topic string, message []byte) error {
+		var base struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(message, &base); err != nil || base.ID == "" {
+			return handler(topic, message)
+		}
+
+		ctx := context.Background()
+		claimed, err := store.Claim(ctx, base.ID)
+		if err != nil {
+			return fmt.Errorf("failed to claim event %s: %w", base.ID, err)
+		}
+		if !claimed {
+			log.Printf("[PubSub] Skipping duplicate event %s on %s", base.ID, topic)
+			return nil
+		}
+
+		if err := handler(topic, message); err != nil {
+			if releaseErr := store.Release(ctx, base.ID); releaseErr != nil {
+				log.Printf("[PubSub] Failed to release event %s: %v", base.ID, releaseErr)
+			}
+			return err
+		}
+		if err := store.Complete(ctx, base.ID); err != nil {
+			// 已處理但未記錄：租約到期前的重複消息仍會被拒絕，之後可能再處理一次
+			log.Printf("[PubSub] Failed to record event %s as processed: %v", base.ID, err)
+		}
+		return nil
+	}
+}
+
+// MemoryIdempotencyStore keeps event IDs in memory for ttl
+// 記憶體冪等記錄，適用於單一消費者進程
+type MemoryIdempotencyStore struct {
+	ttl       time.Duration
+	lease     time.Duration
+	mu        sync.Mutex
+	seen      map[string]idempotencyEntry
+	lastSweep time.Time
+}
+
+// idempotencyEntry 事件的處理狀態與到期時間
+type idempotencyEntry struct {
+	processed bool
+	expires   time.Time
+}
+
+// NewMemoryIdempotencyStore creates an in-memory store (ttl <= 0 uses DefaultIdempotencyTTL)
+// 創建記憶體冪等記錄
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return &MemoryIdempotencyStore{
+		ttl:       ttl,
+		lease:     DefaultIdempotencyLease,
+		seen:      make(map[string]idempotencyEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// SetLease sets how long a claim lasts before it is completed (lease <= 0 uses DefaultIdempotencyLease)
+func (s *MemoryIdempotencyStore) SetLease(lease time.Duration) {
+	if lease <= 0 {
+		lease = DefaultIdempotencyLease
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lease = lease
+}
+
+// Claim leases id; it returns false if id was processed within ttl
+func (s *MemoryIdempotencyStore) Claim(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= s.ttl {
+		for key, entry := range s.seen {
+			if now.After(entry.expires) {
+				delete(s.seen, key)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if entry, ok := s.seen[id]; ok && now.Before(entry.expires) {
+		if entry.processed {
+			return false, nil
+		}
+		return false, ErrEventInProgress
+	}
+	s.seen[id] = idempotencyEntry{expires: now.Add(s.lease)}
+	return true, nil
+}
+
+// Complete records id as processed for ttl
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seen[id] = idempotencyEntry{processed: true, expires: time.Now().Add(s.ttl)}
+	return nil
+}
+
+// Release forgets id
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.seen, id)
+	return nil
+}
+
+// RedisIdempotencyStore keeps event IDs in Redis so competing consumers share them
+// Redis 冪等記錄，多個競爭消費者共用
+type RedisIdempotencyStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	lease  time.Duration
+}
+
+const (
+	// redisClaimProcessing 處理中的事件值
+	redisClaimProcessing = "processing"
+	// redisClaimProcessed 已處理的事件值
+	redisClaimProcessed = "processed"
+)
+
+// NewRedisIdempotencyStore creates a Redis store; keys are "<prefix>:<id>" (prefix defaults to "pandora:processed")
+// 創建 Redis 冪等記錄
+func NewRedisIdempotencyStore(client *redis.Client, prefix string, ttl time.Duration) *RedisIdempotencyStore {
+	if prefix == "" {
+		prefix = "pandora:processed"
+	}
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return &RedisIdempotencyStore{client: client, prefix: prefix, ttl: ttl, lease: DefaultIdempotencyLease}
+}
+
+// SetLease sets how long a claim lasts before it is completed (lease <= 0 uses DefaultIdempotencyLease)
+func (s *RedisIdempotencyStore) SetLease(lease time.Duration) {
+	if lease <= 0 {
+		lease = DefaultIdempotencyLease
+	}
+	s.lease = lease
+}
+
+// Claim leases id with SET NX; it returns false if id was already processed
+func (s *RedisIdempotencyStore) Claim(ctx context.Context, id string) (bool, error) {
+	key := s.prefix + ":" + id
+	claimed, err := s.client.SetNX(ctx, key, redisClaimProcessing, s.lease).Result()
+	if err != nil || claimed {
+		return claimed, err
+	}
+
+	state, err := s.client.Get(ctx, key).Result()
+	switch {
+	case err == redis.Nil:
+		// 租約剛好到期，交由重試再次搶占
+		return false, ErrEventInProgress
+	case err != nil:
+		return false, err
+	case state == redisClaimProcessed:
+		return false, nil
+	default:
+		return false, ErrEventInProgress
+	}
+}
+
+// Complete records id as processed for ttl
+func (s *RedisIdempotencyStore) Complete(ctx context.Context, id string) error {
+	return s.client.Set(ctx, s.prefix+":"+id, redisClaimProcessed, s.ttl).Err()
+}
+
+// Release deletes id
+func (s *RedisIdempotencyStore) Release(ctx context.Context, id string) error {
+	return s.client.Del(ctx, s.prefix+":"+id).Err()
+}
+
+// NewIdempotencyStoreForConfig returns a Redis store when config.Type is "redis" so that
+// competing consumers share processed IDs, and an in-memory store otherwise;
+// the returned close function releases the Redis client
+// 依配置選擇冪等記錄：Redis Streams 使用 Redis，其餘使用記憶體
+func NewIdempotencyStoreForConfig(config *Config) (IdempotencyStore, func() error) {
+	if config == nil || config.Type != "redis" {
+		return NewMemoryIdempotencyStore(DefaultIdempotencyTTL), func() error { return nil }
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:        config.RedisAddr,
+		Password:    config.RedisPassword,
+		DB:          config.RedisDB,
+		DialTimeout: config.ConnectionTimeout,
+	})
+	return NewRedisIdempotencyStore(client, "", DefaultIdempotencyTTL), client.Close
+}
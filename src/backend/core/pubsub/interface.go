@@ -151,9 +151,26 @@ type Config struct {
 	// RedisDB is the Redis database number
 	RedisDB int
 
+	// CloudEventsMode selects the wire format of published events (RabbitMQ; default legacy JSON).
+	// Subscribers accept every format regardless of this setting
+	CloudEventsMode CloudEventsMode
+
 	// BufferSize is the message buffer size
 	BufferSize int
 
+	// Bindings are the queue bindings used by the memory and Redis Streams backends
+	// (RabbitMQ bindings come from the broker definitions); defaults to DefaultBindings
+	Bindings []Binding
+
+	// RedisStreamPrefix is the key prefix of Redis Streams queues (default "pandora:pubsub")
+	RedisStreamPrefix string
+
+	// RedisStreamMaxLen caps each queue stream, approximately (0 = unlimited)
+	RedisStreamMaxLen int64
+
+	// RedisClaimIdle is how long a message may stay pending on another consumer before it is reclaimed
+	RedisClaimIdle time.Duration
+
 	// ConnectionTimeout is the timeout for establishing a connection
 	ConnectionTimeout time.Duration
 
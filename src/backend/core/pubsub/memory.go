@@ -0,0 +1,354 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMemoryBufferSize is the per-queue buffer of MemoryQueue when Config.BufferSize is not set
+	defaultMemoryBufferSize = 1000
+	// maxMemoryDeadLetters caps the dead letters kept per queue; the oldest are discarded first
+	maxMemoryDeadLetters = 10000
+)
+
+// ErrQueueFull is returned by MemoryQueue.Publish when a target queue has no room;
+// the message is still delivered to the other target queues
+var ErrQueueFull = errors.New("message queue is full")
+
+// MemoryQueue is an in-process MessageQueue with exchange/routing-key topic semantics
+// 進程內消息隊列：依綁定的 topic 樣式 (threat.*、#) 將消息送入隊列，
+// 支援重試、死信與競爭消費者，適用於邊緣部署與單元測試
+type MemoryQueue struct {
+	config    *Config
+	mu        sync.RWMutex
+	bindings  []Binding
+	defaults  []Binding
+	queues    map[string]*memoryQueue
+	maxDead   int
+	closed    bool
+	closeChan chan struct{}
+}
+
+// memoryQueue holds the pending and dead messages of a queue
+type memoryQueue struct {
+	messages chan *memoryEnvelope
+	dead     []*memoryEnvelope
+}
+
+// memoryEnvelope is a message routed to a queue
+type memoryEnvelope struct {
+	exchange   string
+	routingKey string
+	body       []byte
+	retries    int
+	reason     string
+	failedAt   time.Time
+}
+
+// NewMemoryQueue creates an in-process message queue. config.Bindings are bound immediately;
+// without them a DefaultBindings queue is bound only when it is first subscribed, so
+// messages are not buffered for queues nobody consumes
+// 創建進程內消息隊列
+func NewMemoryQueue(config *Config) *MemoryQueue {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	mq := &MemoryQueue{
+		config:    config,
+		queues:    make(map[string]*memoryQueue),
+		maxDead:   maxMemoryDeadLetters,
+		closeChan: make(chan struct{}),
+	}
+	if config.Bindings == nil {
+		mq.defaults = DefaultBindings(config.Exchange)
+	}
+	for _, binding := range config.Bindings {
+		mq.Bind(binding)
+	}
+	return mq
+}
+
+// Bind routes messages published to binding.Exchange with a matching routing key to binding.Queue
+// 綁定隊列
+func (mq *MemoryQueue) Bind(binding Binding) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	mq.bind(binding)
+}
+
+// bind adds the binding unless it exists; callers hold mq.mu
+func (mq *MemoryQueue) bind(binding Binding) {
+	for _, existing := range mq.bindings {
+		if existing == binding {
+			return
+		}
+	}
+	mq.bindings = append(mq.bindings, binding)
+	mq.queue(binding.Queue)
+}
+
+// queue returns the named queue, creating it if needed; callers hold mq.mu
+func (mq *MemoryQueue) queue(name string) *memoryQueue {
+	q, ok := mq.queues[name]
+	if !ok {
+		size := mq.config.BufferSize
+		if size <= 0 {
+			size = defaultMemoryBufferSize
+		}
+		q = &memoryQueue{messages: make(chan *memoryEnvelope, size)}
+		mq.queues[name] = q
+	}
+	return q
+}
+
+// Publish routes the message to every queue bound with a matching pattern without blocking;
+// queues that are full drop the message and are reported with ErrQueueFull
+// 發布消息到符合綁定的隊列，不等待；已滿的隊列丟棄該消息並返回 ErrQueueFull
+func (mq *MemoryQueue) Publish(ctx context.Context, exchange, routingKey string, message []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mq.mu.RLock()
+	if mq.closed {
+		mq.mu.RUnlock()
+		return fmt.Errorf("message queue is closed")
+	}
+	var names []string
+	var targets []*memoryQueue
+	seen := make(map[string]bool)
+	for _, binding := range mq.bindings {
+		if binding.Exchange == exchange && !seen[binding.Queue] && MatchRoutingKey(binding.RoutingKey, routingKey) {
+			seen[binding.Queue] = true
+			names = append(names, binding.Queue)
+			targets = append(targets, mq.queues[binding.Queue])
+		}
+	}
+	mq.mu.RUnlock()
+
+	body := append([]byte(nil), message...)
+	var full []string
+	for i, q := range targets {
+		if !mq.enqueue(q, &memoryEnvelope{exchange: exchange, routingKey: routingKey, body: body}) {
+			full = append(full, names[i])
+		}
+	}
+	if len(full) > 0 {
+		return fmt.Errorf("%w: %v", ErrQueueFull, full)
+	}
+	return nil
+}
+
+// enqueue puts the envelope on the queue unless it is full
+func (mq *MemoryQueue) enqueue(q *memoryQueue, envelope *memoryEnvelope) bool {
+	select {
+	case q.messages <- envelope:
+		return true
+	default:
+		return false
+	}
+}
+
+// deadLetter records the failed envelope, discarding the oldest dead letters beyond maxDead
+func (mq *MemoryQueue) deadLetter(q *memoryQueue, envelope *memoryEnvelope, reason string) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	envelope.reason = reason
+	envelope.failedAt = time.Now().UTC()
+	q.dead = append(q.dead, envelope)
+	if excess := len(q.dead) - mq.maxDead; excess > 0 {
+		q.dead = append([]*memoryEnvelope(nil), q.dead[excess:]...)
+	}
+}
+
+// Subscribe consumes the queue with the default options
+// 訂閱指定隊列的消息 (預設選項)
+func (mq *MemoryQueue) Subscribe(ctx context.Context, queue string, handler MessageHandler) error {
+	return mq.SubscribeWithOptions(ctx, queue, handler, DefaultSubscribeOptions())
+}
+
+// SubscribeWithOptions consumes the queue until ctx is canceled or the queue is closed;
+// several subscribers of the same queue compete for its messages
+// 訂閱指定隊列；同一隊列的多個訂閱者競爭消費，失敗時依 RetryPolicy 重試後送入死信
+func (mq *MemoryQueue) SubscribeWithOptions(ctx context.Context, queue string, handler MessageHandler, opts *SubscribeOptions) error {
+	if opts == nil {
+		opts = DefaultSubscribeOptions()
+	}
+
+	mq.mu.Lock()
+	if mq.closed {
+		mq.mu.Unlock()
+		return fmt.Errorf("message queue is closed")
+	}
+	for _, binding := range mq.defaults {
+		if binding.Queue == queue {
+			mq.bind(binding)
+		}
+	}
+	q := mq.queue(queue)
+	mq.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-mq.closeChan:
+				return
+			case envelope := <-q.messages:
+				mq.handle(q, opts, handler, envelope)
+			}
+		}
+	}()
+	return nil
+}
+
+// handle runs the handler and schedules a retry or dead-letters the message on failure
+func (mq *MemoryQueue) handle(q *memoryQueue, opts *SubscribeOptions, handler MessageHandler, envelope *memoryEnvelope) {
+	body, err := DecodeEvent(envelope.body, "", nil)
+	if err != nil {
+		err = Permanent(fmt.Errorf("invalid CloudEvent: %w", err))
+	} else {
+		err = callHandler(handler, envelope.routingKey, body)
+	}
+	if err == nil || opts.AutoAck {
+		if err != nil {
+			log.Printf("[MemoryQueue] Error handling message: %v", err)
+		}
+		return
+	}
+
+	log.Printf("[MemoryQueue] Error handling message: %v", err)
+	policy := opts.RetryPolicy
+	if policy != nil && (envelope.retries >= policy.MaxRetries || IsPermanent(err)) {
+		mq.deadLetter(q, envelope, err.Error())
+		return
+	}
+
+	delay := time.Duration(0)
+	if policy != nil {
+		delay = policy.Delay(envelope.retries)
+		envelope.retries++
+	}
+	// 延遲後放回；隊列已關閉時放棄，隊列已滿時送入死信而不是等待
+	time.AfterFunc(delay, func() {
+		select {
+		case <-mq.closeChan:
+			return
+		default:
+		}
+		if !mq.enqueue(q, envelope) {
+			mq.deadLetter(q, envelope, fmt.Sprintf("%v (retry dropped: %v)", err, ErrQueueFull))
+		}
+	})
+}
+
+// InspectDeadLetters returns up to limit dead letters of queue without removing them
+// 查看死信
+func (mq *MemoryQueue) InspectDeadLetters(ctx context.Context, queue string, limit int) ([]DeadLetter, error) {
+	if limit <= 0 {
+		limit = DefaultDeadLetterLimit
+	}
+
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	letters := make([]DeadLetter, 0)
+	q, ok := mq.queues[queue]
+	if !ok {
+		return letters, nil
+	}
+	for _, envelope := range q.dead {
+		if len(letters) == limit {
+			break
+		}
+		letters = append(letters, DeadLetter{
+			Queue:      queue,
+			Exchange:   envelope.exchange,
+			RoutingKey: envelope.routingKey,
+			Reason:     envelope.reason,
+			RetryCount: envelope.retries,
+			FailedAt:   envelope.failedAt,
+			Body:       envelope.body,
+		})
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetters re-publishes up to limit dead letters (0 = all) to their original exchange and routing key
+// 重新發布死信到原始交換機與路由鍵
+func (mq *MemoryQueue) ReplayDeadLetters(ctx context.Context, queue string, limit int) (int, error) {
+	mq.mu.Lock()
+	q, ok := mq.queues[queue]
+	if !ok {
+		mq.mu.Unlock()
+		return 0, nil
+	}
+	n := len(q.dead)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	replay := q.dead[:n:n]
+	q.dead = q.dead[n:]
+	mq.mu.Unlock()
+
+	for i, envelope := range replay {
+		if err := mq.Publish(ctx, envelope.exchange, envelope.routingKey, envelope.body); err != nil {
+			// 未重播的死信放回隊列前端
+			mq.mu.Lock()
+			q.dead = append(append([]*memoryEnvelope(nil), replay[i:]...), q.dead...)
+			mq.mu.Unlock()
+			return i, err
+		}
+	}
+	return len(replay), nil
+}
+
+// PurgeDeadLetters removes all dead letters of queue
+// 清空死信
+func (mq *MemoryQueue) PurgeDeadLetters(ctx context.Context, queue string) (int, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	q, ok := mq.queues[queue]
+	if !ok {
+		return 0, nil
+	}
+	purged := len(q.dead)
+	q.dead = nil
+	return purged, nil
+}
+
+// Close stops all subscribers; pending messages are discarded
+// 關閉隊列並停止所有訂閱者
+func (mq *MemoryQueue) Close() error {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+
+	if mq.closed {
+		return nil
+	}
+	mq.closed = true
+	close(mq.closeChan)
+	return nil
+}
+
+// Health reports an error once the queue is closed
+// 健康檢查
+func (mq *MemoryQueue) Health(ctx context.Context) error {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	if mq.closed {
+		return fmt.Errorf("message queue is closed")
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queueBackend 建立測試用的消息隊列後端，同一後端建立的實例共用資料
+type queueBackend struct {
+	name string
+	new  func(t *testing.T, config *Config) MessageQueue
+}
+
+func queueBackends(t *testing.T) []queueBackend {
+	server := miniredis.RunT(t)
+	return []queueBackend{
+		{
+			name: "memory",
+			new: func(t *testing.T, config *Config) MessageQueue {
+				mq := NewMemoryQueue(config)
+				t.Cleanup(func() { mq.Close() })
+				return mq
+			},
+		},
+		{
+			name: "redis",
+			new: func(t *testing.T, config *Config) MessageQueue {
+				client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+				t.Cleanup(func() { client.Close() })
+				mq, err := NewRedisStreamsWithClient(client, config)
+				require.NoError(t, err)
+				t.Cleanup(func() { mq.Close() })
+				return mq
+			},
+		},
+	}
+}
+
+func testQueueConfig(prefix string) *Config {
+	config := DefaultConfig()
+	config.RedisStreamPrefix = prefix
+	config.RedisClaimIdle = 100 * time.Millisecond
+	config.Bindings = append(DefaultBindings("pandora.events"),
+		Binding{Exchange: "pandora.events", Queue: "audit", RoutingKey: "#"},
+		Binding{Exchange: "pandora.events", Queue: "critical", RoutingKey: "*.critical.#"},
+	)
+	return config
+}
+
+// topicCollector 依隊列收集收到的路由鍵
+type topicCollector struct {
+	mu     sync.Mutex
+	topics map[string][]string
+	bodies map[string][]string
+}
+
+func (c *topicCollector) handler(queue string) MessageHandler {
+	return func(topic string, message []byte) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.topics == nil {
+			c.topics = make(map[string][]string)
+			c.bodies = make(map[string][]string)
+		}
+		c.topics[queue] = append(c.topics[queue], topic)
+		c.bodies[queue] = append(c.bodies[queue], string(message))
+		return nil
+	}
+}
+
+func (c *topicCollector) get(queue string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.topics[queue]...)
+}
+
+func TestMatchRoutingKey(t *testing.T) {
+	cases := []struct {
+		pattern, key string
+		match        bool
+	}{
+		{"threat.*", "threat.detected", true},
+		{"threat.*", "threat.detected.high", false},
+		{"threat.*", "threat", false},
+		{"threat.#", "threat", true},
+		{"threat.#", "threat.detected.high", true},
+		{"#", "anything.at.all", true},
+		{"#", "", true},
+		{"*.critical.#", "threat.critical", true},
+		{"*.critical.#", "network.critical.ddos", true},
+		{"*.critical.#", "critical.threat", false},
+		{"#.blocked", "threat.ip.blocked", true},
+		{"#.#.blocked", "blocked", true},
+		{"device.status", "device.status", true},
+		{"device.status", "device.online", false},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.match, MatchRoutingKey(tc.pattern, tc.key), "%s ~ %s", tc.pattern, tc.key)
+	}
+}
+
+// TestMessageQueueBackends 相同的發布/訂閱程式碼在各後端上的行為一致
+func TestMessageQueueBackends(t *testing.T) {
+	for _, backend := range queueBackends(t) {
+		t.Run(backend.name, func(t *testing.T) {
+			t.Run("TopicRouting", func(t *testing.T) {
+				mq := backend.new(t, testQueueConfig("routing"))
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				// 訂閱前發布的消息也會送達
+				require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.detected", []byte("early")))
+
+				collector := &topicCollector{}
+				for _, queue := range []string{"threat_events", "network_events", "audit", "critical"} {
+					require.NoError(t, mq.Subscribe(ctx, queue, collector.handler(queue)))
+				}
+
+				threat := NewThreatEvent("ddos", "203.0.113.1", "DDoS", "blocked", 8)
+				message, err := ToJSON(threat)
+				require.NoError(t, err)
+				require.NoError(t, mq.Publish(ctx, "pandora.events", GetRoutingKey(threat.Type), message))
+				require.NoError(t, mq.Publish(ctx, "pandora.events", "network.critical.ddos", []byte("{}")))
+				require.NoError(t, mq.Publish(ctx, "pandora.events", "unrouted", []byte("{}")))
+				require.NoError(t, mq.Publish(ctx, "other.exchange", "threat.detected", []byte("{}")))
+
+				require.Eventually(t, func() bool { return len(collector.get("audit")) == 4 }, 2*time.Second, 5*time.Millisecond)
+				assert.Equal(t, []string{"threat.detected", "threat.detected"}, collector.get("threat_events"))
+				assert.Empty(t, collector.get("network_events"), "network.* 只比對一個單字")
+				assert.Equal(t, []string{"network.critical.ddos"}, collector.get("critical"))
+
+				collector.mu.Lock()
+				assert.Equal(t, "early", collector.bodies["threat_events"][0])
+				assert.Equal(t, string(message), collector.bodies["threat_events"][1])
+				collector.mu.Unlock()
+			})
+
+			t.Run("CompetingConsumers", func(t *testing.T) {
+				config := testQueueConfig("competing")
+				first, second := backend.new(t, config), backend.new(t, config)
+				if backend.name == "memory" {
+					second = first
+				}
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				var mu sync.Mutex
+				var received []string
+				handler := func(topic string, message []byte) error {
+					mu.Lock()
+					defer mu.Unlock()
+					received = append(received, string(message))
+					return nil
+				}
+				require.NoError(t, first.Subscribe(ctx, "system_events", handler))
+				require.NoError(t, second.Subscribe(ctx, "system_events", handler))
+
+				want := []string{"a", "b", "c", "d", "e", "f"}
+				for _, body := range want {
+					require.NoError(t, first.Publish(ctx, "pandora.events", "system.started", []byte(body)))
+				}
+				require.Eventually(t, func() bool {
+					mu.Lock()
+					defer mu.Unlock()
+					return len(received) >= len(want)
+				}, 2*time.Second, 5*time.Millisecond)
+				time.Sleep(50 * time.Millisecond)
+
+				mu.Lock()
+				defer mu.Unlock()
+				sort.Strings(received)
+				assert.Equal(t, want, received, "每則消息只由一個消費者處理")
+			})
+
+			t.Run("RetryAndDeadLetter", func(t *testing.T) {
+				mq := backend.new(t, testQueueConfig("retry"))
+				admin, ok := mq.(DeadLetterAdmin)
+				require.True(t, ok)
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				var mu sync.Mutex
+				attempts := make(map[string]int)
+				handler := func(topic string, message []byte) error {
+					mu.Lock()
+					defer mu.Unlock()
+					attempts[string(message)]++
+					switch {
+					case string(message) == "poison":
+						return Permanent(errors.New("cannot decode"))
+					case string(message) == "flaky" && attempts["flaky"] < 3:
+						return errors.New("temporary")
+					case string(message) == "broken":
+						return errors.New("always fails")
+					}
+					return nil
+				}
+				opts := DefaultSubscribeOptions()
+				opts.RetryPolicy = &RetryPolicy{MaxRetries: 2, InitialInterval: 20 * time.Millisecond, Multiplier: 2}
+				subscriber := mq.(interface {
+					SubscribeWithOptions(context.Context, string, MessageHandler, *SubscribeOptions) error
+				})
+				require.NoError(t, subscriber.SubscribeWithOptions(ctx, "device_events", handler, opts))
+
+				for _, body := range []string{"flaky", "poison", "broken"} {
+					require.NoError(t, mq.Publish(ctx, "pandora.events", "device.status", []byte(body)))
+				}
+
+				var letters []DeadLetter
+				require.Eventually(t, func() bool {
+					var err error
+					letters, err = admin.InspectDeadLetters(ctx, "device_events", 0)
+					return err == nil && len(letters) == 2
+				}, 3*time.Second, 10*time.Millisecond)
+
+				mu.Lock()
+				assert.Equal(t, map[string]int{"flaky": 3, "poison": 1, "broken": 3}, attempts)
+				mu.Unlock()
+
+				byBody := map[string]DeadLetter{}
+				for _, letter := range letters {
+					byBody[string(letter.Body)] = letter
+				}
+				assert.Equal(t, 0, byBody["poison"].RetryCount)
+				assert.Contains(t, byBody["poison"].Reason, "cannot decode")
+				assert.Equal(t, 2, byBody["broken"].RetryCount)
+				assert.Equal(t, "always fails", byBody["broken"].Reason)
+				assert.Equal(t, "device.status", byBody["broken"].RoutingKey)
+				assert.Equal(t, "pandora.events", byBody["broken"].Exchange)
+				assert.False(t, byBody["broken"].FailedAt.IsZero())
+
+				replayed, err := admin.ReplayDeadLetters(ctx, "device_events", 1)
+				require.NoError(t, err)
+				assert.Equal(t, 1, replayed)
+				require.Eventually(t, func() bool {
+					letters, err := admin.InspectDeadLetters(ctx, "device_events", 0)
+					return err == nil && len(letters) == 2
+				}, 3*time.Second, 10*time.Millisecond, "重播後再次失敗回到死信")
+
+				purged, err := admin.PurgeDeadLetters(ctx, "device_events")
+				require.NoError(t, err)
+				assert.Equal(t, 2, purged)
+				letters, err = admin.InspectDeadLetters(ctx, "device_events", 0)
+				require.NoError(t, err)
+				assert.Empty(t, letters)
+			})
+
+			t.Run("PubSubAdapter", func(t *testing.T) {
+				mq := backend.new(t, testQueueConfig("adapter"))
+				ps := &queuePubSub{queue: mq, exchange: "pandora.events"}
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				received := make(chan string, 1)
+				require.NoError(t, ps.Subscribe(ctx, "auth.events", func(topic string, message []byte) error {
+					received <- topic
+					return nil
+				}))
+				require.NoError(t, ps.Publish(ctx, "auth.events", &Message{ID: "1", Body: []byte("login")}))
+				select {
+				case topic := <-received:
+					assert.Equal(t, "auth.events", topic)
+				case <-time.After(2 * time.Second):
+					t.Fatal("未收到消息")
+				}
+			})
+		})
+	}
+}
+
+func TestRedisStreamsReclaimsFromStoppedConsumer(t *testing.T) {
+	server := miniredis.RunT(t)
+	newQueue := func() *RedisStreams {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		mq, err := NewRedisStreamsWithClient(client, testQueueConfig("reclaim"))
+		require.NoError(t, err)
+		t.Cleanup(func() { mq.Close() })
+		return mq
+	}
+
+	// 第一個消費者讀取後未確認即停止
+	stopped := newQueue()
+	ctx := context.Background()
+	require.NoError(t, stopped.client.XGroupCreateMkStream(ctx, stopped.streamKey("threat_events"), "threat_events", "0").Err())
+	require.NoError(t, stopped.Publish(ctx, "pandora.events", "threat.detected", []byte("orphan")))
+	read, err := stopped.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    "threat_events",
+		Consumer: stopped.consumer,
+		Streams:  []string{stopped.streamKey("threat_events"), ">"},
+	}).Result()
+	require.NoError(t, err)
+	require.Len(t, read[0].Messages, 1)
+	stopped.Close()
+
+	received := make(chan string, 1)
+	active := newQueue()
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, active.Subscribe(subCtx, "threat_events", func(topic string, message []byte) error {
+		received <- string(message)
+		return nil
+	}))
+
+	select {
+	case body := <-received:
+		assert.Equal(t, "orphan", body)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "閒置超過 RedisClaimIdle 才接手")
+	case <-time.After(3 * time.Second):
+		t.Fatal("未接手停止消費者的 pending 消息")
+	}
+
+	require.Eventually(t, func() bool {
+		pending, err := active.client.XPending(ctx, active.streamKey("threat_events"), "threat_events").Result()
+		return err == nil && pending.Count == 0
+	}, time.Second, 10*time.Millisecond, "處理完成後確認")
+}
+
+// TestMemoryQueueBounded 記憶體隊列不為未訂閱的預設隊列緩衝消息，發布不阻塞，死信有上限
+func TestMemoryQueueBounded(t *testing.T) {
+	mq := NewMemoryQueue(&Config{Exchange: "pandora.events", BufferSize: 2})
+	t.Cleanup(func() { mq.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// 預設綁定在訂閱前不接收消息
+	for i := 0; i < 5; i++ {
+		require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.detected", []byte("unconsumed")))
+	}
+	assert.Empty(t, mq.queues, "未訂閱的預設隊列不建立")
+
+	// 訂閱後綁定；處理函數停住時隊列填滿，發布立即返回 ErrQueueFull
+	release := make(chan struct{})
+	require.NoError(t, mq.Subscribe(ctx, "threat_events", func(topic string, message []byte) error {
+		<-release
+		return nil
+	}))
+	var err error
+	for i := 0; i < 5 && err == nil; i++ {
+		err = mq.Publish(ctx, "pandora.events", "threat.detected", []byte("queued"))
+	}
+	assert.ErrorIs(t, err, ErrQueueFull)
+	close(release)
+
+	// 死信只保留最新的 maxDead 筆
+	mq.maxDead = 2
+	opts := DefaultSubscribeOptions()
+	opts.RetryPolicy = &RetryPolicy{MaxRetries: 0}
+	require.NoError(t, mq.SubscribeWithOptions(ctx, "device_events", func(topic string, message []byte) error {
+		return errors.New("failed")
+	}, opts))
+	for _, body := range []string{"1", "2", "3"} {
+		require.NoError(t, mq.Publish(ctx, "pandora.events", "device.status", []byte(body)))
+		require.Eventually(t, func() bool {
+			letters, _ := mq.InspectDeadLetters(ctx, "device_events", 0)
+			return len(letters) > 0 && string(letters[len(letters)-1].Body) == body
+		}, time.Second, 5*time.Millisecond)
+	}
+	letters, err := mq.InspectDeadLetters(ctx, "device_events", 0)
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	assert.Equal(t, "2", string(letters[0].Body))
+}
+
+func TestNewMessageQueue(t *testing.T) {
+	mq, err := NewMessageQueue(&Config{Type: "memory", Exchange: "pandora.events"})
+	require.NoError(t, err)
+	assert.IsType(t, &MemoryQueue{}, mq)
+	assert.NoError(t, mq.Health(context.Background()))
+	require.NoError(t, mq.Close())
+	assert.Error(t, mq.Health(context.Background()))
+	assert.Error(t, mq.Publish(context.Background(), "pandora.events", "threat.detected", nil))
+
+	server := miniredis.RunT(t)
+	mq, err = NewMessageQueue(&Config{Type: "redis", RedisAddr: server.Addr(), Exchange: "pandora.events"})
+	require.NoError(t, err)
+	assert.IsType(t, &RedisStreams{}, mq)
+	assert.NoError(t, mq.Health(context.Background()))
+	require.NoError(t, mq.Close())
+
+	_, err = NewMessageQueue(&Config{Type: "kafka"})
+	assert.Error(t, err)
+
+	ps, err := NewPubSub(&Config{Type: "memory", Exchange: "pandora.events"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, ps.Close())
+}
@@ -0,0 +1,300 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OutboxTable is the table shared by the writers (axiom-api) and OutboxRelay
+// 事件發件匣資料表名稱；axiom-api 的 model.OutboxEvent 使用相同的資料表
+const OutboxTable = "event_outbox"
+
+// OutboxRecord is an event waiting to be published, written in the same transaction as the data it describes
+// 發件匣記錄：與業務資料在同一個交易中寫入，由 OutboxRelay 發布
+type OutboxRecord struct {
+	ID          uint64     `gorm:"primaryKey;autoIncrement"`
+	EventID     string     `gorm:"size:128;uniqueIndex;not null"`
+	Exchange    string     `gorm:"size:255;not null"`
+	RoutingKey  string     `gorm:"size:255;not null"`
+	Payload     string     `gorm:"type:text;not null"`
+	Attempts    int        `gorm:"not null;default:0"`
+	LastError   string     `gorm:"type:text"`
+	CreatedAt   time.Time  `gorm:"not null;index"`
+	PublishedAt *time.Time `gorm:"index"`
+}
+
+// TableName returns the outbox table name
+func (OutboxRecord) TableName() string {
+	return OutboxTable
+}
+
+// EnqueueOutbox writes event to the outbox using tx, so it is committed or rolled back with the caller's changes.
+// The event must marshal to JSON with a non-empty "id" (BaseEvent.ID); an empty routingKey uses its "type".
+// 在呼叫者的交易中寫入發件匣；事件必須帶有 BaseEvent.ID，routingKey 為空時使用事件類型
+func EnqueueOutbox(tx *gorm.DB, exchange, routingKey string, event interface{}) error {
+	payload, err := ToJSON(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox event: %w", err)
+	}
+
+	var base struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(payload, &base); err != nil {
+		return fmt.Errorf("outbox event is not a JSON object: %w", err)
+	}
+	if base.ID == "" {
+		return fmt.Errorf("outbox event has no id")
+	}
+	if routingKey == "" {
+		routingKey = base.Type
+	}
+	if routingKey == "" {
+		return fmt.Errorf("outbox event %s has no routing key", base.ID)
+	}
+
+	record := &OutboxRecord{
+		EventID:    base.ID,
+		Exchange:   exchange,
+		RoutingKey: routingKey,
+		Payload:    string(payload),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return fmt.Errorf("failed to write outbox event: %w", err)
+	}
+	return nil
+}
+
+// OutboxRelayConfig controls how often and how much the relay publishes
+// 發件匣轉發設定
+type OutboxRelayConfig struct {
+	// PollInterval is the delay between polls when the outbox is drained
+	PollInterval time.Duration
+
+	// BatchSize is the maximum number of events published per transaction
+	BatchSize int
+
+	// Retention is how long published rows are kept before deletion (0 = keep)
+	Retention time.Duration
+}
+
+// DefaultOutboxRelayConfig returns the default relay configuration
+// 返回預設的發件匣轉發設定
+func DefaultOutboxRelayConfig() *OutboxRelayConfig {
+	return &OutboxRelayConfig{
+		PollInterval: time.Second,
+		BatchSize:    100,
+		Retention:    24 * time.Hour,
+	}
+}
+
+// OutboxStats describes the events still waiting in the outbox
+// 發件匣積壓狀態
+type OutboxStats struct {
+	Pending int64         `json:"pending"`
+	Lag     time.Duration `json:"lag"`
+}
+
+// OutboxRelay publishes outbox rows to a MessageQueue in insertion order with at-least-once delivery:
+// a crash after publishing but before marking a row re-publishes it, so consumers should
+// deduplicate by BaseEvent.ID (see IdempotentHandler)
+// 發件匣轉發器：依寫入順序發布事件，保證至少一次送達；
+// 發布後、標記前當機會重複發布，消費端應以 BaseEvent.ID 去重
+type OutboxRelay struct {
+	db     *gorm.DB
+	mq     MessageQueue
+	config *OutboxRelayConfig
+
+	pendingGauge   prometheus.Gauge
+	lagGauge       prometheus.Gauge
+	publishedTotal prometheus.Counter
+	errorsTotal    prometheus.Counter
+}
+
+// NewOutboxRelay creates a relay and migrates the outbox table
+// 創建發件匣轉發器並自動遷移資料表
+func NewOutboxRelay(db *gorm.DB, mq MessageQueue, config *OutboxRelayConfig) (*OutboxRelay, error) {
+	if db == nil || mq == nil {
+		return nil, fmt.Errorf("outbox relay requires a database and a message queue")
+	}
+	if config == nil {
+		config = DefaultOutboxRelayConfig()
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if err := db.AutoMigrate(&OutboxRecord{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate outbox table: %w", err)
+	}
+
+	return &OutboxRelay{
+		db:     db,
+		mq:     mq,
+		config: config,
+		pendingGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "pandora_outbox_pending_events",
+			Help: "Number of outbox events not yet published",
+		}),
+		lagGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "pandora_outbox_lag_seconds",
+			Help: "Age of the oldest unpublished outbox event",
+		}),
+		publishedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pandora_outbox_published_total",
+			Help: "Total number of outbox events published",
+		}),
+		errorsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pandora_outbox_publish_errors_total",
+			Help: "Total number of failed outbox publish attempts",
+		}),
+	}, nil
+}
+
+// Describe implements prometheus.Collector
+func (r *OutboxRelay) Describe(ch chan<- *prometheus.Desc) {
+	r.pendingGauge.Describe(ch)
+	r.lagGauge.Describe(ch)
+	r.publishedTotal.Describe(ch)
+	r.errorsTotal.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (r *OutboxRelay) Collect(ch chan<- prometheus.Metric) {
+	r.pendingGauge.Collect(ch)
+	r.lagGauge.Collect(ch)
+	r.publishedTotal.Collect(ch)
+	r.errorsTotal.Collect(ch)
+}
+
+// Run relays events until ctx is canceled
+// 持續轉發直到 ctx 取消；發件匣清空後才等待下一次輪詢
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			published, err := r.RelayOnce(ctx)
+			if err != nil {
+				log.Printf("[Outbox] Relay failed: %v", err)
+				break
+			}
+			if published < r.config.BatchSize {
+				break
+			}
+		}
+		if _, err := r.Stats(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("[Outbox] Failed to read outbox stats: %v", err)
+		}
+		if r.config.Retention > 0 {
+			if err := r.Cleanup(ctx, time.Now().Add(-r.config.Retention)); err != nil && ctx.Err() == nil {
+				log.Printf("[Outbox] Cleanup failed: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce publishes up to BatchSize pending events in order and returns how many were published.
+// Rows are locked with FOR UPDATE SKIP LOCKED on PostgreSQL so several relays can run concurrently;
+// the batch stops at the first publish failure to preserve ordering
+// 發布一批待送事件；PostgreSQL 上以 SKIP LOCKED 鎖定，允許多個轉發器同時運行，
+// 遇到發布失敗即停止本批次以維持順序
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	published := 0
+	var publishErr error
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("published_at IS NULL").Order("id").Limit(r.config.BatchSize)
+		if tx.Dialector.Name() == "postgres" {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+		var records []OutboxRecord
+		if err := query.Find(&records).Error; err != nil {
+			return fmt.Errorf("failed to load outbox events: %w", err)
+		}
+
+		for i := range records {
+			record := &records[i]
+			if err := r.mq.Publish(ctx, record.Exchange, record.RoutingKey, []byte(record.Payload)); err != nil {
+				r.errorsTotal.Inc()
+				if IsValidationError(err) {
+					// 無效事件已送往隔離隊列，重試也不會成功
+					log.Printf("[Outbox] Event %s quarantined: %v", record.EventID, err)
+					if err := tx.Model(record).Updates(map[string]interface{}{
+						"published_at": time.Now().UTC(),
+						"last_error":   err.Error(),
+					}).Error; err != nil {
+						return fmt.Errorf("failed to mark outbox event %s: %w", record.EventID, err)
+					}
+					continue
+				}
+				publishErr = fmt.Errorf("failed to publish outbox event %s: %w", record.EventID, err)
+				return tx.Model(record).Updates(map[string]interface{}{
+					"attempts":   gorm.Expr("attempts + 1"),
+					"last_error": err.Error(),
+				}).Error
+			}
+			if err := tx.Model(record).Update("published_at", time.Now().UTC()).Error; err != nil {
+				return fmt.Errorf("failed to mark outbox event %s: %w", record.EventID, err)
+			}
+			published++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	r.publishedTotal.Add(float64(published))
+	return published, publishErr
+}
+
+// Stats returns the number of pending events and the age of the oldest one, and updates the lag metrics
+// 返回待發布事件數量與最舊事件的延遲，並更新監控指標
+func (r *OutboxRelay) Stats(ctx context.Context) (OutboxStats, error) {
+	var stats OutboxStats
+	db := r.db.WithContext(ctx)
+
+	if err := db.Model(&OutboxRecord{}).Where("published_at IS NULL").Count(&stats.Pending).Error; err != nil {
+		return stats, fmt.Errorf("failed to count outbox events: %w", err)
+	}
+	if stats.Pending > 0 {
+		var oldest OutboxRecord
+		if err := db.Where("published_at IS NULL").Order("id").Limit(1).Find(&oldest).Error; err != nil {
+			return stats, fmt.Errorf("failed to load oldest outbox event: %w", err)
+		}
+		if !oldest.CreatedAt.IsZero() {
+			stats.Lag = time.Since(oldest.CreatedAt)
+		}
+	}
+
+	r.pendingGauge.Set(float64(stats.Pending))
+	r.lagGauge.Set(stats.Lag.Seconds())
+	return stats, nil
+}
+
+// Cleanup deletes events published before the given time
+// 刪除指定時間前已發布的事件
+func (r *OutboxRelay) Cleanup(ctx context.Context, before time.Time) error {
+	return r.db.WithContext(ctx).
+		Where("published_at IS NOT NULL AND published_at < ?", before.UTC()).
+		Delete(&OutboxRecord{}).Error
+}
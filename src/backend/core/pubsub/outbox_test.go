@@ -0,0 +1,328 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// recordingQueue 記錄發布的消息，fail 回傳非 nil 時發布失敗
+type recordingQueue struct {
+	mu        sync.Mutex
+	published []*Message
+	fail      func(routingKey string) error
+}
+
+func (q *recordingQueue) Publish(ctx context.Context, exchange, routingKey string, message []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.fail != nil {
+		if err := q.fail(routingKey); err != nil {
+			return err
+		}
+	}
+	q.published = append(q.published, &Message{RoutingKey: routingKey, Body: message})
+	return nil
+}
+
+func (q *recordingQueue) Subscribe(ctx context.Context, queue string, handler MessageHandler) error {
+	return nil
+}
+
+func (q *recordingQueue) Close() error { return nil }
+
+func (q *recordingQueue) Health(ctx context.Context) error { return nil }
+
+func (q *recordingQueue) routingKeys() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	keys := make([]string, 0, len(q.published))
+	for _, msg := range q.published {
+		keys = append(keys, msg.RoutingKey)
+	}
+	return keys
+}
+
+func newOutboxTestDB(t *testing.T) *gorm.DB {
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func enqueueSystemEvent(t *testing.T, db *gorm.DB, status string) *SystemEvent {
+	event := NewSystemEvent("axiom-api", status, "outbox test")
+	event.ID = fmt.Sprintf("evt_%s_%d", status, time.Now().UnixNano())
+	event.Type = EventType("system." + status)
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		return EnqueueOutbox(tx, "pandora.events", "", event)
+	}))
+	return event
+}
+
+func TestEnqueueOutboxFollowsTransaction(t *testing.T) {
+	db := newOutboxTestDB(t)
+	require.NoError(t, db.AutoMigrate(&OutboxRecord{}))
+
+	event := NewSystemEvent("axiom-api", "started", "committed")
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		return EnqueueOutbox(tx, "pandora.events", "", event)
+	}))
+
+	rolledBack := NewSystemEvent("axiom-api", "stopped", "rolled back")
+	rolledBack.ID = "evt_rolled_back"
+	err := db.Transaction(func(tx *gorm.DB) error {
+		require.NoError(t, EnqueueOutbox(tx, "pandora.events", "", rolledBack))
+		return errors.New("業務寫入失敗")
+	})
+	require.Error(t, err)
+
+	var records []OutboxRecord
+	require.NoError(t, db.Find(&records).Error)
+	require.Len(t, records, 1)
+	assert.Equal(t, event.ID, records[0].EventID)
+	assert.Equal(t, "system.started", records[0].RoutingKey)
+	assert.Nil(t, records[0].PublishedAt)
+
+	// 同一事件 ID 不能寫入兩次
+	assert.Error(t, db.Transaction(func(tx *gorm.DB) error {
+		return EnqueueOutbox(tx, "pandora.events", "", event)
+	}))
+	assert.Error(t, EnqueueOutbox(db, "pandora.events", "system.error", map[string]string{"type": "system.error"}))
+}
+
+func TestOutboxRelayPublishesInOrder(t *testing.T) {
+	db := newOutboxTestDB(t)
+	mq := &recordingQueue{}
+	relay, err := NewOutboxRelay(db, mq, &OutboxRelayConfig{BatchSize: 2})
+	require.NoError(t, err)
+
+	var events []*SystemEvent
+	for _, status := range []string{"started", "error", "stopped"} {
+		events = append(events, enqueueSystemEvent(t, db, status))
+	}
+
+	stats, err := relay.Stats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Pending)
+	assert.Equal(t, float64(3), testutil.ToFloat64(relay.pendingGauge))
+
+	published, err := relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, published)
+	published, err = relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+
+	assert.Equal(t, []string{"system.started", "system.error", "system.stopped"}, mq.routingKeys())
+	var decoded SystemEvent
+	require.NoError(t, FromJSON(mq.published[0].Body, &decoded))
+	assert.Equal(t, events[0].ID, decoded.ID)
+
+	stats, err = relay.Stats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Pending)
+	assert.Equal(t, time.Duration(0), stats.Lag)
+	assert.Equal(t, float64(3), testutil.ToFloat64(relay.publishedTotal))
+
+	// 已發布的事件依保留時間清除
+	require.NoError(t, relay.Cleanup(context.Background(), time.Now().Add(time.Minute)))
+	var count int64
+	require.NoError(t, db.Model(&OutboxRecord{}).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestOutboxRelayStopsAtPublishFailure(t *testing.T) {
+	db := newOutboxTestDB(t)
+	failing := true
+	mq := &recordingQueue{fail: func(routingKey string) error {
+		if failing && routingKey == "system.error" {
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}}
+	relay, err := NewOutboxRelay(db, mq, nil)
+	require.NoError(t, err)
+
+	enqueueSystemEvent(t, db, "started")
+	failed := enqueueSystemEvent(t, db, "error")
+	enqueueSystemEvent(t, db, "stopped")
+
+	published, err := relay.RelayOnce(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, []string{"system.started"}, mq.routingKeys())
+	assert.Equal(t, float64(1), testutil.ToFloat64(relay.errorsTotal))
+
+	var record OutboxRecord
+	require.NoError(t, db.Where("event_id = ?", failed.ID).First(&record).Error)
+	assert.Equal(t, 1, record.Attempts)
+	assert.Equal(t, "broker unavailable", record.LastError)
+	assert.Nil(t, record.PublishedAt)
+
+	stats, err := relay.Stats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Pending)
+	assert.Greater(t, stats.Lag, time.Duration(0))
+
+	// 恢復後從失敗的事件繼續，順序不變
+	failing = false
+	published, err = relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, published)
+	assert.Equal(t, []string{"system.started", "system.error", "system.stopped"}, mq.routingKeys())
+}
+
+func TestOutboxRelayRun(t *testing.T) {
+	db := newOutboxTestDB(t)
+	mq := &recordingQueue{}
+	relay, err := NewOutboxRelay(db, mq, &OutboxRelayConfig{PollInterval: 10 * time.Millisecond, BatchSize: 1})
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(relay))
+
+	enqueueSystemEvent(t, db, "started")
+	enqueueSystemEvent(t, db, "stopped")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return len(mq.routingKeys()) == 2 }, 2*time.Second, 10*time.Millisecond)
+	enqueueSystemEvent(t, db, "error")
+	require.Eventually(t, func() bool { return len(mq.routingKeys()) == 3 }, 2*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	count, err := testutil.GatherAndCount(registry, "pandora_outbox_pending_events", "pandora_outbox_lag_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestIdempotentHandler(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	stores := map[string]IdempotencyStore{
+		"memory": NewMemoryIdempotencyStore(time.Minute),
+		"redis":  NewRedisIdempotencyStore(client, "test:processed", time.Minute),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			recorder := &callRecorder{}
+			handler := IdempotentHandler(store, recorder.handle)
+
+			event, err := ToJSON(NewSystemEvent("axiom-api", "started", name))
+			require.NoError(t, err)
+			require.NoError(t, handler("system.healthy", event))
+			require.NoError(t, handler("system.healthy", event))
+			assert.Equal(t, 1, recorder.count())
+
+			// 沒有 ID 的消息不去重
+			require.NoError(t, handler("system.healthy", []byte(`{"type":"system.healthy"}`)))
+			require.NoError(t, handler("system.healthy", []byte(`not json`)))
+			assert.Equal(t, 3, recorder.count())
+
+			// 處理失敗時釋放 ID，重試會再次處理
+			failed := NewSystemEvent("axiom-api", "error", name)
+			failed.ID = "evt_failed_" + name
+			body, err := ToJSON(failed)
+			require.NoError(t, err)
+			recorder.setFail(func(n int) error { return errors.New("暫時失敗") })
+			require.Error(t, handler("system.error", body))
+			recorder.setFail(nil)
+			require.NoError(t, handler("system.error", body))
+			require.NoError(t, handler("system.error", body))
+			assert.Equal(t, 5, recorder.count())
+		})
+	}
+}
+
+func TestMemoryIdempotencyStoreExpires(t *testing.T) {
+	store := NewMemoryIdempotencyStore(20 * time.Millisecond)
+	ctx := context.Background()
+
+	claimed, err := store.Claim(ctx, "evt_1")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	require.NoError(t, store.Complete(ctx, "evt_1"))
+	claimed, err = store.Claim(ctx, "evt_1")
+	require.NoError(t, err)
+	assert.False(t, claimed)
+
+	time.Sleep(30 * time.Millisecond)
+	claimed, err = store.Claim(ctx, "evt_1")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestIdempotentHandlerLease(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	memory := NewMemoryIdempotencyStore(time.Minute)
+	memory.SetLease(20 * time.Millisecond)
+	redisStore := NewRedisIdempotencyStore(client, "test:lease", time.Minute)
+	redisStore.SetLease(20 * time.Millisecond)
+
+	stores := map[string]struct {
+		store   IdempotencyStore
+		advance func()
+	}{
+		"memory": {memory, func() { time.Sleep(30 * time.Millisecond) }},
+		"redis":  {redisStore, func() { server.FastForward(30 * time.Millisecond) }},
+	}
+	for name, tc := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			event := NewSystemEvent("axiom-api", "started", name)
+			event.ID = "evt_lease_" + name
+			body, err := ToJSON(event)
+			require.NoError(t, err)
+
+			// 模擬消費者在處理中崩潰：只取得租約，未完成也未釋放
+			claimed, err := tc.store.Claim(ctx, event.ID)
+			require.NoError(t, err)
+			require.True(t, claimed)
+
+			// 租約期間重複的消息需重試，不可直接確認
+			recorder := &callRecorder{}
+			handler := IdempotentHandler(tc.store, recorder.handle)
+			err = handler("system.healthy", body)
+			require.ErrorIs(t, err, ErrEventInProgress)
+			assert.Equal(t, 0, recorder.count())
+
+			// 租約到期後重新投遞的消息會被處理，之後的重複消息才會略過
+			tc.advance()
+			require.NoError(t, handler("system.healthy", body))
+			tc.advance()
+			require.NoError(t, handler("system.healthy", body))
+			assert.Equal(t, 1, recorder.count())
+		})
+	}
+}
@@ -12,14 +12,43 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// amqpChannel is the subset of *amqp.Channel used by RabbitMQ
+// AMQP 通道操作，測試時以記憶體 broker 取代
+type amqpChannel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	QueuePurge(name string, noWait bool) (int, error)
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	Close() error
+}
+
+// amqpConfirmChannel is an amqpChannel that supports publisher confirms
+// 支援 publisher confirm 的通道
+type amqpConfirmChannel interface {
+	amqpChannel
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+}
+
 // RabbitMQ implements the MessageQueue interface using RabbitMQ
 // RabbitMQ 實現，提供可靠的消息隊列功能
 type RabbitMQ struct {
 	config *Config
 	conn   *amqp.Connection
-	ch     *amqp.Channel
+	ch     amqpChannel
 	mu     sync.RWMutex
 
+	// confirm publishes retries, dead letters and replays on its own confirm-mode channel
+	confirm *confirmPublisher
+
+	// adminMu serializes dead-letter administration
+	adminMu sync.Mutex
+
 	// reconnect control
 	reconnecting bool
 	closed       bool
@@ -86,8 +115,24 @@ func (mq *RabbitMQ) connect() error {
 		return fmt.Errorf("failed to declare exchange: %w", err)
 	}
 
+	// 重試與死信使用獨立的 confirm 通道，避免一般發布也必須等待確認
+	confirmCh, err := conn.Channel()
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		return fmt.Errorf("failed to open confirm channel: %w", err)
+	}
+	confirm, err := newConfirmPublisher(confirmCh)
+	if err != nil {
+		confirmCh.Close()
+		ch.Close()
+		conn.Close()
+		return err
+	}
+
 	mq.conn = conn
 	mq.ch = ch
+	mq.confirm = confirm
 
 	log.Printf("[RabbitMQ] Connected to %s", mq.config.URL)
 	return nil
@@ -158,6 +203,17 @@ func (mq *RabbitMQ) Publish(ctx context.Context, exchange, routingKey string, me
 	}
 
 	opts := DefaultPublishOptions()
+	publishing := amqp.Publishing{
+		ContentType:  opts.ContentType,
+		Body:         message,
+		DeliveryMode: amqp.Persistent,
+		Priority:     opts.Priority,
+		Timestamp:    time.Now(),
+		Headers:      amqp.Table(opts.Headers),
+	}
+	if err := EncodeAMQP(mq.config.CloudEventsMode, &publishing); err != nil {
+		return err
+	}
 
 	return mq.ch.PublishWithContext(
 		ctx,
@@ -165,20 +221,20 @@ func (mq *RabbitMQ) Publish(ctx context.Context, exchange, routingKey string, me
 		routingKey,
 		false, // mandatory
 		false, // immediate
-		amqp.Publishing{
-			ContentType:  opts.ContentType,
-			Body:         message,
-			DeliveryMode: amqp.Persistent,
-			Priority:     opts.Priority,
-			Timestamp:    time.Now(),
-			Headers:      amqp.Table(opts.Headers),
-		},
+		publishing,
 	)
 }
 
-// Subscribe listens to messages from the specified queue
-// 訂閱指定隊列的消息
+// Subscribe listens to messages from the specified queue using the default options
+// 訂閱指定隊列的消息 (預設選項：失敗時以指數退避重試 3 次，之後送往死信隊列)
 func (mq *RabbitMQ) Subscribe(ctx context.Context, queue string, handler MessageHandler) error {
+	return mq.SubscribeWithOptions(ctx, queue, handler, DefaultSubscribeOptions())
+}
+
+// SubscribeWithOptions listens to messages from the specified queue
+// 訂閱指定隊列的消息；設定 RetryPolicy 時，失敗的消息經由各延遲的 TTL 隊列重試，
+// 超過 MaxRetries 或回傳 Permanent 錯誤時附上失敗標頭送往死信交換機
+func (mq *RabbitMQ) SubscribeWithOptions(ctx context.Context, queue string, handler MessageHandler, opts *SubscribeOptions) error {
 	mq.mu.RLock()
 	ch := mq.ch
 	mq.mu.RUnlock()
@@ -187,7 +243,14 @@ func (mq *RabbitMQ) Subscribe(ctx context.Context, queue string, handler Message
 		return fmt.Errorf("channel is not initialized")
 	}
 
-	opts := DefaultSubscribeOptions()
+	if opts == nil {
+		opts = DefaultSubscribeOptions()
+	}
+	if opts.RetryPolicy != nil && !opts.AutoAck {
+		if err := mq.declareRetryTopology(ch, queue, opts.RetryPolicy); err != nil {
+			return err
+		}
+	}
 
 	// Set QoS
 	err := ch.Qos(
@@ -226,30 +289,7 @@ func (mq *RabbitMQ) Subscribe(ctx context.Context, queue string, handler Message
 					return
 				}
 
-				message := &Message{
-					ID:          msg.MessageId,
-					RoutingKey:  msg.RoutingKey,
-					Body:        msg.Body,
-					Timestamp:   msg.Timestamp,
-					Headers:     make(map[string]interface{}),
-					DeliveryTag: msg.DeliveryTag,
-					Redelivered: msg.Redelivered,
-				}
-
-				// Convert AMQP headers to map
-				for k, v := range msg.Headers {
-					message.Headers[k] = v
-				}
-
-				// Handle message
-				if err := handler(msg.RoutingKey, message.Body); err != nil {
-					log.Printf("[RabbitMQ] Error handling message: %v", err)
-					// Nack the message for retry
-					msg.Nack(false, true)
-				} else {
-					// Ack the message
-					msg.Ack(false)
-				}
+				mq.handleDelivery(queue, opts, handler, msg)
 			}
 		}
 	}()
@@ -257,6 +297,58 @@ func (mq *RabbitMQ) Subscribe(ctx context.Context, queue string, handler Message
 	return nil
 }
 
+// handleDelivery runs the handler and acks, retries or dead-letters the delivery
+// 處理單一消息：成功時確認，失敗時依重試策略重試或送往死信隊列
+func (mq *RabbitMQ) handleDelivery(queue string, opts *SubscribeOptions, handler MessageHandler, msg amqp.Delivery) {
+	// 重試的消息經由預設交換機回到隊列，路由鍵以原始值為準
+	routingKey := msg.RoutingKey
+	if original, ok := msg.Headers[HeaderOriginalRoutingKey].(string); ok {
+		routingKey = original
+	}
+
+	// 接受舊格式與 CloudEvents，處理函數一律收到舊格式事件
+	body, err := DecodeEvent(msg.Body, msg.ContentType, msg.Headers)
+	if err != nil {
+		err = Permanent(fmt.Errorf("invalid CloudEvent: %w", err))
+	} else {
+		err = callHandler(handler, routingKey, body)
+	}
+	if opts.AutoAck {
+		if err != nil {
+			log.Printf("[RabbitMQ] Error handling message: %v", err)
+		}
+		return
+	}
+	if err == nil {
+		msg.Ack(false)
+		return
+	}
+
+	log.Printf("[RabbitMQ] Error handling message: %v", err)
+	if opts.RetryPolicy == nil {
+		// Nack the message for immediate redelivery
+		msg.Nack(false, true)
+		return
+	}
+	if pubErr := mq.retryOrDeadLetter(queue, opts.RetryPolicy, msg, err); pubErr != nil {
+		log.Printf("[RabbitMQ] Failed to schedule retry: %v", pubErr)
+		msg.Nack(false, true)
+		return
+	}
+	msg.Ack(false)
+}
+
+// callHandler runs the handler, turning a panic into a permanent error
+// 執行消息處理函數，panic 視為無法處理的毒消息
+func callHandler(handler MessageHandler, topic string, body []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = Permanent(fmt.Errorf("handler panic: %v", r))
+		}
+	}()
+	return handler(topic, body)
+}
+
 // Close gracefully shuts down the message queue connection
 // 優雅關閉連接
 func (mq *RabbitMQ) Close() error {
@@ -270,6 +362,11 @@ func (mq *RabbitMQ) Close() error {
 	mq.closed = true
 	close(mq.closeChan)
 
+	if mq.confirm != nil {
+		if err := mq.confirm.ch.Close(); err != nil {
+			log.Printf("[RabbitMQ] Error closing confirm channel: %v", err)
+		}
+	}
 	if mq.ch != nil {
 		if err := mq.ch.Close(); err != nil {
 			log.Printf("[RabbitMQ] Error closing channel: %v", err)
@@ -363,10 +460,69 @@ func NewPubSub(config *Config, logger *logrus.Logger) (PubSub, error) {
 			return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 		}
 		return &PubSubWrapper{RabbitMQ: rabbitmq}, nil
-	case "redis":
-		// TODO: Implement Redis pub/sub
-		return nil, fmt.Errorf("Redis pub/sub not implemented yet")
+	case "memory", "redis":
+		mq, err := NewMessageQueue(config)
+		if err != nil {
+			return nil, err
+		}
+		return &queuePubSub{queue: mq, exchange: config.Exchange}, nil
 	default:
 		return nil, fmt.Errorf("unsupported pub/sub type: %s", config.Type)
 	}
 }
+
+// NewMessageQueue creates a MessageQueue based on config.Type:
+// "rabbitmq" (default), "memory" (in-process) or "redis" (Redis Streams)
+// 根據配置創建消息隊列
+func NewMessageQueue(config *Config) (MessageQueue, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	switch config.Type {
+	case "", "rabbitmq":
+		return NewRabbitMQ(config)
+	case "memory":
+		return NewMemoryQueue(config), nil
+	case "redis":
+		return NewRedisStreams(config)
+	default:
+		return nil, fmt.Errorf("unsupported message queue type: %s", config.Type)
+	}
+}
+
+// queuePubSub adapts a MessageQueue to the PubSub interface; a topic is both the
+// routing key and the queue, bound to itself before subscribing
+// 將 MessageQueue 轉接為 PubSub 接口，topic 同時作為路由鍵與隊列名稱
+type queuePubSub struct {
+	queue    MessageQueue
+	exchange string
+}
+
+// Publish implements the PubSub interface
+func (p *queuePubSub) Publish(ctx context.Context, topic string, message *Message) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return p.queue.Publish(ctx, p.exchange, topic, data)
+}
+
+// Subscribe implements the PubSub interface
+func (p *queuePubSub) Subscribe(ctx context.Context, topic string, handler MessageHandler) error {
+	binding := Binding{Exchange: p.exchange, Queue: topic, RoutingKey: topic}
+	switch mq := p.queue.(type) {
+	case *MemoryQueue:
+		mq.Bind(binding)
+	case *RedisStreams:
+		if err := mq.Bind(ctx, binding); err != nil {
+			return err
+		}
+	}
+	return p.queue.Subscribe(ctx, topic, handler)
+}
+
+// Close implements the PubSub interface
+func (p *queuePubSub) Close() error {
+	return p.queue.Close()
+}
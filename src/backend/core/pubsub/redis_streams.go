@@ -0,0 +1,510 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis Streams defaults
+// Redis Streams 預設值
+const (
+	defaultStreamPrefix = "pandora:pubsub"
+	defaultClaimIdle    = 30 * time.Second
+	pendingBatchSize    = 100
+)
+
+// RedisStreams implements the MessageQueue interface on Redis Streams
+// Redis Streams 實現：每個隊列一個 stream 與同名的消費者群組，
+// 發布時依儲存在 Redis 的綁定 (topic 樣式) 寫入符合的隊列；
+// 失敗的消息留在 pending 清單，依 RetryPolicy 的退避時間以 XCLAIM 重新投遞，
+// 超過重試次數後寫入死信 stream。其他消費者停止時，其 pending 消息在 RedisClaimIdle 後被接手
+type RedisStreams struct {
+	config     *Config
+	client     *redis.Client
+	ownsClient bool
+	prefix     string
+	consumer   string
+	claimIdle  time.Duration
+
+	mu        sync.RWMutex
+	closed    bool
+	closeChan chan struct{}
+}
+
+// NewRedisStreams connects to config.RedisAddr and registers config.Bindings (default: DefaultBindings)
+// 創建 Redis Streams 消息隊列
+func NewRedisStreams(config *Config) (*RedisStreams, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:        config.RedisAddr,
+		Password:    config.RedisPassword,
+		DB:          config.RedisDB,
+		DialTimeout: config.ConnectionTimeout,
+	})
+	rs, err := NewRedisStreamsWithClient(client, config)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	rs.ownsClient = true
+	return rs, nil
+}
+
+// NewRedisStreamsWithClient uses an existing Redis client; the client is not closed by Close
+// 使用既有的 Redis 客戶端創建 Redis Streams 消息隊列
+func NewRedisStreamsWithClient(client *redis.Client, config *Config) (*RedisStreams, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	rs := &RedisStreams{
+		config:    config,
+		client:    client,
+		prefix:    config.RedisStreamPrefix,
+		consumer:  consumerName(),
+		claimIdle: config.RedisClaimIdle,
+		closeChan: make(chan struct{}),
+	}
+	if rs.prefix == "" {
+		rs.prefix = defaultStreamPrefix
+	}
+	if rs.claimIdle <= 0 {
+		rs.claimIdle = defaultClaimIdle
+	}
+
+	timeout := config.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	bindings := config.Bindings
+	if bindings == nil {
+		bindings = DefaultBindings(config.Exchange)
+	}
+	for _, binding := range bindings {
+		if err := rs.Bind(ctx, binding); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+// consumerName returns a consumer name unique to this process
+func consumerName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+func (rs *RedisStreams) bindingsKey() string { return rs.prefix + ":bindings" }
+
+func (rs *RedisStreams) streamKey(queue string) string { return rs.prefix + ":queue:" + queue }
+
+func (rs *RedisStreams) deadKey(queue string) string { return rs.prefix + ":dead:" + queue }
+
+// Bind stores the binding in Redis so every publisher routes matching messages to binding.Queue
+// 綁定隊列 (儲存在 Redis，所有發布者共用)
+func (rs *RedisStreams) Bind(ctx context.Context, binding Binding) error {
+	member, err := json.Marshal(binding)
+	if err != nil {
+		return fmt.Errorf("failed to marshal binding: %w", err)
+	}
+	if err := rs.client.SAdd(ctx, rs.bindingsKey(), member).Err(); err != nil {
+		return fmt.Errorf("failed to store binding: %w", err)
+	}
+	return nil
+}
+
+// Publish appends the message to the stream of every queue bound with a matching pattern
+// 發布消息到符合綁定的隊列 stream
+func (rs *RedisStreams) Publish(ctx context.Context, exchange, routingKey string, message []byte) error {
+	members, err := rs.client.SMembers(ctx, rs.bindingsKey()).Result()
+	if err != nil {
+		return fmt.Errorf("failed to load bindings: %w", err)
+	}
+
+	pipe := rs.client.Pipeline()
+	seen := make(map[string]bool)
+	for _, member := range members {
+		var binding Binding
+		if err := json.Unmarshal([]byte(member), &binding); err != nil {
+			continue
+		}
+		if binding.Exchange != exchange || seen[binding.Queue] || !MatchRoutingKey(binding.RoutingKey, routingKey) {
+			continue
+		}
+		seen[binding.Queue] = true
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: rs.streamKey(binding.Queue),
+			MaxLen: rs.config.RedisStreamMaxLen,
+			Approx: rs.config.RedisStreamMaxLen > 0,
+			Values: map[string]interface{}{
+				"exchange":    exchange,
+				"routing_key": routingKey,
+				"body":        message,
+			},
+		})
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to publish: %w", err)
+	}
+	return nil
+}
+
+// Subscribe consumes the queue with the default options
+// 訂閱指定隊列的消息 (預設選項)
+func (rs *RedisStreams) Subscribe(ctx context.Context, queue string, handler MessageHandler) error {
+	return rs.SubscribeWithOptions(ctx, queue, handler, DefaultSubscribeOptions())
+}
+
+// SubscribeWithOptions consumes the queue through its consumer group until ctx is canceled;
+// messages published before the group existed are delivered as well
+// 以消費者群組訂閱隊列；同一隊列的多個訂閱者 (跨進程) 競爭消費
+func (rs *RedisStreams) SubscribeWithOptions(ctx context.Context, queue string, handler MessageHandler, opts *SubscribeOptions) error {
+	rs.mu.RLock()
+	closed := rs.closed
+	rs.mu.RUnlock()
+	if closed {
+		return fmt.Errorf("message queue is closed")
+	}
+	if opts == nil {
+		opts = DefaultSubscribeOptions()
+	}
+
+	stream := rs.streamKey(queue)
+	if err := rs.client.XGroupCreateMkStream(ctx, stream, queue, "0").Err(); err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	sub := &redisSubscription{rs: rs, queue: queue, stream: stream, opts: opts, handler: handler}
+	go sub.run(ctx)
+	return nil
+}
+
+// redisSubscription is a consumer of one queue; messages are handled sequentially
+type redisSubscription struct {
+	rs      *RedisStreams
+	queue   string
+	stream  string
+	opts    *SubscribeOptions
+	handler MessageHandler
+}
+
+// retryInterval returns how often pending messages are checked for redelivery
+func (s *redisSubscription) retryInterval() time.Duration {
+	interval := 100 * time.Millisecond
+	if s.opts.RetryPolicy != nil {
+		interval = s.opts.RetryPolicy.InitialInterval / 2
+	}
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	if interval > time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// run reads new messages and redelivers due pending messages until ctx is canceled
+func (s *redisSubscription) run(ctx context.Context) {
+	interval := s.retryInterval()
+	count := int64(s.opts.PrefetchCount)
+	if count <= 0 {
+		count = 1
+	}
+	lastRetry := time.Time{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.rs.closeChan:
+			return
+		default:
+		}
+
+		if !s.opts.AutoAck && time.Since(lastRetry) >= interval {
+			s.redeliverPending(ctx)
+			lastRetry = time.Now()
+		}
+
+		streams, err := s.rs.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.queue,
+			Consumer: s.rs.consumer,
+			Streams:  []string{s.stream, ">"},
+			Count:    count,
+			Block:    interval,
+			NoAck:    s.opts.AutoAck,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil || s.rs.isClosed() {
+				return
+			}
+			log.Printf("[RedisStreams] Failed to read %s: %v", s.stream, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.rs.closeChan:
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				s.handle(ctx, msg, 1)
+			}
+		}
+	}
+}
+
+// redeliverPending claims pending messages whose backoff has elapsed; messages pending on
+// other consumers are only claimed after RedisClaimIdle, so in-flight messages are not duplicated
+// 重新投遞退避時間已到的 pending 消息；其他消費者的消息需閒置超過 RedisClaimIdle 才接手
+func (s *redisSubscription) redeliverPending(ctx context.Context) {
+	pending, err := s.rs.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: s.stream,
+		Group:  s.queue,
+		Start:  "-",
+		End:    "+",
+		Count:  pendingBatchSize,
+	}).Result()
+	if err != nil {
+		if ctx.Err() == nil && !s.rs.isClosed() {
+			log.Printf("[RedisStreams] Failed to list pending messages of %s: %v", s.stream, err)
+		}
+		return
+	}
+
+	for _, entry := range pending {
+		due := time.Duration(0)
+		if s.opts.RetryPolicy != nil {
+			due = s.opts.RetryPolicy.Delay(int(entry.RetryCount) - 1)
+		}
+		if entry.Consumer != s.rs.consumer && due < s.rs.claimIdle {
+			due = s.rs.claimIdle
+		}
+		if entry.Idle < due {
+			continue
+		}
+
+		msgs, err := s.rs.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   s.stream,
+			Group:    s.queue,
+			Consumer: s.rs.consumer,
+			MinIdle:  due,
+			Messages: []string{entry.ID},
+		}).Result()
+		if err != nil {
+			log.Printf("[RedisStreams] Failed to claim %s: %v", entry.ID, err)
+			continue
+		}
+		for _, msg := range msgs {
+			s.handle(ctx, msg, int(entry.RetryCount)+1)
+		}
+	}
+}
+
+// handle runs the handler for a delivery; deliveries counts this delivery
+func (s *redisSubscription) handle(ctx context.Context, msg redis.XMessage, deliveries int) {
+	routingKey, _ := msg.Values["routing_key"].(string)
+	body, _ := msg.Values["body"].(string)
+
+	decoded, err := DecodeEvent([]byte(body), "", nil)
+	if err != nil {
+		err = Permanent(fmt.Errorf("invalid CloudEvent: %w", err))
+	} else {
+		err = callHandler(s.handler, routingKey, decoded)
+	}
+	if s.opts.AutoAck {
+		if err != nil {
+			log.Printf("[RedisStreams] Error handling message: %v", err)
+		}
+		return
+	}
+	if err == nil {
+		s.rs.ack(ctx, s.stream, s.queue, msg.ID)
+		return
+	}
+
+	log.Printf("[RedisStreams] Error handling message: %v", err)
+	policy := s.opts.RetryPolicy
+	if policy == nil || (deliveries-1 < policy.MaxRetries && !IsPermanent(err)) {
+		// 留在 pending 清單，退避時間到後重新投遞
+		return
+	}
+
+	values := map[string]interface{}{
+		"queue":     s.queue,
+		"reason":    err.Error(),
+		"retries":   deliveries - 1,
+		"failed_at": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	_, txErr := s.rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: s.rs.deadKey(s.queue), Values: values})
+		pipe.XAck(ctx, s.stream, s.queue, msg.ID)
+		pipe.XDel(ctx, s.stream, msg.ID)
+		return nil
+	})
+	if txErr != nil {
+		log.Printf("[RedisStreams] Failed to dead-letter %s: %v", msg.ID, txErr)
+	}
+}
+
+// ack acknowledges and removes a handled message
+func (rs *RedisStreams) ack(ctx context.Context, stream, group, id string) {
+	_, err := rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, stream, group, id)
+		pipe.XDel(ctx, stream, id)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[RedisStreams] Failed to ack %s: %v", id, err)
+	}
+}
+
+// newRedisDeadLetter converts an entry of a dead-letter stream
+func newRedisDeadLetter(msg redis.XMessage) DeadLetter {
+	letter := DeadLetter{MessageID: msg.ID}
+	letter.Queue, _ = msg.Values["queue"].(string)
+	letter.Exchange, _ = msg.Values["exchange"].(string)
+	letter.RoutingKey, _ = msg.Values["routing_key"].(string)
+	letter.Reason, _ = msg.Values["reason"].(string)
+	if retries, ok := msg.Values["retries"].(string); ok {
+		letter.RetryCount, _ = strconv.Atoi(retries)
+	}
+	if failedAt, ok := msg.Values["failed_at"].(string); ok {
+		letter.FailedAt, _ = time.Parse(time.RFC3339Nano, failedAt)
+	}
+	if body, ok := msg.Values["body"].(string); ok {
+		letter.Body = []byte(body)
+	}
+	return letter
+}
+
+// InspectDeadLetters returns up to limit dead letters of queue without removing them
+// 查看死信
+func (rs *RedisStreams) InspectDeadLetters(ctx context.Context, queue string, limit int) ([]DeadLetter, error) {
+	if limit <= 0 {
+		limit = DefaultDeadLetterLimit
+	}
+	msgs, err := rs.client.XRangeN(ctx, rs.deadKey(queue), "-", "+", int64(limit)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letters: %w", err)
+	}
+
+	letters := make([]DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		letters = append(letters, newRedisDeadLetter(msg))
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetters re-publishes up to limit dead letters (0 = all) to their original exchange and routing key
+// 重新發布死信到原始交換機與路由鍵
+func (rs *RedisStreams) ReplayDeadLetters(ctx context.Context, queue string, limit int) (int, error) {
+	key := rs.deadKey(queue)
+	var msgs []redis.XMessage
+	var err error
+	if limit > 0 {
+		msgs, err = rs.client.XRangeN(ctx, key, "-", "+", int64(limit)).Result()
+	} else {
+		msgs, err = rs.client.XRange(ctx, key, "-", "+").Result()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read dead letters: %w", err)
+	}
+
+	for i, msg := range msgs {
+		letter := newRedisDeadLetter(msg)
+		if err := rs.Publish(ctx, letter.Exchange, letter.RoutingKey, letter.Body); err != nil {
+			return i, fmt.Errorf("failed to replay dead letter: %w", err)
+		}
+		if err := rs.client.XDel(ctx, key, msg.ID).Err(); err != nil {
+			return i + 1, fmt.Errorf("failed to remove replayed dead letter: %w", err)
+		}
+	}
+	return len(msgs), nil
+}
+
+// PurgeDeadLetters removes all dead letters of queue
+// 清空死信
+func (rs *RedisStreams) PurgeDeadLetters(ctx context.Context, queue string) (int, error) {
+	key := rs.deadKey(queue)
+	var count *redis.IntCmd
+	_, err := rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.XLen(ctx, key)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge dead letters: %w", err)
+	}
+	return int(count.Val()), nil
+}
+
+// isClosed reports whether Close was called
+func (rs *RedisStreams) isClosed() bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	return rs.closed
+}
+
+// Close stops all subscribers and closes the Redis client if it was created by NewRedisStreams
+// 關閉連接並停止所有訂閱者
+func (rs *RedisStreams) Close() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.closed {
+		return nil
+	}
+	rs.closed = true
+	close(rs.closeChan)
+	if rs.ownsClient {
+		return rs.client.Close()
+	}
+	return nil
+}
+
+// Health pings Redis
+// 健康檢查
+func (rs *RedisStreams) Health(ctx context.Context) error {
+	if rs.isClosed() {
+		return fmt.Errorf("message queue is closed")
+	}
+	return rs.client.Ping(ctx).Err()
+}
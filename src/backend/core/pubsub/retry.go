@@ -0,0 +1,260 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers added to retried and dead-lettered messages
+// 重試與死信消息附帶的標頭
+const (
+	HeaderRetryCount         = "x-retry-count"
+	HeaderOriginalExchange   = "x-original-exchange"
+	HeaderOriginalRoutingKey = "x-original-routing-key"
+	HeaderSourceQueue        = "x-source-queue"
+	HeaderFailureReason      = "x-failure-reason"
+	HeaderFailedAt           = "x-failed-at"
+)
+
+// PermanentError marks a handler failure that must not be retried (poison message)
+// 永久性錯誤，消息不重試直接送往死信隊列
+type PermanentError struct {
+	Err error
+}
+
+// Error implements the error interface
+func (e *PermanentError) Error() string {
+	return fmt.Sprintf("permanent failure: %v", e.Err)
+}
+
+// Unwrap returns the wrapped error
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err so the message goes straight to the dead-letter queue
+// 包裝錯誤，讓消息跳過重試直接進入死信隊列
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err was wrapped with Permanent
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// Delay returns the backoff before the given retry attempt (0-based)
+// 計算第 attempt 次重試前的等待時間 (指數退避，不超過 MaxInterval)
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := time.Duration(float64(p.InitialInterval) * math.Pow(multiplier, float64(attempt)))
+	if p.MaxInterval > 0 && (delay > p.MaxInterval || delay <= 0) {
+		delay = p.MaxInterval
+	}
+	if delay < time.Millisecond {
+		delay = time.Millisecond
+	}
+	return delay
+}
+
+// retryQueueName returns the TTL queue used for the given delay
+// 每種延遲使用獨立的 TTL 隊列，避免短延遲消息被長延遲消息阻塞
+func retryQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%d", queue, delay.Milliseconds())
+}
+
+// DeadLetterQueueName returns the dead-letter queue of the given queue
+// 取得隊列對應的死信隊列名稱
+func DeadLetterQueueName(queue string) string {
+	return queue + ".dead"
+}
+
+// deadLetterExchangeName returns the dead-letter exchange of the given exchange
+func deadLetterExchangeName(exchange string) string {
+	return exchange + ".dlx"
+}
+
+// declareRetryTopology declares the TTL retry queues and the dead-letter exchange/queue for queue
+// 宣告重試用的 TTL 隊列與死信交換機/隊列：
+// 重試隊列過期後經預設交換機回到原隊列，超過重試次數的消息發布到 DLX
+func (mq *RabbitMQ) declareRetryTopology(ch amqpChannel, queue string, policy *RetryPolicy) error {
+	dlx := deadLetterExchangeName(mq.config.Exchange)
+	if err := ch.ExchangeDeclare(dlx, "direct", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead-letter exchange: %w", err)
+	}
+	dead := DeadLetterQueueName(queue)
+	if _, err := ch.QueueDeclare(dead, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead-letter queue: %w", err)
+	}
+	if err := ch.QueueBind(dead, queue, dlx, false, nil); err != nil {
+		return fmt.Errorf("failed to bind dead-letter queue: %w", err)
+	}
+
+	declared := make(map[string]bool)
+	for attempt := 0; attempt < policy.MaxRetries; attempt++ {
+		delay := policy.Delay(attempt)
+		name := retryQueueName(queue, delay)
+		if declared[name] {
+			continue
+		}
+		declared[name] = true
+
+		_, err := ch.QueueDeclare(name, true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to declare retry queue %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// confirmPublisher publishes on a channel in publisher confirm mode and waits for the broker
+// to confirm each message, so that a delivery is acked only after its copy is safely stored
+// 以 publisher confirm 模式發布重試、死信與重播的消息，broker 確認後才確認原消息
+type confirmPublisher struct {
+	ch       amqpConfirmChannel
+	confirms chan amqp.Confirmation
+	tag      uint64 // 最後一次發布的 delivery tag
+	mu       sync.Mutex
+}
+
+// newConfirmPublisher puts ch into confirm mode
+func newConfirmPublisher(ch amqpConfirmChannel) (*confirmPublisher, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	return &confirmPublisher{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 16)),
+	}, nil
+}
+
+// publish publishes msg and blocks until the broker acks it, nacks it or ctx is done
+func (p *confirmPublisher) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ch.PublishWithContext(ctx, exchange, key, false, false, msg); err != nil {
+		return err
+	}
+	p.tag++
+	for {
+		select {
+		case confirm, ok := <-p.confirms:
+			if !ok {
+				return fmt.Errorf("channel closed before the broker confirmed the message")
+			}
+			if confirm.DeliveryTag < p.tag {
+				// 先前等待逾時的消息的確認
+				continue
+			}
+			if !confirm.Ack {
+				return fmt.Errorf("broker rejected message to %q with routing key %q", exchange, key)
+			}
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for publisher confirm: %w", ctx.Err())
+		}
+	}
+}
+
+// confirmed returns the publisher used for retries, dead letters and replays
+func (mq *RabbitMQ) confirmed() (*confirmPublisher, error) {
+	mq.mu.RLock()
+	defer mq.mu.RUnlock()
+
+	if mq.confirm == nil {
+		return nil, fmt.Errorf("confirm channel is not initialized")
+	}
+	return mq.confirm, nil
+}
+
+// retryOrDeadLetter re-publishes a failed delivery to the retry queue for its attempt,
+// or to the dead-letter exchange once MaxRetries is reached or the error is permanent.
+// It returns only after the broker confirmed the new copy
+// 處理失敗的消息：依重試次數送往對應的 TTL 隊列，超過次數或永久性錯誤則送往死信交換機；
+// broker 確認收到後才返回，呼叫端收到錯誤時不可確認原消息
+func (mq *RabbitMQ) retryOrDeadLetter(queue string, policy *RetryPolicy, msg amqp.Delivery, handlerErr error) error {
+	publisher, err := mq.confirmed()
+	if err != nil {
+		return err
+	}
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	if _, ok := headers[HeaderOriginalRoutingKey]; !ok {
+		headers[HeaderOriginalExchange] = msg.Exchange
+		headers[HeaderOriginalRoutingKey] = msg.RoutingKey
+	}
+	retries := headerInt(headers[HeaderRetryCount])
+
+	publishing := amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Priority:     msg.Priority,
+		MessageId:    msg.MessageId,
+		Timestamp:    msg.Timestamp,
+		Body:         msg.Body,
+	}
+
+	// 消息處理可能已超過 ctx 期限，重新發布使用獨立的逾時
+	timeout := mq.config.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if retries < policy.MaxRetries && !IsPermanent(handlerErr) {
+		headers[HeaderRetryCount] = int32(retries + 1)
+		return publisher.publish(ctx, "", retryQueueName(queue, policy.Delay(retries)), publishing)
+	}
+
+	headers[HeaderSourceQueue] = queue
+	headers[HeaderFailureReason] = handlerErr.Error()
+	headers[HeaderFailedAt] = time.Now().UTC()
+	return publisher.publish(ctx, deadLetterExchangeName(mq.config.Exchange), queue, publishing)
+}
+
+// headerInt converts an AMQP header value to int
+func headerInt(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint8:
+		return int(v)
+	case uint16:
+		return int(v)
+	case uint32:
+		return int(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,391 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedCall 消息處理函數收到的呼叫
+type recordedCall struct {
+	topic string
+	body  string
+	at    time.Time
+}
+
+// callRecorder 記錄呼叫並依 fail 決定處理結果
+type callRecorder struct {
+	mu    sync.Mutex
+	calls []recordedCall
+	fail  func(n int) error
+}
+
+func (r *callRecorder) handle(topic string, message []byte) error {
+	r.mu.Lock()
+	r.calls = append(r.calls, recordedCall{topic: topic, body: string(message), at: time.Now()})
+	n := len(r.calls)
+	fail := r.fail
+	r.mu.Unlock()
+
+	if fail == nil {
+		return nil
+	}
+	return fail(n)
+}
+
+func (r *callRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.calls)
+}
+
+func (r *callRecorder) snapshot() []recordedCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]recordedCall(nil), r.calls...)
+}
+
+func (r *callRecorder) setFail(fail func(n int) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fail = fail
+}
+
+// setupRetryQueue 宣告 pandora.events 與綁定 threat.* 的隊列
+func setupRetryQueue(t *testing.T, broker *fakeBroker, queue string) {
+	t.Helper()
+
+	require.NoError(t, broker.ExchangeDeclare("pandora.events", "topic", true, false, false, false, nil))
+	_, err := broker.QueueDeclare(queue, true, false, false, false, nil)
+	require.NoError(t, err)
+	require.NoError(t, broker.QueueBind(queue, "threat.*", "pandora.events", false, nil))
+}
+
+func testRetryOptions(maxRetries int) *SubscribeOptions {
+	opts := DefaultSubscribeOptions()
+	opts.RetryPolicy = &RetryPolicy{
+		MaxRetries:      maxRetries,
+		InitialInterval: 20 * time.Millisecond,
+		MaxInterval:     60 * time.Millisecond,
+		Multiplier:      2,
+	}
+	return opts
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := &RetryPolicy{MaxRetries: 5, InitialInterval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2}
+	assert.Equal(t, time.Second, policy.Delay(0))
+	assert.Equal(t, 2*time.Second, policy.Delay(1))
+	assert.Equal(t, 4*time.Second, policy.Delay(2))
+	assert.Equal(t, 5*time.Second, policy.Delay(3), "不超過 MaxInterval")
+	assert.Equal(t, 5*time.Second, policy.Delay(100))
+
+	constant := &RetryPolicy{InitialInterval: 100 * time.Millisecond, Multiplier: 0}
+	assert.Equal(t, 100*time.Millisecond, constant.Delay(3), "Multiplier 小於 1 時固定間隔")
+}
+
+func TestSubscribeRetriesWithBackoff(t *testing.T) {
+	broker := newFakeBroker()
+	mq := newTestRabbitMQ(broker)
+	defer mq.Close()
+	setupRetryQueue(t, broker, "threat_events")
+
+	recorder := &callRecorder{fail: func(n int) error {
+		if n < 3 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, mq.SubscribeWithOptions(ctx, "threat_events", recorder.handle, testRetryOptions(3)))
+
+	// 每種延遲一個 TTL 隊列，過期後回到原隊列
+	for _, delay := range []int64{20, 40, 60} {
+		args := broker.queueArgs(retryQueueName("threat_events", time.Duration(delay)*time.Millisecond))
+		require.NotNil(t, args, "delay %d", delay)
+		assert.Equal(t, delay, args["x-message-ttl"])
+		assert.Equal(t, "", args["x-dead-letter-exchange"])
+		assert.Equal(t, "threat_events", args["x-dead-letter-routing-key"])
+	}
+	assert.Equal(t, 0, broker.depth(DeadLetterQueueName("threat_events")))
+
+	require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.detected", []byte(`{"id":"1"}`)))
+	require.Eventually(t, func() bool { return recorder.count() == 3 }, 2*time.Second, 5*time.Millisecond)
+
+	calls := recorder.snapshot()
+	for _, call := range calls {
+		assert.Equal(t, "threat.detected", call.topic, "重試的消息保留原始路由鍵")
+		assert.Equal(t, `{"id":"1"}`, call.body)
+	}
+	assert.GreaterOrEqual(t, calls[1].at.Sub(calls[0].at), 20*time.Millisecond)
+	assert.GreaterOrEqual(t, calls[2].at.Sub(calls[1].at), 40*time.Millisecond, "指數退避")
+
+	time.Sleep(80 * time.Millisecond)
+	assert.Equal(t, 3, recorder.count(), "成功後不再重試")
+	assert.Equal(t, 0, broker.depth(DeadLetterQueueName("threat_events")))
+	broker.mu.Lock()
+	assert.Empty(t, broker.unacked, "所有消息都已確認")
+	broker.mu.Unlock()
+}
+
+func TestSubscribeDeadLettersAfterMaxRetries(t *testing.T) {
+	broker := newFakeBroker()
+	mq := newTestRabbitMQ(broker)
+	defer mq.Close()
+	setupRetryQueue(t, broker, "threat_events")
+
+	recorder := &callRecorder{fail: func(n int) error { return errors.New("invalid threat level") }}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, mq.SubscribeWithOptions(ctx, "threat_events", recorder.handle, testRetryOptions(2)))
+
+	dead := DeadLetterQueueName("threat_events")
+	require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.detected", []byte("first")))
+	require.Eventually(t, func() bool { return broker.depth(dead) == 1 }, 2*time.Second, 5*time.Millisecond)
+	require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.blocked", []byte("second")))
+	require.Eventually(t, func() bool { return broker.depth(dead) == 2 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, 6, recorder.count(), "每則消息處理 1 次加重試 2 次")
+
+	// 查看不會移除死信，順序不變
+	letters, err := mq.InspectDeadLetters(ctx, "threat_events", 0)
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	assert.Equal(t, 2, broker.depth(dead))
+	assert.Equal(t, "first", string(letters[0].Body))
+	assert.Equal(t, "threat_events", letters[0].Queue)
+	assert.Equal(t, "pandora.events", letters[0].Exchange)
+	assert.Equal(t, "threat.detected", letters[0].RoutingKey)
+	assert.Equal(t, "invalid threat level", letters[0].Reason)
+	assert.Equal(t, 2, letters[0].RetryCount)
+	assert.WithinDuration(t, time.Now(), letters[0].FailedAt, time.Minute)
+	assert.Equal(t, "threat.blocked", letters[1].RoutingKey)
+
+	letters, err = mq.InspectDeadLetters(ctx, "threat_events", 1)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "first", string(letters[0].Body))
+
+	// 重播後重新計算重試次數
+	recorder.setFail(nil)
+	replayed, err := mq.ReplayDeadLetters(ctx, "threat_events", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	require.Eventually(t, func() bool { return recorder.count() == 7 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "threat.detected", recorder.snapshot()[6].topic)
+	assert.Equal(t, 1, broker.depth(dead))
+
+	purged, err := mq.PurgeDeadLetters(ctx, "threat_events")
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, 0, broker.depth(dead))
+}
+
+func TestReplayFailingDeadLetters(t *testing.T) {
+	broker := newFakeBroker()
+	mq := newTestRabbitMQ(broker)
+	defer mq.Close()
+	setupRetryQueue(t, broker, "threat_events")
+
+	recorder := &callRecorder{fail: func(n int) error { return Permanent(errors.New("schema mismatch")) }}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, mq.SubscribeWithOptions(ctx, "threat_events", recorder.handle, testRetryOptions(3)))
+
+	for _, body := range []string{"a", "b", "c"} {
+		require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.detected", []byte(body)))
+	}
+	dead := DeadLetterQueueName("threat_events")
+	require.Eventually(t, func() bool { return broker.depth(dead) == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 3, recorder.count(), "永久性錯誤不重試")
+
+	// 重播後再次失敗的消息不在同一次呼叫中重複處理
+	replayed, err := mq.ReplayDeadLetters(ctx, "threat_events", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, replayed)
+	require.Eventually(t, func() bool { return broker.depth(dead) == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 6, recorder.count())
+}
+
+func TestSubscribePoisonMessage(t *testing.T) {
+	broker := newFakeBroker()
+	mq := newTestRabbitMQ(broker)
+	defer mq.Close()
+	setupRetryQueue(t, broker, "threat_events")
+
+	calls := 0
+	handler := func(topic string, message []byte) error {
+		calls++
+		var event ThreatEvent
+		if err := json.Unmarshal(message, &event); err != nil {
+			panic(err)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, mq.SubscribeWithOptions(ctx, "threat_events", handler, testRetryOptions(3)))
+
+	require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.detected", []byte("{not json")))
+	dead := DeadLetterQueueName("threat_events")
+	require.Eventually(t, func() bool { return broker.depth(dead) == 1 }, time.Second, 5*time.Millisecond)
+
+	letters, err := mq.InspectDeadLetters(ctx, "threat_events", 0)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Contains(t, letters[0].Reason, "handler panic")
+	assert.Equal(t, 0, letters[0].RetryCount)
+	assert.Equal(t, 1, calls)
+}
+
+func TestSubscribeRequeuesWhenRetryIsNotConfirmed(t *testing.T) {
+	broker := newFakeBroker()
+	mq := newTestRabbitMQ(broker)
+	defer mq.Close()
+	setupRetryQueue(t, broker, "threat_events")
+	confirmCh := mq.confirm.ch.(*fakeConfirmChannel)
+	confirmCh.setNack(true)
+
+	recorder := &callRecorder{fail: func(n int) error {
+		if n == 3 {
+			// broker 恢復後重試才會被確認
+			confirmCh.setNack(false)
+		}
+		return errors.New("database unavailable")
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, mq.SubscribeWithOptions(ctx, "threat_events", recorder.handle, testRetryOptions(1)))
+
+	require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.detected", []byte("x")))
+	dead := DeadLetterQueueName("threat_events")
+	require.Eventually(t, func() bool { return broker.depth(dead) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 4, recorder.count(), "未確認的重試不確認原消息，原消息重新投遞")
+
+	letters, err := mq.InspectDeadLetters(ctx, "threat_events", 0)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, 1, letters[0].RetryCount)
+
+	// 重播未被確認時死信保留在隊列中
+	confirmCh.setNack(true)
+	replayed, err := mq.ReplayDeadLetters(ctx, "threat_events", 0)
+	assert.Error(t, err)
+	assert.Zero(t, replayed)
+	assert.Equal(t, 1, broker.depth(dead))
+}
+
+func TestSubscribeWithoutRetryPolicy(t *testing.T) {
+	broker := newFakeBroker()
+	mq := newTestRabbitMQ(broker)
+	defer mq.Close()
+	setupRetryQueue(t, broker, "threat_events")
+
+	recorder := &callRecorder{fail: func(n int) error {
+		if n == 1 {
+			return errors.New("temporary")
+		}
+		return nil
+	}}
+	opts := DefaultSubscribeOptions()
+	opts.RetryPolicy = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, mq.SubscribeWithOptions(ctx, "threat_events", recorder.handle, opts))
+
+	require.NoError(t, mq.Publish(ctx, "pandora.events", "threat.detected", []byte("x")))
+	require.Eventually(t, func() bool { return recorder.count() == 2 }, time.Second, 5*time.Millisecond, "立即重新投遞")
+	assert.Equal(t, -1, broker.depth(DeadLetterQueueName("threat_events")), "未設定重試策略時不宣告死信隊列")
+}
+
+func TestDeadLetterHandler(t *testing.T) {
+	broker := newFakeBroker()
+	mq := newTestRabbitMQ(broker)
+	defer mq.Close()
+	setupRetryQueue(t, broker, "threat_events")
+	require.NoError(t, mq.declareRetryTopology(broker, "threat_events", testRetryOptions(1).RetryPolicy))
+
+	for _, body := range []string{"a", "b"} {
+		require.NoError(t, broker.PublishWithContext(context.Background(), "pandora.events.dlx", "threat_events", false, false, amqp.Publishing{
+			Body: []byte(body),
+			Headers: amqp.Table{
+				HeaderSourceQueue:        "threat_events",
+				HeaderOriginalExchange:   "pandora.events",
+				HeaderOriginalRoutingKey: "threat.detected",
+				HeaderFailureReason:      "boom",
+				HeaderRetryCount:         int32(1),
+			},
+		}))
+	}
+
+	handler := NewDeadLetterHandler(mq)
+	request := func(method, target string) (int, map[string]interface{}) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		return recorder.Code, body
+	}
+
+	code, body := request(http.MethodGet, "/dead-letters/threat_events?limit=10")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 2, body["count"])
+	letters := body["dead_letters"].([]interface{})
+	assert.Equal(t, "boom", letters[0].(map[string]interface{})["reason"])
+
+	code, _ = request(http.MethodGet, "/dead-letters/threat_events?limit=-1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body = request(http.MethodPost, "/dead-letters/threat_events/replay?limit=1")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 1, body["replayed"])
+	assert.Equal(t, 1, broker.depth("threat_events"), "重播到原始交換機與路由鍵")
+
+	code, body = request(http.MethodDelete, "/dead-letters/threat_events")
+	require.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 1, body["purged"])
+
+	code, _ = request(http.MethodDelete, "/dead-letters/unknown")
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestDeadLetterAdminHandlerRequiresToken(t *testing.T) {
+	broker := newFakeBroker()
+	mq := newTestRabbitMQ(broker)
+	defer mq.Close()
+	setupRetryQueue(t, broker, "threat_events")
+	require.NoError(t, mq.declareRetryTopology(broker, "threat_events", testRetryOptions(1).RetryPolicy))
+
+	_, err := NewDeadLetterAdminHandler(mq, "")
+	assert.Error(t, err, "未設定 token 時不提供管理 API")
+
+	handler, err := NewDeadLetterAdminHandler(mq, "s3cret")
+	require.NoError(t, err)
+	for _, authorization := range []string{"", "Bearer wrong", "s3cret"} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodDelete, "/dead-letters/threat_events", nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		handler.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, "authorization %q", authorization)
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/dead-letters/threat_events", nil)
+	request.Header.Set("Authorization", "Bearer s3cret")
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
@@ -0,0 +1,500 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CurrentSchemaVersion is the schema version written by the event constructors.
+// Version 1 is the unversioned format published before schema_version existed.
+// 目前的事件結構版本；沒有 schema_version 的舊事件視為版本 1
+const CurrentSchemaVersion = 2
+
+// JSONSchema is a JSON Schema (draft 2020-12) document
+// JSON Schema 文件
+type JSONSchema map[string]interface{}
+
+// Upcaster migrates a decoded payload in place from one schema version to the next
+// 將事件從某一版本升級到下一版本 (直接修改 payload)
+type Upcaster func(payload map[string]interface{}) error
+
+// ValidationError reports why an event does not match its schema
+// 事件不符合結構定義的錯誤
+type ValidationError struct {
+	Kind    string
+	Version int
+	Errors  []string
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	if e.Kind == "" {
+		return "invalid event: " + strings.Join(e.Errors, "; ")
+	}
+	return fmt.Sprintf("invalid %s event (schema v%d): %s", e.Kind, e.Version, strings.Join(e.Errors, "; "))
+}
+
+// IsValidationError reports whether err is or wraps a ValidationError
+func IsValidationError(err error) bool {
+	var validationErr *ValidationError
+	return errors.As(err, &validationErr)
+}
+
+// eventSchema is the registered schema of an event kind
+type eventSchema struct {
+	version   int
+	schema    JSONSchema
+	upcasters map[int]Upcaster
+}
+
+// SchemaRegistry holds the current schema and the upcasters of each event kind;
+// the kind is the first word of the event type ("threat" for "threat.detected")
+// 事件結構註冊表：每種事件 (事件類型的第一個字，例如 threat.detected 為 threat)
+// 保存目前版本的 JSON Schema 與舊版本的升級函數
+type SchemaRegistry struct {
+	mu      sync.RWMutex
+	schemas map[string]*eventSchema
+}
+
+// NewSchemaRegistry creates an empty registry
+// 創建空的事件結構註冊表
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{schemas: make(map[string]*eventSchema)}
+}
+
+// DefaultSchemaRegistry returns a registry with the built-in event types at CurrentSchemaVersion
+// and the upcasters from version 1
+// 返回包含內建事件類型與版本 1 升級函數的註冊表
+func DefaultSchemaRegistry() *SchemaRegistry {
+	r := NewSchemaRegistry()
+	r.Register("threat", CurrentSchemaVersion, ThreatEvent{})
+	r.Register("network", CurrentSchemaVersion, NetworkEvent{})
+	r.Register("system", CurrentSchemaVersion, SystemEvent{})
+	r.Register("device", CurrentSchemaVersion, DeviceEvent{})
+	r.Register("report", CurrentSchemaVersion, ReportEvent{})
+
+	// v1 事件沒有 schema_version，部分發布者也省略 severity
+	r.RegisterUpcaster("threat", 1, func(payload map[string]interface{}) error {
+		if _, ok := payload["severity"]; !ok {
+			level, _ := payload["threat_level"].(float64)
+			payload["severity"] = severityFromThreatLevel(int(level))
+		}
+		return nil
+	})
+	for _, kind := range []string{"network", "system", "device", "report"} {
+		r.RegisterUpcaster(kind, 1, func(payload map[string]interface{}) error {
+			if _, ok := payload["severity"]; !ok {
+				payload["severity"] = "info"
+			}
+			return nil
+		})
+	}
+	return r
+}
+
+// Register sets the current schema of kind, generated from the Go type of prototype
+// 以 Go 型別產生並註冊事件目前版本的 JSON Schema
+func (r *SchemaRegistry) Register(kind string, version int, prototype interface{}) {
+	schema := GenerateSchema(prototype)
+	schema["$id"] = fmt.Sprintf("urn:pandora:events:%s:v%d", kind, version)
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		if property, ok := properties["schema_version"].(JSONSchema); ok {
+			property["const"] = version
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.schemas[kind]
+	if !ok {
+		existing = &eventSchema{upcasters: make(map[int]Upcaster)}
+		r.schemas[kind] = existing
+	}
+	existing.version = version
+	existing.schema = schema
+}
+
+// RegisterUpcaster registers the migration of kind from version from to from+1
+// 註冊事件從 from 版本升級到 from+1 版本的函數
+func (r *SchemaRegistry) RegisterUpcaster(kind string, from int, upcaster Upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.schemas[kind]
+	if !ok {
+		existing = &eventSchema{upcasters: make(map[int]Upcaster)}
+		r.schemas[kind] = existing
+	}
+	existing.upcasters[from] = upcaster
+}
+
+// Schema returns the current schema of kind
+// 返回事件目前版本的 JSON Schema
+func (r *SchemaRegistry) Schema(kind string) (JSONSchema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	existing, ok := r.schemas[kind]
+	if !ok || existing.schema == nil {
+		return nil, false
+	}
+	return existing.schema, true
+}
+
+// Kinds returns the registered event kinds in sorted order
+func (r *SchemaRegistry) Kinds() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kinds := make([]string, 0, len(r.schemas))
+	for kind, existing := range r.schemas {
+		if existing.schema != nil {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// WriteSchemas writes each current schema to dir as <kind>.v<version>.schema.json
+// 將目前版本的 JSON Schema 寫入目錄
+func (r *SchemaRegistry) WriteSchemas(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create schema directory: %w", err)
+	}
+	for _, kind := range r.Kinds() {
+		r.mu.RLock()
+		existing := r.schemas[kind]
+		version, schema := existing.version, existing.schema
+		r.mu.RUnlock()
+
+		data, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s schema: %w", kind, err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%s.v%d.schema.json", kind, version))
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write %s schema: %w", kind, err)
+		}
+	}
+	return nil
+}
+
+// EventKind returns the kind of an event type ("threat" for "threat.detected")
+// 返回事件類型所屬的種類
+func EventKind(eventType string) string {
+	if i := strings.Index(eventType, "."); i >= 0 {
+		return eventType[:i]
+	}
+	return eventType
+}
+
+// Validate checks that message is a current-version event matching its schema.
+// Messages whose type has no registered schema are accepted unchanged.
+// 驗證事件符合目前版本的結構；未註冊的事件類型不驗證
+func (r *SchemaRegistry) Validate(message []byte) error {
+	payload, err := decodePayload(message)
+	if err != nil {
+		return err
+	}
+	return r.validate(payload)
+}
+
+// Upcast migrates message to the current version of its schema and validates it.
+// The returned payload can be decoded into the current Go type with FromJSON.
+// 將舊版本事件升級到目前版本並驗證，返回升級後的 JSON
+func (r *SchemaRegistry) Upcast(message []byte) ([]byte, error) {
+	payload, err := decodePayload(message)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := EventKind(stringValue(payload["type"]))
+	r.mu.RLock()
+	existing, ok := r.schemas[kind]
+	r.mu.RUnlock()
+	if !ok || existing.schema == nil {
+		return message, nil
+	}
+
+	version := 1
+	if raw, ok := payload["schema_version"]; ok {
+		number, ok := raw.(float64)
+		if !ok || number != float64(int(number)) || number < 1 {
+			return nil, &ValidationError{Kind: kind, Version: existing.version, Errors: []string{"schema_version: must be a positive integer"}}
+		}
+		version = int(number)
+	}
+	if version > existing.version {
+		return nil, &ValidationError{Kind: kind, Version: existing.version, Errors: []string{fmt.Sprintf("schema_version: %d is newer than the supported version", version)}}
+	}
+	if version == existing.version {
+		if err := r.validate(payload); err != nil {
+			return nil, err
+		}
+		return message, nil
+	}
+
+	for ; version < existing.version; version++ {
+		r.mu.RLock()
+		upcaster, ok := existing.upcasters[version]
+		r.mu.RUnlock()
+		if !ok {
+			return nil, &ValidationError{Kind: kind, Version: existing.version, Errors: []string{fmt.Sprintf("schema_version: no upcaster from version %d", version)}}
+		}
+		if err := upcaster(payload); err != nil {
+			return nil, fmt.Errorf("failed to upcast %s event from version %d: %w", kind, version, err)
+		}
+		payload["schema_version"] = float64(version + 1)
+	}
+
+	if err := r.validate(payload); err != nil {
+		return nil, err
+	}
+	return json.Marshal(payload)
+}
+
+// validate checks payload against the current schema of its kind
+func (r *SchemaRegistry) validate(payload map[string]interface{}) error {
+	kind := EventKind(stringValue(payload["type"]))
+	r.mu.RLock()
+	existing, ok := r.schemas[kind]
+	r.mu.RUnlock()
+	if !ok || existing.schema == nil {
+		return nil
+	}
+
+	var errs []string
+	validateValue(existing.schema, payload, "", &errs)
+	if len(errs) > 0 {
+		return &ValidationError{Kind: kind, Version: existing.version, Errors: errs}
+	}
+	return nil
+}
+
+// decodePayload decodes a message into a JSON object
+func decodePayload(message []byte) (map[string]interface{}, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(message, &payload); err != nil || payload == nil {
+		return nil, &ValidationError{Errors: []string{"payload is not a JSON object"}}
+	}
+	return payload, nil
+}
+
+func stringValue(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// GenerateSchema generates the JSON Schema of a struct from its fields and json tags:
+// embedded structs are flattened and fields without omitempty are required
+// 依 Go 型別與 json 標籤產生 JSON Schema：嵌入的結構會展開，沒有 omitempty 的欄位為必填
+func GenerateSchema(prototype interface{}) JSONSchema {
+	t := reflect.TypeOf(prototype)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	schema := typeSchema(t)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = t.Name()
+	return schema
+}
+
+// typeSchema returns the schema of a Go type
+func typeSchema(t reflect.Type) JSONSchema {
+	switch t {
+	case timeType:
+		return JSONSchema{"type": "string", "format": "date-time"}
+	case durationType:
+		return JSONSchema{"type": "integer"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := typeSchema(t.Elem())
+		return nullable(schema)
+	case reflect.String:
+		return JSONSchema{"type": "string"}
+	case reflect.Bool:
+		return JSONSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return JSONSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return JSONSchema{"type": "number"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return JSONSchema{"type": []interface{}{"string", "null"}, "contentEncoding": "base64"}
+		}
+		return JSONSchema{"type": []interface{}{"array", "null"}, "items": typeSchema(t.Elem())}
+	case reflect.Array:
+		return JSONSchema{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return JSONSchema{"type": []interface{}{"object", "null"}, "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		var required []interface{}
+		structSchema(t, properties, &required)
+		schema := JSONSchema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		// interface{} 可為任意值
+		return JSONSchema{}
+	}
+}
+
+// structSchema adds the fields of t to properties, flattening embedded structs
+func structSchema(t reflect.Type, properties map[string]interface{}, required *[]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			structSchema(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// nullable allows null in addition to the schema's type
+func nullable(schema JSONSchema) JSONSchema {
+	switch typ := schema["type"].(type) {
+	case string:
+		schema["type"] = []interface{}{typ, "null"}
+	}
+	return schema
+}
+
+// validateValue validates value against the subset of JSON Schema produced by GenerateSchema:
+// type, const, properties, required, items, additionalProperties and the date-time format
+func validateValue(schema JSONSchema, value interface{}, path string, errs *[]string) {
+	if typ, ok := schema["type"]; ok && !matchesType(typ, value) {
+		*errs = append(*errs, fmt.Sprintf("%s: expected %v, got %s", fieldPath(path), typ, jsonType(value)))
+		return
+	}
+	if expected, ok := schema["const"]; ok {
+		if number, isNumber := value.(float64); !isNumber || number != float64(expected.(int)) {
+			*errs = append(*errs, fmt.Sprintf("%s: must be %v", fieldPath(path), expected))
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				*errs = append(*errs, fmt.Sprintf("%s: invalid date-time %q", fieldPath(path), v))
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, present := v[name.(string)]; !present {
+					*errs = append(*errs, fmt.Sprintf("%s: is required", joinPath(path, name.(string))))
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(JSONSchema)
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := properties[key].(JSONSchema); ok {
+				validateValue(property, v[key], joinPath(path, key), errs)
+			} else if additional != nil {
+				validateValue(additional, v[key], joinPath(path, key), errs)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(JSONSchema); ok {
+			for i, item := range v {
+				validateValue(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	}
+}
+
+// matchesType reports whether value has one of the JSON types in typ
+func matchesType(typ interface{}, value interface{}) bool {
+	switch t := typ.(type) {
+	case string:
+		actual := jsonType(value)
+		return actual == t || (t == "number" && actual == "integer")
+	case []interface{}:
+		for _, candidate := range t {
+			if matchesType(candidate, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON type name of a decoded value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func fieldPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
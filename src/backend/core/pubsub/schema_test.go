@@ -0,0 +1,247 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+var updateSchemas = flag.Bool("update-schemas", false, "regenerate schemas/*.schema.json")
+
+func TestGenerateSchema(t *testing.T) {
+	schema := GenerateSchema(ThreatEvent{})
+
+	assert.Equal(t, "ThreatEvent", schema["title"])
+	assert.Equal(t, "object", schema["type"])
+	properties := schema["properties"].(map[string]interface{})
+	// BaseEvent 欄位展開到頂層
+	assert.Equal(t, JSONSchema{"type": "string"}, properties["id"])
+	assert.Equal(t, JSONSchema{"type": "string", "format": "date-time"}, properties["timestamp"])
+	assert.Equal(t, JSONSchema{"type": "integer"}, properties["threat_level"])
+	assert.Equal(t, JSONSchema{"type": []interface{}{"array", "null"}, "items": JSONSchema{"type": "string"}}, properties["tags"])
+
+	required := schema["required"].([]interface{})
+	assert.Contains(t, required, "schema_version")
+	assert.Contains(t, required, "threat_type")
+	assert.NotContains(t, required, "target_ip")
+	assert.NotContains(t, required, "tags")
+
+	report := GenerateSchema(&ReportEvent{})
+	content := report["properties"].(map[string]interface{})["content"].(JSONSchema)
+	assert.Equal(t, "base64", content["contentEncoding"])
+}
+
+func TestSchemaRegistryValidate(t *testing.T) {
+	registry := DefaultSchemaRegistry()
+	assert.Equal(t, []string{"device", "network", "report", "system", "threat"}, registry.Kinds())
+
+	constructors := map[string]interface{}{
+		"threat":  NewThreatEvent("ddos", "192.168.1.100", "DDoS", "blocked", 8),
+		"network": NewNetworkEvent("port_scan", "192.168.1.100", "10.0.0.1", "tcp"),
+		"system":  NewSystemEvent("engine", "running", "started"),
+		"device":  NewDeviceEvent("usb-001", "usb-serial", "connected"),
+		"report":  NewReportEvent("daily", "security", "pdf"),
+	}
+	for kind, event := range constructors {
+		data, err := ToJSON(event)
+		require.NoError(t, err)
+		assert.NoError(t, registry.Validate(data), kind)
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		errText string
+	}{
+		{"wrong type", `{"id":"evt_1","type":"threat.detected","schema_version":2,"timestamp":"2026-01-01T00:00:00Z","source":"a","severity":"high","threat_type":"ddos","threat_level":"high","source_ip":"1.2.3.4","description":"d","action":"blocked"}`, "threat_level: expected integer, got string"},
+		{"missing field", `{"id":"evt_1","type":"system.started","schema_version":2,"timestamp":"2026-01-01T00:00:00Z","source":"a","severity":"info","status":"running","message":"m"}`, "component: is required"},
+		{"bad timestamp", `{"id":"evt_1","type":"system.started","schema_version":2,"timestamp":"yesterday","source":"a","severity":"info","component":"c","status":"running","message":"m"}`, `timestamp: invalid date-time "yesterday"`},
+		{"old version", `{"id":"evt_1","type":"system.started","schema_version":1,"timestamp":"2026-01-01T00:00:00Z","source":"a","severity":"info","component":"c","status":"running","message":"m"}`, "schema_version: must be 2"},
+		{"not an object", `[1,2,3]`, "payload is not a JSON object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := registry.Validate([]byte(tt.payload))
+			require.Error(t, err)
+			assert.True(t, IsValidationError(err))
+			assert.Contains(t, err.Error(), tt.errText)
+		})
+	}
+
+	// 未註冊的事件類型不驗證
+	assert.NoError(t, registry.Validate([]byte(`{"type":"auth.login","user":"admin"}`)))
+}
+
+func TestSchemaRegistryUpcast(t *testing.T) {
+	registry := DefaultSchemaRegistry()
+
+	// v1：沒有 schema_version 與 severity
+	legacy := []byte(`{"id":"evt_1","type":"threat.detected","timestamp":"2025-06-01T08:00:00Z","source":"pandora-agent","threat_type":"ddos","threat_level":9,"source_ip":"192.168.1.100","description":"DDoS","action":"blocked"}`)
+	current, err := registry.Upcast(legacy)
+	require.NoError(t, err)
+
+	var event ThreatEvent
+	require.NoError(t, FromJSON(current, &event))
+	assert.Equal(t, CurrentSchemaVersion, event.SchemaVersion)
+	assert.Equal(t, "critical", event.Severity)
+	assert.Equal(t, "ddos", event.ThreatType)
+
+	// 目前版本不變
+	data, err := ToJSON(NewSystemEvent("engine", "running", "started"))
+	require.NoError(t, err)
+	unchanged, err := registry.Upcast(data)
+	require.NoError(t, err)
+	assert.Equal(t, data, unchanged)
+
+	// 較新的版本與缺少升級函數都視為無效
+	_, err = registry.Upcast([]byte(`{"id":"evt_2","type":"system.started","schema_version":3}`))
+	assert.True(t, IsValidationError(err))
+
+	custom := NewSchemaRegistry()
+	custom.Register("system", 3, SystemEvent{})
+	custom.RegisterUpcaster("system", 2, func(payload map[string]interface{}) error {
+		payload["component"] = payload["service"]
+		delete(payload, "service")
+		return nil
+	})
+	_, err = custom.Upcast([]byte(`{"id":"evt_3","type":"system.started","timestamp":"2025-06-01T08:00:00Z","source":"a","severity":"info","service":"engine","status":"running","message":"m"}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no upcaster from version 1")
+
+	upgraded, err := custom.Upcast([]byte(`{"id":"evt_4","type":"system.started","schema_version":2,"timestamp":"2025-06-01T08:00:00Z","source":"a","severity":"info","service":"engine","status":"running","message":"m"}`))
+	require.NoError(t, err)
+	var system SystemEvent
+	require.NoError(t, FromJSON(upgraded, &system))
+	assert.Equal(t, 3, system.SchemaVersion)
+	assert.Equal(t, "engine", system.Component)
+}
+
+func TestSchemaFilesUpToDate(t *testing.T) {
+	dir := "schemas"
+	if *updateSchemas {
+		require.NoError(t, DefaultSchemaRegistry().WriteSchemas(dir))
+	}
+
+	generated := t.TempDir()
+	require.NoError(t, DefaultSchemaRegistry().WriteSchemas(generated))
+	files, err := filepath.Glob(filepath.Join(generated, "*.schema.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 5)
+	for _, file := range files {
+		want, err := os.ReadFile(file)
+		require.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(dir, filepath.Base(file)))
+		require.NoError(t, err, "執行 go test -run TestSchemaFilesUpToDate -update-schemas 重新產生")
+		assert.JSONEq(t, string(want), string(got), filepath.Base(file))
+	}
+}
+
+func TestValidatingQueue(t *testing.T) {
+	config := testQueueConfig("")
+	mq := NewMemoryQueue(config)
+	t.Cleanup(func() { mq.Close() })
+	validating := NewValidatingQueue(mq, nil, "pandora.events")
+
+	quarantined := make(chan []byte, 10)
+	require.NoError(t, mq.Subscribe(context.Background(), QuarantineQueue, func(topic string, message []byte) error {
+		quarantined <- message
+		return nil
+	}))
+	received := make(chan []byte, 10)
+	require.NoError(t, validating.SubscribeWithOptions(context.Background(), "threat_events", func(topic string, message []byte) error {
+		received <- message
+		return nil
+	}, DefaultSubscribeOptions()))
+
+	// 發布有效事件
+	data, err := ToJSON(NewThreatEvent("ddos", "192.168.1.100", "DDoS", "blocked", 8))
+	require.NoError(t, err)
+	require.NoError(t, validating.Publish(context.Background(), "pandora.events", "threat.detected", data))
+	select {
+	case message := <-received:
+		assert.Equal(t, data, message)
+	case <-time.After(2 * time.Second):
+		t.Fatal("未收到有效事件")
+	}
+
+	// 發布無效事件：返回錯誤並送往隔離隊列
+	err = validating.Publish(context.Background(), "pandora.events", "threat.detected", []byte(`{"id":"evt_bad","type":"threat.detected","schema_version":2}`))
+	require.Error(t, err)
+	assert.True(t, IsValidationError(err))
+	var envelope QuarantinedEvent
+	select {
+	case message := <-quarantined:
+		require.NoError(t, json.Unmarshal(message, &envelope))
+	case <-time.After(2 * time.Second):
+		t.Fatal("無效事件未送往隔離隊列")
+	}
+	assert.Equal(t, "publish", envelope.Stage)
+	assert.Equal(t, "threat.detected", envelope.RoutingKey)
+	assert.Contains(t, envelope.Reason, "threat_type: is required")
+	assert.JSONEq(t, `{"id":"evt_bad","type":"threat.detected","schema_version":2}`, string(envelope.Payload))
+
+	// 消費舊版本事件時升級
+	legacy := `{"id":"evt_v1","type":"threat.detected","timestamp":"2025-06-01T08:00:00Z","source":"pandora-agent","threat_type":"ddos","threat_level":5,"source_ip":"192.168.1.100","description":"DDoS","action":"blocked"}`
+	require.NoError(t, mq.Publish(context.Background(), "pandora.events", "threat.detected", []byte(legacy)))
+	select {
+	case message := <-received:
+		var event ThreatEvent
+		require.NoError(t, FromJSON(message, &event))
+		assert.Equal(t, CurrentSchemaVersion, event.SchemaVersion)
+		assert.Equal(t, "medium", event.Severity)
+	case <-time.After(2 * time.Second):
+		t.Fatal("未收到升級後的事件")
+	}
+
+	// 消費無效事件：不交給處理函數，送往隔離隊列
+	require.NoError(t, mq.Publish(context.Background(), "pandora.events", "threat.detected", []byte("not json")))
+	select {
+	case message := <-quarantined:
+		envelope = QuarantinedEvent{}
+		require.NoError(t, json.Unmarshal(message, &envelope))
+	case <-time.After(2 * time.Second):
+		t.Fatal("無效事件未送往隔離隊列")
+	}
+	assert.Equal(t, "consume", envelope.Stage)
+	assert.Equal(t, "threat_events", envelope.Queue)
+	assert.Equal(t, "not json", envelope.RawPayload)
+	select {
+	case message := <-received:
+		t.Fatalf("無效事件不應交給處理函數: %s", message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOutboxRelayMarksQuarantinedEvents(t *testing.T) {
+	db := newOutboxTestDB(t)
+	mq := NewMemoryQueue(testQueueConfig(""))
+	t.Cleanup(func() { mq.Close() })
+	relay, err := NewOutboxRelay(db, NewValidatingQueue(mq, nil, "pandora.events"), nil)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+		return EnqueueOutbox(tx, "pandora.events", "", map[string]interface{}{"id": "evt_invalid", "type": "system.started", "schema_version": 2})
+	}))
+	enqueueSystemEvent(t, db, "started")
+
+	published, err := relay.RelayOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+
+	stats, err := relay.Stats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Pending)
+
+	var record OutboxRecord
+	require.NoError(t, db.Where("event_id = ?", "evt_invalid").First(&record).Error)
+	assert.NotNil(t, record.PublishedAt)
+	assert.Contains(t, record.LastError, "component: is required")
+}
@@ -0,0 +1,54 @@
+package pubsub
+
+import "strings"
+
+// Binding binds a queue to an exchange with a routing-key pattern
+// 隊列綁定：交換機上符合 RoutingKey 樣式的消息會送入 Queue
+type Binding struct {
+	Exchange string `json:"exchange"`
+	Queue    string `json:"queue"`
+
+	// RoutingKey supports AMQP topic wildcards: "*" matches one word, "#" zero or more
+	RoutingKey string `json:"routing_key"`
+}
+
+// DefaultBindings returns the queue bindings declared in configs/rabbitmq/definitions.json
+// 與 RabbitMQ 部署設定相同的預設綁定，讓其他後端不需額外設定即可使用相同的隊列
+func DefaultBindings(exchange string) []Binding {
+	return []Binding{
+		{Exchange: exchange, Queue: "threat_events", RoutingKey: "threat.*"},
+		{Exchange: exchange, Queue: "network_events", RoutingKey: "network.*"},
+		{Exchange: exchange, Queue: "system_events", RoutingKey: "system.*"},
+		{Exchange: exchange, Queue: "device_events", RoutingKey: "device.*"},
+		{Exchange: exchange, Queue: "tenant_events", RoutingKey: "tenant.*"},
+		{Exchange: exchange, Queue: QuarantineQueue, RoutingKey: QuarantineRoutingKeyPrefix + "#"},
+	}
+}
+
+// MatchRoutingKey reports whether routingKey matches the AMQP topic pattern
+// 依 AMQP topic 規則比對路由鍵：以 "." 分隔單字，"*" 比對一個單字，"#" 比對零或多個單字
+func MatchRoutingKey(pattern, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "#" {
+			// 連續的 "#" 與單一 "#" 相同
+			for len(pattern) > 1 && pattern[1] == "#" {
+				pattern = pattern[1:]
+			}
+			for i := 0; i <= len(words); i++ {
+				if matchWords(pattern[1:], words[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(words) == 0 || (pattern[0] != "*" && pattern[0] != words[0]) {
+			return false
+		}
+		pattern, words = pattern[1:], words[1:]
+	}
+	return len(words) == 0
+}
@@ -0,0 +1,125 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// QuarantineQueue receives events that failed schema validation
+	// 驗證失敗事件的隔離隊列
+	QuarantineQueue = "quarantine_events"
+
+	// QuarantineRoutingKeyPrefix is prepended to the original routing key of a quarantined event
+	// 隔離事件的路由鍵前綴，例如 quarantine.threat.detected
+	QuarantineRoutingKeyPrefix = "quarantine."
+)
+
+// QuarantinedEvent is published to the quarantine queue for an event that failed validation
+// 隔離事件：原始內容與驗證失敗原因
+type QuarantinedEvent struct {
+	// Stage is "publish" or "consume"
+	Stage         string          `json:"stage"`
+	RoutingKey    string          `json:"routing_key"`
+	Queue         string          `json:"queue,omitempty"`
+	Reason        string          `json:"reason"`
+	QuarantinedAt time.Time       `json:"quarantined_at"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	RawPayload    string          `json:"raw_payload,omitempty"`
+}
+
+// ValidatingQueue validates events against a SchemaRegistry on publish and consume:
+// older consumed events are upcast to the current version before reaching the handler,
+// and events that fail validation are sent to the quarantine queue
+// 事件結構驗證：發布與消費時驗證事件，消費時先升級舊版本事件；
+// 驗證失敗的事件送往隔離隊列
+type ValidatingQueue struct {
+	MessageQueue
+	registry *SchemaRegistry
+	exchange string
+}
+
+// NewValidatingQueue wraps mq; quarantined events are published to exchange
+// with the routing key QuarantineRoutingKeyPrefix + original routing key
+// 創建事件結構驗證的消息隊列
+func NewValidatingQueue(mq MessageQueue, registry *SchemaRegistry, exchange string) *ValidatingQueue {
+	if registry == nil {
+		registry = DefaultSchemaRegistry()
+	}
+	return &ValidatingQueue{MessageQueue: mq, registry: registry, exchange: exchange}
+}
+
+// Publish validates message and publishes it; an invalid event is quarantined
+// and a *ValidationError is returned
+// 驗證後發布；驗證失敗時送往隔離隊列並返回 ValidationError
+func (q *ValidatingQueue) Publish(ctx context.Context, exchange, routingKey string, message []byte) error {
+	if err := q.registry.Validate(message); err != nil {
+		if qErr := q.quarantine(ctx, "publish", routingKey, "", message, err); qErr != nil {
+			log.Printf("[PubSub] Failed to quarantine event: %v", qErr)
+		}
+		return err
+	}
+	return q.MessageQueue.Publish(ctx, exchange, routingKey, message)
+}
+
+// Subscribe consumes queue with validation and upcasting
+// 訂閱並驗證、升級事件
+func (q *ValidatingQueue) Subscribe(ctx context.Context, queue string, handler MessageHandler) error {
+	return q.MessageQueue.Subscribe(ctx, queue, q.wrap(queue, handler))
+}
+
+// SubscribeWithOptions consumes queue with validation and upcasting when the
+// underlying queue supports subscribe options
+func (q *ValidatingQueue) SubscribeWithOptions(ctx context.Context, queue string, handler MessageHandler, opts *SubscribeOptions) error {
+	subscriber, ok := q.MessageQueue.(interface {
+		SubscribeWithOptions(ctx context.Context, queue string, handler MessageHandler, opts *SubscribeOptions) error
+	})
+	if !ok {
+		return q.Subscribe(ctx, queue, handler)
+	}
+	return subscriber.SubscribeWithOptions(ctx, queue, q.wrap(queue, handler), opts)
+}
+
+// wrap upcasts each message before calling handler and quarantines invalid ones;
+// a quarantined message is acknowledged, unless publishing to quarantine fails
+func (q *ValidatingQueue) wrap(queue string, handler MessageHandler) MessageHandler {
+	return func(topic string, message []byte) error {
+		current, err := q.registry.Upcast(message)
+		if err != nil {
+			if qErr := q.quarantine(context.Background(), "consume", topic, queue, message, err); qErr != nil {
+				return qErr
+			}
+			return nil
+		}
+		return handler(topic, current)
+	}
+}
+
+// quarantine publishes the invalid message to the quarantine routing key
+func (q *ValidatingQueue) quarantine(ctx context.Context, stage, routingKey, queue string, message []byte, cause error) error {
+	quarantined := QuarantinedEvent{
+		Stage:         stage,
+		RoutingKey:    routingKey,
+		Queue:         queue,
+		Reason:        cause.Error(),
+		QuarantinedAt: time.Now().UTC(),
+	}
+	if json.Valid(message) {
+		quarantined.Payload = json.RawMessage(message)
+	} else {
+		quarantined.RawPayload = string(message)
+	}
+
+	body, err := json.Marshal(quarantined)
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantined event: %w", err)
+	}
+	log.Printf("[PubSub] Quarantining event on %s (%s): %v", routingKey, stage, cause)
+	if err := q.MessageQueue.Publish(ctx, q.exchange, QuarantineRoutingKeyPrefix+routingKey, body); err != nil {
+		return fmt.Errorf("failed to publish quarantined event: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RiskKind 風險來源類型
+type RiskKind string
+
+const (
+	// RiskSourceIP 以來源 IP 追蹤風險（AnalysisEngine 的威脅結果）
+	RiskSourceIP RiskKind = "ip"
+	// RiskSourceUser 以用戶追蹤風險（BehaviorBaseline 的異常偵測）
+	RiskSourceUser RiskKind = "user"
+)
+
+// RiskTier 風險等級與對應的限制倍率
+type RiskTier struct {
+	Name     string  `json:"name"`
+	MinScore float64 `json:"min_score"` // 風險分數達到此值即屬於此等級
+	Factor   float64 `json:"factor"`    // 限制乘上的倍率
+}
+
+// riskTiers 由高到低排列；分數低於 0.3 不調整限制
+var riskTiers = []RiskTier{
+	{Name: "critical", MinScore: 0.85, Factor: 0.1},
+	{Name: "high", MinScore: 0.6, Factor: 0.25},
+	{Name: "elevated", MinScore: 0.3, Factor: 0.5},
+	{Name: "normal", MinScore: 0, Factor: 1},
+}
+
+// RiskTiers 返回所有風險等級（由高到低）
+func RiskTiers() []RiskTier {
+	tiers := make([]RiskTier, len(riskTiers))
+	copy(tiers, riskTiers)
+	return tiers
+}
+
+// tierForScore 分數所屬的風險等級
+func tierForScore(score float64) RiskTier {
+	for _, tier := range riskTiers {
+		if score >= tier.MinScore {
+			return tier
+		}
+	}
+	return riskTiers[len(riskTiers)-1]
+}
+
+// SeverityScore 將威脅嚴重程度（low/medium/high/critical）轉為風險分數
+func SeverityScore(severity string) float64 {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return 1.0
+	case "high":
+		return 0.75
+	case "medium":
+		return 0.5
+	case "low":
+		return 0.25
+	default:
+		return 0
+	}
+}
+
+// riskKey 風險分數的索引
+type riskKey struct {
+	kind RiskKind
+	id   string
+}
+
+// riskScore 回報時的分數，之後依半衰期衰減
+type riskScore struct {
+	score float64
+	at    time.Time
+}
+
+// RiskTracker tracks decaying risk scores per source IP and user
+// 追蹤每個來源的風險分數，分數依半衰期衰減，衰減到 normal 等級後移除
+type RiskTracker struct {
+	halfLife  time.Duration
+	scores    map[riskKey]riskScore
+	lastSweep time.Time
+	mu        sync.Mutex
+	now       func() time.Time
+}
+
+// NewRiskTracker creates a risk tracker; halfLife defaults to 10 minutes
+func NewRiskTracker(halfLife time.Duration) *RiskTracker {
+	if halfLife <= 0 {
+		halfLife = 10 * time.Minute
+	}
+	return &RiskTracker{
+		halfLife: halfLife,
+		scores:   make(map[riskKey]riskScore),
+		now:      time.Now,
+	}
+}
+
+// Report records a risk score (0-1) for a source; the higher of the current and reported score is kept
+func (rt *RiskTracker) Report(kind RiskKind, id string, score float64) {
+	if id == "" || score <= 0 {
+		return
+	}
+	score = math.Min(score, 1)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	now := rt.now()
+	rt.sweep(now)
+	key := riskKey{kind: kind, id: id}
+	if current, exists := rt.scores[key]; exists && rt.decay(current, now) >= score {
+		return
+	}
+	rt.scores[key] = riskScore{score: score, at: now}
+}
+
+// ReportSeverity records a threat severity (low/medium/high/critical) for a source
+func (rt *RiskTracker) ReportSeverity(kind RiskKind, id, severity string) {
+	rt.Report(kind, id, SeverityScore(severity))
+}
+
+// Clear removes the risk score of a source (e.g. after a false positive)
+func (rt *RiskTracker) Clear(kind RiskKind, id string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	delete(rt.scores, riskKey{kind: kind, id: id})
+}
+
+// Score returns the current (decayed) risk score of a source
+func (rt *RiskTracker) Score(kind RiskKind, id string) float64 {
+	if rt == nil || id == "" {
+		return 0
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	current, exists := rt.scores[riskKey{kind: kind, id: id}]
+	if !exists {
+		return 0
+	}
+	return rt.decay(current, rt.now())
+}
+
+// Tier returns the risk tier of a request from ip and userID (the riskier of the two)
+func (rt *RiskTracker) Tier(ip, userID string) RiskTier {
+	return tierForScore(math.Max(rt.Score(RiskSourceIP, ip), rt.Score(RiskSourceUser, userID)))
+}
+
+// Restricted returns the number of sources per kind whose limits are currently reduced
+func (rt *RiskTracker) Restricted() map[RiskKind]int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	now := rt.now()
+	counts := map[RiskKind]int{RiskSourceIP: 0, RiskSourceUser: 0}
+	for key, score := range rt.scores {
+		if tierForScore(rt.decay(score, now)).Factor < 1 {
+			counts[key.kind]++
+		}
+	}
+	return counts
+}
+
+// decay 依半衰期計算目前分數
+func (rt *RiskTracker) decay(score riskScore, now time.Time) float64 {
+	elapsed := now.Sub(score.at)
+	if elapsed <= 0 {
+		return score.score
+	}
+	return score.score * math.Pow(0.5, float64(elapsed)/float64(rt.halfLife))
+}
+
+// sweep 清除已衰減到不影響限制的來源
+func (rt *RiskTracker) sweep(now time.Time) {
+	if now.Sub(rt.lastSweep) < sweepInterval {
+		return
+	}
+	rt.lastSweep = now
+	for key, score := range rt.scores {
+		if tierForScore(rt.decay(score, now)).Factor >= 1 {
+			delete(rt.scores, key)
+		}
+	}
+}
+
+// scaledLimiter 可在同一份狀態上以縮小的限制判斷；所有內建演算法皆實作此介面
+type scaledLimiter interface {
+	Limiter
+	// allowScaled 以 factor (0-1] 倍的限制檢查 key，與 Allow 共用同一個 key 的狀態
+	allowScaled(ctx context.Context, key string, factor float64) (Result, error)
+}
+
+// AdaptiveLimiter scales a limit down for risky sources
+// 依來源的風險等級縮小限制；所有等級共用同一個 key 的狀態，來源變為高風險時不會得到新的額度
+type AdaptiveLimiter struct {
+	config  LimitConfig
+	limiter scaledLimiter
+	risk    *RiskTracker
+}
+
+// NewAdaptiveLimiter creates an adaptive limiter; risk may be nil to always use the configured limit
+func NewAdaptiveLimiter(config LimitConfig, client *redis.Client, risk *RiskTracker) (*AdaptiveLimiter, error) {
+	limiter, err := NewLimiter(config, client)
+	if err != nil {
+		return nil, err
+	}
+	scaled, ok := limiter.(scaledLimiter)
+	if !ok {
+		return nil, fmt.Errorf("rate limit algorithm %s does not support risk scaling", config.Algorithm)
+	}
+	return &AdaptiveLimiter{
+		config:  config.withDefaults(),
+		limiter: scaled,
+		risk:    risk,
+	}, nil
+}
+
+// Allow checks key against the configured limit
+func (a *AdaptiveLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return a.AllowFrom(ctx, key, "", "")
+}
+
+// AllowFrom checks key against the limit for the risk tier of the request source
+func (a *AdaptiveLimiter) AllowFrom(ctx context.Context, key, ip, userID string) (Result, error) {
+	tier := a.risk.Tier(ip, userID)
+	if tier.Factor >= 1 {
+		return a.limiter.Allow(ctx, key)
+	}
+	return a.limiter.allowScaled(ctx, key, tier.Factor)
+}
+
+// EffectiveLimit returns the limit applied to the given tier
+func (a *AdaptiveLimiter) EffectiveLimit(tier RiskTier) int64 {
+	return scaleLimit(a.config.Limit, tier.Factor)
+}
+
+// scaleLimit 依倍率縮小限制（至少 1）
+func scaleLimit(limit int64, factor float64) int64 {
+	if factor >= 1 {
+		return limit
+	}
+	scaled := int64(math.Floor(float64(limit) * factor))
+	if scaled < 1 {
+		scaled = 1
+	}
+	return scaled
+}
+
+// scaledCost 令牌桶在倍率下每次請求消耗的令牌數，使容量約為 scaleLimit(capacity, factor) 次
+func scaledCost(capacity int64, factor float64) int64 {
+	scaled := scaleLimit(capacity, factor)
+	return (capacity + scaled - 1) / scaled
+}
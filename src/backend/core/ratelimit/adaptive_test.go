@@ -0,0 +1,338 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRiskTrackerDecay(t *testing.T) {
+	clock := newTestClock()
+	risk := NewRiskTracker(10 * time.Minute)
+	risk.now = clock.Now
+
+	risk.Report(RiskSourceIP, "10.0.0.1", 0.9)
+	risk.ReportSeverity(RiskSourceUser, "alice", "medium")
+	risk.ReportSeverity(RiskSourceIP, "10.0.0.2", "low")
+	assert.Equal(t, "critical", risk.Tier("10.0.0.1", "").Name)
+	assert.Equal(t, "elevated", risk.Tier("", "alice").Name)
+	assert.Equal(t, "normal", risk.Tier("10.0.0.2", "").Name, "low severity does not reduce limits")
+	assert.Equal(t, "critical", risk.Tier("10.0.0.1", "alice").Name, "the riskier source wins")
+	assert.Equal(t, map[RiskKind]int{RiskSourceIP: 1, RiskSourceUser: 1}, risk.Restricted())
+
+	// 較低的新分數不會覆蓋目前分數
+	risk.Report(RiskSourceIP, "10.0.0.1", 0.4)
+	assert.InDelta(t, 0.9, risk.Score(RiskSourceIP, "10.0.0.1"), 1e-9)
+
+	// 每個半衰期減半
+	clock.Advance(10 * time.Minute)
+	assert.InDelta(t, 0.45, risk.Score(RiskSourceIP, "10.0.0.1"), 1e-9)
+	assert.Equal(t, "elevated", risk.Tier("10.0.0.1", "").Name)
+	assert.Equal(t, "normal", risk.Tier("", "alice").Name)
+
+	clock.Advance(10 * time.Minute)
+	assert.Equal(t, "normal", risk.Tier("10.0.0.1", "").Name)
+	assert.Equal(t, map[RiskKind]int{RiskSourceIP: 0, RiskSourceUser: 0}, risk.Restricted())
+
+	// 衰減後的來源會被清除
+	risk.Report(RiskSourceIP, "10.0.0.3", 1)
+	assert.Len(t, risk.scores, 1)
+	risk.Clear(RiskSourceIP, "10.0.0.3")
+	assert.Zero(t, risk.Score(RiskSourceIP, "10.0.0.3"))
+
+	var disabled *RiskTracker
+	assert.Equal(t, "normal", disabled.Tier("10.0.0.1", "alice").Name)
+}
+
+func TestAdaptiveLimiterTightensRiskySources(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+	for _, algorithm := range []Algorithm{AlgorithmTokenBucket, AlgorithmSlidingWindowLog, AlgorithmSlidingWindowCounter, AlgorithmGCRA} {
+		risk := NewRiskTracker(time.Hour)
+		limiter, err := NewAdaptiveLimiter(LimitConfig{Algorithm: algorithm, Limit: 20, Period: time.Minute, KeyPrefix: fmt.Sprintf("adaptive:%s:", algorithm)}, client, risk)
+		require.NoError(t, err)
+		risk.Report(RiskSourceIP, "10.0.0.66", 0.7)
+
+		count := func(ip string) (int, int64) {
+			allowed, limit := 0, int64(0)
+			for i := 0; i < 30; i++ {
+				result, err := limiter.AllowFrom(ctx, ip, ip, "")
+				require.NoError(t, err)
+				limit = result.Limit
+				if result.Allowed {
+					allowed++
+				}
+			}
+			return allowed, limit
+		}
+
+		allowed, limit := count("10.0.0.1")
+		assert.Equal(t, 20, allowed, algorithm)
+		assert.Equal(t, int64(20), limit, algorithm)
+		allowed, limit = count("10.0.0.66")
+		assert.Equal(t, 5, allowed, algorithm)
+		assert.Equal(t, int64(5), limit, algorithm)
+		assert.Equal(t, int64(2), limiter.EffectiveLimit(tierForScore(1)), algorithm)
+	}
+
+	_, err := NewAdaptiveLimiter(LimitConfig{Algorithm: "leaky", Limit: 1, Period: time.Second}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestAdaptiveLimiterSharesStateAcrossTiers(t *testing.T) {
+	ctx := context.Background()
+	client := newTestRedis(t)
+	// 已使用 20 次中的 10 次後被標記為 high (0.25 倍)：視窗演算法門檻降為 5 已超過，
+	// 令牌桶與 GCRA 剩餘的 10 單位額度每次消耗 4 單位
+	remaining := map[Algorithm]int{
+		AlgorithmTokenBucket:          2,
+		AlgorithmSlidingWindowLog:     0,
+		AlgorithmSlidingWindowCounter: 0,
+		AlgorithmGCRA:                 2,
+	}
+	for algorithm, expected := range remaining {
+		for name, redisClient := range map[string]*redis.Client{"memory": nil, "redis": client} {
+			risk := NewRiskTracker(time.Hour)
+			limiter, err := NewAdaptiveLimiter(LimitConfig{Algorithm: algorithm, Limit: 20, Period: time.Minute, KeyPrefix: fmt.Sprintf("shared:%s:", algorithm)}, redisClient, risk)
+			require.NoError(t, err)
+
+			for i := 0; i < 10; i++ {
+				result, err := limiter.AllowFrom(ctx, "10.0.0.7", "10.0.0.7", "")
+				require.NoError(t, err)
+				require.True(t, result.Allowed, "%s/%s", algorithm, name)
+			}
+			risk.ReportSeverity(RiskSourceIP, "10.0.0.7", "high")
+
+			allowed := 0
+			for i := 0; i < 10; i++ {
+				result, err := limiter.AllowFrom(ctx, "10.0.0.7", "10.0.0.7", "")
+				require.NoError(t, err)
+				if result.Allowed {
+					allowed++
+				}
+			}
+			assert.Equal(t, expected, allowed, "%s/%s", algorithm, name)
+		}
+	}
+}
+
+func TestConcurrencyLimiterAIMD(t *testing.T) {
+	clock := newTestClock()
+	cl := NewConcurrencyLimiter(AIMDConfig{
+		InitialLimit:       10,
+		MinLimit:           2,
+		MaxLimit:           12,
+		LatencyThreshold:   100 * time.Millisecond,
+		ErrorRateThreshold: 0.2,
+		Window:             time.Second,
+	})
+	cl.now = clock.Now
+
+	for i := 0; i < 10; i++ {
+		require.True(t, cl.Acquire())
+	}
+	assert.False(t, cl.Acquire())
+	assert.Equal(t, 10, cl.Inflight())
+
+	// 延遲過高：乘法遞減
+	for i := 0; i < 10; i++ {
+		clock.Advance(100 * time.Millisecond)
+		cl.Release(300*time.Millisecond, false)
+	}
+	assert.Equal(t, 0, cl.Inflight())
+	assert.Equal(t, 7, cl.Limit())
+
+	// 錯誤率過高：持續遞減但不低於下限
+	for window := 0; window < 5; window++ {
+		for i := 0; i < 5; i++ {
+			clock.Advance(250 * time.Millisecond)
+			cl.Observe(10*time.Millisecond, i%2 == 0)
+		}
+	}
+	assert.Equal(t, 2, cl.Limit())
+
+	// 健康且使用率高：每個視窗加一，不超過上限
+	for window := 0; window < 20; window++ {
+		for cl.Acquire() {
+		}
+		for cl.Inflight() > 0 {
+			clock.Advance(100 * time.Millisecond)
+			cl.Release(10*time.Millisecond, false)
+		}
+		clock.Advance(time.Second)
+		cl.Observe(10*time.Millisecond, false)
+	}
+	assert.Equal(t, 12, cl.Limit())
+
+	// 健康但閒置時不再調整
+	cl.limit = 8
+	for i := 0; i < 10; i++ {
+		clock.Advance(time.Second)
+		cl.Observe(10*time.Millisecond, false)
+	}
+	assert.Equal(t, 8, cl.Limit())
+}
+
+func TestMultiLevelRateLimiterRisk(t *testing.T) {
+	ctx := context.Background()
+	mrl := NewMultiLevelRateLimiter(&RateLimitConfig{UserAlgorithm: AlgorithmSlidingWindowLog, UserRequestsPerHour: 10}, nil)
+	defer mrl.Close()
+	risk := NewRiskTracker(time.Hour)
+	mrl.SetRiskTracker(risk)
+	risk.ReportSeverity(RiskSourceIP, "10.0.0.66", "critical")
+	risk.ReportSeverity(RiskSourceUser, "mallory", "high")
+
+	countIP := func(ip string) int {
+		allowed := 0
+		for i := 0; i < 70; i++ {
+			ok, err := mrl.AllowIP(ctx, ip)
+			require.NoError(t, err)
+			if ok {
+				allowed++
+			}
+		}
+		return allowed
+	}
+	assert.Equal(t, 60, countIP("10.0.0.1"))
+	assert.Equal(t, 6, countIP("10.0.0.66"))
+
+	// 變為高風險後沿用同一個桶，不會得到新的額度
+	for i := 0; i < 30; i++ {
+		ok, err := mrl.AllowIP(ctx, "10.0.0.77")
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	risk.ReportSeverity(RiskSourceIP, "10.0.0.77", "critical")
+	assert.Equal(t, 3, countIP("10.0.0.77"))
+
+	countUser := func(userID string) int {
+		allowed := 0
+		for i := 0; i < 20; i++ {
+			ok, err := mrl.AllowUser(ctx, userID)
+			require.NoError(t, err)
+			if ok {
+				allowed++
+			}
+		}
+		return allowed
+	}
+	assert.Equal(t, 10, countUser("alice"))
+	assert.Equal(t, 2, countUser("mallory"))
+
+	allowed, reason, err := mrl.CheckAll(ctx, "10.0.0.66", "/api/events", "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, "ip_rate_limit_exceeded", reason)
+}
+
+func TestMiddlewareAdaptive(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewMiddleware(nil, &MiddlewareConfig{
+		KeyStrategy:  "ip",
+		StatusCode:   http.StatusTooManyRequests,
+		ErrorMessage: "Too many requests",
+		RetryAfter:   true,
+		Levels: []LevelConfig{
+			{Name: "ip", Algorithm: AlgorithmSlidingWindowLog, Limit: 20, Period: time.Minute},
+		},
+		Adaptive: AdaptiveConfig{
+			RiskEnabled: true,
+			Concurrency: AIMDConfig{Enabled: true, InitialLimit: 1, MinLimit: 1, MaxLimit: 1},
+		},
+	}, nil)
+	defer m.Close()
+	require.NotNil(t, m.RiskTracker())
+	require.NotNil(t, m.ConcurrencyLimiter())
+	m.RiskTracker().ReportSeverity(RiskSourceIP, "10.0.0.66", "high")
+
+	release := make(chan struct{})
+	router := gin.New()
+	router.Use(m.Handler())
+	router.GET("/events", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/slow", func(c *gin.Context) {
+		<-release
+		c.Status(http.StatusOK)
+	})
+	request := func(path, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// 高風險來源的有效限制反映在 headers
+	w := request("/events", "10.0.0.1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "20", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-Concurrency-Limit"))
+	w = request("/events", "10.0.0.66")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "5", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "4", w.Header().Get("X-RateLimit-Remaining"))
+	for i := 0; i < 4; i++ {
+		require.Equal(t, http.StatusOK, request("/events", "10.0.0.66").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, request("/events", "10.0.0.66").Code)
+
+	// 併發上限已滿時返回 503
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		request("/slow", "10.0.0.2")
+	}()
+	require.Eventually(t, func() bool { return m.ConcurrencyLimiter().Inflight() == 1 }, time.Second, time.Millisecond)
+	w = request("/events", "10.0.0.3")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 0, m.ConcurrencyLimiter().Inflight())
+
+	// Prometheus 指標
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(m))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	gauges := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			gauges[family.GetName()+labelString(metric)] = metric.GetGauge().GetValue() + metric.GetCounter().GetValue()
+		}
+	}
+	assert.Equal(t, 20.0, gauges["pandora_ratelimit_effective_limit{level=ip,tier=normal}"])
+	assert.Equal(t, 5.0, gauges["pandora_ratelimit_effective_limit{level=ip,tier=high}"])
+	assert.Equal(t, 2.0, gauges["pandora_ratelimit_effective_limit{level=ip,tier=critical}"])
+	assert.Equal(t, 1.0, gauges["pandora_ratelimit_restricted_sources{kind=ip}"])
+	assert.Equal(t, 1.0, gauges["pandora_ratelimit_concurrency_limit"])
+	assert.Equal(t, 0.0, gauges["pandora_ratelimit_inflight_requests"])
+	assert.Equal(t, 1.0, gauges["pandora_ratelimit_rejected_total{level=ip}"])
+	assert.Equal(t, 1.0, gauges["pandora_ratelimit_rejected_total{level=concurrency}"])
+}
+
+// labelString 以 {name=value,...} 表示指標標籤
+func labelString(metric *dto.Metric) string {
+	if len(metric.GetLabel()) == 0 {
+		return ""
+	}
+	s := "{"
+	for i, label := range metric.GetLabel() {
+		if i > 0 {
+			s += ","
+		}
+		s += label.GetName() + "=" + label.GetValue()
+	}
+	return s + "}"
+}
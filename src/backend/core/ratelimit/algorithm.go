@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Algorithm 速率限制演算法
+type Algorithm string
+
+const (
+	// AlgorithmTokenBucket 令牌桶（既有的 TokenBucket / RedisTokenBucket）
+	AlgorithmTokenBucket Algorithm = "token_bucket"
+	// AlgorithmSlidingWindowLog 滑動視窗日誌：精確的「每 Period 最多 Limit 次」
+	AlgorithmSlidingWindowLog Algorithm = "sliding_window_log"
+	// AlgorithmSlidingWindowCounter 滑動視窗計數器：以前一個視窗加權估算，記憶體固定
+	AlgorithmSlidingWindowCounter Algorithm = "sliding_window_counter"
+	// AlgorithmGCRA 通用信元速率演算法：平均每 Period/Limit 一次，可突發 Burst 次
+	AlgorithmGCRA Algorithm = "gcra"
+)
+
+// Limiter 以 key 區分的速率限制器
+type Limiter interface {
+	// Allow 嘗試為 key 消耗一次額度
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// Result 一次限制判斷的結果
+type Result struct {
+	Allowed   bool  `json:"allowed"`
+	Limit     int64 `json:"limit"`
+	Remaining int64 `json:"remaining"`
+	// RetryAfter 被拒絕時最早可重試的等待時間
+	RetryAfter time.Duration `json:"retry_after"`
+	// ResetAfter 沒有新請求時額度完全恢復的等待時間
+	ResetAfter time.Duration `json:"reset_after"`
+}
+
+// LimitConfig 單一限制器配置
+type LimitConfig struct {
+	Algorithm Algorithm     `yaml:"algorithm" json:"algorithm"`
+	Limit     int64         `yaml:"limit" json:"limit"`   // 每個 Period 允許的請求數
+	Period    time.Duration `yaml:"period" json:"period"` // 時間長度
+	Burst     int64         `yaml:"burst" json:"burst"`   // GCRA 與令牌桶的突發容量（預設 Limit）
+
+	// KeyPrefix Redis key 前綴（預設 "ratelimit:"）
+	KeyPrefix string `yaml:"key_prefix" json:"key_prefix"`
+}
+
+// NewLimiter 依演算法建立限制器；client 不為 nil 時使用 Redis 實作，多個實例共享額度
+func NewLimiter(config LimitConfig, client *redis.Client) (Limiter, error) {
+	if config.Limit <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive, got %d", config.Limit)
+	}
+	if config.Period <= 0 {
+		return nil, fmt.Errorf("rate limit period must be positive, got %s", config.Period)
+	}
+	config = config.withDefaults()
+
+	switch config.Algorithm {
+	case AlgorithmTokenBucket, "":
+		// 令牌桶每個 Period 補充 Limit 個令牌，低於每秒一個的速率也不會被捨入
+		return NewTokenBucketAlgorithm(config.Burst, config.Limit, config.Period, client, config.KeyPrefix), nil
+	case AlgorithmSlidingWindowLog:
+		if client != nil {
+			return NewRedisSlidingWindowLog(client, config.KeyPrefix, config.Limit, config.Period), nil
+		}
+		return NewSlidingWindowLog(config.Limit, config.Period), nil
+	case AlgorithmSlidingWindowCounter:
+		if client != nil {
+			return NewRedisSlidingWindowCounter(client, config.KeyPrefix, config.Limit, config.Period), nil
+		}
+		return NewSlidingWindowCounter(config.Limit, config.Period), nil
+	case AlgorithmGCRA:
+		if client != nil {
+			return NewRedisGCRA(client, config.KeyPrefix, config.Limit, config.Period, config.Burst), nil
+		}
+		return NewGCRA(config.Limit, config.Period, config.Burst), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm: %s", config.Algorithm)
+	}
+}
+
+// withDefaults 補齊 Burst 與 KeyPrefix 的預設值
+func (c LimitConfig) withDefaults() LimitConfig {
+	if c.Burst <= 0 {
+		c.Burst = c.Limit
+	}
+	if c.KeyPrefix == "" {
+		c.KeyPrefix = "ratelimit:"
+	}
+	return c
+}
+
+// 記憶體實作每隔 sweepInterval 清除閒置的 key
+const sweepInterval = time.Minute
+
+// micros 轉換為 Redis 腳本使用的微秒整數；記憶體與 Redis 實作使用相同的算術以得到相同結果
+func micros(d time.Duration) int64 {
+	return d.Microseconds()
+}
+
+// fromMicros 微秒轉為 time.Duration
+func fromMicros(us int64) time.Duration {
+	return time.Duration(us) * time.Microsecond
+}
+
+// ttlMillis Redis key 的過期毫秒數（至少 1）
+func ttlMillis(us int64) int64 {
+	ms := (us + 999) / 1000
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
+
+// newInstanceID 產生實例識別碼，用於 Redis 日誌成員的唯一性
+func newInstanceID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// scriptResult 解析 Lua 腳本返回的 {allowed, remaining, retry_us, reset_us}
+func scriptResult(values []interface{}, limit int64) (Result, error) {
+	if len(values) != 4 {
+		return Result{}, fmt.Errorf("unexpected rate limit script result: %v", values)
+	}
+	numbers := make([]int64, len(values))
+	for i, value := range values {
+		number, ok := value.(int64)
+		if !ok {
+			return Result{}, fmt.Errorf("unexpected rate limit script result: %v", values)
+		}
+		numbers[i] = number
+	}
+	return Result{
+		Allowed:    numbers[0] == 1,
+		Limit:      limit,
+		Remaining:  numbers[1],
+		RetryAfter: fromMicros(numbers[2]),
+		ResetAfter: fromMicros(numbers[3]),
+	}, nil
+}
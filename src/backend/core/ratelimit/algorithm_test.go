@@ -0,0 +1,514 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClock 可控制的時鐘
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2026, 5, 1, 12, 0, 0, 123456000, time.UTC)}
+}
+
+func newTestRedis(t *testing.T) *redis.Client {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// algorithmCase 建立使用指定時鐘的記憶體與 Redis 限制器
+type algorithmCase struct {
+	name   string
+	limit  int64
+	period time.Duration
+	memory func(clock *testClock) Limiter
+	redis  func(client *redis.Client, clock *testClock) Limiter
+}
+
+func algorithmCases() []algorithmCase {
+	return []algorithmCase{
+		{
+			name:   "sliding_window_log",
+			limit:  5,
+			period: time.Second,
+			memory: func(clock *testClock) Limiter {
+				l := NewSlidingWindowLog(5, time.Second)
+				l.now = clock.Now
+				return l
+			},
+			redis: func(client *redis.Client, clock *testClock) Limiter {
+				l := NewRedisSlidingWindowLog(client, "test:log:", 5, time.Second)
+				l.now = clock.Now
+				return l
+			},
+		},
+		{
+			name:   "sliding_window_counter",
+			limit:  5,
+			period: time.Second,
+			memory: func(clock *testClock) Limiter {
+				c := NewSlidingWindowCounter(5, time.Second)
+				c.now = clock.Now
+				return c
+			},
+			redis: func(client *redis.Client, clock *testClock) Limiter {
+				c := NewRedisSlidingWindowCounter(client, "test:counter:", 5, time.Second)
+				c.now = clock.Now
+				return c
+			},
+		},
+		{
+			name:   "token_bucket",
+			limit:  5,
+			period: time.Second,
+			memory: func(clock *testClock) Limiter {
+				b := NewTokenBucketAlgorithm(3, 5, time.Second, nil, "test:bucket:")
+				b.now = clock.Now
+				return b
+			},
+			redis: func(client *redis.Client, clock *testClock) Limiter {
+				b := NewTokenBucketAlgorithm(3, 5, time.Second, client, "test:bucket:")
+				b.now = clock.Now
+				return b
+			},
+		},
+		{
+			name:   "gcra",
+			limit:  5,
+			period: time.Second,
+			memory: func(clock *testClock) Limiter {
+				g := NewGCRA(5, time.Second, 3)
+				g.now = clock.Now
+				return g
+			},
+			redis: func(client *redis.Client, clock *testClock) Limiter {
+				g := NewRedisGCRA(client, "test:gcra:", 5, time.Second, 3)
+				g.now = clock.Now
+				return g
+			},
+		},
+	}
+}
+
+// randomGap 隨機的請求間隔，偏向密集以觸發限制
+func randomGap(rng *rand.Rand, period time.Duration) time.Duration {
+	switch rng.Intn(4) {
+	case 0:
+		return 0
+	case 1:
+		return time.Duration(rng.Int63n(int64(period / 20)))
+	case 2:
+		return time.Duration(rng.Int63n(int64(period / 2)))
+	default:
+		return time.Duration(rng.Int63n(int64(2 * period)))
+	}
+}
+
+func TestAlgorithmsMemoryMatchesRedis(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range algorithmCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			for seed := int64(1); seed <= 5; seed++ {
+				rng := rand.New(rand.NewSource(seed))
+				clock := newTestClock()
+				memory := tc.memory(clock)
+				distributed := tc.redis(newTestRedis(t), clock)
+
+				for i := 0; i < 300; i++ {
+					clock.Advance(randomGap(rng, tc.period))
+					key := fmt.Sprintf("key-%d", rng.Intn(3))
+					expected, err := memory.Allow(ctx, key)
+					require.NoError(t, err)
+					actual, err := distributed.Allow(ctx, key)
+					require.NoError(t, err)
+					require.Equal(t, expected, actual, "seed %d request %d", seed, i)
+				}
+			}
+		})
+	}
+}
+
+func TestAlgorithmsColdBurst(t *testing.T) {
+	ctx := context.Background()
+	burst := map[string]int64{"sliding_window_log": 5, "sliding_window_counter": 5, "token_bucket": 3, "gcra": 3}
+	for _, tc := range algorithmCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newTestClock()
+			for name, limiter := range map[string]Limiter{"memory": tc.memory(clock), "redis": tc.redis(newTestRedis(t), clock)} {
+				allowed := int64(0)
+				for i := 0; i < 20; i++ {
+					result, err := limiter.Allow(ctx, "burst")
+					require.NoError(t, err)
+					if result.Allowed {
+						allowed++
+						assert.Equal(t, burst[tc.name]-allowed, result.Remaining, name)
+					}
+				}
+				assert.Equal(t, burst[tc.name], allowed, name)
+
+				// 其他 key 不受影響
+				result, err := limiter.Allow(ctx, "other")
+				require.NoError(t, err)
+				assert.True(t, result.Allowed, name)
+			}
+		})
+	}
+}
+
+func TestAlgorithmsRetryAfter(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range algorithmCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(42))
+			clock := newTestClock()
+			limiter := tc.memory(clock)
+			denials := 0
+
+			for i := 0; i < 2000; i++ {
+				clock.Advance(randomGap(rng, tc.period))
+				result, err := limiter.Allow(ctx, "retry")
+				require.NoError(t, err)
+				if result.Allowed {
+					assert.Zero(t, result.RetryAfter)
+					assert.GreaterOrEqual(t, result.Remaining, int64(0))
+					continue
+				}
+				denials++
+				require.Positive(t, result.RetryAfter)
+				assert.GreaterOrEqual(t, result.ResetAfter, result.RetryAfter)
+
+				// 重試時間前仍被拒絕，重試時間到即被允許
+				clock.Advance(result.RetryAfter - time.Microsecond)
+				early, err := limiter.Allow(ctx, "retry")
+				require.NoError(t, err)
+				require.False(t, early.Allowed, "request %d allowed before retry-after", i)
+				clock.Advance(time.Microsecond)
+				late, err := limiter.Allow(ctx, "retry")
+				require.NoError(t, err)
+				require.True(t, late.Allowed, "request %d denied after retry-after", i)
+			}
+			assert.Greater(t, denials, 50)
+		})
+	}
+}
+
+func TestTokenBucketRefillsBelowOnePerSecond(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock()
+	config := LimitConfig{Algorithm: AlgorithmTokenBucket, Limit: 60, Period: time.Hour, Burst: 2}
+	for name, client := range map[string]*redis.Client{"memory": nil, "redis": newTestRedis(t)} {
+		limiter, err := NewLimiter(config, client)
+		require.NoError(t, err)
+		limiter.(*TokenBucketAlgorithm).now = clock.Now
+
+		for i := 0; i < 2; i++ {
+			result, err := limiter.Allow(ctx, "slow")
+			require.NoError(t, err)
+			require.True(t, result.Allowed, name)
+		}
+		result, err := limiter.Allow(ctx, "slow")
+		require.NoError(t, err)
+		require.False(t, result.Allowed, name)
+		assert.Equal(t, time.Minute, result.RetryAfter, name)
+		assert.Equal(t, 2*time.Minute, result.ResetAfter, name)
+
+		// 每小時 60 個令牌即每分鐘一個，不會被捨入成每秒一個
+		clock.Advance(30 * time.Second)
+		result, err = limiter.Allow(ctx, "slow")
+		require.NoError(t, err)
+		assert.False(t, result.Allowed, name)
+		clock.Advance(30 * time.Second)
+		result, err = limiter.Allow(ctx, "slow")
+		require.NoError(t, err)
+		assert.True(t, result.Allowed, name)
+	}
+}
+
+func TestTokenBucketSweepsIdleBuckets(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock()
+	limiter := NewTokenBucketAlgorithm(2, 1, time.Second, nil, "test:bucket:")
+	limiter.now = clock.Now
+
+	for i := 0; i < 100; i++ {
+		_, err := limiter.Allow(ctx, fmt.Sprintf("10.0.0.%d", i))
+		require.NoError(t, err)
+	}
+	_, err := limiter.Allow(ctx, "busy")
+	require.NoError(t, err)
+	_, err = limiter.Allow(ctx, "busy")
+	require.NoError(t, err)
+	assert.Len(t, limiter.buckets, 101)
+
+	// 閒置而補滿的桶被清除，仍在補充中的桶保留
+	clock.Advance(sweepInterval - time.Second)
+	_, err = limiter.Allow(ctx, "busy")
+	require.NoError(t, err)
+	_, err = limiter.Allow(ctx, "busy")
+	require.NoError(t, err)
+	clock.Advance(time.Second)
+	result, err := limiter.Allow(ctx, "busy")
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Len(t, limiter.buckets, 1)
+	result, err = limiter.Allow(ctx, "busy")
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "清除不影響仍在使用的桶")
+}
+
+func TestSlidingWindowLogIsExact(t *testing.T) {
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(7))
+	clock := newTestClock()
+	limiter := NewSlidingWindowLog(5, time.Second)
+	limiter.now = clock.Now
+	period := time.Second
+
+	var allowed []time.Time
+	for i := 0; i < 3000; i++ {
+		clock.Advance(randomGap(rng, period))
+		result, err := limiter.Allow(ctx, "exact")
+		require.NoError(t, err)
+
+		// 被允許與否完全取決於 (now-period, now] 內的允許數
+		inWindow := 0
+		for _, at := range allowed {
+			if at.After(clock.Now().Add(-period)) {
+				inWindow++
+			}
+		}
+		require.Equal(t, inWindow < 5, result.Allowed, "request %d", i)
+		if result.Allowed {
+			allowed = append(allowed, clock.Now())
+		}
+	}
+}
+
+func TestSlidingWindowCounterBounds(t *testing.T) {
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(11))
+	clock := newTestClock()
+	limiter := NewSlidingWindowCounter(5, time.Second)
+	limiter.now = clock.Now
+	period := time.Second
+
+	// 每個固定視窗不超過 limit，任何滑動視窗不超過 2*limit
+	perWindow := make(map[int64]int)
+	var allowed []time.Time
+	for i := 0; i < 3000; i++ {
+		clock.Advance(randomGap(rng, period))
+		result, err := limiter.Allow(ctx, "counter")
+		require.NoError(t, err)
+		if result.Allowed {
+			perWindow[clock.Now().UnixMicro()/period.Microseconds()]++
+			allowed = append(allowed, clock.Now())
+		}
+	}
+	for window, count := range perWindow {
+		assert.LessOrEqual(t, count, 5, "window %d", window)
+	}
+	assert.LessOrEqual(t, maxInWindow(allowed, period), 10)
+}
+
+func TestGCRABound(t *testing.T) {
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(13))
+	clock := newTestClock()
+	limiter := NewGCRA(5, time.Second, 3)
+	limiter.now = clock.Now
+	interval := 200 * time.Millisecond
+
+	var allowed []time.Time
+	for i := 0; i < 3000; i++ {
+		clock.Advance(randomGap(rng, time.Second))
+		result, err := limiter.Allow(ctx, "gcra")
+		require.NoError(t, err)
+		if result.Allowed {
+			allowed = append(allowed, clock.Now())
+		}
+	}
+
+	// 任何長度 L 的區間內最多 burst + L/interval 次
+	for _, window := range []time.Duration{0, interval, time.Second, 5 * time.Second} {
+		assert.LessOrEqual(t, maxInWindow(allowed, window+time.Microsecond), 3+int(window/interval), "window %s", window)
+	}
+}
+
+func TestAlgorithmsConvergeToRate(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range algorithmCases() {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newTestClock()
+			limiter := tc.memory(clock)
+
+			// 以十倍速率持續送出 100 個週期
+			step := tc.period / time.Duration(tc.limit*10)
+			allowed := 0
+			for elapsed := time.Duration(0); elapsed < 100*tc.period; elapsed += step {
+				result, err := limiter.Allow(ctx, "flood")
+				require.NoError(t, err)
+				if result.Allowed {
+					allowed++
+				}
+				clock.Advance(step)
+			}
+			assert.InDelta(t, 100*tc.limit, allowed, float64(tc.limit)*3)
+		})
+	}
+}
+
+// maxInWindow 任何長度為 window 的半開區間內的最大數量
+func maxInWindow(times []time.Time, window time.Duration) int {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	best, start := 0, 0
+	for end := range times {
+		for times[end].Sub(times[start]) >= window {
+			start++
+		}
+		if end-start+1 > best {
+			best = end - start + 1
+		}
+	}
+	return best
+}
+
+func TestNewLimiter(t *testing.T) {
+	client := newTestRedis(t)
+	for _, algorithm := range []Algorithm{AlgorithmTokenBucket, AlgorithmSlidingWindowLog, AlgorithmSlidingWindowCounter, AlgorithmGCRA} {
+		for _, c := range []*redis.Client{nil, client} {
+			limiter, err := NewLimiter(LimitConfig{Algorithm: algorithm, Limit: 3, Period: time.Minute}, c)
+			require.NoError(t, err)
+			allowed := 0
+			for i := 0; i < 5; i++ {
+				result, err := limiter.Allow(context.Background(), fmt.Sprintf("new-%s-%v", algorithm, c != nil))
+				require.NoError(t, err)
+				if result.Allowed {
+					allowed++
+				}
+			}
+			assert.Equal(t, 3, allowed, "%s redis=%v", algorithm, c != nil)
+		}
+	}
+
+	_, err := NewLimiter(LimitConfig{Algorithm: "leaky", Limit: 1, Period: time.Second}, nil)
+	assert.Error(t, err)
+	_, err = NewLimiter(LimitConfig{Algorithm: AlgorithmGCRA, Period: time.Second}, nil)
+	assert.Error(t, err)
+}
+
+func TestMultiLevelRateLimiterAlgorithms(t *testing.T) {
+	ctx := context.Background()
+	mrl := NewMultiLevelRateLimiter(&RateLimitConfig{
+		IPRequestsPerMinute: 3,
+		IPAlgorithm:         AlgorithmSlidingWindowLog,
+		UserAlgorithm:       AlgorithmGCRA,
+		UserRequestsPerHour: 2,
+	}, nil)
+	defer mrl.Close()
+
+	for i := 0; i < 3; i++ {
+		allowed, reason, err := mrl.CheckAll(ctx, "10.0.0.1", fmt.Sprintf("/api/%d", i), "")
+		require.NoError(t, err)
+		assert.True(t, allowed, reason)
+	}
+	allowed, reason, err := mrl.CheckAll(ctx, "10.0.0.1", "/api/x", "")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, "ip_rate_limit_exceeded", reason)
+
+	// 端點層級仍使用令牌桶（容量 10）
+	for i := 0; i < 10; i++ {
+		allowed, err := mrl.AllowEndpoint(ctx, "/login")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, err = mrl.AllowEndpoint(ctx, "/login")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	for i := 0; i < 2; i++ {
+		allowed, err := mrl.AllowUser(ctx, "alice")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, err = mrl.AllowUser(ctx, "alice")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestMiddlewareLevels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	m := NewMiddleware(nil, &MiddlewareConfig{
+		KeyStrategy:  "ip",
+		StatusCode:   http.StatusTooManyRequests,
+		ErrorMessage: "Too many requests",
+		RetryAfter:   true,
+		WhitelistIPs: []string{"10.0.0.9"},
+		Levels: []LevelConfig{
+			{Name: "ip", Algorithm: AlgorithmGCRA, Limit: 60, Period: time.Minute, Burst: 5},
+			{Name: "login", KeyStrategy: "endpoint", Algorithm: AlgorithmSlidingWindowLog, Limit: 3, Period: time.Minute},
+		},
+		RedisAddr: mr.Addr(),
+	}, nil)
+	defer m.Close()
+
+	router := gin.New()
+	router.Use(m.Handler())
+	router.POST("/login", func(c *gin.Context) { c.Status(http.StatusOK) })
+	request := func(ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = ip + ":1234"
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// 端點層級由所有 IP 共享，headers 取剩餘最少的層級
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"} {
+		w := request(ip)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "3", w.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, fmt.Sprint(2-i), w.Header().Get("X-RateLimit-Remaining"))
+		assert.Equal(t, "60", w.Header().Get("X-RateLimit-Reset"))
+	}
+
+	w := request("10.0.0.3")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Contains(t, w.Body.String(), `"level":"login"`)
+	assert.NotEmpty(t, mr.Keys())
+
+	// 白名單不受限制
+	assert.Equal(t, http.StatusOK, request("10.0.0.9").Code)
+
+	// 無效的層級配置不會啟用
+	invalid := NewMiddleware(nil, &MiddlewareConfig{Levels: []LevelConfig{{Name: "bad", Algorithm: "leaky", Limit: 1, Period: time.Second}}}, nil)
+	assert.Empty(t, invalid.levels)
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// AIMDConfig 自適應併發限制配置
+type AIMDConfig struct {
+	Enabled      bool `yaml:"enabled" json:"enabled" mapstructure:"enabled"`
+	InitialLimit int  `yaml:"initial_limit" json:"initial_limit" mapstructure:"initial_limit"` // 初始併發上限（預設 100）
+	MinLimit     int  `yaml:"min_limit" json:"min_limit" mapstructure:"min_limit"`             // 最低併發上限（預設 5）
+	MaxLimit     int  `yaml:"max_limit" json:"max_limit" mapstructure:"max_limit"`             // 最高併發上限（預設 1000）
+
+	// 視窗內平均延遲或錯誤率超過門檻時乘法遞減，否則加法遞增
+	LatencyThreshold   time.Duration `yaml:"latency_threshold" json:"latency_threshold" mapstructure:"latency_threshold"`          // 預設 1s
+	ErrorRateThreshold float64       `yaml:"error_rate_threshold" json:"error_rate_threshold" mapstructure:"error_rate_threshold"` // 預設 0.1
+	BackoffRatio       float64       `yaml:"backoff_ratio" json:"backoff_ratio" mapstructure:"backoff_ratio"`                      // 遞減倍率（預設 0.7）
+	Window             time.Duration `yaml:"window" json:"window" mapstructure:"window"`                                           // 評估視窗（預設 1s）
+}
+
+// ConcurrencyLimiter limits in-flight requests with an AIMD-adjusted limit
+// 依後端延遲與錯誤率以 AIMD 調整的全域併發限制
+type ConcurrencyLimiter struct {
+	config   AIMDConfig
+	limit    float64
+	inflight int
+
+	// 目前視窗的樣本
+	windowStart time.Time
+	samples     int
+	failures    int
+	latency     time.Duration
+	peak        int // 視窗內最高併發數
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewConcurrencyLimiter creates an AIMD concurrency limiter
+func NewConcurrencyLimiter(config AIMDConfig) *ConcurrencyLimiter {
+	if config.MinLimit <= 0 {
+		config.MinLimit = 5
+	}
+	if config.MaxLimit <= 0 {
+		config.MaxLimit = 1000
+	}
+	if config.MaxLimit < config.MinLimit {
+		config.MaxLimit = config.MinLimit
+	}
+	if config.InitialLimit <= 0 {
+		config.InitialLimit = 100
+	}
+	if config.LatencyThreshold <= 0 {
+		config.LatencyThreshold = time.Second
+	}
+	if config.ErrorRateThreshold <= 0 {
+		config.ErrorRateThreshold = 0.1
+	}
+	if config.BackoffRatio <= 0 || config.BackoffRatio >= 1 {
+		config.BackoffRatio = 0.7
+	}
+	if config.Window <= 0 {
+		config.Window = time.Second
+	}
+
+	limit := math.Min(math.Max(float64(config.InitialLimit), float64(config.MinLimit)), float64(config.MaxLimit))
+	return &ConcurrencyLimiter{
+		config: config,
+		limit:  limit,
+		now:    time.Now,
+	}
+}
+
+// Acquire reserves a slot; it returns false when the current limit is reached
+func (cl *ConcurrencyLimiter) Acquire() bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.windowStart.IsZero() {
+		cl.windowStart = cl.now()
+	}
+	if cl.inflight >= int(cl.limit) {
+		return false
+	}
+	cl.inflight++
+	if cl.inflight > cl.peak {
+		cl.peak = cl.inflight
+	}
+	return true
+}
+
+// Release frees a slot acquired by Acquire and records the request outcome
+func (cl *ConcurrencyLimiter) Release(latency time.Duration, failed bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.inflight > 0 {
+		cl.inflight--
+	}
+	cl.observe(latency, failed)
+}
+
+// Observe records a backend latency/error sample without holding a slot
+func (cl *ConcurrencyLimiter) Observe(latency time.Duration, failed bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.observe(latency, failed)
+}
+
+// Limit returns the current concurrency limit
+func (cl *ConcurrencyLimiter) Limit() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return int(cl.limit)
+}
+
+// Inflight returns the number of requests currently holding a slot
+func (cl *ConcurrencyLimiter) Inflight() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.inflight
+}
+
+// observe 記錄樣本，視窗結束時調整上限
+func (cl *ConcurrencyLimiter) observe(latency time.Duration, failed bool) {
+	now := cl.now()
+	if cl.windowStart.IsZero() {
+		cl.windowStart = now
+	}
+
+	cl.samples++
+	cl.latency += latency
+	if failed {
+		cl.failures++
+	}
+
+	if now.Sub(cl.windowStart) < cl.config.Window {
+		return
+	}
+
+	average := cl.latency / time.Duration(cl.samples)
+	errorRate := float64(cl.failures) / float64(cl.samples)
+	if average > cl.config.LatencyThreshold || errorRate > cl.config.ErrorRateThreshold {
+		// 乘法遞減
+		cl.limit = math.Max(cl.limit*cl.config.BackoffRatio, float64(cl.config.MinLimit))
+	} else if cl.peak*2 >= int(cl.limit) {
+		// 加法遞增；使用率不到一半時維持，避免閒置時無限成長
+		cl.limit = math.Min(cl.limit+1, float64(cl.config.MaxLimit))
+	}
+
+	cl.windowStart = now
+	cl.samples = 0
+	cl.failures = 0
+	cl.latency = 0
+	cl.peak = cl.inflight
+}
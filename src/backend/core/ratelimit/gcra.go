@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// GCRA implements the generic cell rate algorithm
+// 通用信元速率演算法：只保存理論到達時間 (TAT)，平均每 Period/Limit 允許一次，最多突發 Burst 次
+type GCRA struct {
+	limit     int64
+	period    time.Duration
+	burst     int64
+	tats      map[string]int64 // 理論到達時間（微秒）
+	lastSweep time.Time
+	mu        sync.Mutex
+	now       func() time.Time
+}
+
+// NewGCRA creates an in-memory GCRA limiter allowing limit requests per period with the given burst
+func NewGCRA(limit int64, period time.Duration, burst int64) *GCRA {
+	return &GCRA{
+		limit:  limit,
+		period: period,
+		burst:  burst,
+		tats:   make(map[string]int64),
+		now:    time.Now,
+	}
+}
+
+// Allow checks if a request for key is allowed
+func (g *GCRA) Allow(ctx context.Context, key string) (Result, error) {
+	return g.allowScaled(ctx, key, 1)
+}
+
+// allowScaled 在同一個 TAT 上以放大的發射間隔檢查
+func (g *GCRA) allowScaled(ctx context.Context, key string, factor float64) (Result, error) {
+	burst, interval, tolerance := gcraScaled(g.limit, g.period, g.burst, factor)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+	nowUs := now.UnixMicro()
+
+	tat, exists := g.tats[key]
+	if !exists || tat < nowUs {
+		tat = nowUs
+	}
+	newTat := tat + interval
+	allowAt := newTat - tolerance
+	if nowUs < allowAt {
+		return Result{
+			Allowed:    false,
+			Limit:      burst,
+			Remaining:  0,
+			RetryAfter: fromMicros(allowAt - nowUs),
+			ResetAfter: fromMicros(tat - nowUs),
+		}, nil
+	}
+
+	g.tats[key] = newTat
+	return Result{
+		Allowed:    true,
+		Limit:      burst,
+		Remaining:  (nowUs - allowAt) / interval,
+		ResetAfter: fromMicros(newTat - nowUs),
+	}, nil
+}
+
+// sweep 清除 TAT 已過期（額度已完全恢復）的 key
+func (g *GCRA) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < sweepInterval {
+		return
+	}
+	g.lastSweep = now
+	nowUs := now.UnixMicro()
+	for key, tat := range g.tats {
+		if tat <= nowUs {
+			delete(g.tats, key)
+		}
+	}
+}
+
+// gcraInterval 發射間隔（微秒，至少 1）
+func gcraInterval(limit int64, period time.Duration) int64 {
+	interval := micros(period) / limit
+	if interval < 1 {
+		interval = 1
+	}
+	return interval
+}
+
+// gcraScaled 縮小倍率後的突發容量、發射間隔與容忍度；factor 為 1 時即原本的設定
+func gcraScaled(limit int64, period time.Duration, burst int64, factor float64) (int64, int64, int64) {
+	burst = scaleLimit(burst, factor)
+	interval := gcraInterval(scaleLimit(limit, factor), period)
+	return burst, interval, burst * interval
+}
+
+// gcraScript 與 GCRA.Allow 相同的邏輯
+// KEYS[1] TAT；ARGV: now, interval, tolerance
+var gcraScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local tolerance = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or ARGV[1])
+if tat < now then
+    tat = now
+end
+local new_tat = tat + interval
+local allow_at = new_tat - tolerance
+if now < allow_at then
+    return {0, 0, allow_at - now, tat - now}
+end
+
+local ttl = new_tat - now
+redis.call('SET', KEYS[1], string.format('%.0f', new_tat), 'PX', math.ceil(ttl / 1000))
+return {1, math.floor((now - allow_at) / interval), 0, ttl}
+`)
+
+// RedisGCRA implements a distributed GCRA limiter
+// 使用 Redis 的分散式 GCRA，每個 key 只保存一個 TAT
+type RedisGCRA struct {
+	redis  *redis.Client
+	prefix string
+	limit  int64
+	period time.Duration
+	burst  int64
+	now    func() time.Time
+}
+
+// NewRedisGCRA creates a Redis-based GCRA limiter
+func NewRedisGCRA(client *redis.Client, prefix string, limit int64, period time.Duration, burst int64) *RedisGCRA {
+	return &RedisGCRA{
+		redis:  client,
+		prefix: prefix,
+		limit:  limit,
+		period: period,
+		burst:  burst,
+		now:    time.Now,
+	}
+}
+
+// Allow checks if a request for key is allowed (distributed)
+func (g *RedisGCRA) Allow(ctx context.Context, key string) (Result, error) {
+	return g.allowScaled(ctx, key, 1)
+}
+
+// allowScaled 在同一個 TAT 上以放大的發射間隔檢查 (分散式)
+func (g *RedisGCRA) allowScaled(ctx context.Context, key string, factor float64) (Result, error) {
+	burst, interval, tolerance := gcraScaled(g.limit, g.period, g.burst, factor)
+	nowUs := g.now().UnixMicro()
+	values, err := gcraScript.Run(ctx, g.redis, []string{g.prefix + key},
+		nowUs, interval, tolerance).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("gcra: %w", err)
+	}
+	return scriptResult(values, burst)
+}
@@ -2,9 +2,14 @@ package ratelimit
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,6 +18,19 @@ type Middleware struct {
 	limiter *TokenBucketLimiter
 	logger  *logrus.Logger
 	config  *MiddlewareConfig
+	levels  []*middlewareLevel
+	redis   *redis.Client
+
+	// 自適應限制
+	risk        *RiskTracker
+	concurrency *ConcurrencyLimiter
+	rejected    *prometheus.CounterVec
+}
+
+// middlewareLevel 一個已建立的限制層級
+type middlewareLevel struct {
+	config  LevelConfig
+	limiter *AdaptiveLimiter
 }
 
 // MiddlewareConfig 中間件配置
@@ -28,6 +46,37 @@ type MiddlewareConfig struct {
 	// 豁免配置
 	WhitelistIPs  []string `yaml:"whitelist_ips" json:"whitelist_ips"`   // IP 白名單
 	WhitelistKeys []string `yaml:"whitelist_keys" json:"whitelist_keys"` // Key 白名單
+
+	// 多層級限制：設定後依序檢查每個層級，取代 TokenBucketLimiter 的速率檢查
+	Levels []LevelConfig `yaml:"levels" json:"levels"`
+
+	// Redis 配置（層級使用分散式限制時）
+	RedisAddr     string `yaml:"redis_addr" json:"redis_addr"`
+	RedisPassword string `yaml:"redis_password" json:"redis_password"`
+	RedisDB       int    `yaml:"redis_db" json:"redis_db"`
+
+	// 自適應限制
+	Adaptive AdaptiveConfig `yaml:"adaptive" json:"adaptive"`
+}
+
+// AdaptiveConfig 自適應限制配置
+type AdaptiveConfig struct {
+	// 來源風險升高時縮小層級限制（見 RiskTiers）
+	RiskEnabled  bool          `yaml:"risk_enabled" json:"risk_enabled" mapstructure:"risk_enabled"`
+	RiskHalfLife time.Duration `yaml:"risk_half_life" json:"risk_half_life" mapstructure:"risk_half_life"` // 風險分數半衰期（預設 10m）
+
+	// 依後端延遲與錯誤率調整的全域併發上限
+	Concurrency AIMDConfig `yaml:"concurrency" json:"concurrency" mapstructure:"concurrency"`
+}
+
+// LevelConfig 單一限制層級配置
+type LevelConfig struct {
+	Name        string        `yaml:"name" json:"name" mapstructure:"name"`
+	KeyStrategy string        `yaml:"key_strategy" json:"key_strategy" mapstructure:"key_strategy"` // "ip", "user", "ip+user", "endpoint"（預設同中間件）
+	Algorithm   Algorithm     `yaml:"algorithm" json:"algorithm" mapstructure:"algorithm"`
+	Limit       int64         `yaml:"limit" json:"limit" mapstructure:"limit"`
+	Period      time.Duration `yaml:"period" json:"period" mapstructure:"period"`
+	Burst       int64         `yaml:"burst" json:"burst" mapstructure:"burst"`
 }
 
 // NewMiddleware 建立新的中間件
@@ -45,11 +94,77 @@ func NewMiddleware(limiter *TokenBucketLimiter, config *MiddlewareConfig, logger
 		}
 	}
 
-	return &Middleware{
+	m := &Middleware{
 		limiter: limiter,
 		logger:  logger,
 		config:  config,
 	}
+	if config.RedisAddr != "" {
+		m.redis = redis.NewClient(&redis.Options{
+			Addr:     config.RedisAddr,
+			Password: config.RedisPassword,
+			DB:       config.RedisDB,
+		})
+	}
+	if config.Adaptive.RiskEnabled {
+		m.risk = NewRiskTracker(config.Adaptive.RiskHalfLife)
+	}
+	if config.Adaptive.Concurrency.Enabled {
+		m.concurrency = NewConcurrencyLimiter(config.Adaptive.Concurrency)
+	}
+	m.rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pandora_ratelimit_rejected_total",
+		Help: "Total number of requests rejected by the rate limiter",
+	}, []string{"level"})
+	if err := m.setLevels(config.Levels); err != nil {
+		m.logger.Errorf("速率限制層級配置無效，改用 Token Bucket: %v", err)
+	}
+
+	return m
+}
+
+// setLevels 建立限制層級；任一層級無效時不變更現有層級
+func (m *Middleware) setLevels(configs []LevelConfig) error {
+	levels := make([]*middlewareLevel, 0, len(configs))
+	for i, config := range configs {
+		if config.Name == "" {
+			config.Name = fmt.Sprintf("level%d", i)
+		}
+		if config.KeyStrategy == "" {
+			config.KeyStrategy = m.config.KeyStrategy
+		}
+		limiter, err := NewAdaptiveLimiter(LimitConfig{
+			Algorithm: config.Algorithm,
+			Limit:     config.Limit,
+			Period:    config.Period,
+			Burst:     config.Burst,
+			KeyPrefix: fmt.Sprintf("ratelimit:%s:", config.Name),
+		}, m.redis, m.risk)
+		if err != nil {
+			return fmt.Errorf("level %s: %w", config.Name, err)
+		}
+		levels = append(levels, &middlewareLevel{config: config, limiter: limiter})
+	}
+	m.levels = levels
+	return nil
+}
+
+// RiskTracker 返回風險追蹤器（未啟用時為 nil），供威脅偵測與行為分析回報來源風險
+func (m *Middleware) RiskTracker() *RiskTracker {
+	return m.risk
+}
+
+// ConcurrencyLimiter 返回全域併發限制器（未啟用時為 nil），可回報其他後端的延遲與錯誤
+func (m *Middleware) ConcurrencyLimiter() *ConcurrencyLimiter {
+	return m.concurrency
+}
+
+// Close 關閉層級使用的 Redis 連線
+func (m *Middleware) Close() error {
+	if m.redis != nil {
+		return m.redis.Close()
+	}
+	return nil
 }
 
 // Handler Gin 中間件處理器
@@ -64,6 +179,13 @@ func (m *Middleware) Handler() gin.HandlerFunc {
 			return
 		}
 
+		if len(m.levels) > 0 {
+			if m.checkLevels(c) {
+				m.serve(c)
+			}
+			return
+		}
+
 		// 檢查速率限制
 		allowed, err := m.limiter.Allow(key)
 		if err != nil {
@@ -89,6 +211,7 @@ func (m *Middleware) Handler() gin.HandlerFunc {
 			c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", m.limiter.config.Rate))
 			c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
 
+			m.rejected.WithLabelValues("default").Inc()
 			m.logger.Warnf("請求被限制 [%s]: %s - %s", key, c.ClientIP(), c.Request.URL.Path)
 
 			c.JSON(m.config.StatusCode, gin.H{
@@ -105,8 +228,99 @@ func (m *Middleware) Handler() gin.HandlerFunc {
 		c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", m.limiter.config.Rate))
 		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
 
+		m.serve(c)
+	}
+}
+
+// checkLevels 依序檢查每個層級，headers 取最嚴格的結果；限制依請求來源的風險等級調整
+func (m *Middleware) checkLevels(c *gin.Context) bool {
+	ip, userID := c.ClientIP(), ""
+	if uid, exists := c.Get("user_id"); exists {
+		userID = fmt.Sprint(uid)
+	}
+
+	var tightest Result
+	for i, level := range m.levels {
+		key := m.generateKeyFor(c, level.config.KeyStrategy)
+		result, err := level.limiter.AllowFrom(c.Request.Context(), key, ip, userID)
+		if err != nil {
+			m.logger.Errorf("檢查速率限制失敗 [%s/%s]: %v", level.config.Name, key, err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Internal server error",
+			})
+			c.Abort()
+			return false
+		}
+
+		if !result.Allowed {
+			m.setRateLimitHeaders(c, result)
+			if m.config.RetryAfter {
+				c.Header("Retry-After", fmt.Sprintf("%d", ceilSeconds(result.RetryAfter)))
+			}
+
+			m.rejected.WithLabelValues(level.config.Name).Inc()
+			m.logger.Warnf("請求被限制 [%s/%s]: %s - %s", level.config.Name, key, c.ClientIP(), c.Request.URL.Path)
+
+			c.JSON(m.config.StatusCode, gin.H{
+				"error":       m.config.ErrorMessage,
+				"level":       level.config.Name,
+				"key":         key,
+				"retry_after": ceilSeconds(result.RetryAfter),
+			})
+			c.Abort()
+			return false
+		}
+
+		if i == 0 || result.Remaining < tightest.Remaining {
+			tightest = result
+		}
+	}
+
+	m.setRateLimitHeaders(c, tightest)
+	return true
+}
+
+// serve 在全域併發上限內執行後續處理器，並記錄延遲與 5xx 供 AIMD 調整上限
+func (m *Middleware) serve(c *gin.Context) {
+	if m.concurrency == nil {
 		c.Next()
+		return
+	}
+
+	if !m.concurrency.Acquire() {
+		limit := m.concurrency.Limit()
+		c.Header("X-Concurrency-Limit", strconv.Itoa(limit))
+		if m.config.RetryAfter {
+			c.Header("Retry-After", "1")
+		}
+		m.rejected.WithLabelValues("concurrency").Inc()
+		m.logger.Warnf("併發數已達上限 %d: %s - %s", limit, c.ClientIP(), c.Request.URL.Path)
+
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Service overloaded, please try again later",
+		})
+		c.Abort()
+		return
 	}
+
+	c.Header("X-Concurrency-Limit", strconv.Itoa(m.concurrency.Limit()))
+	start := time.Now()
+	defer func() {
+		m.concurrency.Release(time.Since(start), c.Writer.Status() >= http.StatusInternalServerError)
+	}()
+	c.Next()
+}
+
+// setRateLimitHeaders 設定 X-RateLimit headers
+func (m *Middleware) setRateLimitHeaders(c *gin.Context, result Result) {
+	c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", result.Limit))
+	c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", result.Remaining))
+	c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", ceilSeconds(result.ResetAfter)))
+}
+
+// ceilSeconds 無條件進位的秒數
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
 }
 
 // BruteForceProtection 暴力攻擊防護中間件
@@ -146,7 +360,12 @@ func (m *Middleware) BruteForceProtection() gin.HandlerFunc {
 
 // generateKey 生成限制 key
 func (m *Middleware) generateKey(c *gin.Context) string {
-	switch m.config.KeyStrategy {
+	return m.generateKeyFor(c, m.config.KeyStrategy)
+}
+
+// generateKeyFor 依指定策略生成限制 key
+func (m *Middleware) generateKeyFor(c *gin.Context, strategy string) string {
+	switch strategy {
 	case "ip":
 		return c.ClientIP()
 	case "user":
@@ -161,6 +380,12 @@ func (m *Middleware) generateKey(c *gin.Context) string {
 			userID = fmt.Sprintf("%s:%v", userID, uid)
 		}
 		return userID
+	case "endpoint":
+		path := c.FullPath()
+		if path == "" {
+			path = c.Request.URL.Path
+		}
+		return c.Request.Method + " " + path
 	default:
 		return c.ClientIP()
 	}
@@ -194,3 +419,58 @@ func (m *Middleware) RecordFailedAuth(key string) error {
 func (m *Middleware) ResetLimit(key string) error {
 	return m.limiter.Reset(key)
 }
+
+var (
+	effectiveLimitDesc = prometheus.NewDesc(
+		"pandora_ratelimit_effective_limit",
+		"Requests per period allowed for each rate limit level and risk tier",
+		[]string{"level", "tier"}, nil,
+	)
+	restrictedSourcesDesc = prometheus.NewDesc(
+		"pandora_ratelimit_restricted_sources",
+		"Number of sources whose rate limits are currently reduced due to elevated risk",
+		[]string{"kind"}, nil,
+	)
+	concurrencyLimitDesc = prometheus.NewDesc(
+		"pandora_ratelimit_concurrency_limit",
+		"Current AIMD-adjusted limit on concurrent requests",
+		nil, nil,
+	)
+	inflightRequestsDesc = prometheus.NewDesc(
+		"pandora_ratelimit_inflight_requests",
+		"Number of requests currently being served under the concurrency limit",
+		nil, nil,
+	)
+)
+
+// Describe implements prometheus.Collector
+func (m *Middleware) Describe(ch chan<- *prometheus.Desc) {
+	ch <- effectiveLimitDesc
+	ch <- restrictedSourcesDesc
+	ch <- concurrencyLimitDesc
+	ch <- inflightRequestsDesc
+	m.rejected.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *Middleware) Collect(ch chan<- prometheus.Metric) {
+	for _, level := range m.levels {
+		for _, tier := range riskTiers {
+			if m.risk == nil && tier.Factor < 1 {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(effectiveLimitDesc, prometheus.GaugeValue,
+				float64(level.limiter.EffectiveLimit(tier)), level.config.Name, tier.Name)
+		}
+	}
+	if m.risk != nil {
+		for kind, count := range m.risk.Restricted() {
+			ch <- prometheus.MustNewConstMetric(restrictedSourcesDesc, prometheus.GaugeValue, float64(count), string(kind))
+		}
+	}
+	if m.concurrency != nil {
+		ch <- prometheus.MustNewConstMetric(concurrencyLimitDesc, prometheus.GaugeValue, float64(m.concurrency.Limit()))
+		ch <- prometheus.MustNewConstMetric(inflightRequestsDesc, prometheus.GaugeValue, float64(m.concurrency.Inflight()))
+	}
+	m.rejected.Collect(ch)
+}
@@ -0,0 +1,353 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SlidingWindowLog implements the sliding window log algorithm
+// 滑動視窗日誌：記錄每個被允許請求的時間，任何長度為 Period 的區間內最多 Limit 次
+type SlidingWindowLog struct {
+	limit     int64
+	period    int64              // 微秒
+	logs      map[string][]int64 // 被允許請求的時間（微秒，遞增）
+	lastSweep time.Time
+	mu        sync.Mutex
+	now       func() time.Time
+}
+
+// NewSlidingWindowLog creates an in-memory sliding window log limiter
+func NewSlidingWindowLog(limit int64, period time.Duration) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		limit:  limit,
+		period: micros(period),
+		logs:   make(map[string][]int64),
+		now:    time.Now,
+	}
+}
+
+// Allow checks if a request for key is allowed
+func (l *SlidingWindowLog) Allow(ctx context.Context, key string) (Result, error) {
+	return l.allowScaled(ctx, key, 1)
+}
+
+// allowScaled 以縮小的數量門檻檢查同一份日誌
+func (l *SlidingWindowLog) allowScaled(ctx context.Context, key string, factor float64) (Result, error) {
+	limit := scaleLimit(l.limit, factor)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	nowUs := now.UnixMicro()
+
+	// 移除已離開視窗 (now-period, now] 的紀錄
+	log := l.logs[key]
+	cutoff := nowUs - l.period
+	expired := 0
+	for expired < len(log) && log[expired] <= cutoff {
+		expired++
+	}
+	log = log[expired:]
+
+	count := int64(len(log))
+	if count >= limit {
+		l.logs[key] = log
+		// 需等到視窗內只剩 limit-1 筆紀錄
+		return Result{
+			Allowed:    false,
+			Limit:      limit,
+			Remaining:  0,
+			RetryAfter: fromMicros(log[count-limit] + l.period - nowUs),
+			ResetAfter: fromMicros(log[len(log)-1] + l.period - nowUs),
+		}, nil
+	}
+
+	l.logs[key] = append(log, nowUs)
+	return Result{
+		Allowed:    true,
+		Limit:      limit,
+		Remaining:  limit - count - 1,
+		ResetAfter: fromMicros(l.period),
+	}, nil
+}
+
+// sweep 清除視窗內已沒有紀錄的 key
+func (l *SlidingWindowLog) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	cutoff := now.UnixMicro() - l.period
+	for key, log := range l.logs {
+		if len(log) == 0 || log[len(log)-1] <= cutoff {
+			delete(l.logs, key)
+		}
+	}
+}
+
+// slidingWindowLogScript 與 SlidingWindowLog.Allow 相同的邏輯，使用 sorted set 保存紀錄
+// KEYS[1] 日誌；ARGV: now, cutoff, limit, member, period, ttl_ms
+var slidingWindowLogScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local limit = tonumber(ARGV[3])
+local period = tonumber(ARGV[5])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', ARGV[2])
+local count = redis.call('ZCARD', key)
+if count < limit then
+    redis.call('ZADD', key, ARGV[1], ARGV[4])
+    redis.call('PEXPIRE', key, ARGV[6])
+    return {1, limit - count - 1, 0, period}
+end
+
+local oldest = redis.call('ZRANGE', key, count - limit, count - limit, 'WITHSCORES')
+local newest = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+return {0, 0, tonumber(oldest[2]) + period - now, tonumber(newest[2]) + period - now}
+`)
+
+// RedisSlidingWindowLog implements a distributed sliding window log using a Redis sorted set
+// 使用 Redis sorted set 的分散式滑動視窗日誌
+type RedisSlidingWindowLog struct {
+	redis    *redis.Client
+	prefix   string
+	limit    int64
+	period   int64 // 微秒
+	instance string
+	seq      atomic.Uint64
+	now      func() time.Time
+}
+
+// NewRedisSlidingWindowLog creates a Redis-based sliding window log limiter
+func NewRedisSlidingWindowLog(client *redis.Client, prefix string, limit int64, period time.Duration) *RedisSlidingWindowLog {
+	return &RedisSlidingWindowLog{
+		redis:    client,
+		prefix:   prefix,
+		limit:    limit,
+		period:   micros(period),
+		instance: newInstanceID(),
+		now:      time.Now,
+	}
+}
+
+// Allow checks if a request for key is allowed (distributed)
+func (l *RedisSlidingWindowLog) Allow(ctx context.Context, key string) (Result, error) {
+	return l.allowScaled(ctx, key, 1)
+}
+
+// allowScaled 以縮小的數量門檻檢查同一份日誌 (分散式)
+func (l *RedisSlidingWindowLog) allowScaled(ctx context.Context, key string, factor float64) (Result, error) {
+	limit := scaleLimit(l.limit, factor)
+	nowUs := l.now().UnixMicro()
+	// 同一微秒可能有多個請求，成員名稱需唯一
+	member := fmt.Sprintf("%d-%s-%d", nowUs, l.instance, l.seq.Add(1))
+
+	values, err := slidingWindowLogScript.Run(ctx, l.redis, []string{l.prefix + key},
+		nowUs, nowUs-l.period, limit, member, l.period, ttlMillis(l.period)).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("sliding window log: %w", err)
+	}
+	return scriptResult(values, limit)
+}
+
+// slidingWindowState 計數器狀態：目前視窗與前一個視窗的請求數
+type slidingWindowState struct {
+	window int64
+	curr   int64
+	prev   int64
+}
+
+// SlidingWindowCounter implements the sliding window counter algorithm
+// 滑動視窗計數器：前一個固定視窗的請求數依重疊比例加權，加上目前視窗的請求數
+type SlidingWindowCounter struct {
+	limit     int64
+	period    int64 // 微秒
+	states    map[string]*slidingWindowState
+	lastSweep time.Time
+	mu        sync.Mutex
+	now       func() time.Time
+}
+
+// NewSlidingWindowCounter creates an in-memory sliding window counter limiter
+func NewSlidingWindowCounter(limit int64, period time.Duration) *SlidingWindowCounter {
+	return &SlidingWindowCounter{
+		limit:  limit,
+		period: micros(period),
+		states: make(map[string]*slidingWindowState),
+		now:    time.Now,
+	}
+}
+
+// Allow checks if a request for key is allowed
+func (c *SlidingWindowCounter) Allow(ctx context.Context, key string) (Result, error) {
+	return c.allowScaled(ctx, key, 1)
+}
+
+// allowScaled 以縮小的數量門檻檢查同一組計數器
+func (c *SlidingWindowCounter) allowScaled(ctx context.Context, key string, factor float64) (Result, error) {
+	limit := scaleLimit(c.limit, factor)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.sweep(now)
+	nowUs := now.UnixMicro()
+	window := nowUs / c.period
+	elapsed := nowUs - window*c.period
+
+	state, exists := c.states[key]
+	if !exists {
+		state = &slidingWindowState{window: window}
+		c.states[key] = state
+	}
+	if state.window != window {
+		if state.window == window-1 {
+			state.prev = state.curr
+		} else {
+			state.prev = 0
+		}
+		state.curr = 0
+		state.window = window
+	}
+
+	// 以整數計算加權數量（單位：請求·微秒），避免浮點誤差
+	capacity := limit * c.period
+	used := state.prev*(c.period-elapsed) + state.curr*c.period
+	reset := 2*c.period - elapsed
+	if used >= capacity {
+		return Result{
+			Allowed:    false,
+			Limit:      limit,
+			Remaining:  0,
+			RetryAfter: fromMicros(slidingWindowRetry(state.prev, state.curr, limit, elapsed, c.period)),
+			ResetAfter: fromMicros(reset),
+		}, nil
+	}
+
+	state.curr++
+	return Result{
+		Allowed:    true,
+		Limit:      limit,
+		Remaining:  (capacity - used - 1) / c.period,
+		ResetAfter: fromMicros(reset),
+	}, nil
+}
+
+// sweep 清除前兩個視窗之前的狀態
+func (c *SlidingWindowCounter) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < sweepInterval {
+		return
+	}
+	c.lastSweep = now
+	window := now.UnixMicro() / c.period
+	for key, state := range c.states {
+		if state.window < window-1 {
+			delete(c.states, key)
+		}
+	}
+}
+
+// slidingWindowRetry 計算加權數量降到 limit 以下所需的微秒數
+func slidingWindowRetry(prev, curr, limit, elapsed, period int64) int64 {
+	var retry int64
+	if curr < limit {
+		// 目前視窗內，前一視窗的權重下降即可：prev*(period-t) < (limit-curr)*period
+		room := (limit - curr) * period
+		retry = period - (room-1)/prev - elapsed
+	} else {
+		// 需等到下一個視窗，目前視窗成為前一視窗：curr*(period-t) < limit*period
+		retry = (period - elapsed) + period - (limit*period-1)/curr
+	}
+	if retry < 1 {
+		retry = 1
+	}
+	return retry
+}
+
+// slidingWindowCounterScript 與 SlidingWindowCounter.Allow 相同的邏輯
+// KEYS[1] 目前視窗、KEYS[2] 前一視窗；ARGV: limit, period, elapsed, ttl_ms
+var slidingWindowCounterScript = redis.NewScript(`
+local function idiv(a, b)
+    return (a - a % b) / b
+end
+
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+local curr = tonumber(redis.call('GET', KEYS[1]) or '0')
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+
+local capacity = limit * period
+local used = prev * (period - elapsed) + curr * period
+local reset = 2 * period - elapsed
+if used >= capacity then
+    local retry
+    if curr < limit then
+        retry = period - idiv((limit - curr) * period - 1, prev) - elapsed
+    else
+        retry = (period - elapsed) + period - idiv(capacity - 1, curr)
+    end
+    if retry < 1 then
+        retry = 1
+    end
+    return {0, 0, retry, reset}
+end
+
+if redis.call('INCR', KEYS[1]) == 1 then
+    redis.call('PEXPIRE', KEYS[1], ARGV[4])
+end
+return {1, idiv(capacity - used - 1, period), 0, reset}
+`)
+
+// RedisSlidingWindowCounter implements a distributed sliding window counter
+// 使用 Redis 計數器的分散式滑動視窗計數器，每個視窗一個 key
+type RedisSlidingWindowCounter struct {
+	redis  *redis.Client
+	prefix string
+	limit  int64
+	period int64 // 微秒
+	now    func() time.Time
+}
+
+// NewRedisSlidingWindowCounter creates a Redis-based sliding window counter limiter
+func NewRedisSlidingWindowCounter(client *redis.Client, prefix string, limit int64, period time.Duration) *RedisSlidingWindowCounter {
+	return &RedisSlidingWindowCounter{
+		redis:  client,
+		prefix: prefix,
+		limit:  limit,
+		period: micros(period),
+		now:    time.Now,
+	}
+}
+
+// Allow checks if a request for key is allowed (distributed)
+func (c *RedisSlidingWindowCounter) Allow(ctx context.Context, key string) (Result, error) {
+	return c.allowScaled(ctx, key, 1)
+}
+
+// allowScaled 以縮小的數量門檻檢查同一組計數器 (分散式)
+func (c *RedisSlidingWindowCounter) allowScaled(ctx context.Context, key string, factor float64) (Result, error) {
+	limit := scaleLimit(c.limit, factor)
+	nowUs := c.now().UnixMicro()
+	window := nowUs / c.period
+	elapsed := nowUs - window*c.period
+	keys := []string{
+		fmt.Sprintf("%s%s:%d", c.prefix, key, window),
+		fmt.Sprintf("%s%s:%d", c.prefix, key, window-1),
+	}
+
+	values, err := slidingWindowCounterScript.Run(ctx, c.redis, keys,
+		limit, c.period, elapsed, ttlMillis(2*c.period)).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("sliding window counter: %w", err)
+	}
+	return scriptResult(values, limit)
+}
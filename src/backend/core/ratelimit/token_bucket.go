@@ -3,7 +3,6 @@ package ratelimit
 import (
 	"context"
 	"fmt"
-	"math"
 	"sync"
 	"time"
 
@@ -13,84 +12,104 @@ import (
 
 // TokenBucket implements the token bucket rate limiting algorithm
 // Token Bucket 率限制算法實現
+// 令牌以整數單位計算：1 個令牌 = period 的微秒數，每微秒補充 limit 個單位，
+// 因此每 period 補充 limit 個令牌（例如每小時 100 個）不會有捨入誤差
 type TokenBucket struct {
-	capacity     int64         // 桶容量
-	tokens       int64         // 當前令牌數
-	refillRate   int64         // 每秒補充令牌數
-	lastRefill   time.Time     // 上次補充時間
+	capacity     int64 // 桶容量（令牌）
+	limit        int64 // 每 period 補充令牌數
+	periodMicros int64 // 補充週期（微秒），即每個令牌的單位數
+	tokens       int64 // 當前令牌單位數
+	lastRefill   int64 // 上次補充時間（Unix 微秒）
 	mu           sync.RWMutex
 	logger       *logrus.Logger
+	now          func() time.Time
 }
 
-// NewTokenBucket creates a new token bucket
+// NewTokenBucket creates a new token bucket refilling refillRate tokens per second
 func NewTokenBucket(capacity, refillRate int64, logger *logrus.Logger) *TokenBucket {
+	return NewTokenBucketPerPeriod(capacity, refillRate, time.Second, logger)
+}
+
+// NewTokenBucketPerPeriod creates a token bucket refilling limit tokens every period
+func NewTokenBucketPerPeriod(capacity, limit int64, period time.Duration, logger *logrus.Logger) *TokenBucket {
 	if logger == nil {
 		logger = logrus.New()
 	}
 
+	periodMicros := period.Microseconds()
+	if periodMicros < 1 {
+		periodMicros = 1
+	}
 	return &TokenBucket{
-		capacity:   capacity,
-		tokens:     capacity,
-		refillRate: refillRate,
-		lastRefill: time.Now(),
-		logger:     logger,
+		capacity:     capacity,
+		limit:        limit,
+		periodMicros: periodMicros,
+		tokens:       capacity * periodMicros,
+		lastRefill:   time.Now().UnixMicro(),
+		logger:       logger,
+		now:          time.Now,
 	}
 }
 
 // Allow checks if a request is allowed
 func (tb *TokenBucket) Allow() bool {
-	tb.mu.Lock()
-	defer tb.mu.Unlock()
-
-	// 補充令牌
-	tb.refill()
-
-	// 檢查是否有可用令牌
-	if tb.tokens > 0 {
-		tb.tokens--
-		return true
-	}
-
-	return false
+	return tb.AllowN(1)
 }
 
 // AllowN checks if N requests are allowed
 func (tb *TokenBucket) AllowN(n int64) bool {
+	allowed, _ := tb.take(n)
+	return allowed
+}
+
+// take 補充後嘗試取出 n 個令牌，返回是否允許與剩餘的令牌單位數
+func (tb *TokenBucket) take(n int64) (bool, int64) {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
 	tb.refill()
 
-	if tb.tokens >= n {
-		tb.tokens -= n
-		return true
+	cost := n * tb.periodMicros
+	if tb.tokens >= cost {
+		tb.tokens -= cost
+		return true, tb.tokens
 	}
 
-	return false
+	return false, tb.tokens
 }
 
 // refill refills tokens based on elapsed time
 func (tb *TokenBucket) refill() {
-	now := time.Now()
-	elapsed := now.Sub(tb.lastRefill)
-
-	// 計算應該補充的令牌數
-	tokensToAdd := int64(elapsed.Seconds()) * tb.refillRate
+	now := tb.now().UnixMicro()
+	elapsed := now - tb.lastRefill
+	if elapsed <= 0 {
+		return
+	}
 
-	if tokensToAdd > 0 {
-		tb.tokens += tokensToAdd
-		if tb.tokens > tb.capacity {
-			tb.tokens = tb.capacity
-		}
-		tb.lastRefill = now
+	// 計算應該補充的令牌單位，超過容量時直接補滿以避免溢位
+	capacity := tb.capacity * tb.periodMicros
+	if tb.limit > 0 && elapsed > capacity/tb.limit {
+		tb.tokens = capacity
+	} else {
+		tb.tokens = min(tb.tokens+elapsed*tb.limit, capacity)
 	}
+	tb.lastRefill = now
 }
 
 // GetTokens returns the current number of tokens
 func (tb *TokenBucket) GetTokens() int64 {
 	tb.mu.RLock()
 	defer tb.mu.RUnlock()
-	return tb.tokens
+	return tb.tokens / tb.periodMicros
+}
+
+// full 補充後檢查桶是否已滿
+func (tb *TokenBucket) full() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	return tb.tokens >= tb.capacity*tb.periodMicros
 }
 
 // Reset resets the token bucket
@@ -98,97 +117,121 @@ func (tb *TokenBucket) Reset() {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
-	tb.tokens = tb.capacity
-	tb.lastRefill = time.Now()
+	tb.tokens = tb.capacity * tb.periodMicros
+	tb.lastRefill = tb.now().UnixMicro()
 }
 
 // RedisTokenBucket implements distributed token bucket using Redis
-// 使用 Redis 實現的分散式 Token Bucket
+// 使用 Redis 實現的分散式 Token Bucket，令牌單位與 TokenBucket 相同
 type RedisTokenBucket struct {
-	redis      *redis.Client
-	key        string
-	capacity   int64
-	refillRate int64
-	logger     *logrus.Logger
+	redis        *redis.Client
+	key          string
+	capacity     int64
+	limit        int64
+	periodMicros int64
+	logger       *logrus.Logger
+	now          func() time.Time
 }
 
-// NewRedisTokenBucket creates a new Redis-based token bucket
+// NewRedisTokenBucket creates a new Redis-based token bucket refilling refillRate tokens per second
 func NewRedisTokenBucket(redis *redis.Client, key string, capacity, refillRate int64, logger *logrus.Logger) *RedisTokenBucket {
+	return NewRedisTokenBucketPerPeriod(redis, key, capacity, refillRate, time.Second, logger)
+}
+
+// NewRedisTokenBucketPerPeriod creates a Redis-based token bucket refilling limit tokens every period
+func NewRedisTokenBucketPerPeriod(redis *redis.Client, key string, capacity, limit int64, period time.Duration, logger *logrus.Logger) *RedisTokenBucket {
 	if logger == nil {
 		logger = logrus.New()
 	}
 
+	periodMicros := period.Microseconds()
+	if periodMicros < 1 {
+		periodMicros = 1
+	}
 	return &RedisTokenBucket{
-		redis:      redis,
-		key:        key,
-		capacity:   capacity,
-		refillRate: refillRate,
-		logger:     logger,
+		redis:        redis,
+		key:          key,
+		capacity:     capacity,
+		limit:        limit,
+		periodMicros: periodMicros,
+		logger:       logger,
+		now:          time.Now,
 	}
 }
 
 // Allow checks if a request is allowed (distributed)
 func (rtb *RedisTokenBucket) Allow(ctx context.Context) (bool, error) {
-	// 使用 Lua 腳本實現原子操作
-	script := `
+	return rtb.AllowN(ctx, 1)
+}
+
+// AllowN checks if n tokens can be taken at once (distributed)
+func (rtb *RedisTokenBucket) AllowN(ctx context.Context, n int64) (bool, error) {
+	allowed, _, err := rtb.take(ctx, n)
+	return allowed, err
+}
+
+// redisTokenBucketScript 原子地補充並取出令牌；令牌與時間皆為整數（單位、微秒）
+const redisTokenBucketScript = `
 local key = KEYS[1]
 local capacity = tonumber(ARGV[1])
 local refill_rate = tonumber(ARGV[2])
 local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
 
 -- 獲取當前狀態
 local bucket = redis.call('HMGET', key, 'tokens', 'last_refill')
 local tokens = tonumber(bucket[1]) or capacity
 local last_refill = tonumber(bucket[2]) or now
 
--- 計算補充的令牌
-local elapsed = now - last_refill
-local tokens_to_add = math.floor(elapsed * refill_rate)
-
-if tokens_to_add > 0 then
-    tokens = math.min(tokens + tokens_to_add, capacity)
+-- 計算補充的令牌單位
+if now > last_refill then
+    tokens = math.min(tokens + (now - last_refill) * refill_rate, capacity)
     last_refill = now
 end
 
 -- 檢查是否有可用令牌
-if tokens > 0 then
-    tokens = tokens - 1
-    redis.call('HMSET', key, 'tokens', tokens, 'last_refill', last_refill)
-    redis.call('EXPIRE', key, 3600)  -- 1 小時過期
-    return 1
-else
-    return 0
+local allowed = 0
+if tokens >= cost then
+    tokens = tokens - cost
+    allowed = 1
 end
+
+-- 補滿之後狀態等同不存在，過期時間為補滿所需的時間
+redis.call('HMSET', key, 'tokens', tokens, 'last_refill', last_refill)
+redis.call('PEXPIRE', key, math.ceil((capacity - tokens) / refill_rate / 1000) + 1000)
+return {allowed, tokens}
 `
 
-	result, err := rtb.redis.Eval(ctx, script, []string{rtb.key},
-		rtb.capacity, rtb.refillRate, time.Now().Unix()).Int()
+// take 補充後嘗試取出 n 個令牌，返回是否允許與剩餘的令牌單位數
+func (rtb *RedisTokenBucket) take(ctx context.Context, n int64) (bool, int64, error) {
+	result, err := rtb.redis.Eval(ctx, redisTokenBucketScript, []string{rtb.key},
+		rtb.capacity*rtb.periodMicros, rtb.limit, rtb.now().UnixMicro(), n*rtb.periodMicros).Int64Slice()
 
 	if err != nil {
 		rtb.logger.Errorf("Redis token bucket error: %v", err)
-		return false, err
+		return false, 0, err
 	}
 
-	return result == 1, nil
+	return result[0] == 1, result[1], nil
 }
 
 // GetTokens returns the current number of tokens (distributed)
 func (rtb *RedisTokenBucket) GetTokens(ctx context.Context) (int64, error) {
-	result, err := rtb.redis.HGet(ctx, rtb.key, "tokens").Int64()
+	result, err := rtb.redis.HGet(ctx, rtb.key, "tokens").Float64()
 	if err == redis.Nil {
 		return rtb.capacity, nil
 	}
 	if err != nil {
 		return 0, err
 	}
-	return result, nil
+	return int64(result) / rtb.periodMicros, nil
 }
 
 // Reset resets the token bucket (distributed)
 func (rtb *RedisTokenBucket) Reset(ctx context.Context) error {
 	return rtb.redis.HMSet(ctx, rtb.key,
-		"tokens", rtb.capacity,
-		"last_refill", time.Now().Unix(),
+		"tokens", rtb.capacity*rtb.periodMicros,
+		"last_refill", rtb.now().UnixMicro(),
 	).Err()
 }
 
@@ -198,6 +241,10 @@ type MultiLevelRateLimiter struct {
 	ipLimiter       map[string]*TokenBucket
 	endpointLimiter map[string]*TokenBucket
 	userLimiter     map[string]*TokenBucket
+	ipLevel         *AdaptiveLimiter
+	endpointLevel   *AdaptiveLimiter
+	userLevel       *AdaptiveLimiter
+	risk            *RiskTracker
 	redis           *redis.Client
 	logger          *logrus.Logger
 	mu              sync.RWMutex
@@ -214,6 +261,11 @@ type RateLimitConfig struct {
 	// 用戶層級限制
 	UserRequestsPerHour int64
 
+	// 各層級演算法（預設令牌桶）
+	IPAlgorithm       Algorithm
+	EndpointAlgorithm Algorithm
+	UserAlgorithm     Algorithm
+
 	// Redis 配置（用於分散式限制）
 	RedisAddr     string
 	RedisPassword string
@@ -221,6 +273,7 @@ type RateLimitConfig struct {
 }
 
 // NewMultiLevelRateLimiter creates a new multi-level rate limiter
+// 層級使用令牌桶以外的演算法時，限制取自 RateLimitConfig（未設定則為 60/分、10/分、1000/時）
 func NewMultiLevelRateLimiter(config *RateLimitConfig, logger *logrus.Logger) *MultiLevelRateLimiter {
 	if logger == nil {
 		logger = logrus.New()
@@ -235,46 +288,101 @@ func NewMultiLevelRateLimiter(config *RateLimitConfig, logger *logrus.Logger) *M
 		})
 	}
 
-	return &MultiLevelRateLimiter{
+	mrl := &MultiLevelRateLimiter{
 		ipLimiter:       make(map[string]*TokenBucket),
 		endpointLimiter: make(map[string]*TokenBucket),
 		userLimiter:     make(map[string]*TokenBucket),
 		redis:           redisClient,
 		logger:          logger,
 	}
+	mrl.ipLevel = mrl.levelLimiter(config.IPAlgorithm, config.IPRequestsPerMinute, 60, time.Minute, "ratelimit:ip:")
+	mrl.endpointLevel = mrl.levelLimiter(config.EndpointAlgorithm, config.EndpointRequestsPerMinute, 10, time.Minute, "ratelimit:endpoint:")
+	mrl.userLevel = mrl.levelLimiter(config.UserAlgorithm, config.UserRequestsPerHour, 1000, time.Hour, "ratelimit:user:")
+	return mrl
+}
+
+// SetRiskTracker enables risk-based limits: IP and user levels tighten for risky sources.
+// Call before the limiter starts serving requests.
+func (mrl *MultiLevelRateLimiter) SetRiskTracker(risk *RiskTracker) {
+	mrl.risk = risk
+	for _, level := range []*AdaptiveLimiter{mrl.ipLevel, mrl.endpointLevel, mrl.userLevel} {
+		level.risk = risk
+	}
+}
+
+// levelLimiter 建立層級的限制器；無效的演算法改用令牌桶
+func (mrl *MultiLevelRateLimiter) levelLimiter(algorithm Algorithm, limit, defaultLimit int64, period time.Duration, prefix string) *AdaptiveLimiter {
+	if algorithm == "" {
+		algorithm = AlgorithmTokenBucket
+	}
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	config := LimitConfig{Algorithm: algorithm, Limit: limit, Period: period, KeyPrefix: prefix}
+	limiter, err := NewAdaptiveLimiter(config, mrl.redis, nil)
+	if err != nil {
+		mrl.logger.Warnf("Invalid rate limit algorithm %q, falling back to token bucket: %v", algorithm, err)
+		config.Algorithm = AlgorithmTokenBucket
+		limiter, _ = NewAdaptiveLimiter(config, mrl.redis, nil) // 令牌桶配置必定有效
+	}
+	return limiter
+}
+
+// allowLevel 依來源風險檢查層級的限制器；令牌桶層級返回 handled=false，沿用原本的實作
+func (mrl *MultiLevelRateLimiter) allowLevel(ctx context.Context, level *AdaptiveLimiter, key, ip, userID string) (allowed, handled bool, err error) {
+	if level.config.Algorithm == AlgorithmTokenBucket {
+		return false, false, nil
+	}
+
+	result, err := level.AllowFrom(ctx, key, ip, userID)
+	if err != nil {
+		return false, true, err
+	}
+	return result.Allowed, true, nil
 }
 
 // AllowIP checks if an IP is allowed
 func (mrl *MultiLevelRateLimiter) AllowIP(ctx context.Context, ip string) (bool, error) {
+	if allowed, handled, err := mrl.allowLevel(ctx, mrl.ipLevel, ip, ip, ""); handled {
+		return allowed, err
+	}
+
 	mrl.mu.RLock()
 	limiter, exists := mrl.ipLimiter[ip]
 	mrl.mu.RUnlock()
 
 	if !exists {
 		mrl.mu.Lock()
-		limiter = NewTokenBucket(60, 1, mrl.logger) // 60 requests per minute
+		limiter = NewTokenBucketPerPeriod(60, 60, time.Minute, mrl.logger) // 60 requests per minute
 		mrl.ipLimiter[ip] = limiter
 		mrl.mu.Unlock()
 	}
 
+	// 高風險來源在同一個桶上每次消耗較多令牌
+	cost := scaledCost(60, mrl.risk.Tier(ip, "").Factor)
+
 	// 如果有 Redis，使用分散式限制
 	if mrl.redis != nil {
-		redisLimiter := NewRedisTokenBucket(mrl.redis, fmt.Sprintf("ratelimit:ip:%s", ip), 60, 1, mrl.logger)
-		return redisLimiter.Allow(ctx)
+		redisLimiter := NewRedisTokenBucketPerPeriod(mrl.redis, fmt.Sprintf("ratelimit:ip:%s", ip), 60, 60, time.Minute, mrl.logger)
+		return redisLimiter.AllowN(ctx, cost)
 	}
 
-	return limiter.Allow(), nil
+	return limiter.AllowN(cost), nil
 }
 
 // AllowEndpoint checks if an endpoint is allowed
 func (mrl *MultiLevelRateLimiter) AllowEndpoint(ctx context.Context, endpoint string) (bool, error) {
+	if allowed, handled, err := mrl.allowLevel(ctx, mrl.endpointLevel, endpoint, "", ""); handled {
+		return allowed, err
+	}
+
 	mrl.mu.RLock()
 	limiter, exists := mrl.endpointLimiter[endpoint]
 	mrl.mu.RUnlock()
 
 	if !exists {
 		mrl.mu.Lock()
-		limiter = NewTokenBucket(10, int64(math.Round(1000.0/6.0)), mrl.logger) // 10 requests per minute for sensitive endpoints
+		limiter = NewTokenBucketPerPeriod(10, 10, time.Minute, mrl.logger) // 10 requests per minute for sensitive endpoints
 		mrl.endpointLimiter[endpoint] = limiter
 		mrl.mu.Unlock()
 	}
@@ -284,18 +392,22 @@ func (mrl *MultiLevelRateLimiter) AllowEndpoint(ctx context.Context, endpoint st
 
 // AllowUser checks if a user is allowed
 func (mrl *MultiLevelRateLimiter) AllowUser(ctx context.Context, userID string) (bool, error) {
+	if allowed, handled, err := mrl.allowLevel(ctx, mrl.userLevel, userID, "", userID); handled {
+		return allowed, err
+	}
+
 	mrl.mu.RLock()
 	limiter, exists := mrl.userLimiter[userID]
 	mrl.mu.RUnlock()
 
 	if !exists {
 		mrl.mu.Lock()
-		limiter = NewTokenBucket(1000, int64(math.Round(1000000.0/3600.0)), mrl.logger) // 1000 requests per hour
+		limiter = NewTokenBucketPerPeriod(1000, 1000, time.Hour, mrl.logger) // 1000 requests per hour
 		mrl.userLimiter[userID] = limiter
 		mrl.mu.Unlock()
 	}
 
-	return limiter.Allow(), nil
+	return limiter.AllowN(scaledCost(1000, mrl.risk.Tier("", userID).Factor)), nil
 }
 
 // CheckAll checks all rate limit levels
@@ -334,3 +446,96 @@ func (mrl *MultiLevelRateLimiter) Close() error {
 	return nil
 }
 
+// TokenBucketAlgorithm adapts TokenBucket and RedisTokenBucket to the Limiter interface
+// 以 key 區分的令牌桶，讓既有實作可與其他演算法互換
+type TokenBucketAlgorithm struct {
+	capacity  int64
+	limit     int64
+	period    time.Duration
+	buckets   map[string]*TokenBucket
+	lastSweep time.Time
+	redis     *redis.Client
+	prefix    string
+	mu        sync.Mutex
+	now       func() time.Time
+}
+
+// NewTokenBucketAlgorithm creates a keyed token bucket limiter refilling limit tokens every period;
+// client may be nil for in-memory buckets
+func NewTokenBucketAlgorithm(capacity, limit int64, period time.Duration, client *redis.Client, prefix string) *TokenBucketAlgorithm {
+	return &TokenBucketAlgorithm{
+		capacity: capacity,
+		limit:    limit,
+		period:   period,
+		buckets:  make(map[string]*TokenBucket),
+		redis:    client,
+		prefix:   prefix,
+		now:      time.Now,
+	}
+}
+
+// Allow checks if a request for key is allowed
+func (tba *TokenBucketAlgorithm) Allow(ctx context.Context, key string) (Result, error) {
+	return tba.allowScaled(ctx, key, 1)
+}
+
+// allowScaled 在同一個桶上以較高的單次消耗實現縮小的限制
+func (tba *TokenBucketAlgorithm) allowScaled(ctx context.Context, key string, factor float64) (Result, error) {
+	cost := scaledCost(tba.capacity, factor)
+	var allowed bool
+	var tokens, periodMicros int64
+	if tba.redis != nil {
+		bucket := NewRedisTokenBucketPerPeriod(tba.redis, tba.prefix+key, tba.capacity, tba.limit, tba.period, nil)
+		bucket.now = tba.now
+		var err error
+		if allowed, tokens, err = bucket.take(ctx, cost); err != nil {
+			return Result{}, err
+		}
+		periodMicros = bucket.periodMicros
+	} else {
+		tba.mu.Lock()
+		now := tba.now()
+		tba.sweep(now)
+		bucket, exists := tba.buckets[key]
+		if !exists {
+			bucket = NewTokenBucketPerPeriod(tba.capacity, tba.limit, tba.period, nil)
+			bucket.now = tba.now
+			bucket.lastRefill = now.UnixMicro()
+			tba.buckets[key] = bucket
+		}
+		allowed, tokens = bucket.take(cost)
+		periodMicros = bucket.periodMicros
+		tba.mu.Unlock()
+	}
+
+	// 每微秒補充 limit 個令牌單位，等待時間向上取整到微秒
+	refillAfter := func(units int64) time.Duration {
+		if units <= 0 {
+			return 0
+		}
+		return time.Duration((units+tba.limit-1)/tba.limit) * time.Microsecond
+	}
+	result := Result{
+		Allowed:    allowed,
+		Limit:      tba.capacity / cost,
+		Remaining:  tokens / periodMicros / cost,
+		ResetAfter: refillAfter(tba.capacity*periodMicros - tokens),
+	}
+	if !allowed {
+		result.RetryAfter = refillAfter(cost*periodMicros - tokens)
+	}
+	return result, nil
+}
+
+// sweep 清除已補滿的桶；補滿的桶與新建的桶狀態相同
+func (tba *TokenBucketAlgorithm) sweep(now time.Time) {
+	if now.Sub(tba.lastSweep) < sweepInterval {
+		return
+	}
+	tba.lastSweep = now
+	for key, bucket := range tba.buckets {
+		if bucket.full() {
+			delete(tba.buckets, key)
+		}
+	}
+}
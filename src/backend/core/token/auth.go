@@ -20,14 +20,18 @@ type Auth struct {
 	isEnabled   bool
 	tokenPath   string
 	validTokens map[string]time.Time
-	mu          sync.RWMutex
+	// deviceTokens 以 Token 雜湊為鍵的設備 Token
+	deviceTokens map[string]DeviceToken
+	deviceStore  DeviceTokenStore
+	mu           sync.RWMutex
 }
 
 // NewAuth 建立新的USB Token認證系統
 func NewAuth(logger *logrus.Logger) *Auth {
 	return &Auth{
-		logger:      logger,
-		validTokens: make(map[string]time.Time),
+		logger:       logger,
+		validTokens:  make(map[string]time.Time),
+		deviceTokens: make(map[string]DeviceToken),
 	}
 }
 
@@ -305,4 +309,17 @@ func (a *Auth) CleanExpiredTokens() {
 			a.logger.Debugf("已清理過期Token: %s", tokenHash[:8]+"...")
 		}
 	}
+	expired := 0
+	for tokenHash, device := range a.deviceTokens {
+		if now.After(device.ExpiresAt) {
+			delete(a.deviceTokens, tokenHash)
+			expired++
+			a.logger.Debugf("已清理設備 %s 的過期Token", device.DeviceID)
+		}
+	}
+	if expired > 0 {
+		if err := a.saveDeviceTokensLocked(); err != nil {
+			a.logger.Warnf("保存設備 Token 失敗: %v", err)
+		}
+	}
 }
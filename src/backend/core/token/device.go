@@ -0,0 +1,183 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultDeviceTokenTTL 設備 Token 預設有效期限
+const DefaultDeviceTokenTTL = 30 * 24 * time.Hour
+
+// DeviceToken 綁定到單一設備的 Token（例如 ESP32、Arduino 連接 MQTT 時的密碼）
+type DeviceToken struct {
+	DeviceID  string    `json:"device_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DeviceTokenStore 保存設備 Token（以 Token 雜湊為鍵，不含明文），讓重新啟動後 Token 仍有效
+type DeviceTokenStore interface {
+	LoadDeviceTokens() (map[string]DeviceToken, error)
+	SaveDeviceTokens(tokens map[string]DeviceToken) error
+}
+
+// FileDeviceTokenStore 以 JSON 檔案保存設備 Token
+type FileDeviceTokenStore struct {
+	path string
+}
+
+// NewFileDeviceTokenStore 創建檔案儲存
+func NewFileDeviceTokenStore(path string) *FileDeviceTokenStore {
+	return &FileDeviceTokenStore{path: path}
+}
+
+// LoadDeviceTokens 讀取設備 Token；檔案不存在時返回空集合
+func (s *FileDeviceTokenStore) LoadDeviceTokens() (map[string]DeviceToken, error) {
+	tokens := make(map[string]DeviceToken)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("讀取設備 Token 檔案失敗: %w", err)
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("解析設備 Token 檔案失敗: %w", err)
+	}
+	return tokens, nil
+}
+
+// SaveDeviceTokens 寫入暫存檔後改名，避免寫入中斷時留下不完整的檔案
+func (s *FileDeviceTokenStore) SaveDeviceTokens(tokens map[string]DeviceToken) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("建立設備 Token 目錄失敗: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("寫入設備 Token 檔案失敗: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("寫入設備 Token 檔案失敗: %w", err)
+	}
+	return nil
+}
+
+// SetDeviceTokenStore 載入已保存的設備 Token，之後簽發與撤銷都會寫入 store
+func (a *Auth) SetDeviceTokenStore(store DeviceTokenStore) error {
+	tokens, err := store.LoadDeviceTokens()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.deviceTokens == nil {
+		a.deviceTokens = make(map[string]DeviceToken)
+	}
+	now := time.Now()
+	for hash, device := range tokens {
+		if now.Before(device.ExpiresAt) {
+			a.deviceTokens[hash] = device
+		}
+	}
+	a.deviceStore = store
+	a.logger.Infof("已載入 %d 個設備 Token", len(a.deviceTokens))
+	return nil
+}
+
+// saveDeviceTokensLocked 寫入 store（呼叫端持有 a.mu）
+func (a *Auth) saveDeviceTokensLocked() error {
+	if a.deviceStore == nil {
+		return nil
+	}
+	return a.deviceStore.SaveDeviceTokens(a.deviceTokens)
+}
+
+// IssueDeviceToken 為設備簽發新的 Token；只保存 Token 雜湊，明文只在此返回一次
+func (a *Auth) IssueDeviceToken(deviceID string, ttl time.Duration) (string, error) {
+	if deviceID == "" {
+		return "", fmt.Errorf("設備 ID 不可為空")
+	}
+	if ttl <= 0 {
+		ttl = DefaultDeviceTokenTTL
+	}
+
+	// 不使用 GenerateToken，避免明文 Token 寫入日誌
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", fmt.Errorf("生成Token失敗: %v", err)
+	}
+	tokenStr := hex.EncodeToString(tokenBytes)
+
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.deviceTokens == nil {
+		a.deviceTokens = make(map[string]DeviceToken)
+	}
+	hash := a.hashToken([]byte(tokenStr))
+	a.deviceTokens[hash] = DeviceToken{
+		DeviceID:  deviceID,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := a.saveDeviceTokensLocked(); err != nil {
+		delete(a.deviceTokens, hash)
+		return "", fmt.Errorf("保存設備 Token 失敗: %w", err)
+	}
+	a.logger.Infof("已為設備 %s 簽發 Token，有效至 %s", deviceID, now.Add(ttl).Format(time.RFC3339))
+	return tokenStr, nil
+}
+
+// VerifyDeviceToken 驗證 Token 是否為該設備簽發且尚未過期
+func (a *Auth) VerifyDeviceToken(deviceID, tokenStr string) bool {
+	if deviceID == "" || tokenStr == "" {
+		return false
+	}
+
+	a.mu.RLock()
+	device, exists := a.deviceTokens[a.hashToken([]byte(tokenStr))]
+	a.mu.RUnlock()
+	if !exists || time.Now().After(device.ExpiresAt) {
+		return false
+	}
+
+	// 以固定時間比較設備 ID，避免洩漏 Token 與設備的對應
+	want := sha256.Sum256([]byte(device.DeviceID))
+	got := sha256.Sum256([]byte(deviceID))
+	return subtle.ConstantTimeCompare(want[:], got[:]) == 1
+}
+
+// RevokeDeviceTokens 撤銷設備的所有 Token，返回撤銷數量；保存失敗時記憶體中仍已撤銷
+func (a *Auth) RevokeDeviceTokens(deviceID string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	revoked := 0
+	for hash, device := range a.deviceTokens {
+		if device.DeviceID == deviceID {
+			delete(a.deviceTokens, hash)
+			revoked++
+		}
+	}
+	if revoked == 0 {
+		return 0, nil
+	}
+	a.logger.Infof("已撤銷設備 %s 的 %d 個 Token", deviceID, revoked)
+	if err := a.saveDeviceTokensLocked(); err != nil {
+		return revoked, fmt.Errorf("保存設備 Token 失敗: %w", err)
+	}
+	return revoked, nil
+}
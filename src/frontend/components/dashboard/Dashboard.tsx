@@ -74,7 +74,6 @@ interface SystemStatus {
     total: number
     online: number
     offline: number
-    lastActivity: string
   }
 }
 